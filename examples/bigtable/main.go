@@ -0,0 +1,125 @@
+// Example Cloud Bigtable client using the gRPC-GCP channel pool.
+//
+// Bigtable has no sessions, so the calls are not bound to channels. Instead,
+// the calls are sent over the channel with the lowest latency among the
+// channels with capacity and the pool grows when all channels are busy.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp"
+	btpb "google.golang.org/genproto/googleapis/bigtable/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/oauth"
+	"google.golang.org/grpc/metadata"
+
+	gpb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+const (
+	endpoint = "bigtable.googleapis.com:443"
+	scope    = "https://www.googleapis.com/auth/bigtable.data.readonly"
+)
+
+var (
+	project  = flag.String("project", "", "GCP project for Cloud Bigtable.")
+	instance = flag.String("instance", "test1", "Target instance.")
+	table    = flag.String("table", "test1", "Target table.")
+	readers  = flag.Int("readers", 20, "Number of concurrent ReadRows calls.")
+
+	apiConfig = &gpb.ApiConfig{
+		ChannelPool: &gpb.ChannelPoolConfig{
+			// Keep a few channels connected and grow up to 8 channels when
+			// every channel has 100 active streams.
+			MinSize:                          2,
+			MaxSize:                          8,
+			MaxConcurrentStreamsLowWatermark: 100,
+			PickStrategy:                     gpb.ChannelPoolConfig_PICK_LOWEST_LATENCY,
+			// Replace the connection of a channel with no responses within 1
+			// second and 3 timed out calls.
+			UnresponsiveDetectionMs: 1000,
+			UnresponsiveCalls:       3,
+		},
+	}
+)
+
+func tableName() string {
+	return fmt.Sprintf("projects/%s/instances/%s/tables/%s", *project, *instance, *table)
+}
+
+// readRows reads up to limit rows of the table and returns the number of cells.
+func readRows(ctx context.Context, client btpb.BigtableClient, limit int64) (int, error) {
+	stream, err := client.ReadRows(ctx, &btpb.ReadRowsRequest{
+		TableName: tableName(),
+		RowsLimit: limit,
+	})
+	if err != nil {
+		return 0, err
+	}
+	cells := 0
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return cells, nil
+		}
+		if err != nil {
+			return cells, err
+		}
+		cells += len(resp.GetChunks())
+	}
+}
+
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+
+	perRPC, err := oauth.NewApplicationDefault(ctx, scope)
+	if err != nil {
+		log.Fatalf("Could not get credentials: %v", err)
+	}
+	conn, err := grpcgcp.Dial(
+		endpoint,
+		apiConfig,
+		grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(nil, "")),
+		grpc.WithPerRPCCredentials(perRPC),
+	)
+	if err != nil {
+		log.Fatalf("Could not dial %s: %v", endpoint, err)
+	}
+	defer conn.Close()
+	client := btpb.NewBigtableClient(conn)
+
+	// Bigtable routes the calls by the table.
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-goog-request-params", "table_name="+url.QueryEscape(tableName()))
+
+	var wg sync.WaitGroup
+	for i := 0; i < *readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cells, err := readRows(ctx, client, 10)
+			if err != nil {
+				log.Printf("ReadRows failed: %v", err)
+				return
+			}
+			log.Printf("ReadRows returned %d cells", cells)
+		}()
+	}
+	wg.Wait()
+
+	snap, err := conn.AffinitySnapshot()
+	if err != nil {
+		log.Fatalf("Could not get affinity snapshot: %v", err)
+	}
+	for _, ch := range snap.Channels {
+		log.Printf("Channel %d: %s, connected to %s", ch.Index, ch.State, ch.RemoteAddr)
+	}
+}
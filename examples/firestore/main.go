@@ -0,0 +1,116 @@
+// Example Cloud Firestore client using the gRPC-GCP channel pool with
+// transaction affinity.
+//
+// Firestore transactions are identified by bytes rather than a string field,
+// so the calls of a transaction are sent over the same channel by making them
+// with a context from grpcgcp.WithChannelAffinity instead of an affinity
+// configuration of the methods.
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+
+	firestorepb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/oauth"
+	"google.golang.org/grpc/metadata"
+
+	gpb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+const (
+	endpoint = "firestore.googleapis.com:443"
+	scope    = "https://www.googleapis.com/auth/datastore"
+)
+
+var (
+	project  = flag.String("project", "", "GCP project for Cloud Firestore.")
+	database = flag.String("database", "(default)", "Target database.")
+	document = flag.String("document", "users/alice", "Path of the document to read, relative to the documents of the database.")
+
+	apiConfig = &gpb.ApiConfig{
+		ChannelPool: &gpb.ChannelPoolConfig{
+			MaxSize:                          4,
+			MaxConcurrentStreamsLowWatermark: 100,
+			// Fail calls without a deadline waiting for a channel for more
+			// than 10 seconds, e.g., Listen calls.
+			MaxPickWaitMs: 10000,
+		},
+	}
+)
+
+func databaseURI() string {
+	return fmt.Sprintf("projects/%s/databases/%s", *project, *database)
+}
+
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+
+	perRPC, err := oauth.NewApplicationDefault(ctx, scope)
+	if err != nil {
+		log.Fatalf("Could not get credentials: %v", err)
+	}
+	conn, err := grpcgcp.Dial(
+		endpoint,
+		apiConfig,
+		grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(nil, "")),
+		grpc.WithPerRPCCredentials(perRPC),
+	)
+	if err != nil {
+		log.Fatalf("Could not dial %s: %v", endpoint, err)
+	}
+	defer conn.Close()
+	client := firestorepb.NewFirestoreClient(conn)
+
+	// Firestore routes the calls by the database.
+	ctx = metadata.AppendToOutgoingContext(ctx, "google-cloud-resource-prefix", databaseURI())
+
+	tx, err := client.BeginTransaction(ctx, &firestorepb.BeginTransactionRequest{
+		Database: databaseURI(),
+		Options: &firestorepb.TransactionOptions{
+			Mode: &firestorepb.TransactionOptions_ReadOnly_{
+				ReadOnly: &firestorepb.TransactionOptions_ReadOnly{},
+			},
+		},
+	})
+	if err != nil {
+		log.Fatalf("Could not begin transaction: %v", err)
+	}
+
+	// Send the calls of the transaction over the same channel.
+	key := base64.StdEncoding.EncodeToString(tx.GetTransaction())
+	txCtx := grpcgcp.WithChannelAffinity(ctx, key)
+	defer grpcgcp.ReleaseChannelAffinity(conn.ClientConn(), key)
+
+	doc, err := client.GetDocument(txCtx, &firestorepb.GetDocumentRequest{
+		Name: fmt.Sprintf("%s/documents/%s", databaseURI(), *document),
+		ConsistencySelector: &firestorepb.GetDocumentRequest_Transaction{
+			Transaction: tx.GetTransaction(),
+		},
+	})
+	if err != nil {
+		log.Fatalf("Could not get document: %v", err)
+	}
+	log.Printf("Document %s has %d fields", doc.GetName(), len(doc.GetFields()))
+
+	snap, err := conn.AffinitySnapshot()
+	if err != nil {
+		log.Fatalf("Could not get affinity snapshot: %v", err)
+	}
+	// Should print "Transactions in progress: 1".
+	log.Printf("Transactions in progress: %d", len(snap.Keys))
+
+	if _, err := client.Rollback(txCtx, &firestorepb.RollbackRequest{
+		Database:    databaseURI(),
+		Transaction: tx.GetTransaction(),
+	}); err != nil {
+		log.Fatalf("Could not roll back transaction: %v", err)
+	}
+}
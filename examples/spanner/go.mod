@@ -0,0 +1,25 @@
+module github.com/GoogleCloudPlatform/grpc-gcp-go/examples/spanner
+
+go 1.20
+
+require (
+	cloud.google.com/go/spanner v1.45.0
+	github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp v1.3.0
+	google.golang.org/grpc v1.56.3
+)
+
+require (
+	cloud.google.com/go/compute v1.19.1 // indirect
+	cloud.google.com/go/compute/metadata v0.2.3 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/net v0.9.0 // indirect
+	golang.org/x/oauth2 v0.7.0 // indirect
+	golang.org/x/sys v0.7.0 // indirect
+	golang.org/x/text v0.9.0 // indirect
+	google.golang.org/appengine v1.6.7 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
+	google.golang.org/protobuf v1.30.0 // indirect
+)
+
+// The example tracks the grpcgcp package of this repository.
+replace github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp => ../../grpcgcp
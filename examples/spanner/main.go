@@ -0,0 +1,167 @@
+// Example Cloud Spanner client using the gRPC-GCP channel pool with session
+// affinity.
+//
+// Sessions are bound to the channel they were created on, every call using a
+// session is sent over its channel and the binding is removed when the session
+// is deleted.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	spannerpb "cloud.google.com/go/spanner/apiv1/spannerpb"
+	"github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/oauth"
+	"google.golang.org/grpc/metadata"
+
+	gpb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+const (
+	endpoint = "spanner.googleapis.com:443"
+	scope    = "https://www.googleapis.com/auth/spanner.data"
+)
+
+var (
+	project  = flag.String("project", "", "GCP project for Cloud Spanner.")
+	instance = flag.String("instance", "test1", "Target instance.")
+	database = flag.String("database", "test1", "Target database.")
+	sessions = flag.Int("sessions", 10, "Number of sessions to create.")
+
+	apiConfig = &gpb.ApiConfig{
+		ChannelPool: &gpb.ChannelPoolConfig{
+			MaxSize:                          4,
+			MaxConcurrentStreamsLowWatermark: 100,
+			// Spread new sessions across the channels.
+			BindPickStrategy: gpb.ChannelPoolConfig_ROUND_ROBIN,
+			// Use a ready channel for a session whose channel is not ready.
+			FallbackToReady: true,
+			// Report the sessions of every database in the pool metrics.
+			AffinityKeyPrefixDelimiter: "/sessions/",
+		},
+		Method: []*gpb.MethodConfig{
+			{
+				Name: []string{"/google.spanner.v1.Spanner/CreateSession"},
+				Affinity: &gpb.AffinityConfig{
+					Command:     gpb.AffinityConfig_BIND,
+					AffinityKey: "name",
+				},
+			},
+			{
+				// The response has a repeated session field, all the sessions
+				// are bound to the channel of the call.
+				Name: []string{"/google.spanner.v1.Spanner/BatchCreateSessions"},
+				Affinity: &gpb.AffinityConfig{
+					Command:     gpb.AffinityConfig_BIND,
+					AffinityKey: "session.name",
+				},
+			},
+			{
+				Name: []string{"/google.spanner.v1.Spanner/GetSession"},
+				Affinity: &gpb.AffinityConfig{
+					Command:     gpb.AffinityConfig_BOUND,
+					AffinityKey: "name",
+				},
+			},
+			{
+				Name: []string{"/google.spanner.v1.Spanner/DeleteSession"},
+				Affinity: &gpb.AffinityConfig{
+					Command:     gpb.AffinityConfig_UNBIND,
+					AffinityKey: "name",
+				},
+			},
+			{
+				Name: []string{
+					"/google.spanner.v1.Spanner/BeginTransaction",
+					"/google.spanner.v1.Spanner/Commit",
+					"/google.spanner.v1.Spanner/ExecuteBatchDml",
+					"/google.spanner.v1.Spanner/ExecuteSql",
+					"/google.spanner.v1.Spanner/ExecuteStreamingSql",
+					"/google.spanner.v1.Spanner/PartitionQuery",
+					"/google.spanner.v1.Spanner/PartitionRead",
+					"/google.spanner.v1.Spanner/Read",
+					"/google.spanner.v1.Spanner/Rollback",
+					"/google.spanner.v1.Spanner/StreamingRead",
+				},
+				Affinity: &gpb.AffinityConfig{
+					Command:     gpb.AffinityConfig_BOUND,
+					AffinityKey: "session",
+				},
+			},
+		},
+	}
+)
+
+func databaseURI() string {
+	return fmt.Sprintf("projects/%s/instances/%s/databases/%s", *project, *instance, *database)
+}
+
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+
+	perRPC, err := oauth.NewApplicationDefault(ctx, scope)
+	if err != nil {
+		log.Fatalf("Could not get credentials: %v", err)
+	}
+	conn, err := grpcgcp.Dial(
+		endpoint,
+		apiConfig,
+		grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(nil, "")),
+		grpc.WithPerRPCCredentials(perRPC),
+	)
+	if err != nil {
+		log.Fatalf("Could not dial %s: %v", endpoint, err)
+	}
+	defer conn.Close()
+	client := spannerpb.NewSpannerClient(conn)
+
+	// Spanner routes the calls by the database.
+	ctx = metadata.AppendToOutgoingContext(ctx, "google-cloud-resource-prefix", databaseURI())
+
+	names := []string{}
+	for i := 0; i < *sessions; i++ {
+		s, err := client.CreateSession(ctx, &spannerpb.CreateSessionRequest{Database: databaseURI()})
+		if err != nil {
+			log.Fatalf("Could not create session: %v", err)
+		}
+		names = append(names, s.GetName())
+	}
+
+	for _, name := range names {
+		rs, err := client.ExecuteSql(ctx, &spannerpb.ExecuteSqlRequest{
+			Session: name,
+			Sql:     "SELECT 1",
+		})
+		if err != nil {
+			log.Fatalf("Could not execute query: %v", err)
+		}
+		log.Printf("Session %s returned %v rows", name, len(rs.GetRows()))
+	}
+
+	snap, err := conn.AffinitySnapshot()
+	if err != nil {
+		log.Fatalf("Could not get affinity snapshot: %v", err)
+	}
+	for _, ch := range snap.Channels {
+		log.Printf("Channel %d: %d sessions", ch.Index, ch.AffinityCount)
+	}
+
+	for _, name := range names {
+		if _, err := client.DeleteSession(ctx, &spannerpb.DeleteSessionRequest{Name: name}); err != nil {
+			log.Fatalf("Could not delete session: %v", err)
+		}
+	}
+
+	m, err := conn.PoolMetrics()
+	if err != nil {
+		log.Fatalf("Could not get pool metrics: %v", err)
+	}
+	// Should print "Bound sessions: 0".
+	log.Printf("Bound sessions: %d", m.BoundKeys)
+}
@@ -125,5 +125,472 @@ with provided configuration. And specify gRPC-GCP interceptors.
 		grpc.WithUnaryInterceptor(grpcgcp.GCPUnaryClientInterceptor),
 		grpc.WithStreamInterceptor(grpcgcp.GCPStreamClientInterceptor),
 	)
+
+Alternatively, use WithDefaults to get all the DialOptions above from the
+apiConfig in one call.
+
+	opts, err := grpcgcp.WithDefaults(apiConfig)
+	if err != nil {
+		t.Fatalf("cannot create grpcgcp dial options: %v", err)
+	}
+	conn, err := grpc.Dial(target, opts...)
+
+Or use Dial, which also adds the GCP stats handler below and returns a GCPConn
+usable by generated clients with the introspection of the channel pool.
+
+	conn, err := grpcgcp.Dial(target, apiConfig, grpc.WithTransportCredentials(creds))
+
+The GCP interceptors must run after any other interceptor, so the affinity key
+is extracted from the messages actually sent and received. Chain other
+interceptors with ChainUnaryClientInterceptors and
+ChainStreamClientInterceptors, which place the GCP interceptors last and reject
+misplaced or duplicate GCP interceptors.
+
+Instead of maintaining the method configs by hand, ApiConfigForResource
+derives them from the google.api.resource and google.api.resource_reference
+annotations of the service descriptors for a resource type, e.g.,
+"spanner.googleapis.com/Session": Create* methods BIND the created resource,
+Delete* methods UNBIND it, and methods referencing it in the request are BOUND.
+
+Several client libraries sharing one ClientConn combine their ApiConfigs with
+MergeApiConfigs. A method configured differently by two ApiConfigs is an
+error, and every channel pool option is taken from the first ApiConfig setting
+it, so the ApiConfigs are listed by precedence.
+
+Optionally, provide the GCP stats handler to account active streams of the
+channels from the begin and end events of every call attempt. This keeps the
+streams count accurate for calls failed before the picker's done callback.
+
+	conn, err := grpc.Dial(target, append(opts, grpc.WithStatsHandler(grpcgcp.NewGCPStatsHandler()))...)
+
+The stats handler also learns the address every channel is connected to. When
+the resolver removes an address, the channels connected to it are drained: the
+affinity keys bound to them move to the surviving channels and their
+connections are replaced once their active streams finish. Other channels,
+and every channel without the stats handler, are updated with the new addresses
+in place.
+
+The backend latency reported by the Google Front End in the Server-Timing
+response metadata, the gfet4t7 metric, is averaged for every channel and
+reported as ServerLatencyMs in the AffinitySnapshot next to the client-observed
+LatencyMs. The trailers are read by the picker, the headers by the stats
+handler only. With the PICK_LOWEST_LATENCY pick strategy, it scores the
+channels without successful unary calls, e.g., with streaming calls only.
+
+The calls not bound by an affinity key go to the least busy channel. Equally
+busy channels are picked in turn, so the load is not skewed toward the first
+channels of the pool, unless Config.Deterministic is set.
+
+After outlier ejections or while channels are draining, the remaining
+channels have unequal capacity. The PICK_WEIGHTED_ROUND_ROBIN pick strategy
+spreads the calls not bound by an affinity key across the channels
+proportionally to their remaining stream budget scaled by their health score,
+instead of sending every call to the least busy channel until another one
+becomes less busy.
+
+Replacing connections, e.g., refreshing unresponsive channels, costs a full
+TLS handshake each. Dial with NewTLSCredentials to resume the TLS sessions of
+earlier connections from a shared session cache. Its handshakes, or those of
+any credentials wrapped with MeasureHandshakes, are reported for every channel
+as HandshakeMs and TLSResumed in the AffinitySnapshot by the stats handler.
+
+Transitions of a flapping channel out of READY are logged once per minute as a
+summary, e.g., "channel 3 flapped 27 times in 1m0s", and counted as Flaps in
+the PoolMetrics and the AffinitySnapshot.
+
+The Google Front End rotates its connections with a GOAWAY. Once a call is
+rejected by the draining connection of a channel, the replacement connection of
+the channel is created right away, without waiting for the connection to
+close, and the ChannelGoAway event is emitted. The PoolMetrics count such
+GoAways apart from the ConnectionLosses of READY connections closed without a
+GOAWAY, e.g., by network failures.
+
+Calls are counted by their status code, OK, UNAVAILABLE, DEADLINE_EXCEEDED,
+RESOURCE_EXHAUSTED or other, for every channel in the AffinitySnapshot and for
+the pool in the PoolMetrics to reveal channels with a skewed error rate. The
+outlier detection logs the counts of the channels it ejects.
+
+For deployments without Prometheus, ExportPoolMetrics periodically pushes the
+channels, the READY channels, the active streams, the bound affinity keys and
+the calls waiting for a channel as custom gauges, e.g.,
+"custom.googleapis.com/grpc_gcp/ready_channels", with the pool label and a
+generic_task resource by default. Implement the MetricWriter with the
+CreateTimeSeries call of the Cloud Monitoring client to push them to Cloud
+Monitoring, which keeps this package free of the client dependency.
+
+Environments already scraping /debug/vars can set Config.Expvar, or
+WithExpvar, to publish the PoolMetrics of every pool under the
+"grpcgcp.pools" expvar map by the dial target, without any other dependency.
+
+To debug why a call was sent over a channel, set pick_audit_size of the
+channel pool config. GetPickDecisions then returns the last pick decisions with
+the method, the affinity key hash, the channel, the reason, e.g., "bound" or
+"least-busy", and the time the call waited for a channel.
+PickDecisionFromContext returns the pick decision of a call from its Context
+without the audit, e.g., in the HandleRPC of a stats.Handler from the
+OutHeader event on, or in an interceptor chained after the GCP interceptors, to
+log or report the channel of every call along with its own metrics.
+
+GetChannelStats returns the live counters of the channels driving the
+least-busy picks, the active streams and the bound affinity keys, e.g., for
+load reports. They are read atomically, and every call is counted exactly once
+from its pick until it ends.
+
+WatchChannelStates streams the connectivity state transitions of the channels
+with the number of READY channels, e.g., for a session pool to pause creating
+sessions while the channel pool is degraded.
+
+By default, the ClientConn is READY as soon as any channel is READY. To gate
+readiness probes on the capacity of the pool, set MinReadyChannels of the
+Config to keep the ClientConn CONNECTING until that many channels are READY,
+and DegradedReadyRatio to get the PoolDegraded and PoolRecovered events when
+the share of READY channels drops below the ratio and recovers. To wait for a
+warmed pool on startup without changing the state of the ClientConn, call
+WaitForPoolReady with the number of READY channels to wait for, at most
+min_size. It works before the first call on the ClientConn.
+
+While the name resolver fails, the pool keeps serving on its channels and
+their connections, requests re-resolution with an exponential backoff and is
+reported as Degraded, with the ResolverError, in the PoolMetrics and the
+AffinitySnapshot. PoolDegraded and PoolRecovered are emitted as well. If no
+address was ever resolved, the calls fail with the resolver error instead of
+waiting for a channel, except the wait-for-ready calls.
+
+To size the pool, PoolMetrics reports its SaturationPercent, the active streams
+in percent of the streams it serves below the low watermark at max_size, and
+the PeakStreams of its busiest channel over the last SaturationWindow of the
+Config, see ChannelStats.PeakStreams for every channel. WithSaturationWarning
+logs a warning and calls back with a suggested max_size once the saturation
+stays above a threshold for a whole window:
+
+	cfg, err := grpcgcp.NewConfig(grpcgcp.WithSaturationWarning(80, time.Minute, func(w grpcgcp.SaturationWarning) {
+		log.Printf("grpc pool saturated at %.0f%%, raise max_size to %d", w.Percent, w.SuggestedMaxSize)
+	}))
+
+UpdateConfig replaces the pool limits and the method configs of a live pool,
+e.g., to retune a long-lived server, without reconnecting. The pool grows to a
+raised min_size right away, and the channels above a lowered max_size move
+their affinity keys to the remaining channels and are removed once their
+active streams finish.
+
+Long-lived proxies restarting in place can hand the affinity bindings over to
+the next process. CloseWithHandover closes the ClientConn and returns the
+channel index of every bound key by key hash as a JSON-serializable
+HandoverSnapshot. Pass it as the Handover of the Config of the new balancer,
+with the same min_size, to bind the keys of the first calls to the same
+channel indexes.
+
+Targets other than TCP endpoints, e.g., "unix:///run/proxy.sock" of a local
+sidecar or DirectPath backends over ALTS, are supported as well. The addresses
+of the channels are compared in their canonical form, so IPv6 addresses of
+DirectPath backends and unix socket paths match the resolved addresses. Set
+disable_health_check of the channel pool config for backends not serving the
+grpc.health.v1 service. The network and the security protocol of every channel
+are reported in the AffinitySnapshot.
+
+To make the channels deliberately connect to different frontends, set
+channel_targets of the channel pool config, e.g., to
+"spanner-{index}.example.com" for DNS-based load distribution or to a list of
+alternate VIP hostnames. Every channel dials its own target, with {index}
+replaced by the index of the channel, instead of the resolved addresses, while
+the calls keep the authority of the ClientConn.
+
+Set connect_timeout_ms of the channel pool config to abandon the new
+connections of the channels not READY in time, e.g., because their SYN is
+black-holed. They are replaced with new connections, which may connect to
+another resolved address, and counted as ConnectTimeouts in the PoolMetrics.
+
+Set keepalive of the channel pool config to apply the keepalive parameters of
+the channels with WithDefaults. PoolMetrics count the calls failed by keepalive
+timeouts and by servers closing connections for too many pings separately.
+
+Calls without a deadline wait for a channel as long as it takes, e.g., while
+the pool grows. Set max_pick_wait_ms of the channel pool config to fail them
+with a PoolExhaustedError, which includes the pool metrics, after the wait.
+
+When every channel is busy, a pick adds one channel to the pool and the
+concurrent picks wait for that channel instead of adding one each. The pool
+grows again only once the channel is READY, even if it fails to connect
+meanwhile, and the waiting picks are picked again then. PoolMetrics count the
+picks that waited for a channel added by another pick as CoalescedGrowths.
+
+Failures of the balancer can be told apart with errors.Is: ErrPoolExhausted,
+ErrKeyNotBound, ErrChannelDraining and ErrChannelNotFound. Use errors.As with
+a *PoolError to get the channel, the affinity key hash and the pool metrics of
+the failure.
+
+Hooks:
+
+To be notified about affinity bindings and replaced channels, e.g., to recreate
+Cloud Spanner sessions, register the balancer with a Config during initialization.
+
+	func init() {
+		grpcgcp.Register(grpcgcp.Config{
+			OnChannelReplaced: func(channelID int, keys []string) {
+				// Recreate sessions bound to the channel.
+			},
+		})
+	}
+
+To use differently configured balancers in the same process, e.g., for
+clients of different Google Cloud APIs, register them under other names with
+RegisterWithName and dial with WithDefaultsForBalancer.
+
+When a process dials many ClientConns, each with its own pool, cap their total
+channels with a ChannelBudget shared by the Configs of the builders:
+
+	budget := grpcgcp.NewChannelBudget(64)
+	cfg, err := grpcgcp.NewConfig(grpcgcp.WithChannelBudget(budget))
+
+Every pool gets its first channel. Once the budget is exhausted, the pools
+above their fair share drain their extra channels for the pools waiting for
+channels, see PoolMetrics.BudgetDenials.
+
+The Config also accepts a Logger to capture the balancer logs in the logging
+pipeline of the application instead of grpclog, and an OnEvent callback to
+observe lifecycle events of the balancer, e.g., ChannelReady or KeyBound.
+An Observer in the Config receives every balancer built by the builder as a
+BalancerInfo, e.g., to inspect the channel pool of a ClientConn in tests.
+BeforeNewSubConn may change the addresses and the options of every connection
+the balancer creates, e.g., to disable the health check for an endpoint, or
+veto the connection.
+
+The channels are identified by their label, e.g., "ch-0", in the logs,
+ChannelStats, AffinitySnapshot and the pick decisions. Set ChannelLabel in the
+Config to label them otherwise, e.g., by the client library of the pool. A
+channel keeps its label when its connection is replaced.
+
+To tune a deployment without changing its code, set GRPC_GCP_MAX_CHANNELS,
+GRPC_GCP_MAX_STREAMS or GRPC_GCP_LOG_LEVEL, e.g., to FINE. They are read when
+a builder is constructed, i.e., on init for the default balancer, and take
+precedence over the Config and the ApiConfig.
+
+To validate the channel pool before sending traffic through it, set
+ShadowPolicy of the Config, e.g., to "round_robin". The calls are sent over the
+connections of that balancer, while the pool picks its channel for every call
+and accounts the call as if it was sent over the channel. PoolMetrics.Shadow
+counts the picks of the pool by channel and the calls the pool would have
+failed or queued. The pool connects its own channels, doubling the connections.
+
+Custom codecs:
+
+Affinity keys are extracted from proto messages with protoreflect, so dynamicpb
+messages work as well. Messages passed through a custom codec as []byte in the
+protobuf wire format are decoded partially, only the fields on the affinity key
+path, using the method descriptors from protoregistry.GlobalFiles or the
+Descriptors of the Config.
+
+The affinity key path may go through the members of a oneof, e.g.,
+"session_template.name", an unset member yielding an empty key, and through the
+entries of a map with string keys selected with name[key], e.g.,
+"session_template.labels[key]" or `labels["a.b"]` for keys with dots. A path
+stopping at a map or a oneof, or selecting an entry of a field that is not a
+map, fails with an error naming the path element.
+
+When the routing resource differs between request variants, list further
+paths in fallback_affinity_keys by priority, e.g., "session" after the
+affinity_key "transaction.id". The first path holding a non-empty key is used,
+and paths not found in a message are skipped.
+
+APIs without an explicit session, e.g., Firestore, where the resource path of
+the requests identifies the state to keep on a channel, can use the
+BIND_ON_FIRST_USE command. The first call with an affinity key binds the key to
+the channel picked for it before the call starts, without a BIND call or a
+response field, and the following calls with the key use that channel like
+BOUND calls. Set ttl_ms to unbind the keys not used for a while.
+
+Google APIs route the calls by the x-goog-request-params header. The
+affinity_key_params of an affinity config compose the affinity key of the
+parameters of the header, e.g., the table_name and app_profile_id of
+Bigtable, read even for the calls made without the GCP interceptors.
+BigtableApiConfig returns the method configs binding the Bigtable data API
+calls of a table and app profile to a channel on first use.
+
+Retries and hedging:
+
+gRPC retries and hedged attempts of a call are picked independently and may
+land on the same channel. Set spread_call_attempts of the channel pool config
+to send every attempt of a call without an affinity key over a channel not
+used by the previous attempts of the call. This requires the GCP interceptors.
+
+The BIND calls creating the keys the following calls are routed by, e.g.,
+CreateSession, should not hang a whole workflow. Set call_policy of their
+method config to time out every attempt of the unary calls after timeout_ms and
+retry them with backoff, up to max_attempts attempts, on the retryable codes.
+The GCP unary interceptor applies the policy once the attempt is picked, so it
+also covers the first calls on a new ClientConn. Streaming calls ignore it.
+
+Call priority:
+
+When the channels approach their stream limits, calls without an affinity key
+are picked by their priority, set by priority of the method channel pool config
+or per call with WithCallPriority. HIGH priority calls, e.g., commits, always
+get the least busy channel without waiting for the pool to grow. LOW priority
+calls leave low_priority_reserved_streams of every channel to other calls and
+wait for a call to finish once the pool is at its max size.
+
+Chatty control-plane calls, e.g., the polling of long-running operations, can
+be kept off the stream budget of the pool. Set control_channel of their method
+channel pool config to send them over a dedicated control channel outside the
+pool, ignoring their affinity config.
+
+	ctx = grpcgcp.WithCallPriority(ctx, configpb.CallPriority_HIGH)
+
+Calls of methods without a method config pick a channel like calls without
+affinity and are counted as UnknownMethodCalls in the PoolMetrics. Set
+unknown_method_policy of the channel pool config to UNKNOWN_METHOD_REJECT to
+fail them with FAILED_PRECONDITION, e.g., to catch config drift in tests, or to
+UNKNOWN_METHOD_OVERFLOW_CHANNEL to send them over the control channel.
+
+Channel affinity via context:
+
+To send a set of calls, e.g., the calls of a transaction, over the same channel
+without an affinity configuration of the methods, make the calls with a
+context from WithChannelAffinity and release the key with
+ReleaseChannelAffinity when done. PinChannel pins calls to a channel by its
+index in the pool.
+
+	ctx = grpcgcp.WithChannelAffinity(ctx, txID)
+	defer grpcgcp.ReleaseChannelAffinity(conn, txID)
+
+The OnChannel and WithAffinityKey call options do the same for a single call,
+e.g., a targeted health probe of a channel in production, and take precedence
+over the context of the call.
+
+	_, err := client.Check(ctx, req, grpcgcp.OnChannel(3))
+
+When several logical clients share one ClientConn, their affinity keys may
+collide, e.g., equal session names of different services. Scope the keys of
+every client to a namespace with WithAffinityNamespace or the
+NamespaceUnaryClientInterceptor and NamespaceStreamClientInterceptor of its
+interceptor chain. The keys are then bound as NamespacedAffinityKey(ns, key),
+which is also the key the hooks receive and ReleaseChannelAffinity expects.
+
+Equivalent forms of an affinity key, e.g., resource names with a trailing
+slash or a differently cased project, are bound independently unless the
+NormalizeAffinityKey of the Config maps them to one key, see
+NormalizeResourceName. The keys from the messages, the request params header
+and WithChannelAffinity are normalized before they are bound or looked up.
+
+The affinity keys from the response of a BIND call are bound before the call
+returns to the caller: in the done callback of a unary call and on the first
+response message of a streaming call. Calls with the keys made right after the
+BIND call, from any goroutine, always find the binding.
+
+For APIs without an explicit call ending the use of a key, set ttl_ms of the
+affinity config of the BIND method. The keys bound by the method are unbound,
+with the OnUnbind hook called, once not used for the ttl.
+
+The keys of an UNBIND call are unbound once the call succeeds, and stay bound
+if it fails. Set unbind_on_codes of the affinity config, e.g., to "NOT_FOUND",
+to also unbind the keys of a call failed because the server no longer knows
+them, e.g., a deleted session. With unbind_policy set to UNBIND_ON_PICK the
+keys are unbound when the call is sent and bound again to their channel if the
+call fails, unless bound by another call meanwhile.
+
+To cap the affinity keys bound to a channel, e.g., to 100 Cloud Spanner
+sessions per connection, set max_affinity_keys_per_channel of the channel pool
+config. BIND calls then prefer the channels below the cap, and the pool grows
+when every channel reached it.
+
+To see how groups of affinity keys, e.g., the Cloud Spanner sessions of every
+database, are distributed across the channels, set
+affinity_key_prefix_delimiter of the channel pool config, e.g., to
+"/sessions/". GetAffinitySnapshot and GetPoolMetrics then report the bindings
+aggregated by key prefix.
+
+Over long uptimes, affinity keys may concentrate on older channels. Set
+affinity_rebalancing of the channel pool config to gradually move keys without
+active calls from the channels with the most keys to the channels with the
+least keys.
+
+Address preference:
+
+Resolvers may attach attributes to the addresses, e.g., the locality. Make
+calls with a context from WithAddressPreference to prefer the channels
+connected to the addresses matching a selector, e.g., in the same zone. The
+address of a channel is learned by the GCP stats handler.
+
+Testing:
+
+The grpcgcptest package provides a fake channel pool with a deterministic
+channel assignment to test client libraries using the grpc_gcp balancer. It
+also provides an in-process server of a session-based service with fault
+injection to validate binding, spillover and failover end-to-end.
+
+To assert the affinity routing against a real server, e.g., in a staging
+environment, install EchoUnaryServerInterceptor and
+EchoStreamServerInterceptor with the ApiConfig of the clients on the server.
+They echo the client connection every call was received over and its affinity
+keys in the EchoConnectionTrailer and EchoAffinityKeyTrailer trailers, so a
+test can check that the calls with a key share one connection.
+
+Resilience suites can inject faults into the balancer with the Chaos of the
+Config, never set by default: DropPick fails picks with the retryable
+ErrChaosDropped and BindDelay delays the binds of BIND calls, see NewChaos for
+a seeded implementation. With Chaos set, ForceTransientFailure reports a
+channel as TRANSIENT_FAILURE for a while, e.g., to exercise the fallback of
+its bound calls to other channels.
+
+Multi-endpoint failover:
+
+To fail over from a preferred endpoint to alternative endpoints, e.g., from a
+regional endpoint to the global one, use GCPMultiEndpoint. It creates a channel
+pool for every endpoint and switches to the next endpoint in the list when the
+pool of the preferred endpoint is not ready for RecoveryTimeout. It switches
+back once the preferred endpoint's pool recovers. Set StandbyPoolSize to keep
+only a few connected channels in the pools of the alternative endpoints.
+
+	gme, err := grpcgcp.NewGCPMultiEndpoint(
+		&grpcgcp.GCPMultiEndpointOptions{
+			GRPCgcpConfig: apiConfig,
+			MultiEndpoints: map[string]*multiendpoint.MultiEndpointOptions{
+				"default": {
+					Endpoints: []string{
+						"us-central1-spanner.googleapis.com:443",
+						"spanner.googleapis.com:443",
+					},
+					RecoveryTimeout: 3 * time.Second,
+				},
+			},
+			Default: "default",
+		},
+		grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(nil, "")),
+	)
+	if err != nil {
+		t.Fatalf("cannot create GCPMultiEndpoint: %v", err)
+	}
+	defer gme.Close()
+	client := spannerpb.NewSpannerClient(gme)
+
+When several endpoints are healthy, set LatencyProbeInterval to probe the
+round-trip time of every endpoint and PreferLowestLatency of a MultiEndpoint to
+use its lowest latency endpoint instead of following the priority order.
+LatencyTolerance keeps the current endpoint unless another one is faster by
+more than the tolerance.
+
+Authority sharding:
+
+To call multiple authorities of a target, e.g., regional authorities for
+resource-based routing, without mixing them in one channel pool, use
+GCPShardedConn. It keeps a separate channel pool for every authority provided
+with NewAuthorityContext.
+
+Multi-tenant proxies attaching per-RPC credentials of different tenants may
+also partition the pools by the identity of the credentials provided with
+NewIdentityContext, so the tokens of different tenants never share a
+connection, e.g., for quota or project separation at the GFE.
+
+	ctx = grpcgcp.NewIdentityContext(ctx, tenantID)
+	resp, err := client.ExecuteSql(ctx, req, grpc.PerRPCCredentials(tenantCreds))
+
+xDS:
+
+The grpc_gcp balancer is registered in the xDS LB policy registry as XDSName.
+An xDS control plane, e.g., Traffic Director, can configure it as a custom load
+balancing policy of a cluster using a TypedStruct with the type URL
+"type.googleapis.com/grpc.gcp.ApiConfig" and the api configuration in the
+value. The channel pool is then created on top of the endpoints provided by xDS.
+The GCP interceptors are still needed for affinity.
 */
 package grpcgcp // import "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp"
@@ -0,0 +1,109 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// AddressSelector reports whether a resolved address, with the attributes
+// attached by the resolver, e.g., the locality, is preferred for a call.
+type AddressSelector func(addr resolver.Address) bool
+
+type addressPreferenceKey struct{}
+
+// WithAddressPreference returns a new Context making the calls without an
+// affinity key prefer the channels connected to the addresses matching the
+// selector, e.g., addresses in the same zone as the caller. If no ready
+// channel is connected to a matching address, any channel is used.
+//
+// The address of a channel is known once a call was sent over its current
+// connection with the GCP stats handler, see NewGCPStatsHandler.
+func WithAddressPreference(ctx context.Context, sel AddressSelector) context.Context {
+	return context.WithValue(ctx, addressPreferenceKey{}, sel)
+}
+
+// addressPreferenceFromContext returns the address selector of the call, if
+// any.
+func addressPreferenceFromContext(ctx context.Context) AddressSelector {
+	if ctx == nil {
+		return nil
+	}
+	sel, _ := ctx.Value(addressPreferenceKey{}).(AddressSelector)
+	return sel
+}
+
+// subConnAddress returns the resolved address, with its attributes, the
+// current connection of the subconn is connected to.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) subConnAddress(ref *subConnRef) (resolver.Address, bool) {
+	ci := ref.getConnInfo()
+	if ci == nil || ci.remoteAddr == "" {
+		return resolver.Address{}, false
+	}
+	remote := addressKey(ci.remoteAddr)
+	for _, a := range gb.addrs {
+		if addressKey(a.Addr) == remote {
+			return a, true
+		}
+	}
+	return resolver.Address{}, false
+}
+
+// addressKey returns the canonical form of a resolved address or the remote
+// address of a connection to compare them. IP addresses are normalized, e.g.,
+// "[2001:db8:0::1]:443" and "[::ffff:10.0.0.1]:443" of a DirectPath backend
+// are the same as "[2001:db8::1]:443" and "10.0.0.1:443". The scheme of unix
+// socket addresses is removed, e.g., "unix:///tmp/grpc.sock" is the same as
+// the "/tmp/grpc.sock" remote address and "unix-abstract:grpc" as "@grpc".
+func addressKey(addr string) string {
+	switch {
+	case strings.HasPrefix(addr, "unix-abstract:"):
+		return "@" + strings.TrimPrefix(addr, "unix-abstract:")
+	case strings.HasPrefix(addr, "unix:"):
+		return strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return addr
+	}
+	return net.JoinHostPort(ip.String(), port)
+}
+
+// preferredSubConnRefs returns the subConnRefs connected to the addresses
+// matching the selector.
+func (gb *gcpBalancer) preferredSubConnRefs(refs []*subConnRef, sel AddressSelector) []*subConnRef {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+	preferred := []*subConnRef{}
+	for _, ref := range refs {
+		if addr, ok := gb.subConnAddress(ref); ok && sel(addr) {
+			preferred = append(preferred, ref)
+		}
+	}
+	return preferred
+}
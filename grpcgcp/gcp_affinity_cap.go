@@ -0,0 +1,58 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+// atAffinityCap reports whether the number of affinity keys bound to the
+// scRef reached max_affinity_keys_per_channel.
+func (gb *gcpBalancer) atAffinityCap(scRef *subConnRef) bool {
+	max := gb.config().GetChannelPool().GetMaxAffinityKeysPerChannel()
+	return max > 0 && scRef.getAffinityCnt() >= int32(max)
+}
+
+// belowAffinityCap returns the refs a BIND call may use: the refs below
+// max_affinity_keys_per_channel. If every ref is at the cap, the pool grows,
+// if it can, and all refs are returned.
+func (gb *gcpBalancer) belowAffinityCap(refs []*subConnRef) []*subConnRef {
+	if gb.config().GetChannelPool().GetMaxAffinityKeysPerChannel() == 0 {
+		return refs
+	}
+	var below []*subConnRef
+	for _, ref := range refs {
+		if !gb.atAffinityCap(ref) {
+			below = append(below, ref)
+		}
+	}
+	if len(below) > 0 {
+		return below
+	}
+	gb.growAtAffinityCap()
+	return refs
+}
+
+// growAtAffinityCap adds a channel for the keys to bind if the pool is below
+// max_size and the channel budget allows it.
+func (gb *gcpBalancer) growAtAffinityCap() {
+	maxSize := gb.config().GetChannelPool().GetMaxSize()
+	if (maxSize == 0 || gb.getConnectionPoolSize() < int(maxSize)) && gb.budgetAllowsGrowth() {
+		if gb.log.V(FINE) {
+			gb.log.Infof("every channel reached %d bound affinity keys, adding a channel", gb.config().GetChannelPool().GetMaxAffinityKeysPerChannel())
+		}
+		gb.newSubConn()
+	}
+}
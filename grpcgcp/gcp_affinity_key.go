@@ -0,0 +1,463 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+// DescriptorResolver resolves the descriptors of the services called with
+// messages encoded by a custom codec, see Config.Descriptors.
+// protoregistry.Files implements it.
+type DescriptorResolver interface {
+	FindDescriptorByName(protoreflect.FullName) (protoreflect.Descriptor, error)
+}
+
+// affinityKeys retrieves the affinity key(s) from the request or the reply
+// message of the method. Messages encoded in the protobuf wire format, e.g.,
+// []byte of a passthrough codec, are scanned for the fields on the locator
+// path only, without decoding other fields, using the descriptor of the
+// method's message.
+func (gb *gcpBalancer) affinityKeys(locator, method string, reply bool, msg interface{}) ([]string, error) {
+	raw, ok := wireBytes(msg)
+	if !ok {
+		return getAffinityKeysFromMessage(locator, msg)
+	}
+	wp, err := gb.wirePath(locator, method, reply)
+	if err != nil {
+		return nil, err
+	}
+	return wp.scan(raw, 0)
+}
+
+// configuredAffinityKeys retrieves the affinity key(s) from the request or the
+// reply message of the method at the affinity_key of the affinity config, or
+// at the first of its fallback_affinity_keys holding a key if it holds none.
+// The keys are normalized, see Config.NormalizeAffinityKey.
+func (gb *gcpBalancer) configuredAffinityKeys(cfg *pb.AffinityConfig, method string, reply bool, msg interface{}) ([]string, error) {
+	keys, err := firstAffinityKeys(cfg, func(locator string) ([]string, error) {
+		return gb.affinityKeys(locator, method, reply, msg)
+	})
+	if err != nil {
+		return keys, err
+	}
+	return gb.normalizeKeys(keys), nil
+}
+
+// firstAffinityKeys returns the keys located at the affinity_key of the
+// affinity config unless they are empty, then the keys located at the first
+// of its fallback_affinity_keys holding a key. The result of the affinity_key
+// is returned if no path holds a key.
+func firstAffinityKeys(cfg *pb.AffinityConfig, locate func(locator string) ([]string, error)) ([]string, error) {
+	keys, err := locate(cfg.GetAffinityKey())
+	if err == nil && hasAffinityKey(keys) {
+		return keys, nil
+	}
+	for _, locator := range cfg.GetFallbackAffinityKeys() {
+		if fk, ferr := locate(locator); ferr == nil && hasAffinityKey(fk) {
+			return fk, nil
+		}
+	}
+	return keys, err
+}
+
+// hasAffinityKey reports whether any of the located keys is not empty.
+func hasAffinityKey(keys []string) bool {
+	for _, k := range keys {
+		if k != "" {
+			return true
+		}
+	}
+	return false
+}
+
+type wirePathKey struct {
+	locator string
+	method  string
+	reply   bool
+}
+
+// wirePath is an affinity key locator compiled to the fields of a message.
+type wirePath struct {
+	elems []wireElem
+}
+
+// wireElem is a field on the locator path. If entry is set, the field is a
+// map and the path continues with the value of its entry with the key.
+type wireElem struct {
+	fd    protoreflect.FieldDescriptor
+	key   string
+	entry bool
+}
+
+// wirePath returns the compiled locator for the request or the reply message
+// of the method. Locators are compiled once per method.
+func (gb *gcpBalancer) wirePath(locator, method string, reply bool) (*wirePath, error) {
+	key := wirePathKey{locator: locator, method: method, reply: reply}
+	if wp, ok := gb.wirePaths.Load(key); ok {
+		return wp.(*wirePath), nil
+	}
+	md, err := gb.messageDescriptor(method, reply)
+	if err != nil {
+		return nil, err
+	}
+	wp, err := compileWirePath(md, splitPath(locator))
+	if err != nil {
+		return nil, err
+	}
+	gb.wirePaths.Store(key, wp)
+	return wp, nil
+}
+
+// compileWirePath resolves the fields of the locator path in the message.
+func compileWirePath(md protoreflect.MessageDescriptor, path []string) (*wirePath, error) {
+	wp := &wirePath{}
+	for i := range path {
+		fd, key, entry, err := lookupField(md, path, i)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkFieldKind(fd, path, i, i == len(path)-1, entry); err != nil {
+			return nil, err
+		}
+		wp.elems = append(wp.elems, wireElem{fd: fd, key: key, entry: entry})
+		if entry {
+			fd = fd.MapValue()
+		}
+		md = fd.Message()
+	}
+	return wp, nil
+}
+
+// scan returns the affinity keys from the message encoded in the wire format
+// at the i-th field of the path. Fields not on the path are skipped without
+// decoding.
+func (wp *wirePath) scan(b []byte, i int) ([]string, error) {
+	e := wp.elems[i]
+	fd := e.fd
+	oneof := fd.ContainingOneof()
+	last := i == len(wp.elems)-1
+	keys := []string{}
+	found := false
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, fmt.Errorf("cannot decode message: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if num != fd.Number() || typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, fmt.Errorf("cannot decode message: %v", protowire.ParseError(n))
+			}
+			b = b[n:]
+			if oneof != nil && num != fd.Number() && oneof.Fields().ByNumber(num) != nil {
+				// Another member of the oneof clears the field.
+				keys, found = keys[:0], false
+			}
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return nil, fmt.Errorf("cannot decode message: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if e.entry {
+			k, ev, ok, err := mapEntry(v)
+			if err != nil {
+				return keys, err
+			}
+			if k != e.key {
+				continue
+			}
+			if v = ev; !ok {
+				// Default value of an entry without value.
+				v = nil
+			}
+		}
+		kk := []string{string(v)}
+		if !last {
+			var err error
+			if kk, err = wp.scan(v, i+1); err != nil {
+				return keys, err
+			}
+		}
+		if e.entry || !fd.IsList() {
+			// The last value of a singular field or a map entry wins.
+			keys = keys[:0]
+		}
+		keys = append(keys, kk...)
+		found = true
+	}
+	if !found && (e.entry || !fd.IsList()) {
+		// Default value of an unset singular field or a missing map entry.
+		if last {
+			return []string{""}, nil
+		}
+		return wp.scan(nil, i+1)
+	}
+	return keys, nil
+}
+
+// mapEntry returns the key and the value, if present, of a map entry with
+// string keys encoded in the wire format.
+func mapEntry(b []byte) (key string, value []byte, ok bool, err error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", nil, false, fmt.Errorf("cannot decode map entry: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if (num != 1 && num != 2) || typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return "", nil, false, fmt.Errorf("cannot decode map entry: %v", protowire.ParseError(n))
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return "", nil, false, fmt.Errorf("cannot decode map entry: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if num == 1 {
+			key = string(v)
+		} else {
+			value, ok = v, true
+		}
+	}
+	return key, value, ok, nil
+}
+
+// wireBytes returns the bytes of a message passed through a custom codec as
+// is.
+func wireBytes(msg interface{}) ([]byte, bool) {
+	switch m := msg.(type) {
+	case []byte:
+		return m, true
+	case *[]byte:
+		if m == nil {
+			return nil, false
+		}
+		return *m, true
+	}
+	return nil, false
+}
+
+// messageDescriptor returns the descriptor of the request or the reply message
+// of the method from the Config.Descriptors or the global registry.
+func (gb *gcpBalancer) messageDescriptor(method string, reply bool) (protoreflect.MessageDescriptor, error) {
+	var r DescriptorResolver = protoregistry.GlobalFiles
+	if gb.opts.Descriptors != nil {
+		r = gb.opts.Descriptors
+	}
+	name := protoreflect.FullName(strings.Replace(strings.TrimPrefix(method, "/"), "/", ".", 1))
+	d, err := r.FindDescriptorByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find the descriptor of %q method: %v", method, err)
+	}
+	md, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a method", name)
+	}
+	if reply {
+		return md.Output(), nil
+	}
+	return md.Input(), nil
+}
+
+// findField returns the field of the message matching the locator path
+// element by its proto name, JSON name or Go name.
+func findField(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	fields := md.Fields()
+	if fd := fields.ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	if fd := fields.ByJSONName(name); fd != nil {
+		return fd
+	}
+	title := strings.Title(name)
+	for i := 0; i < fields.Len(); i++ {
+		if fd := fields.Get(i); strings.Title(fd.JSONName()) == title {
+			return fd
+		}
+	}
+	return nil
+}
+
+// keysFromProtoMessage walks the locator path in a proto message using
+// protoreflect, which supports messages without generated Go structs, e.g.,
+// dynamicpb messages.
+func keysFromProtoMessage(m protoreflect.Message, path []string, start int) ([]string, error) {
+	fd, key, entry, err := lookupField(m.Descriptor(), path, start)
+	if err != nil {
+		return nil, err
+	}
+	last := start == len(path)-1
+	if err := checkFieldKind(fd, path, start, last, entry); err != nil {
+		return nil, err
+	}
+	v := m.Get(fd)
+	if entry {
+		mp := v.Map()
+		if v = mp.Get(protoreflect.ValueOfString(key).MapKey()); !v.IsValid() {
+			// Default value of a missing map entry.
+			if last {
+				return []string{""}, nil
+			}
+			v = mp.NewValue()
+		}
+	}
+	if entry || !fd.IsList() {
+		if last {
+			return []string{v.String()}, nil
+		}
+		return keysFromProtoMessage(v.Message(), path, start+1)
+	}
+	keys := []string{}
+	l := v.List()
+	for i := 0; i < l.Len(); i++ {
+		if last {
+			keys = append(keys, l.Get(i).String())
+			continue
+		}
+		kk, err := keysFromProtoMessage(l.Get(i).Message(), path, start+1)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, kk...)
+	}
+	return keys, nil
+}
+
+// lookupField returns the field of the message for the start-th element of
+// the locator path and the key of the map entry if the element selects one
+// with "name[key]".
+func lookupField(md protoreflect.MessageDescriptor, path []string, start int) (fd protoreflect.FieldDescriptor, key string, entry bool, err error) {
+	name, key, entry, err := parsePathElement(path, start)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if fd = findField(md, name); fd != nil {
+		return fd, key, entry, nil
+	}
+	if od := md.Oneofs().ByName(protoreflect.Name(name)); od != nil {
+		var names []string
+		for i := 0; i < od.Fields().Len(); i++ {
+			names = append(names, string(od.Fields().Get(i).Name()))
+		}
+		return nil, "", false, fmt.Errorf("path %q traversal error: %q (index %d in the path) is a oneof in %q message, select one of its fields: %s", strings.Join(path, "."), name, start, md.FullName(), strings.Join(names, ", "))
+	}
+	return nil, "", false, fmt.Errorf("path %q traversal error: cannot lookup field %q (index %d in the path) in %q message", strings.Join(path, "."), name, start, md.FullName())
+}
+
+// parsePathElement splits the start-th element of the locator path into the
+// field name and the key of the map entry if the element is "name[key]". The
+// key may be quoted, e.g., name["a.b"].
+func parsePathElement(path []string, start int) (name, key string, entry bool, err error) {
+	elem := path[start]
+	open := strings.IndexByte(elem, '[')
+	if open < 0 {
+		if strings.IndexByte(elem, ']') >= 0 {
+			return "", "", false, fmt.Errorf("path %q traversal error: malformed element %q (index %d in the path), want name or name[key]", strings.Join(path, "."), elem, start)
+		}
+		return elem, "", false, nil
+	}
+	if open == 0 || !strings.HasSuffix(elem, "]") || open+2 > len(elem)-1 {
+		return "", "", false, fmt.Errorf("path %q traversal error: malformed element %q (index %d in the path), want name or name[key]", strings.Join(path, "."), elem, start)
+	}
+	key = elem[open+1 : len(elem)-1]
+	if len(key) >= 2 && (key[0] == '"' || key[0] == '\'') && key[len(key)-1] == key[0] {
+		key = key[1 : len(key)-1]
+	}
+	return elem[:open], key, true, nil
+}
+
+// splitPath splits the locator into the path elements on the dots outside of
+// the map keys in brackets.
+func splitPath(locator string) []string {
+	var path []string
+	depth, start := 0, 0
+	for i := 0; i < len(locator); i++ {
+		switch locator[i] {
+		case '[':
+			depth++
+		case ']':
+			if depth > 0 {
+				depth--
+			}
+		case '.':
+			if depth == 0 {
+				path = append(path, locator[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(path, locator[start:])
+}
+
+// checkFieldKind returns an error if the field cannot hold the affinity key
+// when it is the last element of the path or a message otherwise. For a map
+// field selected with "name[key]", the kind of the map values is checked.
+func checkFieldKind(fd protoreflect.FieldDescriptor, path []string, start int, last, entry bool) error {
+	if fd.IsMap() {
+		if !entry {
+			return fmt.Errorf("path %q traversal error: %q (index %d in the path) is a map field, select an entry with %s[key]", strings.Join(path, "."), path[start], start, path[start])
+		}
+		if k := fd.MapKey().Kind(); k != protoreflect.StringKind {
+			return fmt.Errorf("path %q traversal error: %q (index %d in the path) is a map field with %q keys, only string keys are supported", strings.Join(path, "."), path[start], start, k)
+		}
+		fd = fd.MapValue()
+	} else if entry {
+		return fmt.Errorf("path %q traversal error: %q (index %d in the path) is not a map field", strings.Join(path, "."), path[start], start)
+	}
+	if last {
+		if fd.Kind() != protoreflect.StringKind {
+			return fmt.Errorf("cannot get string value from %q which is %q", strings.Join(path, "."), fd.Kind())
+		}
+		return nil
+	}
+	if fd.Kind() != protoreflect.MessageKind {
+		return fmt.Errorf("path %q traversal error: cannot lookup field %q (index %d in the path) in a %q value", strings.Join(path, "."), path[start+1], start+1, fd.Kind())
+	}
+	return nil
+}
+
+// protoMessage returns the protoreflect view of a valid proto message.
+func protoMessage(msg interface{}) (protoreflect.Message, bool) {
+	pm, ok := msg.(proto.Message)
+	if !ok {
+		return nil, false
+	}
+	if v := reflect.ValueOf(pm); v.Kind() == reflect.Pointer && v.IsNil() {
+		return nil, false
+	}
+	m := pm.ProtoReflect()
+	return m, m.IsValid()
+}
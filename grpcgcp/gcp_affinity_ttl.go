@@ -0,0 +1,105 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// affinityExpiryInterval returns the interval of the sweep unbinding expired
+// affinity keys, i.e., the lowest ttl_ms of the methods, or 0 if no method
+// uses the ttl_ms.
+func (gb *gcpBalancer) affinityExpiryInterval() time.Duration {
+	var interval time.Duration
+	for _, m := range gb.config().GetMethod() {
+		ttl := time.Duration(m.GetAffinity().GetTtlMs()) * time.Millisecond
+		if ttl > 0 && (interval == 0 || ttl < interval) {
+			interval = ttl
+		}
+	}
+	return interval
+}
+
+// startAffinityExpiry starts the periodic unbinding of the affinity keys not
+// used for their ttl. Keys are also expired lazily when picked.
+func (gb *gcpBalancer) startAffinityExpiry() {
+	interval := gb.affinityExpiryInterval()
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-gb.done:
+				return
+			case <-ticker.C:
+				gb.expireAffinityKeys()
+			}
+		}
+	}()
+}
+
+// affinityKeyExpiredLocked reports whether the key was not used for its ttl.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) affinityKeyExpiredLocked(key string, now time.Time) bool {
+	ttl, ok := gb.affinityTTL[key]
+	if !ok {
+		return false
+	}
+	used := gb.affinityUsed[key]
+	if used == nil {
+		return false
+	}
+	return now.Sub(time.Unix(0, atomic.LoadInt64(used))) >= ttl
+}
+
+// expireAffinityKey unbinds the key if it was not used for its ttl.
+func (gb *gcpBalancer) expireAffinityKey(key string) {
+	gb.mu.RLock()
+	expired := gb.affinityKeyExpiredLocked(key, time.Now())
+	gb.mu.RUnlock()
+	if expired {
+		gb.unbindExpiredKey(key)
+	}
+}
+
+// expireAffinityKeys unbinds all keys not used for their ttl.
+func (gb *gcpBalancer) expireAffinityKeys() {
+	now := time.Now()
+	gb.mu.RLock()
+	expired := []string{}
+	for key := range gb.affinityTTL {
+		if gb.affinityKeyExpiredLocked(key, now) {
+			expired = append(expired, key)
+		}
+	}
+	gb.mu.RUnlock()
+	for _, key := range expired {
+		gb.unbindExpiredKey(key)
+	}
+}
+
+// unbindExpiredKey unbinds the key as an UNBIND call would, unless the key was
+// used after the expiry check.
+func (gb *gcpBalancer) unbindExpiredKey(key string) {
+	gb.unbindSubConnIf(key, 0, true)
+}
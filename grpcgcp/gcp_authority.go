@@ -0,0 +1,239 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultMaxShards is the maximum number of channel pools a GCPShardedConn
+// dials besides the default one, see GCPShardedConn.SetMaxShards.
+const DefaultMaxShards = 100
+
+// ErrTooManyShards is the error of calls needing a new channel pool of a
+// GCPShardedConn already at its maximum number of pools. Use errors.Is to
+// check for it.
+var ErrTooManyShards = status.Error(codes.ResourceExhausted, "grpcgcp: too many channel pools in GCPShardedConn")
+
+type contextAuthorityKey int
+
+var authorityKey contextAuthorityKey
+
+// NewAuthorityContext returns a new Context that carries the authority to use
+// for calls made with a GCPShardedConn.
+func NewAuthorityContext(ctx context.Context, authority string) context.Context {
+	return context.WithValue(ctx, authorityKey, authority)
+}
+
+// FromAuthorityContext returns the authority stored in ctx, if any.
+func FromAuthorityContext(ctx context.Context) (string, bool) {
+	authority, ok := ctx.Value(authorityKey).(string)
+	return authority, ok
+}
+
+type contextIdentityKey int
+
+var identityKey contextIdentityKey
+
+// NewIdentityContext returns a new Context that carries the identity of the
+// per-RPC credentials of the calls made with a GCPShardedConn, e.g., the
+// tenant of a multi-tenant proxy. Calls with different identities never share
+// channels, so the connections of a tenant carry only the tokens of the
+// tenant, e.g., for quota or project separation at the GFE.
+func NewIdentityContext(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, identityKey, identity)
+}
+
+// FromIdentityContext returns the identity stored in ctx, if any.
+func FromIdentityContext(ctx context.Context) (string, bool) {
+	identity, ok := ctx.Value(identityKey).(string)
+	return identity, ok
+}
+
+// shardKey identifies a channel pool of a GCPShardedConn.
+type shardKey struct {
+	authority string
+	identity  string
+}
+
+func (k shardKey) String() string {
+	if k.identity == "" {
+		return fmt.Sprintf("%q authority", k.authority)
+	}
+	return fmt.Sprintf("%q authority and %q identity", k.authority, k.identity)
+}
+
+// GCPShardedConn shards the channel pool of a target by the authority of the
+// calls, e.g., for resource-based routing of Google Cloud APIs to regional
+// authorities. Calls with the same authority use the same channel pool and
+// channels are never shared between authorities.
+//
+// The authority of a call is provided with NewAuthorityContext. Calls without
+// an authority use the pool dialed with the provided DialOptions as is. The
+// pool of an authority is dialed with grpc.WithAuthority on the first call with
+// the authority.
+//
+// The pools are further partitioned by the identity of the call credentials
+// provided with NewIdentityContext, e.g., by the tenant of a multi-tenant
+// proxy attaching per-RPC credentials of the tenants. Every authority and
+// identity pair gets its own pool, dialed on the first call with the pair.
+//
+// The pools are kept until Close, so the number of pools is bounded, by
+// DefaultMaxShards unless changed with SetMaxShards. Calls needing a new pool
+// beyond the bound fail with ErrTooManyShards, e.g., when the authorities or
+// the identities come from untrusted input.
+//
+//	conn, err := grpcgcp.NewGCPShardedConn(target, opts...)
+//	if err != nil {
+//		// Handle error.
+//	}
+//	defer conn.Close()
+//	client := spannerpb.NewSpannerClient(conn)
+//	ctx = grpcgcp.NewAuthorityContext(ctx, "us-central1-spanner.googleapis.com")
+//	resp, err := client.ExecuteSql(ctx, req)
+//
+// [GCPShardedConn] implements [grpc.ClientConnInterface] and can be used
+// as a [grpc.ClientConn] when creating gRPC clients.
+type GCPShardedConn struct {
+	mu sync.Mutex
+
+	target string
+	opts   []grpc.DialOption
+	def    *grpc.ClientConn
+	shards map[shardKey]*grpc.ClientConn
+	max    int
+	closed bool
+}
+
+// Make sure GCPShardedConn implements grpc.ClientConnInterface.
+var _ grpc.ClientConnInterface = (*GCPShardedConn)(nil)
+
+// NewGCPShardedConn dials the default channel pool of the target with the
+// DialOptions. The DialOptions are expected to enable the grpc_gcp balancer,
+// e.g., from WithDefaults, and are used for the pools of all authorities.
+func NewGCPShardedConn(target string, opts ...grpc.DialOption) (*GCPShardedConn, error) {
+	def, err := grpc.Dial(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &GCPShardedConn{
+		target: target,
+		opts:   opts,
+		def:    def,
+		shards: make(map[shardKey]*grpc.ClientConn),
+		max:    DefaultMaxShards,
+	}, nil
+}
+
+// SetMaxShards sets the maximum number of channel pools dialed besides the
+// default one, DefaultMaxShards by default. Pools already dialed are kept.
+func (c *GCPShardedConn) SetMaxShards(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.max = n
+}
+
+func (c *GCPShardedConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
+	conn, err := c.pickConn(ctx)
+	if err != nil {
+		return err
+	}
+	return conn.Invoke(ctx, method, args, reply, opts...)
+}
+
+func (c *GCPShardedConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	conn, err := c.pickConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return conn.NewStream(ctx, desc, method, opts...)
+}
+
+// Conn returns the ClientConn of the channel pool of the authority, dialing it
+// if needed. An empty authority returns the default ClientConn.
+func (c *GCPShardedConn) Conn(authority string) (*grpc.ClientConn, error) {
+	return c.conn(shardKey{authority: authority})
+}
+
+// IdentityConn returns the ClientConn of the channel pool of the authority and
+// the identity, dialing it if needed. An empty authority and identity returns
+// the default ClientConn.
+func (c *GCPShardedConn) IdentityConn(authority, identity string) (*grpc.ClientConn, error) {
+	return c.conn(shardKey{authority: authority, identity: identity})
+}
+
+func (c *GCPShardedConn) conn(key shardKey) (*grpc.ClientConn, error) {
+	if key == (shardKey{}) {
+		return c.def, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, fmt.Errorf("grpcgcp: GCPShardedConn is closed")
+	}
+	if conn, ok := c.shards[key]; ok {
+		return conn, nil
+	}
+	if len(c.shards) >= c.max {
+		return nil, ErrTooManyShards
+	}
+	opts := append([]grpc.DialOption{}, c.opts...)
+	if key.authority != "" {
+		opts = append(opts, grpc.WithAuthority(key.authority))
+	}
+	conn, err := grpc.Dial(c.target, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("grpcgcp: cannot dial the channel pool for %v: %v", key, err)
+	}
+	c.shards[key] = conn
+	return conn, nil
+}
+
+func (c *GCPShardedConn) pickConn(ctx context.Context) (*grpc.ClientConn, error) {
+	authority, _ := FromAuthorityContext(ctx)
+	identity, _ := FromIdentityContext(ctx)
+	return c.conn(shardKey{authority: authority, identity: identity})
+}
+
+// Close closes the channel pools of all authorities.
+func (c *GCPShardedConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	errs := []error{}
+	for key, conn := range c.shards {
+		if err := conn.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%v: %v", key, err))
+		}
+		delete(c.shards, key)
+	}
+	if err := c.def.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("grpcgcp: errors while closing GCPShardedConn: %v", errs)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package grpcgcp
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestGCPShardedConn(t *testing.T) {
+	opts, err := WithDefaults(nil)
+	if err != nil {
+		t.Fatalf("WithDefaults(nil) returned error: %v", err)
+	}
+	c, err := NewGCPShardedConn("localhost:0", append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+	if err != nil {
+		t.Fatalf("NewGCPShardedConn returned error: %v", err)
+	}
+
+	pick := func(ctx context.Context) *grpc.ClientConn {
+		conn, err := c.pickConn(ctx)
+		if err != nil {
+			t.Fatalf("pickConn returned error: %v", err)
+		}
+		return conn
+	}
+	ctx := context.Background()
+	def := pick(ctx)
+	us := pick(NewAuthorityContext(ctx, "us-central1.example.com"))
+	eu := pick(NewAuthorityContext(ctx, "europe-west1.example.com"))
+	if def == us || def == eu || us == eu {
+		t.Fatalf("authorities share ClientConns: default %p, us %p, eu %p", def, us, eu)
+	}
+	if got := pick(NewAuthorityContext(ctx, "us-central1.example.com")); got != us {
+		t.Fatalf("second call for the same authority got ClientConn %p, want %p", got, us)
+	}
+	if got := pick(NewAuthorityContext(ctx, "")); got != def {
+		t.Fatalf("call with empty authority got ClientConn %p, want the default %p", got, def)
+	}
+
+	// Identities partition the pools of the authorities.
+	tenant1 := pick(NewIdentityContext(ctx, "tenant1"))
+	tenant2 := pick(NewIdentityContext(ctx, "tenant2"))
+	usTenant1 := pick(NewIdentityContext(NewAuthorityContext(ctx, "us-central1.example.com"), "tenant1"))
+	shared := map[*grpc.ClientConn]bool{}
+	for _, conn := range []*grpc.ClientConn{def, us, eu, tenant1, tenant2, usTenant1} {
+		if shared[conn] {
+			t.Fatalf("ClientConn %p is shared by authorities or identities", conn)
+		}
+		shared[conn] = true
+	}
+	if got, err := c.IdentityConn("us-central1.example.com", "tenant1"); err != nil || got != usTenant1 {
+		t.Fatalf("IdentityConn returned %p, %v, want %p, nil", got, err, usTenant1)
+	}
+	if got := pick(NewIdentityContext(ctx, "")); got != def {
+		t.Fatalf("call with empty identity got ClientConn %p, want the default %p", got, def)
+	}
+
+	// The pools beyond the maximum are not dialed.
+	c.SetMaxShards(5)
+	if _, err := c.Conn("asia-east1.example.com"); err != ErrTooManyShards {
+		t.Fatalf("Conn beyond the maximum of shards returned error: %v, want: %v", err, ErrTooManyShards)
+	}
+	if got, err := c.Conn("us-central1.example.com"); err != nil || got != us {
+		t.Fatalf("Conn of an existing shard returned %p, %v, want %p, nil", got, err, us)
+	}
+	if got := pick(ctx); got != def {
+		t.Fatalf("call without authority got ClientConn %p, want the default %p", got, def)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	for _, conn := range []*grpc.ClientConn{def, us, eu, tenant1, tenant2, usTenant1} {
+		if s := conn.GetState(); s != connectivity.Shutdown {
+			t.Fatalf("ClientConn %p is %v after Close, want %v", conn, s, connectivity.Shutdown)
+		}
+	}
+	if _, err := c.Conn("us-central1.example.com"); err == nil {
+		t.Fatalf("Conn after Close returned no error")
+	}
+}
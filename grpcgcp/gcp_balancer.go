@@ -19,13 +19,16 @@
 package grpcgcp
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/grpclog"
@@ -42,6 +45,11 @@ var _ balancer.Balancer = (*gcpBalancer)(nil) // Ensure gcpBalancer implements B
 const (
 	// Name is the name of grpc_gcp balancer.
 	Name = "grpc_gcp"
+	// XDSName is the name of grpc_gcp balancer for the xDS LB policy registry.
+	// The xDS control plane can configure grpc_gcp as a custom load balancing
+	// policy using a TypedStruct with the type URL
+	// "type.googleapis.com/grpc.gcp.ApiConfig" and the ApiConfig in the value.
+	XDSName = "grpc.gcp.ApiConfig"
 
 	healthCheckEnabled = true
 	defaultMinSize     = 1
@@ -51,10 +59,16 @@ const (
 
 func init() {
 	balancer.Register(newBuilder())
+	balancer.Register(newBuilderFromEnv(XDSName, Config{}))
 }
 
 type gcpBalancerBuilder struct {
 	balancer.ConfigParser
+
+	name string
+	opts Config
+	// The overrides from the environment variables read on construction.
+	env envOverrides
 }
 
 type GCPBalancerConfig struct {
@@ -66,44 +80,72 @@ func (bb *gcpBalancerBuilder) Build(
 	cc balancer.ClientConn,
 	opt balancer.BuildOptions,
 ) balancer.Balancer {
+	if bb.opts.ShadowPolicy != "" {
+		return bb.buildShadow(cc, opt)
+	}
+	return bb.build(cc, opt)
+}
+
+func (bb *gcpBalancerBuilder) build(cc balancer.ClientConn, opt balancer.BuildOptions) *gcpBalancer {
 	gb := &gcpBalancer{
+		opts:             bb.opts,
 		cc:               cc,
-		methodCfg:        make(map[string]*pb.AffinityConfig),
 		affinityMap:      make(map[string]balancer.SubConn),
 		fallbackMap:      make(map[string]balancer.SubConn),
+		affinityUsed:     make(map[string]*int64),
+		unbound:          make(map[string]unboundKey),
 		scRefs:           make(map[balancer.SubConn]*subConnRef),
 		scStates:         make(map[balancer.SubConn]connectivity.State),
 		refreshingScRefs: make(map[balancer.SubConn]*subConnRef),
 		scRefList:        []*subConnRef{},
 		rrRefId:          ^uint32(0),
 		csEvltr:          &connectivityStateEvaluator{},
+		done:             make(chan struct{}),
 		// Initialize picker to a picker that always return
 		// ErrNoSubConnAvailable, because when state of a SubConn changes, we
 		// may call UpdateBalancerState with this picker.
 		picker: newErrPicker(balancer.ErrNoSubConnAvailable),
 	}
-	gb.log = NewGCPLogger(compLogger, fmt.Sprintf("[gcpBalancer %p]", gb))
+	var logger grpclog.LoggerV2 = compLogger
+	if bb.opts.Logger != nil {
+		logger = bb.opts.Logger
+	}
+	if bb.env.logLevelSet {
+		logger = verbosityLogger{LoggerV2: logger, level: bb.env.logLevel}
+	}
+	gb.log = NewGCPLogger(logger, fmt.Sprintf("[gcpBalancer %p]", gb))
+	gb.startEvents()
+	gb.initHandover()
+	gb.publishExpvar(opt.Target.URL.String())
+	if bb.opts.Observer != nil {
+		bb.opts.Observer.BalancerBuilt(gb)
+	}
 	return gb
 }
 
-func (*gcpBalancerBuilder) Name() string {
-	return Name
+func (bb *gcpBalancerBuilder) Name() string {
+	return bb.name
 }
 
 // ParseConfig converts raw json config into GCPBalancerConfig.
 // This is called by ClientConn on any load balancer config update.
 // After parsing the config, ClientConn calls UpdateClientConnState passing the config.
+// An empty or null config, e.g., an empty TypedStruct from the xDS control
+// plane, results in the default config.
 func (*gcpBalancerBuilder) ParseConfig(j json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
 	c := &GCPBalancerConfig{
 		ApiConfig: &pb.ApiConfig{},
 	}
+	if t := bytes.TrimSpace(j); len(t) == 0 || bytes.Equal(t, []byte("null")) {
+		return c, nil
+	}
 	err := protojson.Unmarshal(j, c)
 	return c, err
 }
 
 // newBuilder creates a new grpcgcp balancer builder.
 func newBuilder() balancer.Builder {
-	return &gcpBalancerBuilder{}
+	return newBuilderFromEnv(Name, Config{})
 }
 
 // connectivityStateEvaluator gets updated by addrConns when their
@@ -113,6 +155,9 @@ type connectivityStateEvaluator struct {
 	numReady            uint64 // Number of addrConns in ready state.
 	numConnecting       uint64 // Number of addrConns in connecting state.
 	numTransientFailure uint64 // Number of addrConns in transientFailure.
+	// Number of addrConns in ready state required for the ready state, see
+	// Config.MinReadyChannels. Any addrConn in ready state is enough if 0.
+	minReady uint64
 }
 
 // recordTransition records state change happening in every subConn and based on
@@ -140,27 +185,86 @@ func (cse *connectivityStateEvaluator) recordTransition(
 		}
 	}
 
-	// Evaluate.
-	if cse.numReady > 0 {
+	return cse.evaluate()
+}
+
+// evaluate returns the aggregated state. While fewer than minReady addrConns
+// are in ready state, the aggregated state is connecting.
+func (cse *connectivityStateEvaluator) evaluate() connectivity.State {
+	if cse.numReady > 0 && cse.numReady >= cse.minReady {
 		return connectivity.Ready
 	}
-	if cse.numConnecting > 0 {
+	if cse.numReady > 0 || cse.numConnecting > 0 {
 		return connectivity.Connecting
 	}
 	return connectivity.TransientFailure
 }
 
+// methodPool keeps the channel pool overrides for a group of methods.
+type methodPool struct {
+	idx        int   // Index of the method pool in subConnRef.methodStreamsCnt.
+	maxStreams int32 // The low watermark of max number of concurrent streams of the methods.
+}
+
 // subConnRef keeps reference to the real SubConn with its
 // connectivity state, affinity count and streams count.
 type subConnRef struct {
+	id          int // Index of the subConnRef in the pool.
 	subConn     balancer.SubConn
 	stateSignal chan struct{} // This channel is closed and re-created when subConn or its state changes.
 	affinityCnt int32         // Keeps track of the number of keys bound to the subConn.
 	streamsCnt  int32         // Keeps track of the number of streams opened on the subConn.
-	lastResp    time.Time     // Timestamp of the last response from the server.
-	deCalls     uint32        // Keeps track of deadline exceeded calls since last response.
-	refreshing  bool          // If this subconn is in the process of refreshing.
-	refreshCnt  uint32        // Number of refreshes since last response.
+	// Label of the channel in the logs and the introspection output, see
+	// Config.ChannelLabel. It does not change when the connection is replaced.
+	label string
+	// High-water marks of streamsCnt in the current and the previous window,
+	// see Config.SaturationWindow.
+	peakStreams     int32
+	prevPeakStreams int32
+	lastResp        time.Time // Timestamp of the last response from the server.
+	deCalls         uint32    // Keeps track of deadline exceeded calls since last response.
+	refreshing      bool      // If this subconn is in the process of refreshing.
+	refreshCnt      uint32    // Number of refreshes since last response.
+	// Keeps track of the number of streams opened on the subConn per method pool.
+	methodStreamsCnt []int32
+	latency          ewma      // Moving average of the calls latency in nanoseconds.
+	utilization      ewma      // Moving average of the backend utilization reported by ORCA.
+	errorRate        ewma      // Moving average of the calls error rate.
+	serverLatency    ewma      // Moving average of the backend latency reported by the GFE in nanoseconds.
+	wrrCurrent       float64   // Current weight of the smooth weighted round-robin, guarded by the balancer wrrMu.
+	odCalls          uint32    // Calls finished since last outlier detection evaluation.
+	odErrors         uint32    // Calls failed since last outlier detection evaluation.
+	ejectedUntil     time.Time // Non-zero if the subconn is ejected by the outlier detection.
+	// Calls finished by status code since last outlier detection evaluation.
+	odClasses callClassCounts
+	// Number of consecutive reconnect attempts since the subconn was ready.
+	reconnectAttempts uint32
+	reconnectTimer    *time.Timer // Scheduled reconnect of the idle subconn.
+	// If the subconn is excluded from new picks until its streams finish, see
+	// DrainChannel. Guarded by the balancer mutex.
+	draining bool
+	// If the subconn is removed from the pool once drained, see UpdateConfig.
+	// Guarded by the balancer mutex.
+	removing bool
+	// If the current connection received a GOAWAY, see observeGoAway.
+	// Guarded by the balancer mutex.
+	goAway bool
+	// Max concurrent streams advertised by the server of the current
+	// connection or 0 if unknown, see SetServerMaxConcurrentStreams.
+	serverMaxStreams int32
+	// Details of the current connection observed by the GCP stats handler or
+	// nil if unknown.
+	connInfo atomic.Value
+	// Adaptive throttling of the channel or nil if it is not configured.
+	throttler *adaptiveThrottler
+	// Number of transitions of the subconn out of READY.
+	flaps uint64
+	// Transitions out of READY since flapLogStart not logged yet, see
+	// recordFlapLocked. Guarded by the balancer mutex.
+	flapLogCnt   int
+	flapLogStart time.Time
+	// Calls finished on the subconn by status code.
+	calls callClassCounts
 }
 
 func (ref *subConnRef) getAffinityCnt() int32 {
@@ -179,12 +283,30 @@ func (ref *subConnRef) affinityDecr() {
 	atomic.AddInt32(&ref.affinityCnt, -1)
 }
 
-func (ref *subConnRef) streamsIncr() {
-	atomic.AddInt32(&ref.streamsCnt, 1)
+// getMethodStreamsCnt returns the number of streams of the methods from the
+// method pool mp or the number of all streams if mp is nil.
+func (ref *subConnRef) getMethodStreamsCnt(mp *methodPool) int32 {
+	if mp == nil {
+		return ref.getStreamsCnt()
+	}
+	if mp.idx >= len(ref.methodStreamsCnt) {
+		return 0
+	}
+	return atomic.LoadInt32(&ref.methodStreamsCnt[mp.idx])
+}
+
+func (ref *subConnRef) streamsIncr(mp *methodPool) {
+	ref.observeStreams(atomic.AddInt32(&ref.streamsCnt, 1))
+	if mp != nil && mp.idx < len(ref.methodStreamsCnt) {
+		atomic.AddInt32(&ref.methodStreamsCnt[mp.idx], 1)
+	}
 }
 
-func (ref *subConnRef) streamsDecr() {
+func (ref *subConnRef) streamsDecr(mp *methodPool) {
 	atomic.AddInt32(&ref.streamsCnt, -1)
+	if mp != nil && mp.idx < len(ref.methodStreamsCnt) {
+		atomic.AddInt32(&ref.methodStreamsCnt[mp.idx], -1)
+	}
 }
 
 func (ref *subConnRef) deCallsInc() uint32 {
@@ -197,9 +319,28 @@ func (ref *subConnRef) gotResp() {
 	ref.refreshCnt = 0
 }
 
+// unboundKey is the channel of a recently unbound affinity key.
+type unboundKey struct {
+	sc      balancer.SubConn
+	expires time.Time
+}
+
 type gcpBalancer struct {
-	cfg       *GCPBalancerConfig
-	methodCfg map[string]*pb.AffinityConfig
+	opts Config
+	// The *balancerConfig with the config and the method configs, replaced
+	// as a whole by UpdateConfig while the picks read it without a lock, see
+	// config and methods.
+	cfg atomic.Value
+	// The control channel outside the pool, nil until a method config sends
+	// calls over it, and its state.
+	controlSC    balancer.SubConn
+	controlState connectivity.State
+	// Labels of the channels of the SubConns removed by the balancer until
+	// they shut down, see removeSubConnLocked.
+	removedLabels map[balancer.SubConn]string
+	// Affinity key locators compiled to field paths of the wire format by
+	// wirePathKey.
+	wirePaths sync.Map
 
 	addrs   []resolver.Address
 	cc      balancer.ClientConn
@@ -209,36 +350,136 @@ type gcpBalancer struct {
 	mu          sync.RWMutex
 	affinityMap map[string]balancer.SubConn
 	fallbackMap map[string]balancer.SubConn
-	scStates    map[balancer.SubConn]connectivity.State
-	scRefs      map[balancer.SubConn]*subConnRef
-	scRefList   []*subConnRef
-	rrRefId     uint32
+	// Last time (unix nanos) an affinity key was bound or used by a call.
+	// Updated atomically so that picks of bound keys need the read lock only.
+	affinityUsed map[string]*int64
+	// Time to live since the last use of the affinity keys bound by methods
+	// with the ttl_ms, see AffinityConfig.
+	affinityTTL map[string]time.Duration
+	// Active calls using the affinity keys, updated atomically. Tracked only
+	// with the affinity rebalancing, see rebalancing.
+	affinityStreams map[string]*int32
+	// Whether the affinity rebalancing is configured.
+	rebalancing bool
+	// The last pick decisions or nil if not recorded, see pick_audit_size.
+	pickAudit *pickAudit
+	// Receivers of the channel state transitions, see WatchChannelStates.
+	stateWatchers map[chan ChannelStateUpdate]struct{}
+	// Recently unbound affinity keys with their last channel, see
+	// AffinityConfig.unbind_grace_period_ms.
+	unbound   map[string]unboundKey
+	scStates  map[balancer.SubConn]connectivity.State
+	scRefs    map[balancer.SubConn]*subConnRef
+	scRefList []*subConnRef
+	rrRefId   uint32
 
 	// Map from a fresh SubConn to the subConnRef where we want to refresh subConn.
 	refreshingScRefs map[balancer.SubConn]*subConnRef
 	// Unresponsive detection enabled flag.
 	unresponsiveDetection bool
+	// Number of method pools, i.e., method configs with channel pool overrides.
+	methodPoolsCnt int
 
 	picker balancer.Picker
 	log    grpclog.LoggerV2
+
+	// Closed when the balancer is closed.
+	done chan struct{}
+	// Queue of events for the OnEvent callback, nil if not set.
+	events chan Event
+
+	// Cumulative counters of the pool, see PoolMetrics.
+	counters poolCounters
+	// The picks of the pool in shadow mode, nil otherwise.
+	shadow *shadowMetrics
+	// Whether the pool reached Config.DegradedReadyRatio once and whether it
+	// is degraded, see checkDegradedLocked.
+	reachedReadyRatio bool
+	// The last error of the name resolver while it fails, the number of
+	// consecutive re-resolution requests and the pending one, see
+	// ResolverError.
+	resolverErr     error
+	resolveAttempts uint32
+	resolveTimer    *time.Timer
+	// The resolverFailure of the picks while no address was ever resolved.
+	resolverFailFast atomic.Value
+	degraded         bool
+	// Number of consecutive samples of the saturation at or above
+	// Config.SaturationWarningPercent, the lowest of them and whether the
+	// saturation was sustained for a window, see sampleSaturation.
+	saturatedSamples int
+	saturationLow    float64
+	saturated        bool
+	// The channel added to grow the pool until it is READY and the number of
+	// picks waiting for it, see coalesceGrowthLocked.
+	growing     *subConnRef
+	growWaiters int
+	// Connect timeouts of the new SubConns until they are READY, see
+	// connect_timeout_ms.
+	connectTimers map[balancer.SubConn]*time.Timer
+	// The channel indexes of the affinity keys by key hash not restored from
+	// Config.Handover yet and their number, see restoreHandover.
+	handoverMu   sync.Mutex
+	handover     map[string]int
+	handoverLeft int32
+	// The name of the pool in the expvar map, see Config.Expvar. Guarded by
+	// expvarMu.
+	expvarName string
+	// Guards the current weights of the subConnRefs for the
+	// PICK_WEIGHTED_ROUND_ROBIN pick strategy, see getWeightedSubConnRef.
+	wrrMu sync.Mutex
+	// The actual states of the SubConns forced to TransientFailure until
+	// they are released, see ForceTransientFailure.
+	chaosForced map[balancer.SubConn]connectivity.State
+	// The last connection error of a SubConn in TransientFailure.
+	lastConnErr error
+	// Fails calls fast during an outage, nil if not configured.
+	breaker *circuitBreaker
+	// Set if LOW priority calls wait for a call to finish, see CallPriority.
+	lowPriorityQueued uint32
+
+	// The ClientConn the balancer belongs to, see linkConn. Guarded by connMu.
+	connMu sync.Mutex
+	conn   *grpc.ClientConn
+	// Set once the balancer is linked or closed, so that linkConn skips the
+	// lock on the pick path.
+	linked uint32
+	// Set by unlinkConn. Guarded by connMu.
+	unlinked bool
 }
 
 func (gb *gcpBalancer) initializeConfig(cfg *GCPBalancerConfig) {
-	gb.cfg = &GCPBalancerConfig{
-		ApiConfig: &pb.ApiConfig{
-			ChannelPool: &pb.ChannelPoolConfig{},
-		},
-	}
+	apiCfg := &pb.ApiConfig{}
 	if cfg != nil && cfg.ApiConfig != nil {
-		gb.cfg = &GCPBalancerConfig{
-			ApiConfig: proto.Clone(cfg.ApiConfig).(*pb.ApiConfig),
-		}
+		apiCfg = proto.Clone(cfg.ApiConfig).(*pb.ApiConfig)
 	}
-
-	if gb.cfg.GetChannelPool() == nil {
-		gb.cfg.ChannelPool = &pb.ChannelPoolConfig{}
+	if apiCfg.GetChannelPool() == nil {
+		apiCfg.ChannelPool = &pb.ChannelPoolConfig{}
+	}
+	cp := apiCfg.GetChannelPool()
+	gb.applyPoolDefaults(cp)
+	methods := newMethodConfigs(apiCfg.GetMethod())
+	gb.methodPoolsCnt = methods.poolsCnt
+	gb.setConfig(&GCPBalancerConfig{ApiConfig: apiCfg}, methods)
+	if needsControlChannel(methods, cp) {
+		gb.ensureControlChannelLocked()
 	}
-	cp := gb.cfg.GetChannelPool()
+	gb.unresponsiveDetection = cp.GetUnresponsiveCalls() > 0 && cp.GetUnresponsiveDetectionMs() > 0
+	gb.breaker = newCircuitBreaker(cp.GetCircuitBreaker(), gb.log)
+	gb.rebalancing = cp.GetAffinityRebalancing().GetIntervalMs() > 0
+	gb.pickAudit = newPickAudit(cp.GetPickAuditSize())
+	gb.applyMinReadyLocked()
+	gb.enforceMinSize()
+	gb.startOutlierDetection()
+	gb.startAffinityExpiry()
+	gb.startAffinityRebalancing()
+	gb.startSaturationTracking()
+}
+
+// applyPoolDefaults overrides the channel pool config with the Config and sets
+// the defaults of the pool limits.
+func (gb *gcpBalancer) applyPoolDefaults(cp *pb.ChannelPoolConfig) {
+	gb.opts.applyTo(cp)
 	if cp.GetMinSize() == 0 {
 		cp.MinSize = defaultMinSize
 	}
@@ -248,25 +489,45 @@ func (gb *gcpBalancer) initializeConfig(cfg *GCPBalancerConfig) {
 	if cp.GetMaxConcurrentStreamsLowWatermark() == 0 {
 		cp.MaxConcurrentStreamsLowWatermark = defaultMaxStreams
 	}
-	mp := make(map[string]*pb.AffinityConfig)
-	methodCfgs := gb.cfg.GetMethod()
-	for _, methodCfg := range methodCfgs {
-		methodNames := methodCfg.GetName()
-		affinityCfg := methodCfg.GetAffinity()
-		if methodNames != nil && affinityCfg != nil {
-			for _, method := range methodNames {
-				mp[method] = affinityCfg
-			}
-		}
+}
+
+// balancerConfig is the config of the balancer with the method configs parsed
+// from it. It is not modified once stored, see setConfig.
+type balancerConfig struct {
+	cfg     *GCPBalancerConfig
+	methods *methodConfigs
+}
+
+// noMethodConfigs are the method configs of a balancer without a config yet.
+var noMethodConfigs = &methodConfigs{}
+
+// config returns the config of the balancer, see UpdateConfig, or nil if it
+// has no config yet.
+func (gb *gcpBalancer) config() *GCPBalancerConfig {
+	if c, ok := gb.cfg.Load().(*balancerConfig); ok {
+		return c.cfg
 	}
-	gb.methodCfg = mp
-	gb.unresponsiveDetection = cp.GetUnresponsiveCalls() > 0 && cp.GetUnresponsiveDetectionMs() > 0
-	gb.enforceMinSize()
+	return nil
+}
+
+// methods returns the method configs of the balancer.
+func (gb *gcpBalancer) methods() *methodConfigs {
+	if c, ok := gb.cfg.Load().(*balancerConfig); ok {
+		return c.methods
+	}
+	return noMethodConfigs
+}
+
+// setConfig replaces the config and the method configs of the balancer.
+func (gb *gcpBalancer) setConfig(cfg *GCPBalancerConfig, methods *methodConfigs) {
+	gb.cfg.Store(&balancerConfig{cfg: cfg, methods: methods})
 }
 
 func (gb *gcpBalancer) enforceMinSize() {
-	for len(gb.scRefs) < int(gb.cfg.GetChannelPool().GetMinSize()) {
-		gb.addSubConn()
+	for len(gb.scRefs) < int(gb.config().GetChannelPool().GetMinSize()) {
+		if !gb.addSubConn() {
+			return
+		}
 	}
 }
 
@@ -277,8 +538,15 @@ func (gb *gcpBalancer) UpdateClientConnState(ccs balancer.ClientConnState) error
 	if gb.log.V(FINE) {
 		gb.log.Infoln("got new resolved addresses: ", addrs, " and balancer config: ", ccs.BalancerConfig)
 	}
+	oldAddrs := gb.addrs
 	gb.addrs = addrs
-	if gb.cfg == nil {
+	if len(addrs) > 0 {
+		gb.resolverRecoveredLocked()
+	}
+	if gb.controlSC != nil {
+		gb.controlSC.UpdateAddresses(addrs)
+	}
+	if gb.config() == nil {
 		cfg, ok := ccs.BalancerConfig.(*GCPBalancerConfig)
 		if !ok && ccs.BalancerConfig != nil {
 			return fmt.Errorf("provided config is not GCPBalancerConfig: %v", ccs.BalancerConfig)
@@ -291,17 +559,93 @@ func (gb *gcpBalancer) UpdateClientConnState(ccs balancer.ClientConnState) error
 		return nil
 	}
 
+	if gb.hasChannelTargets() {
+		// The channels resolve their channel targets themselves.
+		return nil
+	}
+
+	if removed := removedAddresses(oldAddrs, addrs); len(removed) > 0 {
+		gb.log.Infof("resolver removed addresses %v, replacing their channels", removed)
+		gb.replaceSubConns(removed)
+		return nil
+	}
+
 	for _, scRef := range gb.scRefs {
 		// TODO(weiranf): update streams count when new addrs resolved?
 		scRef.subConn.UpdateAddresses(addrs)
 		scRef.subConn.Connect()
 	}
+	for sc := range gb.refreshingScRefs {
+		sc.UpdateAddresses(addrs)
+	}
 
 	return nil
 }
 
-func (gb *gcpBalancer) ResolverError(err error) {
-	gb.log.Warningf("ResolverError: %v", err)
+// removedAddresses returns the addresses from oldAddrs missing in newAddrs.
+func removedAddresses(oldAddrs, newAddrs []resolver.Address) []string {
+	present := make(map[string]bool, len(newAddrs))
+	for _, a := range newAddrs {
+		present[addressKey(a.Addr)] = true
+	}
+	removed := []string{}
+	for _, a := range oldAddrs {
+		if !present[addressKey(a.Addr)] {
+			removed = append(removed, a.Addr)
+		}
+	}
+	return removed
+}
+
+// replaceSubConns applies new addresses after the resolver removed some
+// addresses. A ready SubConn connected to a removed address, as observed by
+// the GCP stats handler, is drained: it takes no new calls, its affinity keys
+// are moved to the surviving channels and its connection is replaced once its
+// active streams finish. Other SubConns, including ready SubConns with an
+// unknown address, are updated with the new addresses in place: gRPC keeps the
+// connection to an address still resolved and reconnects the others.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) replaceSubConns(removed []string) {
+	isRemoved := make(map[string]bool, len(removed))
+	for _, a := range removed {
+		isRemoved[addressKey(a)] = true
+	}
+	// Replacements in progress were created with the old addresses.
+	for sc := range gb.refreshingScRefs {
+		sc.UpdateAddresses(gb.addrs)
+	}
+	drained := []*subConnRef{}
+	for sc, scRef := range gb.scRefs {
+		if gb.scStates[sc] != connectivity.Ready {
+			sc.UpdateAddresses(gb.addrs)
+			sc.Connect()
+			continue
+		}
+		if scRef.refreshing || scRef.draining {
+			continue
+		}
+		ci := scRef.getConnInfo()
+		if ci == nil || ci.remoteAddr == "" || !isRemoved[addressKey(ci.remoteAddr)] {
+			sc.UpdateAddresses(gb.addrs)
+			continue
+		}
+		atomic.AddUint64(&gb.counters.resolverChurn, 1)
+		gb.drainLocked(scRef)
+		drained = append(drained, scRef)
+	}
+	if len(drained) == 0 {
+		return
+	}
+	// Migrate once all the channels of the removed addresses are draining so
+	// that no key is moved to another draining channel.
+	for _, ref := range drained {
+		gb.migrateKeysLocked(ref)
+	}
+	gb.regeneratePicker()
+	gb.cc.UpdateState(balancer.State{
+		ConnectivityState: gb.state,
+		Picker:            gb.picker,
+	})
 }
 
 // check current connection pool size
@@ -313,11 +657,15 @@ func (gb *gcpBalancer) getConnectionPoolSize() int {
 }
 
 // newSubConn creates a new SubConn using cc.NewSubConn and initialize the subConnRef
-// if none of the subconns are in the Connecting state.
+// if none of the subconns are in the Connecting state and the channel added by
+// the previous call is READY.
 func (gb *gcpBalancer) newSubConn() {
 	gb.mu.Lock()
 	defer gb.mu.Unlock()
 
+	if gb.coalesceGrowthLocked() {
+		return
+	}
 	// there are chances the newly created subconns are still connecting,
 	// we can wait on those new subconns.
 	for _, scState := range gb.scStates {
@@ -325,48 +673,80 @@ func (gb *gcpBalancer) newSubConn() {
 			return
 		}
 	}
-	gb.addSubConn()
+	if gb.addSubConn() {
+		gb.growing = gb.scRefList[len(gb.scRefList)-1]
+	}
 }
 
 // addSubConn creates a new SubConn using cc.NewSubConn and initialize the subConnRef.
+// Returns false if the SubConn was not created.
 // Must be called holding the mutex lock.
-func (gb *gcpBalancer) addSubConn() {
-	sc, err := gb.cc.NewSubConn(
-		gb.addrs,
-		balancer.NewSubConnOptions{HealthCheckEnabled: healthCheckEnabled},
-	)
+func (gb *gcpBalancer) addSubConn() bool {
+	if !gb.acquireBudgetLocked() {
+		return false
+	}
+	sc, err := gb.newSubConnLocked(len(gb.scRefList))
 	if err != nil {
 		gb.log.Errorf("failed to NewSubConn: %v", err)
-		return
+		gb.releaseBudget()
+		return false
 	}
 	gb.scRefs[sc] = &subConnRef{
-		subConn:     sc,
-		stateSignal: make(chan struct{}),
-		lastResp:    time.Now(),
+		id:               len(gb.scRefList),
+		label:            gb.newChannelLabelLocked(len(gb.scRefList)),
+		subConn:          sc,
+		stateSignal:      make(chan struct{}),
+		lastResp:         time.Now(),
+		methodStreamsCnt: make([]int32, gb.methodPoolsCnt),
+		throttler:        newAdaptiveThrottler(gb.config().GetChannelPool().GetAdaptiveThrottling()),
 	}
 	gb.scStates[sc] = connectivity.Idle
 	gb.scRefList = append(gb.scRefList, gb.scRefs[sc])
+	gb.emit(ChannelCreated, gb.scRefs[sc].id, "", "")
+	gb.armConnectTimeoutLocked(gb.scRefs[sc], sc)
 	sc.Connect()
+	return true
 }
 
 // getReadySubConnRef returns a subConnRef and a bool. The bool indicates whether
 // the boundKey exists in the affinityMap. If returned subConnRef is a nil, it
-// means the underlying subconn is not READY yet.
+// means the underlying subconn is not READY yet. If the boundKey does not exist
+// but was recently unbound, the READY subConnRef it was bound to is returned.
 func (gb *gcpBalancer) getReadySubConnRef(boundKey string) (*subConnRef, bool) {
+	gb.expireAffinityKey(boundKey)
+	gb.restoreHandover(boundKey)
+	// Fast path for a ready bound subconn with the read lock only.
+	gb.mu.RLock()
+	sc, ok := gb.affinityMap[boundKey]
+	if !ok {
+		defer gb.mu.RUnlock()
+		return gb.getUnboundSubConnRef(boundKey), false
+	}
+	gb.touchAffinityKey(boundKey)
+	if scRef := gb.scRefs[sc]; gb.scStates[sc] == connectivity.Ready && !scRef.draining {
+		gb.mu.RUnlock()
+		return scRef, true
+	}
+	gb.mu.RUnlock()
+
 	gb.mu.Lock()
 	defer gb.mu.Unlock()
 
+	if sc, ok := gb.affinityMap[boundKey]; ok && gb.scRefs[sc].draining {
+		gb.migrateKeyLocked(boundKey, gb.scRefs[sc])
+	}
+
 	if sc, ok := gb.affinityMap[boundKey]; ok {
 		if gb.scStates[sc] != connectivity.Ready {
 			// It's possible that the bound subconn is not in the readySubConns list,
 			// If it's not ready, we throw ErrNoSubConnAvailable or
 			// fallback to a previously mapped ready subconn or the least busy.
-			if gb.cfg.GetChannelPool().GetFallbackToReady() {
+			if gb.config().GetChannelPool().GetFallbackToReady() {
 				if sc, ok := gb.fallbackMap[boundKey]; ok {
 					return gb.scRefs[sc], true
 				}
 				// Try to create fallback mapping.
-				if scRef, err := gb.picker.(*gcpPicker).getLeastBusySubConnRef(); err == nil {
+				if scRef, err := gb.picker.(*gcpPicker).getLeastBusySubConnRef(nil); err == nil {
 					gb.fallbackMap[boundKey] = scRef.subConn
 					return scRef, true
 				}
@@ -382,14 +762,23 @@ func (gb *gcpBalancer) getSubConnRoundRobin(ctx context.Context) *subConnRef {
 	if len(gb.scRefList) == 0 {
 		gb.newSubConn()
 	}
-	scRef := gb.scRefList[atomic.AddUint32(&gb.rrRefId, 1)%uint32(len(gb.scRefList))]
+	n := uint32(len(gb.scRefList))
+	id := atomic.AddUint32(&gb.rrRefId, 1)
+	scRef := gb.scRefList[id%n]
+	// Skip the channels at the cap of bound affinity keys.
+	for i := uint32(1); i < n && gb.atAffinityCap(scRef); i++ {
+		scRef = gb.scRefList[(id+i)%n]
+	}
+	if gb.atAffinityCap(scRef) {
+		gb.growAtAffinityCap()
+	}
 
 	gb.mu.RLock()
 	if state := gb.scStates[scRef.subConn]; state == connectivity.Ready {
 		gb.mu.RUnlock()
 		return scRef
-	} else {
-		grpclog.Infof("grpcgcp.gcpBalancer: scRef is not ready: %v", state)
+	} else if gb.log.V(FINEST) {
+		gb.log.Infof("scRef is not ready: %v", state)
 	}
 
 	ticker := time.NewTicker(time.Millisecond * 100)
@@ -414,54 +803,207 @@ func (gb *gcpBalancer) getSubConnRoundRobin(ctx context.Context) *subConnRef {
 
 // bindSubConn binds the given affinity key to an existing subConnRef.
 func (gb *gcpBalancer) bindSubConn(bindKey string, sc balancer.SubConn) {
+	gb.bindSubConnWithTTL(bindKey, sc, 0)
+}
+
+// bindSubConnWithTTL binds the given affinity key to an existing subConnRef.
+// If ttl is positive, the key expires after ttl without use.
+func (gb *gcpBalancer) bindSubConnWithTTL(bindKey string, sc balancer.SubConn, ttl time.Duration) {
 	gb.mu.Lock()
-	defer gb.mu.Unlock()
-	_, ok := gb.affinityMap[bindKey]
+	boundSC, ok := gb.affinityMap[bindKey]
 	if !ok {
 		gb.affinityMap[bindKey] = sc
+		boundSC = sc
+		// A key bound again is counted once.
+		gb.scRefs[sc].affinityIncr()
+	}
+	delete(gb.unbound, bindKey)
+	if gb.affinityUsed == nil {
+		gb.affinityUsed = make(map[string]*int64)
+	}
+	if _, ok := gb.affinityUsed[bindKey]; !ok {
+		gb.affinityUsed[bindKey] = new(int64)
+	}
+	gb.touchAffinityKey(bindKey)
+	if gb.rebalancing {
+		if gb.affinityStreams == nil {
+			gb.affinityStreams = make(map[string]*int32)
+		}
+		if _, ok := gb.affinityStreams[bindKey]; !ok {
+			gb.affinityStreams[bindKey] = new(int32)
+		}
+	}
+	if ttl > 0 {
+		if gb.affinityTTL == nil {
+			gb.affinityTTL = make(map[string]time.Duration)
+		}
+		gb.affinityTTL[bindKey] = ttl
 	}
-	gb.scRefs[sc].affinityIncr()
+	channelID, label := gb.scRefs[boundSC].id, gb.scRefs[boundSC].label
+	gb.mu.Unlock()
+	if gb.log.V(FINE) {
+		gb.log.Infof("bound affinity key %s to channel %s", AffinityKeyHash(bindKey), label)
+	}
+	gb.emit(KeyBound, channelID, bindKey, "")
+	gb.onBind(bindKey, channelID)
 }
 
-// unbindSubConn removes the existing binding associated with the key.
-func (gb *gcpBalancer) unbindSubConn(boundKey string) {
+// validateBinding checks that the affinity key from the response of a BOUND
+// call is bound to the channel used by the call. The key may be bound to
+// another channel if, e.g., the server migrated the session.
+func (gb *gcpBalancer) validateBinding(key string, scRef *subConnRef) {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+	sc, ok := gb.affinityMap[key]
+	if !ok || sc == scRef.subConn || gb.fallbackMap[key] == scRef.subConn {
+		return
+	}
+	atomic.AddUint64(&gb.counters.affinityMismatches, 1)
+	gb.log.Warningf(
+		"affinity mismatch: the response of a call on channel %s has affinity key %s bound to channel %s",
+		scRef.label, AffinityKeyHash(key), gb.subConnLabelLocked(sc),
+	)
+}
+
+// touchAffinityKey records the time the affinity key was used.
+// Must be called holding the mutex read or write lock.
+func (gb *gcpBalancer) touchAffinityKey(key string) {
+	if used := gb.affinityUsed[key]; used != nil {
+		atomic.StoreInt64(used, time.Now().UnixNano())
+	}
+}
+
+// unbindSubConn removes the existing binding associated with the key. If grace
+// is positive, the key is remembered with its channel for the grace period.
+func (gb *gcpBalancer) unbindSubConn(boundKey string, grace time.Duration) bool {
+	return gb.unbindSubConnIf(boundKey, grace, false)
+}
+
+// unbindSubConnIf removes the existing binding associated with the key. If
+// expiredOnly is set, the key is unbound only if it was not used for its ttl.
+// It returns false if the key was not unbound.
+func (gb *gcpBalancer) unbindSubConnIf(boundKey string, grace time.Duration, expiredOnly bool) bool {
 	gb.mu.Lock()
-	defer gb.mu.Unlock()
 	boundSC, ok := gb.affinityMap[boundKey]
-	if ok {
-		gb.scRefs[boundSC].affinityDecr()
-		delete(gb.affinityMap, boundKey)
+	if !ok || (expiredOnly && !gb.affinityKeyExpiredLocked(boundKey, time.Now())) {
+		gb.mu.Unlock()
+		return false
 	}
+	scRef := gb.scRefs[boundSC]
+	scRef.affinityDecr()
+	delete(gb.affinityMap, boundKey)
+	delete(gb.affinityUsed, boundKey)
+	delete(gb.affinityTTL, boundKey)
+	delete(gb.affinityStreams, boundKey)
+	gb.rememberUnboundLocked(boundKey, boundSC, grace)
+	gb.mu.Unlock()
+	if gb.log.V(FINE) {
+		gb.log.Infof("unbound affinity key %s from channel %s", AffinityKeyHash(boundKey), scRef.label)
+	}
+	gb.emit(KeyUnbound, scRef.id, boundKey, "")
+	gb.onUnbind(boundKey, scRef.id)
+	return true
+}
+
+// rememberUnboundLocked remembers the channel of the unbound key for the grace
+// period and forgets the expired unbound keys.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) rememberUnboundLocked(key string, sc balancer.SubConn, grace time.Duration) {
+	now := time.Now()
+	for k, v := range gb.unbound {
+		if now.After(v.expires) {
+			delete(gb.unbound, k)
+		}
+	}
+	if grace <= 0 {
+		return
+	}
+	if gb.unbound == nil {
+		gb.unbound = make(map[string]unboundKey)
+	}
+	gb.unbound[key] = unboundKey{sc: sc, expires: now.Add(grace)}
+}
+
+// getUnboundSubConnRef returns the ready subConnRef of a recently unbound key
+// or nil. Must be called holding the mutex read lock.
+func (gb *gcpBalancer) getUnboundSubConnRef(key string) *subConnRef {
+	u, ok := gb.unbound[key]
+	if !ok || time.Now().After(u.expires) || gb.scStates[u.sc] != connectivity.Ready {
+		return nil
+	}
+	if ref := gb.scRefs[u.sc]; ref != nil && !ref.draining && !ref.isEjected() {
+		return ref
+	}
+	return nil
 }
 
 // regeneratePicker takes a snapshot of the balancer, and generates a picker
 // from it. The picker is
-//   - errPicker with ErrTransientFailure if the balancer is in TransientFailure,
+//   - errPicker with ErrTransientFailure and the last connection error if the
+//     balancer is in TransientFailure,
 //   - built by the pickerBuilder with all READY SubConns otherwise.
 func (gb *gcpBalancer) regeneratePicker() {
 	if gb.state == connectivity.TransientFailure {
-		gb.picker = newErrPicker(balancer.ErrTransientFailure)
+		gb.picker = newErrPicker(gb.transientFailureErr())
 		return
 	}
 	readyRefs := []*subConnRef{}
 
-	// Select ready subConns from subConn map.
+	// Select ready subConns from subConn map skipping ejected and draining subConns.
 	for sc, scState := range gb.scStates {
-		if scState == connectivity.Ready {
+		if ref := gb.scRefs[sc]; scState == connectivity.Ready && !ref.isEjected() && !ref.draining {
 			readyRefs = append(readyRefs, gb.scRefs[sc])
 		}
 	}
+	if gb.opts.Deterministic {
+		// Order by channel index, so that ties are broken by the lowest index.
+		sort.Slice(readyRefs, func(i, j int) bool {
+			return readyRefs[i].id < readyRefs[j].id
+		})
+	}
 	gb.picker = newGCPPicker(readyRefs, gb)
 }
 
+// transientFailureErr returns the error of the picker in TransientFailure.
+// The error must not be a status error: gRPC fails fail-fast calls with the
+// UNAVAILABLE status on such picker errors while wait-for-ready calls keep
+// waiting for a new picker, same as with the picker of the base balancer.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) transientFailureErr() error {
+	if gb.resolverErr != nil {
+		return fmt.Errorf("%v, name resolver error: %v", balancer.ErrTransientFailure, gb.resolverErr)
+	}
+	if gb.lastConnErr == nil {
+		return balancer.ErrTransientFailure
+	}
+	return fmt.Errorf("%v, last connection error: %v", balancer.ErrTransientFailure, gb.lastConnErr)
+}
+
 func (gb *gcpBalancer) UpdateSubConnState(sc balancer.SubConn, scs balancer.SubConnState) {
 	gb.mu.Lock()
 	defer gb.mu.Unlock()
+	if gb.chaosHoldLocked(sc, scs.ConnectivityState) {
+		return
+	}
+	gb.updateSubConnStateLocked(sc, scs)
+}
+
+// updateSubConnStateLocked applies the state change of the SubConn.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) updateSubConnStateLocked(sc balancer.SubConn, scs balancer.SubConnState) {
 	s := scs.ConnectivityState
 
+	if sc == gb.controlSC {
+		gb.updateControlStateLocked(s)
+		return
+	}
+	if s == connectivity.Ready || s == connectivity.Shutdown {
+		gb.disarmConnectTimeoutLocked(sc)
+	}
+
 	if scRef, found := gb.refreshingScRefs[sc]; found {
 		if gb.log.V(FINE) {
-			gb.log.Infof("handle replacement SubConn state change: %p, %v", sc, s)
+			gb.log.Infof("handle replacement SubConn state change: %s, %v", gb.subConnLabelLocked(sc), s)
 		}
 		if s != connectivity.Ready {
 			// Ignore the replacement sc until it's ready.
@@ -480,34 +1022,76 @@ func (gb *gcpBalancer) UpdateSubConnState(sc balancer.SubConn, scs balancer.SubC
 		scRef.deCalls = 0
 		scRef.lastResp = time.Now()
 		scRef.refreshing = false
+		scRef.goAway = false
 		scRef.refreshCnt++
-		gb.cc.RemoveSubConn(oldSc)
+		scRef.reconnectAttempts = 0
+		scRef.stopReconnect()
+		atomic.StoreInt32(&scRef.serverMaxStreams, 0)
+		scRef.resetConnInfo()
+		gb.moveSubConnLocked(oldSc, sc)
+		gb.removeSubConnLocked(oldSc, scRef)
+		gb.onChannelReplaced(scRef)
+		if scRef.isEjected() || scRef.draining {
+			// The replacement connection lifts the ejection and completes
+			// the draining.
+			scRef.ejectedUntil = time.Time{}
+			scRef.draining = scRef.removing
+			defer func() {
+				gb.regeneratePicker()
+				gb.cc.UpdateState(balancer.State{
+					ConnectivityState: gb.state,
+					Picker:            gb.picker,
+				})
+			}()
+		}
 	}
 
-	if gb.log.V(FINE) {
-		gb.log.Infof("handle SubConn state change: %p, %v", sc, s)
+	if gb.log.V(FINE) && !gb.flappingLocked(sc) {
+		gb.log.Infof("handle SubConn state change: %s, %v", gb.subConnLabelLocked(sc), s)
 	}
 
 	oldS, ok := gb.scStates[sc]
 	if !ok {
 		if gb.log.V(FINE) {
 			gb.log.Infof(
-				"got state changes for an unknown/replaced SubConn: %p, %v",
-				sc,
+				"got state changes for an unknown/replaced SubConn: %s, %v",
+				gb.subConnLabelLocked(sc),
 				s,
 			)
 		}
+		if s == connectivity.Shutdown {
+			delete(gb.removedLabels, sc)
+		}
 		return
 	}
 	gb.scStates[sc] = s
+	// The ref of a SubConn shutting down is removed below.
+	ref := gb.scRefs[sc]
+	gb.settleGrowthLocked(ref, sc, s)
+	if s == connectivity.TransientFailure && scs.ConnectionError != nil {
+		gb.lastConnErr = scs.ConnectionError
+	}
 	switch s {
 	case connectivity.Idle:
-		sc.Connect()
+		gb.reconnect(sc)
+	case connectivity.Ready:
+		if scRef := gb.scRefs[sc]; scRef != nil {
+			scRef.reconnectAttempts = 0
+			scRef.goAway = false
+		}
 	case connectivity.Shutdown:
+		if scRef := gb.scRefs[sc]; scRef != nil && scRef.subConn == sc {
+			scRef.stopReconnect()
+		}
 		delete(gb.scRefs, sc)
 		delete(gb.scStates, sc)
 	}
 	if oldS == connectivity.Ready && s != oldS {
+		if scRef := gb.scRefs[sc]; scRef != nil {
+			gb.emit(ChannelBroken, scRef.id, "", "")
+			gb.recordFlapLocked(scRef, s)
+			gb.recordDisconnectLocked(scRef)
+		}
 		// Subconn is broken. Remove fallback mapping to this subconn.
 		for k, v := range gb.fallbackMap {
 			if v == sc {
@@ -516,6 +1100,9 @@ func (gb *gcpBalancer) UpdateSubConnState(sc balancer.SubConn, scs balancer.SubC
 		}
 	}
 	if oldS != connectivity.Ready && s == connectivity.Ready {
+		if scRef := gb.scRefs[sc]; scRef != nil {
+			gb.emit(ChannelReady, scRef.id, "", "")
+		}
 		// Remove fallback mapping for the keys of recovered subconn.
 		for k := range gb.fallbackMap {
 			if gb.affinityMap[k] == sc {
@@ -526,6 +1113,10 @@ func (gb *gcpBalancer) UpdateSubConnState(sc balancer.SubConn, scs balancer.SubC
 
 	oldAggrState := gb.state
 	gb.state = gb.csEvltr.recordTransition(oldS, s)
+	if ref != nil && ref.subConn == sc && s != oldS {
+		gb.notifyStateWatchersLocked(ref.id, oldS, s)
+	}
+	gb.checkDegradedLocked()
 
 	// Regenerate picker when one of the following happens:
 	//  - this sc became ready from not-ready
@@ -548,6 +1139,27 @@ func (gb *gcpBalancer) UpdateSubConnState(sc balancer.SubConn, scs balancer.SubC
 	}
 }
 
+// moveSubConnLocked moves the affinity keys of the replaced SubConn oldSc to
+// its replacement sc.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) moveSubConnLocked(oldSc, sc balancer.SubConn) {
+	for k, v := range gb.affinityMap {
+		if v == oldSc {
+			gb.affinityMap[k] = sc
+		}
+	}
+	for k, v := range gb.fallbackMap {
+		if v == oldSc {
+			gb.fallbackMap[k] = sc
+		}
+	}
+	for k, v := range gb.unbound {
+		if v.sc == oldSc {
+			gb.unbound[k] = unboundKey{sc: sc, expires: v.expires}
+		}
+	}
+}
+
 // refresh initiates a new SubConn for a specific subConnRef and starts connecting.
 // If the refresh is already initiated for the ref, then this is a no-op.
 func (gb *gcpBalancer) refresh(ref *subConnRef) {
@@ -556,21 +1168,65 @@ func (gb *gcpBalancer) refresh(ref *subConnRef) {
 	}
 	gb.mu.Lock()
 	defer gb.mu.Unlock()
+	gb.refreshLocked(ref)
+}
+
+// refreshLocked is the same as refresh but must be called holding the mutex lock.
+func (gb *gcpBalancer) refreshLocked(ref *subConnRef) {
 	if ref.refreshing {
 		return
 	}
 	ref.refreshing = true
-	sc, err := gb.cc.NewSubConn(
-		gb.addrs,
-		balancer.NewSubConnOptions{HealthCheckEnabled: healthCheckEnabled},
-	)
+	sc, err := gb.newSubConnLocked(ref.id)
 	if err != nil {
 		gb.log.Errorf("failed to create a replacement SubConn with NewSubConn: %v", err)
+		ref.refreshing = false
 		return
 	}
 	gb.refreshingScRefs[sc] = ref
+	gb.armConnectTimeoutLocked(ref, sc)
 	sc.Connect()
 }
 
+// newSubConnLocked creates a SubConn for the channel with the channelID using
+// cc.NewSubConn after the Config.BeforeNewSubConn hook, if any.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) newSubConnLocked(channelID int) (balancer.SubConn, error) {
+	addrs := gb.channelAddrsLocked(channelID)
+	opts := balancer.NewSubConnOptions{
+		HealthCheckEnabled: healthCheckEnabled && !gb.config().GetChannelPool().GetDisableHealthCheck(),
+	}
+	if gb.opts.BeforeNewSubConn != nil {
+		var err error
+		addrs = append([]resolver.Address(nil), addrs...)
+		if addrs, opts, err = gb.opts.BeforeNewSubConn(channelID, addrs, opts); err != nil {
+			return nil, fmt.Errorf("vetoed by BeforeNewSubConn: %v", err)
+		}
+	}
+	return gb.cc.NewSubConn(addrs, opts)
+}
+
 func (gb *gcpBalancer) Close() {
+	gb.unlinkConn()
+	if gb.done != nil {
+		close(gb.done)
+	}
+	gb.mu.Lock()
+	for _, ref := range gb.scRefList {
+		ref.stopReconnect()
+	}
+	for sc := range gb.connectTimers {
+		gb.disarmConnectTimeoutLocked(sc)
+	}
+	if gb.resolveTimer != nil {
+		gb.resolveTimer.Stop()
+	}
+	gb.mu.Unlock()
+	gb.unpublishExpvar()
+	if gb.opts.ChannelBudget != nil {
+		gb.opts.ChannelBudget.leave(gb)
+	}
+	if gb.opts.Observer != nil {
+		gb.opts.Observer.BalancerClosed(gb)
+	}
 }
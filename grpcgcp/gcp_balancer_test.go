@@ -3,8 +3,17 @@ package grpcgcp
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,11 +22,14 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/grpclog"
 	"google.golang.org/grpc/resolver"
-	"google.golang.org/grpc/serviceconfig"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
 )
@@ -47,31 +59,41 @@ var testApiConfig = &pb.ApiConfig{
 	},
 }
 
-var currBalancer *gcpBalancer
-
-// testBuilderWrapper wraps the real gcpBalancerBuilder
-// so it can cache the created balancer object
-type testBuilderWrapper struct {
-	balancer.ConfigParser
+// testObserver records the balancers built by a builder.
+type testObserver struct {
+	built  chan *gcpBalancer
+	closed chan *gcpBalancer
+}
 
-	name        string
-	realBuilder *gcpBalancerBuilder
+func newTestObserver() *testObserver {
+	return &testObserver{
+		built:  make(chan *gcpBalancer, 10),
+		closed: make(chan *gcpBalancer, 10),
+	}
 }
 
-func (tb *testBuilderWrapper) Build(
-	cc balancer.ClientConn,
-	opt balancer.BuildOptions,
-) balancer.Balancer {
-	currBalancer = tb.realBuilder.Build(cc, opt).(*gcpBalancer)
-	return currBalancer
+func (o *testObserver) BalancerBuilt(b BalancerInfo) {
+	o.built <- b.(*gcpBalancer)
 }
 
-func (*testBuilderWrapper) Name() string {
-	return Name
+func (o *testObserver) BalancerClosed(b BalancerInfo) {
+	o.closed <- b.(*gcpBalancer)
 }
 
-func (tb *testBuilderWrapper) ParseConfig(j json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
-	return tb.realBuilder.ParseConfig(j)
+// configured waits for the next balancer built and configured.
+func (o *testObserver) configured(t *testing.T) *gcpBalancer {
+	t.Helper()
+	var b *gcpBalancer
+	select {
+	case b = <-o.built:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no balancer was built")
+	}
+	// The balancer is configured asynchronously.
+	for i := 0; i < 500 && b.ApiConfig() == nil; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	return b
 }
 
 func TestDefaultConfig(t *testing.T) {
@@ -102,7 +124,7 @@ func TestDefaultConfig(t *testing.T) {
 		ResolverState: resolver.State{},
 	})
 
-	if diff := cmp.Diff(wantCfg, b.cfg.ApiConfig, protocmp.Transform()); diff != "" {
+	if diff := cmp.Diff(wantCfg, b.config().ApiConfig, protocmp.Transform()); diff != "" {
 		t.Errorf("gcp_balancer config has unexpected difference (-want +got):\n%v", diff)
 	}
 
@@ -113,7 +135,7 @@ func TestDefaultConfig(t *testing.T) {
 		BalancerConfig: &GCPBalancerConfig{},
 	})
 
-	if diff := cmp.Diff(wantCfg, b.cfg.ApiConfig, protocmp.Transform()); diff != "" {
+	if diff := cmp.Diff(wantCfg, b.config().ApiConfig, protocmp.Transform()); diff != "" {
 		t.Errorf("gcp_balancer config has unexpected difference (-want +got):\n%v", diff)
 	}
 }
@@ -139,7 +161,7 @@ func TestConfig(t *testing.T) {
 		},
 	})
 
-	if diff := cmp.Diff(testApiConfig, b.cfg.ApiConfig, protocmp.Transform()); diff != "" {
+	if diff := cmp.Diff(testApiConfig, b.config().ApiConfig, protocmp.Transform()); diff != "" {
 		t.Errorf("gcp_balancer config has unexpected difference (-want +got):\n%v", diff)
 	}
 }
@@ -158,12 +180,47 @@ func TestParseConfig(t *testing.T) {
 	}
 }
 
+func TestParseConfigFromXDS(t *testing.T) {
+	j, err := protojson.Marshal(testApiConfig)
+	if err != nil {
+		t.Fatalf("cannot encode ApiConfig: %v", err)
+	}
+	// The xDS client converts the TypedStruct value to JSON.
+	s := &structpb.Struct{}
+	if err := protojson.Unmarshal(j, s); err != nil {
+		t.Fatalf("cannot decode ApiConfig JSON to Struct: %v", err)
+	}
+	j, err = json.Marshal(s)
+	if err != nil {
+		t.Fatalf("cannot encode Struct: %v", err)
+	}
+
+	bb := balancer.Get(XDSName)
+	if bb == nil {
+		t.Fatalf("balancer.Get(%q) returned nil, want grpc_gcp balancer builder", XDSName)
+	}
+	cfg, err := bb.(balancer.ConfigParser).ParseConfig(j)
+	if err != nil {
+		t.Fatalf("ParseConfig returns error: %v, want: nil", err)
+	}
+	if diff := cmp.Diff(testApiConfig, cfg, protocmp.Transform()); diff != "" {
+		t.Errorf("ParseConfig() result has unexpected difference (-want +got):\n%v", diff)
+	}
+
+	// Empty TypedStruct results in the default config.
+	cfg, err = bb.(balancer.ConfigParser).ParseConfig(json.RawMessage("null"))
+	if err != nil {
+		t.Fatalf("ParseConfig returns error: %v, want: nil", err)
+	}
+	if diff := cmp.Diff(&pb.ApiConfig{}, cfg, protocmp.Transform()); diff != "" {
+		t.Errorf("ParseConfig() result has unexpected difference (-want +got):\n%v", diff)
+	}
+}
+
 func TestParseConfigFromDial(t *testing.T) {
-	// Register test builder wrapper.
-	balancer.Register(&testBuilderWrapper{
-		name:        Name,
-		realBuilder: newBuilder().(*gcpBalancerBuilder),
-	})
+	obs := newTestObserver()
+	balancer.Register(NewBalancerBuilder(Config{Observer: obs}))
+	defer balancer.Register(newBuilder())
 
 	json, err := protojson.Marshal(testApiConfig)
 	if err != nil {
@@ -183,11 +240,98 @@ func TestParseConfigFromDial(t *testing.T) {
 	}
 	defer conn.Close()
 
-	if diff := cmp.Diff(testApiConfig, currBalancer.cfg.ApiConfig, protocmp.Transform()); diff != "" {
+	if diff := cmp.Diff(testApiConfig, obs.configured(t).ApiConfig(), protocmp.Transform()); diff != "" {
+		t.Errorf("gcp_balancer config has unexpected difference (-want +got):\n%v", diff)
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	obs := newTestObserver()
+	balancer.Register(NewBalancerBuilder(Config{Observer: obs}))
+	defer balancer.Register(newBuilder())
+
+	opts, err := WithDefaults(testApiConfig)
+	if err != nil {
+		t.Fatalf("WithDefaults returns error: %v, want: nil", err)
+	}
+
+	conn, err := grpc.Dial("localhost:433", append(opts, grpc.WithInsecure())...)
+	if err != nil {
+		t.Fatalf("Creation of ClientConn failed due to error: %s", err.Error())
+	}
+	defer conn.Close()
+
+	if diff := cmp.Diff(testApiConfig, obs.configured(t).ApiConfig(), protocmp.Transform()); diff != "" {
 		t.Errorf("gcp_balancer config has unexpected difference (-want +got):\n%v", diff)
 	}
 }
 
+func TestObserverBalancerClosed(t *testing.T) {
+	obs := newTestObserver()
+	balancer.Register(NewBalancerBuilder(Config{Observer: obs}))
+	defer balancer.Register(newBuilder())
+
+	opts, err := WithDefaults(testApiConfig)
+	if err != nil {
+		t.Fatalf("WithDefaults returns error: %v, want: nil", err)
+	}
+	conn, err := grpc.Dial("localhost:433", append(opts, grpc.WithInsecure())...)
+	if err != nil {
+		t.Fatalf("Creation of ClientConn failed due to error: %s", err.Error())
+	}
+	b := obs.configured(t)
+	if got := b.PoolMetrics(); got == nil {
+		t.Fatalf("PoolMetrics() returns nil for a configured balancer")
+	}
+	conn.Close()
+
+	select {
+	case got := <-obs.closed:
+		if got != b {
+			t.Fatalf("BalancerClosed() is called for another balancer")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("BalancerClosed() is not called after the ClientConn is closed")
+	}
+}
+
+func TestWithDefaultsKeepalive(t *testing.T) {
+	for _, tc := range []struct {
+		cfg     *pb.KeepaliveConfig
+		wantErr bool
+		opts    int
+	}{
+		{cfg: nil, opts: 4},
+		{cfg: &pb.KeepaliveConfig{TimeMs: 30000, TimeoutMs: 5000, PermitWithoutStream: true}, opts: 5},
+		{cfg: &pb.KeepaliveConfig{TimeMs: 5000}, wantErr: true},
+		{cfg: &pb.KeepaliveConfig{TimeoutMs: 5000}, wantErr: true},
+	} {
+		opts, err := WithDefaults(&pb.ApiConfig{ChannelPool: &pb.ChannelPoolConfig{Keepalive: tc.cfg}})
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Fatalf("WithDefaults with keepalive %v returns error: %v, want error: %v", tc.cfg, err, tc.wantErr)
+		}
+		if len(opts) != tc.opts {
+			t.Fatalf("WithDefaults with keepalive %v returns %d options, want %d", tc.cfg, len(opts), tc.opts)
+		}
+	}
+}
+
+func TestRecordKeepaliveDrop(t *testing.T) {
+	gb := &gcpBalancer{}
+	for _, err := range []error{
+		status.Error(codes.Unavailable, "connection error: desc = \"keepalive ping failed to receive ACK within timeout\""),
+		status.Error(codes.Unavailable, "closing transport due to: connection error, received prior goaway: code: ENHANCE_YOUR_CALM, debug data: \"too_many_pings\""),
+		status.Error(codes.Unavailable, "closing transport due to: connection error, received prior goaway: code: ENHANCE_YOUR_CALM, debug data: \"too_many_pings\""),
+		status.Error(codes.Unavailable, "connection reset"),
+	} {
+		gb.recordKeepaliveDrop(err)
+	}
+	m := gb.poolMetrics()
+	if m.KeepaliveTimeouts != 1 || m.KeepaliveTooManyPings != 2 {
+		t.Fatalf("poolMetrics() returns %d keepalive timeouts and %d too many pings, want 1 and 2", m.KeepaliveTimeouts, m.KeepaliveTooManyPings)
+	}
+}
+
 func TestCreatesMinSubConns(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -486,7 +630,8 @@ func TestRefreshingSubConnsDoesNotAffectConnState(t *testing.T) {
 	time.Sleep(time.Millisecond * 110)
 
 	addCall := func() {
-		ctx, _ := context.WithTimeout(context.TODO(), 0)
+		ctx, cancel := context.WithTimeout(context.TODO(), 0)
+		defer cancel()
 		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: ctx})
 		if err != nil {
 			t.Fatalf("gcpPicker.Pick returns error %v, want: nil", err)
@@ -580,7 +725,8 @@ func TestShutdownWhileRefreshing(t *testing.T) {
 	time.Sleep(time.Millisecond * 110)
 
 	addCall := func() {
-		ctx, _ := context.WithTimeout(context.TODO(), 0)
+		ctx, cancel := context.WithTimeout(context.TODO(), 0)
+		defer cancel()
 		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: ctx})
 		if err != nil {
 			t.Fatalf("gcpPicker.Pick returns error %v, want: nil", err)
@@ -947,3 +1093,3064 @@ func TestRoundRobinBindShouldRespectDeadlineAndCancellation(t *testing.T) {
 		t.Fatalf("gcpPicker.Pick did not respect deadline, took: %v, want <=%v", elapsed, timeout+margin)
 	}
 }
+
+func TestOutlierDetection(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// A slice to store all SubConns created by gcpBalancer's ClientConn.
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().RemoveSubConn(gomock.Any()).Times(1)
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().MinTimes(1)
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(5)
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          4,
+					MaxSize:                          4,
+					MaxConcurrentStreamsLowWatermark: 100,
+					OutlierDetection: &pb.OutlierDetectionConfig{
+						// Long interval so that we can trigger the evaluation manually.
+						IntervalMs:               3600000,
+						MinCalls:                 10,
+						ErrorPercentageOverPeers: 30,
+						EjectionTimeMs:           3600000,
+					},
+				},
+			},
+		},
+	})
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+
+	unavailableErr := status.Error(codes.Unavailable, "unavailable")
+	for i, sc := range scs {
+		for j := 0; j < 10; j++ {
+			var err error
+			// SubConn 0 fails 80% of calls, others fail 10% of calls.
+			if (i == 0 && j < 8) || j == 0 {
+				err = unavailableErr
+			}
+			b.scRefs[sc].recordCall(time.Millisecond, false, err)
+		}
+	}
+	// Not enough calls on SubConn 3 to be evaluated.
+	b.scRefs[scs[3]].resetCallsCount()
+
+	refs := []*subConnRef{}
+	for _, sc := range scs {
+		refs = append(refs, b.scRefs[sc])
+	}
+
+	b.detectOutliers()
+
+	if !refs[0].isEjected() {
+		t.Fatalf("SubConn 0 is not ejected, want ejected")
+	}
+	for i, ref := range refs[1:] {
+		if ref.isEjected() {
+			t.Fatalf("SubConn %d is ejected, want not ejected", i+1)
+		}
+	}
+	// A replacement SubConn must be created.
+	if got, want := len(scs), 5; got != want {
+		t.Fatalf("Unexpected number of subConns: %d, want %d", got, want)
+	}
+	for i := 0; i < 20; i++ {
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+		if err != nil || pr.SubConn == scs[0] {
+			t.Fatalf("gcpPicker.Pick returns %v, %v, want: not %v, nil", pr.SubConn, err, scs[0])
+		}
+	}
+
+	// Replacement is ready, the ejection is lifted.
+	b.UpdateSubConnState(scs[4], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	if refs[0].isEjected() || b.scRefs[scs[4]] != refs[0] {
+		t.Fatalf("Replaced SubConn is ejected, want not ejected")
+	}
+	// SubConn 4 has the least streams now.
+	pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+	if want := scs[4]; pr.SubConn != want || err != nil {
+		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", pr.SubConn, err, want)
+	}
+}
+
+func TestOutlierDetectionHealthyChannels(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	// No channel is replaced.
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(4)
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          4,
+					MaxSize:                          4,
+					MaxConcurrentStreamsLowWatermark: 100,
+					OutlierDetection: &pb.OutlierDetectionConfig{
+						// Long interval so that we can trigger the evaluation manually.
+						IntervalMs: 3600000,
+						MinCalls:   10,
+					},
+				},
+			},
+		},
+	})
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+
+	// Channels without errors are never ejected.
+	for round := 0; round < 3; round++ {
+		for _, sc := range scs {
+			for j := 0; j < 10; j++ {
+				b.scRefs[sc].recordCall(time.Millisecond, false, nil)
+			}
+		}
+		b.detectOutliers()
+		for i, sc := range scs {
+			if b.scRefs[sc].isEjected() {
+				t.Fatalf("healthy SubConn %d is ejected in round %d, want not ejected", i, round)
+			}
+		}
+	}
+
+	// A lone evaluated channel with a few errors is not ejected under the
+	// default error_percentage_over_peers.
+	unavailableErr := status.Error(codes.Unavailable, "unavailable")
+	for j := 0; j < 10; j++ {
+		var err error
+		if j == 0 {
+			err = unavailableErr
+		}
+		b.scRefs[scs[0]].recordCall(time.Millisecond, false, err)
+	}
+	b.detectOutliers()
+	if b.scRefs[scs[0]].isEjected() {
+		t.Fatalf("SubConn 0 with 10%% errors and no evaluated peers is ejected, want not ejected")
+	}
+}
+
+func TestCallCountsByCode(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().RemoveSubConn(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).AnyTimes()
+
+	logger := &recordingLogger{}
+	bb := &gcpBalancerBuilder{name: Name, opts: Config{Logger: logger}}
+	b := bb.Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          2,
+					MaxSize:                          2,
+					MaxConcurrentStreamsLowWatermark: 100,
+					OutlierDetection: &pb.OutlierDetectionConfig{
+						// Long interval so that we can trigger the evaluation manually.
+						IntervalMs:               3600000,
+						MinCalls:                 5,
+						ErrorPercentageOverPeers: 30,
+						EjectionTimeMs:           3600000,
+					},
+				},
+			},
+		},
+	})
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+
+	call := func(channel int, err error) {
+		pr, pErr := b.picker.Pick(balancer.PickInfo{Ctx: PinChannel(context.Background(), channel)})
+		if pErr != nil {
+			t.Fatalf("gcpPicker.Pick returned error: %v", pErr)
+		}
+		pr.Done(balancer.DoneInfo{Err: err})
+	}
+	for i := 0; i < 6; i++ {
+		call(0, status.Error(codes.Unavailable, "unavailable"))
+		call(1, nil)
+	}
+	call(0, status.Error(codes.DeadlineExceeded, "deadline exceeded"))
+	call(0, nil)
+	call(1, status.Error(codes.ResourceExhausted, "quota exceeded"))
+	call(1, status.Error(codes.NotFound, "not found"))
+
+	wantCh0 := CallCounts{OK: 1, Unavailable: 6, DeadlineExceeded: 1}
+	wantCh1 := CallCounts{OK: 6, ResourceExhausted: 1, Other: 1}
+	snap := b.affinitySnapshot()
+	if diff := cmp.Diff(wantCh0, snap.Channels[0].Calls); diff != "" {
+		t.Fatalf("channel 0 calls counts mismatch (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(wantCh1, snap.Channels[1].Calls); diff != "" {
+		t.Fatalf("channel 1 calls counts mismatch (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(wantCh0.plus(wantCh1), b.poolMetrics().Calls); diff != "" {
+		t.Fatalf("pool calls counts mismatch (-want, +got):\n%s", diff)
+	}
+	if got, want := b.poolMetrics().Calls.Total(), uint64(16); got != want {
+		t.Fatalf("Calls.Total() = %d, want %d", got, want)
+	}
+
+	// The outlier detection reports the error skew by status code.
+	b.detectOutliers()
+	if !b.scRefList[0].isEjected() {
+		t.Fatalf("channel 0 is not ejected, want ejected")
+	}
+	if got := logger.count("(ok: 1, unavailable: 6, deadline exceeded: 1, resource exhausted: 0, other: 0)"); got != 1 {
+		t.Fatalf("ejection of channel 0 logged %d times with calls counts by code, want 1", got)
+	}
+	if got := logger.count("ejecting channel ch-0 with"); got != 1 {
+		t.Fatalf("ejection of channel 0 logged %d times with its label, want 1", got)
+	}
+}
+
+func TestAddressKey(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		want string
+	}{
+		{"10.0.0.1:443", "10.0.0.1:443"},
+		{"[::ffff:10.0.0.1]:443", "10.0.0.1:443"},
+		{"[2001:db8:0::1]:443", "[2001:db8::1]:443"},
+		{"[2001:DB8::1]:443", "[2001:db8::1]:443"},
+		{"spanner.googleapis.com:443", "spanner.googleapis.com:443"},
+		{"unix:///tmp/grpc.sock", "/tmp/grpc.sock"},
+		{"unix:/tmp/grpc.sock", "/tmp/grpc.sock"},
+		{"/tmp/grpc.sock", "/tmp/grpc.sock"},
+		{"unix-abstract:grpc", "@grpc"},
+		{"@grpc", "@grpc"},
+	} {
+		if got := addressKey(tc.addr); got != tc.want {
+			t.Errorf("addressKey(%q) = %q, want %q", tc.addr, got, tc.want)
+		}
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var connects int32
+	// A slice to store all SubConns created by gcpBalancer's ClientConn.
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		if len(scs) == 0 {
+			newSC.EXPECT().Connect().Do(func() { atomic.AddInt32(&connects, 1) }).AnyTimes()
+			newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		} else {
+			newSC.EXPECT().Connect().Times(1)
+		}
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(2)
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize: 1,
+					MaxSize: 1,
+					ReconnectBackoff: &pb.ReconnectBackoffConfig{
+						BaseDelayMs: 100,
+						Multiplier:  2,
+						MaxAttempts: 2,
+					},
+				},
+			},
+		},
+	})
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	initial := atomic.LoadInt32(&connects)
+
+	// The first reconnect attempt is delayed by base delay.
+	start := time.Now()
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.Idle})
+	if got, want := atomic.LoadInt32(&connects), initial; got != want {
+		t.Fatalf("Connect() called %d times right after Idle, want %d", got, want)
+	}
+	for atomic.LoadInt32(&connects) < initial+1 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("Reconnected in %v, want at least 100ms", elapsed)
+	}
+
+	// The second attempt is delayed twice more.
+	start = time.Now()
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.Connecting})
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.Idle})
+	for atomic.LoadInt32(&connects) < initial+2 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("Reconnected in %v, want at least 200ms", elapsed)
+	}
+
+	// Max attempts exceeded, the channel is replaced.
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.Connecting})
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.Idle})
+	// The replaced SubConn is not reconnected anymore.
+	if got, want := atomic.LoadInt32(&connects), initial+2; got != want {
+		t.Fatalf("Connect() of the replaced SubConn called %d times, want %d", got, want)
+	}
+	if got, want := len(scs), 2; got != want {
+		t.Fatalf("Unexpected number of subConns: %d, want %d", got, want)
+	}
+	if _, ok := b.refreshingScRefs[scs[1]]; !ok {
+		t.Fatalf("The new SubConn is not a replacement")
+	}
+
+	// Ready resets the attempts.
+	mockCC.EXPECT().RemoveSubConn(scs[0]).Times(1)
+	b.UpdateSubConnState(scs[1], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	if got := b.scRefs[scs[1]].reconnectAttempts; got != 0 {
+		t.Fatalf("Reconnect attempts is %d after the replacement, want 0", got)
+	}
+}
+
+func TestAffinitySnapshot(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// A slice to store all SubConns created by gcpBalancer's ClientConn.
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(2)
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize: 2,
+					MaxSize: 2,
+				},
+			},
+		},
+	})
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+
+	conn := &grpc.ClientConn{}
+	if _, err := GetAffinitySnapshot(conn); err != ErrBalancerNotFound {
+		t.Fatalf("GetAffinitySnapshot(conn) returned error: %v, want: %v", err, ErrBalancerNotFound)
+	}
+	// The first call made with the interceptors links the ClientConn.
+	ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{cc: conn})
+	pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: ctx})
+	if err != nil {
+		t.Fatalf("gcpPicker.Pick returned error: %v", err)
+	}
+	// Bind the keys to the channel without the active stream.
+	picked, other := 0, 1
+	if pr.SubConn == scs[1] {
+		picked, other = 1, 0
+	}
+	b.bindSubConn("key1", scs[other])
+	b.bindSubConn(NamespacedAffinityKey("ns", "key1"), scs[other])
+
+	snap, err := GetAffinitySnapshot(conn)
+	if err != nil {
+		t.Fatalf("GetAffinitySnapshot(conn) returned error: %v", err)
+	}
+	wantChannels := []ChannelSnapshot{
+		{Index: 0, Label: "ch-0", State: "READY"},
+		{Index: 1, Label: "ch-1", State: "READY"},
+	}
+	wantChannels[picked].ActiveStreams = 1
+	wantChannels[other].AffinityCount = 2
+	if diff := cmp.Diff(wantChannels, snap.Channels); diff != "" {
+		t.Fatalf("GetAffinitySnapshot(conn) returned unexpected channels (-want, +got):\n%s", diff)
+	}
+	if got, want := len(snap.Keys), 2; got != want {
+		t.Fatalf("GetAffinitySnapshot(conn) returned %d keys, want %d", got, want)
+	}
+	for _, k := range snap.Keys {
+		if k.ChannelIndex != other || k.AffinityCount != 2 || k.LastUsed.IsZero() {
+			t.Fatalf("GetAffinitySnapshot(conn) returned unexpected key: %+v", k)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	AffinityHandler(conn).ServeHTTP(rec, httptest.NewRequest("GET", "/?key=key1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("AffinityHandler returned status %d, want %d", rec.Code, http.StatusOK)
+	}
+	got := &AffinitySnapshot{}
+	if err := json.Unmarshal(rec.Body.Bytes(), got); err != nil {
+		t.Fatalf("AffinityHandler returned invalid JSON: %v", err)
+	}
+	if len(got.Keys) != 1 || got.Keys[0].KeyHash != AffinityKeyHash("key1") {
+		t.Fatalf("AffinityHandler returned keys: %+v, want only key1", got.Keys)
+	}
+
+	// The key of the query is normalized as the keys of the calls.
+	b.opts.NormalizeAffinityKey = NormalizeResourceName
+	rec = httptest.NewRecorder()
+	AffinityHandler(conn).ServeHTTP(rec, httptest.NewRequest("GET", "/?key=key1/", nil))
+	got = &AffinitySnapshot{}
+	if err := json.Unmarshal(rec.Body.Bytes(), got); err != nil {
+		t.Fatalf("AffinityHandler returned invalid JSON: %v", err)
+	}
+	if len(got.Keys) != 1 || got.Keys[0].KeyHash != AffinityKeyHash("key1") {
+		t.Fatalf("AffinityHandler returned keys: %+v, want only key1", got.Keys)
+	}
+
+	// The namespace of the query scopes the key.
+	rec = httptest.NewRecorder()
+	AffinityHandler(conn).ServeHTTP(rec, httptest.NewRequest("GET", "/?key=key1&namespace=ns", nil))
+	got = &AffinitySnapshot{}
+	if err := json.Unmarshal(rec.Body.Bytes(), got); err != nil {
+		t.Fatalf("AffinityHandler returned invalid JSON: %v", err)
+	}
+	if want := AffinityKeyHash(NamespacedAffinityKey("ns", "key1")); len(got.Keys) != 1 || got.Keys[0].KeyHash != want {
+		t.Fatalf("AffinityHandler returned keys: %+v, want only key1 of namespace ns", got.Keys)
+	}
+
+	b.Close()
+	if _, err := GetAffinitySnapshot(conn); err != ErrBalancerNotFound {
+		t.Fatalf("GetAffinitySnapshot(conn) after Close returned error: %v, want: %v", err, ErrBalancerNotFound)
+	}
+
+	// A balancer closed before its first call is never linked.
+	b = newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.Close()
+	b.linkConn(conn)
+	if _, err := GetAffinitySnapshot(conn); err != ErrBalancerNotFound {
+		t.Fatalf("GetAffinitySnapshot(conn) after linking a closed balancer returned error: %v, want: %v", err, ErrBalancerNotFound)
+	}
+}
+
+func TestAffinityPrefixStats(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(2)
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                    2,
+					MaxSize:                    2,
+					AffinityKeyPrefixDelimiter: "/sessions/",
+				},
+			},
+		},
+	})
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+
+	db1, db2 := "projects/p/instances/i/databases/db1", "projects/p/instances/i/databases/db2"
+	b.bindSubConn(db1+"/sessions/s1", scs[0])
+	b.bindSubConn(db1+"/sessions/s2", scs[0])
+	b.bindSubConn(db1+"/sessions/s3", scs[1])
+	b.bindSubConn(db2+"/sessions/s1", scs[1])
+	b.bindSubConn("other", scs[1])
+
+	want := []AffinityPrefixSnapshot{
+		{Prefix: "", BoundKeys: 1, ChannelKeys: []int{0, 1}},
+		{Prefix: db1, BoundKeys: 3, ChannelKeys: []int{2, 1}},
+		{Prefix: db2, BoundKeys: 1, ChannelKeys: []int{0, 1}},
+	}
+	if diff := cmp.Diff(want, b.affinitySnapshot().Prefixes); diff != "" {
+		t.Fatalf("affinitySnapshot() returned unexpected prefixes (-want, +got):\n%s", diff)
+	}
+	wantByPrefix := map[string]int{"": 1, db1: 3, db2: 1}
+	if diff := cmp.Diff(wantByPrefix, b.poolMetrics().BoundKeysByPrefix); diff != "" {
+		t.Fatalf("poolMetrics() returned unexpected keys by prefix (-want, +got):\n%s", diff)
+	}
+}
+
+func TestReplacesSubConnsOnRemovedAddresses(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	defer func(d time.Duration) { drainCheckInterval = d }(drainCheckInterval)
+	drainCheckInterval = time.Millisecond
+
+	addrsAB := []resolver.Address{{Addr: "a"}, {Addr: "b"}}
+	addrsAC := []resolver.Address{{Addr: "a"}, {Addr: "c"}}
+	addrsACD := []resolver.Address{{Addr: "a"}, {Addr: "c"}, {Addr: "d"}}
+
+	// A slice to store all SubConns created by gcpBalancer's ClientConn.
+	scsMu := sync.Mutex{}
+	scs := []*mocks.MockSubConn{}
+	created := make(chan struct{}, 4)
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Eq(addrsAB), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scsMu.Lock()
+		scs = append(scs, newSC)
+		scsMu.Unlock()
+		created <- struct{}{}
+		return newSC, nil
+	}).Times(2)
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{Addresses: addrsAB},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize: 2,
+					MaxSize: 2,
+				},
+			},
+		},
+	})
+	<-created
+	<-created
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+		// Both SubConns are connected to the address to be removed.
+		b.scRefs[sc].setConnInfo(&connInfo{remoteAddr: "b"})
+	}
+
+	// Address "b" removed, ready SubConns must be replaced.
+	mockCC.EXPECT().NewSubConn(gomock.Eq(addrsAC), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Eq(addrsACD)).Times(1)
+		scsMu.Lock()
+		scs = append(scs, newSC)
+		scsMu.Unlock()
+		created <- struct{}{}
+		return newSC, nil
+	}).Times(2)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{Addresses: addrsAC},
+	})
+	for i := 0; i < 2; i++ {
+		select {
+		case <-created:
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for the replacements of the drained connections")
+		}
+	}
+	b.mu.Lock()
+	refreshing := len(b.refreshingScRefs)
+	b.mu.Unlock()
+	if got, want := refreshing, 2; got != want {
+		t.Fatalf("Unexpected number of replacement subConns: %d, want %d", got, want)
+	}
+	if got, want := b.poolMetrics().ResolverChurn, uint64(2); got != want {
+		t.Fatalf("ResolverChurn is %d, want %d", got, want)
+	}
+
+	// Address "d" added, no new replacements.
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{Addresses: addrsACD},
+	})
+	scsMu.Lock()
+	n := len(scs)
+	scsMu.Unlock()
+	if got, want := n, 4; got != want {
+		t.Fatalf("Unexpected number of subConns: %d, want %d", got, want)
+	}
+
+	// Old SubConns are removed once the replacements are ready.
+	mockCC.EXPECT().RemoveSubConn(scs[0]).Times(1)
+	mockCC.EXPECT().RemoveSubConn(scs[1]).Times(1)
+	for _, sc := range scs[2:] {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+	m := b.poolMetrics()
+	if m.Channels != 2 || m.ReadyChannels != 2 {
+		t.Fatalf("poolMetrics() returned %+v, want 2 channels, 2 ready", m)
+	}
+}
+
+func TestDrainsChannelsOfRemovedAddresses(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	defer func(d time.Duration) { drainCheckInterval = d }(drainCheckInterval)
+	drainCheckInterval = time.Millisecond
+
+	addrsAB := []resolver.Address{{Addr: "a"}, {Addr: "b"}}
+	addrsAC := []resolver.Address{{Addr: "a"}, {Addr: "c"}}
+
+	scsMu := sync.Mutex{}
+	scs := []*mocks.MockSubConn{}
+	created := make(chan struct{}, 5)
+	newSubConn := func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scsMu.Lock()
+		scs = append(scs, newSC)
+		scsMu.Unlock()
+		created <- struct{}{}
+		return newSC, nil
+	}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Eq(addrsAB), gomock.Any()).DoAndReturn(newSubConn).Times(3)
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{Addresses: addrsAB},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          3,
+					MaxSize:                          3,
+					MaxConcurrentStreamsLowWatermark: 100,
+				},
+			},
+		},
+	})
+	for i := 0; i < 3; i++ {
+		<-created
+	}
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+	// Channel 0 is connected to the address to be removed, channel 1 to the
+	// remaining address and the address of channel 2 is unknown.
+	b.scRefs[scs[0]].setConnInfo(&connInfo{remoteAddr: "b"})
+	b.scRefs[scs[1]].setConnInfo(&connInfo{remoteAddr: "a"})
+	b.bindSubConn("key1", scs[0])
+	b.bindSubConn("key2", scs[0])
+	ref := b.scRefs[scs[0]]
+	// Simulate an active stream of a bound key on the draining channel.
+	ref.streamsIncr(nil)
+
+	// Channel 2 with the unknown address is updated in place.
+	mockCC.EXPECT().NewSubConn(gomock.Eq(addrsAC), gomock.Any()).DoAndReturn(newSubConn).Times(1)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{Addresses: addrsAC},
+	})
+	if got, want := b.poolMetrics().ResolverChurn, uint64(1); got != want {
+		t.Fatalf("ResolverChurn is %d, want %d", got, want)
+	}
+	if !ref.draining || b.scRefs[scs[1]].draining || b.scRefs[scs[1]].refreshing || b.scRefs[scs[2]].draining || b.scRefs[scs[2]].refreshing {
+		t.Fatalf("unexpected channels after the address removal: draining %v, %v, %v, refreshing %v, %v, want true, false, false, false, false",
+			ref.draining, b.scRefs[scs[1]].draining, b.scRefs[scs[2]].draining, b.scRefs[scs[1]].refreshing, b.scRefs[scs[2]].refreshing)
+	}
+
+	// The bound keys are moved to the surviving channels.
+	for _, key := range []string{"key1", "key2"} {
+		if got := b.affinityMap[key]; got == scs[0] {
+			t.Fatalf("%s is still bound to the draining channel", key)
+		}
+	}
+	if got := ref.getAffinityCnt(); got != 0 {
+		t.Fatalf("affinity count of the draining channel is %d, want 0", got)
+	}
+	for _, r := range b.picker.(*gcpPicker).scRefs {
+		if r == ref {
+			t.Fatalf("picker has the draining channel")
+		}
+	}
+
+	// The connection is replaced once the active stream finishes.
+	ref.streamsDecr(nil)
+	select {
+	case <-created:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the replacement of the drained connection")
+	}
+	scsMu.Lock()
+	newSC := scs[len(scs)-1]
+	scsMu.Unlock()
+	mockCC.EXPECT().RemoveSubConn(scs[0]).Times(1)
+	b.UpdateSubConnState(newSC, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	if ref.subConn != newSC || ref.draining {
+		t.Fatalf("drained channel has SubConn %v and draining %v, want %v and false", ref.subConn, ref.draining, newSC)
+	}
+}
+
+func TestAffinityRebalancing(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(3)
+
+	testMethod := "testBoundMethod"
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          3,
+					MaxSize:                          3,
+					MaxConcurrentStreamsLowWatermark: 100,
+					AffinityRebalancing: &pb.AffinityRebalancingConfig{
+						IntervalMs: 3600000,
+						MaxMoves:   2,
+					},
+				},
+				Method: []*pb.MethodConfig{
+					{
+						Name: []string{testMethod},
+						Affinity: &pb.AffinityConfig{
+							Command:     pb.AffinityConfig_BOUND,
+							AffinityKey: "key",
+						},
+					},
+				},
+			},
+		},
+	})
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+	for i := 0; i < 5; i++ {
+		b.bindSubConn(fmt.Sprintf("key%d", i), scs[0])
+	}
+	b.bindSubConn("key5", scs[1])
+
+	// A call using key0 is active.
+	ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: &testMsg{Key: "key0"}})
+	pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
+	if err != nil || pr.SubConn != scs[0] {
+		t.Fatalf("gcpPicker.Pick for key0 returned %v, %v, want: %v, nil", pr.SubConn, err, scs[0])
+	}
+	if got := atomic.LoadInt32(b.affinityStreams["key0"]); got != 1 {
+		t.Fatalf("key0 has %d active calls, want 1", got)
+	}
+
+	affinityCounts := func() []int32 {
+		counts := []int32{}
+		for _, ref := range b.scRefList {
+			counts = append(counts, ref.getAffinityCnt())
+		}
+		return counts
+	}
+	// Up to 2 keys are moved per interval, to the channel with the least keys.
+	b.rebalanceAffinity()
+	if diff := cmp.Diff([]int32{3, 2, 1}, affinityCounts()); diff != "" {
+		t.Fatalf("unexpected affinity counts after rebalancing (-want, +got):\n%s", diff)
+	}
+	// The key counts within the tolerance are not rebalanced.
+	b.rebalanceAffinity()
+	if diff := cmp.Diff([]int32{2, 2, 2}, affinityCounts()); diff != "" {
+		t.Fatalf("unexpected affinity counts after rebalancing (-want, +got):\n%s", diff)
+	}
+	if got, want := b.poolMetrics().RebalancedKeys, uint64(3); got != want {
+		t.Fatalf("RebalancedKeys is %d, want %d", got, want)
+	}
+	// The key with an active call is not moved.
+	if got := b.affinityMap["key0"]; got != scs[0] {
+		t.Fatalf("key0 with an active call is bound to %v, want %v", got, scs[0])
+	}
+
+	pr.Done(balancer.DoneInfo{})
+	if got := atomic.LoadInt32(b.affinityStreams["key0"]); got != 0 {
+		t.Fatalf("key0 has %d active calls after the call is done, want 0", got)
+	}
+	b.unbindSubConn("key0", 0)
+	if _, ok := b.affinityStreams["key0"]; ok {
+		t.Fatalf("active calls of key0 are tracked after unbind")
+	}
+}
+
+func TestConfigHooks(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// A slice to store all SubConns created by gcpBalancer's ClientConn.
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(3)
+
+	type event struct {
+		name      string
+		key       string
+		channelID int
+	}
+	events := []event{}
+	replaced := make(chan []string, 1)
+	bb := &gcpBalancerBuilder{
+		name: Name,
+		opts: Config{
+			OnBind: func(key string, channelID int) {
+				events = append(events, event{"bind", key, channelID})
+			},
+			OnUnbind: func(key string, channelID int) {
+				events = append(events, event{"unbind", key, channelID})
+			},
+			OnChannelReplaced: func(channelID int, keys []string) {
+				if channelID != 1 {
+					t.Errorf("OnChannelReplaced called for channel %d, want 1", channelID)
+				}
+				replaced <- keys
+			},
+		},
+	}
+	b := bb.Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize: 2,
+					MaxSize: 2,
+				},
+			},
+		},
+	})
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+
+	b.bindSubConn("key1", scs[1])
+	b.bindSubConn("key2", scs[0])
+	b.unbindSubConn("key2", 0)
+	wantEvents := []event{
+		{"bind", "key1", 1},
+		{"bind", "key2", 0},
+		{"unbind", "key2", 0},
+	}
+	if diff := cmp.Diff(wantEvents, events, cmp.AllowUnexported(event{})); diff != "" {
+		t.Fatalf("unexpected hook calls (-want, +got):\n%s", diff)
+	}
+
+	// Replace the connection of channel 1.
+	mockCC.EXPECT().RemoveSubConn(scs[1]).Times(1)
+	b.refresh(b.scRefs[scs[1]])
+	b.UpdateSubConnState(scs[2], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	select {
+	case keys := <-replaced:
+		if diff := cmp.Diff([]string{"key1"}, keys); diff != "" {
+			t.Fatalf("OnChannelReplaced called with unexpected keys (-want, +got):\n%s", diff)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("OnChannelReplaced was not called")
+	}
+	// The key is still bound to the channel with the fresh connection.
+	if got, want := b.affinityMap["key1"], scs[2]; got != want {
+		t.Fatalf("key1 is bound to %v, want %v", got, want)
+	}
+}
+
+func TestBeforeNewSubConnHook(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	gotOpts := []balancer.NewSubConnOptions{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_ []resolver.Address, opts balancer.NewSubConnOptions) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		gotOpts = append(gotOpts, opts)
+		return newSC, nil
+	}).Times(2)
+
+	channelIDs := []int{}
+	veto := errors.New("vetoed")
+	bb := &gcpBalancerBuilder{
+		name: Name,
+		opts: Config{
+			BeforeNewSubConn: func(channelID int, addrs []resolver.Address, opts balancer.NewSubConnOptions) ([]resolver.Address, balancer.NewSubConnOptions, error) {
+				channelIDs = append(channelIDs, channelID)
+				if len(channelIDs) > 2 {
+					return nil, opts, veto
+				}
+				if channelID == 1 {
+					opts.HealthCheckEnabled = false
+				}
+				return addrs, opts, nil
+			},
+		},
+	}
+	b := bb.Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize: 3,
+					MaxSize: 3,
+				},
+			},
+		},
+	})
+	// The third channel is vetoed.
+	if got, want := len(b.scRefList), 2; got != want {
+		t.Fatalf("pool has %d channels, want %d", got, want)
+	}
+	if got, want := []bool{gotOpts[0].HealthCheckEnabled, gotOpts[1].HealthCheckEnabled}, []bool{healthCheckEnabled, false}; !cmp.Equal(got, want) {
+		t.Fatalf("NewSubConn called with health check enabled %v, want %v", got, want)
+	}
+
+	// A vetoed replacement keeps the current connection and may be retried.
+	ref := b.scRefs[scs[0]]
+	b.refresh(ref)
+	if ref.refreshing {
+		t.Fatalf("channel 0 is refreshing after a vetoed replacement")
+	}
+	if got := b.scRefs[scs[0]]; got != ref {
+		t.Fatalf("channel 0 lost its connection after a vetoed replacement")
+	}
+	if diff := cmp.Diff([]int{0, 1, 2, 0}, channelIDs); diff != "" {
+		t.Fatalf("BeforeNewSubConn called for unexpected channels (-want, +got):\n%s", diff)
+	}
+}
+
+func TestDisableHealthCheck(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_ []resolver.Address, opts balancer.NewSubConnOptions) (*mocks.MockSubConn, error) {
+		if opts.HealthCheckEnabled {
+			t.Errorf("NewSubConn called with health check enabled, want disabled")
+		}
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		return newSC, nil
+	}).Times(2)
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{Addresses: []resolver.Address{{Addr: "/tmp/grpc.sock"}}},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:            2,
+					MaxSize:            2,
+					DisableHealthCheck: true,
+				},
+			},
+		},
+	})
+}
+
+// recordingLogger records the info logs.
+type recordingLogger struct {
+	grpclog.LoggerV2
+	mu   sync.Mutex
+	logs []string
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logs = append(l.logs, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Infoln(args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logs = append(l.logs, fmt.Sprintln(args...))
+}
+
+func (l *recordingLogger) V(level int) bool {
+	return true
+}
+
+func (l *recordingLogger) Warningf(format string, args ...interface{}) {
+	l.Infof(format, args...)
+}
+
+// count returns the number of logs containing the substring.
+func (l *recordingLogger) count(substr string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, log := range l.logs {
+		if strings.Contains(log, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFlappingChannelLogs(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	defer func(d time.Duration) { flapLogInterval = d }(flapLogInterval)
+	flapLogInterval = 50 * time.Millisecond
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(1)
+
+	logger := &recordingLogger{LoggerV2: grpclog.NewLoggerV2(ioutil.Discard, ioutil.Discard, ioutil.Discard)}
+	bb := &gcpBalancerBuilder{name: Name, opts: Config{Logger: logger}}
+	b := bb.Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize: 1,
+					MaxSize: 1,
+				},
+			},
+		},
+	})
+	for i := 0; i < 5; i++ {
+		b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+		b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.TransientFailure})
+	}
+
+	if got, want := b.poolMetrics().Flaps, uint64(5); got != want {
+		t.Fatalf("Flaps is %d, want %d", got, want)
+	}
+	if got, want := b.affinitySnapshot().Channels[0].Flaps, uint64(5); got != want {
+		t.Fatalf("Flaps of channel 0 is %d, want %d", got, want)
+	}
+	if got, want := logger.count("channel ch-0 is no longer ready"), 1; got != want {
+		t.Fatalf("got %d logs of the first transition, want %d", got, want)
+	}
+	// State changes of the flapping channel are not logged after its second
+	// transition out of READY.
+	if got, want := logger.count("handle SubConn state change"), 4; got != want {
+		t.Fatalf("got %d logs of state changes, want %d: %q", got, want, logger.logs)
+	}
+
+	summary := fmt.Sprintf("channel ch-0 flapped 5 times in %v", flapLogInterval)
+	for deadline := time.Now().Add(time.Second); logger.count(summary) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("log %q not found in %q", summary, logger.logs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The next transition starts a new interval.
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.TransientFailure})
+	if got, want := logger.count("channel ch-0 is no longer ready"), 2; got != want {
+		t.Fatalf("got %d logs of the first transition, want %d", got, want)
+	}
+}
+
+func TestConfigLogger(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(1)
+
+	logger := &recordingLogger{LoggerV2: grpclog.NewLoggerV2(ioutil.Discard, ioutil.Discard, ioutil.Discard)}
+	label := func(channelID int) string { return fmt.Sprintf("spanner-%d", channelID) }
+	bb := &gcpBalancerBuilder{name: Name, opts: Config{Logger: logger, ChannelLabel: label}}
+	b := bb.Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize: 1,
+					MaxSize: 1,
+				},
+			},
+		},
+	})
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.bindSubConn("key1", scs[0])
+	b.unbindSubConn("key1", 0)
+
+	prefix := fmt.Sprintf("[gcpBalancer %p] ", b)
+	for _, want := range []string{
+		"handle SubConn state change: spanner-0, READY",
+		fmt.Sprintf("bound affinity key %s to channel spanner-0", AffinityKeyHash("key1")),
+		fmt.Sprintf("unbound affinity key %s from channel spanner-0", AffinityKeyHash("key1")),
+	} {
+		found := false
+		for _, l := range logger.logs {
+			if l == prefix+want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("log %q not found in %q", prefix+want, logger.logs)
+		}
+	}
+	if got, want := b.channelStats()[0].Label(), "spanner-0"; got != want {
+		t.Errorf("ChannelStats.Label() returned %q, want %q", got, want)
+	}
+}
+func TestConfigOnEvent(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(1)
+
+	events := make(chan Event, 10)
+	bb := &gcpBalancerBuilder{
+		name: Name,
+		opts: Config{
+			OnEvent: func(e Event) {
+				events <- e
+			},
+		},
+	}
+	b := bb.Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize: 1,
+					MaxSize: 1,
+				},
+			},
+		},
+	})
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.bindSubConn("key1", scs[0])
+	b.unbindSubConn("key1", 0)
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.Connecting})
+	if _, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "method", Ctx: context.Background()}); err != balancer.ErrNoSubConnAvailable {
+		t.Fatalf("gcpPicker.Pick returns %v, want: %v", err, balancer.ErrNoSubConnAvailable)
+	}
+
+	want := []Event{
+		{Type: ChannelCreated, ChannelID: 0},
+		{Type: ChannelReady, ChannelID: 0},
+		{Type: KeyBound, ChannelID: 0, KeyHash: AffinityKeyHash("key1")},
+		{Type: KeyUnbound, ChannelID: 0, KeyHash: AffinityKeyHash("key1")},
+		{Type: ChannelBroken, ChannelID: 0},
+		{Type: PickQueued, ChannelID: -1, Method: "method"},
+	}
+	for _, w := range want {
+		select {
+		case e := <-events:
+			if e.Time.IsZero() {
+				t.Errorf("%v event has no time", e.Type)
+			}
+			e.Time = time.Time{}
+			if diff := cmp.Diff(w, e); diff != "" {
+				t.Errorf("unexpected event (-want, +got):\n%s", diff)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %v event", w.Type)
+		}
+	}
+}
+func TestNewConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{"empty", nil, false},
+		{"valid", []Option{WithMinConns(2), WithMaxConns(8), WithMaxStreamsPerConn(50), WithPickStrategy(pb.ChannelPoolConfig_PICK_LOWEST_LATENCY)}, false},
+		{"min greater than max", []Option{WithMinConns(9), WithMaxConns(8)}, true},
+		{"too many streams", []Option{WithMaxStreamsPerConn(101)}, true},
+		{"unknown pick strategy", []Option{WithPickStrategy(42)}, true},
+	} {
+		if _, err := NewConfig(tc.opts...); (err != nil) != tc.wantErr {
+			t.Errorf("%s: NewConfig returned error %v, want error: %v", tc.name, err, tc.wantErr)
+		}
+	}
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		return newSC, nil
+	}).Times(2)
+
+	cfg, err := NewConfig(WithMinConns(2), WithMaxConns(8))
+	if err != nil {
+		t.Fatalf("NewConfig returned unexpected error: %v", err)
+	}
+	b := NewBalancerBuilder(cfg).Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MaxSize:                          4,
+					MaxConcurrentStreamsLowWatermark: 10,
+				},
+			},
+		},
+	})
+	want := &pb.ChannelPoolConfig{
+		MinSize:                          2,
+		MaxSize:                          8,
+		MaxConcurrentStreamsLowWatermark: 10,
+	}
+	if diff := cmp.Diff(want, b.config().GetChannelPool(), protocmp.Transform()); diff != "" {
+		t.Fatalf("unexpected channel pool config (-want, +got):\n%s", diff)
+	}
+}
+
+func TestDrainChannel(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	defer func(d time.Duration) { drainCheckInterval = d }(drainCheckInterval)
+	drainCheckInterval = time.Millisecond
+
+	scsMu := sync.Mutex{}
+	scs := []*mocks.MockSubConn{}
+	created := make(chan struct{}, 3)
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scsMu.Lock()
+		scs = append(scs, newSC)
+		scsMu.Unlock()
+		created <- struct{}{}
+		return newSC, nil
+	}).Times(3)
+
+	testMethod := "testBoundMethod"
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          2,
+					MaxSize:                          2,
+					MaxConcurrentStreamsLowWatermark: 100,
+				},
+				Method: []*pb.MethodConfig{
+					{
+						Name: []string{testMethod},
+						Affinity: &pb.AffinityConfig{
+							Command:     pb.AffinityConfig_BOUND,
+							AffinityKey: "key",
+						},
+					},
+				},
+			},
+		},
+	})
+	<-created
+	<-created
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+	b.bindSubConn("key1", scs[0])
+	moves := make(chan string, 2)
+	b.opts.OnUnbind = func(key string, channelID int) { moves <- fmt.Sprintf("unbind %s from %d", key, channelID) }
+	b.opts.OnBind = func(key string, channelID int) { moves <- fmt.Sprintf("bind %s to %d", key, channelID) }
+	ref := b.scRefs[scs[0]]
+	// Simulate an active stream on the draining channel.
+	ref.streamsIncr(nil)
+
+	if err := b.drain(0); err != nil {
+		t.Fatalf("drain(0) returned unexpected error: %v", err)
+	}
+	if err := b.drain(2); !errors.Is(err, ErrChannelNotFound) {
+		t.Fatalf("drain(2) of a pool of 2 channels returned %v, want: %v", err, ErrChannelNotFound)
+	}
+	var poolErr *PoolError
+	if err := b.drain(0); !errors.As(err, &poolErr) || poolErr.Err != ErrChannelDraining || poolErr.ChannelIndex != 0 || poolErr.Pool.Channels != 2 {
+		t.Fatalf("drain(0) of a draining channel returned %v, want: a PoolError of %v", err, ErrChannelDraining)
+	}
+
+	// New calls avoid the draining channel.
+	pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "otherMethod", Ctx: context.Background()})
+	if err != nil || pr.SubConn != scs[1] {
+		t.Fatalf("gcpPicker.Pick returned %v, %v, want: %v, nil", pr.SubConn, err, scs[1])
+	}
+	pr.Done(balancer.DoneInfo{})
+
+	// The bound key is moved on its next use.
+	ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: &testMsg{Key: "key1"}})
+	pr, err = b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
+	if err != nil || pr.SubConn != scs[1] {
+		t.Fatalf("gcpPicker.Pick for the bound key returned %v, %v, want: %v, nil", pr.SubConn, err, scs[1])
+	}
+	pr.Done(balancer.DoneInfo{})
+	if got := b.affinityMap["key1"]; got != scs[1] {
+		t.Fatalf("key1 is bound to %v after the draining, want %v", got, scs[1])
+	}
+	if got := ref.getAffinityCnt(); got != 0 {
+		t.Fatalf("affinity count of the draining channel is %d, want 0", got)
+	}
+	// The move is reported with the hooks.
+	for _, want := range []string{"unbind key1 from 0", "bind key1 to 1"} {
+		select {
+		case got := <-moves:
+			if got != want {
+				t.Fatalf("moving key1 called hook %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for the hook %q", want)
+		}
+	}
+
+	// The connection is replaced once the active stream finishes.
+	mockCC.EXPECT().RemoveSubConn(scs[0]).Times(1)
+	ref.streamsDecr(nil)
+	select {
+	case <-created:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the replacement of the drained connection")
+	}
+	scsMu.Lock()
+	newSC := scs[2]
+	scsMu.Unlock()
+	b.UpdateSubConnState(newSC, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	if ref.subConn != newSC || ref.draining {
+		t.Fatalf("drained channel has SubConn %v and draining %v, want %v and false", ref.subConn, ref.draining, newSC)
+	}
+	if got := len(b.picker.(*gcpPicker).scRefs); got != 2 {
+		t.Fatalf("picker has %d ready channels after the draining, want 2", got)
+	}
+}
+
+func TestTransientFailurePickerError(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(1)
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		BalancerConfig: &GCPBalancerConfig{ApiConfig: &pb.ApiConfig{}},
+	})
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.Connecting})
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{
+		ConnectivityState: connectivity.TransientFailure,
+		ConnectionError:   fmt.Errorf("connection refused"),
+	})
+
+	_, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "method", Ctx: context.Background()})
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("gcpPicker.Pick in TransientFailure returned %v, want the last connection error", err)
+	}
+	// Only errors without a status let wait-for-ready calls wait for a new picker.
+	if _, ok := status.FromError(err); ok {
+		t.Fatalf("gcpPicker.Pick in TransientFailure returned a status error: %v", err)
+	}
+}
+
+func TestMethodWildcards(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		return newSC, nil
+	}).AnyTimes()
+
+	exact := &pb.AffinityConfig{Command: pb.AffinityConfig_BIND, AffinityKey: "name"}
+	service := &pb.AffinityConfig{Command: pb.AffinityConfig_BOUND, AffinityKey: "session"}
+	pkg := &pb.AffinityConfig{Command: pb.AffinityConfig_UNBIND, AffinityKey: "name"}
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				Method: []*pb.MethodConfig{
+					{
+						Name:     []string{"/google.spanner.v1.Spanner/CreateSession"},
+						Affinity: exact,
+					},
+					{
+						// The exact method config without affinity takes
+						// precedence over the wildcard with affinity.
+						Name: []string{"/google.spanner.v1.Spanner/BatchCreateSessions"},
+						ChannelPool: &pb.MethodChannelPoolConfig{
+							MaxConcurrentStreamsLowWatermark: 10,
+						},
+					},
+					{
+						Name:     []string{"/google.spanner.v1.Spanner/*"},
+						Affinity: service,
+						ChannelPool: &pb.MethodChannelPoolConfig{
+							MaxConcurrentStreamsLowWatermark: 10,
+						},
+					},
+					{
+						Name:     []string{"google.spanner.*"},
+						Affinity: pkg,
+					},
+				},
+			},
+		},
+	})
+
+	for _, tc := range []struct {
+		method   string
+		want     *pb.AffinityConfig
+		wantPool bool
+	}{
+		{"/google.spanner.v1.Spanner/CreateSession", exact, false},
+		{"/google.spanner.v1.Spanner/BatchCreateSessions", nil, true},
+		{"/google.spanner.v1.Spanner/ExecuteSql", service, true},
+		{"/google.spanner.admin.v1.DatabaseAdmin/GetDatabase", pkg, false},
+		{"/google.firestore.v1.Firestore/GetDocument", nil, false},
+	} {
+		got, ok := b.methodAffinity(tc.method)
+		if ok != (tc.want != nil) || !cmp.Equal(got, tc.want, protocmp.Transform()) {
+			t.Errorf("methodAffinity(%q) = (%v, %v), want %v", tc.method, got, ok, tc.want)
+		}
+		if gotPool := b.methodPool(tc.method) != nil; gotPool != tc.wantPool {
+			t.Errorf("methodPool(%q) != nil is %v, want %v", tc.method, gotPool, tc.wantPool)
+		}
+	}
+}
+
+func TestRegisterWithName(t *testing.T) {
+	const name = "grpc_gcp_register_with_name_test"
+	RegisterWithName(name, Config{MaxConns: 7})
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		return newSC, nil
+	}).AnyTimes()
+
+	maxSize := func(bb balancer.Builder) uint32 {
+		b := bb.Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+		b.UpdateClientConnState(balancer.ClientConnState{
+			BalancerConfig: &GCPBalancerConfig{ApiConfig: &pb.ApiConfig{}},
+		})
+		return b.config().GetChannelPool().GetMaxSize()
+	}
+	named := balancer.Get(name)
+	if named == nil {
+		t.Fatalf("balancer %q is not registered", name)
+	}
+	if got, want := maxSize(named), uint32(7); got != want {
+		t.Errorf("max size of the named balancer is %d, want %d", got, want)
+	}
+	if got, want := maxSize(balancer.Get(Name)), uint32(defaultMaxSize); got != want {
+		t.Errorf("max size of the default balancer is %d, want %d", got, want)
+	}
+
+	opts, err := WithDefaultsForBalancer(name, nil)
+	if err != nil {
+		t.Fatalf("WithDefaultsForBalancer returned unexpected error: %v", err)
+	}
+	if len(opts) == 0 {
+		t.Fatalf("WithDefaultsForBalancer returned no DialOptions")
+	}
+}
+
+func TestWatchChannelStates(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(2)
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          2,
+					MaxSize:                          2,
+					MaxConcurrentStreamsLowWatermark: 100,
+				},
+			},
+		},
+	})
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := b.watchStates(ctx)
+	next := func() ChannelStateUpdate {
+		select {
+		case u := <-updates:
+			u.Time = time.Time{}
+			return u
+		case <-time.After(time.Second):
+			t.Fatalf("no channel state update received")
+		}
+		return ChannelStateUpdate{}
+	}
+	want := []ChannelStateUpdate{
+		{ChannelIndex: 0, From: connectivity.Ready, To: connectivity.Ready, ReadyChannels: 1, Channels: 2, PoolState: connectivity.Ready},
+		{ChannelIndex: 1, From: connectivity.Idle, To: connectivity.Idle, ReadyChannels: 1, Channels: 2, PoolState: connectivity.Ready},
+	}
+	for _, w := range want {
+		if diff := cmp.Diff(w, next()); diff != "" {
+			t.Fatalf("unexpected initial channel state update (-want, +got):\n%s", diff)
+		}
+	}
+
+	b.UpdateSubConnState(scs[1], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.TransientFailure})
+	want = []ChannelStateUpdate{
+		{ChannelIndex: 1, From: connectivity.Idle, To: connectivity.Ready, ReadyChannels: 2, Channels: 2, PoolState: connectivity.Ready},
+		{ChannelIndex: 0, From: connectivity.Ready, To: connectivity.TransientFailure, ReadyChannels: 1, Channels: 2, PoolState: connectivity.Ready},
+	}
+	for _, w := range want {
+		if diff := cmp.Diff(w, next()); diff != "" {
+			t.Fatalf("unexpected channel state update (-want, +got):\n%s", diff)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-updates:
+		if ok {
+			t.Fatalf("unexpected channel state update after the watch was canceled")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("channel state updates are not closed after the watch was canceled")
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	for k, v := range map[string]string{
+		EnvMaxChannels: "2",
+		EnvMaxStreams:  "7",
+		EnvLogLevel:    "fine",
+	} {
+		old, ok := os.LookupEnv(k)
+		os.Setenv(k, v)
+		defer func(k, old string, ok bool) {
+			if ok {
+				os.Setenv(k, old)
+			} else {
+				os.Unsetenv(k)
+			}
+		}(k, old, ok)
+	}
+
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		return newSC, nil
+	}).Times(2)
+
+	// The environment takes precedence over the Config and the ApiConfig.
+	b := NewBalancerBuilder(Config{MinConns: 3, MaxConns: 8, Logger: &recordingLogger{}}).Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MaxSize:                          10,
+					MaxConcurrentStreamsLowWatermark: 50,
+				},
+			},
+		},
+	})
+	cp := b.config().GetChannelPool()
+	if cp.GetMinSize() != 2 || cp.GetMaxSize() != 2 || cp.GetMaxConcurrentStreamsLowWatermark() != 7 {
+		t.Fatalf("channel pool config is %v, want min_size 2, max_size 2 and max_concurrent_streams_low_watermark 7", cp)
+	}
+	if !b.log.V(FINE) || b.log.V(FINEST) {
+		t.Fatalf("V(FINE) = %v, V(FINEST) = %v with %s=fine, want true, false", b.log.V(FINE), b.log.V(FINEST), EnvLogLevel)
+	}
+
+	// Invalid values are ignored.
+	os.Setenv(EnvMaxChannels, "many")
+	os.Setenv(EnvLogLevel, "verbose")
+	bb := NewBalancerBuilder(Config{MaxConns: 8}).(*gcpBalancerBuilder)
+	if bb.opts.MaxConns != 8 || bb.env.logLevelSet {
+		t.Fatalf("builder with invalid environment variables has MaxConns %d and log level set %v, want 8 and false", bb.opts.MaxConns, bb.env.logLevelSet)
+	}
+}
+
+func TestUpdateConfig(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	defer func(d time.Duration) { drainCheckInterval = d }(drainCheckInterval)
+	drainCheckInterval = time.Millisecond
+
+	scs := []*mocks.MockSubConn{}
+	removed := make(chan balancer.SubConn, 3)
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(3)
+	mockCC.EXPECT().RemoveSubConn(gomock.Any()).Do(func(sc balancer.SubConn) {
+		removed <- sc
+	}).Times(2)
+
+	testMethod := "testMethod"
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          2,
+					MaxSize:                          4,
+					MaxConcurrentStreamsLowWatermark: 100,
+					ChannelIdHeader:                  "x-channel-id",
+				},
+				Method: []*pb.MethodConfig{
+					{
+						Name: []string{testMethod},
+						Affinity: &pb.AffinityConfig{
+							Command:     pb.AffinityConfig_BOUND,
+							AffinityKey: "key",
+						},
+					},
+				},
+			},
+		},
+	})
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+
+	// Invalid configs are rejected and keep the config.
+	if err := b.updateConfig(&pb.ApiConfig{ChannelPool: &pb.ChannelPoolConfig{MinSize: 3, MaxSize: 2}}); err == nil {
+		t.Fatalf("updateConfig with min_size above max_size returned nil error, want error")
+	}
+	withMethodPool := &pb.ApiConfig{Method: []*pb.MethodConfig{{
+		Name:        []string{testMethod},
+		ChannelPool: &pb.MethodChannelPoolConfig{MaxConcurrentStreamsLowWatermark: 1},
+	}}}
+	if err := b.updateConfig(withMethodPool); err == nil {
+		t.Fatalf("updateConfig adding a method channel pool returned nil error, want error")
+	}
+	if _, ok := b.methodAffinity(testMethod); !ok {
+		t.Fatalf("method affinity config was replaced by a rejected config")
+	}
+
+	// The pool grows to the new min_size and the method configs are replaced.
+	if err := b.updateConfig(&pb.ApiConfig{
+		ChannelPool: &pb.ChannelPoolConfig{MinSize: 3, MaxSize: 3, MaxConcurrentStreamsLowWatermark: 10},
+	}); err != nil {
+		t.Fatalf("updateConfig returned unexpected error: %v", err)
+	}
+	if got, want := len(b.scRefList), 3; got != want {
+		t.Fatalf("pool has %d channels after raising min_size, want %d", got, want)
+	}
+	b.UpdateSubConnState(scs[2], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	if _, ok := b.methodAffinity(testMethod); ok {
+		t.Fatalf("method affinity config was not replaced")
+	}
+	cp := b.config().GetChannelPool()
+	if got, want := cp.GetMaxConcurrentStreamsLowWatermark(), uint32(10); got != want {
+		t.Fatalf("max_concurrent_streams_low_watermark is %d, want %d", got, want)
+	}
+	if got, want := cp.GetChannelIdHeader(), "x-channel-id"; got != want {
+		t.Fatalf("channel_id_header is %q after the update, want %q kept", got, want)
+	}
+
+	// Channels above the new max_size get no new calls, move their keys and
+	// are removed once drained.
+	b.bindSubConn("key1", scs[2])
+	ref := b.scRefs[scs[1]]
+	ref.streamsIncr(nil)
+	if err := b.updateConfig(&pb.ApiConfig{ChannelPool: &pb.ChannelPoolConfig{MinSize: 1, MaxSize: 1}}); err != nil {
+		t.Fatalf("updateConfig returned unexpected error: %v", err)
+	}
+	if got, want := len(b.scRefList), 1; got != want {
+		t.Fatalf("pool has %d channels after lowering max_size, want %d", got, want)
+	}
+	for i := 0; i < 3; i++ {
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "method", Ctx: context.Background()})
+		if err != nil || pr.SubConn != scs[0] {
+			t.Fatalf("Pick returned %v, %v, want %v, nil", pr.SubConn, err, scs[0])
+		}
+	}
+	b.mu.RLock()
+	bound := b.affinityMap["key1"]
+	b.mu.RUnlock()
+	if bound != scs[0] {
+		t.Fatalf("key1 is bound to %v after its channel was removed, want %v", bound, scs[0])
+	}
+	if sc := <-removed; sc != scs[2] {
+		t.Fatalf("removed %v first, want the drained %v", sc, scs[2])
+	}
+	ref.streamsDecr(nil)
+	if sc := <-removed; sc != scs[1] {
+		t.Fatalf("removed %v, want %v once drained", sc, scs[1])
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if got, want := len(b.scRefs), 1; got != want {
+		t.Fatalf("pool has %d SubConns after the removal, want %d", got, want)
+	}
+}
+
+func TestChannelStats(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(2)
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          2,
+					MaxSize:                          2,
+					MaxConcurrentStreamsLowWatermark: 100,
+				},
+			},
+		},
+	})
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+	stats := b.channelStats()
+	if got, want := len(stats), 2; got != want {
+		t.Fatalf("channelStats() returned %d channels, want %d", got, want)
+	}
+	active := func() int32 {
+		return stats[0].ActiveStreams() + stats[1].ActiveStreams()
+	}
+
+	// Concurrent picks and done callbacks, some called twice, leave no active
+	// streams behind.
+	var wg sync.WaitGroup
+	picks := make(chan balancer.PickResult, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "method", Ctx: context.Background()})
+			if err != nil {
+				t.Errorf("Pick returned unexpected error: %v", err)
+				return
+			}
+			picks <- pr
+		}()
+	}
+	wg.Wait()
+	close(picks)
+	if got, want := active(), int32(100); got != want {
+		t.Fatalf("%d active streams after 100 picks, want %d", got, want)
+	}
+	for pr := range picks {
+		wg.Add(1)
+		go func(pr balancer.PickResult) {
+			defer wg.Done()
+			pr.Done(balancer.DoneInfo{})
+			pr.Done(balancer.DoneInfo{})
+		}(pr)
+	}
+	wg.Wait()
+	if got := active(); got != 0 {
+		t.Fatalf("%d active streams after all calls ended, want 0", got)
+	}
+
+	// A key bound again is counted once.
+	b.bindSubConn("key", scs[1])
+	b.bindSubConn("key", scs[1])
+	if got, want := stats[1].AffinityKeys(), int32(1); got != want {
+		t.Fatalf("channel %d has %d affinity keys, want %d", stats[1].Index(), got, want)
+	}
+	b.unbindSubConn("key", 0)
+	if got := stats[1].AffinityKeys(); got != 0 {
+		t.Fatalf("channel %d has %d affinity keys after unbinding, want 0", stats[1].Index(), got)
+	}
+}
+
+func TestShadowMode(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var state balancer.State
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).Do(func(s balancer.State) {
+		state = s
+	}).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(3)
+
+	bb := &gcpBalancerBuilder{name: Name, opts: Config{ShadowPolicy: "pick_first", Deterministic: true}}
+	b := bb.Build(mockCC, balancer.BuildOptions{})
+	defer b.Close()
+	sb, ok := b.(*shadowBalancer)
+	if !ok {
+		t.Fatalf("Build returned %T in shadow mode, want *shadowBalancer", b)
+	}
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{Addresses: []resolver.Address{{Addr: "localhost:443"}}},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          2,
+					MaxSize:                          2,
+					MaxConcurrentStreamsLowWatermark: 100,
+				},
+			},
+		},
+	})
+	// The pool creates its channels first, then pick_first creates its
+	// connection.
+	gcpSCs, delegateSC := scs[:2], scs[2]
+
+	b.UpdateSubConnState(delegateSC, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	if state.ConnectivityState != connectivity.Ready {
+		t.Fatalf("state is %v after the delegate connected, want %v", state.ConnectivityState, connectivity.Ready)
+	}
+	pick := func() balancer.PickResult {
+		ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: &testMsg{}})
+		pr, err := state.Picker.Pick(balancer.PickInfo{FullMethodName: "method", Ctx: ctx})
+		if err != nil {
+			t.Fatalf("Pick returned error: %v", err)
+		}
+		if pr.SubConn != delegateSC {
+			t.Fatalf("Pick returned SubConn %v, want the SubConn of the delegate %v", pr.SubConn, delegateSC)
+		}
+		return pr
+	}
+	finish := func(pr balancer.PickResult) {
+		if pr.Done != nil {
+			pr.Done(balancer.DoneInfo{})
+		}
+	}
+
+	// The calls are sent while no channel of the pool is ready.
+	finish(pick())
+	if got := sb.gb.PoolMetrics().Shadow; got.Picks != 1 || got.PickErrors != 1 {
+		t.Fatalf("shadow metrics are %+v, want 1 pick and 1 pick error", got)
+	}
+
+	for _, sc := range gcpSCs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+	first, second := pick(), pick()
+	if got := sb.gb.PoolMetrics().ActiveStreams; got != 2 {
+		t.Fatalf("pool has %d active streams, want 2", got)
+	}
+	finish(first)
+	finish(second)
+	want := &ShadowMetrics{
+		Policy:         "pick_first",
+		Picks:          3,
+		PickErrors:     1,
+		PicksByChannel: map[int]uint64{0: 1, 1: 1},
+	}
+	if diff := cmp.Diff(want, sb.gb.PoolMetrics().Shadow); diff != "" {
+		t.Fatalf("shadow metrics have unexpected diff (-want, +got):\n%s", diff)
+	}
+	if got := sb.gb.PoolMetrics().ActiveStreams; got != 0 {
+		t.Fatalf("pool has %d active streams after the calls finished, want 0", got)
+	}
+
+	if _, err := NewConfig(WithShadowPolicy("no_such_policy")); err == nil {
+		t.Fatalf("NewConfig with an unregistered ShadowPolicy returned no error")
+	}
+}
+
+func TestPoolReadiness(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var state connectivity.State
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).Do(func(s balancer.State) {
+		state = s.ConnectivityState
+	}).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(3)
+
+	events := make(chan EventType, 10)
+	cfg, err := NewConfig(WithMinReadyChannels(2), WithDegradedReadyRatio(0.5))
+	if err != nil {
+		t.Fatalf("NewConfig returned error: %v", err)
+	}
+	cfg.OnEvent = func(e Event) {
+		if e.Type == PoolDegraded || e.Type == PoolRecovered {
+			events <- e.Type
+		}
+	}
+	b := (&gcpBalancerBuilder{name: Name, opts: cfg}).Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          3,
+					MaxSize:                          3,
+					MaxConcurrentStreamsLowWatermark: 100,
+				},
+			},
+		},
+	})
+	setState := func(i int, s connectivity.State) {
+		b.UpdateSubConnState(scs[i], balancer.SubConnState{ConnectivityState: s})
+	}
+	wantEvent := func(want EventType) {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("got %v event, want %v", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %v event", want)
+		}
+	}
+
+	// The ClientConn is CONNECTING until 2 channels are READY.
+	setState(0, connectivity.Ready)
+	if state != connectivity.Connecting {
+		t.Fatalf("state is %v with 1 READY channel, want %v", state, connectivity.Connecting)
+	}
+	setState(1, connectivity.Ready)
+	if state != connectivity.Ready {
+		t.Fatalf("state is %v with 2 READY channels, want %v", state, connectivity.Ready)
+	}
+	setState(2, connectivity.Ready)
+
+	// 1 of 3 READY channels is below the ratio.
+	setState(0, connectivity.TransientFailure)
+	setState(1, connectivity.TransientFailure)
+	wantEvent(PoolDegraded)
+	if m := b.PoolMetrics(); !m.Degraded {
+		t.Fatalf("PoolMetrics.Degraded is false with 1 of 3 READY channels")
+	}
+	if state != connectivity.Connecting {
+		t.Fatalf("state is %v with 1 READY channel, want %v", state, connectivity.Connecting)
+	}
+
+	setState(0, connectivity.Ready)
+	wantEvent(PoolRecovered)
+	if m := b.PoolMetrics(); m.Degraded {
+		t.Fatalf("PoolMetrics.Degraded is true with 2 of 3 READY channels")
+	}
+	if state != connectivity.Ready {
+		t.Fatalf("state is %v with 2 READY channels, want %v", state, connectivity.Ready)
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected %v event", e)
+	default:
+	}
+
+	if _, err := NewConfig(WithDegradedReadyRatio(1.5)); err == nil {
+		t.Fatalf("NewConfig with DegradedReadyRatio 1.5 returned no error")
+	}
+}
+
+func TestPoolSaturation(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		return newSC, nil
+	}).Times(2)
+
+	warnings := make(chan SaturationWarning, 10)
+	cfg, err := NewConfig(WithSaturationWarning(50, 100*time.Millisecond, func(w SaturationWarning) {
+		warnings <- w
+	}))
+	if err != nil {
+		t.Fatalf("NewConfig returned error: %v", err)
+	}
+	b := (&gcpBalancerBuilder{name: Name, opts: cfg}).Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          2,
+					MaxSize:                          2,
+					MaxConcurrentStreamsLowWatermark: 2,
+				},
+			},
+		},
+	})
+	ref := b.scRefList[0]
+	for i := 0; i < 3; i++ {
+		ref.streamsIncr(nil)
+	}
+
+	// 3 streams of the 4 the pool serves below the low watermark.
+	m := b.PoolMetrics()
+	if m.SaturationPercent != 75 || m.PeakStreams != 3 {
+		t.Fatalf("PoolMetrics has SaturationPercent %v and PeakStreams %v, want 75 and 3", m.SaturationPercent, m.PeakStreams)
+	}
+	select {
+	case w := <-warnings:
+		want := SaturationWarning{Percent: 75, Window: 100 * time.Millisecond, Channels: 2, MaxSize: 2, SuggestedMaxSize: 3}
+		if w != want {
+			t.Fatalf("got saturation warning %+v, want %+v", w, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no saturation warning")
+	}
+
+	// The high-water mark is kept for a window after the streams finish.
+	for i := 0; i < 3; i++ {
+		ref.streamsDecr(nil)
+	}
+	if got := (ChannelStats{ref: ref}).PeakStreams(); got != 3 {
+		t.Fatalf("PeakStreams() = %v right after the streams finished, want 3", got)
+	}
+	deadline := time.Now().Add(time.Second)
+	for (ChannelStats{ref: ref}).PeakStreams() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("PeakStreams() is not 0 two windows after the streams finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if m := b.PoolMetrics(); m.SaturationPercent != 0 {
+		t.Fatalf("PoolMetrics.SaturationPercent is %v without streams, want 0", m.SaturationPercent)
+	}
+	select {
+	case w := <-warnings:
+		t.Fatalf("unexpected saturation warning %+v", w)
+	default:
+	}
+
+	if _, err := NewConfig(WithSaturationWarning(80, -time.Second, nil)); err == nil {
+		t.Fatalf("NewConfig with negative SaturationWindow returned no error")
+	}
+}
+
+func TestHandover(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		return newSC, nil
+	}).AnyTimes()
+
+	testMethod := "testMethod"
+	ccState := balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          3,
+					MaxSize:                          3,
+					MaxConcurrentStreamsLowWatermark: 100,
+				},
+				Method: []*pb.MethodConfig{
+					{
+						Name: []string{testMethod},
+						Affinity: &pb.AffinityConfig{
+							Command:     pb.AffinityConfig_BOUND,
+							AffinityKey: "key",
+						},
+					},
+				},
+			},
+		},
+	}
+	newBalancer := func(cfg Config) *gcpBalancer {
+		b := (&gcpBalancerBuilder{name: Name, opts: cfg}).Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+		b.UpdateClientConnState(ccState)
+		for _, ref := range b.scRefList {
+			b.UpdateSubConnState(ref.subConn, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+		}
+		return b
+	}
+
+	old := newBalancer(Config{})
+	old.bindSubConn("s1", old.scRefList[2].subConn)
+	old.bindSubConn("s2", old.scRefList[1].subConn)
+	data, err := json.Marshal(old.handoverSnapshot())
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	old.Close()
+
+	snap := &HandoverSnapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		t.Fatalf("json.Unmarshal returned error: %v", err)
+	}
+	wantKeys := map[string]int{AffinityKeyHash("s1"): 2, AffinityKeyHash("s2"): 1}
+	if snap.Channels != 3 || !cmp.Equal(snap.Keys, wantKeys) {
+		t.Fatalf("handover snapshot has %d channels and keys %v, want 3 channels and keys %v", snap.Channels, snap.Keys, wantKeys)
+	}
+
+	b := newBalancer(Config{Handover: snap})
+	defer b.Close()
+	pick := func(key string) balancer.SubConn {
+		t.Helper()
+		ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: &testMsg{Key: key}})
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
+		if err != nil {
+			t.Fatalf("gcpPicker.Pick for %q returns error %v, want nil", key, err)
+		}
+		pr.Done(balancer.DoneInfo{})
+		return pr.SubConn
+	}
+	// The keys of the snapshot use the channels with the same indexes, other
+	// keys use the least busy channel.
+	if got, want := pick("s1"), b.scRefList[2].subConn; got != want {
+		t.Fatalf("s1 picked %v, want channel 2 %v", got, want)
+	}
+	if got, want := pick("s2"), b.scRefList[1].subConn; got != want {
+		t.Fatalf("s2 picked %v, want channel 1 %v", got, want)
+	}
+	if got := b.affinityMap["s1"]; got != b.scRefList[2].subConn {
+		t.Fatalf("s1 is bound to %v, want channel 2", got)
+	}
+	if _, ok := b.affinityMap["s3"]; ok || pick("s3") == nil {
+		t.Fatalf("s3 not in the snapshot is bound or not picked")
+	}
+	if _, ok := b.affinityMap["s3"]; ok {
+		t.Fatalf("s3 not in the snapshot is bound after the pick")
+	}
+	if got := b.poolMetrics().HandoverKeys; got != 2 {
+		t.Fatalf("PoolMetrics.HandoverKeys is %d, want 2", got)
+	}
+}
+
+func TestPoolGrowthCoalescing(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var mu sync.Mutex
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		mu.Lock()
+		scs = append(scs, newSC)
+		mu.Unlock()
+		return newSC, nil
+	}).Times(3)
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          1,
+					MaxSize:                          4,
+					MaxConcurrentStreamsLowWatermark: 1,
+				},
+			},
+		},
+	})
+	setState := func(i int, s connectivity.State) {
+		b.UpdateSubConnState(scs[i], balancer.SubConnState{ConnectivityState: s})
+	}
+	pickConcurrently := func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b.mu.RLock()
+				p := b.picker.(*gcpPicker)
+				b.mu.RUnlock()
+				if _, err := p.getLeastBusySubConnRef(nil); err != balancer.ErrNoSubConnAvailable {
+					t.Errorf("getLeastBusySubConnRef returned %v, want %v", err, balancer.ErrNoSubConnAvailable)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	setState(0, connectivity.Ready)
+	atomic.StoreInt32(&b.scRefList[0].streamsCnt, 1)
+
+	// The busy picks add a single channel and wait for it even if it fails to
+	// connect.
+	pickConcurrently()
+	if got := b.getConnectionPoolSize(); got != 2 {
+		t.Fatalf("pool has %d channels after concurrent picks, want 2", got)
+	}
+	setState(1, connectivity.Connecting)
+	setState(1, connectivity.TransientFailure)
+	pickConcurrently()
+	if got := b.getConnectionPoolSize(); got != 2 {
+		t.Fatalf("pool has %d channels while the added channel is not READY, want 2", got)
+	}
+	if got := b.PoolMetrics().CoalescedGrowths; got != 19 {
+		t.Fatalf("PoolMetrics.CoalescedGrowths is %d, want 19", got)
+	}
+
+	// Once the added channel is READY and busy, the pool grows again.
+	setState(1, connectivity.Ready)
+	atomic.StoreInt32(&b.scRefList[1].streamsCnt, 1)
+	pickConcurrently()
+	if got := b.getConnectionPoolSize(); got != 3 {
+		t.Fatalf("pool has %d channels after the added channel is READY, want 3", got)
+	}
+}
+
+func TestChannelTargets(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	targets := []string{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(addrs []resolver.Address, _ balancer.NewSubConnOptions) (*mocks.MockSubConn, error) {
+		if len(addrs) != 1 {
+			t.Fatalf("NewSubConn got %v addresses, want 1 channel target", addrs)
+		}
+		targets = append(targets, addrs[0].Addr)
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		return newSC, nil
+	}).Times(3)
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{Addresses: []resolver.Address{{Addr: "10.0.0.1:8443"}}},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:        3,
+					MaxSize:        3,
+					ChannelTargets: []string{"spanner-{index}.example.com", "vip-b.example.com:443"},
+				},
+			},
+		},
+	})
+	want := []string{"spanner-0.example.com:8443", "vip-b.example.com:443", "spanner-2.example.com:8443"}
+	if diff := cmp.Diff(want, targets); diff != "" {
+		t.Fatalf("channels dial unexpected targets (-want, +got):\n%s", diff)
+	}
+
+	// The channels keep their targets when the resolved addresses change.
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{Addresses: []resolver.Address{{Addr: "10.0.0.2:8443"}}},
+	})
+}
+
+type fakeMetricWriter struct {
+	points chan []MetricPoint
+}
+
+func (w *fakeMetricWriter) WriteMetrics(ctx context.Context, points []MetricPoint) error {
+	w.points <- points
+	return nil
+}
+
+func TestExportPoolMetrics(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(2)
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{MinSize: 2, MaxSize: 2},
+			},
+		},
+	})
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.bindSubConn("key", scs[0])
+	atomic.StoreInt32(&b.scRefList[0].streamsCnt, 3)
+
+	// A call waiting for a channel is counted once until it is picked.
+	gcpCtx := &gcpContext{}
+	ctx := context.WithValue(context.Background(), gcpKey, gcpCtx)
+	b.trackQueuedPick(ctx, balancer.ErrNoSubConnAvailable)
+	b.trackQueuedPick(ctx, balancer.ErrNoSubConnAvailable)
+
+	w := &fakeMetricWriter{points: make(chan []MetricPoint, 1)}
+	opts := MetricsExportOptions{
+		Writer:   w,
+		Interval: 10 * time.Millisecond,
+		Resource: withDefaultResource(MonitoredResource{Labels: map[string]string{"project_id": "p"}}),
+		Pool:     "spanner",
+	}
+	exportCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.exportMetrics(exportCtx, opts)
+
+	var points []MetricPoint
+	select {
+	case points = <-w.points:
+	case <-time.After(time.Second):
+		t.Fatalf("no metrics pushed")
+	}
+	got := map[string]int64{}
+	for _, p := range points {
+		got[p.Type] = p.Value
+		if p.Labels["pool"] != "spanner" {
+			t.Fatalf("point of %s has %q pool label, want %q", p.Type, p.Labels["pool"], "spanner")
+		}
+		if p.Resource.Type != "generic_task" || p.Resource.Labels["project_id"] != "p" || p.Resource.Labels["location"] != "global" {
+			t.Fatalf("point of %s has unexpected resource %v", p.Type, p.Resource)
+		}
+	}
+	want := map[string]int64{
+		MetricChannels:      2,
+		MetricReadyChannels: 1,
+		MetricActiveStreams: 3,
+		MetricBoundKeys:     1,
+		MetricQueuedPicks:   1,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("pushed unexpected gauges (-want, +got):\n%s", diff)
+	}
+
+	b.trackQueuedPick(ctx, nil)
+	if got := b.PoolMetrics().QueuedPicks; got != 0 {
+		t.Fatalf("PoolMetrics.QueuedPicks is %d after the pick, want 0", got)
+	}
+
+	if err := ExportPoolMetrics(context.Background(), nil, MetricsExportOptions{Writer: w, Interval: time.Second}); err == nil {
+		t.Fatalf("ExportPoolMetrics returned no error for an interval shorter than %v", minExportInterval)
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var mu sync.Mutex
+	scs := []*mocks.MockSubConn{}
+	newSCs := make(chan *mocks.MockSubConn, 10)
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		mu.Lock()
+		scs = append(scs, newSC)
+		mu.Unlock()
+		newSCs <- newSC
+		return newSC, nil
+	}).Times(5)
+	removed := make(chan balancer.SubConn, 10)
+	mockCC.EXPECT().RemoveSubConn(gomock.Any()).Do(func(sc balancer.SubConn) {
+		removed <- sc
+	}).Times(3)
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:          2,
+					MaxSize:          2,
+					ConnectTimeoutMs: 50,
+				},
+			},
+		},
+	})
+	nextSC := func() *mocks.MockSubConn {
+		t.Helper()
+		select {
+		case sc := <-newSCs:
+			return sc
+		case <-time.After(time.Second):
+			t.Fatalf("no SubConn created")
+		}
+		return nil
+	}
+	wantRemoved := func(want balancer.SubConn) {
+		t.Helper()
+		select {
+		case sc := <-removed:
+			if sc != want {
+				t.Fatalf("removed SubConn %p, want %p", sc, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("SubConn %p not removed", want)
+		}
+	}
+	sc0, sc1 := nextSC(), nextSC()
+	b.UpdateSubConnState(sc0, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.UpdateSubConnState(sc1, balancer.SubConnState{ConnectivityState: connectivity.Connecting})
+	b.bindSubConn("key", sc1)
+
+	// The connection of channel 1 is replaced right away with its key.
+	sc2 := nextSC()
+	wantRemoved(sc1)
+	b.UpdateSubConnState(sc2, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.mu.RLock()
+	if b.scRefList[1].subConn != sc2 || b.affinityMap["key"] != sc2 {
+		t.Errorf("channel 1 was not replaced with the new SubConn and its key")
+	}
+	b.mu.RUnlock()
+
+	// A replacement connection not READY in time is replaced with another.
+	b.refresh(b.scRefList[0])
+	sc3 := nextSC()
+	sc4 := nextSC()
+	wantRemoved(sc3)
+	b.UpdateSubConnState(sc4, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	wantRemoved(sc0)
+
+	if got := b.PoolMetrics().ConnectTimeouts; got != 2 {
+		t.Fatalf("PoolMetrics.ConnectTimeouts is %d, want 2", got)
+	}
+}
+
+func TestGoAwayReplacesConnection(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	newSCs := make(chan *mocks.MockSubConn, 10)
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		newSCs <- newSC
+		return newSC, nil
+	}).Times(3)
+	removed := make(chan balancer.SubConn, 10)
+	mockCC.EXPECT().RemoveSubConn(gomock.Any()).Do(func(sc balancer.SubConn) {
+		removed <- sc
+	}).Times(1)
+
+	goAways := make(chan Event, 10)
+	b := (&gcpBalancerBuilder{name: Name, opts: Config{
+		OnEvent: func(e Event) {
+			if e.Type == ChannelGoAway {
+				goAways <- e
+			}
+		},
+	}}).Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          2,
+					MaxSize:                          2,
+					MaxConcurrentStreamsLowWatermark: 100,
+				},
+			},
+		},
+	})
+	sc0, sc1 := <-newSCs, <-newSCs
+	b.UpdateSubConnState(sc0, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.UpdateSubConnState(sc1, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+
+	// A call rejected by the draining connection starts its replacement
+	// before the connection closes.
+	pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+	if err != nil {
+		t.Fatalf("gcpPicker.Pick returns err: %v", err)
+	}
+	goAwaySC, otherSC := sc0, sc1
+	if pr.SubConn == sc1 {
+		goAwaySC, otherSC = sc1, sc0
+	}
+	ref := b.scRefs[goAwaySC]
+	pr.Done(balancer.DoneInfo{Err: status.Error(codes.Unavailable, "the connection is draining")})
+	var replacement *mocks.MockSubConn
+	select {
+	case replacement = <-newSCs:
+	case <-time.After(time.Second):
+		t.Fatalf("no replacement SubConn created after GOAWAY")
+	}
+	select {
+	case e := <-goAways:
+		if e.ChannelID != ref.id {
+			t.Fatalf("ChannelGoAway event of channel %d, want %d", e.ChannelID, ref.id)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no ChannelGoAway event emitted")
+	}
+	// The GOAWAY of a connection is observed once.
+	b.observeGoAway(ref, goAwaySC)
+
+	// The connection closed after the GOAWAY is not a connection loss.
+	b.UpdateSubConnState(goAwaySC, balancer.SubConnState{ConnectivityState: connectivity.Idle})
+	if m := b.PoolMetrics(); m.GoAways != 1 || m.ConnectionLosses != 0 {
+		t.Fatalf("PoolMetrics returns %d GOAWAYs and %d connection losses, want 1 and 0", m.GoAways, m.ConnectionLosses)
+	}
+	b.UpdateSubConnState(replacement, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	select {
+	case sc := <-removed:
+		if sc != goAwaySC {
+			t.Fatalf("removed SubConn %p, want %p", sc, goAwaySC)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("SubConn %p not removed", goAwaySC)
+	}
+	b.mu.RLock()
+	if ref.subConn != replacement || ref.goAway {
+		t.Errorf("channel %d was not replaced with the new SubConn", ref.id)
+	}
+	b.mu.RUnlock()
+
+	// A connection lost without a GOAWAY is told apart.
+	b.UpdateSubConnState(otherSC, balancer.SubConnState{ConnectivityState: connectivity.Idle})
+	if m := b.PoolMetrics(); m.GoAways != 1 || m.ConnectionLosses != 1 {
+		t.Fatalf("PoolMetrics returns %d GOAWAYs and %d connection losses, want 1 and 1", m.GoAways, m.ConnectionLosses)
+	}
+	if n := len(goAways); n != 0 {
+		t.Fatalf("%d more ChannelGoAway events emitted, want 0", n)
+	}
+}
+
+func TestResolverError(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	newSCs := make(chan *mocks.MockSubConn, 10)
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		newSCs <- newSC
+		return newSC, nil
+	}).Times(1)
+	resolveNow := make(chan struct{}, 10)
+	mockCC.EXPECT().ResolveNow(gomock.Any()).Do(func(resolver.ResolveNowOptions) {
+		resolveNow <- struct{}{}
+	}).AnyTimes()
+
+	b := (&gcpBalancerBuilder{name: Name, opts: Config{Deterministic: true}}).Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	cfg := &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          1,
+				MaxConcurrentStreamsLowWatermark: 100,
+			},
+		},
+	}
+	b.UpdateClientConnState(balancer.ClientConnState{BalancerConfig: cfg})
+	sc := <-newSCs
+	pick := func() error {
+		_, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+		return err
+	}
+
+	// Without any resolved address the calls fail with the resolver error.
+	b.ResolverError(errors.New("no such host"))
+	if err := pick(); err == nil || !strings.Contains(err.Error(), "no such host") {
+		t.Fatalf("gcpPicker.Pick returns %v, want the resolver error", err)
+	}
+	if m := b.PoolMetrics(); !m.Degraded || m.ResolverErrors != 1 || m.ResolverError != "no such host" {
+		t.Fatalf("PoolMetrics returns degraded %v, %d resolver errors and %q, want true, 1 and %q", m.Degraded, m.ResolverErrors, m.ResolverError, "no such host")
+	}
+	// Re-resolution is requested after the backoff.
+	select {
+	case <-resolveNow:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("ResolveNow not called after the resolver error")
+	}
+
+	// Resolved addresses clear the error.
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState:  resolver.State{Addresses: []resolver.Address{{Addr: "10.0.0.1:443"}}},
+		BalancerConfig: cfg,
+	})
+	if err := pick(); err != balancer.ErrNoSubConnAvailable {
+		t.Fatalf("gcpPicker.Pick returns %v, want %v", err, balancer.ErrNoSubConnAvailable)
+	}
+	if snap := b.affinitySnapshot(); snap.Degraded || snap.ResolverError != "" {
+		t.Fatalf("affinitySnapshot returns degraded %v and resolver error %q after recovery, want false and empty", snap.Degraded, snap.ResolverError)
+	}
+
+	// The pool keeps serving on its READY channels while the resolver fails.
+	b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.ResolverError(errors.New("timeout"))
+	if err := pick(); err != nil {
+		t.Fatalf("gcpPicker.Pick returns %v while the resolver fails, want nil", err)
+	}
+	if snap := b.affinitySnapshot(); !snap.Degraded || snap.ResolverError != "timeout" {
+		t.Fatalf("affinitySnapshot returns degraded %v and resolver error %q, want true and %q", snap.Degraded, snap.ResolverError, "timeout")
+	}
+}
+
+func TestExpvar(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	target := resolver.Target{URL: url.URL{Scheme: "dns", Path: "/expvar.example.com:443"}}
+	build := func() *gcpBalancer {
+		return (&gcpBalancerBuilder{name: Name, opts: Config{Expvar: true}}).Build(mockCC, balancer.BuildOptions{Target: target}).(*gcpBalancer)
+	}
+	b1, b2 := build(), build()
+	pools, ok := expvar.Get(ExpvarName).(*expvar.Map)
+	if !ok {
+		t.Fatalf("expvar %q is not published", ExpvarName)
+	}
+	for _, name := range []string{"dns:///expvar.example.com:443", "dns:///expvar.example.com:443#2"} {
+		v := pools.Get(name)
+		if v == nil {
+			t.Fatalf("pool %q is not published", name)
+		}
+		m := &PoolMetrics{}
+		if err := json.Unmarshal([]byte(v.String()), m); err != nil {
+			t.Fatalf("pool %q is not PoolMetrics: %v", name, err)
+		}
+	}
+
+	b1.Close()
+	if v := pools.Get("dns:///expvar.example.com:443"); v != nil {
+		t.Fatalf("pool of the closed balancer is still published")
+	}
+	b2.Close()
+
+	// Pools are not published without the option.
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{Target: target}).(*gcpBalancer)
+	defer b.Close()
+	if v := pools.Get("dns:///expvar.example.com:443"); v != nil {
+		t.Fatalf("pool is published without Config.Expvar")
+	}
+}
+
+func TestChannelBudget(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	defer func(d time.Duration) { drainCheckInterval = d }(drainCheckInterval)
+	drainCheckInterval = time.Millisecond
+
+	budget := NewChannelBudget(4)
+	bb := &gcpBalancerBuilder{name: Name, opts: Config{ChannelBudget: budget}}
+	newPool := func(minSize uint32) (*gcpBalancer, chan balancer.SubConn) {
+		scs := &[]*mocks.MockSubConn{}
+		removed := make(chan balancer.SubConn, 4)
+		mockCC := mocks.NewMockClientConn(mockCtrl)
+		mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+		mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+			newSC := mocks.NewMockSubConn(mockCtrl)
+			newSC.EXPECT().Connect().AnyTimes()
+			newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+			*scs = append(*scs, newSC)
+			return newSC, nil
+		}).AnyTimes()
+		mockCC.EXPECT().RemoveSubConn(gomock.Any()).Do(func(sc balancer.SubConn) {
+			removed <- sc
+		}).AnyTimes()
+		b := bb.Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+		b.UpdateClientConnState(balancer.ClientConnState{
+			ResolverState: resolver.State{},
+			BalancerConfig: &GCPBalancerConfig{
+				ApiConfig: &pb.ApiConfig{
+					ChannelPool: &pb.ChannelPoolConfig{
+						MinSize:                          minSize,
+						MaxSize:                          8,
+						MaxConcurrentStreamsLowWatermark: 1,
+					},
+				},
+			},
+		})
+		for _, sc := range *scs {
+			b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+		}
+		return b, removed
+	}
+
+	b1, removed := newPool(6)
+	defer b1.Close()
+	if got, want := b1.getConnectionPoolSize(), 4; got != want {
+		t.Fatalf("first pool has %d channels, want %d capped by the budget", got, want)
+	}
+	if b1.budgetAllowsGrowth() {
+		t.Fatalf("budgetAllowsGrowth() of the first pool is true with the budget exhausted, want false")
+	}
+
+	// The first channel of a pool is always added and the pools above their
+	// fair share shrink for the pools waiting for channels.
+	b2, _ := newPool(2)
+	if got, want := b2.getConnectionPoolSize(), 1; got != want {
+		t.Fatalf("second pool has %d channels, want %d", got, want)
+	}
+	if got, want := budget.InUse(), 5; got != want {
+		t.Fatalf("InUse() is %d, want %d", got, want)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-removed:
+		case <-time.After(time.Second):
+			t.Fatalf("first pool did not remove its channels above the fair share")
+		}
+	}
+	if got, want := b1.getConnectionPoolSize(), 2; got != want {
+		t.Fatalf("first pool has %d channels after yielding, want %d", got, want)
+	}
+	if !b2.budgetAllowsGrowth() {
+		t.Fatalf("budgetAllowsGrowth() of the waiting pool is false, want true")
+	}
+	b2.mu.Lock()
+	b2.enforceMinSize()
+	b2.mu.Unlock()
+	if got, want := b2.getConnectionPoolSize(), 2; got != want {
+		t.Fatalf("second pool has %d channels, want %d", got, want)
+	}
+	if b1.budgetAllowsGrowth() {
+		t.Fatalf("budgetAllowsGrowth() of the first pool at its fair share is true, want false")
+	}
+	if got := b1.poolMetrics().BudgetDenials; got == 0 {
+		t.Fatalf("BudgetDenials of the first pool is 0, want > 0")
+	}
+
+	// The channels of a closed pool return to the budget.
+	b2.Close()
+	if got, want := budget.InUse(), 2; got != want {
+		t.Fatalf("InUse() is %d after Close, want %d", got, want)
+	}
+	if got, want := budget.Pools(), 1; got != want {
+		t.Fatalf("Pools() is %d after Close, want %d", got, want)
+	}
+}
+
+// testChaos drops the picks of the methods and delays every bind.
+type testChaos struct {
+	drop  map[string]bool
+	delay time.Duration
+}
+
+func (c testChaos) DropPick(method string) bool {
+	return c.drop[method]
+}
+
+func (c testChaos) BindDelay(string) time.Duration {
+	return c.delay
+}
+
+func TestChaos(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(2)
+
+	bb := &gcpBalancerBuilder{
+		name: Name,
+		opts: Config{
+			Chaos: testChaos{drop: map[string]bool{"dropped": true}, delay: 50 * time.Millisecond},
+		},
+	}
+	b := bb.Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:         2,
+					MaxSize:         2,
+					FallbackToReady: true,
+				},
+				Method: []*pb.MethodConfig{
+					{
+						Name:     []string{"bind"},
+						Affinity: &pb.AffinityConfig{Command: pb.AffinityConfig_BIND, AffinityKey: "key"},
+					},
+					{
+						Name:     []string{"bound"},
+						Affinity: &pb.AffinityConfig{Command: pb.AffinityConfig_BOUND, AffinityKey: "key"},
+					},
+				},
+			},
+		},
+	})
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+	pick := func(method string, gcpCtx *gcpContext) (balancer.PickResult, error) {
+		ctx := context.WithValue(context.Background(), gcpKey, gcpCtx)
+		b.mu.RLock()
+		picker := b.picker
+		b.mu.RUnlock()
+		return picker.Pick(balancer.PickInfo{FullMethodName: method, Ctx: ctx})
+	}
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		for start := time.Now(); !cond(); time.Sleep(time.Millisecond) {
+			if time.Since(start) > time.Second {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+	boundTo := func(key string) balancer.SubConn {
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+		return b.affinityMap[key]
+	}
+
+	// Picks are dropped.
+	if _, err := pick("dropped", &gcpContext{}); err != ErrChaosDropped {
+		t.Fatalf("pick of a dropped method returned error %v, want %v", err, ErrChaosDropped)
+	}
+
+	// Binds are delayed.
+	pr, err := pick("bind", &gcpContext{replyMsg: &testMsg{Key: "k1"}})
+	if err != nil {
+		t.Fatalf("pick returned error: %v", err)
+	}
+	pr.Done(balancer.DoneInfo{})
+	if sc := boundTo("k1"); sc != nil {
+		t.Fatalf("k1 is bound to %v right after the BIND call, want a delayed bind", sc)
+	}
+	waitFor("the delayed bind of k1", func() bool { return boundTo("k1") == pr.SubConn })
+
+	// A channel forced to TransientFailure falls back to a READY channel.
+	index := b.scRefs[pr.SubConn].id
+	if err := ForceTransientFailure(nil, index, time.Second); err != ErrBalancerNotFound {
+		t.Fatalf("ForceTransientFailure of an unknown ClientConn returned error %v, want %v", err, ErrBalancerNotFound)
+	}
+	if err := b.forceTransientFailure(index, 100*time.Millisecond); err != nil {
+		t.Fatalf("forceTransientFailure returned error: %v", err)
+	}
+	// The actual state changes are held until the channel is released.
+	b.UpdateSubConnState(pr.SubConn, balancer.SubConnState{ConnectivityState: connectivity.Idle})
+	b.UpdateSubConnState(pr.SubConn, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.mu.RLock()
+	state := b.scStates[pr.SubConn]
+	b.mu.RUnlock()
+	if state != connectivity.TransientFailure {
+		t.Fatalf("forced channel is %v, want %v", state, connectivity.TransientFailure)
+	}
+	fallback, err := pick("bound", &gcpContext{reqMsg: &testMsg{Key: "k1"}})
+	if err != nil {
+		t.Fatalf("pick returned error: %v", err)
+	}
+	if fallback.SubConn == pr.SubConn {
+		t.Fatalf("call bound to the forced channel picked it, want a fallback channel")
+	}
+	fallback.Done(balancer.DoneInfo{})
+	waitFor("the release of the forced channel", func() bool {
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+		return b.scStates[pr.SubConn] == connectivity.Ready
+	})
+	if pr, err := pick("bound", &gcpContext{reqMsg: &testMsg{Key: "k1"}}); err != nil || pr.SubConn != boundTo("k1") {
+		t.Fatalf("pick after the release returned %v, %v, want %v, nil", pr.SubConn, err, boundTo("k1"))
+	}
+
+	// Chaos must be enabled explicitly.
+	plain := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer plain.Close()
+	if err := plain.forceTransientFailure(0, time.Second); err == nil {
+		t.Fatal("forceTransientFailure without Chaos returned nil, want error")
+	}
+
+	// The drops of NewChaos are reproducible from the seed.
+	c1, c2 := NewChaos(0.3, 0, 42), NewChaos(0.3, 0, 42)
+	drops := 0
+	for i := 0; i < 1000; i++ {
+		d := c1.DropPick("m")
+		if d != c2.DropPick("m") {
+			t.Fatalf("drop %d differs between Chaos with the same seed", i)
+		}
+		if d {
+			drops++
+		}
+	}
+	if drops < 200 || drops > 400 {
+		t.Fatalf("NewChaos(0.3, ...) dropped %d of 1000 picks, want about 300", drops)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+// bigtableService is the Bigtable data API service.
+const bigtableService = "google.bigtable.v2.Bigtable"
+
+// bigtableTableMethods are the methods of the Bigtable data API called on a
+// table.
+var bigtableTableMethods = []string{
+	"ReadRows",
+	"SampleRowKeys",
+	"MutateRow",
+	"MutateRows",
+	"CheckAndMutateRow",
+	"ReadModifyWriteRow",
+	"GenerateInitialChangeStreamPartitions",
+	"ReadChangeStream",
+}
+
+// BigtableApiConfig returns the ApiConfig binding the calls of the Bigtable
+// data API to the channels by their table and app profile on first use, so
+// the calls routed by Bigtable to the same table and cluster share a
+// connection:
+//
+//	opts, err := grpcgcp.WithDefaults(grpcgcp.BigtableApiConfig())
+//
+// The affinity key is composed of the table_name and app_profile_id of the
+// x-goog-request-params routing header set by the Bigtable client, or of the
+// request message if the header lacks them, see affinity_key_params. A busy
+// channel overflows to the least busy channel with capacity. PingAndWarm,
+// which warms every channel, and the calls not on a table, e.g., with an
+// authorized view, have no affinity. The channel pool config is not set, see
+// MergeApiConfigs to combine the ApiConfig with one setting it.
+func BigtableApiConfig() *pb.ApiConfig {
+	mc := &pb.MethodConfig{
+		Affinity: &pb.AffinityConfig{
+			Command:           pb.AffinityConfig_BIND_ON_FIRST_USE,
+			AffinityKeyParams: []string{"table_name", "app_profile_id"},
+			OverflowWhenBusy:  true,
+		},
+	}
+	for _, m := range bigtableTableMethods {
+		mc.Name = append(mc.Name, "/"+bigtableService+"/"+m)
+	}
+	return &pb.ApiConfig{Method: []*pb.MethodConfig{mc}}
+}
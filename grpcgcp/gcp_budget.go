@@ -0,0 +1,233 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+)
+
+// ChannelBudget caps the channels of the pools of every balancer sharing it,
+// e.g., of the many ClientConns of a process, see Config.ChannelBudget. A pool
+// adds a channel only while the channels of all the pools are below the max,
+// and its first channel always, so every pool can serve. Once the budget is
+// exhausted, a pool with fewer channels than its fair share, the max divided
+// by the number of pools, waits for the pools above their fair share to drain
+// their extra channels, and the pools at or above their fair share do not
+// grow until the waiting pools got their channels. The budget takes
+// precedence over min_size and max_size. The control channels and the
+// replacement connections of the channels are not counted.
+type ChannelBudget struct {
+	max int
+
+	mu sync.Mutex
+	// Channels held by every pool sharing the budget.
+	pools map[*gcpBalancer]int
+	// Channels held by all the pools.
+	used int
+	// Pools below their fair share denied a channel, see allowsLocked.
+	waiting map[*gcpBalancer]bool
+	// Pools asked to shrink to their fair share and not done yet.
+	yielding map[*gcpBalancer]bool
+}
+
+// NewChannelBudget returns a ChannelBudget capping the channels of the pools
+// sharing it at max. It does not cap the channels if max is not positive.
+func NewChannelBudget(max int) *ChannelBudget {
+	return &ChannelBudget{
+		max:      max,
+		pools:    make(map[*gcpBalancer]int),
+		waiting:  make(map[*gcpBalancer]bool),
+		yielding: make(map[*gcpBalancer]bool),
+	}
+}
+
+// Max returns the max number of channels of the pools sharing the budget.
+func (b *ChannelBudget) Max() int {
+	return b.max
+}
+
+// InUse returns the number of channels of the pools sharing the budget,
+// including the channels being drained.
+func (b *ChannelBudget) InUse() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Pools returns the number of pools holding channels of the budget.
+func (b *ChannelBudget) Pools() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pools)
+}
+
+// fairShareLocked returns the number of channels every pool is entitled to.
+// Must be called holding the budget mutex lock.
+func (b *ChannelBudget) fairShareLocked() int {
+	n := len(b.pools)
+	if n == 0 {
+		return b.max
+	}
+	if share := b.max / n; share > 0 {
+		return share
+	}
+	return 1
+}
+
+// allowsLocked reports whether the pool of the gb may add a channel. If not
+// and the pool is below its fair share, the pools above their fair share are
+// asked to shrink.
+// Must be called holding the budget mutex lock.
+func (b *ChannelBudget) allowsLocked(gb *gcpBalancer) bool {
+	held := b.pools[gb]
+	if b.max <= 0 || held == 0 {
+		return true
+	}
+	share := b.fairShareLocked()
+	if b.used < b.max && (len(b.waiting) == 0 || b.waiting[gb] || held < share) {
+		return true
+	}
+	if held < share {
+		b.waiting[gb] = true
+		for pool, n := range b.pools {
+			if n > share && !b.yielding[pool] {
+				b.yielding[pool] = true
+				go b.yield(pool, share)
+			}
+		}
+	}
+	return false
+}
+
+// allows reports whether the pool of the gb may add a channel without
+// acquiring it, e.g., before a pick asks the pool to grow.
+func (b *ChannelBudget) allows(gb *gcpBalancer) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.allowsLocked(gb)
+}
+
+// acquire counts a new channel of the pool of the gb against the budget and
+// reports whether the pool may add it.
+func (b *ChannelBudget) acquire(gb *gcpBalancer) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.allowsLocked(gb) {
+		return false
+	}
+	b.pools[gb]++
+	b.used++
+	delete(b.waiting, gb)
+	return true
+}
+
+// release returns a channel removed from the pool of the gb to the budget.
+func (b *ChannelBudget) release(gb *gcpBalancer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, ok := b.pools[gb]
+	if !ok {
+		return
+	}
+	b.used--
+	if n > 1 {
+		b.pools[gb] = n - 1
+		return
+	}
+	delete(b.pools, gb)
+	delete(b.waiting, gb)
+}
+
+// leave returns every channel of the closed pool of the gb to the budget.
+func (b *ChannelBudget) leave(gb *gcpBalancer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= b.pools[gb]
+	delete(b.pools, gb)
+	delete(b.waiting, gb)
+	delete(b.yielding, gb)
+}
+
+// yield shrinks the pool of the gb to the share for the waiting pools.
+func (b *ChannelBudget) yield(gb *gcpBalancer, share int) {
+	gb.yieldToBudget(share)
+	b.mu.Lock()
+	delete(b.yielding, gb)
+	b.mu.Unlock()
+}
+
+// budgetAllowsGrowth reports whether the Config.ChannelBudget, if any, allows
+// the pool to add a channel.
+func (gb *gcpBalancer) budgetAllowsGrowth() bool {
+	b := gb.opts.ChannelBudget
+	if b == nil || b.allows(gb) {
+		return true
+	}
+	atomic.AddUint64(&gb.counters.budgetDenials, 1)
+	return false
+}
+
+// acquireBudgetLocked counts a new channel against the Config.ChannelBudget,
+// if any, and reports whether the pool may add it.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) acquireBudgetLocked() bool {
+	b := gb.opts.ChannelBudget
+	if b == nil || b.acquire(gb) {
+		return true
+	}
+	atomic.AddUint64(&gb.counters.budgetDenials, 1)
+	if gb.log.V(FINE) {
+		gb.log.Infof("channel budget of %d channels is exhausted, not adding a channel", b.Max())
+	}
+	return false
+}
+
+// releaseBudget returns a channel removed from the pool to the
+// Config.ChannelBudget, if any.
+func (gb *gcpBalancer) releaseBudget() {
+	if b := gb.opts.ChannelBudget; b != nil {
+		b.release(gb)
+	}
+}
+
+// yieldToBudget shrinks the pool to the share of the Config.ChannelBudget for
+// the pools waiting for channels. The extra channels are drained and return
+// to the budget once removed, see shrinkLocked.
+func (gb *gcpBalancer) yieldToBudget(share int) {
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+	select {
+	case <-gb.done:
+		return
+	default:
+	}
+	if len(gb.scRefList) <= share {
+		return
+	}
+	gb.log.Warningf("channel budget is exhausted, shrinking the pool from %d to %d channels", len(gb.scRefList), share)
+	gb.shrinkLocked(share)
+	gb.regeneratePicker()
+	gb.cc.UpdateState(balancer.State{
+		ConnectivityState: gb.state,
+		Picker:            gb.picker,
+	})
+}
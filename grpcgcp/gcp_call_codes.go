@@ -0,0 +1,119 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// callClass is the class of the status code a call finished with.
+type callClass int
+
+const (
+	callOK callClass = iota
+	callUnavailable
+	callDeadlineExceeded
+	callResourceExhausted
+	callOther
+	numCallClasses
+)
+
+// classifyCall returns the class of the status code of the call error.
+func classifyCall(err error) callClass {
+	switch status.Code(err) {
+	case codes.OK:
+		return callOK
+	case codes.Unavailable:
+		return callUnavailable
+	case codes.DeadlineExceeded:
+		return callDeadlineExceeded
+	case codes.ResourceExhausted:
+		return callResourceExhausted
+	}
+	return callOther
+}
+
+// callClassCounts are the numbers of calls by callClass.
+type callClassCounts [numCallClasses]uint64
+
+// add atomically counts a call of the class.
+func (c *callClassCounts) add(class callClass) {
+	atomic.AddUint64(&c[class], 1)
+}
+
+// load atomically reads the counts.
+func (c *callClassCounts) load() CallCounts {
+	return CallCounts{
+		OK:                atomic.LoadUint64(&c[callOK]),
+		Unavailable:       atomic.LoadUint64(&c[callUnavailable]),
+		DeadlineExceeded:  atomic.LoadUint64(&c[callDeadlineExceeded]),
+		ResourceExhausted: atomic.LoadUint64(&c[callResourceExhausted]),
+		Other:             atomic.LoadUint64(&c[callOther]),
+	}
+}
+
+// swap atomically resets the counts and returns their values.
+func (c *callClassCounts) swap() CallCounts {
+	return CallCounts{
+		OK:                atomic.SwapUint64(&c[callOK], 0),
+		Unavailable:       atomic.SwapUint64(&c[callUnavailable], 0),
+		DeadlineExceeded:  atomic.SwapUint64(&c[callDeadlineExceeded], 0),
+		ResourceExhausted: atomic.SwapUint64(&c[callResourceExhausted], 0),
+		Other:             atomic.SwapUint64(&c[callOther], 0),
+	}
+}
+
+// CallCounts are the numbers of calls finished on a channel, or on all
+// channels of the pool, by their status code. A channel with a skewed share of
+// UNAVAILABLE or DEADLINE_EXCEEDED calls compared to its peers is likely
+// connected to an unhealthy backend.
+type CallCounts struct {
+	OK                uint64 `json:"ok"`
+	Unavailable       uint64 `json:"unavailable"`
+	DeadlineExceeded  uint64 `json:"deadlineExceeded"`
+	ResourceExhausted uint64 `json:"resourceExhausted"`
+	// Calls finished with other status codes, e.g., CANCELLED or NOT_FOUND.
+	Other uint64 `json:"other"`
+}
+
+// Total returns the number of all calls.
+func (c CallCounts) Total() uint64 {
+	return c.OK + c.Unavailable + c.DeadlineExceeded + c.ResourceExhausted + c.Other
+}
+
+func (c CallCounts) plus(o CallCounts) CallCounts {
+	return CallCounts{
+		OK:                c.OK + o.OK,
+		Unavailable:       c.Unavailable + o.Unavailable,
+		DeadlineExceeded:  c.DeadlineExceeded + o.DeadlineExceeded,
+		ResourceExhausted: c.ResourceExhausted + o.ResourceExhausted,
+		Other:             c.Other + o.Other,
+	}
+}
+
+func (c CallCounts) String() string {
+	return fmt.Sprintf(
+		"ok: %d, unavailable: %d, deadline exceeded: %d, resource exhausted: %d, other: %d",
+		c.OK, c.Unavailable, c.DeadlineExceeded, c.ResourceExhausted, c.Other,
+	)
+}
@@ -0,0 +1,133 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultCallInitialBackoff = 100 * time.Millisecond
+	defaultCallMaxBackoff     = 5 * time.Second
+	callBackoffJitter         = 0.2
+)
+
+// callAttempt is the call policy of a unary call, set by the picker, and the
+// timeout of the current attempt of the call. The policy is only known once
+// the attempt is picked, so the interceptor makes each attempt with a
+// cancelable context and the picker starts the timeout.
+type callAttempt struct {
+	mu     sync.Mutex
+	policy *pb.CallPolicy
+	// The number of the current attempt, ignoring the timers of previous
+	// attempts firing late.
+	n      uint32
+	start  time.Time
+	cancel context.CancelFunc
+	// Fires at the timeout of the current attempt, nil until the attempt is
+	// picked with a timeout.
+	timer    *time.Timer
+	timedOut bool
+}
+
+// begin starts an attempt of the call canceled by cancel.
+func (a *callAttempt) begin(cancel context.CancelFunc) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.n++
+	a.start = time.Now()
+	a.cancel = cancel
+	a.timer = nil
+	a.timedOut = false
+}
+
+// apply sets the call policy of the call and starts the timeout of the current
+// attempt if not started yet, e.g., by a previous pick of the attempt while
+// no channel was ready.
+func (a *callAttempt) apply(policy *pb.CallPolicy) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.policy = policy
+	if a.cancel == nil || a.timer != nil || policy.GetTimeoutMs() == 0 {
+		return
+	}
+	n, cancel := a.n, a.cancel
+	timeout := time.Duration(policy.GetTimeoutMs()) * time.Millisecond
+	a.timer = time.AfterFunc(timeout-time.Since(a.start), func() {
+		a.mu.Lock()
+		if a.n != n {
+			a.mu.Unlock()
+			return
+		}
+		a.timedOut = true
+		a.mu.Unlock()
+		cancel()
+	})
+}
+
+// end ends the current attempt and returns the call policy and whether the
+// attempt timed out.
+func (a *callAttempt) end() (*pb.CallPolicy, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	a.n++
+	a.cancel = nil
+	return a.policy, a.timedOut
+}
+
+// callRetryDelay returns the backoff before retrying the call after its n-th
+// attempt failed with err, or false if the call must not be retried.
+func callRetryDelay(policy *pb.CallPolicy, n uint32, err error) (time.Duration, bool) {
+	if n >= policy.GetMaxAttempts() {
+		return 0, false
+	}
+	code := status.Code(err)
+	if names := policy.GetRetryableCodes(); len(names) > 0 {
+		if !codeIn(names, code) {
+			return 0, false
+		}
+	} else if code != codes.Unavailable && code != codes.DeadlineExceeded {
+		return 0, false
+	}
+	delay := defaultCallInitialBackoff
+	if ms := policy.GetInitialBackoffMs(); ms > 0 {
+		delay = time.Duration(ms) * time.Millisecond
+	}
+	maxDelay := defaultCallMaxBackoff
+	if ms := policy.GetMaxBackoffMs(); ms > 0 {
+		maxDelay = time.Duration(ms) * time.Millisecond
+	}
+	for i := uint32(1); i < n && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return time.Duration(float64(delay) * (1 + callBackoffJitter*(2*rand.Float64()-1))), true
+}
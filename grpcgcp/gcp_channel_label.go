@@ -0,0 +1,93 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"fmt"
+	"strconv"
+
+	"google.golang.org/grpc/balancer"
+)
+
+// controlChannelLabel is the label of the control channel in the logs, see
+// MethodChannelPoolConfig.control_channel.
+const controlChannelLabel = "control"
+
+// DefaultChannelLabel returns the label of the channel with the index in the
+// pool if Config.ChannelLabel is not set or returns an empty label, e.g.,
+// "ch-0" for the first channel.
+func DefaultChannelLabel(channelID int) string {
+	return "ch-" + strconv.Itoa(channelID)
+}
+
+// newChannelLabelLocked returns the label of the channel added to the pool
+// with the channelID, see Config.ChannelLabel.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) newChannelLabelLocked(channelID int) string {
+	if gb.opts.ChannelLabel != nil {
+		if l := gb.opts.ChannelLabel(channelID); l != "" {
+			return l
+		}
+	}
+	return DefaultChannelLabel(channelID)
+}
+
+// channelLabelLocked returns the label of the channel with the channelID or
+// its default label if the channel is not in the pool.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) channelLabelLocked(channelID int) string {
+	if channelID >= 0 && channelID < len(gb.scRefList) {
+		return gb.scRefList[channelID].label
+	}
+	return DefaultChannelLabel(channelID)
+}
+
+// subConnLabelLocked returns the label of the channel of the SubConn for the
+// logs, for the current and the replacement connection of the channel and
+// for the control channel. A SubConn removed by the balancer, e.g., a
+// replaced connection, is logged with the label of its channel until it shuts
+// down, and an unknown SubConn by its address.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) subConnLabelLocked(sc balancer.SubConn) string {
+	if ref, ok := gb.scRefs[sc]; ok {
+		return ref.label
+	}
+	if ref, ok := gb.refreshingScRefs[sc]; ok {
+		return ref.label + " (replacement)"
+	}
+	if sc != nil && sc == gb.controlSC {
+		return controlChannelLabel
+	}
+	if l, ok := gb.removedLabels[sc]; ok {
+		return l + " (removed)"
+	}
+	return fmt.Sprintf("%p", sc)
+}
+
+// removeSubConnLocked removes the SubConn of the channel of the ref, e.g., a
+// replaced connection. The SubConn is still logged with the label of the
+// channel until it shuts down.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) removeSubConnLocked(sc balancer.SubConn, ref *subConnRef) {
+	if gb.removedLabels == nil {
+		gb.removedLabels = make(map[balancer.SubConn]string)
+	}
+	gb.removedLabels[sc] = ref.label
+	gb.cc.RemoveSubConn(sc)
+}
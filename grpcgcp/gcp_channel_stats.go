@@ -0,0 +1,88 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"google.golang.org/grpc"
+)
+
+// ChannelStats are the live counters of a channel in the pool driving the
+// least-busy picks, e.g., for load reports. The methods are safe for
+// concurrent use and read the counters atomically without locking the pool.
+//
+// ActiveStreams is incremented when a call is picked for the channel and
+// decremented exactly once when the call ends, so it never goes negative and
+// returns to zero once all calls finish. AffinityKeys is incremented when a
+// key not bound yet is bound to the channel and decremented when the key is
+// unbound or moved to another channel. Each counter is exact when read, but
+// two counters are not read together atomically.
+type ChannelStats struct {
+	ref *subConnRef
+}
+
+// Index returns the index of the channel in the pool.
+func (s ChannelStats) Index() int {
+	return s.ref.id
+}
+
+// Label returns the label of the channel, see Config.ChannelLabel, e.g., for
+// the channel label of the metrics recorded from the stats.
+func (s ChannelStats) Label() string {
+	return s.ref.label
+}
+
+// ActiveStreams returns the number of calls in flight on the channel.
+func (s ChannelStats) ActiveStreams() int32 {
+	return s.ref.getStreamsCnt()
+}
+
+// PeakStreams returns the max number of calls in flight on the channel observed
+// over the last one to two Config.SaturationWindow.
+func (s ChannelStats) PeakStreams() int32 {
+	return s.ref.getPeakStreams()
+}
+
+// AffinityKeys returns the number of affinity keys bound to the channel.
+func (s ChannelStats) AffinityKeys() int32 {
+	return s.ref.getAffinityCnt()
+}
+
+// GetChannelStats returns the live counters of the channels in the pool of the
+// ClientConn ordered by index. The returned ChannelStats keep reporting the
+// counters of their channels without calling GetChannelStats again, until a
+// channel is removed from the pool, see UpdateConfig. ErrBalancerNotFound is
+// returned if the ClientConn does not use the grpc_gcp balancer or no call was
+// made on it with the GCP interceptors yet.
+func GetChannelStats(conn *grpc.ClientConn) ([]ChannelStats, error) {
+	gb, err := balancerForConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	return gb.channelStats(), nil
+}
+
+func (gb *gcpBalancer) channelStats() []ChannelStats {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+	stats := make([]ChannelStats, 0, len(gb.scRefList))
+	for _, ref := range gb.scRefList {
+		stats = append(stats, ChannelStats{ref: ref})
+	}
+	return stats
+}
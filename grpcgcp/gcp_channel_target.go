@@ -0,0 +1,69 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// channelTargetIndex is the placeholder of the index of the channel in the
+// ChannelPoolConfig.channel_targets.
+const channelTargetIndex = "{index}"
+
+// defaultTargetPort is the port of the channel targets without a port if no
+// resolved address has a port.
+const defaultTargetPort = "443"
+
+// hasChannelTargets reports whether the channels dial the channel_targets
+// instead of the resolved addresses.
+func (gb *gcpBalancer) hasChannelTargets() bool {
+	return len(gb.config().GetChannelPool().GetChannelTargets()) > 0
+}
+
+// channelAddrsLocked returns the addresses to connect the channel with the
+// channelID to: the address of its channel target if channel_targets are
+// configured or the resolved addresses otherwise.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) channelAddrsLocked(channelID int) []resolver.Address {
+	targets := gb.config().GetChannelPool().GetChannelTargets()
+	if len(targets) == 0 || channelID < 0 {
+		return gb.addrs
+	}
+	target := strings.Replace(targets[channelID%len(targets)], channelTargetIndex, strconv.Itoa(channelID), -1)
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(strings.Trim(target, "[]"), gb.resolvedPortLocked())
+	}
+	return []resolver.Address{{Addr: target}}
+}
+
+// resolvedPortLocked returns the port of the first resolved address with a
+// port or defaultTargetPort.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) resolvedPortLocked() string {
+	for _, a := range gb.addrs {
+		if _, port, err := net.SplitHostPort(a.Addr); err == nil && port != "" {
+			return port
+		}
+	}
+	return defaultTargetPort
+}
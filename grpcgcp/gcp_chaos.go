@@ -0,0 +1,199 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+// ErrChaosDropped fails the calls whose pick was dropped by the Chaos of the
+// Config. The UNAVAILABLE code lets the calls be retried.
+var ErrChaosDropped = status.Error(codes.Unavailable, "grpcgcp: pick dropped by chaos")
+
+// errChaosForced is the connection error of the channels forced to
+// TransientFailure.
+var errChaosForced = errors.New("grpcgcp: TransientFailure forced by chaos")
+
+// Chaos injects faults into the balancer for resilience tests, e.g., to
+// exercise the fallback and rebind paths of a client library
+// deterministically. It is enabled only with Config.Chaos and its methods are
+// called concurrently from the picks and the ends of the calls.
+type Chaos interface {
+	// DropPick reports whether the pick of a call of the method fails with
+	// ErrChaosDropped instead of picking a channel.
+	DropPick(method string) bool
+	// BindDelay returns how long the binding of the affinity key from the
+	// response of a BIND call is delayed. Calls with the key made in the
+	// meantime are picked as if the key was not bound.
+	BindDelay(key string) time.Duration
+}
+
+// NewChaos returns a Chaos dropping the dropFraction of the picks, in [0, 1],
+// and delaying every bind by the bindDelay. The dropped picks are drawn from a
+// pseudo-random sequence of the seed, so a test with the same sequence of
+// picks drops the same picks.
+func NewChaos(dropFraction float64, bindDelay time.Duration, seed int64) Chaos {
+	return &fractionChaos{
+		dropFraction: dropFraction,
+		bindDelay:    bindDelay,
+		rnd:          rand.New(rand.NewSource(seed)),
+	}
+}
+
+type fractionChaos struct {
+	dropFraction float64
+	bindDelay    time.Duration
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (c *fractionChaos) DropPick(string) bool {
+	if c.dropFraction <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rnd.Float64() < c.dropFraction
+}
+
+func (c *fractionChaos) BindDelay(string) time.Duration {
+	return c.bindDelay
+}
+
+// ForceTransientFailure reports the channel with the index in the pool of the
+// ClientConn as TRANSIENT_FAILURE for the duration d, regardless of its
+// connection, e.g., to test the fallback of the calls bound to the channel,
+// see fallback_to_ready. The state changes of the connection in the meantime
+// are applied once the duration elapses. Forcing a channel already forced
+// does nothing. It requires Config.Chaos. ErrBalancerNotFound is returned if
+// the ClientConn does not use the grpc_gcp balancer or no call was made on it
+// with the GCP interceptors yet.
+func ForceTransientFailure(conn *grpc.ClientConn, index int, d time.Duration) error {
+	gb, err := balancerForConn(conn)
+	if err != nil {
+		return err
+	}
+	return gb.forceTransientFailure(index, d)
+}
+
+func (gb *gcpBalancer) forceTransientFailure(index int, d time.Duration) error {
+	if gb.opts.Chaos == nil {
+		return fmt.Errorf("grpcgcp: chaos is not enabled, set Config.Chaos")
+	}
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+	if index < 0 || index >= len(gb.scRefList) {
+		return gb.poolError(ErrChannelNotFound, index, "")
+	}
+	ref := gb.scRefList[index]
+	sc := ref.subConn
+	if _, ok := gb.chaosForced[sc]; ok {
+		return nil
+	}
+	if gb.chaosForced == nil {
+		gb.chaosForced = make(map[balancer.SubConn]connectivity.State)
+	}
+	gb.log.Warningf("forcing channel %s to TRANSIENT_FAILURE for %v", ref.label, d)
+	gb.chaosForced[sc] = gb.scStates[sc]
+	gb.updateSubConnStateLocked(sc, balancer.SubConnState{
+		ConnectivityState: connectivity.TransientFailure,
+		ConnectionError:   errChaosForced,
+	})
+	time.AfterFunc(d, func() {
+		gb.mu.Lock()
+		defer gb.mu.Unlock()
+		select {
+		case <-gb.done:
+			return
+		default:
+		}
+		gb.releaseChaosLocked(sc)
+	})
+	return nil
+}
+
+// chaosHoldLocked records the state change of a SubConn forced to
+// TransientFailure instead of applying it and reports whether it did. The
+// shutdown of the SubConn is applied right away.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) chaosHoldLocked(sc balancer.SubConn, s connectivity.State) bool {
+	if _, ok := gb.chaosForced[sc]; !ok {
+		return false
+	}
+	if s == connectivity.Shutdown {
+		delete(gb.chaosForced, sc)
+		return false
+	}
+	gb.chaosForced[sc] = s
+	return true
+}
+
+// releaseChaosLocked applies the last actual state of the SubConn forced to
+// TransientFailure.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) releaseChaosLocked(sc balancer.SubConn) {
+	s, ok := gb.chaosForced[sc]
+	if !ok {
+		return
+	}
+	delete(gb.chaosForced, sc)
+	if ref := gb.scRefs[sc]; ref != nil {
+		gb.log.Infof("releasing channel %s forced to TRANSIENT_FAILURE, its connection is %v", ref.label, s)
+	}
+	gb.updateSubConnStateLocked(sc, balancer.SubConnState{ConnectivityState: s})
+}
+
+// dropPickByChaos reports whether the Chaos of the Config drops the pick.
+func (gb *gcpBalancer) dropPickByChaos(method string) bool {
+	return gb.opts.Chaos != nil && gb.opts.Chaos.DropPick(method)
+}
+
+// bindAfterChaosDelay binds the affinity key of a BIND call to the SubConn
+// after the delay of the Chaos of the Config, if any.
+func (gb *gcpBalancer) bindAfterChaosDelay(key string, sc balancer.SubConn, ttl time.Duration) {
+	if gb.opts.Chaos == nil {
+		gb.bindSubConnWithTTL(key, sc, ttl)
+		return
+	}
+	d := gb.opts.Chaos.BindDelay(key)
+	if d <= 0 {
+		gb.bindSubConnWithTTL(key, sc, ttl)
+		return
+	}
+	time.AfterFunc(d, func() {
+		gb.mu.RLock()
+		_, ok := gb.scRefs[sc]
+		gb.mu.RUnlock()
+		// The channel may have been removed or its connection replaced.
+		if ok {
+			gb.bindSubConnWithTTL(key, sc, ttl)
+		}
+	})
+}
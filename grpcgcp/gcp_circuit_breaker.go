@@ -0,0 +1,168 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+const (
+	defaultCircuitBreakerInterval = 10 * time.Second
+	defaultCircuitBreakerCooldown = 5 * time.Second
+)
+
+// ErrCircuitOpen is the error of calls failed fast because the circuit breaker
+// of the channel pool is open, see ChannelPoolConfig.circuit_breaker. Use
+// errors.Is to check for it.
+var ErrCircuitOpen = status.Error(codes.Unavailable, "grpcgcp: the circuit breaker of the channel pool is open")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker fails calls fast while the backend of the pool is failing.
+type circuitBreaker struct {
+	cfg      *pb.CircuitBreakerConfig
+	interval time.Duration
+	cooldown time.Duration
+	log      grpclog.LoggerV2
+
+	mu          sync.Mutex
+	state       circuitState
+	consecutive uint32    // Failed calls in a row.
+	calls       uint32    // Calls finished since windowStart.
+	failures    uint32    // Calls failed since windowStart.
+	windowStart time.Time // Start of the error percentage evaluation interval.
+	openUntil   time.Time // When a probe call is allowed in the open state.
+	probing     bool      // Whether the probe call is in flight.
+}
+
+// newCircuitBreaker returns a circuitBreaker or nil if it is not configured.
+func newCircuitBreaker(cfg *pb.CircuitBreakerConfig, log grpclog.LoggerV2) *circuitBreaker {
+	if cfg.GetConsecutiveFailures() == 0 && cfg.GetErrorPercentage() == 0 {
+		return nil
+	}
+	cb := &circuitBreaker{
+		cfg:         cfg,
+		interval:    defaultCircuitBreakerInterval,
+		cooldown:    defaultCircuitBreakerCooldown,
+		log:         log,
+		windowStart: time.Now(),
+	}
+	if cfg.GetIntervalMs() > 0 {
+		cb.interval = time.Duration(cfg.GetIntervalMs()) * time.Millisecond
+	}
+	if cfg.GetCooldownMs() > 0 {
+		cb.cooldown = time.Duration(cfg.GetCooldownMs()) * time.Millisecond
+	}
+	return cb
+}
+
+// allow returns ErrCircuitOpen if a new call must fail fast. Otherwise it
+// reports whether the call is the probe call of the half-open state.
+func (cb *circuitBreaker) allow() (bool, error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case circuitOpen:
+		if time.Now().Before(cb.openUntil) {
+			return false, ErrCircuitOpen
+		}
+		cb.state = circuitHalfOpen
+		cb.probing = true
+		return true, nil
+	case circuitHalfOpen:
+		if cb.probing {
+			return false, ErrCircuitOpen
+		}
+		cb.probing = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// cancelProbe allows another probe call if the probe call was not sent.
+func (cb *circuitBreaker) cancelProbe() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.probing = false
+}
+
+// record accounts the result of a finished call.
+func (cb *circuitBreaker) record(probe bool, err error) {
+	failed := isChannelError(err)
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if probe {
+		cb.probing = false
+		if failed {
+			cb.open()
+		} else {
+			cb.reset()
+		}
+		return
+	}
+	if cb.state != circuitClosed {
+		return
+	}
+	now := time.Now()
+	if now.Sub(cb.windowStart) > cb.interval {
+		cb.calls, cb.failures, cb.windowStart = 0, 0, now
+	}
+	cb.calls++
+	if !failed {
+		cb.consecutive = 0
+		return
+	}
+	cb.failures++
+	cb.consecutive++
+	if n := cb.cfg.GetConsecutiveFailures(); n > 0 && cb.consecutive >= n {
+		cb.open()
+		return
+	}
+	if p := cb.cfg.GetErrorPercentage(); p > 0 && cb.calls >= cb.cfg.GetMinCalls() && cb.failures*100 >= p*cb.calls {
+		cb.open()
+	}
+}
+
+// open opens the circuit breaker for the cooldown.
+// Must be called holding the mutex lock.
+func (cb *circuitBreaker) open() {
+	cb.log.Warningf("circuit breaker opened for %v", cb.cooldown)
+	cb.state = circuitOpen
+	cb.openUntil = time.Now().Add(cb.cooldown)
+}
+
+// reset closes the circuit breaker.
+// Must be called holding the mutex lock.
+func (cb *circuitBreaker) reset() {
+	cb.state = circuitClosed
+	cb.consecutive, cb.calls, cb.failures, cb.windowStart = 0, 0, 0, time.Now()
+}
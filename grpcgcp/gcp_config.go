@@ -0,0 +1,386 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/resolver"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+// Config holds options of the grpc_gcp balancer which cannot be expressed in
+// the ApiConfig, e.g., callbacks, and channel pool options overriding the
+// ApiConfig. Use Register to apply the Config and NewConfig to create a
+// validated Config from functional options.
+//
+// The hooks allow integration with client libraries that keep state tied to
+// the channels, e.g., the Cloud Spanner session pool may recreate sessions
+// when a channel is replaced. Hooks are called without holding the balancer
+// lock but they must not block.
+type Config struct {
+	// OnBind is called after an affinity key is bound to a channel as a result
+	// of a call with the BIND affinity command. It is also called
+	// asynchronously, after OnUnbind, when the balancer moves a key to another
+	// channel, e.g., off a draining channel.
+	OnBind func(key string, channelID int)
+	// OnUnbind is called after an affinity key is unbound from a channel as a
+	// result of a call with the UNBIND affinity command or after the key expired,
+	// see AffinityConfig.ttl_ms, or moved to another channel, see OnBind.
+	OnUnbind func(key string, channelID int)
+	// OnChannelReplaced is called asynchronously after the connection of a
+	// channel was replaced with a new one, e.g., because the channel was
+	// unresponsive. The affinity keys bound to the channel are provided.
+	OnChannelReplaced func(channelID int, keys []string)
+	// OnEvent is called with the lifecycle events of the balancer in the order
+	// they happened. Events are delivered from a separate goroutine and dropped
+	// if the callback falls behind by more than 1024 events.
+	OnEvent func(e Event)
+	// Deterministic makes the channel selection reproducible for tests: channels
+	// with equal load are picked in the order of their index and the reconnect
+	// backoff has no jitter. Affinity keys are always bound synchronously
+	// before the call returns. See the grpcgcptest package for a fake pool.
+	Deterministic bool
+	// Logger receives the logs of the balancer instead of the "grpcgcp" grpclog
+	// component, e.g., an adapter to the logging library of the application.
+	// State transitions and affinity events are logged if V(FINE) is true,
+	// pick decisions if V(FINEST) is true.
+	Logger grpclog.LoggerV2
+	// Observer is notified when the balancers of the builder are built and
+	// closed.
+	Observer BalancerObserver
+	// Descriptors resolves the method descriptors to extract affinity keys
+	// from messages passed through a custom codec as []byte in the protobuf
+	// wire format. Only the fields on the affinity key path are decoded. If
+	// nil, protoregistry.GlobalFiles is used.
+	Descriptors DescriptorResolver
+	// BeforeNewSubConn is called before the connection of a channel is created,
+	// for a new channel and for the replacement connection of a channel, with
+	// the resolved addresses, or the address of the channel target, see
+	// ChannelPoolConfig.channel_targets, and the options of the SubConn. The control
+	// channel, see MethodChannelPoolConfig.control_channel, has the channelID
+	// -1. It returns the addresses and the options to create the SubConn with,
+	// e.g., without the health check for a specific endpoint, or an error to
+	// veto the creation: a vetoed channel is not added to the pool and a vetoed
+	// replacement keeps the current connection of the channel. Unlike the other
+	// hooks it is called holding the balancer lock and must not call back into
+	// the balancer, e.g., GetPoolMetrics.
+	BeforeNewSubConn func(channelID int, addrs []resolver.Address, opts balancer.NewSubConnOptions) ([]resolver.Address, balancer.NewSubConnOptions, error)
+	// ShadowPolicy runs the balancers in shadow mode if set to the name of a
+	// registered balancer, e.g., "round_robin" or "pick_first": the calls are
+	// sent over the connections of the named balancer while the channel pool
+	// picks its channel for every call and accounts the call as if it was sent
+	// over the channel, see ShadowMetrics. The channel pool still connects its
+	// channels, so the connections are doubled.
+	ShadowPolicy string
+	// Chaos injects faults into the balancers for resilience tests, e.g.,
+	// dropped picks and delayed binds, and enables ForceTransientFailure.
+	// It must not be set in production.
+	Chaos Chaos
+	// Handover are the affinity bindings of the channel pool of a previous
+	// ClientConn, see CloseWithHandover. The balancers bind the affinity key
+	// of a call not bound yet to the channel with the index the key was bound
+	// to, so the keys stay on the same channel ordinal across a hot restart.
+	// Set min_size to the number of channels of the snapshot to restore all
+	// the bindings.
+	Handover *HandoverSnapshot
+	// Expvar publishes the PoolMetrics of every balancer under the
+	// "grpcgcp.pools" expvar map by the dial target of the ClientConn, e.g.,
+	// for the environments scraping /debug/vars. The pools of ClientConns
+	// with the same target are published as "<target>#2" and so on. A pool
+	// is removed from the map when its ClientConn is closed.
+	Expvar bool
+	// NormalizeAffinityKey maps the equivalent forms of an affinity key to
+	// one key before it is bound or looked up, e.g., NormalizeResourceName
+	// for the resource names arriving with trailing slashes or differently
+	// cased project IDs. It is applied to the keys located in the messages,
+	// the keys composed of affinity_key_params, the keys of
+	// WithChannelAffinity and the keys passed to ReleaseChannelAffinity,
+	// before they are scoped to their namespace. It must be fast and safe for
+	// concurrent use.
+	NormalizeAffinityKey func(key string) string
+	// ChannelLabel returns the human-readable label of the channel added to
+	// the pool with the channelID, e.g., to tell the channels of several
+	// pools apart in the logs. The label identifies the channel in the logs,
+	// ChannelStats, AffinitySnapshot and the PickDecisions, and is kept when
+	// the connection of the channel is replaced. If nil or if it returns an
+	// empty label, DefaultChannelLabel is used, e.g., "ch-0". Like
+	// BeforeNewSubConn, it is called holding the balancer lock and must not
+	// call back into the balancer.
+	ChannelLabel func(channelID int) string
+	// ChannelBudget caps the channels of the pools of all the balancers
+	// sharing it, e.g., of every ClientConn of the process dialed with the
+	// builder of the Config, see NewChannelBudget. The pools above their fair
+	// share of a tight budget shrink for the pools waiting for channels.
+	ChannelBudget *ChannelBudget
+
+	// The channel pool options below override the respective options of the
+	// ChannelPoolConfig from the ApiConfig if not zero.
+
+	// MinConns is the minimum number of channels in the pool, see min_size.
+	MinConns uint32
+	// MaxConns is the max number of channels in the pool, see max_size.
+	MaxConns uint32
+	// MaxStreamsPerConn is the low watermark of concurrent streams in a channel,
+	// see max_concurrent_streams_low_watermark.
+	MaxStreamsPerConn uint32
+	// PickStrategy for calls not bound by an affinity key, see pick_strategy.
+	PickStrategy pb.ChannelPoolConfig_PickStrategy
+
+	// The options below configure how the states of the channels aggregate
+	// into the state of the ClientConn, e.g., to gate readiness probes on the
+	// capacity of the pool.
+
+	// MinReadyChannels is the number of READY channels required for the READY
+	// state of the ClientConn, capped at min_size. The state is CONNECTING
+	// while fewer channels are READY, but calls are still sent over the READY
+	// channels. If 0, any READY channel makes the ClientConn READY.
+	MinReadyChannels uint32
+	// DegradedReadyRatio reports the pool as degraded once fewer than this
+	// ratio of its channels are READY, e.g., 0.5, with the PoolDegraded and
+	// PoolRecovered events and PoolMetrics.Degraded. The pool is not reported
+	// as degraded before it first reaches the ratio. Disabled if 0.
+	DegradedReadyRatio float64
+
+	// The options below configure the tracking of the saturation of the pool,
+	// i.e., its active streams in percent of the streams it serves below the
+	// low watermark at max_size, see PoolMetrics.SaturationPercent.
+
+	// SaturationWindow is the window of the high-water marks of the
+	// concurrent streams of the channels, see ChannelStats.PeakStreams, and
+	// the time the saturation must stay at or above SaturationWarningPercent
+	// for a warning. Defaults to 1 minute.
+	SaturationWindow time.Duration
+	// SaturationWarningPercent is the saturation of the pool, e.g., 80,
+	// sustained for a SaturationWindow the balancer logs a warning and calls
+	// OnSaturation for. The warning is repeated only after the saturation
+	// dropped below. Disabled if 0.
+	SaturationWarningPercent float64
+	// OnSaturation is called with the sustained saturation of the pool, see
+	// SaturationWarningPercent, e.g., to alert that max_size should be raised.
+	OnSaturation func(w SaturationWarning)
+}
+
+// Option is a functional option of the Config, see NewConfig.
+type Option func(*Config)
+
+// NewConfig returns a Config with the options applied and validated.
+//
+//	cfg, err := grpcgcp.NewConfig(grpcgcp.WithMinConns(2), grpcgcp.WithMaxConns(8))
+//	if err != nil {
+//		// Handle error.
+//	}
+//	grpcgcp.Register(cfg)
+func NewConfig(opts ...Option) (Config, error) {
+	cfg := Config{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg, cfg.validate()
+}
+
+// WithMinConns sets the minimum number of channels in the pool.
+func WithMinConns(n uint32) Option {
+	return func(c *Config) { c.MinConns = n }
+}
+
+// WithMaxConns sets the max number of channels in the pool.
+func WithMaxConns(n uint32) Option {
+	return func(c *Config) { c.MaxConns = n }
+}
+
+// WithMaxStreamsPerConn sets the low watermark of concurrent streams in a
+// channel. The valid range is [1, 100].
+func WithMaxStreamsPerConn(n uint32) Option {
+	return func(c *Config) { c.MaxStreamsPerConn = n }
+}
+
+// WithPickStrategy sets the strategy for picking a channel for calls not bound
+// by an affinity key.
+func WithPickStrategy(s pb.ChannelPoolConfig_PickStrategy) Option {
+	return func(c *Config) { c.PickStrategy = s }
+}
+
+// WithMinReadyChannels sets the number of READY channels required for the
+// READY state of the ClientConn.
+func WithMinReadyChannels(n uint32) Option {
+	return func(c *Config) { c.MinReadyChannels = n }
+}
+
+// WithDegradedReadyRatio sets the ratio of READY channels below which the
+// pool is reported as degraded.
+func WithDegradedReadyRatio(r float64) Option {
+	return func(c *Config) { c.DegradedReadyRatio = r }
+}
+
+// WithSaturationWarning calls the fn once the saturation of the pool stays at
+// or above the percent for the window.
+func WithSaturationWarning(percent float64, window time.Duration, fn func(w SaturationWarning)) Option {
+	return func(c *Config) {
+		c.SaturationWarningPercent = percent
+		c.SaturationWindow = window
+		c.OnSaturation = fn
+	}
+}
+
+// WithShadowPolicy runs the balancers in shadow mode with the calls sent over
+// the connections of the named balancer.
+func WithShadowPolicy(name string) Option {
+	return func(c *Config) { c.ShadowPolicy = name }
+}
+
+// WithChaos injects the faults of the Chaos into the balancers, for
+// resilience tests only.
+func WithChaos(chaos Chaos) Option {
+	return func(c *Config) { c.Chaos = chaos }
+}
+
+// WithHandover restores the affinity bindings of the handover snapshot of a
+// previous ClientConn.
+func WithHandover(snap *HandoverSnapshot) Option {
+	return func(c *Config) { c.Handover = snap }
+}
+
+// WithExpvar publishes the PoolMetrics of the balancers under expvar, see
+// Config.Expvar.
+func WithExpvar() Option {
+	return func(c *Config) { c.Expvar = true }
+}
+
+// WithAffinityKeyNormalizer sets the normalizer of the affinity keys, see
+// Config.NormalizeAffinityKey.
+func WithAffinityKeyNormalizer(fn func(key string) string) Option {
+	return func(c *Config) { c.NormalizeAffinityKey = fn }
+}
+
+// WithChannelLabel sets the labels of the channels, see Config.ChannelLabel.
+func WithChannelLabel(fn func(channelID int) string) Option {
+	return func(c *Config) { c.ChannelLabel = fn }
+}
+
+// WithChannelBudget caps the channels of the pools sharing the budget, see
+// Config.ChannelBudget.
+func WithChannelBudget(b *ChannelBudget) Option {
+	return func(c *Config) { c.ChannelBudget = b }
+}
+
+// WithLogger sets the logger of the balancer.
+func WithLogger(l grpclog.LoggerV2) Option {
+	return func(c *Config) { c.Logger = l }
+}
+
+func (c Config) validate() error {
+	if c.MaxConns > 0 && c.MinConns > c.MaxConns {
+		return fmt.Errorf("grpcgcp: MinConns (%d) is greater than MaxConns (%d)", c.MinConns, c.MaxConns)
+	}
+	if c.MaxStreamsPerConn > 100 {
+		return fmt.Errorf("grpcgcp: MaxStreamsPerConn (%d) is out of the [1, 100] range", c.MaxStreamsPerConn)
+	}
+	if _, ok := pb.ChannelPoolConfig_PickStrategy_name[int32(c.PickStrategy)]; !ok {
+		return fmt.Errorf("grpcgcp: unknown PickStrategy %v", c.PickStrategy)
+	}
+	if c.DegradedReadyRatio < 0 || c.DegradedReadyRatio > 1 {
+		return fmt.Errorf("grpcgcp: DegradedReadyRatio (%v) is out of the [0, 1] range", c.DegradedReadyRatio)
+	}
+	if c.SaturationWindow < 0 {
+		return fmt.Errorf("grpcgcp: SaturationWindow (%v) is negative", c.SaturationWindow)
+	}
+	if c.SaturationWarningPercent < 0 {
+		return fmt.Errorf("grpcgcp: SaturationWarningPercent (%v) is negative", c.SaturationWarningPercent)
+	}
+	if c.ShadowPolicy != "" && balancer.Get(c.ShadowPolicy) == nil {
+		return fmt.Errorf("grpcgcp: ShadowPolicy %q is not a registered balancer", c.ShadowPolicy)
+	}
+	return nil
+}
+
+// applyTo overrides the channel pool options of the ChannelPoolConfig with the
+// non-zero options of the Config.
+func (c Config) applyTo(cp *pb.ChannelPoolConfig) {
+	if c.MinConns > 0 {
+		cp.MinSize = c.MinConns
+	}
+	if c.MaxConns > 0 {
+		cp.MaxSize = c.MaxConns
+	}
+	if c.MaxStreamsPerConn > 0 {
+		cp.MaxConcurrentStreamsLowWatermark = c.MaxStreamsPerConn
+	}
+	if c.PickStrategy != pb.ChannelPoolConfig_PICK_STRATEGY_UNSPECIFIED {
+		cp.PickStrategy = c.PickStrategy
+	}
+}
+
+// Register registers the grpc_gcp balancer with the provided Config replacing
+// the default grpc_gcp balancer.
+//
+// NOTE: this function must only be called during initialization time (i.e. in
+// an init() function), and is not thread-safe.
+func Register(cfg Config) {
+	balancer.Register(NewBalancerBuilder(cfg))
+}
+
+// RegisterWithName registers the grpc_gcp balancer with the provided Config
+// under the name, keeping the default grpc_gcp balancer intact. This allows
+// clients in the same process, e.g., for different Google Cloud APIs, to use
+// differently configured balancers. Use WithDefaultsForBalancer to dial with
+// the named balancer.
+//
+// NOTE: this function must only be called during initialization time (i.e. in
+// an init() function), and is not thread-safe.
+func RegisterWithName(name string, cfg Config) {
+	balancer.Register(newBuilderFromEnv(name, cfg))
+}
+
+// NewBalancerBuilder returns a builder of the grpc_gcp balancer with the
+// provided Config without registering it, e.g., to build the balancer in tests.
+func NewBalancerBuilder(cfg Config) balancer.Builder {
+	return newBuilderFromEnv(Name, cfg)
+}
+
+func (gb *gcpBalancer) onBind(key string, channelID int) {
+	if gb.opts.OnBind != nil {
+		gb.opts.OnBind(key, channelID)
+	}
+}
+
+func (gb *gcpBalancer) onUnbind(key string, channelID int) {
+	if gb.opts.OnUnbind != nil {
+		gb.opts.OnUnbind(key, channelID)
+	}
+}
+
+// onChannelReplaced notifies about the replaced channel of the scRef.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) onChannelReplaced(scRef *subConnRef) {
+	if gb.opts.OnChannelReplaced == nil {
+		return
+	}
+	keys := []string{}
+	for k, sc := range gb.affinityMap {
+		if sc == scRef.subConn {
+			keys = append(keys, k)
+		}
+	}
+	go gb.opts.OnChannelReplaced(scRef.id, keys)
+}
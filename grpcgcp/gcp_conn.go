@@ -0,0 +1,123 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// ErrBalancerNotFound is returned when there is no grpc_gcp balancer known for
+// a ClientConn. The balancer of a ClientConn is known after the first call on
+// the ClientConn made with the GCP interceptors.
+var ErrBalancerNotFound = errors.New("grpcgcp: no grpc_gcp balancer found for the ClientConn")
+
+// balancers maps *grpc.ClientConn to its *gcpBalancer.
+var balancers sync.Map
+
+// linkConn associates the balancer with the ClientConn it balances if not yet
+// associated. The ClientConn is not available to the balancer on build, so
+// the association happens when the picker sees a call with the ClientConn
+// provided by the GCP interceptors.
+func (gb *gcpBalancer) linkConn(conn *grpc.ClientConn) {
+	if conn == nil || atomic.LoadUint32(&gb.linked) == 1 {
+		return
+	}
+	gb.connMu.Lock()
+	defer gb.connMu.Unlock()
+	// A closed balancer is never linked.
+	if gb.conn != nil || gb.unlinked {
+		return
+	}
+	gb.conn = conn
+	balancers.Store(conn, gb)
+	atomic.StoreUint32(&gb.linked, 1)
+}
+
+// unlinkConn removes the association of the balancer with its ClientConn and
+// prevents any later association.
+func (gb *gcpBalancer) unlinkConn() {
+	gb.connMu.Lock()
+	defer gb.connMu.Unlock()
+	gb.unlinked = true
+	atomic.StoreUint32(&gb.linked, 1)
+	if gb.conn == nil {
+		return
+	}
+	// Keep the association of a newer balancer if the ClientConn switched
+	// balancers.
+	if linked, ok := balancers.Load(gb.conn); ok && linked == gb {
+		balancers.Delete(gb.conn)
+	}
+}
+
+// balancerForConn returns the grpc_gcp balancer of the ClientConn.
+func balancerForConn(conn *grpc.ClientConn) (*gcpBalancer, error) {
+	if gb, ok := balancers.Load(conn); ok {
+		return gb.(*gcpBalancer), nil
+	}
+	return nil, ErrBalancerNotFound
+}
+
+// poolLinkMethod is the method of the call linking the balancer to its
+// ClientConn, see linkPool. The call fails on pick and is never sent.
+const poolLinkMethod = "/grpc.gcp.PoolLink/Link"
+
+// errPoolLinked fails the call linking the balancer to its ClientConn.
+var errPoolLinked = status.Error(codes.Canceled, "grpcgcp: pool link call")
+
+type poolLinkKey struct{}
+
+// linkPool makes the call linking the balancer to the ClientConn, once the
+// balancer has a picker, and returns the balancer.
+func linkPool(ctx context.Context, conn *grpc.ClientConn) (*gcpBalancer, error) {
+	// The call always fails, the balancer is linked if it picked the call.
+	conn.Invoke(context.WithValue(ctx, poolLinkKey{}, true), poolLinkMethod, &emptypb.Empty{}, &emptypb.Empty{}, grpc.WaitForReady(true))
+	if gb, err := balancerForConn(conn); err == nil {
+		return gb, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrBalancerNotFound
+}
+
+// isPoolLink reports whether the call is the call linking the balancer to its
+// ClientConn, see linkPool.
+func isPoolLink(ctx context.Context) bool {
+	link, _ := ctx.Value(poolLinkKey{}).(bool)
+	return link
+}
+
+// pickPoolLink links the balancer to the ClientConn of the call and fails the
+// call so that it is not sent.
+func (p *gcpPicker) pickPoolLink(info balancer.PickInfo) (balancer.PickResult, error) {
+	if gcpCtx, ok := info.Ctx.Value(gcpKey).(*gcpContext); ok {
+		p.gb.linkConn(gcpCtx.cc)
+	}
+	return balancer.PickResult{}, errPoolLinked
+}
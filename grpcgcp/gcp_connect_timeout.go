@@ -0,0 +1,110 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+)
+
+// armConnectTimeoutLocked abandons the new SubConn sc of the ref if it is not
+// READY within the connect_timeout_ms, see connectTimedOut.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) armConnectTimeoutLocked(ref *subConnRef, sc balancer.SubConn) {
+	timeout := time.Duration(gb.config().GetChannelPool().GetConnectTimeoutMs()) * time.Millisecond
+	if timeout == 0 {
+		return
+	}
+	if gb.connectTimers == nil {
+		gb.connectTimers = make(map[balancer.SubConn]*time.Timer)
+	}
+	gb.connectTimers[sc] = time.AfterFunc(timeout, func() {
+		gb.mu.Lock()
+		defer gb.mu.Unlock()
+		select {
+		case <-gb.done:
+			return
+		default:
+		}
+		gb.connectTimedOut(ref, sc, timeout)
+	})
+}
+
+// disarmConnectTimeoutLocked stops the connect timeout of the SubConn once it
+// is READY or removed.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) disarmConnectTimeoutLocked(sc balancer.SubConn) {
+	if t, ok := gb.connectTimers[sc]; ok {
+		t.Stop()
+		delete(gb.connectTimers, sc)
+	}
+}
+
+// connectTimedOut replaces the SubConn sc of the ref which did not become
+// READY within the timeout: the connection of the channel is replaced right
+// away, and a pending replacement connection is replaced with another one.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) connectTimedOut(ref *subConnRef, sc balancer.SubConn, timeout time.Duration) {
+	if _, ok := gb.connectTimers[sc]; !ok {
+		return
+	}
+	delete(gb.connectTimers, sc)
+	switch {
+	case gb.refreshingScRefs[sc] == ref:
+		gb.log.Warningf("replacement connection of channel %s is not READY after %v, replacing it", ref.label, timeout)
+		atomic.AddUint64(&gb.counters.connectTimeouts, 1)
+		delete(gb.refreshingScRefs, sc)
+		gb.removeSubConnLocked(sc, ref)
+		ref.refreshing = false
+		gb.refreshLocked(ref)
+	case ref.subConn == sc && gb.scRefs[sc] == ref && !ref.removing:
+		gb.log.Warningf("connection of channel %s is %v after %v, replacing it", ref.label, gb.scStates[sc], timeout)
+		atomic.AddUint64(&gb.counters.connectTimeouts, 1)
+		gb.abandonSubConnLocked(ref)
+	}
+}
+
+// abandonSubConnLocked replaces the SubConn of the ref, which never became
+// READY and has no calls, with a new SubConn right away.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) abandonSubConnLocked(ref *subConnRef) {
+	oldSc := ref.subConn
+	sc, err := gb.newSubConnLocked(ref.id)
+	if err != nil {
+		gb.log.Errorf("failed to create a replacement SubConn with NewSubConn: %v", err)
+		return
+	}
+	// The new SubConn takes over the state of the abandoned one until it
+	// reports its own so that the aggregated state stays consistent.
+	gb.scStates[sc] = gb.scStates[oldSc]
+	delete(gb.scRefs, oldSc)
+	delete(gb.scStates, oldSc)
+	gb.scRefs[sc] = ref
+	ref.subConn = sc
+	ref.reconnectAttempts = 0
+	ref.stopReconnect()
+	ref.resetConnInfo()
+	gb.moveSubConnLocked(oldSc, sc)
+	gb.removeSubConnLocked(oldSc, ref)
+	gb.onChannelReplaced(ref)
+	gb.armConnectTimeoutLocked(ref, sc)
+	sc.Connect()
+}
@@ -0,0 +1,99 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+// controlChannelID is the channel ID of the control channel passed to
+// Config.BeforeNewSubConn.
+const controlChannelID = -1
+
+// ensureControlChannelLocked creates the control channel for the methods
+// bypassing the pool if not created yet.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) ensureControlChannelLocked() {
+	if gb.controlSC != nil {
+		return
+	}
+	sc, err := gb.newSubConnLocked(controlChannelID)
+	if err != nil {
+		gb.log.Errorf("failed to create the control channel: %v", err)
+		return
+	}
+	if gb.log.V(FINE) {
+		gb.log.Infof("created the control channel %s", controlChannelLabel)
+	}
+	gb.controlSC = sc
+	gb.controlState = connectivity.Idle
+	sc.Connect()
+}
+
+// updateControlStateLocked handles a state change of the control channel. The
+// control channel does not affect the state of the pool.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) updateControlStateLocked(s connectivity.State) {
+	if gb.log.V(FINE) {
+		gb.log.Infof("control channel state changed from %v to %v", gb.controlState, s)
+	}
+	gb.controlState = s
+	switch s {
+	case connectivity.Idle:
+		gb.controlSC.Connect()
+	case connectivity.Ready:
+		// Queued calls of the control methods are picked again with a new
+		// picker.
+		gb.regeneratePicker()
+		gb.cc.UpdateState(balancer.State{
+			ConnectivityState: gb.state,
+			Picker:            gb.picker,
+		})
+	}
+}
+
+// needsControlChannel reports whether the methods or the unknown methods, see
+// unknown_method_policy, send calls over the control channel.
+func needsControlChannel(methods *methodConfigs, cp *pb.ChannelPoolConfig) bool {
+	return methods.hasControlMethods() || cp.GetUnknownMethodPolicy() == pb.ChannelPoolConfig_UNKNOWN_METHOD_OVERFLOW_CHANNEL
+}
+
+// pickControl picks the control channel for a call of a method bypassing the
+// pool. The call waits while the control channel is not ready.
+func (p *gcpPicker) pickControl(info balancer.PickInfo) (balancer.PickResult, error) {
+	if gcpCtx, ok := info.Ctx.Value(gcpKey).(*gcpContext); ok {
+		p.gb.linkConn(gcpCtx.cc)
+		if !gcpCtx.streaming {
+			gcpCtx.call.apply(p.gb.methodCallPolicy(info.FullMethodName))
+		}
+	}
+	p.gb.mu.RLock()
+	sc, state := p.gb.controlSC, p.gb.controlState
+	p.gb.mu.RUnlock()
+	if sc == nil || state != connectivity.Ready {
+		if p.log.V(FINEST) {
+			p.log.Infof("control channel is not ready for %s: %v", info.FullMethodName, state)
+		}
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	return balancer.PickResult{SubConn: sc}, nil
+}
@@ -0,0 +1,282 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// AffinitySnapshot is a point-in-time view of the affinity bindings of a
+// ClientConn using the grpc_gcp balancer.
+type AffinitySnapshot struct {
+	// Time when the snapshot was taken.
+	Time time.Time `json:"time"`
+	// Channels in the pool ordered by index.
+	Channels []ChannelSnapshot `json:"channels"`
+	// Affinity keys bound to the channels ordered by key hash.
+	Keys []AffinityKeySnapshot `json:"keys"`
+	// Affinity bindings aggregated by key prefix ordered by prefix, see
+	// ChannelPoolConfig.affinity_key_prefix_delimiter. Empty if the delimiter
+	// is not configured.
+	Prefixes []AffinityPrefixSnapshot `json:"prefixes,omitempty"`
+	// Whether the pool is degraded, see PoolMetrics.Degraded.
+	Degraded bool `json:"degraded,omitempty"`
+	// The last error of the name resolver while it fails, empty otherwise.
+	ResolverError string `json:"resolverError,omitempty"`
+}
+
+// ChannelSnapshot describes a channel in the pool.
+type ChannelSnapshot struct {
+	// Index of the channel in the pool.
+	Index int `json:"index"`
+	// Label of the channel, see Config.ChannelLabel.
+	Label string `json:"label"`
+	// Connectivity state of the channel.
+	State string `json:"state"`
+	// Number of affinity keys bound to the channel.
+	AffinityCount int32 `json:"affinityCount"`
+	// Number of active streams on the channel.
+	ActiveStreams int32 `json:"activeStreams"`
+	// Number of transitions of the channel out of the READY state.
+	Flaps uint64 `json:"flaps"`
+	// Number of calls finished on the channel by status code.
+	Calls CallCounts `json:"calls"`
+	// Moving average of the latency of the successful unary calls on the
+	// channel in milliseconds.
+	LatencyMs float64 `json:"latencyMs,omitempty"`
+	// Moving average of the backend latency reported by the Google Front End
+	// in the Server-Timing response headers and trailers in milliseconds. The
+	// headers are only observed by the GCP stats handler.
+	ServerLatencyMs float64 `json:"serverLatencyMs,omitempty"`
+	// The connection details below are observed by the GCP stats handler,
+	// see NewGCPStatsHandler, and are empty until a call is sent over the
+	// current connection of the channel.
+	// Network of the connection, e.g., "tcp" or "unix".
+	Network string `json:"network,omitempty"`
+	// Remote address of the connection, e.g., the backend IP or the path of
+	// the unix socket.
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	// Local address of the connection.
+	LocalAddr string `json:"localAddr,omitempty"`
+	// Security protocol of the connection, e.g., "tls" or "alts" for
+	// Directpath.
+	AuthType string `json:"authType,omitempty"`
+	// TLS version, cipher suite and server name of TLS connections.
+	TLSVersion     string `json:"tlsVersion,omitempty"`
+	TLSCipherSuite string `json:"tlsCipherSuite,omitempty"`
+	TLSServerName  string `json:"tlsServerName,omitempty"`
+	// Whether the TLS handshake resumed a previous session, see
+	// NewTLSCredentials.
+	TLSResumed bool `json:"tlsResumed,omitempty"`
+	// Duration of the handshake of the connection in milliseconds, if
+	// measured, see MeasureHandshakes.
+	HandshakeMs float64 `json:"handshakeMs,omitempty"`
+	// Attributes attached by the resolver to the address of the connection,
+	// e.g., the locality.
+	Attributes string `json:"attributes,omitempty"`
+}
+
+// AffinityKeySnapshot describes an affinity key binding.
+type AffinityKeySnapshot struct {
+	// Hash of the affinity key, see AffinityKeyHash.
+	KeyHash string `json:"keyHash"`
+	// Index of the channel the key is bound to.
+	ChannelIndex int `json:"channelIndex"`
+	// Number of affinity keys bound to the channel.
+	AffinityCount int32 `json:"affinityCount"`
+	// Last time the key was bound or used by a call.
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+// AffinityPrefixSnapshot describes the affinity bindings of the keys with the
+// same prefix, e.g., the Cloud Spanner sessions of a database.
+type AffinityPrefixSnapshot struct {
+	// Prefix of the affinity keys.
+	Prefix string `json:"prefix"`
+	// Number of affinity keys with the prefix bound to the channels.
+	BoundKeys int `json:"boundKeys"`
+	// Number of affinity keys with the prefix bound to every channel ordered
+	// by channel index.
+	ChannelKeys []int `json:"channelKeys"`
+}
+
+// AffinityKeyHash returns the hash identifying the affinity key in an
+// AffinitySnapshot. Affinity keys, e.g., session names, are not exposed as is.
+func AffinityKeyHash(key string) string {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// GetAffinitySnapshot returns a snapshot of the affinity bindings of the
+// ClientConn. ErrBalancerNotFound is returned if the ClientConn does not use
+// the grpc_gcp balancer or no call was made on it with the GCP interceptors yet.
+func GetAffinitySnapshot(conn *grpc.ClientConn) (*AffinitySnapshot, error) {
+	gb, err := balancerForConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	return gb.affinitySnapshot(), nil
+}
+
+func (gb *gcpBalancer) affinitySnapshot() *AffinitySnapshot {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+
+	snap := &AffinitySnapshot{
+		Time:     time.Now(),
+		Channels: []ChannelSnapshot{},
+		Keys:     []AffinityKeySnapshot{},
+		Degraded: gb.degraded || gb.resolverErr != nil,
+	}
+	if gb.resolverErr != nil {
+		snap.ResolverError = gb.resolverErr.Error()
+	}
+	for _, ref := range gb.scRefList {
+		cs := ChannelSnapshot{
+			Index:           ref.id,
+			Label:           ref.label,
+			State:           gb.scStates[ref.subConn].String(),
+			AffinityCount:   ref.getAffinityCnt(),
+			ActiveStreams:   ref.getStreamsCnt(),
+			Flaps:           atomic.LoadUint64(&ref.flaps),
+			Calls:           ref.calls.load(),
+			LatencyMs:       ref.latency.value() / float64(time.Millisecond),
+			ServerLatencyMs: ref.serverLatency.value() / float64(time.Millisecond),
+		}
+		if ci := ref.getConnInfo(); ci != nil {
+			cs.Network = ci.network
+			cs.RemoteAddr = ci.remoteAddr
+			cs.LocalAddr = ci.localAddr
+			cs.AuthType = ci.authType
+			cs.TLSVersion = ci.tlsVersion
+			cs.TLSCipherSuite = ci.tlsCipherSuite
+			cs.TLSServerName = ci.tlsServerName
+			cs.TLSResumed = ci.tlsResumed
+			cs.HandshakeMs = float64(ci.handshake) / float64(time.Millisecond)
+		}
+		if addr, ok := gb.subConnAddress(ref); ok && addr.Attributes != nil {
+			cs.Attributes = addr.Attributes.String()
+		}
+		snap.Channels = append(snap.Channels, cs)
+	}
+	delim := gb.affinityKeyPrefixDelimiter()
+	prefixes := map[string]*AffinityPrefixSnapshot{}
+	for key, sc := range gb.affinityMap {
+		ref, ok := gb.scRefs[sc]
+		if !ok {
+			continue
+		}
+		if delim != "" {
+			prefix := affinityKeyPrefix(key, delim)
+			ps, ok := prefixes[prefix]
+			if !ok {
+				ps = &AffinityPrefixSnapshot{Prefix: prefix, ChannelKeys: make([]int, len(gb.scRefList))}
+				prefixes[prefix] = ps
+			}
+			ps.BoundKeys++
+			ps.ChannelKeys[ref.id]++
+		}
+		var lastUsed time.Time
+		if used := gb.affinityUsed[key]; used != nil {
+			lastUsed = time.Unix(0, atomic.LoadInt64(used))
+		}
+		snap.Keys = append(snap.Keys, AffinityKeySnapshot{
+			KeyHash:       AffinityKeyHash(key),
+			ChannelIndex:  ref.id,
+			AffinityCount: ref.getAffinityCnt(),
+			LastUsed:      lastUsed,
+		})
+	}
+	sort.Slice(snap.Keys, func(i, j int) bool {
+		return snap.Keys[i].KeyHash < snap.Keys[j].KeyHash
+	})
+	for _, ps := range prefixes {
+		snap.Prefixes = append(snap.Prefixes, *ps)
+	}
+	sort.Slice(snap.Prefixes, func(i, j int) bool {
+		return snap.Prefixes[i].Prefix < snap.Prefixes[j].Prefix
+	})
+	return snap
+}
+
+// affinityKeyPrefixDelimiter returns the configured delimiter of the affinity
+// key prefixes or an empty string.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) affinityKeyPrefixDelimiter() string {
+	cfg := gb.config()
+	if cfg == nil {
+		return ""
+	}
+	return cfg.ApiConfig.GetChannelPool().GetAffinityKeyPrefixDelimiter()
+}
+
+// affinityKeyPrefix returns the part of the key before the last occurrence of
+// the delimiter or an empty string if the key has no delimiter.
+func affinityKeyPrefix(key, delim string) string {
+	if i := strings.LastIndex(key, delim); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// AffinityHandler returns an http.Handler serving the affinity snapshot of the
+// ClientConn as JSON. If the "key" query parameter is provided, only the
+// binding of that affinity key, normalized with Config.NormalizeAffinityKey,
+// is served. The "namespace" query parameter scopes the key to the namespace,
+// see NamespacedAffinityKey.
+//
+//	http.Handle("/debug/grpcgcp/affinity", grpcgcp.AffinityHandler(conn))
+func AffinityHandler(conn *grpc.ClientConn) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gb, err := balancerForConn(conn)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		snap := gb.affinitySnapshot()
+		q := r.URL.Query()
+		if key := q.Get("key"); key != "" {
+			key = NamespacedAffinityKey(q.Get("namespace"), key)
+			hash := AffinityKeyHash(gb.normalizeScopedKey(key))
+			keys := []AffinityKeySnapshot{}
+			for _, k := range snap.Keys {
+				if k.KeyHash == hash {
+					keys = append(keys, k)
+				}
+			}
+			snap.Keys = keys
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(snap); err != nil {
+			compLogger.Warningf("failed to write affinity snapshot: %v", err)
+		}
+	})
+}
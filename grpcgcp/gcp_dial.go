@@ -0,0 +1,177 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+// WithDefaults returns DialOptions which enable the grpc_gcp load balancer with
+// the provided apiConfig and set up gRPC-GCP unary and stream interceptors.
+// The keepalive config of the channel pool, if any, is validated and applied.
+//
+// Usage:
+//
+//	opts, err := grpcgcp.WithDefaults(apiConfig)
+//	if err != nil {
+//		// Handle error.
+//	}
+//	conn, err := grpc.Dial(target, append(opts, grpc.WithTransportCredentials(creds))...)
+//
+// The interceptors are added using grpc.WithChainUnaryInterceptor and
+// grpc.WithChainStreamInterceptor, thus other interceptors may be provided
+// along with these options. Chain them with ChainUnaryClientInterceptors and
+// ChainStreamClientInterceptors to run them before the GCP interceptors.
+func WithDefaults(apiConfig *pb.ApiConfig) ([]grpc.DialOption, error) {
+	return WithDefaultsForBalancer(Name, apiConfig)
+}
+
+// WithDefaultsForBalancer is the same as WithDefaults but enables the grpc_gcp
+// balancer registered under the name with RegisterWithName.
+func WithDefaultsForBalancer(name string, apiConfig *pb.ApiConfig) ([]grpc.DialOption, error) {
+	if apiConfig == nil {
+		apiConfig = &pb.ApiConfig{}
+	}
+	jsonCfg, err := protojson.Marshal(apiConfig)
+	if err != nil {
+		return nil, fmt.Errorf("cannot json encode ApiConfig: %v", err)
+	}
+	keepaliveOpts, err := keepaliveDialOptions(apiConfig.GetChannelPool().GetKeepalive())
+	if err != nil {
+		return nil, err
+	}
+	return append([]grpc.DialOption{
+		grpc.WithDisableServiceConfig(),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s":%s}]}`, name, string(jsonCfg))),
+		grpc.WithChainUnaryInterceptor(GCPUnaryClientInterceptor),
+		grpc.WithChainStreamInterceptor(GCPStreamClientInterceptor),
+	}, keepaliveOpts...), nil
+}
+
+// GCPConn is a ClientConn using the grpc_gcp balancer with the GCP
+// interceptors and the GCP stats handler, created with Dial. It implements
+// [grpc.ClientConnInterface], so generated clients can use it directly, and
+// provides the introspection of the channel pool.
+//
+//	conn, err := grpcgcp.Dial(target, apiConfig, grpc.WithTransportCredentials(creds))
+//	if err != nil {
+//		// Handle error.
+//	}
+//	defer conn.Close()
+//	client := spannerpb.NewSpannerClient(conn)
+type GCPConn struct {
+	cc *grpc.ClientConn
+}
+
+// Make sure GCPConn implements grpc.ClientConnInterface.
+var _ grpc.ClientConnInterface = (*GCPConn)(nil)
+
+// Dial creates a ClientConn to the target with the DialOptions from
+// WithDefaults for the apiConfig, the GCP stats handler and the provided
+// DialOptions.
+func Dial(target string, apiConfig *pb.ApiConfig, opts ...grpc.DialOption) (*GCPConn, error) {
+	gcpOpts, err := WithDefaults(apiConfig)
+	if err != nil {
+		return nil, err
+	}
+	gcpOpts = append(gcpOpts, grpc.WithStatsHandler(NewGCPStatsHandler()))
+	cc, err := grpc.Dial(target, append(gcpOpts, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &GCPConn{cc: cc}, nil
+}
+
+func (c *GCPConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
+	return c.cc.Invoke(ctx, method, args, reply, opts...)
+}
+
+func (c *GCPConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return c.cc.NewStream(ctx, desc, method, opts...)
+}
+
+// ClientConn returns the underlying ClientConn, e.g., for the functions of
+// this package accepting a ClientConn.
+func (c *GCPConn) ClientConn() *grpc.ClientConn {
+	return c.cc
+}
+
+// PoolMetrics returns the metrics of the channel pool, see GetPoolMetrics.
+func (c *GCPConn) PoolMetrics() (*PoolMetrics, error) {
+	return GetPoolMetrics(c.cc)
+}
+
+// AffinitySnapshot returns a snapshot of the affinity bindings of the channel
+// pool, see GetAffinitySnapshot.
+func (c *GCPConn) AffinitySnapshot() (*AffinitySnapshot, error) {
+	return GetAffinitySnapshot(c.cc)
+}
+
+// PickDecisions returns the last pick decisions of the channel pool, see
+// GetPickDecisions.
+func (c *GCPConn) PickDecisions() ([]PickDecision, error) {
+	return GetPickDecisions(c.cc)
+}
+
+// ChannelStats returns the live counters of the channels in the pool, see
+// GetChannelStats.
+func (c *GCPConn) ChannelStats() ([]ChannelStats, error) {
+	return GetChannelStats(c.cc)
+}
+
+// WatchChannelStates returns a channel receiving the connectivity state
+// transitions of the channels in the pool, see WatchChannelStates.
+func (c *GCPConn) WatchChannelStates(ctx context.Context) (<-chan ChannelStateUpdate, error) {
+	return WatchChannelStates(ctx, c.cc)
+}
+
+// WaitForPoolReady blocks until at least minReady channels of the pool are
+// READY, see WaitForPoolReady.
+func (c *GCPConn) WaitForPoolReady(ctx context.Context, minReady int) error {
+	return WaitForPoolReady(ctx, c.cc, minReady)
+}
+
+// DrainChannel gracefully recycles the channel with the index in the pool, see
+// DrainChannel.
+func (c *GCPConn) DrainChannel(index int) error {
+	return DrainChannel(c.cc, index)
+}
+
+// UpdateConfig replaces the pool limits and the method configs of the channel
+// pool, see UpdateConfig.
+func (c *GCPConn) UpdateConfig(cfg *pb.ApiConfig) error {
+	return UpdateConfig(c.cc, cfg)
+}
+
+// Close closes the ClientConn.
+func (c *GCPConn) Close() error {
+	return c.cc.Close()
+}
+
+// CloseWithHandover closes the ClientConn and returns the affinity bindings of
+// the channel pool, see CloseWithHandover.
+func (c *GCPConn) CloseWithHandover() (*HandoverSnapshot, error) {
+	return CloseWithHandover(c.cc)
+}
@@ -0,0 +1,159 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+)
+
+// drainCheckInterval is how often a draining channel is checked for active
+// streams.
+var drainCheckInterval = 100 * time.Millisecond
+
+// DrainChannel gracefully recycles the channel with the index in the pool of
+// the ClientConn. The channel is excluded from new picks and the affinity keys
+// bound to it are moved to other channels on their next use. Once the active
+// streams of the channel finish, its connection is replaced with a new one and
+// the channel takes picks again. An error matching ErrChannelDraining is
+// returned if the channel is already draining and ErrChannelNotFound if the
+// pool has no channel with the index. ErrBalancerNotFound is returned if the
+// ClientConn does not use the grpc_gcp balancer or no call was made on it with
+// the GCP interceptors yet.
+func DrainChannel(conn *grpc.ClientConn, index int) error {
+	gb, err := balancerForConn(conn)
+	if err != nil {
+		return err
+	}
+	return gb.drain(index)
+}
+
+func (gb *gcpBalancer) drain(index int) error {
+	gb.mu.Lock()
+	if index < 0 || index >= len(gb.scRefList) {
+		gb.mu.Unlock()
+		return gb.poolError(ErrChannelNotFound, index, "")
+	}
+	if !gb.drainLocked(gb.scRefList[index]) {
+		gb.mu.Unlock()
+		return gb.poolError(ErrChannelDraining, index, "")
+	}
+	gb.regeneratePicker()
+	gb.cc.UpdateState(balancer.State{
+		ConnectivityState: gb.state,
+		Picker:            gb.picker,
+	})
+	gb.mu.Unlock()
+	return nil
+}
+
+// drainLocked excludes the ref from new picks and replaces its connection once
+// its active streams finish. It returns false if the ref is already draining.
+// The caller must update the picker. Must be called holding the mutex lock.
+func (gb *gcpBalancer) drainLocked(ref *subConnRef) bool {
+	if ref.draining {
+		return false
+	}
+	ref.draining = true
+	if gb.log.V(FINE) {
+		gb.log.Infof("draining channel %s with %d active streams", ref.label, ref.getStreamsCnt())
+	}
+	go gb.awaitDrained(ref)
+	return true
+}
+
+// awaitDrained replaces the connection of the draining ref once it has no
+// active streams.
+func (gb *gcpBalancer) awaitDrained(ref *subConnRef) {
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+	for ref.getStreamsCnt() > 0 {
+		select {
+		case <-ticker.C:
+		case <-gb.done:
+			return
+		}
+	}
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+	if ref.removing {
+		gb.removeLocked(ref)
+		return
+	}
+	if gb.log.V(FINE) {
+		gb.log.Infof("channel %s is drained, replacing its connection", ref.label)
+	}
+	gb.refreshLocked(ref)
+}
+
+// migrateKeyLocked binds the key bound to the draining ref to the least busy
+// ready channel. The key stays on the draining ref if there is no other ready
+// channel. Must be called holding the mutex lock.
+func (gb *gcpBalancer) migrateKeyLocked(key string, ref *subConnRef) {
+	var target *subConnRef
+	for _, r := range gb.scRefList {
+		if r.draining || r.isEjected() || gb.scStates[r.subConn] != connectivity.Ready {
+			continue
+		}
+		if target == nil || r.getStreamsCnt() < target.getStreamsCnt() {
+			target = r
+		}
+	}
+	if target == nil {
+		return
+	}
+	gb.moveKeyLocked(key, ref, target)
+	if gb.log.V(FINE) {
+		gb.log.Infof("moved affinity key %s from draining channel %s to channel %s", AffinityKeyHash(key), ref.label, target.label)
+	}
+}
+
+// moveKeyLocked binds the key bound to the from ref to the to ref. The move is
+// reported as an unbind from the from ref followed by a bind to the to ref.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) moveKeyLocked(key string, from, to *subConnRef) {
+	gb.affinityMap[key] = to.subConn
+	delete(gb.fallbackMap, key)
+	from.affinityDecr()
+	to.affinityIncr()
+	gb.emit(KeyUnbound, from.id, key, "")
+	gb.emit(KeyBound, to.id, key, "")
+	if gb.opts.OnBind == nil && gb.opts.OnUnbind == nil {
+		return
+	}
+	fromID, toID := from.id, to.id
+	go func() {
+		gb.onUnbind(key, fromID)
+		gb.onBind(key, toID)
+	}()
+}
+
+// migrateKeysLocked moves all the keys bound to the draining ref to other
+// ready channels, see migrateKeyLocked.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) migrateKeysLocked(ref *subConnRef) {
+	for key, sc := range gb.affinityMap {
+		if sc == ref.subConn {
+			gb.migrateKeyLocked(key, ref)
+		}
+	}
+}
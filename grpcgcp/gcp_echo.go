@@ -0,0 +1,176 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"sync"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Trailers set by the echo server interceptors, see
+// EchoUnaryServerInterceptor.
+const (
+	// EchoConnectionTrailer is the address of the client connection the call
+	// was received over as seen by the server. Calls sent over the same
+	// channel of the pool have the same value.
+	EchoConnectionTrailer = "x-grpc-gcp-echo-connection"
+	// EchoAffinityKeyTrailer is the affinity key of the call, one value per
+	// key for a repeated field.
+	EchoAffinityKeyTrailer = "x-grpc-gcp-echo-affinity-key"
+)
+
+// EchoUnaryServerInterceptor returns a server interceptor echoing the
+// connection the unary calls were received over and their affinity keys in the
+// trailers, e.g., for integration tests and staging environments asserting
+// that the calls with an affinity key stay on one channel of the pool:
+//
+//	srv := grpc.NewServer(
+//		grpc.UnaryInterceptor(grpcgcp.EchoUnaryServerInterceptor(apiConfig)),
+//		grpc.StreamInterceptor(grpcgcp.EchoStreamServerInterceptor(apiConfig)),
+//	)
+//	...
+//	var trailer metadata.MD
+//	_, err := client.ExecuteSql(ctx, req, grpc.Trailer(&trailer))
+//	conn := trailer.Get(grpcgcp.EchoConnectionTrailer)
+//
+// The affinity keys are located with the method configs of the ApiConfig the
+// clients use: in the response for BIND calls and in the request otherwise.
+// The keys are not echoed for the methods without an affinity config or if the
+// key cannot be located, e.g., in the response of a failed call.
+func EchoUnaryServerInterceptor(cfg *pb.ApiConfig) grpc.UnaryServerInterceptor {
+	methods := newMethodConfigs(cfg.GetMethod())
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		e := newEcho(ctx, methods, info.FullMethod)
+		if e.bind() {
+			if err == nil {
+				e.addKeys(resp)
+			}
+		} else {
+			e.addKeys(req)
+		}
+		grpc.SetTrailer(ctx, e.md)
+		return resp, err
+	}
+}
+
+// EchoStreamServerInterceptor returns a server interceptor echoing the
+// connection the streaming calls were received over and their affinity keys
+// in the trailers, see EchoUnaryServerInterceptor. The affinity keys are
+// located in the first response message for BIND calls and in the first
+// request message otherwise.
+func EchoStreamServerInterceptor(cfg *pb.ApiConfig) grpc.StreamServerInterceptor {
+	methods := newMethodConfigs(cfg.GetMethod())
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		es := &echoStream{ServerStream: ss, echo: newEcho(ss.Context(), methods, info.FullMethod)}
+		err := handler(srv, es)
+		es.mu.Lock()
+		ss.SetTrailer(es.md)
+		es.mu.Unlock()
+		return err
+	}
+}
+
+// echo collects the trailers of a call.
+type echo struct {
+	md       metadata.MD
+	affinity *pb.AffinityConfig
+	// Incoming metadata of the call with the routing header, see
+	// affinity_key_params.
+	in metadata.MD
+}
+
+func newEcho(ctx context.Context, methods *methodConfigs, method string) *echo {
+	e := &echo{md: metadata.MD{}}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		e.md.Set(EchoConnectionTrailer, p.Addr.String())
+	}
+	e.affinity, _ = methods.methodAffinity(method)
+	e.in, _ = metadata.FromIncomingContext(ctx)
+	return e
+}
+
+// bind reports whether the affinity keys are in the response messages.
+func (e *echo) bind() bool {
+	return e.affinity.GetCommand() == pb.AffinityConfig_BIND
+}
+
+// addKeys adds the affinity keys located in the msg to the trailers.
+func (e *echo) addKeys(msg interface{}) {
+	if msg == nil {
+		return
+	}
+	locate := func(locator string) ([]string, error) {
+		return getAffinityKeysFromMessage(locator, msg)
+	}
+	if !e.bind() && len(e.affinity.GetAffinityKeyParams()) > 0 {
+		if key := requestParamsKey(e.affinity, e.in, locate); key != "" {
+			e.md.Append(EchoAffinityKeyTrailer, key)
+		}
+		return
+	}
+	if e.affinity.GetAffinityKey() == "" {
+		return
+	}
+	keys, err := firstAffinityKeys(e.affinity, locate)
+	if err != nil {
+		return
+	}
+	e.md.Append(EchoAffinityKeyTrailer, keys...)
+}
+
+// echoStream locates the affinity keys in the first message of the stream
+// carrying them.
+type echoStream struct {
+	grpc.ServerStream
+	*echo
+
+	mu      sync.Mutex
+	located bool
+}
+
+func (s *echoStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil && !s.bind() {
+		s.locate(m)
+	}
+	return err
+}
+
+func (s *echoStream) SendMsg(m interface{}) error {
+	if s.bind() {
+		s.locate(m)
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *echoStream) locate(m interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.located {
+		return
+	}
+	s.located = true
+	s.addKeys(m)
+}
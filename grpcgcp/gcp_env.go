@@ -0,0 +1,126 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/grpclog"
+)
+
+// Environment variables overriding the pool behavior of the balancers built
+// by the builders constructed afterwards, e.g., to tune a deployment without
+// changing its code. They take precedence over the Config, which takes
+// precedence over the ApiConfig. Invalid values are logged and ignored.
+const (
+	// EnvMaxChannels overrides the max number of channels in the pool, see
+	// Config.MaxConns.
+	EnvMaxChannels = "GRPC_GCP_MAX_CHANNELS"
+	// EnvMaxStreams overrides the low watermark of concurrent streams in a
+	// channel, see Config.MaxStreamsPerConn.
+	EnvMaxStreams = "GRPC_GCP_MAX_STREAMS"
+	// EnvLogLevel overrides the verbosity of the balancer logs: "INFO" for no
+	// verbose logs, "FINE", "FINEST" or a number. The info logs of the
+	// "grpcgcp" grpclog component are only printed with
+	// GRPC_GO_LOG_SEVERITY_LEVEL=info.
+	EnvLogLevel = "GRPC_GCP_LOG_LEVEL"
+)
+
+// envOverrides are the overrides from the environment variables.
+type envOverrides struct {
+	maxConns   uint32
+	maxStreams uint32
+	// The verbosity of the balancer logs if logLevelSet.
+	logLevel    int
+	logLevelSet bool
+}
+
+// readEnv reads the overrides from the environment variables.
+func readEnv() envOverrides {
+	e := envOverrides{}
+	if v, ok := os.LookupEnv(EnvMaxChannels); ok {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || n == 0 {
+			compLogger.Warningf("ignoring %s=%q: not a positive number", EnvMaxChannels, v)
+		} else {
+			e.maxConns = uint32(n)
+		}
+	}
+	if v, ok := os.LookupEnv(EnvMaxStreams); ok {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || n == 0 || n > 100 {
+			compLogger.Warningf("ignoring %s=%q: not in the [1, 100] range", EnvMaxStreams, v)
+		} else {
+			e.maxStreams = uint32(n)
+		}
+	}
+	if v, ok := os.LookupEnv(EnvLogLevel); ok {
+		switch strings.ToUpper(v) {
+		case "INFO":
+			e.logLevel, e.logLevelSet = 0, true
+		case "FINE":
+			e.logLevel, e.logLevelSet = FINE, true
+		case "FINEST":
+			e.logLevel, e.logLevelSet = FINEST, true
+		default:
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				compLogger.Warningf("ignoring %s=%q: not INFO, FINE, FINEST or a verbosity level", EnvLogLevel, v)
+			} else {
+				e.logLevel, e.logLevelSet = n, true
+			}
+		}
+	}
+	return e
+}
+
+// applyTo overrides the options of the Config with the environment variables.
+func (e envOverrides) applyTo(cfg Config) Config {
+	if e.maxConns > 0 {
+		cfg.MaxConns = e.maxConns
+		if cfg.MinConns > cfg.MaxConns {
+			compLogger.Warningf("%s=%d is below MinConns (%d), lowering MinConns", EnvMaxChannels, e.maxConns, cfg.MinConns)
+			cfg.MinConns = cfg.MaxConns
+		}
+	}
+	if e.maxStreams > 0 {
+		cfg.MaxStreamsPerConn = e.maxStreams
+	}
+	return cfg
+}
+
+// newBuilderFromEnv returns a builder of the balancer with the Config
+// overridden by the environment variables.
+func newBuilderFromEnv(name string, cfg Config) *gcpBalancerBuilder {
+	env := readEnv()
+	return &gcpBalancerBuilder{name: name, opts: env.applyTo(cfg), env: env}
+}
+
+// verbosityLogger reports the verbosity of the wrapped logger as the level.
+type verbosityLogger struct {
+	grpclog.LoggerV2
+	level int
+}
+
+// V implements grpclog.LoggerV2.
+func (l verbosityLogger) V(level int) bool {
+	return level <= l.level
+}
@@ -0,0 +1,100 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The failure modes of the balancer. Use errors.Is to check for them and
+// errors.As with a *PoolError, or a *PoolExhaustedError, to get the details
+// and the metrics of the channel pool when the failure happened.
+var (
+	// ErrPoolExhausted is matched by a PoolExhaustedError.
+	ErrPoolExhausted = errors.New("grpcgcp: no channel available in the pool")
+	// ErrKeyNotBound is the failure of an operation on an affinity key not
+	// bound to a channel, e.g., ReleaseChannelAffinity of a key already
+	// unbound by an UNBIND call.
+	ErrKeyNotBound = errors.New("grpcgcp: affinity key is not bound")
+	// ErrChannelDraining is the failure of an operation on a draining channel,
+	// e.g., DrainChannel of a channel already draining.
+	ErrChannelDraining = errors.New("grpcgcp: channel is draining")
+	// ErrChannelNotFound is the failure of an operation on a channel index not
+	// in the pool, e.g., a call pinned with PinChannel.
+	ErrChannelNotFound = errors.New("grpcgcp: no such channel in the pool")
+)
+
+// PoolError is a failure of an operation on the channel pool. It wraps one of
+// ErrKeyNotBound, ErrChannelDraining or ErrChannelNotFound.
+type PoolError struct {
+	// Err is the failure mode.
+	Err error
+	// Index of the channel of the operation, -1 if none.
+	ChannelIndex int
+	// Hash of the affinity key of the operation, if any, see AffinityKeyHash.
+	KeyHash string
+	// The metrics of the channel pool when the operation failed.
+	Pool PoolMetrics
+}
+
+func (e *PoolError) Error() string {
+	switch e.Err {
+	case ErrKeyNotBound:
+		return fmt.Sprintf("grpcgcp: affinity key %s is not bound", e.KeyHash)
+	case ErrChannelDraining:
+		return fmt.Sprintf("grpcgcp: channel %d is draining", e.ChannelIndex)
+	case ErrChannelNotFound:
+		return fmt.Sprintf("grpcgcp: no channel with index %d in the pool of %d channels", e.ChannelIndex, e.Pool.Channels)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the failure mode for errors.Is.
+func (e *PoolError) Unwrap() error {
+	return e.Err
+}
+
+// GRPCStatus makes gRPC fail a call with the error, e.g., a call pinned to a
+// channel not in the pool fails with the INVALID_ARGUMENT status.
+func (e *PoolError) GRPCStatus() *status.Status {
+	code := codes.Unknown
+	switch e.Err {
+	case ErrKeyNotBound:
+		code = codes.FailedPrecondition
+	case ErrChannelDraining:
+		code = codes.Unavailable
+	case ErrChannelNotFound:
+		code = codes.InvalidArgument
+	}
+	return status.New(code, e.Error())
+}
+
+// poolError returns the failure with the current metrics of the pool.
+// Must be called without holding the mutex lock.
+func (gb *gcpBalancer) poolError(err error, index int, key string) *PoolError {
+	e := &PoolError{Err: err, ChannelIndex: index, Pool: *gb.poolMetrics()}
+	if key != "" {
+		e.KeyHash = AffinityKeyHash(key)
+	}
+	return e
+}
@@ -0,0 +1,135 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"fmt"
+	"time"
+)
+
+// eventQueueSize is the number of events buffered for the OnEvent callback.
+// Events are dropped when the queue is full.
+const eventQueueSize = 1024
+
+// EventType is the type of a balancer lifecycle event.
+type EventType int
+
+const (
+	// ChannelCreated is emitted when a new channel is added to the pool.
+	ChannelCreated EventType = iota
+	// ChannelReady is emitted when a channel becomes READY.
+	ChannelReady
+	// ChannelBroken is emitted when a READY channel leaves the READY state.
+	ChannelBroken
+	// KeyBound is emitted when an affinity key is bound to a channel.
+	KeyBound
+	// KeyUnbound is emitted when an affinity key is unbound from a channel.
+	KeyUnbound
+	// PickQueued is emitted when a call waits for a READY channel.
+	PickQueued
+	// PoolDegraded is emitted when fewer than Config.DegradedReadyRatio of the
+	// channels are READY.
+	PoolDegraded
+	// PoolRecovered is emitted when a degraded pool has enough READY channels
+	// again.
+	PoolRecovered
+	// ChannelGoAway is emitted when the connection of a channel received a
+	// GOAWAY and its replacement is created.
+	ChannelGoAway
+)
+
+func (t EventType) String() string {
+	switch t {
+	case ChannelCreated:
+		return "ChannelCreated"
+	case ChannelReady:
+		return "ChannelReady"
+	case ChannelBroken:
+		return "ChannelBroken"
+	case KeyBound:
+		return "KeyBound"
+	case KeyUnbound:
+		return "KeyUnbound"
+	case PickQueued:
+		return "PickQueued"
+	case PoolDegraded:
+		return "PoolDegraded"
+	case PoolRecovered:
+		return "PoolRecovered"
+	case ChannelGoAway:
+		return "ChannelGoAway"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// Event is a lifecycle event of the grpc_gcp balancer, see Config.OnEvent.
+type Event struct {
+	// Type of the event.
+	Type EventType
+	// Time when the event happened.
+	Time time.Time
+	// Index of the channel in the pool or -1 for PickQueued, PoolDegraded and
+	// PoolRecovered.
+	ChannelID int
+	// Hash of the affinity key for KeyBound and KeyUnbound, see
+	// AffinityKeyHash.
+	KeyHash string
+	// Full method name of the call for PickQueued.
+	Method string
+}
+
+// startEvents starts the delivery of events to the OnEvent callback if set.
+func (gb *gcpBalancer) startEvents() {
+	if gb.opts.OnEvent == nil {
+		return
+	}
+	gb.events = make(chan Event, eventQueueSize)
+	go func() {
+		for {
+			select {
+			case e := <-gb.events:
+				gb.opts.OnEvent(e)
+			case <-gb.done:
+				return
+			}
+		}
+	}()
+}
+
+// emit queues the event for the OnEvent callback. It never blocks, so it may
+// be called holding the mutex lock.
+func (gb *gcpBalancer) emit(t EventType, channelID int, key, method string) {
+	if gb.events == nil {
+		return
+	}
+	e := Event{
+		Type:      t,
+		Time:      time.Now(),
+		ChannelID: channelID,
+		Method:    method,
+	}
+	if key != "" {
+		e.KeyHash = AffinityKeyHash(key)
+	}
+	select {
+	case gb.events <- e:
+	default:
+		gb.log.Warningf("event queue is full, dropping %v event", t)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// ExpvarName is the name of the expvar map of the pools published with
+// Config.Expvar.
+const ExpvarName = "grpcgcp.pools"
+
+var (
+	expvarOnce  sync.Once
+	expvarPools *expvar.Map
+	// Guards the names of the pools in expvarPools.
+	expvarMu sync.Mutex
+)
+
+// publishExpvar publishes the PoolMetrics of the balancer in the expvar map
+// by the target if Config.Expvar is set. The first unused name of the target
+// and "<target>#2" and so on is used.
+func (gb *gcpBalancer) publishExpvar(target string) {
+	if !gb.opts.Expvar {
+		return
+	}
+	expvarOnce.Do(func() {
+		expvarPools = expvar.NewMap(ExpvarName)
+	})
+	if target == "" {
+		target = "pool"
+	}
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	name := target
+	for i := 2; expvarPools.Get(name) != nil; i++ {
+		name = fmt.Sprintf("%s#%d", target, i)
+	}
+	expvarPools.Set(name, expvar.Func(func() interface{} {
+		return gb.poolMetrics()
+	}))
+	gb.expvarName = name
+}
+
+// unpublishExpvar removes the balancer from the expvar map.
+func (gb *gcpBalancer) unpublishExpvar() {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if gb.expvarName == "" {
+		return
+	}
+	expvarPools.Delete(gb.expvarName)
+	gb.expvarName = ""
+}
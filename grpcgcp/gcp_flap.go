@@ -0,0 +1,71 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+)
+
+// flapLogInterval is the interval over which the transitions of a flapping
+// channel are aggregated in the logs.
+var flapLogInterval = time.Minute
+
+// recordFlapLocked counts the transition of the ref out of READY. The first
+// transition in flapLogInterval is logged right away, the following ones are
+// logged as a summary at the end of the interval.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) recordFlapLocked(ref *subConnRef, s connectivity.State) {
+	atomic.AddUint64(&ref.flaps, 1)
+	atomic.AddUint64(&gb.counters.flaps, 1)
+	if !ref.flapLogStart.IsZero() {
+		ref.flapLogCnt++
+		return
+	}
+	gb.log.Infof("channel %s is no longer ready: %v", ref.label, s)
+	ref.flapLogStart = time.Now()
+	ref.flapLogCnt = 0
+	time.AfterFunc(flapLogInterval, func() {
+		gb.mu.Lock()
+		defer gb.mu.Unlock()
+		gb.flushFlapLogLocked(ref)
+	})
+}
+
+// flushFlapLogLocked logs the summary of the transitions of the ref since the
+// start of the interval.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) flushFlapLogLocked(ref *subConnRef) {
+	if ref.flapLogCnt > 0 {
+		gb.log.Warningf("channel %s flapped %d times in %v", ref.label, ref.flapLogCnt+1, flapLogInterval)
+	}
+	ref.flapLogStart = time.Time{}
+	ref.flapLogCnt = 0
+}
+
+// flappingLocked reports whether the subconn left READY more than once in the
+// current flapLogInterval. The logs of its state changes are suppressed.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) flappingLocked(sc balancer.SubConn) bool {
+	ref := gb.scRefs[sc]
+	return ref != nil && ref.flapLogCnt > 0
+}
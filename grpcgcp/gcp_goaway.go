@@ -0,0 +1,75 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// goAwayMessage is the message of the calls rejected by a connection draining
+// after it received a GOAWAY from the server, e.g., the Google Front End
+// rotating its connections.
+const goAwayMessage = "the connection is draining"
+
+// isGoAwayError reports whether the call failed because its connection
+// received a GOAWAY, as opposed to a network failure.
+func isGoAwayError(err error) bool {
+	s := status.Convert(err)
+	return s.Code() == codes.Unavailable && strings.Contains(s.Message(), goAwayMessage)
+}
+
+// observeGoAway records the GOAWAY received by the connection sc of the
+// channel and starts creating the replacement connection right away instead
+// of waiting for the connection to close, so the channel has a READY
+// connection again as soon as possible. The calls in flight on the draining
+// connection are not interrupted.
+func (gb *gcpBalancer) observeGoAway(ref *subConnRef, sc balancer.SubConn) {
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+	if ref.subConn != sc || ref.goAway {
+		// Already observed or the connection was replaced.
+		return
+	}
+	ref.goAway = true
+	atomic.AddUint64(&gb.counters.goAways, 1)
+	gb.emit(ChannelGoAway, ref.id, "", "")
+	if ref.draining || ref.removing {
+		return
+	}
+	gb.log.Infof("channel %s received GOAWAY, replacing its connection", ref.label)
+	gb.refreshLocked(ref)
+}
+
+// recordDisconnectLocked tells apart the READY connection of the channel
+// closed after a GOAWAY from a connection lost, e.g., by a network failure.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) recordDisconnectLocked(ref *subConnRef) {
+	if ref.goAway {
+		if gb.log.V(FINE) {
+			gb.log.Infof("channel %s closed its connection after GOAWAY", ref.label)
+		}
+		return
+	}
+	atomic.AddUint64(&gb.counters.connectionLosses, 1)
+}
@@ -0,0 +1,61 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+)
+
+// coalesceGrowthLocked returns true if the channel added to grow the pool is
+// not READY yet. The picks asking for another channel meanwhile wait for that
+// channel instead of adding one each, and are picked again once it becomes
+// READY, see settleGrowthLocked.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) coalesceGrowthLocked() bool {
+	if gb.growing == nil {
+		return false
+	}
+	gb.growWaiters++
+	atomic.AddUint64(&gb.counters.coalescedGrowths, 1)
+	if gb.log.V(FINEST) {
+		gb.log.Infof("waiting for channel %s added to grow the pool", gb.growing.label)
+	}
+	return true
+}
+
+// settleGrowthLocked lets the pool grow again once the channel added to grow
+// it becomes READY or is shut down. The picker regenerated for the READY
+// channel wakes up the picks waiting for it.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) settleGrowthLocked(ref *subConnRef, sc balancer.SubConn, s connectivity.State) {
+	if ref == nil || gb.growing != ref || ref.subConn != sc {
+		return
+	}
+	if s != connectivity.Ready && s != connectivity.Shutdown {
+		return
+	}
+	if gb.log.V(FINE) {
+		gb.log.Infof("channel %s added to grow the pool is %v, %d picks waited for it", ref.label, s, gb.growWaiters)
+	}
+	gb.growing = nil
+	gb.growWaiters = 0
+}
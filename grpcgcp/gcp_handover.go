@@ -0,0 +1,135 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// HandoverSnapshot are the affinity bindings of a closed channel pool, see
+// CloseWithHandover. It is serializable as JSON, e.g., to pass it to the next
+// process of a hot restart, which binds the keys to the channels with the
+// same indexes, see Config.Handover.
+type HandoverSnapshot struct {
+	// Time when the snapshot was taken.
+	Time time.Time `json:"time"`
+	// Number of channels in the pool.
+	Channels int `json:"channels"`
+	// Index of the channel every affinity key was bound to by the hash of
+	// the key, see AffinityKeyHash.
+	Keys map[string]int `json:"keys"`
+}
+
+// CloseWithHandover closes the ClientConn and returns the affinity bindings of
+// its channel pool for a warm handoff to the balancer of a new ClientConn, e.g.,
+// after a hot restart of a long-lived proxy:
+//
+//	snap, err := grpcgcp.CloseWithHandover(conn)
+//	if err != nil {
+//		// Handle error.
+//	}
+//	data, err := json.Marshal(snap)
+//	...
+//	// In the new process.
+//	var snap grpcgcp.HandoverSnapshot
+//	err := json.Unmarshal(data, &snap)
+//	cfg, err := grpcgcp.NewConfig(grpcgcp.WithHandover(&snap))
+//	grpcgcp.Register(cfg)
+//
+// The keys bound by the calls still in flight when the ClientConn closes are
+// not in the snapshot. ErrBalancerNotFound is returned, and the ClientConn is
+// not closed, if the ClientConn does not use the grpc_gcp balancer or no call
+// was made on it with the GCP interceptors yet.
+func CloseWithHandover(conn *grpc.ClientConn) (*HandoverSnapshot, error) {
+	gb, err := balancerForConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	snap := gb.handoverSnapshot()
+	return snap, conn.Close()
+}
+
+func (gb *gcpBalancer) handoverSnapshot() *HandoverSnapshot {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+	snap := &HandoverSnapshot{
+		Time:     time.Now(),
+		Channels: len(gb.scRefList),
+		Keys:     make(map[string]int, len(gb.affinityMap)),
+	}
+	for key, sc := range gb.affinityMap {
+		if ref := gb.scRefs[sc]; ref != nil {
+			snap.Keys[AffinityKeyHash(key)] = ref.id
+		}
+	}
+	return snap
+}
+
+// initHandover copies the bindings of the Config.Handover the balancer binds
+// the keys of its first calls by.
+func (gb *gcpBalancer) initHandover() {
+	snap := gb.opts.Handover
+	if snap == nil || len(snap.Keys) == 0 {
+		return
+	}
+	gb.handover = make(map[string]int, len(snap.Keys))
+	for hash, id := range snap.Keys {
+		gb.handover[hash] = id
+	}
+	atomic.StoreInt32(&gb.handoverLeft, int32(len(gb.handover)))
+}
+
+// restoreHandover binds the affinity key not bound yet to the channel of the
+// handover snapshot, if any. Every binding of the snapshot is restored once.
+// The binding of a channel not in the pool, e.g., below a smaller min_size,
+// is dropped.
+func (gb *gcpBalancer) restoreHandover(key string) {
+	if atomic.LoadInt32(&gb.handoverLeft) == 0 {
+		return
+	}
+	hash := AffinityKeyHash(key)
+	gb.handoverMu.Lock()
+	id, ok := gb.handover[hash]
+	if ok {
+		delete(gb.handover, hash)
+		atomic.AddInt32(&gb.handoverLeft, -1)
+	}
+	gb.handoverMu.Unlock()
+	if !ok {
+		return
+	}
+	gb.mu.RLock()
+	_, bound := gb.affinityMap[key]
+	var ref *subConnRef
+	if id >= 0 && id < len(gb.scRefList) {
+		ref = gb.scRefList[id]
+	}
+	gb.mu.RUnlock()
+	if bound || ref == nil {
+		return
+	}
+	if gb.log.V(FINE) {
+		gb.log.Infof("restoring the binding of affinity key %s to channel %s from the handover snapshot", hash, ref.label)
+	}
+	gb.bindSubConn(key, ref.subConn)
+	atomic.AddUint64(&gb.counters.handoverKeys, 1)
+}
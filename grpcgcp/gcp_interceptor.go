@@ -21,8 +21,12 @@ package grpcgcp
 import (
 	"context"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type key int
@@ -34,6 +38,65 @@ type gcpContext struct {
 	reqMsg interface{}
 	// response message used for post-process of an affinity call
 	replyMsg interface{}
+	// whether the call is a streaming call
+	streaming bool
+	// the ClientConn the call is made on
+	cc *grpc.ClientConn
+	// post-process of the first response message of a streaming call, set by
+	// the picker
+	firstRecv func(m interface{})
+	// the number of retries of a unary call made after its channel was torn
+	// down
+	retries uint32
+	// whether the unary call must be retried, set by the picker
+	retry bool
+	// the call policy of the unary call and the timeout of its current
+	// attempt
+	call callAttempt
+	// the channels used by the attempts of the call
+	attempts callAttempts
+	// the time (unix nanos) the call started waiting for a channel, 0 if the
+	// call is not waiting
+	queuedAt int64
+	// the balancer counting the call while it waits for a channel
+	queued queuedPick
+	// the *PickDecision of the latest attempt of the call, see
+	// PickDecisionFromContext
+	pick atomic.Value
+}
+
+// callAttempts tracks the channels used by the attempts of a call. Hedged
+// attempts are picked concurrently.
+type callAttempts struct {
+	mu   sync.Mutex
+	refs map[*subConnRef]bool
+}
+
+// add records the channel used by an attempt.
+func (ca *callAttempts) add(ref *subConnRef) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if ca.refs == nil {
+		ca.refs = make(map[*subConnRef]bool)
+	}
+	ca.refs[ref] = true
+}
+
+// unused returns the refs not used by previous attempts. If all refs were
+// used, nil is returned.
+func (ca *callAttempts) unused(refs []*subConnRef) []*subConnRef {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if len(ca.refs) == 0 {
+		return refs
+	}
+	var res []*subConnRef
+	for _, ref := range refs {
+		if !ca.refs[ref] {
+			res = append(res, ref)
+		}
+	}
+	return res
 }
 
 // GCPUnaryClientInterceptor intercepts the execution of a unary RPC
@@ -46,14 +109,59 @@ func GCPUnaryClientInterceptor(
 	cc *grpc.ClientConn,
 	invoker grpc.UnaryInvoker,
 	opts ...grpc.CallOption,
+) error {
+	warnIfNested(ctx)
+	return gcpUnaryClientInterceptor(ctx, method, req, reply, cc, invoker, opts...)
+}
+
+func gcpUnaryClientInterceptor(
+	ctx context.Context,
+	method string,
+	req interface{},
+	reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
 ) error {
 	gcpCtx := &gcpContext{
 		reqMsg:   req,
 		replyMsg: reply,
+		cc:       cc,
 	}
-	ctx = context.WithValue(ctx, gcpKey, gcpCtx)
+	ctx = context.WithValue(withPickCallOptions(ctx, opts), gcpKey, gcpCtx)
 
-	return invoker(ctx, method, req, reply, cc, opts...)
+	var attempts uint32
+	for {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		gcpCtx.call.begin(cancel)
+		err := invoker(attemptCtx, method, req, reply, cc, opts...)
+		gcpCtx.queued.dequeue()
+		policy, timedOut := gcpCtx.call.end()
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if gcpCtx.retry {
+			gcpCtx.retry = false
+			gcpCtx.retries++
+			continue
+		}
+		if timedOut && ctx.Err() == nil {
+			err = status.Errorf(codes.DeadlineExceeded, "grpcgcp: the attempt of %s timed out after %dms", method, policy.GetTimeoutMs())
+		}
+		attempts++
+		delay, ok := callRetryDelay(policy, attempts, err)
+		if !ok || ctx.Err() != nil {
+			return err
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+	}
 }
 
 // GCPStreamClientInterceptor intercepts the execution of a client streaming RPC
@@ -65,11 +173,23 @@ func GCPStreamClientInterceptor(
 	method string,
 	streamer grpc.Streamer,
 	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	warnIfNested(ctx)
+	return gcpStreamClientInterceptor(ctx, desc, cc, method, streamer, opts...)
+}
+
+func gcpStreamClientInterceptor(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
 ) (grpc.ClientStream, error) {
 	// This constructor does not create a real ClientStream,
 	// it only stores all parameters and let SendMsg() to create ClientStream.
 	cs := &gcpClientStream{
-		ctx:      ctx,
+		ctx:      withPickCallOptions(ctx, opts),
 		desc:     desc,
 		cc:       cc,
 		method:   method,
@@ -93,14 +213,20 @@ type gcpClientStream struct {
 	method   string
 	streamer grpc.Streamer
 	opts     []grpc.CallOption
+
+	gcpCtx *gcpContext
+	// whether a response message was received, only accessed by RecvMsg
+	received bool
 }
 
 func (cs *gcpClientStream) SendMsg(m interface{}) error {
 	cs.Lock()
 	// Initialize underlying ClientStream when getting the first request.
 	if cs.ClientStream == nil {
-		ctx := context.WithValue(cs.ctx, gcpKey, &gcpContext{reqMsg: m})
+		cs.gcpCtx = &gcpContext{reqMsg: m, streaming: true, cc: cs.cc}
+		ctx := context.WithValue(cs.ctx, gcpKey, cs.gcpCtx)
 		realCS, err := cs.streamer(ctx, cs.desc, cs.cc, cs.method, cs.opts...)
+		cs.gcpCtx.queued.dequeue()
 		if err != nil {
 			cs.initStreamErr = err
 			cs.Unlock()
@@ -126,5 +252,16 @@ func (cs *gcpClientStream) RecvMsg(m interface{}) error {
 		return cs.initStreamErr
 	}
 	cs.Unlock()
-	return cs.ClientStream.RecvMsg(m)
+	if err := cs.ClientStream.RecvMsg(m); err != nil {
+		return err
+	}
+	// The first response message of a stream may carry the affinity key to
+	// bind, e.g., a server-streaming call with the BIND command.
+	if !cs.received {
+		cs.received = true
+		if cs.gcpCtx.firstRecv != nil {
+			cs.gcpCtx.firstRecv(m)
+		}
+	}
+	return nil
 }
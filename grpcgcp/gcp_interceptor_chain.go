@@ -0,0 +1,123 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+var nestedWarning sync.Once
+
+// warnIfNested logs once if the GCP interceptor intercepts a call already
+// intercepted by another GCP interceptor, i.e., the GCP interceptors are
+// registered more than once on the ClientConn. Only the innermost one, closest
+// to the picker, takes effect.
+func warnIfNested(ctx context.Context) {
+	if _, ok := ctx.Value(gcpKey).(*gcpContext); !ok {
+		return
+	}
+	nestedWarning.Do(func() {
+		compLogger.Warningf("the GCP interceptors are registered more than once on a ClientConn, e.g., by WithDefaults and grpc.WithChainUnaryInterceptor; use ChainUnaryClientInterceptors and ChainStreamClientInterceptors to add other interceptors")
+	})
+}
+
+// sameFunc reports whether the functions are the same top-level function.
+func sameFunc(a, b interface{}) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
+
+// ChainUnaryClientInterceptors returns a unary interceptor calling the
+// interceptors in order with the GCP unary interceptor as the innermost one.
+// This way every interceptor sees the call as made by the application, while
+// the affinity key is extracted from the request and the reply actually sent
+// and received, e.g., after an interceptor modified the request, and BIND is
+// applied before any interceptor sees the reply.
+//
+// GCPUnaryClientInterceptor may only be passed as the last interceptor. An
+// error is returned if it is passed more than once or before other
+// interceptors, or if any interceptor is nil.
+//
+//	unary, err := grpcgcp.ChainUnaryClientInterceptors(authInterceptor, loggingInterceptor)
+//	if err != nil {
+//		// Handle error.
+//	}
+//	conn, err := grpc.Dial(target, append(opts, grpc.WithChainUnaryInterceptor(unary))...)
+//
+// The GCP interceptor added by WithDefaults, which precedes the chain, gives
+// way to the one of the chain. Other DialOptions cannot be inspected, so a GCP
+// interceptor registered separately after the chain is only detected by the
+// first call and logged.
+func ChainUnaryClientInterceptors(interceptors ...grpc.UnaryClientInterceptor) (grpc.UnaryClientInterceptor, error) {
+	chain := make([]grpc.UnaryClientInterceptor, 0, len(interceptors)+1)
+	for i, in := range interceptors {
+		if in == nil {
+			return nil, fmt.Errorf("grpcgcp: unary interceptor %d is nil", i)
+		}
+		if !sameFunc(in, GCPUnaryClientInterceptor) {
+			chain = append(chain, in)
+			continue
+		}
+		if i != len(interceptors)-1 {
+			return nil, fmt.Errorf("grpcgcp: GCPUnaryClientInterceptor must be the last interceptor, got it at %d of %d interceptors", i, len(interceptors))
+		}
+	}
+	chain = append(chain, gcpUnaryClientInterceptor)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		for i := len(chain) - 1; i > 0; i-- {
+			in, next := chain[i], invoker
+			invoker = func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				return in(ctx, method, req, reply, cc, next, opts...)
+			}
+		}
+		return chain[0](ctx, method, req, reply, cc, invoker, opts...)
+	}, nil
+}
+
+// ChainStreamClientInterceptors is the same as ChainUnaryClientInterceptors
+// for streaming calls with the GCP stream interceptor as the innermost one.
+func ChainStreamClientInterceptors(interceptors ...grpc.StreamClientInterceptor) (grpc.StreamClientInterceptor, error) {
+	chain := make([]grpc.StreamClientInterceptor, 0, len(interceptors)+1)
+	for i, in := range interceptors {
+		if in == nil {
+			return nil, fmt.Errorf("grpcgcp: stream interceptor %d is nil", i)
+		}
+		if !sameFunc(in, GCPStreamClientInterceptor) {
+			chain = append(chain, in)
+			continue
+		}
+		if i != len(interceptors)-1 {
+			return nil, fmt.Errorf("grpcgcp: GCPStreamClientInterceptor must be the last interceptor, got it at %d of %d interceptors", i, len(interceptors))
+		}
+	}
+	chain = append(chain, gcpStreamClientInterceptor)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		for i := len(chain) - 1; i > 0; i-- {
+			in, next := chain[i], streamer
+			streamer = func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+				return in(ctx, desc, cc, method, next, opts...)
+			}
+		}
+		return chain[0](ctx, desc, cc, method, streamer, opts...)
+	}, nil
+}
@@ -28,19 +28,24 @@ import (
 	"github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/mocks"
 	"github.com/golang/mock/gomock"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"google.golang.org/grpc"
 )
 
+// ccComparer compares ClientConns by identity.
+var ccComparer = cmp.Comparer(func(a, b *grpc.ClientConn) bool { return a == b })
+
 func TestGCPUnaryClientInterceptor(t *testing.T) {
 	ctx := context.TODO()
 	wantMethod := "someMethod"
 	wantReq := "requestMessage"
 	wantRepl := "replyMessage"
+	wantCC := &grpc.ClientConn{}
 	wantGCPCtx := &gcpContext{
 		reqMsg:   wantReq,
 		replyMsg: wantRepl,
+		cc:       wantCC,
 	}
-	wantCC := &grpc.ClientConn{}
 	wantOpts := []grpc.CallOption{grpc.CallContentSubtype("someSubtype"), grpc.MaxCallRecvMsgSize(42)}
 
 	invCalled := false
@@ -69,7 +74,7 @@ func TestGCPUnaryClientInterceptor(t *testing.T) {
 	gotGCPCtx, hasGCPCtx := gotCtx.Value(gcpKey).(*gcpContext)
 	if !hasGCPCtx {
 		t.Errorf("provided grpc.UnaryInvoker function was called with context without gcpContext")
-	} else if diff := cmp.Diff(wantGCPCtx, gotGCPCtx, cmp.AllowUnexported(gcpContext{})); diff != "" {
+	} else if diff := cmp.Diff(wantGCPCtx, gotGCPCtx, cmp.AllowUnexported(gcpContext{}), cmpopts.IgnoreFields(gcpContext{}, "attempts", "call", "queued", "pick"), ccComparer); diff != "" {
 		t.Errorf("provided grpc.UnaryInvoker function was called with unexpected gcpContext (-want, +got):\n%s", diff)
 	}
 	if gotMethod != wantMethod {
@@ -113,11 +118,13 @@ func TestGCPStreamClientInterceptor(t *testing.T) {
 	wantMethod := "someMethod"
 	wantReq := "someRequest"
 	wantRes := &fakeResp{}
-	wantGCPCtx := &gcpContext{
-		reqMsg: wantReq,
-	}
 	wantSD := &grpc.StreamDesc{}
 	wantCC := &grpc.ClientConn{}
+	wantGCPCtx := &gcpContext{
+		reqMsg:    wantReq,
+		streaming: true,
+		cc:        wantCC,
+	}
 	wantOpts := []grpc.CallOption{grpc.CallContentSubtype("someSubtype"), grpc.MaxCallRecvMsgSize(42)}
 
 	streamerCalled := false
@@ -126,7 +133,7 @@ func TestGCPStreamClientInterceptor(t *testing.T) {
 		gotGCPCtx, hasGCPCtx := ctx.Value(gcpKey).(*gcpContext)
 		if !hasGCPCtx {
 			t.Errorf("grpc.Streamer called with context without gcpContext")
-		} else if diff := cmp.Diff(wantGCPCtx, gotGCPCtx, cmp.AllowUnexported(gcpContext{})); diff != "" {
+		} else if diff := cmp.Diff(wantGCPCtx, gotGCPCtx, cmp.AllowUnexported(gcpContext{}), cmpopts.IgnoreFields(gcpContext{}, "attempts", "call", "queued", "pick"), ccComparer); diff != "" {
 			t.Errorf("grpc.Streamer called with unexpected gcpContext (-want, +got):\n%s", diff)
 		}
 		if desc != wantSD {
@@ -179,11 +186,13 @@ func TestGCPStreamClientInterceptorCallingReadBeforeSend(t *testing.T) {
 	wantMethod := "someMethod"
 	wantReq := "someRequest"
 	wantRes := &fakeResp{}
-	wantGCPCtx := &gcpContext{
-		reqMsg: wantReq,
-	}
 	wantSD := &grpc.StreamDesc{}
 	wantCC := &grpc.ClientConn{}
+	wantGCPCtx := &gcpContext{
+		reqMsg:    wantReq,
+		streaming: true,
+		cc:        wantCC,
+	}
 	wantOpts := []grpc.CallOption{grpc.CallContentSubtype("someSubtype"), grpc.MaxCallRecvMsgSize(42)}
 
 	streamerCalled := false
@@ -192,7 +201,7 @@ func TestGCPStreamClientInterceptorCallingReadBeforeSend(t *testing.T) {
 		gotGCPCtx, hasGCPCtx := ctx.Value(gcpKey).(*gcpContext)
 		if !hasGCPCtx {
 			t.Errorf("grpc.Streamer called with context without gcpContext")
-		} else if diff := cmp.Diff(wantGCPCtx, gotGCPCtx, cmp.AllowUnexported(gcpContext{})); diff != "" {
+		} else if diff := cmp.Diff(wantGCPCtx, gotGCPCtx, cmp.AllowUnexported(gcpContext{}), cmpopts.IgnoreFields(gcpContext{}, "attempts", "call", "queued", "pick"), ccComparer); diff != "" {
 			t.Errorf("grpc.Streamer called with unexpected gcpContext (-want, +got):\n%s", diff)
 		}
 		if desc != wantSD {
@@ -247,3 +256,170 @@ func TestGCPStreamClientInterceptorCallingReadBeforeSend(t *testing.T) {
 	}
 	received.Wait()
 }
+
+func TestGCPStreamClientInterceptorFirstRecv(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	firstRes := &fakeResp{}
+	secondRes := &fakeResp{}
+	var gotMsgs []interface{}
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		// Simulate the picker of a streaming bind call.
+		ctx.Value(gcpKey).(*gcpContext).firstRecv = func(m interface{}) {
+			gotMsgs = append(gotMsgs, m)
+		}
+		mockCS := mocks.NewMockClientStream(mockCtrl)
+		mockCS.EXPECT().SendMsg(gomock.Any()).Times(1)
+		mockCS.EXPECT().RecvMsg(gomock.Any()).Times(2)
+		return mockCS, nil
+	}
+	cs, err := GCPStreamClientInterceptor(context.TODO(), &grpc.StreamDesc{}, nil, "someMethod", streamer)
+	if err != nil {
+		t.Fatalf("GCPStreamClientInterceptor(...) returned error: %v, want: nil", err)
+	}
+	if err := cs.SendMsg("someRequest"); err != nil {
+		t.Fatalf("SendMsg() returned error: %v, want: nil", err)
+	}
+	for _, m := range []interface{}{firstRes, secondRes} {
+		if err := cs.RecvMsg(m); err != nil {
+			t.Fatalf("RecvMsg() returned error: %v, want: nil", err)
+		}
+	}
+	if len(gotMsgs) != 1 || gotMsgs[0] != firstRes {
+		t.Fatalf("first response post-process called with %v, want: [%v]", gotMsgs, firstRes)
+	}
+}
+
+func TestChainUnaryClientInterceptors(t *testing.T) {
+	var order []string
+	// The outer interceptor replaces the request, the inner one observes the
+	// context.
+	outer := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		order = append(order, "outer")
+		return invoker(ctx, method, "modifiedRequest", reply, cc, opts...)
+	}
+	inner := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		order = append(order, "inner")
+		if _, ok := ctx.Value(gcpKey).(*gcpContext); ok {
+			t.Errorf("interceptor before the GCP interceptor called with gcpContext")
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	inv := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		order = append(order, "invoker")
+		gcpCtx, ok := ctx.Value(gcpKey).(*gcpContext)
+		if !ok {
+			t.Fatalf("grpc.UnaryInvoker called with context without gcpContext")
+		}
+		if gcpCtx.reqMsg != "modifiedRequest" {
+			t.Errorf("gcpContext has request %v, want: modifiedRequest", gcpCtx.reqMsg)
+		}
+		return nil
+	}
+	for _, interceptors := range [][]grpc.UnaryClientInterceptor{
+		{outer, inner},
+		{outer, inner, GCPUnaryClientInterceptor},
+	} {
+		order = nil
+		chain, err := ChainUnaryClientInterceptors(interceptors...)
+		if err != nil {
+			t.Fatalf("ChainUnaryClientInterceptors(...) returned error: %v, want: nil", err)
+		}
+		if err := chain(context.TODO(), "someMethod", "someRequest", "someReply", nil, inv); err != nil {
+			t.Fatalf("chained interceptor returned error: %v, want: nil", err)
+		}
+		if diff := cmp.Diff([]string{"outer", "inner", "invoker"}, order); diff != "" {
+			t.Fatalf("unexpected order of interceptors (-want, +got):\n%s", diff)
+		}
+	}
+
+	for _, interceptors := range [][]grpc.UnaryClientInterceptor{
+		{GCPUnaryClientInterceptor, outer},
+		{GCPUnaryClientInterceptor, GCPUnaryClientInterceptor},
+		{outer, nil},
+	} {
+		if _, err := ChainUnaryClientInterceptors(interceptors...); err == nil {
+			t.Fatalf("ChainUnaryClientInterceptors(%v) returned no error", interceptors)
+		}
+	}
+}
+
+func TestChainStreamClientInterceptors(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var order []string
+	outer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		order = append(order, "outer")
+		if _, ok := ctx.Value(gcpKey).(*gcpContext); ok {
+			t.Errorf("interceptor before the GCP interceptor called with gcpContext")
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		order = append(order, "streamer")
+		if _, ok := ctx.Value(gcpKey).(*gcpContext); !ok {
+			t.Errorf("grpc.Streamer called with context without gcpContext")
+		}
+		mockCS := mocks.NewMockClientStream(mockCtrl)
+		mockCS.EXPECT().SendMsg(gomock.Any()).Times(1)
+		return mockCS, nil
+	}
+	chain, err := ChainStreamClientInterceptors(outer)
+	if err != nil {
+		t.Fatalf("ChainStreamClientInterceptors(...) returned error: %v, want: nil", err)
+	}
+	cs, err := chain(context.TODO(), &grpc.StreamDesc{}, nil, "someMethod", streamer)
+	if err != nil {
+		t.Fatalf("chained interceptor returned error: %v, want: nil", err)
+	}
+	if err := cs.SendMsg("someRequest"); err != nil {
+		t.Fatalf("SendMsg() returned error: %v, want: nil", err)
+	}
+	if diff := cmp.Diff([]string{"outer", "streamer"}, order); diff != "" {
+		t.Fatalf("unexpected order of interceptors (-want, +got):\n%s", diff)
+	}
+	if _, err := ChainStreamClientInterceptors(GCPStreamClientInterceptor, outer); err == nil {
+		t.Fatalf("ChainStreamClientInterceptors with GCPStreamClientInterceptor first returned no error")
+	}
+}
+
+func TestPickCallOptions(t *testing.T) {
+	cc := &grpc.ClientConn{}
+	check := func(ctx context.Context, wantIndex int, wantKey string) {
+		t.Helper()
+		if index, ok := PinnedChannelFromContext(ctx); !ok || index != wantIndex {
+			t.Errorf("call pinned to channel %v (%v), want %v", index, ok, wantIndex)
+		}
+		if key, ok := ChannelAffinityFromContext(ctx); !ok || key != wantKey {
+			t.Errorf("call has affinity key %q (%v), want %q", key, ok, wantKey)
+		}
+	}
+
+	// The call options take precedence over the context.
+	ctx := PinChannel(WithChannelAffinity(context.Background(), "ctx-key"), 0)
+	opts := []grpc.CallOption{OnChannel(3), grpc.MaxCallRecvMsgSize(42), WithAffinityKey("call-key")}
+	inv := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		check(ctx, 3, "call-key")
+		return nil
+	}
+	if err := GCPUnaryClientInterceptor(ctx, "method", "req", "reply", cc, inv, opts...); err != nil {
+		t.Fatalf("GCPUnaryClientInterceptor(...) returned error: %v, want: nil", err)
+	}
+
+	streamerCalled := false
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		streamerCalled = true
+		check(ctx, 3, "call-key")
+		return nil, fmt.Errorf("no stream")
+	}
+	cs, err := GCPStreamClientInterceptor(ctx, &grpc.StreamDesc{}, cc, "method", streamer, opts...)
+	if err != nil {
+		t.Fatalf("GCPStreamClientInterceptor(...) returned error: %v, want: nil", err)
+	}
+	cs.SendMsg("req")
+	if !streamerCalled {
+		t.Fatalf("provided grpc.Streamer function was not called")
+	}
+}
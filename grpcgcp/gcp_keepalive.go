@@ -0,0 +1,73 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+// minKeepaliveTime is the minimum keepalive time of gRPC clients, lower values
+// are raised to it.
+const minKeepaliveTime = 10 * time.Second
+
+// keepaliveDialOptions returns the DialOptions applying the keepalive config
+// or an error if the config is not valid.
+func keepaliveDialOptions(cfg *pb.KeepaliveConfig) ([]grpc.DialOption, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	t := time.Duration(cfg.GetTimeMs()) * time.Millisecond
+	if t == 0 {
+		if cfg.GetTimeoutMs() > 0 || cfg.GetPermitWithoutStream() {
+			return nil, fmt.Errorf("grpcgcp: keepalive time_ms is required with timeout_ms or permit_without_stream")
+		}
+		return nil, nil
+	}
+	if t < minKeepaliveTime {
+		return nil, fmt.Errorf("grpcgcp: keepalive time_ms (%d) is below the minimum of %v", cfg.GetTimeMs(), minKeepaliveTime)
+	}
+	return []grpc.DialOption{
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                t,
+			Timeout:             time.Duration(cfg.GetTimeoutMs()) * time.Millisecond,
+			PermitWithoutStream: cfg.GetPermitWithoutStream(),
+		}),
+	}, nil
+}
+
+// recordKeepaliveDrop counts the call failed because its connection was closed
+// by a keepalive timeout or by the server for too many pings.
+func (gb *gcpBalancer) recordKeepaliveDrop(err error) {
+	msg := status.Convert(err).Message()
+	switch {
+	case strings.Contains(msg, "too_many_pings"):
+		atomic.AddUint64(&gb.counters.keepaliveTooManyPings, 1)
+	case strings.Contains(msg, "keepalive ping failed to receive ACK"):
+		atomic.AddUint64(&gb.counters.keepaliveTimeouts, 1)
+	}
+}
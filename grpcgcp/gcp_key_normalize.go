@@ -0,0 +1,68 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"strings"
+)
+
+// NormalizeResourceName is an affinity key normalizer, see
+// Config.NormalizeAffinityKey, for the keys of resource names arriving in
+// different forms: it trims the trailing slashes and lower-cases the project
+// IDs, which are case-insensitive, e.g., "projects/My-Project/databases/db/"
+// is normalized to "projects/my-project/databases/db".
+func NormalizeResourceName(key string) string {
+	key = strings.TrimRight(key, "/")
+	segs := strings.Split(key, "/")
+	for i := 0; i+1 < len(segs); i++ {
+		if segs[i] == "projects" {
+			segs[i+1] = strings.ToLower(segs[i+1])
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// normalizeKey returns the affinity key normalized with
+// Config.NormalizeAffinityKey, if set. Empty keys are not normalized.
+func (gb *gcpBalancer) normalizeKey(key string) string {
+	if gb.opts.NormalizeAffinityKey == nil || key == "" {
+		return key
+	}
+	return gb.opts.NormalizeAffinityKey(key)
+}
+
+// normalizeKeys normalizes the affinity keys in place, see normalizeKey.
+func (gb *gcpBalancer) normalizeKeys(keys []string) []string {
+	if gb.opts.NormalizeAffinityKey == nil {
+		return keys
+	}
+	for i, k := range keys {
+		keys[i] = gb.normalizeKey(k)
+	}
+	return keys
+}
+
+// normalizeScopedKey normalizes the affinity key in its namespace, if any,
+// see NamespacedAffinityKey.
+func (gb *gcpBalancer) normalizeScopedKey(key string) string {
+	if i := strings.Index(key, namespaceSeparator); i >= 0 {
+		return key[:i+len(namespaceSeparator)] + gb.normalizeKey(key[i+len(namespaceSeparator):])
+	}
+	return gb.normalizeKey(key)
+}
@@ -6,6 +6,8 @@ import (
 	"google.golang.org/grpc/grpclog"
 )
 
+// Verbosity levels of the balancer logs. State transitions and affinity events
+// are logged at the FINE level, pick decisions at the FINEST level.
 const (
 	FINE   = 90
 	FINEST = 99
@@ -34,7 +36,7 @@ func NewGCPLogger(logger grpclog.LoggerV2, prefix string) *gcpLogger {
 
 // Error implements grpclog.LoggerV2.
 func (l *gcpLogger) Error(args ...interface{}) {
-	l.logger.Error(append([]interface{}{l.prefix}, args)...)
+	l.logger.Error(append([]interface{}{l.prefix}, args...)...)
 }
 
 // Errorf implements grpclog.LoggerV2.
@@ -44,12 +46,12 @@ func (l *gcpLogger) Errorf(format string, args ...interface{}) {
 
 // Errorln implements grpclog.LoggerV2.
 func (l *gcpLogger) Errorln(args ...interface{}) {
-	l.logger.Errorln(append([]interface{}{l.prefix}, args)...)
+	l.logger.Errorln(append([]interface{}{l.prefix}, args...)...)
 }
 
 // Fatal implements grpclog.LoggerV2.
 func (l *gcpLogger) Fatal(args ...interface{}) {
-	l.logger.Fatal(append([]interface{}{l.prefix}, args)...)
+	l.logger.Fatal(append([]interface{}{l.prefix}, args...)...)
 }
 
 // Fatalf implements grpclog.LoggerV2.
@@ -59,12 +61,12 @@ func (l *gcpLogger) Fatalf(format string, args ...interface{}) {
 
 // Fatalln implements grpclog.LoggerV2.
 func (l *gcpLogger) Fatalln(args ...interface{}) {
-	l.Fatalln(append([]interface{}{l.prefix}, args)...)
+	l.logger.Fatalln(append([]interface{}{l.prefix}, args...)...)
 }
 
 // Info implements grpclog.LoggerV2.
 func (l *gcpLogger) Info(args ...interface{}) {
-	l.logger.Info(append([]interface{}{l.prefix}, args)...)
+	l.logger.Info(append([]interface{}{l.prefix}, args...)...)
 }
 
 // Infof implements grpclog.LoggerV2.
@@ -74,7 +76,7 @@ func (l *gcpLogger) Infof(format string, args ...interface{}) {
 
 // Infoln implements grpclog.LoggerV2.
 func (l *gcpLogger) Infoln(args ...interface{}) {
-	l.logger.Infoln(append([]interface{}{l.prefix}, args)...)
+	l.logger.Infoln(append([]interface{}{l.prefix}, args...)...)
 }
 
 // V implements grpclog.LoggerV2.
@@ -84,7 +86,7 @@ func (l *gcpLogger) V(level int) bool {
 
 // Warning implements grpclog.LoggerV2.
 func (l *gcpLogger) Warning(args ...interface{}) {
-	l.logger.Warning(append([]interface{}{l.prefix}, args)...)
+	l.logger.Warning(append([]interface{}{l.prefix}, args...)...)
 }
 
 // Warningf implements grpclog.LoggerV2.
@@ -94,5 +96,5 @@ func (l *gcpLogger) Warningf(format string, args ...interface{}) {
 
 // Warningln implements grpclog.LoggerV2.
 func (l *gcpLogger) Warningln(args ...interface{}) {
-	l.logger.Warningln(append([]interface{}{l.prefix}, args)...)
+	l.logger.Warningln(append([]interface{}{l.prefix}, args...)...)
 }
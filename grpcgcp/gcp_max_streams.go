@@ -0,0 +1,64 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"math"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+)
+
+// SetServerMaxConcurrentStreams reports the MAX_CONCURRENT_STREAMS setting
+// advertised by the server in the HTTP/2 SETTINGS frame of the connection of
+// the channel with the index in the pool of the ClientConn. A channel with as
+// many streams as the server allows is considered busy even below the
+// max_concurrent_streams_low_watermark, so new calls spill to another channel
+// or grow the pool instead of being queued by the server. The limit is reset
+// when the connection of the channel is replaced. A limit of 0 removes it.
+//
+// gRPC-Go does not expose the HTTP/2 settings, so the limit must be provided
+// by an integration which observes them, e.g., a custom transport.
+// ErrBalancerNotFound is returned if the ClientConn does not use the grpc_gcp
+// balancer or no call was made on it with the GCP interceptors yet.
+func SetServerMaxConcurrentStreams(conn *grpc.ClientConn, index int, limit uint32) error {
+	gb, err := balancerForConn(conn)
+	if err != nil {
+		return err
+	}
+	return gb.setServerMaxStreams(index, limit)
+}
+
+func (gb *gcpBalancer) setServerMaxStreams(index int, limit uint32) error {
+	gb.mu.RLock()
+	if index < 0 || index >= len(gb.scRefList) {
+		gb.mu.RUnlock()
+		return gb.poolError(ErrChannelNotFound, index, "")
+	}
+	defer gb.mu.RUnlock()
+	if limit > math.MaxInt32 {
+		limit = math.MaxInt32
+	}
+	atomic.StoreInt32(&gb.scRefList[index].serverMaxStreams, int32(limit))
+	return nil
+}
+
+func (ref *subConnRef) getServerMaxStreams() int32 {
+	return atomic.LoadInt32(&ref.serverMaxStreams)
+}
@@ -0,0 +1,102 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+// MergeApiConfigs returns the ApiConfig merging the method configs and the
+// channel pool configs of the ApiConfigs, e.g., of several client libraries
+// sharing one ClientConn:
+//
+//	cfg, err := grpcgcp.MergeApiConfigs(spannerConfig, firestoreConfig)
+//	if err != nil {
+//		// Handle error.
+//	}
+//	opts, err := grpcgcp.WithDefaults(cfg)
+//
+// The method configs are kept in the order of the ApiConfigs. A method name,
+// or wildcard pattern, configured more than once must have the same affinity,
+// channel pool and call policy every time and is kept in its first method
+// config only, otherwise an error naming the method is returned.
+//
+// Every option of the channel pool config is taken from the first ApiConfig
+// setting it to a value other than the default, so the ApiConfigs are listed
+// by precedence. Repeated options, e.g., channel_targets, are taken as a whole
+// and message options, e.g., keepalive, are merged the same way. The
+// ApiConfigs are not modified.
+func MergeApiConfigs(cfgs ...*pb.ApiConfig) (*pb.ApiConfig, error) {
+	type origin struct {
+		cfg *pb.MethodConfig
+		idx int
+	}
+	merged := &pb.ApiConfig{}
+	seen := make(map[string]origin)
+	for i, cfg := range cfgs {
+		for _, mc := range cfg.GetMethod() {
+			body := proto.Clone(mc).(*pb.MethodConfig)
+			body.Name = nil
+			var names []string
+			for _, name := range mc.GetName() {
+				if o, ok := seen[name]; ok {
+					if !proto.Equal(o.cfg, body) {
+						return nil, fmt.Errorf("grpcgcp: method %q has different method configs in ApiConfig %d and ApiConfig %d", name, o.idx, i)
+					}
+					continue
+				}
+				seen[name] = origin{cfg: body, idx: i}
+				names = append(names, name)
+			}
+			if len(names) == 0 {
+				continue
+			}
+			out := proto.Clone(body).(*pb.MethodConfig)
+			out.Name = names
+			merged.Method = append(merged.Method, out)
+		}
+		if cp := cfg.GetChannelPool(); cp != nil {
+			if merged.ChannelPool == nil {
+				merged.ChannelPool = &pb.ChannelPoolConfig{}
+			}
+			mergeUnset(merged.ChannelPool.ProtoReflect(), proto.Clone(cp).ProtoReflect())
+		}
+	}
+	return merged, nil
+}
+
+// mergeUnset sets the fields of dst not set yet to the values of the fields
+// of src. The fields of message type set in both are merged recursively. src
+// must not be used afterwards.
+func mergeUnset(dst, src protoreflect.Message) {
+	src.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case !dst.Has(fd):
+			dst.Set(fd, v)
+		case fd.Message() != nil && !fd.IsList() && !fd.IsMap():
+			mergeUnset(dst.Mutable(fd).Message(), v.Message())
+		}
+		return true
+	})
+}
@@ -0,0 +1,84 @@
+package grpcgcp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+func TestMergeApiConfigs(t *testing.T) {
+	bound := &pb.AffinityConfig{Command: pb.AffinityConfig_BOUND, AffinityKey: "session"}
+	a := &pb.ApiConfig{
+		ChannelPool: &pb.ChannelPoolConfig{
+			MaxSize:   4,
+			Keepalive: &pb.KeepaliveConfig{TimeMs: 30000},
+		},
+		Method: []*pb.MethodConfig{
+			{Name: []string{"/spanner.Spanner/ExecuteSql", "/spanner.Spanner/Read"}, Affinity: bound},
+		},
+	}
+	b := &pb.ApiConfig{
+		ChannelPool: &pb.ChannelPoolConfig{
+			MaxSize:                          8,
+			MinSize:                          2,
+			MaxConcurrentStreamsLowWatermark: 50,
+			ChannelTargets:                   []string{"a:443", "b:443"},
+			Keepalive:                        &pb.KeepaliveConfig{TimeMs: 60000, TimeoutMs: 5000},
+		},
+		Method: []*pb.MethodConfig{
+			// The same config of Read is merged.
+			{Name: []string{"/spanner.Spanner/Read", "/firestore.Firestore/*"}, Affinity: bound},
+			{Name: []string{"/firestore.Firestore/Commit"}, ChannelPool: &pb.MethodChannelPoolConfig{Priority: pb.CallPriority_HIGH}},
+		},
+	}
+	aCopy := proto.Clone(a)
+	got, err := MergeApiConfigs(a, nil, b)
+	if err != nil {
+		t.Fatalf("MergeApiConfigs returned error: %v", err)
+	}
+	want := &pb.ApiConfig{
+		ChannelPool: &pb.ChannelPoolConfig{
+			MaxSize:                          4,
+			MinSize:                          2,
+			MaxConcurrentStreamsLowWatermark: 50,
+			ChannelTargets:                   []string{"a:443", "b:443"},
+			Keepalive:                        &pb.KeepaliveConfig{TimeMs: 30000, TimeoutMs: 5000},
+		},
+		Method: []*pb.MethodConfig{
+			{Name: []string{"/spanner.Spanner/ExecuteSql", "/spanner.Spanner/Read"}, Affinity: bound},
+			{Name: []string{"/firestore.Firestore/*"}, Affinity: bound},
+			{Name: []string{"/firestore.Firestore/Commit"}, ChannelPool: &pb.MethodChannelPoolConfig{Priority: pb.CallPriority_HIGH}},
+		},
+	}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Fatalf("MergeApiConfigs returned unexpected config (-want +got):\n%s", diff)
+	}
+	if !proto.Equal(a, aCopy) {
+		t.Fatalf("MergeApiConfigs modified its argument: %v, want %v", a, aCopy)
+	}
+	// The merged config is not shared with the arguments.
+	got.ChannelPool.ChannelTargets[0] = "c:443"
+	got.Method[0].Affinity.AffinityKey = "name"
+	if b.ChannelPool.ChannelTargets[0] != "a:443" || bound.AffinityKey != "session" {
+		t.Fatal("the merged config shares values with the arguments")
+	}
+
+	// The same method with a different affinity is a conflict.
+	c := &pb.ApiConfig{
+		Method: []*pb.MethodConfig{
+			{Name: []string{"/spanner.Spanner/Read"}, Affinity: &pb.AffinityConfig{Command: pb.AffinityConfig_BIND, AffinityKey: "name"}},
+		},
+	}
+	if _, err := MergeApiConfigs(a, c); err == nil || !strings.Contains(err.Error(), `"/spanner.Spanner/Read"`) {
+		t.Fatalf("MergeApiConfigs with conflicting affinity returned error %v, want conflict on /spanner.Spanner/Read", err)
+	}
+
+	if got, err := MergeApiConfigs(); err != nil || !proto.Equal(got, &pb.ApiConfig{}) {
+		t.Fatalf("MergeApiConfigs() returned %v, %v, want empty config, nil", got, err)
+	}
+}
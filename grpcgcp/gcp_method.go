@@ -0,0 +1,263 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"strings"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+// methodWildcard is a method name pattern ending with "*" from the method
+// configs, e.g., "/google.spanner.v1.Spanner/*" or "google.spanner.v1.*".
+type methodWildcard struct {
+	// The pattern without the trailing "*" and the leading "/".
+	prefix   string
+	affinity *pb.AffinityConfig
+	pool     *methodPool
+	priority pb.CallPriority
+	policy   *pb.CallPolicy
+	control  bool
+}
+
+// isMethodWildcard reports whether the method name from a method config is a
+// wildcard pattern.
+func isMethodWildcard(name string) bool {
+	return strings.HasSuffix(name, "*")
+}
+
+func newMethodWildcard(name string, affinity *pb.AffinityConfig, pool *methodPool, priority pb.CallPriority, policy *pb.CallPolicy, control bool) methodWildcard {
+	return methodWildcard{
+		prefix:   strings.TrimPrefix(strings.TrimSuffix(name, "*"), "/"),
+		affinity: affinity,
+		pool:     pool,
+		priority: priority,
+		policy:   policy,
+		control:  control,
+	}
+}
+
+func (w methodWildcard) matches(method string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(method, "/"), w.prefix)
+}
+
+// methodConfigs are the method configs by method name.
+type methodConfigs struct {
+	affinity   map[string]*pb.AffinityConfig
+	pools      map[string]*methodPool
+	priorities map[string]pb.CallPriority
+	policies   map[string]*pb.CallPolicy
+	// Methods sent over the control channel.
+	control map[string]bool
+	// Method names configured without a wildcard.
+	exact map[string]bool
+	// Method name patterns in the order of the method configs.
+	wildcards []methodWildcard
+	// Number of method pools, i.e., method configs with channel pool
+	// overrides.
+	poolsCnt int
+}
+
+func newMethodConfigs(methodCfgs []*pb.MethodConfig) *methodConfigs {
+	m := &methodConfigs{
+		affinity:   make(map[string]*pb.AffinityConfig),
+		pools:      make(map[string]*methodPool),
+		priorities: make(map[string]pb.CallPriority),
+		policies:   make(map[string]*pb.CallPolicy),
+		control:    make(map[string]bool),
+		exact:      make(map[string]bool),
+	}
+	for _, methodCfg := range methodCfgs {
+		affinityCfg := methodCfg.GetAffinity()
+		priority := methodCfg.GetChannelPool().GetPriority()
+		policy := methodCfg.GetCallPolicy()
+		control := methodCfg.GetChannelPool().GetControlChannel()
+		var pool *methodPool
+		if maxStreams := methodCfg.GetChannelPool().GetMaxConcurrentStreamsLowWatermark(); maxStreams > 0 {
+			pool = &methodPool{
+				idx:        m.poolsCnt,
+				maxStreams: int32(maxStreams),
+			}
+			m.poolsCnt++
+		}
+		for _, method := range methodCfg.GetName() {
+			if isMethodWildcard(method) {
+				m.wildcards = append(m.wildcards, newMethodWildcard(method, affinityCfg, pool, priority, policy, control))
+				continue
+			}
+			m.exact[method] = true
+			if affinityCfg != nil {
+				m.affinity[method] = affinityCfg
+			}
+			if pool != nil {
+				m.pools[method] = pool
+			}
+			if priority != pb.CallPriority_NORMAL {
+				m.priorities[method] = priority
+			}
+			if policy != nil {
+				m.policies[method] = policy
+			}
+			if control {
+				m.control[method] = true
+			}
+		}
+	}
+	return m
+}
+
+// methodAffinity returns the affinity config of the method, see
+// methodConfigs.methodAffinity.
+func (gb *gcpBalancer) methodAffinity(method string) (*pb.AffinityConfig, bool) {
+	return gb.methods().methodAffinity(method)
+}
+
+// methodPool returns the method pool of the method or nil, see
+// methodConfigs.methodPool.
+func (gb *gcpBalancer) methodPool(method string) *methodPool {
+	return gb.methods().methodPool(method)
+}
+
+// methodPriority returns the call priority of the method, see
+// methodConfigs.methodPriority.
+func (gb *gcpBalancer) methodPriority(method string) pb.CallPriority {
+	return gb.methods().methodPriority(method)
+}
+
+// methodCallPolicy returns the call policy of the method or nil, see
+// methodConfigs.methodCallPolicy.
+func (gb *gcpBalancer) methodCallPolicy(method string) *pb.CallPolicy {
+	return gb.methods().methodCallPolicy(method)
+}
+
+// methodControl reports whether the calls of the method are sent over the
+// control channel, see methodConfigs.methodControl.
+func (gb *gcpBalancer) methodControl(method string) bool {
+	return gb.methods().methodControl(method)
+}
+
+// methodKnown reports whether the method has a method config, see
+// methodConfigs.methodKnown.
+func (gb *gcpBalancer) methodKnown(method string) bool {
+	return gb.methods().methodKnown(method)
+}
+
+// methodAffinity returns the affinity config of the method. A config for the
+// exact method name takes precedence over wildcard patterns, which are
+// evaluated in the order of the method configs.
+func (m *methodConfigs) methodAffinity(method string) (*pb.AffinityConfig, bool) {
+	if cfg, ok := m.affinity[method]; ok || m.exact[method] {
+		return cfg, ok
+	}
+	for _, w := range m.wildcards {
+		if w.affinity != nil && w.matches(method) {
+			return w.affinity, true
+		}
+	}
+	return nil, false
+}
+
+// methodPool returns the method pool of the method or nil. The method config
+// of the exact method name takes precedence over wildcard patterns, which are
+// evaluated in the order of the method configs.
+func (m *methodConfigs) methodPool(method string) *methodPool {
+	if mp, ok := m.pools[method]; ok || m.exact[method] {
+		return mp
+	}
+	for _, w := range m.wildcards {
+		if w.pool != nil && w.matches(method) {
+			return w.pool
+		}
+	}
+	return nil
+}
+
+// methodPriority returns the call priority of the method. The method config
+// of the exact method name takes precedence over wildcard patterns, which are
+// evaluated in the order of the method configs.
+func (m *methodConfigs) methodPriority(method string) pb.CallPriority {
+	if p, ok := m.priorities[method]; ok || m.exact[method] {
+		return p
+	}
+	for _, w := range m.wildcards {
+		if w.priority != pb.CallPriority_NORMAL && w.matches(method) {
+			return w.priority
+		}
+	}
+	return pb.CallPriority_NORMAL
+}
+
+// methodCallPolicy returns the call policy of the method or nil. The method
+// config of the exact method name takes precedence over wildcard patterns,
+// which are evaluated in the order of the method configs.
+func (m *methodConfigs) methodCallPolicy(method string) *pb.CallPolicy {
+	if cp, ok := m.policies[method]; ok || m.exact[method] {
+		return cp
+	}
+	for _, w := range m.wildcards {
+		if w.policy != nil && w.matches(method) {
+			return w.policy
+		}
+	}
+	return nil
+}
+
+// methodControl reports whether the calls of the method are sent over the
+// control channel. The method config of the exact method name takes precedence
+// over wildcard patterns, which are evaluated in the order of the method
+// configs.
+func (m *methodConfigs) methodControl(method string) bool {
+	if m.exact[method] {
+		return m.control[method]
+	}
+	for _, w := range m.wildcards {
+		if w.control && w.matches(method) {
+			return true
+		}
+	}
+	return false
+}
+
+// methodKnown reports whether the method has a method config, by its exact
+// name or by a wildcard pattern.
+func (m *methodConfigs) methodKnown(method string) bool {
+	if m.exact[method] {
+		return true
+	}
+	for _, w := range m.wildcards {
+		if w.matches(method) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasControlMethods reports whether any method config sends the calls over the
+// control channel.
+func (m *methodConfigs) hasControlMethods() bool {
+	if len(m.control) > 0 {
+		return true
+	}
+	for _, w := range m.wildcards {
+		if w.control {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,206 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// poolCounters are cumulative counters of the channel pool.
+type poolCounters struct {
+	// Number of channels replaced because the resolver removed addresses.
+	resolverChurn uint64
+	// Number of BOUND responses with an affinity key bound to another channel.
+	affinityMismatches uint64
+	// Number of calls failed because of a keepalive timeout.
+	keepaliveTimeouts uint64
+	// Number of calls failed because the server closed the connection for too
+	// many keepalive pings.
+	keepaliveTooManyPings uint64
+	// Number of transitions of channels out of READY.
+	flaps uint64
+	// Number of affinity keys moved by the affinity rebalancing.
+	rebalancedKeys uint64
+	// Number of pool growths coalesced with a channel not READY yet.
+	coalescedGrowths uint64
+	// Number of calls waiting for a channel, a gauge.
+	queuedPicks int64
+	// Number of connections abandoned by the connect timeout.
+	connectTimeouts uint64
+	// Number of calls of methods without a method config.
+	unknownMethodCalls uint64
+	// Number of affinity keys bound from the handover snapshot.
+	handoverKeys uint64
+	// Number of errors of the name resolver.
+	resolverErrors uint64
+	// Number of connections which received a GOAWAY.
+	goAways uint64
+	// Number of READY connections closed without a GOAWAY.
+	connectionLosses uint64
+	// Number of channels not added because the channel budget is exhausted.
+	budgetDenials uint64
+}
+
+// PoolMetrics are gauges and cumulative counters of the channel pool of a
+// ClientConn using the grpc_gcp balancer.
+type PoolMetrics struct {
+	// Number of channels in the pool.
+	Channels int `json:"channels"`
+	// Number of channels in the READY state.
+	ReadyChannels int `json:"readyChannels"`
+	// Number of active streams on all channels.
+	ActiveStreams int32 `json:"activeStreams"`
+	// Number of affinity keys bound to the channels.
+	BoundKeys int `json:"boundKeys"`
+	// Number of channels replaced because the resolver removed addresses.
+	ResolverChurn uint64 `json:"resolverChurn"`
+	// Number of BOUND call responses with an affinity key bound to another
+	// channel than the one used by the call, see
+	// AffinityConfig.response_affinity_key.
+	AffinityMismatches uint64 `json:"affinityMismatches"`
+	// Number of affinity keys bound to the channels by key prefix, see
+	// ChannelPoolConfig.affinity_key_prefix_delimiter. Nil if the delimiter
+	// is not configured.
+	BoundKeysByPrefix map[string]int `json:"boundKeysByPrefix,omitempty"`
+	// Number of calls failed because their connection did not receive the
+	// keepalive ping acknowledgment in time, see KeepaliveConfig.
+	KeepaliveTimeouts uint64 `json:"keepaliveTimeouts"`
+	// Number of calls failed because the server closed their connection with
+	// ENHANCE_YOUR_CALM for too many keepalive pings, see KeepaliveConfig.
+	KeepaliveTooManyPings uint64 `json:"keepaliveTooManyPings"`
+	// Number of transitions of the channels out of the READY state. A fast
+	// growing count indicates flapping connections.
+	Flaps uint64 `json:"flaps"`
+	// Number of affinity keys moved to other channels by the affinity
+	// rebalancing, see ChannelPoolConfig.affinity_rebalancing.
+	RebalancedKeys uint64 `json:"rebalancedKeys"`
+	// Number of picks which found every channel busy while the channel added
+	// to grow the pool was not READY yet, and waited for it instead of adding
+	// another channel.
+	CoalescedGrowths uint64 `json:"coalescedGrowths"`
+	// Number of calls made with the GCP interceptors waiting for a channel.
+	QueuedPicks int64 `json:"queuedPicks"`
+	// Number of new connections of the channels abandoned because they were
+	// not READY within ChannelPoolConfig.connect_timeout_ms.
+	ConnectTimeouts uint64 `json:"connectTimeouts"`
+	// Number of calls of the methods without a method config, e.g., methods
+	// added to the client library after the ApiConfig was written, see
+	// ChannelPoolConfig.unknown_method_policy.
+	UnknownMethodCalls uint64 `json:"unknownMethodCalls"`
+	// Number of affinity keys bound to their channel from the handover
+	// snapshot of the previous ClientConn, see Config.Handover.
+	HandoverKeys uint64 `json:"handoverKeys"`
+	// Number of connections of the channels which received a GOAWAY from the
+	// server, e.g., the Google Front End rotating its connections, detected
+	// from the calls rejected by the draining connection. The replacement
+	// connection of the channel is created right away.
+	GoAways uint64 `json:"goAways"`
+	// Number of READY connections of the channels closed without a GOAWAY
+	// detected, e.g., by network failures or a GOAWAY rejecting no call.
+	ConnectionLosses uint64 `json:"connectionLosses"`
+	// Number of times the pool was not grown because the ChannelBudget of
+	// the Config was exhausted, see Config.ChannelBudget.
+	BudgetDenials uint64 `json:"budgetDenials"`
+	// Number of calls finished on the channels by status code. See the
+	// ChannelSnapshot of GetAffinitySnapshot for the counts of every channel.
+	Calls CallCounts `json:"calls"`
+	// The active streams in percent of the streams the pool serves below the
+	// low watermark at max_size, see max_concurrent_streams_low_watermark.
+	// A saturation near 100 means new calls soon exceed the low watermark of
+	// every channel, see Config.SaturationWarningPercent.
+	SaturationPercent float64 `json:"saturationPercent"`
+	// The max concurrent streams of a channel observed over the last one to
+	// two Config.SaturationWindow, see ChannelStats.PeakStreams.
+	PeakStreams int32 `json:"peakStreams"`
+	// Number of errors reported by the name resolver. The pool keeps serving
+	// on its channels while the resolver fails.
+	ResolverErrors uint64 `json:"resolverErrors"`
+	// The last error of the name resolver while it fails, empty otherwise.
+	ResolverError string `json:"resolverError,omitempty"`
+	// Whether the pool is degraded, see Config.DegradedReadyRatio, or its
+	// name resolver fails.
+	Degraded bool `json:"degraded"`
+	// The picks of the pool in shadow mode, nil if the pool is not in shadow
+	// mode, see Config.ShadowPolicy.
+	Shadow *ShadowMetrics `json:"shadow,omitempty"`
+}
+
+// GetPoolMetrics returns the metrics of the channel pool of the ClientConn.
+// ErrBalancerNotFound is returned if the ClientConn does not use the grpc_gcp
+// balancer or no call was made on it with the GCP interceptors yet.
+func GetPoolMetrics(conn *grpc.ClientConn) (*PoolMetrics, error) {
+	gb, err := balancerForConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	return gb.poolMetrics(), nil
+}
+
+func (gb *gcpBalancer) poolMetrics() *PoolMetrics {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+
+	m := &PoolMetrics{
+		Channels:              len(gb.scRefs),
+		BoundKeys:             len(gb.affinityMap),
+		ResolverChurn:         atomic.LoadUint64(&gb.counters.resolverChurn),
+		AffinityMismatches:    atomic.LoadUint64(&gb.counters.affinityMismatches),
+		KeepaliveTimeouts:     atomic.LoadUint64(&gb.counters.keepaliveTimeouts),
+		KeepaliveTooManyPings: atomic.LoadUint64(&gb.counters.keepaliveTooManyPings),
+		Flaps:                 atomic.LoadUint64(&gb.counters.flaps),
+		RebalancedKeys:        atomic.LoadUint64(&gb.counters.rebalancedKeys),
+		CoalescedGrowths:      atomic.LoadUint64(&gb.counters.coalescedGrowths),
+		QueuedPicks:           atomic.LoadInt64(&gb.counters.queuedPicks),
+		ConnectTimeouts:       atomic.LoadUint64(&gb.counters.connectTimeouts),
+		UnknownMethodCalls:    atomic.LoadUint64(&gb.counters.unknownMethodCalls),
+		HandoverKeys:          atomic.LoadUint64(&gb.counters.handoverKeys),
+		GoAways:               atomic.LoadUint64(&gb.counters.goAways),
+		ConnectionLosses:      atomic.LoadUint64(&gb.counters.connectionLosses),
+		BudgetDenials:         atomic.LoadUint64(&gb.counters.budgetDenials),
+		ResolverErrors:        atomic.LoadUint64(&gb.counters.resolverErrors),
+		Degraded:              gb.degraded || gb.resolverErr != nil,
+	}
+	for sc, ref := range gb.scRefs {
+		if gb.scStates[sc] == connectivity.Ready {
+			m.ReadyChannels++
+		}
+		m.ActiveStreams += ref.getStreamsCnt()
+		if peak := ref.getPeakStreams(); peak > m.PeakStreams {
+			m.PeakStreams = peak
+		}
+		m.Calls = m.Calls.plus(ref.calls.load())
+	}
+	m.SaturationPercent, _ = gb.saturationPercentLocked()
+	if gb.resolverErr != nil {
+		m.ResolverError = gb.resolverErr.Error()
+	}
+	if gb.shadow != nil {
+		m.Shadow = gb.shadow.snapshot()
+	}
+	if delim := gb.affinityKeyPrefixDelimiter(); delim != "" {
+		m.BoundKeysByPrefix = map[string]int{}
+		for key := range gb.affinityMap {
+			m.BoundKeysByPrefix[affinityKeyPrefix(key, delim)]++
+		}
+	}
+	return m
+}
@@ -0,0 +1,198 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// Metric types of the pool gauges pushed by ExportPoolMetrics as custom
+// metrics of Cloud Monitoring. Every point has the "pool" metric label.
+const (
+	// MetricChannels is the number of channels in the pool.
+	MetricChannels = "custom.googleapis.com/grpc_gcp/channels"
+	// MetricReadyChannels is the number of READY channels in the pool.
+	MetricReadyChannels = "custom.googleapis.com/grpc_gcp/ready_channels"
+	// MetricActiveStreams is the number of calls in flight on the channels.
+	MetricActiveStreams = "custom.googleapis.com/grpc_gcp/active_streams"
+	// MetricBoundKeys is the number of affinity keys bound to the channels.
+	MetricBoundKeys = "custom.googleapis.com/grpc_gcp/bound_keys"
+	// MetricQueuedPicks is the number of calls waiting for a channel.
+	MetricQueuedPicks = "custom.googleapis.com/grpc_gcp/queued_picks"
+)
+
+// minExportInterval is the shortest interval of ExportPoolMetrics, the max
+// rate Cloud Monitoring accepts points of a time series at.
+const minExportInterval = 5 * time.Second
+
+// defaultExportInterval is the interval of ExportPoolMetrics if not set.
+const defaultExportInterval = time.Minute
+
+// MonitoredResource is the monitored resource of the pushed metrics, e.g.,
+// "generic_task" or "gce_instance" with its labels.
+type MonitoredResource struct {
+	Type   string
+	Labels map[string]string
+}
+
+// MetricPoint is a point of a pool gauge pushed by ExportPoolMetrics.
+type MetricPoint struct {
+	// The metric type, e.g., MetricReadyChannels.
+	Type string
+	// The metric labels.
+	Labels map[string]string
+	// The monitored resource of the point.
+	Resource MonitoredResource
+	// The time of the point.
+	Time time.Time
+	// The value of the gauge.
+	Value int64
+}
+
+// MetricWriter writes the points of the pool gauges, e.g., as a
+// CreateTimeSeriesRequest with one GAUGE INT64 time series per point using
+// the MetricClient of cloud.google.com/go/monitoring/apiv3.
+type MetricWriter interface {
+	WriteMetrics(ctx context.Context, points []MetricPoint) error
+}
+
+// MetricsExportOptions are the options of ExportPoolMetrics.
+type MetricsExportOptions struct {
+	// Writer pushes the points. Required.
+	Writer MetricWriter
+	// Interval between the pushes. Default value is 1 minute, it must be at
+	// least 5 seconds.
+	Interval time.Duration
+	// The monitored resource of the points. If Type is empty, a
+	// "generic_task" resource is used with the labels set here and the
+	// missing ones defaulting to: project_id from the GOOGLE_CLOUD_PROJECT
+	// environment variable, location "global", namespace "grpc_gcp", job the
+	// name of the executable and task_id the hostname and the process id.
+	Resource MonitoredResource
+	// The value of the "pool" metric label telling the pools of a process
+	// apart. Default value is the target of the ClientConn.
+	Pool string
+}
+
+// ExportPoolMetrics periodically pushes the gauges of the channel pool of the
+// ClientConn, see the Metric constants, with the writer, e.g., to Cloud
+// Monitoring for deployments without Prometheus. The pushes stop when the ctx
+// is done or the ClientConn is closed. Failed pushes are logged and the next
+// push is made at the next interval. ErrBalancerNotFound is returned if the
+// ClientConn does not use the grpc_gcp balancer or no call was made on it with
+// the GCP interceptors yet.
+func ExportPoolMetrics(ctx context.Context, conn *grpc.ClientConn, opts MetricsExportOptions) error {
+	if opts.Writer == nil {
+		return fmt.Errorf("grpcgcp: no Writer in the MetricsExportOptions")
+	}
+	if opts.Interval == 0 {
+		opts.Interval = defaultExportInterval
+	}
+	if opts.Interval < minExportInterval {
+		return fmt.Errorf("grpcgcp: metrics export Interval (%v) is shorter than %v", opts.Interval, minExportInterval)
+	}
+	gb, err := balancerForConn(conn)
+	if err != nil {
+		return err
+	}
+	if opts.Pool == "" {
+		opts.Pool = conn.Target()
+	}
+	opts.Resource = withDefaultResource(opts.Resource)
+	go gb.exportMetrics(ctx, opts)
+	return nil
+}
+
+// exportMetrics pushes the pool gauges every opts.Interval until the ctx is
+// done or the balancer is closed.
+func (gb *gcpBalancer) exportMetrics(ctx context.Context, opts MetricsExportOptions) {
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-gb.done:
+			return
+		case now := <-ticker.C:
+			wctx, cancel := context.WithTimeout(ctx, opts.Interval)
+			if err := opts.Writer.WriteMetrics(wctx, gb.metricPoints(opts, now)); err != nil {
+				gb.log.Warningf("failed to push the pool metrics: %v", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// metricPoints returns the points of the pool gauges at the time.
+func (gb *gcpBalancer) metricPoints(opts MetricsExportOptions, now time.Time) []MetricPoint {
+	m := gb.poolMetrics()
+	gauges := []struct {
+		typ   string
+		value int64
+	}{
+		{MetricChannels, int64(m.Channels)},
+		{MetricReadyChannels, int64(m.ReadyChannels)},
+		{MetricActiveStreams, int64(m.ActiveStreams)},
+		{MetricBoundKeys, int64(m.BoundKeys)},
+		{MetricQueuedPicks, m.QueuedPicks},
+	}
+	points := make([]MetricPoint, 0, len(gauges))
+	for _, g := range gauges {
+		points = append(points, MetricPoint{
+			Type:     g.typ,
+			Labels:   map[string]string{"pool": opts.Pool},
+			Resource: opts.Resource,
+			Time:     now,
+			Value:    g.value,
+		})
+	}
+	return points
+}
+
+// withDefaultResource returns the resource or a "generic_task" resource with
+// the default labels if the resource has no type.
+func withDefaultResource(r MonitoredResource) MonitoredResource {
+	if r.Type != "" {
+		return r
+	}
+	host, _ := os.Hostname()
+	defaults := map[string]string{
+		"project_id": os.Getenv("GOOGLE_CLOUD_PROJECT"),
+		"location":   "global",
+		"namespace":  "grpc_gcp",
+		"job":        filepath.Base(os.Args[0]),
+		"task_id":    host + "-" + strconv.Itoa(os.Getpid()),
+	}
+	res := MonitoredResource{Type: "generic_task", Labels: make(map[string]string, len(defaults))}
+	for k, v := range defaults {
+		res.Labels[k] = v
+	}
+	for k, v := range r.Labels {
+		res.Labels[k] = v
+	}
+	return res
+}
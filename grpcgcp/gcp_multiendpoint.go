@@ -23,12 +23,16 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/multiendpoint"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/grpclog"
-	"google.golang.org/protobuf/encoding/protojson"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 
 	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
@@ -117,9 +121,14 @@ type GCPMultiEndpoint struct {
 	mes         map[string]multiendpoint.MultiEndpoint
 	pools       map[string]*monitoredConn
 	opts        []grpc.DialOption
+	standbyOpts []grpc.DialOption
 	gcpConfig   *pb.ApiConfig
 	dialFunc    func(ctx context.Context, target string, dopts ...grpc.DialOption) (*grpc.ClientConn, error)
 	log         grpclog.LoggerV2
+	// The interval and the probe of the endpoint latencies, see
+	// GCPMultiEndpointOptions.LatencyProbeInterval.
+	probeInterval time.Duration
+	probe         func(ctx context.Context, conn *grpc.ClientConn) error
 
 	grpc.ClientConnInterface
 }
@@ -174,6 +183,57 @@ type GCPMultiEndpointOptions struct {
 	Default string
 	// Func to dial grpc ClientConn.
 	DialFunc func(ctx context.Context, target string, dopts ...grpc.DialOption) (*grpc.ClientConn, error)
+	// Options specific to an endpoint where key is the endpoint. Optional.
+	EndpointOptions map[string]*EndpointOptions
+	// If not zero, the pool of an endpoint that is not the top priority
+	// endpoint of any MultiEndpoint is a warm standby pool: it keeps this
+	// number of channels connected at all times instead of the min_size of
+	// GRPCgcpConfig, so that a failover to the endpoint only switches the
+	// pool used for calls without waiting for new connections. The pool still
+	// grows up to the max_size once calls are sent over it. The number is
+	// capped by the max_size. Endpoints are classified when their pools are
+	// created, see UpdateMultiEndpoints. Optional.
+	StandbyPoolSize uint32
+	// If not zero, the latency of every endpoint with a READY pool is probed
+	// with this interval and reported to the MultiEndpoints as a moving
+	// average, see multiendpoint.MultiEndpointOptions.PreferLowestLatency.
+	// Optional.
+	LatencyProbeInterval time.Duration
+	// The probe timed as the round-trip time of an endpoint. The default probe
+	// is a grpc.health.v1.Health/Check call, which completes the round trip
+	// with any response, including an error status such as UNIMPLEMENTED.
+	// Probes failed with UNAVAILABLE, DEADLINE_EXCEEDED or CANCELLED are not
+	// reported. Optional.
+	LatencyProbe func(ctx context.Context, conn *grpc.ClientConn) error
+}
+
+// EndpointOptions holds options of the connection pool of a single endpoint,
+// e.g., a private or DirectPath endpoint may need different TLS and authority
+// settings than the public endpoint.
+type EndpointOptions struct {
+	// Transport credentials for the endpoint overriding the transport
+	// credentials from the dial options.
+	TransportCredentials credentials.TransportCredentials
+	// Value of the :authority pseudo-header for the calls to the endpoint.
+	Authority string
+	// Dial options for the endpoint applied after the common dial options.
+	DialOptions []grpc.DialOption
+}
+
+// dialOptions returns dial options for the endpoint on top of the common
+// dial options opts.
+func (eo *EndpointOptions) dialOptions(opts []grpc.DialOption) []grpc.DialOption {
+	if eo == nil {
+		return opts
+	}
+	o := append([]grpc.DialOption{}, opts...)
+	if eo.TransportCredentials != nil {
+		o = append(o, grpc.WithTransportCredentials(eo.TransportCredentials))
+	}
+	if eo.Authority != "" {
+		o = append(o, grpc.WithAuthority(eo.Authority))
+	}
+	return append(o, eo.DialOptions...)
 }
 
 // NewGCPMultiEndpoint creates new [GCPMultiEndpoint] -- MultiEndpoints-enabled gRPC client
@@ -195,14 +255,25 @@ func NewGCPMultiEndpoint(meOpts *GCPMultiEndpointOptions, opts ...grpc.DialOptio
 	if err != nil {
 		return nil, err
 	}
+	standbyOpts, err := makeStandbyOpts(meOpts, opts)
+	if err != nil {
+		return nil, err
+	}
 	gme := &GCPMultiEndpoint{
 		mes:         make(map[string]multiendpoint.MultiEndpoint),
 		pools:       make(map[string]*monitoredConn),
 		defaultName: meOpts.Default,
 		opts:        o,
+		standbyOpts: standbyOpts,
 		gcpConfig:   proto.Clone(meOpts.GRPCgcpConfig).(*pb.ApiConfig),
 		dialFunc:    meOpts.DialFunc,
 		log:         NewGCPLogger(compLogger, fmt.Sprintf("[GCPMultiEndpoint #%d]", atomic.AddUint32(&gmeCounter, 1))),
+
+		probeInterval: meOpts.LatencyProbeInterval,
+		probe:         meOpts.LatencyProbe,
+	}
+	if gme.probe == nil {
+		gme.probe = healthCheckProbe
 	}
 	if gme.dialFunc == nil {
 		gme.dialFunc = func(_ context.Context, target string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
@@ -216,26 +287,44 @@ func NewGCPMultiEndpoint(meOpts *GCPMultiEndpointOptions, opts ...grpc.DialOptio
 }
 
 func makeOpts(meOpts *GCPMultiEndpointOptions, opts []grpc.DialOption) ([]grpc.DialOption, error) {
-	grpcGCPjsonConfig, err := protojson.Marshal(meOpts.GRPCgcpConfig)
+	gcpOpts, err := WithDefaults(meOpts.GRPCgcpConfig)
 	if err != nil {
 		return nil, err
 	}
 	o := append([]grpc.DialOption{}, opts...)
-	o = append(o, []grpc.DialOption{
-		grpc.WithDisableServiceConfig(),
-		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s":%s}]}`, Name, string(grpcGCPjsonConfig))),
-		grpc.WithChainUnaryInterceptor(GCPUnaryClientInterceptor),
-		grpc.WithChainStreamInterceptor(GCPStreamClientInterceptor),
-	}...)
+	o = append(o, gcpOpts...)
 
 	return o, nil
 }
 
+// makeStandbyOpts returns the dial options for warm standby pools or nil if
+// the StandbyPoolSize is not set.
+func makeStandbyOpts(meOpts *GCPMultiEndpointOptions, opts []grpc.DialOption) ([]grpc.DialOption, error) {
+	size := meOpts.StandbyPoolSize
+	if size == 0 {
+		return nil, nil
+	}
+	cfg := &pb.ApiConfig{}
+	if meOpts.GRPCgcpConfig != nil {
+		cfg = proto.Clone(meOpts.GRPCgcpConfig).(*pb.ApiConfig)
+	}
+	if cfg.GetChannelPool() == nil {
+		cfg.ChannelPool = &pb.ChannelPoolConfig{}
+	}
+	if max := cfg.GetChannelPool().GetMaxSize(); max > 0 && size > max {
+		size = max
+	}
+	cfg.GetChannelPool().MinSize = size
+	return makeOpts(&GCPMultiEndpointOptions{GRPCgcpConfig: cfg}, opts)
+}
+
 type monitoredConn struct {
 	endpoint string
 	conn     *grpc.ClientConn
 	gme      *GCPMultiEndpoint
 	cancel   context.CancelFunc
+	// Moving average of the probed round-trip time in nanoseconds.
+	latency ewma
 }
 
 func newMonitoredConn(endpoint string, conn *grpc.ClientConn, gme *GCPMultiEndpoint) (mc *monitoredConn) {
@@ -247,6 +336,9 @@ func newMonitoredConn(endpoint string, conn *grpc.ClientConn, gme *GCPMultiEndpo
 		cancel:   cancel,
 	}
 	go mc.monitor(ctx)
+	if gme.probeInterval > 0 {
+		go mc.probeLatency(ctx)
+	}
 	return
 }
 
@@ -272,6 +364,52 @@ func (mc *monitoredConn) monitor(ctx context.Context) {
 	}
 }
 
+// probeLatency probes the round-trip time of the endpoint every probeInterval
+// while its pool is READY and reports the moving average to all
+// multiendpoints.
+func (mc *monitoredConn) probeLatency(ctx context.Context) {
+	ticker := time.NewTicker(mc.gme.probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if mc.conn.GetState() != connectivity.Ready {
+			continue
+		}
+		pctx, cancel := context.WithTimeout(ctx, mc.gme.probeInterval)
+		start := time.Now()
+		err := mc.gme.probe(pctx, mc.conn)
+		rtt := time.Since(start)
+		cancel()
+		switch status.Code(err) {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled:
+			if mc.gme.log.V(FINE) {
+				mc.gme.log.Infof("latency probe of %q endpoint failed: %v", mc.endpoint, err)
+			}
+			continue
+		}
+		mc.latency.add(float64(rtt))
+		latency := time.Duration(mc.latency.value())
+		if mc.gme.log.V(FINEST) {
+			mc.gme.log.Infof("%q endpoint latency is %v, probed %v", mc.endpoint, latency, rtt)
+		}
+		mc.gme.mu.RLock()
+		for _, me := range mc.gme.mes {
+			me.SetEndpointLatency(mc.endpoint, latency)
+		}
+		mc.gme.mu.RUnlock()
+	}
+}
+
+// healthCheckProbe is the default latency probe, see
+// GCPMultiEndpointOptions.LatencyProbe.
+func healthCheckProbe(ctx context.Context, conn *grpc.ClientConn) error {
+	return conn.Invoke(ctx, "/grpc.health.v1.Health/Check", &healthpb.HealthCheckRequest{}, &healthpb.HealthCheckResponse{})
+}
+
 func (mc *monitoredConn) stopMonitoring() {
 	mc.cancel()
 }
@@ -292,7 +430,8 @@ func (mc *monitoredConn) stopMonitoring() {
 //     connection poll for this endpoint will be shutdown.
 //   - A connection pool will be created for every new endpoint.
 //   - For an existing endpoint nothing will change (the connection pool will not be re-created,
-//     thus no connection credentials change, nor connection configuration change).
+//     thus no connection credentials change, nor connection configuration change). Thus,
+//     [GCPMultiEndpointOptions.EndpointOptions] are only applied to new endpoints.
 func (gme *GCPMultiEndpoint) UpdateMultiEndpoints(meOpts *GCPMultiEndpointOptions) error {
 	gme.mu.Lock()
 	defer gme.mu.Unlock()
@@ -301,22 +440,34 @@ func (gme *GCPMultiEndpoint) UpdateMultiEndpoints(meOpts *GCPMultiEndpointOption
 	}
 
 	validPools := make(map[string]bool)
+	topPriority := make(map[string]bool)
 	for _, meo := range meOpts.MultiEndpoints {
 		for _, e := range meo.Endpoints {
 			validPools[e] = true
 		}
+		if len(meo.Endpoints) > 0 {
+			topPriority[meo.Endpoints[0]] = true
+		}
 	}
 
 	// Add missing pools.
 	for e := range validPools {
 		if _, ok := gme.pools[e]; !ok {
+			opts, standby := gme.opts, gme.standbyOpts != nil && !topPriority[e]
+			if standby {
+				opts = gme.standbyOpts
+			}
 			// This creates a ClientConn with the gRPC-GCP balancer managing connection pool.
-			conn, err := gme.dialFunc(context.Background(), e, gme.opts...)
+			conn, err := gme.dialFunc(context.Background(), e, meOpts.EndpointOptions[e].dialOptions(opts)...)
 			if err != nil {
 				return err
 			}
 			if gme.log.V(FINE) {
-				gme.log.Infof("created new channel pool for %q endpoint.", e)
+				if standby {
+					gme.log.Infof("created new warm standby channel pool for %q endpoint.", e)
+				} else {
+					gme.log.Infof("created new channel pool for %q endpoint.", e)
+				}
 			}
 			gme.pools[e] = newMonitoredConn(e, conn, gme)
 		}
@@ -0,0 +1,114 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+type contextNamespaceKey int
+
+var namespaceCtxKey contextNamespaceKey
+
+// namespaceSeparator separates the namespace from the affinity key. Namespaces
+// must not contain it, so keys of different namespaces never collide.
+const namespaceSeparator = "\x00"
+
+// WithAffinityNamespace returns a new Context scoping the affinity keys of the
+// calls made with it to the namespace, e.g., the name of one of several
+// logical clients sharing a ClientConn. The keys from the messages and from
+// WithChannelAffinity are bound and looked up as NamespacedAffinityKey(ns,
+// key), so equal keys of different namespaces are bound independently. An
+// empty namespace is the default namespace of the calls without one. The
+// namespace must not contain a NUL character.
+func WithAffinityNamespace(ctx context.Context, ns string) context.Context {
+	return context.WithValue(ctx, namespaceCtxKey, ns)
+}
+
+// AffinityNamespaceFromContext returns the affinity namespace stored in ctx,
+// if any.
+func AffinityNamespaceFromContext(ctx context.Context) (string, bool) {
+	ns, ok := ctx.Value(namespaceCtxKey).(string)
+	return ns, ok
+}
+
+// NamespacedAffinityKey returns the key the affinity key of a call in the
+// namespace is bound as, e.g., for ReleaseChannelAffinity and in the
+// callbacks of the Config. It returns the key unchanged for the empty
+// namespace.
+func NamespacedAffinityKey(ns, key string) string {
+	if ns == "" {
+		return key
+	}
+	return ns + namespaceSeparator + key
+}
+
+// NamespaceUnaryClientInterceptor returns a unary client interceptor scoping
+// the affinity keys of the calls to the namespace, see WithAffinityNamespace,
+// e.g., for the interceptor chain of one logical client. It does not override
+// the namespace of the Context.
+func NamespaceUnaryClientInterceptor(ns string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(withDefaultNamespace(ctx, ns), method, req, reply, cc, opts...)
+	}
+}
+
+// NamespaceStreamClientInterceptor returns a stream client interceptor scoping
+// the affinity keys of the streams to the namespace, see
+// NamespaceUnaryClientInterceptor.
+func NamespaceStreamClientInterceptor(ns string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(withDefaultNamespace(ctx, ns), desc, cc, method, opts...)
+	}
+}
+
+// withDefaultNamespace returns the ctx with the namespace unless it has one.
+func withDefaultNamespace(ctx context.Context, ns string) context.Context {
+	if _, ok := AffinityNamespaceFromContext(ctx); ok {
+		return ctx
+	}
+	return WithAffinityNamespace(ctx, ns)
+}
+
+// namespaceKeys scopes the affinity keys of a call made with the ctx to its
+// namespace, if any. The keys are modified in place.
+func namespaceKeys(ctx context.Context, keys []string) []string {
+	ns, _ := AffinityNamespaceFromContext(ctx)
+	if ns == "" {
+		return keys
+	}
+	for i, k := range keys {
+		keys[i] = NamespacedAffinityKey(ns, k)
+	}
+	return keys
+}
+
+// scopedContextAffinityKey returns the affinity key set with
+// WithChannelAffinity in the ctx, normalized, see Config.NormalizeAffinityKey,
+// and scoped to the namespace of the ctx, if any.
+func (gb *gcpBalancer) scopedContextAffinityKey(ctx context.Context) (string, bool) {
+	key, ok := ChannelAffinityFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	ns, _ := AffinityNamespaceFromContext(ctx)
+	return NamespacedAffinityKey(ns, gb.normalizeKey(key)), true
+}
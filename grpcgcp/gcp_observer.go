@@ -0,0 +1,66 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+// BalancerInfo provides the introspection of a grpc_gcp balancer instance.
+type BalancerInfo interface {
+	// ApiConfig returns a copy of the effective api configuration of the
+	// balancer or nil if the balancer did not receive its configuration yet.
+	ApiConfig() *pb.ApiConfig
+	// PoolMetrics returns the metrics of the channel pool.
+	PoolMetrics() *PoolMetrics
+	// AffinitySnapshot returns a snapshot of the affinity bindings.
+	AffinitySnapshot() *AffinitySnapshot
+}
+
+// BalancerObserver is notified when the balancers built by a builder with the
+// observer in its Config are built and closed, e.g., to inspect the balancer of
+// a ClientConn in tests without a call made on the ClientConn. Every balancer
+// reports to the observer of its own builder only, so clients with different
+// builders do not observe each other's balancers.
+type BalancerObserver interface {
+	// BalancerBuilt is called when a balancer is built.
+	BalancerBuilt(b BalancerInfo)
+	// BalancerClosed is called when a balancer is closed.
+	BalancerClosed(b BalancerInfo)
+}
+
+func (gb *gcpBalancer) ApiConfig() *pb.ApiConfig {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+	cfg := gb.config()
+	if cfg == nil {
+		return nil
+	}
+	return proto.Clone(cfg.ApiConfig).(*pb.ApiConfig)
+}
+
+func (gb *gcpBalancer) PoolMetrics() *PoolMetrics {
+	return gb.poolMetrics()
+}
+
+func (gb *gcpBalancer) AffinitySnapshot() *AffinitySnapshot {
+	return gb.affinitySnapshot()
+}
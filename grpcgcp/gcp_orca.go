@@ -0,0 +1,117 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"math"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// orcaTrailerKey is the trailer with the ORCA per-call load report, a
+// serialized xds.data.orca.v3.OrcaLoadReport.
+const orcaTrailerKey = "endpoint-load-metrics-bin"
+
+// Field numbers of xds.data.orca.v3.OrcaLoadReport. The report is decoded
+// without the generated types to avoid the dependency on the xDS protos.
+const (
+	orcaCPUUtilizationField         = 1
+	orcaApplicationUtilizationField = 9
+)
+
+// serverUtilization returns the backend utilization reported by the server of
+// the finished call, if any. The application utilization takes precedence over
+// the CPU utilization. The load report parsed by gRPC is used if the
+// application imports the orca package, otherwise the trailer is decoded.
+func serverUtilization(info balancer.DoneInfo) (float64, bool) {
+	if l, ok := info.ServerLoad.(interface{ GetApplicationUtilization() float64 }); ok && l.GetApplicationUtilization() > 0 {
+		return l.GetApplicationUtilization(), true
+	}
+	if l, ok := info.ServerLoad.(interface{ GetCpuUtilization() float64 }); ok {
+		return l.GetCpuUtilization(), true
+	}
+	vals := info.Trailer.Get(orcaTrailerKey)
+	if len(vals) == 0 {
+		return 0, false
+	}
+	return parseOrcaUtilization([]byte(vals[0]))
+}
+
+// parseOrcaUtilization decodes the utilization from a serialized
+// OrcaLoadReport.
+func parseOrcaUtilization(b []byte) (float64, bool) {
+	var cpu, app float64
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, false
+		}
+		b = b[n:]
+		if typ == protowire.Fixed64Type && (num == orcaCPUUtilizationField || num == orcaApplicationUtilizationField) {
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return 0, false
+			}
+			b = b[n:]
+			if num == orcaCPUUtilizationField {
+				cpu = math.Float64frombits(v)
+			} else {
+				app = math.Float64frombits(v)
+			}
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return 0, false
+		}
+		b = b[n:]
+	}
+	if app > 0 {
+		return app, true
+	}
+	return cpu, true
+}
+
+// utilizationScore returns the expected utilization of the backend of the
+// channel with a new call based on the recent utilization reports and the
+// active streams. Channels without reports score 0 and are tried first.
+func (ref *subConnRef) utilizationScore(streams int32) float64 {
+	return ref.utilization.value() * float64(streams+1)
+}
+
+// getLeastUtilizedSubConnRef returns the subConnRef with the best utilization
+// score among the subConnRefs with capacity, see hasCapacity. At least one
+// such subConnRef must exist.
+func (p *gcpPicker) getLeastUtilizedSubConnRef(mp *methodPool, maxStreams int32) *subConnRef {
+	var bestScRef *subConnRef
+	var bestScore float64
+	var bestStreamsCnt int32
+	for _, scRef := range p.scRefs {
+		if !hasCapacity(scRef, mp, maxStreams) {
+			continue
+		}
+		streamsCnt := scRef.getMethodStreamsCnt(mp)
+		score := scRef.utilizationScore(streamsCnt)
+		if bestScRef == nil || score < bestScore || (score == bestScore && streamsCnt < bestStreamsCnt) {
+			bestScRef, bestScore, bestStreamsCnt = scRef, score, streamsCnt
+		}
+	}
+	return bestScRef
+}
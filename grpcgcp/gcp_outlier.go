@@ -0,0 +1,159 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+)
+
+const (
+	defaultMaxEjectionPercent       = 50
+	defaultErrorPercentageOverPeers = 10
+)
+
+// outlierCandidate is a channel evaluated by the outlier detection.
+type outlierCandidate struct {
+	ref    *subConnRef
+	errPct float64
+	byCode CallCounts
+}
+
+// countCall counts a finished call, its status code class and whether it
+// failed for the outlier detection.
+func (ref *subConnRef) countCall(class callClass, failed bool) {
+	ref.odClasses.add(class)
+	atomic.AddUint32(&ref.odCalls, 1)
+	if failed {
+		atomic.AddUint32(&ref.odErrors, 1)
+	}
+}
+
+// resetCallsCount resets the outlier detection counters and returns their values.
+func (ref *subConnRef) resetCallsCount() (calls, errors uint32, byCode CallCounts) {
+	return atomic.SwapUint32(&ref.odCalls, 0), atomic.SwapUint32(&ref.odErrors, 0), ref.odClasses.swap()
+}
+
+// isEjected reports whether the subConnRef is ejected by the outlier detection.
+// Must be called holding the balancer mutex lock.
+func (ref *subConnRef) isEjected() bool {
+	return !ref.ejectedUntil.IsZero()
+}
+
+// startOutlierDetection starts periodic outlier detection if it is enabled.
+func (gb *gcpBalancer) startOutlierDetection() {
+	od := gb.config().GetChannelPool().GetOutlierDetection()
+	if od.GetIntervalMs() == 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(od.GetIntervalMs()) * time.Millisecond)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-gb.done:
+				return
+			case <-ticker.C:
+				gb.detectOutliers()
+			}
+		}
+	}()
+}
+
+// detectOutliers evaluates the channels, lifts expired ejections and ejects
+// channels with outstanding error rate.
+func (gb *gcpBalancer) detectOutliers() {
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+
+	od := gb.config().GetChannelPool().GetOutlierDetection()
+	now := time.Now()
+	changed := false
+	ejected := 0
+	candidates := []outlierCandidate{}
+	var errPctSum float64
+	for _, ref := range gb.scRefList {
+		calls, errors, byCode := ref.resetCallsCount()
+		if ref.isEjected() {
+			if now.Before(ref.ejectedUntil) {
+				ejected++
+				continue
+			}
+			ref.ejectedUntil = time.Time{}
+			changed = true
+			if gb.log.V(FINE) {
+				gb.log.Infof("outlier detection: lifted ejection of channel %s", ref.label)
+			}
+		}
+		if gb.scStates[ref.subConn] != connectivity.Ready || calls == 0 || calls < od.GetMinCalls() {
+			continue
+		}
+		errPct := 100 * float64(errors) / float64(calls)
+		errPctSum += errPct
+		candidates = append(candidates, outlierCandidate{ref: ref, errPct: errPct, byCode: byCode})
+	}
+
+	maxPct := od.GetMaxEjectionPercent()
+	if maxPct == 0 {
+		maxPct = defaultMaxEjectionPercent
+	}
+	maxEjected := len(gb.scRefList) * int(maxPct) / 100
+	overPeers := od.GetErrorPercentageOverPeers()
+	if overPeers == 0 {
+		overPeers = defaultErrorPercentageOverPeers
+	}
+
+	// Evaluate the worst channels first.
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].errPct > candidates[j].errPct
+	})
+	for _, c := range candidates {
+		// The rest of the candidates have no errors either.
+		if ejected >= maxEjected || c.errPct == 0 {
+			break
+		}
+		peersAvg := float64(0)
+		if len(candidates) > 1 {
+			peersAvg = (errPctSum - c.errPct) / float64(len(candidates)-1)
+		}
+		if c.errPct-peersAvg <= float64(overPeers) {
+			break
+		}
+		gb.log.Warningf(
+			"outlier detection: ejecting channel %s with %.1f%% errors (%v) while peers have %.1f%% on average",
+			c.ref.label, c.errPct, c.byCode, peersAvg,
+		)
+		c.ref.ejectedUntil = now.Add(time.Duration(od.GetEjectionTimeMs()) * time.Millisecond)
+		ejected++
+		changed = true
+		gb.refreshLocked(c.ref)
+	}
+
+	if changed {
+		gb.regeneratePicker()
+		gb.cc.UpdateState(balancer.State{
+			ConnectivityState: gb.state,
+			Picker:            gb.picker,
+		})
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/stats"
+)
+
+// connInfo describes the connection of a channel as seen by its calls.
+type connInfo struct {
+	network        string
+	remoteAddr     string
+	localAddr      string
+	authType       string
+	tlsVersion     string
+	tlsCipherSuite string
+	tlsServerName  string
+	tlsResumed     bool
+	// Duration of the handshake, 0 if not measured, see MeasureHandshakes.
+	handshake time.Duration
+}
+
+// newConnInfo returns the connection details from the outgoing header of a
+// call and the auth info of its peer, if any.
+func newConnInfo(h *stats.OutHeader, authInfo credentials.AuthInfo) *connInfo {
+	ci := &connInfo{}
+	if h.RemoteAddr != nil {
+		ci.network = h.RemoteAddr.Network()
+		ci.remoteAddr = h.RemoteAddr.String()
+	}
+	if h.LocalAddr != nil {
+		ci.localAddr = h.LocalAddr.String()
+	}
+	ci.handshake, _ = handshakes.duration(h.LocalAddr, h.RemoteAddr)
+	if authInfo != nil {
+		ci.authType = authInfo.AuthType()
+	}
+	if t, ok := authInfo.(credentials.TLSInfo); ok {
+		ci.tlsVersion = tlsVersionName(t.State.Version)
+		ci.tlsCipherSuite = tls.CipherSuiteName(t.State.CipherSuite)
+		ci.tlsServerName = t.State.ServerName
+		ci.tlsResumed = t.State.DidResume
+	}
+	return ci
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04X", v)
+}
+
+// setConnInfo records the connection details of the current connection of the
+// subconn.
+func (ref *subConnRef) setConnInfo(ci *connInfo) {
+	if old := ref.getConnInfo(); old != nil && *old == *ci {
+		return
+	}
+	ref.connInfo.Store(ci)
+}
+
+// getConnInfo returns the connection details of the current connection of the
+// subconn or nil if unknown.
+func (ref *subConnRef) getConnInfo() *connInfo {
+	ci, _ := ref.connInfo.Load().(*connInfo)
+	return ci
+}
+
+// resetConnInfo forgets the connection details when the connection of the
+// subconn is replaced.
+func (ref *subConnRef) resetConnInfo() {
+	ref.connInfo.Store((*connInfo)(nil))
+}
@@ -0,0 +1,234 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+// PickReason is the reason a channel was picked for a call.
+type PickReason int
+
+const (
+	// PickLeastBusy is the least busy channel, or the channel chosen by the
+	// pick_strategy of the channel pool config, for a call without a bound
+	// affinity key.
+	PickLeastBusy PickReason = iota
+	// PickBound is the channel the affinity key of the call is bound to.
+	PickBound
+	// PickFallback is a ready channel used because the channel the affinity
+	// key is bound to is not ready, see fallback_to_ready.
+	PickFallback
+	// PickOverflow is a less busy channel used because the channel the
+	// affinity key is bound to is busy, see overflow_when_busy.
+	PickOverflow
+	// PickRecentlyUnbound is the channel of a recently unbound affinity key,
+	// see unbind_grace_period_ms.
+	PickRecentlyUnbound
+	// PickRoundRobin is the next channel of a BIND call with the ROUND_ROBIN
+	// bind_pick_strategy.
+	PickRoundRobin
+	// PickPinned is the channel pinned with PinChannel.
+	PickPinned
+	// PickContextAffinity is the channel of the affinity key of
+	// WithChannelAffinity.
+	PickContextAffinity
+)
+
+func (r PickReason) String() string {
+	switch r {
+	case PickLeastBusy:
+		return "least-busy"
+	case PickBound:
+		return "bound"
+	case PickFallback:
+		return "fallback"
+	case PickOverflow:
+		return "overflow"
+	case PickRecentlyUnbound:
+		return "recently-unbound"
+	case PickRoundRobin:
+		return "round-robin"
+	case PickPinned:
+		return "pinned"
+	case PickContextAffinity:
+		return "context-affinity"
+	}
+	return fmt.Sprintf("PickReason(%d)", int(r))
+}
+
+// MarshalText encodes the reason as its name, e.g., in JSON.
+func (r PickReason) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// PickDecision describes the channel picked for a call, see GetPickDecisions.
+type PickDecision struct {
+	// Time of the pick.
+	Time time.Time `json:"time"`
+	// Full method name of the call.
+	Method string `json:"method"`
+	// Hash of the affinity key of the call, if any, see AffinityKeyHash.
+	KeyHash string `json:"keyHash,omitempty"`
+	// Index of the picked channel in the pool.
+	ChannelIndex int `json:"channelIndex"`
+	// Label of the picked channel, see Config.ChannelLabel.
+	ChannelLabel string `json:"channelLabel"`
+	// Why the channel was picked.
+	Reason PickReason `json:"reason"`
+	// How long the call waited for a channel before the pick.
+	QueueTime time.Duration `json:"queueTime"`
+	// Whether the affinity key of the call is bound to a channel, the picked
+	// one or, for PickFallback and PickOverflow, another one.
+	KeyBound bool `json:"keyBound"`
+}
+
+// pickAudit is a ring buffer of the last pick decisions.
+type pickAudit struct {
+	mu   sync.Mutex
+	buf  []PickDecision
+	next int
+	full bool
+}
+
+func newPickAudit(size uint32) *pickAudit {
+	if size == 0 {
+		return nil
+	}
+	return &pickAudit{buf: make([]PickDecision, size)}
+}
+
+// record adds the decision replacing the oldest one if the buffer is full.
+func (a *pickAudit) record(d PickDecision) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.buf[a.next] = d
+	a.next++
+	if a.next == len(a.buf) {
+		a.next = 0
+		a.full = true
+	}
+}
+
+// decisions returns the recorded decisions from the oldest to the newest.
+func (a *pickAudit) decisions() []PickDecision {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.full {
+		return append([]PickDecision{}, a.buf[:a.next]...)
+	}
+	return append(append([]PickDecision{}, a.buf[a.next:]...), a.buf[:a.next]...)
+}
+
+// PickDecisionFromContext returns the pick decision of the latest attempt of
+// the call made with the ctx, e.g., for a stats.Handler to report the channel
+// of every call from the stats.OutHeader and later events, or for an
+// interceptor chained after the GCP interceptors once the call returns. It
+// requires the GCP interceptors and reports false until a channel of the pool
+// is picked for the call, e.g., in stats.Begin, and for the calls sent over
+// the control channel.
+func PickDecisionFromContext(ctx context.Context) (PickDecision, bool) {
+	gcpCtx, ok := ctx.Value(gcpKey).(*gcpContext)
+	if !ok {
+		return PickDecision{}, false
+	}
+	d, ok := gcpCtx.pick.Load().(*PickDecision)
+	if !ok {
+		return PickDecision{}, false
+	}
+	return *d, true
+}
+
+// GetPickDecisions returns the last pick decisions of the ClientConn, from
+// the oldest to the newest, recorded if pick_audit_size of the channel pool
+// config is set. ErrBalancerNotFound is returned if the ClientConn does not
+// use the grpc_gcp balancer or no call was made on it with the GCP
+// interceptors yet.
+func GetPickDecisions(conn *grpc.ClientConn) ([]PickDecision, error) {
+	gb, err := balancerForConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	if gb.pickAudit == nil {
+		return nil, fmt.Errorf("grpcgcp: pick decisions are not recorded, set pick_audit_size of the channel pool config")
+	}
+	return gb.pickAudit.decisions(), nil
+}
+
+// auditPick records the decision of the pick of scRef for the call.
+func (p *gcpPicker) pickDecision(ctx context.Context, method, boundKey string, cmd pb.AffinityConfig_Command, overflow bool, scRef *subConnRef, queued time.Duration) PickDecision {
+	d := PickDecision{
+		Time:         time.Now(),
+		Method:       method,
+		ChannelIndex: scRef.id,
+		ChannelLabel: scRef.label,
+		QueueTime:    queued,
+	}
+	key := boundKey
+	_, pinned := PinnedChannelFromContext(ctx)
+	ctxKey, hasCtxKey := p.gb.scopedContextAffinityKey(ctx)
+	switch {
+	case pinned:
+		d.Reason = PickPinned
+	case hasCtxKey:
+		d.Reason = PickContextAffinity
+		key = ctxKey
+	case cmd == pb.AffinityConfig_BIND && p.cfg.GetChannelPool().GetBindPickStrategy() == pb.ChannelPoolConfig_ROUND_ROBIN:
+		d.Reason = PickRoundRobin
+	case boundKey != "":
+		d.Reason = p.gb.boundPickReason(boundKey, overflow, scRef)
+	}
+	if key != "" {
+		d.KeyHash = AffinityKeyHash(key)
+	}
+	switch d.Reason {
+	case PickBound, PickFallback, PickOverflow, PickContextAffinity:
+		d.KeyBound = true
+	}
+	return d
+}
+
+// boundPickReason returns why scRef was picked for a call with the affinity
+// key.
+func (gb *gcpBalancer) boundPickReason(key string, overflow bool, scRef *subConnRef) PickReason {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+	if sc, ok := gb.affinityMap[key]; ok {
+		switch {
+		case sc == scRef.subConn:
+			return PickBound
+		case gb.fallbackMap[key] == scRef.subConn:
+			return PickFallback
+		case overflow:
+			return PickOverflow
+		}
+		return PickLeastBusy
+	}
+	if u, ok := gb.unbound[key]; ok && u.sc == scRef.subConn {
+		return PickRecentlyUnbound
+	}
+	return PickLeastBusy
+}
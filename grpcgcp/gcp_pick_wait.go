@@ -0,0 +1,158 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PoolExhaustedError is the error of a call without a deadline which waited
+// for a channel longer than ChannelPoolConfig.max_pick_wait_ms. The call fails
+// with the RESOURCE_EXHAUSTED status. Use errors.Is with ErrPoolExhausted or
+// errors.As to check for it.
+type PoolExhaustedError struct {
+	// How long the call waited for a channel.
+	Waited time.Duration
+	// The max size of the channel pool, 0 if unlimited.
+	MaxSize uint32
+	// The metrics of the channel pool when the call failed.
+	Pool PoolMetrics
+}
+
+func (e *PoolExhaustedError) Error() string {
+	return fmt.Sprintf(
+		"grpcgcp: no channel available after waiting %v: %d of %d channels ready (max size %d) with %d active streams",
+		e.Waited, e.Pool.ReadyChannels, e.Pool.Channels, e.MaxSize, e.Pool.ActiveStreams,
+	)
+}
+
+// Is makes errors.Is match the error with ErrPoolExhausted.
+func (e *PoolExhaustedError) Is(target error) bool {
+	return target == ErrPoolExhausted
+}
+
+// GRPCStatus makes gRPC fail the call with the error instead of waiting for a
+// new picker.
+func (e *PoolExhaustedError) GRPCStatus() *status.Status {
+	return status.New(codes.ResourceExhausted, e.Error())
+}
+
+// queue returns balancer.ErrNoSubConnAvailable to make the call wait for a new
+// picker or a PoolExhaustedError if the call without a deadline waited for a
+// channel for max_pick_wait_ms.
+func (p *gcpPicker) queue(info balancer.PickInfo) error {
+	p.gb.emit(PickQueued, -1, "", info.FullMethodName)
+	maxWait := time.Duration(p.cfg.GetChannelPool().GetMaxPickWaitMs()) * time.Millisecond
+	if maxWait == 0 {
+		return balancer.ErrNoSubConnAvailable
+	}
+	if _, ok := info.Ctx.Deadline(); ok {
+		return balancer.ErrNoSubConnAvailable
+	}
+	gcpCtx, ok := info.Ctx.Value(gcpKey).(*gcpContext)
+	if !ok {
+		return balancer.ErrNoSubConnAvailable
+	}
+	now := time.Now()
+	if atomic.CompareAndSwapInt64(&gcpCtx.queuedAt, 0, now.UnixNano()) {
+		// Pick the call again once it waited for max_pick_wait_ms even if the
+		// state of the pool does not change.
+		time.AfterFunc(maxWait, p.gb.repick)
+		return balancer.ErrNoSubConnAvailable
+	}
+	waited := now.Sub(time.Unix(0, atomic.LoadInt64(&gcpCtx.queuedAt)))
+	if waited < maxWait {
+		return balancer.ErrNoSubConnAvailable
+	}
+	if p.log.V(FINE) {
+		p.log.Infof("call of %s waited for a channel for %v, failing", info.FullMethodName, waited)
+	}
+	return &PoolExhaustedError{
+		Waited:  waited,
+		MaxSize: p.cfg.GetChannelPool().GetMaxSize(),
+		Pool:    *p.gb.poolMetrics(),
+	}
+}
+
+// repick provides gRPC with a new picker to pick the waiting calls again.
+func (gb *gcpBalancer) repick() {
+	select {
+	case <-gb.done:
+		return
+	default:
+	}
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+	gb.regeneratePicker()
+	gb.cc.UpdateState(balancer.State{
+		ConnectivityState: gb.state,
+		Picker:            gb.picker,
+	})
+}
+
+// queuedPick counts a call waiting for a channel once in the queued picks of
+// the balancer, see PoolMetrics.QueuedPicks.
+type queuedPick struct {
+	mu sync.Mutex
+	// The balancer counting the call while it waits, nil otherwise.
+	gb *gcpBalancer
+}
+
+// enqueue counts the call in the queued picks of gb if not counted yet.
+func (q *queuedPick) enqueue(gb *gcpBalancer) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.gb == nil {
+		q.gb = gb
+		atomic.AddInt64(&gb.counters.queuedPicks, 1)
+	}
+}
+
+// dequeue stops counting the call in the queued picks, if counted.
+func (q *queuedPick) dequeue() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.gb != nil {
+		atomic.AddInt64(&q.gb.counters.queuedPicks, -1)
+		q.gb = nil
+	}
+}
+
+// trackQueuedPick counts the call made with the GCP interceptors in the
+// queued picks while its picks return balancer.ErrNoSubConnAvailable. The
+// interceptors stop counting a call failing while it waits.
+func (gb *gcpBalancer) trackQueuedPick(ctx context.Context, err error) {
+	gcpCtx, ok := ctx.Value(gcpKey).(*gcpContext)
+	if !ok {
+		return
+	}
+	if err == balancer.ErrNoSubConnAvailable {
+		gcpCtx.queued.enqueue(gb)
+		return
+	}
+	gcpCtx.queued.dequeue()
+}
@@ -22,14 +22,16 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -39,83 +41,302 @@ var deErr = status.Error(codes.DeadlineExceeded, context.DeadlineExceeded.Error(
 func newGCPPicker(readySCRefs []*subConnRef, gb *gcpBalancer) balancer.Picker {
 	gp := &gcpPicker{
 		gb:     gb,
+		cfg:    gb.config(),
 		scRefs: readySCRefs,
+		cursor: new(uint32),
 	}
 	gp.log = NewGCPLogger(gb.log, fmt.Sprintf("[gcpPicker %p]", gp))
 	return gp
 }
 
 type gcpPicker struct {
-	gb     *gcpBalancer
-	mu     sync.Mutex
-	scRefs []*subConnRef
+	gb *gcpBalancer
+	// Config of the balancer when the picker was created. The picker is
+	// regenerated when the config is updated, see UpdateConfig.
+	cfg    *GCPBalancerConfig
+	scRefs []*subConnRef // Immutable snapshot of ready subconns.
 	log    grpclog.LoggerV2
+	// Priority of the call for the pickers derived for a call.
+	priority grpc_gcp.CallPriority
+	// Rotates the tiebreak among equally busy subconns, shared with the
+	// pickers derived for a call, see tieBreakStart.
+	cursor *uint32
 }
 
 func (p *gcpPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	if isPoolLink(info.Ctx) {
+		return p.pickPoolLink(info)
+	}
+	if p.gb.dropPickByChaos(info.FullMethodName) {
+		return balancer.PickResult{}, ErrChaosDropped
+	}
+	pr, err := p.pickChannel(info)
+	p.gb.trackQueuedPick(info.Ctx, err)
+	return pr, err
+}
+
+// pickChannel picks a channel for the call, see unknown_method_policy for the
+// calls of the methods without a method config.
+func (p *gcpPicker) pickChannel(info balancer.PickInfo) (balancer.PickResult, error) {
+	if !p.gb.methodKnown(info.FullMethodName) {
+		return p.pickUnknownMethod(info)
+	}
+	return p.pickPool(info)
+}
+
+// pickPool picks the control channel or a channel of the pool, through the
+// circuit breaker if configured, for the call.
+func (p *gcpPicker) pickPool(info balancer.PickInfo) (balancer.PickResult, error) {
+	if p.gb.methodControl(info.FullMethodName) {
+		return p.pickControl(info)
+	}
+	cb := p.gb.breaker
+	if cb == nil {
+		return p.pick(info, false)
+	}
+	probe, err := cb.allow()
+	if err != nil {
+		return balancer.PickResult{}, err
+	}
+	pr, err := p.pick(info, probe)
+	if err != nil && probe {
+		cb.cancelProbe()
+	}
+	return pr, err
+}
+
+// pick picks a subconn for the call. If probe is true, the call is the probe
+// call of the half-open circuit breaker.
+func (p *gcpPicker) pick(info balancer.PickInfo, probe bool) (balancer.PickResult, error) {
+	ctx := info.Ctx
+	gcpCtx, hasGCPCtx := ctx.Value(gcpKey).(*gcpContext)
+	if hasGCPCtx {
+		p.gb.linkConn(gcpCtx.cc)
+		if !gcpCtx.streaming {
+			gcpCtx.call.apply(p.gb.methodCallPolicy(info.FullMethodName))
+		}
+	}
+
 	if len(p.scRefs) <= 0 {
+		if err := p.gb.resolverPickErr(); err != nil {
+			return balancer.PickResult{}, err
+		}
 		if p.log.V(FINEST) {
 			p.log.Info("returning balancer.ErrNoSubConnAvailable as no subconns are available.")
 		}
-		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+		return balancer.PickResult{}, p.queue(info)
 	}
 
-	ctx := info.Ctx
-	gcpCtx, hasGCPCtx := ctx.Value(gcpKey).(*gcpContext)
 	boundKey := ""
-	locator := ""
+	var unbindKeys []string
+	respLocator := ""
+	var unbindGrace, ttl time.Duration
 	var cmd grpc_gcp.AffinityConfig_Command
+	overflow := false
+	mcfg, hasAffinity := p.gb.methodAffinity(info.FullMethodName)
 
-	if mcfg, ok := p.gb.methodCfg[info.FullMethodName]; ok {
-		locator = mcfg.GetAffinityKey()
+	if hasAffinity {
+		respLocator = mcfg.GetResponseAffinityKey()
+		unbindGrace = time.Duration(mcfg.GetUnbindGracePeriodMs()) * time.Millisecond
+		ttl = time.Duration(mcfg.GetTtlMs()) * time.Millisecond
 		cmd = mcfg.GetCommand()
-		if hasGCPCtx && (cmd == grpc_gcp.AffinityConfig_BOUND || cmd == grpc_gcp.AffinityConfig_UNBIND) {
-			a, err := getAffinityKeysFromMessage(locator, gcpCtx.reqMsg)
+		overflow = mcfg.GetOverflowWhenBusy()
+		byParams := len(mcfg.GetAffinityKeyParams()) > 0
+		if (hasGCPCtx || byParams) && (cmd == grpc_gcp.AffinityConfig_BOUND || cmd == grpc_gcp.AffinityConfig_UNBIND || cmd == grpc_gcp.AffinityConfig_BIND_ON_FIRST_USE) {
+			var reqMsg interface{}
+			if hasGCPCtx {
+				reqMsg = gcpCtx.reqMsg
+			}
+			md, _ := metadata.FromOutgoingContext(ctx)
+			a, err := p.gb.requestAffinityKeys(md, mcfg, info.FullMethodName, reqMsg)
 			if err != nil {
 				return balancer.PickResult{}, fmt.Errorf(
 					"failed to retrieve affinity key from request message: %v", err)
 			}
+			if !byParams || a[0] != "" {
+				a = namespaceKeys(ctx, a)
+			}
 			boundKey = a[0]
+			if cmd == grpc_gcp.AffinityConfig_UNBIND {
+				// A repeated field unbinds all its keys, e.g., BatchDeleteSessions.
+				unbindKeys = a
+			}
 		}
 	}
 
-	scRef, err := p.getAndIncrementSubConnRef(info.Ctx, boundKey, cmd)
+	mp := p.gb.methodPool(info.FullMethodName)
+	tracker := streamTrackerFromContext(ctx)
+	spread := hasGCPCtx && boundKey == "" && p.cfg.GetChannelPool().GetSpreadCallAttempts()
+	picker := p
+	if spread {
+		// Pick a channel not used by previous retry or hedged attempts.
+		if refs := gcpCtx.attempts.unused(p.scRefs); len(refs) > 0 && len(refs) < len(p.scRefs) {
+			picker = &gcpPicker{gb: p.gb, cfg: p.cfg, scRefs: refs, log: p.log, cursor: p.cursor}
+		}
+	}
+	if sel := addressPreferenceFromContext(info.Ctx); sel != nil && boundKey == "" {
+		// Prefer the channels connected to the matching addresses.
+		if refs := p.gb.preferredSubConnRefs(picker.scRefs, sel); len(refs) > 0 && len(refs) < len(picker.scRefs) {
+			picker = &gcpPicker{gb: p.gb, cfg: p.cfg, scRefs: refs, log: p.log, cursor: p.cursor}
+		}
+	}
+	if prio := p.gb.callPriority(info.Ctx, info.FullMethodName); prio != grpc_gcp.CallPriority_NORMAL && boundKey == "" {
+		picker = &gcpPicker{gb: p.gb, cfg: p.cfg, scRefs: picker.scRefs, log: p.log, priority: prio, cursor: p.cursor}
+	}
+	if cmd == grpc_gcp.AffinityConfig_BIND || cmd == grpc_gcp.AffinityConfig_BIND_ON_FIRST_USE {
+		// Prefer the channels below the cap of bound affinity keys.
+		if refs := p.gb.belowAffinityCap(picker.scRefs); len(refs) < len(picker.scRefs) {
+			picker = &gcpPicker{gb: p.gb, cfg: p.cfg, scRefs: refs, log: p.log, priority: picker.priority, cursor: p.cursor}
+		}
+	}
+	scRef, err := picker.getAndIncrementSubConnRef(info.Ctx, boundKey, cmd, overflow, ttl, mp)
 	if err != nil {
+		if err == balancer.ErrNoSubConnAvailable {
+			err = p.queue(info)
+		}
 		return balancer.PickResult{}, err
 	}
 	if scRef == nil {
 		if p.log.V(FINEST) {
 			p.log.Info("returning balancer.ErrNoSubConnAvailable as no SubConn was picked.")
 		}
-		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+		return balancer.PickResult{}, p.queue(info)
+	}
+	var queued time.Duration
+	if hasGCPCtx {
+		if q := atomic.SwapInt64(&gcpCtx.queuedAt, 0); q != 0 {
+			queued = time.Since(time.Unix(0, q))
+		}
+	}
+
+	if scRef.throttler != nil && scRef.throttler.throttle() {
+		if tracker == nil {
+			scRef.streamsDecr(mp)
+		}
+		if p.log.V(FINEST) {
+			p.log.Infof("throttled call on channel %s", scRef.label)
+		}
+		return balancer.PickResult{}, ErrThrottled
+	}
+	if spread {
+		gcpCtx.attempts.add(scRef)
+	}
+	if p.gb.pickAudit != nil || hasGCPCtx {
+		d := p.pickDecision(info.Ctx, info.FullMethodName, boundKey, cmd, overflow, scRef, queued)
+		if p.gb.pickAudit != nil {
+			p.gb.pickAudit.record(d)
+		}
+		if hasGCPCtx {
+			gcpCtx.pick.Store(&d)
+		}
+	}
+
+	var restore []unboundBinding
+	if cmd == grpc_gcp.AffinityConfig_UNBIND && mcfg.GetUnbindPolicy() == grpc_gcp.AffinityConfig_UNBIND_ON_PICK {
+		restore = p.gb.unbindOnPick(unbindKeys, unbindGrace)
+	}
+
+	var keyStreams *int32
+	if p.gb.rebalancing {
+		keyStreams = p.gb.affinityKeyStreamsIncr(info.Ctx, boundKey)
 	}
 
 	callStarted := time.Now()
+	pickedSC := scRef.subConn
+	method := info.FullMethodName
 	// define callback for post process once call is done
+	var finished uint32
 	callback := func(info balancer.DoneInfo) {
-		scRef.streamsDecr()
+		// Account the call exactly once even if done is called again.
+		if !atomic.CompareAndSwapUint32(&finished, 0, 1) {
+			return
+		}
+		if tracker == nil {
+			scRef.streamsDecr(mp)
+		}
+		p.gb.dequeueLowPriority()
+		if keyStreams != nil {
+			atomic.AddInt32(keyStreams, -1)
+		}
+		scRef.recordCall(time.Since(callStarted), hasGCPCtx && gcpCtx.streaming, info.Err)
+		if u, ok := serverUtilization(info); ok {
+			scRef.utilization.add(u)
+		}
+		scRef.recordServerTiming(info.Trailer)
+		if cb := p.gb.breaker; cb != nil {
+			cb.record(probe, info.Err)
+		}
+		if scRef.throttler != nil {
+			scRef.throttler.record(info.Err)
+		}
 		p.detectUnresponsive(ctx, scRef, callStarted, info.Err)
 		if info.Err != nil {
+			p.gb.recordKeepaliveDrop(info.Err)
+			if isGoAwayError(info.Err) {
+				p.gb.observeGoAway(scRef, pickedSC)
+			}
+			if hasGCPCtx && !gcpCtx.streaming {
+				gcpCtx.retry = p.retryOnTeardown(gcpCtx, scRef, pickedSC, info)
+			}
+			if cmd == grpc_gcp.AffinityConfig_UNBIND {
+				p.gb.unbindFailed(mcfg, unbindKeys, restore, unbindGrace, info.Err)
+			}
 			return
 		}
 
 		switch cmd {
 		case grpc_gcp.AffinityConfig_BIND:
-			bindKeys, err := getAffinityKeysFromMessage(locator, gcpCtx.replyMsg)
+			if hasGCPCtx && gcpCtx.streaming {
+				// Bound on the first response message of the stream.
+				return
+			}
+			bindKeys, err := p.gb.configuredAffinityKeys(mcfg, method, true, gcpCtx.replyMsg)
 			if err == nil {
-				for _, bk := range bindKeys {
-					p.gb.bindSubConn(bk, scRef.subConn)
+				for _, bk := range namespaceKeys(ctx, bindKeys) {
+					p.gb.bindAfterChaosDelay(bk, scRef.subConn, ttl)
 				}
 			}
 		case grpc_gcp.AffinityConfig_UNBIND:
-			p.gb.unbindSubConn(boundKey)
+			for _, k := range unbindKeys {
+				p.gb.unbindSubConn(k, unbindGrace)
+			}
+		case grpc_gcp.AffinityConfig_BOUND:
+			if respLocator == "" || !hasGCPCtx || gcpCtx.replyMsg == nil {
+				return
+			}
+			respKeys, err := p.gb.affinityKeys(respLocator, method, true, gcpCtx.replyMsg)
+			if err != nil {
+				p.log.Warningf("failed to retrieve affinity key from response message: %v", err)
+				return
+			}
+			for _, k := range namespaceKeys(ctx, p.gb.normalizeKeys(respKeys)) {
+				p.gb.validateBinding(k, scRef)
+			}
+		}
+	}
+
+	if cmd == grpc_gcp.AffinityConfig_BIND && hasGCPCtx && gcpCtx.streaming {
+		// Bind as soon as the first response message is received because
+		// streams may last long.
+		gcpCtx.firstRecv = func(m interface{}) {
+			bindKeys, err := p.gb.configuredAffinityKeys(mcfg, method, true, m)
+			if err != nil {
+				return
+			}
+			for _, bk := range namespaceKeys(ctx, bindKeys) {
+				p.gb.bindAfterChaosDelay(bk, scRef.subConn, ttl)
+			}
 		}
 	}
 
 	if p.log.V(FINEST) {
-		p.log.Infof("picked SubConn: %p", scRef.subConn)
+		p.log.Infof("picked channel %s", scRef.label)
+	}
+	pr := balancer.PickResult{SubConn: scRef.subConn, Done: callback}
+	if h := p.cfg.GetChannelPool().GetChannelIdHeader(); h != "" {
+		pr.Metadata = metadata.Pairs(h, strconv.Itoa(scRef.id))
 	}
-	return balancer.PickResult{SubConn: scRef.subConn, Done: callback}, nil
+	return pr, nil
 }
 
 // unresponsiveWindow returns channel pool's unresponsiveDetectionMs multiplied
@@ -123,7 +344,7 @@ func (p *gcpPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 // exponential backoff when RPCs keep deadline exceeded after consecutive reconnections.
 func (p *gcpPicker) unresponsiveWindow(scRef *subConnRef) time.Duration {
 	factor := uint32(1 << scRef.refreshCnt)
-	return time.Millisecond * time.Duration(factor*p.gb.cfg.GetChannelPool().GetUnresponsiveDetectionMs())
+	return time.Millisecond * time.Duration(factor*p.cfg.GetChannelPool().GetUnresponsiveDetectionMs())
 }
 
 func (p *gcpPicker) detectUnresponsive(ctx context.Context, scRef *subConnRef, callStarted time.Time, rpcErr error) {
@@ -144,78 +365,263 @@ func (p *gcpPicker) detectUnresponsive(ctx context.Context, scRef *subConnRef, c
 
 	// Increment deadline exceeded calls and check if there were enough deadline
 	// exceeded calls and enough time passed since last response to trigger refresh.
-	if scRef.deCallsInc() >= p.gb.cfg.GetChannelPool().GetUnresponsiveCalls() &&
+	if scRef.deCallsInc() >= p.cfg.GetChannelPool().GetUnresponsiveCalls() &&
 		scRef.lastResp.Before(time.Now().Add(-p.unresponsiveWindow(scRef))) {
 		p.gb.refresh(scRef)
 	}
 }
 
-func (p *gcpPicker) getAndIncrementSubConnRef(ctx context.Context, boundKey string, cmd grpc_gcp.AffinityConfig_Command) (*subConnRef, error) {
-	if cmd == grpc_gcp.AffinityConfig_BIND && p.gb.cfg.GetChannelPool().GetBindPickStrategy() == grpc_gcp.ChannelPoolConfig_ROUND_ROBIN {
+func (p *gcpPicker) getAndIncrementSubConnRef(ctx context.Context, boundKey string, cmd grpc_gcp.AffinityConfig_Command, overflow bool, ttl time.Duration, mp *methodPool) (*subConnRef, error) {
+	if index, ok := PinnedChannelFromContext(ctx); ok {
+		scRef, err := p.gb.getPinnedSubConnRef(index)
+		if err != nil {
+			return nil, err
+		}
+		incrementStreams(ctx, scRef, mp)
+		return scRef, nil
+	}
+
+	if key, ok := p.gb.scopedContextAffinityKey(ctx); ok {
+		scRef, err := p.getContextAffinitySubConnRef(key, mp)
+		if scRef != nil {
+			incrementStreams(ctx, scRef, mp)
+		}
+		return scRef, err
+	}
+
+	if cmd == grpc_gcp.AffinityConfig_BIND && p.cfg.GetChannelPool().GetBindPickStrategy() == grpc_gcp.ChannelPoolConfig_ROUND_ROBIN {
 		scRef := p.gb.getSubConnRoundRobin(ctx)
 		if p.log.V(FINEST) {
-			p.log.Infof("picking SubConn for round-robin bind: %p", scRef.subConn)
+			p.log.Infof("picking channel %s for round-robin bind", scRef.label)
 		}
-		scRef.streamsIncr()
+		incrementStreams(ctx, scRef, mp)
 		return scRef, nil
 	}
 
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	scRef, err := p.getSubConnRef(boundKey)
+	if cmd == grpc_gcp.AffinityConfig_BIND_ON_FIRST_USE && boundKey != "" {
+		scRef, err := p.getFirstUseSubConnRef(boundKey, overflow, ttl, mp)
+		if scRef != nil {
+			incrementStreams(ctx, scRef, mp)
+		}
+		return scRef, err
+	}
+
+	// The picker is lock-free: streams counters are atomic and the ready
+	// subconns are an immutable snapshot regenerated by the balancer.
+	// Concurrent picks may choose the same least busy subconn. The imbalance
+	// is bounded by the number of concurrent picks and is corrected by the
+	// following picks.
+	scRef, err := p.getSubConnRef(boundKey, overflow, mp)
 	if err != nil {
 		return nil, err
 	}
 	if scRef != nil {
-		scRef.streamsIncr()
+		incrementStreams(ctx, scRef, mp)
 	}
 	return scRef, nil
 }
 
+// incrementStreams increments the streams count of the picked scRef. If the
+// call attempt is tracked by the GCP stats handler, the stats handler takes
+// care of the streams count.
+func incrementStreams(ctx context.Context, scRef *subConnRef, mp *methodPool) {
+	if st := streamTrackerFromContext(ctx); st != nil {
+		st.assign(scRef, mp)
+		return
+	}
+	scRef.streamsIncr(mp)
+}
+
 // getSubConnRef returns the subConnRef object that contains the subconn
-// ready to be used by picker.
-// Must be called holding the picker mutex lock.
-func (p *gcpPicker) getSubConnRef(boundKey string) (*subConnRef, error) {
+// ready to be used by picker. If overflow is true and the subconn bound to the
+// boundKey is busy, a less busy subconn may be returned, see
+// AffinityConfig.overflow_when_busy.
+func (p *gcpPicker) getSubConnRef(boundKey string, overflow bool, mp *methodPool) (*subConnRef, error) {
 	if boundKey != "" {
-		if ref, ok := p.gb.getReadySubConnRef(boundKey); ok {
+		if ref, ok := p.gb.getReadySubConnRef(boundKey); ok || ref != nil {
+			if overflow && ref != nil {
+				return p.getOverflowSubConnRef(ref, mp), nil
+			}
 			return ref, nil
 		}
 	}
 
-	return p.getLeastBusySubConnRef()
+	return p.getLeastBusySubConnRef(mp)
 }
 
-// Must be called holding the picker mutex lock.
-func (p *gcpPicker) getLeastBusySubConnRef() (*subConnRef, error) {
-	minScRef := p.scRefs[0]
-	minStreamsCnt := minScRef.getStreamsCnt()
-	for _, scRef := range p.scRefs {
-		if scRef.getStreamsCnt() < minStreamsCnt {
-			minStreamsCnt = scRef.getStreamsCnt()
+// getFirstUseSubConnRef returns the subConnRef bound to the affinity key of a
+// BIND_ON_FIRST_USE call. If the key is not bound yet, it is bound to the least
+// busy subConnRef before the call starts, so that the following calls with the
+// key use the same subConnRef even if the call fails.
+func (p *gcpPicker) getFirstUseSubConnRef(key string, overflow bool, ttl time.Duration, mp *methodPool) (*subConnRef, error) {
+	if ref, ok := p.gb.getReadySubConnRef(key); ok || ref != nil {
+		if overflow && ref != nil {
+			return p.getOverflowSubConnRef(ref, mp), nil
+		}
+		return ref, nil
+	}
+	return p.bindLeastBusySubConnRef(key, ttl, mp)
+}
+
+// bindLeastBusySubConnRef binds the affinity key to the least busy subConnRef
+// and returns the subConnRef the key is bound to.
+func (p *gcpPicker) bindLeastBusySubConnRef(key string, ttl time.Duration, mp *methodPool) (*subConnRef, error) {
+	ref, err := p.getLeastBusySubConnRef(mp)
+	if err != nil || ref == nil {
+		return ref, err
+	}
+	p.gb.bindSubConnWithTTL(key, ref.subConn, ttl)
+	// A concurrent call with the same key may have bound it first.
+	if bound, ok := p.gb.getReadySubConnRef(key); ok {
+		return bound, nil
+	}
+	return ref, nil
+}
+
+// getLeastBusySubConnRef returns the subConnRef with the least number of streams.
+// If mp is not nil, only streams of the methods from the method pool are
+// counted and the method pool's low watermark is used.
+func (p *gcpPicker) getLeastBusySubConnRef(mp *methodPool) (*subConnRef, error) {
+	maxStreams := int32(p.cfg.GetChannelPool().GetMaxConcurrentStreamsLowWatermark())
+	if mp != nil {
+		maxStreams = mp.maxStreams
+	}
+	if p.priority == grpc_gcp.CallPriority_LOW {
+		maxStreams = p.gb.lowPriorityMaxStreams(maxStreams)
+	}
+	// The first of the equally busy subconns from the start is picked.
+	n := len(p.scRefs)
+	start := p.tieBreakStart()
+	minScRef := p.scRefs[start]
+	minStreamsCnt := minScRef.getMethodStreamsCnt(mp)
+	// The least busy connection with capacity.
+	var capScRef *subConnRef
+	var capStreamsCnt int32
+	for i := 0; i < n; i++ {
+		scRef := p.scRefs[(start+i)%n]
+		cnt := scRef.getMethodStreamsCnt(mp)
+		if cnt < minStreamsCnt {
+			minStreamsCnt = cnt
 			minScRef = scRef
 		}
+		if hasCapacity(scRef, mp, maxStreams) && (capScRef == nil || cnt < capStreamsCnt) {
+			capStreamsCnt = cnt
+			capScRef = scRef
+		}
 	}
 
 	// If the least busy connection still has capacity, use it
-	if minStreamsCnt < int32(p.gb.cfg.GetChannelPool().GetMaxConcurrentStreamsLowWatermark()) {
-		return minScRef, nil
+	if capScRef != nil {
+		switch p.cfg.GetChannelPool().GetPickStrategy() {
+		case grpc_gcp.ChannelPoolConfig_PICK_LOWEST_LATENCY:
+			return p.getLowestLatencySubConnRef(mp, maxStreams), nil
+		case grpc_gcp.ChannelPoolConfig_PICK_LEAST_UTILIZATION:
+			return p.getLeastUtilizedSubConnRef(mp, maxStreams), nil
+		case grpc_gcp.ChannelPoolConfig_PICK_WEIGHTED_ROUND_ROBIN:
+			return p.getWeightedSubConnRef(mp, maxStreams), nil
+		}
+		return capScRef, nil
 	}
 
-	if p.gb.cfg.GetChannelPool().GetMaxSize() == 0 || p.gb.getConnectionPoolSize() < int(p.gb.cfg.GetChannelPool().GetMaxSize()) {
+	if (p.cfg.GetChannelPool().GetMaxSize() == 0 || p.gb.getConnectionPoolSize() < int(p.cfg.GetChannelPool().GetMaxSize())) && p.gb.budgetAllowsGrowth() {
 		// Ask balancer to create new subconn when all current subconns are busy and
-		// the connection pool still has capacity (either unlimited or maxSize is not reached).
+		// the connection pool still has capacity (either unlimited or maxSize is not reached)
+		// and the channel budget allows it.
 		p.gb.newSubConn()
 
+		if p.priority == grpc_gcp.CallPriority_HIGH {
+			// High priority calls do not wait for the new subconn.
+			return minScRef, nil
+		}
 		// Let this picker return ErrNoSubConnAvailable because it needs some time
 		// for the subconn to be READY.
 		return nil, balancer.ErrNoSubConnAvailable
 	}
 
+	if p.priority == grpc_gcp.CallPriority_LOW {
+		// Low priority calls wait for a call to finish instead of adding to
+		// the busy subconns.
+		p.gb.queueLowPriority()
+		return nil, balancer.ErrNoSubConnAvailable
+	}
+
 	// If no capacity for the pool size and every connection reachs the soft limit,
 	// Then picks the least busy one anyway.
 	return minScRef, nil
 }
 
+// tieBreakStart returns the index of the subConnRef the search for the least
+// busy one starts from. It rotates on every pick, so equally busy subconns are
+// picked in turn instead of the first of them in the order of the snapshot
+// every time. It is always 0 with Config.Deterministic.
+func (p *gcpPicker) tieBreakStart() int {
+	if p.cursor == nil || p.gb.opts.Deterministic || len(p.scRefs) < 2 {
+		return 0
+	}
+	return int((atomic.AddUint32(p.cursor, 1) - 1) % uint32(len(p.scRefs)))
+}
+
+// getOverflowSubConnRef returns the bound subConnRef if it has capacity or
+// the least busy subConnRef with capacity otherwise. The pool grows if no
+// subConnRef has capacity, but the call does not wait for the new subconn and
+// uses the bound subConnRef.
+func (p *gcpPicker) getOverflowSubConnRef(bound *subConnRef, mp *methodPool) *subConnRef {
+	maxStreams := int32(p.cfg.GetChannelPool().GetMaxConcurrentStreamsLowWatermark())
+	if mp != nil {
+		maxStreams = mp.maxStreams
+	}
+	if hasCapacity(bound, mp, maxStreams) {
+		return bound
+	}
+	ref, err := p.getLeastBusySubConnRef(mp)
+	if err != nil || ref == nil || !hasCapacity(ref, mp, maxStreams) {
+		return bound
+	}
+	if p.log.V(FINEST) {
+		p.log.Infof("bound channel %s is busy, overflowing to channel %s", bound.label, ref.label)
+	}
+	return ref
+}
+
+// hasCapacity reports whether the subConnRef has less than maxStreams streams of
+// the methods from the method pool mp and less streams than the max concurrent
+// streams limit of the server if known.
+func hasCapacity(scRef *subConnRef, mp *methodPool, maxStreams int32) bool {
+	if scRef.getMethodStreamsCnt(mp) >= maxStreams {
+		return false
+	}
+	limit := scRef.getServerMaxStreams()
+	return limit == 0 || scRef.getStreamsCnt() < limit
+}
+
+// getLowestLatencySubConnRef returns the subConnRef with the best latency score
+// among the subConnRefs with capacity, see hasCapacity. At least one such
+// subConnRef must exist. Of equally scored subConnRefs with equal streams, one
+// without latency samples is preferred so that its latency gets sampled.
+func (p *gcpPicker) getLowestLatencySubConnRef(mp *methodPool, maxStreams int32) *subConnRef {
+	var bestScRef *subConnRef
+	var bestScore float64
+	var bestStreamsCnt int32
+	var bestSampled bool
+	unsampled := meanLatency(p.scRefs)
+	for _, scRef := range p.scRefs {
+		if !hasCapacity(scRef, mp, maxStreams) {
+			continue
+		}
+		streamsCnt := scRef.getMethodStreamsCnt(mp)
+		score := scRef.latencyScore(streamsCnt, unsampled)
+		sampled := scRef.sampledLatency() != 0
+		better := bestScRef == nil || score < bestScore
+		if score == bestScore {
+			better = better || streamsCnt < bestStreamsCnt || (streamsCnt == bestStreamsCnt && bestSampled && !sampled)
+		}
+		if better {
+			bestScRef, bestScore, bestStreamsCnt, bestSampled = scRef, score, streamsCnt, sampled
+		}
+	}
+	return bestScRef
+}
+
 func keysFromMessage(val reflect.Value, path []string, start int) ([]string, error) {
 	if val.Kind() == reflect.Pointer || val.Kind() == reflect.Interface {
 		val = val.Elem()
@@ -231,7 +637,23 @@ func keysFromMessage(val reflect.Value, path []string, start int) ([]string, err
 	if val.Kind() != reflect.Struct {
 		return nil, fmt.Errorf("path %q traversal error: cannot lookup field %q (index %d in the path) in a %q value", strings.Join(path, "."), path[start], start, val.Kind())
 	}
-	valField := val.FieldByName(strings.Title(path[start]))
+	name, key, entry, err := parsePathElement(path, start)
+	if err != nil {
+		return nil, err
+	}
+	valField := val.FieldByName(strings.Title(name))
+
+	if entry {
+		if valField.Kind() != reflect.Map || valField.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("path %q traversal error: %q (index %d in the path) is not a map field with string keys", strings.Join(path, "."), path[start], start)
+		}
+		v := valField.MapIndex(reflect.ValueOf(key).Convert(valField.Type().Key()))
+		if !v.IsValid() {
+			// Default value of a missing map entry.
+			v = reflect.Zero(valField.Type().Elem())
+		}
+		return keysFromMessage(v, path, start+1)
+	}
 
 	if valField.Kind() != reflect.Slice {
 		return keysFromMessage(valField, path, start+1)
@@ -249,16 +671,21 @@ func keysFromMessage(val reflect.Value, path []string, start int) ([]string, err
 }
 
 // getAffinityKeysFromMessage retrieves the affinity key(s) from proto message using
-// the key locator defined in the affinity config.
+// the key locator defined in the affinity config. Proto messages are walked
+// with protoreflect, so messages without generated Go structs, e.g., dynamicpb
+// messages, are supported.
 func getAffinityKeysFromMessage(
 	locator string,
 	msg interface{},
 ) (affinityKeys []string, err error) {
-	names := strings.Split(locator, ".")
+	names := splitPath(locator)
 	if len(names) == 0 {
 		return nil, fmt.Errorf("empty affinityKey locator")
 	}
 
+	if m, ok := protoMessage(msg); ok {
+		return keysFromProtoMessage(m, names, 0)
+	}
 	return keysFromMessage(reflect.ValueOf(msg), names, 0)
 }
 
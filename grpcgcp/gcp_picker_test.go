@@ -20,18 +20,34 @@ package grpcgcp
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
+	"net/url"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/mocks"
 	"github.com/golang/mock/gomock"
 	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
 
 	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
 )
@@ -247,6 +263,314 @@ func TestInvalidKeyLocator(t *testing.T) {
 	}
 }
 
+// testDescriptors returns the files with the test.Sessions service whose
+// BatchUse method has a Batch request with a repeated Session field.
+func testDescriptors(t testing.TB) *protoregistry.Files {
+	t.Helper()
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Session"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("session_name"), JsonName: proto.String("sessionName"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+					{Name: proto.String("count"), JsonName: proto.String("count"), Number: proto.Int32(2), Type: descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+				},
+			},
+			{
+				Name: proto.String("Batch"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("id"), JsonName: proto.String("id"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+					{Name: proto.String("sessions"), JsonName: proto.String("sessions"), Number: proto.Int32(2), Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".test.Session"), Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()},
+					{Name: proto.String("primary"), JsonName: proto.String("primary"), Number: proto.Int32(3), Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".test.Session"), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+					{Name: proto.String("template"), JsonName: proto.String("template"), Number: proto.Int32(4), Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".test.Session"), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), OneofIndex: proto.Int32(0)},
+					{Name: proto.String("template_name"), JsonName: proto.String("templateName"), Number: proto.Int32(5), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), OneofIndex: proto.Int32(0)},
+					{Name: proto.String("by_label"), JsonName: proto.String("byLabel"), Number: proto.Int32(6), Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".test.Batch.ByLabelEntry"), Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()},
+					{Name: proto.String("labels"), JsonName: proto.String("labels"), Number: proto.Int32(7), Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".test.Batch.LabelsEntry"), Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()},
+					{Name: proto.String("by_id"), JsonName: proto.String("byId"), Number: proto.Int32(8), Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".test.Batch.ByIdEntry"), Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()},
+				},
+				OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("target")}},
+				NestedType: []*descriptorpb.DescriptorProto{
+					testMapEntry("ByLabelEntry", descriptorpb.FieldDescriptorProto_TYPE_STRING, ".test.Session"),
+					testMapEntry("LabelsEntry", descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					testMapEntry("ByIdEntry", descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{
+				Name: proto.String("Sessions"),
+				Method: []*descriptorpb.MethodDescriptorProto{
+					{Name: proto.String("BatchUse"), InputType: proto.String(".test.Batch"), OutputType: proto.String(".test.Session")},
+				},
+			},
+		},
+	}
+	fd, err := protodesc.NewFile(fdp, nil)
+	if err != nil {
+		t.Fatalf("protodesc.NewFile returned error: %v", err)
+	}
+	files := &protoregistry.Files{}
+	if err := files.RegisterFile(fd); err != nil {
+		t.Fatalf("RegisterFile returned error: %v", err)
+	}
+	return files
+}
+
+// testMapEntry returns the descriptor of a map entry with the key type and the
+// Session values if valueType is set or string values otherwise.
+func testMapEntry(name string, keyType descriptorpb.FieldDescriptorProto_Type, valueType string) *descriptorpb.DescriptorProto {
+	value := &descriptorpb.FieldDescriptorProto{Name: proto.String("value"), JsonName: proto.String("value"), Number: proto.Int32(2), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()}
+	if valueType != "" {
+		value.Type, value.TypeName = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), proto.String(valueType)
+	}
+	return &descriptorpb.DescriptorProto{
+		Name: proto.String(name),
+		Field: []*descriptorpb.FieldDescriptorProto{
+			{Name: proto.String("key"), JsonName: proto.String("key"), Number: proto.Int32(1), Type: keyType.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+			value,
+		},
+		Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+	}
+}
+
+// testBatch returns a dynamic Batch message with the sessions and the primary
+// session.
+func testBatch(t testing.TB, files *protoregistry.Files, primary string, sessions ...string) *dynamicpb.Message {
+	t.Helper()
+	d, err := files.FindDescriptorByName("test.Batch")
+	if err != nil {
+		t.Fatalf("FindDescriptorByName returned error: %v", err)
+	}
+	md := d.(protoreflect.MessageDescriptor)
+	sessionMD := md.Fields().ByName("sessions").Message()
+	batch := dynamicpb.NewMessage(md)
+	batch.Set(md.Fields().ByName("id"), protoreflect.ValueOfInt64(42))
+	list := batch.Mutable(md.Fields().ByName("sessions")).List()
+	for _, name := range sessions {
+		s := dynamicpb.NewMessage(sessionMD)
+		s.Set(sessionMD.Fields().ByName("session_name"), protoreflect.ValueOfString(name))
+		s.Set(sessionMD.Fields().ByName("count"), protoreflect.ValueOfInt32(7))
+		list.Append(protoreflect.ValueOfMessage(s))
+	}
+	if primary != "" {
+		s := dynamicpb.NewMessage(sessionMD)
+		s.Set(sessionMD.Fields().ByName("session_name"), protoreflect.ValueOfString(primary))
+		batch.Set(md.Fields().ByName("primary"), protoreflect.ValueOfMessage(s))
+	}
+	return batch
+}
+
+func TestGetKeysFromProtoMessage(t *testing.T) {
+	msg := &pb.ApiConfig{
+		ChannelPool: &pb.ChannelPoolConfig{ChannelIdHeader: "x-channel"},
+		Method: []*pb.MethodConfig{
+			{Name: []string{"m1", "m2"}},
+			{Name: []string{"m3"}},
+		},
+	}
+	for _, locator := range []string{"channelPool.channelIdHeader", "channel_pool.channel_id_header", "ChannelPool.ChannelIdHeader"} {
+		res, err := getAffinityKeysFromMessage(locator, msg)
+		if err != nil {
+			t.Fatalf("getAffinityKeysFromMessage(%q) failed: %v", locator, err)
+		}
+		if diff := cmp.Diff([]string{"x-channel"}, res); diff != "" {
+			t.Fatalf("getAffinityKeysFromMessage(%q) returns unexpected diff (-want, +got):\n%s", locator, diff)
+		}
+	}
+	res, err := getAffinityKeysFromMessage("method.name", msg)
+	if err != nil {
+		t.Fatalf("getAffinityKeysFromMessage failed: %v", err)
+	}
+	if diff := cmp.Diff([]string{"m1", "m2", "m3"}, res); diff != "" {
+		t.Fatalf("getAffinityKeysFromMessage returns unexpected diff (-want, +got):\n%s", diff)
+	}
+
+	files := testDescriptors(t)
+	batch := testBatch(t, files, "p", "s1", "s2")
+	res, err = getAffinityKeysFromMessage("sessions.sessionName", batch)
+	if err != nil {
+		t.Fatalf("getAffinityKeysFromMessage failed for dynamic message: %v", err)
+	}
+	if diff := cmp.Diff([]string{"s1", "s2"}, res); diff != "" {
+		t.Fatalf("getAffinityKeysFromMessage returns unexpected diff for dynamic message (-want, +got):\n%s", diff)
+	}
+
+	expectedErr := "cannot get string value from \"sessions.count\" which is \"int32\""
+	if _, err := getAffinityKeysFromMessage("sessions.count", batch); err == nil || err.Error() != expectedErr {
+		t.Fatalf("getAffinityKeysFromMessage returns wrong err: %v, want: %v", err, expectedErr)
+	}
+}
+
+func TestGetKeysFromWireBytes(t *testing.T) {
+	files := testDescriptors(t)
+	gb := &gcpBalancer{opts: Config{Descriptors: files}}
+	b, err := proto.Marshal(testBatch(t, files, "p", "s1", "s2"))
+	if err != nil {
+		t.Fatalf("proto.Marshal returned error: %v", err)
+	}
+
+	for _, tc := range []struct {
+		locator string
+		msg     interface{}
+		want    []string
+	}{
+		{locator: "sessions.session_name", msg: b, want: []string{"s1", "s2"}},
+		{locator: "primary.sessionName", msg: &b, want: []string{"p"}},
+	} {
+		res, err := gb.affinityKeys(tc.locator, "/test.Sessions/BatchUse", false, tc.msg)
+		if err != nil {
+			t.Fatalf("affinityKeys(%q) failed: %v", tc.locator, err)
+		}
+		if diff := cmp.Diff(tc.want, res); diff != "" {
+			t.Fatalf("affinityKeys(%q) returns unexpected diff (-want, +got):\n%s", tc.locator, diff)
+		}
+	}
+
+	// The locators are compiled once per method.
+	if _, ok := gb.wirePaths.Load(wirePathKey{locator: "primary.sessionName", method: "/test.Sessions/BatchUse"}); !ok {
+		t.Fatalf("affinityKeys did not cache the compiled locator")
+	}
+
+	// The reply of the method is a Session.
+	batch := testBatch(t, files, "s3")
+	reply, err := proto.Marshal(batch.Get(batch.Descriptor().Fields().ByName("primary")).Message().Interface())
+	if err != nil {
+		t.Fatalf("proto.Marshal returned error: %v", err)
+	}
+	res, err := gb.affinityKeys("sessionName", "/test.Sessions/BatchUse", true, &reply)
+	if err != nil {
+		t.Fatalf("affinityKeys failed for reply: %v", err)
+	}
+	if diff := cmp.Diff([]string{"s3"}, res); diff != "" {
+		t.Fatalf("affinityKeys returns unexpected diff for reply (-want, +got):\n%s", diff)
+	}
+
+	// An unset singular field has the default value.
+	b, _ = proto.Marshal(testBatch(t, files, "", "s1"))
+	res, err = gb.affinityKeys("primary.sessionName", "/test.Sessions/BatchUse", false, b)
+	if err != nil {
+		t.Fatalf("affinityKeys failed for unset field: %v", err)
+	}
+	if diff := cmp.Diff([]string{""}, res); diff != "" {
+		t.Fatalf("affinityKeys returns unexpected diff for unset field (-want, +got):\n%s", diff)
+	}
+
+	if _, err := gb.affinityKeys("sessions.sessionName", "/test.Sessions/Unknown", false, b); err == nil {
+		t.Fatalf("affinityKeys returns no error for unknown method")
+	}
+	if _, err := gb.affinityKeys("sessions.sessionName", "/test.Sessions/BatchUse", false, []byte{0xff}); err == nil {
+		t.Fatalf("affinityKeys returns no error for malformed message")
+	}
+}
+
+// withConfig sets the config of a balancer built by a test without
+// UpdateClientConnState, with the method configs of its ApiConfig.
+func withConfig(gb *gcpBalancer, cfg *GCPBalancerConfig) *gcpBalancer {
+	gb.setConfig(cfg, newMethodConfigs(cfg.GetMethod()))
+	return gb
+}
+
+// testNestedBatch returns a dynamic Batch message with the template in the
+// target oneof and the entries of the by_label and labels maps.
+func testNestedBatch(t testing.TB, files *protoregistry.Files, template string, byLabel, labels map[string]string) *dynamicpb.Message {
+	t.Helper()
+	batch := testBatch(t, files, "")
+	md := batch.Descriptor()
+	sessionMD := md.Fields().ByName("primary").Message()
+	session := func(name string) protoreflect.Value {
+		s := dynamicpb.NewMessage(sessionMD)
+		s.Set(sessionMD.Fields().ByName("session_name"), protoreflect.ValueOfString(name))
+		return protoreflect.ValueOfMessage(s)
+	}
+	if template != "" {
+		batch.Set(md.Fields().ByName("template"), session(template))
+	}
+	m := batch.Mutable(md.Fields().ByName("by_label")).Map()
+	for k, v := range byLabel {
+		m.Set(protoreflect.ValueOfString(k).MapKey(), session(v))
+	}
+	m = batch.Mutable(md.Fields().ByName("labels")).Map()
+	for k, v := range labels {
+		m.Set(protoreflect.ValueOfString(k).MapKey(), protoreflect.ValueOfString(v))
+	}
+	return batch
+}
+
+func TestGetKeysFromNestedPaths(t *testing.T) {
+	files := testDescriptors(t)
+	gb := &gcpBalancer{opts: Config{Descriptors: files}}
+	batch := testNestedBatch(t, files, "t1", map[string]string{"a.b": "s1", "c": "s2"}, map[string]string{"env": "prod"})
+	b, err := proto.Marshal(batch)
+	if err != nil {
+		t.Fatalf("proto.Marshal returned error: %v", err)
+	}
+
+	for _, tc := range []struct {
+		locator string
+		want    []string
+	}{
+		{locator: "template.sessionName", want: []string{"t1"}},
+		{locator: "templateName", want: []string{""}},
+		{locator: "by_label[c].session_name", want: []string{"s2"}},
+		{locator: `byLabel["a.b"].sessionName`, want: []string{"s1"}},
+		{locator: "labels[env]", want: []string{"prod"}},
+		{locator: "labels[missing]", want: []string{""}},
+		{locator: "by_label[missing].session_name", want: []string{""}},
+	} {
+		res, err := getAffinityKeysFromMessage(tc.locator, batch)
+		if err != nil {
+			t.Fatalf("getAffinityKeysFromMessage(%q) failed: %v", tc.locator, err)
+		}
+		if diff := cmp.Diff(tc.want, res); diff != "" {
+			t.Fatalf("getAffinityKeysFromMessage(%q) returns unexpected diff (-want, +got):\n%s", tc.locator, diff)
+		}
+		res, err = gb.affinityKeys(tc.locator, "/test.Sessions/BatchUse", false, b)
+		if err != nil {
+			t.Fatalf("affinityKeys(%q) failed: %v", tc.locator, err)
+		}
+		if diff := cmp.Diff(tc.want, res); diff != "" {
+			t.Fatalf("affinityKeys(%q) returns unexpected diff (-want, +got):\n%s", tc.locator, diff)
+		}
+	}
+
+	// A later member of the oneof clears the template on the wire.
+	other := testNestedBatch(t, files, "", nil, nil)
+	other.Set(other.Descriptor().Fields().ByName("template_name"), protoreflect.ValueOfString("n1"))
+	ob, err := proto.Marshal(other)
+	if err != nil {
+		t.Fatalf("proto.Marshal returned error: %v", err)
+	}
+	res, err := gb.affinityKeys("template.sessionName", "/test.Sessions/BatchUse", false, append(append([]byte{}, b...), ob...))
+	if err != nil {
+		t.Fatalf("affinityKeys failed for the cleared oneof member: %v", err)
+	}
+	if diff := cmp.Diff([]string{""}, res); diff != "" {
+		t.Fatalf("affinityKeys returns unexpected diff for the cleared oneof member (-want, +got):\n%s", diff)
+	}
+
+	for _, tc := range []struct {
+		locator string
+		wantErr string
+	}{
+		{locator: "by_label.session_name", wantErr: `path "by_label.session_name" traversal error: "by_label" (index 0 in the path) is a map field, select an entry with by_label[key]`},
+		{locator: "primary[a].session_name", wantErr: `path "primary[a].session_name" traversal error: "primary[a]" (index 0 in the path) is not a map field`},
+		{locator: "by_id[1]", wantErr: `path "by_id[1]" traversal error: "by_id[1]" (index 0 in the path) is a map field with "int64" keys, only string keys are supported`},
+		{locator: "by_label[a]", wantErr: `cannot get string value from "by_label[a]" which is "message"`},
+		{locator: "by_label[].session_name", wantErr: `path "by_label[].session_name" traversal error: malformed element "by_label[]" (index 0 in the path), want name or name[key]`},
+		{locator: "target.session_name", wantErr: `path "target.session_name" traversal error: "target" (index 0 in the path) is a oneof in "test.Batch" message, select one of its fields: template, template_name`},
+	} {
+		if _, err := getAffinityKeysFromMessage(tc.locator, batch); err == nil || err.Error() != tc.wantErr {
+			t.Fatalf("getAffinityKeysFromMessage(%q) returns wrong err: %v, want: %v", tc.locator, err, tc.wantErr)
+		}
+		if _, err := gb.affinityKeys(tc.locator, "/test.Sessions/BatchUse", false, b); err == nil || err.Error() != tc.wantErr {
+			t.Fatalf("affinityKeys(%q) returns wrong err: %v, want: %v", tc.locator, err, tc.wantErr)
+		}
+	}
+}
+
 func TestPickSubConnWithLeastStreams(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -279,17 +603,16 @@ func TestPickSubConnWithLeastStreams(t *testing.T) {
 		},
 	}
 
-	picker := newGCPPicker(scRefs, &gcpBalancer{
-		cfg: &GCPBalancerConfig{
-			ApiConfig: &pb.ApiConfig{
-				ChannelPool: &pb.ChannelPoolConfig{
-					MaxSize:                          10,
-					MaxConcurrentStreamsLowWatermark: 100,
-				},
+	picker := newGCPPicker(scRefs, withConfig(&gcpBalancer{
+		log: compLogger,
+	}, &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          10,
+				MaxConcurrentStreamsLowWatermark: 100,
 			},
 		},
-		log: compLogger,
-	})
+	}))
 
 	ctx := context.Background()
 
@@ -304,248 +627,2979 @@ func TestPickSubConnWithLeastStreams(t *testing.T) {
 	}
 }
 
-func TestPickNewSubConn(t *testing.T) {
+func TestPickSubConnWithServerMaxStreams(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
-	mockSC := mocks.NewMockSubConn(mockCtrl)
+	limitedSC := mocks.NewMockSubConn(mockCtrl)
+	okSC := mocks.NewMockSubConn(mockCtrl)
 	var scRefs = []*subConnRef{
 		{
-			subConn:     mockSC,
+			id:          0,
+			subConn:     limitedSC,
 			stateSignal: make(chan struct{}),
-			affinityCnt: 0,
-			streamsCnt:  100,
+			streamsCnt:  2,
+		},
+		{
+			id:          1,
+			subConn:     okSC,
+			stateSignal: make(chan struct{}),
+			streamsCnt:  3,
 		},
 	}
+	gb := withConfig(&gcpBalancer{
+		scRefList: scRefs,
+		log:       compLogger,
+	}, &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          2,
+				MaxConcurrentStreamsLowWatermark: 100,
+			},
+		},
+	})
+	picker := newGCPPicker(scRefs, gb)
+	pick := func() balancer.SubConn {
+		pr, err := picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+		if err != nil {
+			t.Fatalf("gcpPicker.Pick returns err: %v", err)
+		}
+		pr.Done(balancer.DoneInfo{})
+		return pr.SubConn
+	}
 
-	mockCC := mocks.NewMockClientConn(mockCtrl)
-	newSC := mocks.NewMockSubConn(mockCtrl)
-	newSC.EXPECT().Connect().Times(1)
-	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).Return(newSC, nil).Times(1)
+	if sc := pick(); sc != limitedSC {
+		t.Fatalf("gcpPicker.Pick without server limit returns %v, want: %v", sc, limitedSC)
+	}
+	// The least busy channel is at the server limit.
+	if err := gb.setServerMaxStreams(0, 2); err != nil {
+		t.Fatalf("setServerMaxStreams returned error: %v", err)
+	}
+	if sc := pick(); sc != okSC {
+		t.Fatalf("gcpPicker.Pick with server limit returns %v, want: %v", sc, okSC)
+	}
+	if err := gb.setServerMaxStreams(2, 2); err == nil {
+		t.Fatalf("setServerMaxStreams for a missing channel returned no error")
+	}
+}
 
-	mp := make(map[balancer.SubConn]*subConnRef)
-	mp[mockSC] = scRefs[0]
-	b := &gcpBalancer{
-		cc:       mockCC,
-		scRefs:   mp,
-		scStates: make(map[balancer.SubConn]connectivity.State),
-		cfg: &GCPBalancerConfig{
+func TestPickSpreadsCallAttempts(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var scRefs []*subConnRef
+	for i := 0; i < 3; i++ {
+		scRefs = append(scRefs, &subConnRef{
+			id:          i,
+			subConn:     mocks.NewMockSubConn(mockCtrl),
+			stateSignal: make(chan struct{}),
+			streamsCnt:  int32(i),
+		})
+	}
+	newPicker := func(spread bool) balancer.Picker {
+		gb := withConfig(&gcpBalancer{
+			scRefList: scRefs,
+			log:       compLogger,
+		}, &GCPBalancerConfig{
 			ApiConfig: &pb.ApiConfig{
 				ChannelPool: &pb.ChannelPoolConfig{
-					MaxSize:                          10,
+					MaxSize:                          3,
 					MaxConcurrentStreamsLowWatermark: 100,
+					SpreadCallAttempts:               spread,
 				},
 			},
-		},
-		log: compLogger,
+		})
+		return newGCPPicker(scRefs, gb)
+	}
+	// attempts picks n attempts of the same call, each failing after the pick.
+	attempts := func(picker balancer.Picker, n int) []balancer.SubConn {
+		ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{})
+		var picked []balancer.SubConn
+		for i := 0; i < n; i++ {
+			pr, err := picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: ctx})
+			if err != nil {
+				t.Fatalf("gcpPicker.Pick returns err: %v", err)
+			}
+			pr.Done(balancer.DoneInfo{Err: status.Error(codes.Unavailable, "")})
+			picked = append(picked, pr.SubConn)
+		}
+		return picked
 	}
 
-	picker := newGCPPicker(scRefs, b)
-
-	ctx := context.Background()
-
-	pr, err := picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: ctx})
-	sc := pr.SubConn
-
-	wantErr := balancer.ErrNoSubConnAvailable
-	if sc != nil || err != wantErr {
-		t.Fatalf("gcpPicker.Pick returns %v, _, %v, want: nil, _, %v", sc, err, wantErr)
+	want := []balancer.SubConn{scRefs[0].subConn, scRefs[0].subConn}
+	if got := attempts(newPicker(false), 2); got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("attempts without spreading picked %v, want %v", got, want)
 	}
-	if _, ok := b.scRefs[newSC]; !ok {
-		t.Fatalf("Created SubConn is not stored in gcpBalancer.scRefs")
+	// Once all channels were used, the least busy one is picked again.
+	want = []balancer.SubConn{scRefs[0].subConn, scRefs[1].subConn, scRefs[2].subConn, scRefs[0].subConn}
+	got := attempts(newPicker(true), 4)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("attempts with spreading picked %v, want %v", got, want)
+		}
 	}
 }
 
-func TestBindSubConn(t *testing.T) {
+func TestPickSubConnWithLowestLatency(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
-	scBusy := mocks.NewMockSubConn(mockCtrl)
-	scIdle := mocks.NewMockSubConn(mockCtrl)
-	scBusy.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
-	scIdle.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
-	scBusy.EXPECT().Connect().AnyTimes()
-	scIdle.EXPECT().Connect().AnyTimes()
-	mockCC := mocks.NewMockClientConn(mockCtrl)
-	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
-	mp := make(map[balancer.SubConn]*subConnRef)
-	mp[scBusy] = &subConnRef{
-		subConn:     scBusy,
-		stateSignal: make(chan struct{}),
-		affinityCnt: 0,
-		streamsCnt:  5,
+	newRef := func(latency time.Duration, errRate float64, streams int32) *subConnRef {
+		ref := &subConnRef{
+			subConn:     mocks.NewMockSubConn(mockCtrl),
+			stateSignal: make(chan struct{}),
+			streamsCnt:  streams,
+		}
+		ref.latency.add(float64(latency))
+		ref.errorRate.add(errRate)
+		return ref
 	}
-	mp[scIdle] = &subConnRef{
-		subConn:     scIdle,
-		stateSignal: make(chan struct{}),
-		affinityCnt: 0,
-		streamsCnt:  0,
+	scRefs := []*subConnRef{
+		// Slow.
+		newRef(100*time.Millisecond, 0, 0),
+		// Fast but busy.
+		newRef(10*time.Millisecond, 0, 20),
+		// Fast but erroring.
+		newRef(10*time.Millisecond, 0.5, 0),
+		// Fast enough.
+		newRef(20*time.Millisecond, 0, 1),
+		// Fastest but reached the streams limit.
+		newRef(time.Millisecond, 0, 100),
 	}
 
-	testMethod := "testBindMethod"
-	gcpcfg := &GCPBalancerConfig{
+	picker := newGCPPicker(scRefs, withConfig(&gcpBalancer{
+		log: compLogger,
+	}, &GCPBalancerConfig{
 		ApiConfig: &pb.ApiConfig{
 			ChannelPool: &pb.ChannelPoolConfig{
-				MaxSize:                          2,
+				MaxSize:                          5,
 				MaxConcurrentStreamsLowWatermark: 100,
+				PickStrategy:                     pb.ChannelPoolConfig_PICK_LOWEST_LATENCY,
 			},
-			Method: []*pb.MethodConfig{
-				{
-					Name: []string{testMethod},
-					Affinity: &pb.AffinityConfig{
-						Command:     pb.AffinityConfig_BIND,
-						AffinityKey: "key",
-					},
-				},
-			},
-		},
-	}
-
-	// Simulate a pool with two connections.
-	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
-	b.scRefs = mp
-	b.scStates[scBusy] = connectivity.Idle
-	b.scStates[scIdle] = connectivity.Idle
-	// Simulate resolver.
-	b.UpdateClientConnState(balancer.ClientConnState{
-		ResolverState: resolver.State{
-			Addresses: b.addrs,
 		},
-		BalancerConfig: gcpcfg,
-	})
-
-	// Simulate connections moved to the ready state.
-	b.UpdateSubConnState(scBusy, balancer.SubConnState{ConnectivityState: connectivity.Ready})
-	b.UpdateSubConnState(scIdle, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}))
 
-	// Prepare a bind call context.
-	ctx := context.Background()
-	gcpCtx := &gcpContext{
-		reqMsg: &testMsg{},
+	pr, err := picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+	if want := scRefs[3].subConn; pr.SubConn != want || err != nil {
+		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", pr.SubConn, err, want)
 	}
-	ctx = context.WithValue(ctx, gcpKey, gcpCtx)
 
-	// Idle subconn shoud be returned.
-	pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
-	sc := pr.SubConn
-	if sc != scIdle || err != nil {
-		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", sc, err, scIdle)
+	// Slow calls on the picked channel shift the preference.
+	for i := 0; i < 10; i++ {
+		scRefs[3].recordCall(time.Second, false, nil)
 	}
-
-	testKey := "test_key"
-	// Simulate bind call completion.
-	gcpCtx.replyMsg = &testMsg{
-		Key: testKey,
+	// The erroring channel is still better than the slow one.
+	pr, err = picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+	if want := scRefs[2].subConn; pr.SubConn != want || err != nil {
+		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", pr.SubConn, err, want)
 	}
-	pr.Done(balancer.DoneInfo{})
 
-	// Make sure the key is mapped to the subconn.
-	if mappedSc, ok := b.affinityMap[testKey]; !ok || mappedSc != scIdle {
-		t.Fatalf("b.affinityMap[testKey] returned: %v, %v, want: %v, %v", mappedSc, ok, scIdle, true)
+	// More errors make the erroring channel worse than the slow one.
+	for i := 0; i < 10; i++ {
+		scRefs[2].recordCall(0, false, deErr)
+	}
+	pr, err = picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+	if want := scRefs[0].subConn; pr.SubConn != want || err != nil {
+		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", pr.SubConn, err, want)
 	}
 }
 
-func TestPickMappedSubConn(t *testing.T) {
+func TestPickSubConnWithLowestLatencyFailingChannel(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
-	mockSCnotmapped := mocks.NewMockSubConn(mockCtrl)
-	mockSCmapped := mocks.NewMockSubConn(mockCtrl)
-	mockSCnotmapped.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
-	mockSCmapped.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
-	mockSCnotmapped.EXPECT().Connect().AnyTimes()
-	mockSCmapped.EXPECT().Connect().AnyTimes()
-	mockCC := mocks.NewMockClientConn(mockCtrl)
-	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
-	mp := make(map[balancer.SubConn]*subConnRef)
-	mp[mockSCnotmapped] = &subConnRef{
-		subConn:     mockSCnotmapped,
+	scRefs := []*subConnRef{
+		{subConn: mocks.NewMockSubConn(mockCtrl), stateSignal: make(chan struct{})},
+		{subConn: mocks.NewMockSubConn(mockCtrl), stateSignal: make(chan struct{})},
+	}
+	picker := newGCPPicker(scRefs, withConfig(&gcpBalancer{
+		log: compLogger,
+	}, &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          2,
+				MaxConcurrentStreamsLowWatermark: 100,
+				PickStrategy:                     pb.ChannelPoolConfig_PICK_LOWEST_LATENCY,
+			},
+		},
+	}))
+
+	// Every call of the first channel fails, so it has no latency samples.
+	for i := 0; i < 10; i++ {
+		scRefs[0].recordCall(0, false, deErr)
+	}
+	for _, sampled := range []bool{false, true} {
+		if sampled {
+			scRefs[1].recordCall(50*time.Millisecond, false, nil)
+		}
+		pr, err := picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+		if want := scRefs[1].subConn; pr.SubConn != want || err != nil {
+			t.Fatalf("gcpPicker.Pick with healthy channel sampled: %v returns %v, %v, want: %v, nil", sampled, pr.SubConn, err, want)
+		}
+		pr.Done(balancer.DoneInfo{})
+	}
+}
+
+// orcaTrailer returns a trailer with an ORCA load report.
+func orcaTrailer(cpu, app float64) metadata.MD {
+	var b []byte
+	b = protowire.AppendTag(b, orcaCPUUtilizationField, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(cpu))
+	// Memory utilization is skipped.
+	b = protowire.AppendTag(b, 2, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(0.3))
+	if app > 0 {
+		b = protowire.AppendTag(b, orcaApplicationUtilizationField, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(app))
+	}
+	return metadata.Pairs(orcaTrailerKey, string(b))
+}
+
+func TestPickSubConnWithLeastUtilization(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	newRef := func(utilization float64, streams int32) *subConnRef {
+		ref := &subConnRef{
+			subConn:     mocks.NewMockSubConn(mockCtrl),
+			stateSignal: make(chan struct{}),
+			streamsCnt:  streams,
+		}
+		ref.utilization.add(utilization)
+		return ref
+	}
+	scRefs := []*subConnRef{
+		// Hot backend.
+		newRef(0.9, 0),
+		// Cold backend with a stream.
+		newRef(0.2, 1),
+		// Warm backend.
+		newRef(0.5, 0),
+		// Cold backend which reached the streams limit.
+		newRef(0.1, 100),
+	}
+
+	picker := newGCPPicker(scRefs, withConfig(&gcpBalancer{
+		log: compLogger,
+	}, &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          4,
+				MaxConcurrentStreamsLowWatermark: 100,
+				PickStrategy:                     pb.ChannelPoolConfig_PICK_LEAST_UTILIZATION,
+			},
+		},
+	}))
+
+	pr, err := picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+	if want := scRefs[1].subConn; pr.SubConn != want || err != nil {
+		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", pr.SubConn, err, want)
+	}
+	// The backend reports a high application utilization, which takes
+	// precedence over the CPU utilization.
+	pr.Done(balancer.DoneInfo{Trailer: orcaTrailer(0.1, 0.95)})
+	if got, want := scRefs[1].utilization.value(), 0.2*(1-ewmaAlpha)+0.95*ewmaAlpha; math.Abs(got-want) > 1e-9 {
+		t.Fatalf("utilization after the report is %v, want %v", got, want)
+	}
+	pr, err = picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+	if want := scRefs[2].subConn; pr.SubConn != want || err != nil {
+		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", pr.SubConn, err, want)
+	}
+
+	if u, ok := parseOrcaUtilization([]byte(orcaTrailer(0.4, 0).Get(orcaTrailerKey)[0])); !ok || u != 0.4 {
+		t.Fatalf("parseOrcaUtilization without application utilization returns %v, %v, want: 0.4, true", u, ok)
+	}
+	if _, ok := parseOrcaUtilization([]byte{0xff}); ok {
+		t.Fatalf("parseOrcaUtilization of a malformed report returns ok")
+	}
+}
+
+func TestPickSubConnWeightedRoundRobin(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	newRef := func(errorRate float64, streams int32) *subConnRef {
+		ref := &subConnRef{
+			subConn:     mocks.NewMockSubConn(mockCtrl),
+			stateSignal: make(chan struct{}),
+			streamsCnt:  streams,
+		}
+		ref.errorRate.add(errorRate)
+		return ref
+	}
+	scRefs := []*subConnRef{
+		// Full budget, weight 10.
+		newRef(0, 0),
+		// Half of the budget used, weight 5.
+		newRef(0, 5),
+		// Full budget but half of the calls fail, weight 5.
+		newRef(0.5, 0),
+		// No budget left.
+		newRef(0, 10),
+	}
+
+	picker := newGCPPicker(scRefs, withConfig(&gcpBalancer{
+		log: compLogger,
+	}, &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          4,
+				MaxConcurrentStreamsLowWatermark: 10,
+				PickStrategy:                     pb.ChannelPoolConfig_PICK_WEIGHTED_ROUND_ROBIN,
+			},
+		},
+	}))
+
+	picks := make(map[balancer.SubConn]int)
+	var last balancer.SubConn
+	run := 0
+	for i := 0; i < 20; i++ {
+		pr, err := picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+		if err != nil {
+			t.Fatalf("gcpPicker.Pick returns err: %v", err)
+		}
+		picks[pr.SubConn]++
+		if pr.SubConn == last {
+			run++
+		} else {
+			last, run = pr.SubConn, 1
+		}
+		if run > 2 {
+			t.Fatalf("pick %d is the %d-th consecutive pick of SubConn %v, want interleaved picks", i, run, pr.SubConn)
+		}
+		// Keep the weights constant.
+		for _, ref := range scRefs {
+			if ref.subConn == pr.SubConn {
+				ref.streamsDecr(nil)
+			}
+		}
+	}
+	for i, want := range []int{10, 5, 5, 0} {
+		if got := picks[scRefs[i].subConn]; got != want {
+			t.Errorf("SubConn %d picked %d times, want %d", i, got, want)
+		}
+	}
+}
+
+func TestPickRotatesAmongEquallyBusy(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	newRefs := func() []*subConnRef {
+		refs := []*subConnRef{}
+		for i := 0; i < 3; i++ {
+			refs = append(refs, &subConnRef{
+				id:          i,
+				subConn:     mocks.NewMockSubConn(mockCtrl),
+				stateSignal: make(chan struct{}),
+			})
+		}
+		return refs
+	}
+	cfg := &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          3,
+				MaxConcurrentStreamsLowWatermark: 100,
+			},
+		},
+	}
+	picks := func(picker balancer.Picker, refs []*subConnRef) map[balancer.SubConn]int {
+		t.Helper()
+		got := make(map[balancer.SubConn]int)
+		for i := 0; i < 6; i++ {
+			pr, err := picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+			if err != nil {
+				t.Fatalf("gcpPicker.Pick returns err: %v", err)
+			}
+			got[pr.SubConn]++
+			// Keep the channels equally busy.
+			pr.Done(balancer.DoneInfo{})
+		}
+		return got
+	}
+
+	refs := newRefs()
+	got := picks(newGCPPicker(refs, withConfig(&gcpBalancer{log: compLogger}, cfg)), refs)
+	for _, ref := range refs {
+		if got[ref.subConn] != 2 {
+			t.Fatalf("equally busy SubConn %d picked %d times of 6, want 2", ref.id, got[ref.subConn])
+		}
+	}
+
+	// The first channel wins the ties with Config.Deterministic.
+	refs = newRefs()
+	got = picks(newGCPPicker(refs, withConfig(&gcpBalancer{log: compLogger, opts: Config{Deterministic: true}}, cfg)), refs)
+	if got[refs[0].subConn] != 6 {
+		t.Fatalf("SubConn 0 picked %d times of 6 with Config.Deterministic, want 6", got[refs[0].subConn])
+	}
+}
+
+func TestPickInjectsChannelIdHeader(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	var scRefs = []*subConnRef{
+		{
+			id:          3,
+			subConn:     mocks.NewMockSubConn(mockCtrl),
+			stateSignal: make(chan struct{}),
+		},
+	}
+	cfg := &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          10,
+				MaxConcurrentStreamsLowWatermark: 100,
+			},
+		},
+	}
+	picker := newGCPPicker(scRefs, withConfig(&gcpBalancer{log: compLogger}, cfg))
+
+	pr, err := picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+	if err != nil {
+		t.Fatalf("gcpPicker.Pick returns err: %v", err)
+	}
+	if pr.Metadata != nil {
+		t.Fatalf("gcpPicker.Pick returns metadata: %v, want: nil", pr.Metadata)
+	}
+
+	cfg.GetChannelPool().ChannelIdHeader = "X-Grpcgcp-Channel-Id"
+	pr, err = picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+	if err != nil {
+		t.Fatalf("gcpPicker.Pick returns err: %v", err)
+	}
+	if got, want := pr.Metadata.Get("x-grpcgcp-channel-id"), []string{"3"}; !cmp.Equal(got, want) {
+		t.Fatalf("gcpPicker.Pick returns channel id header: %v, want: %v", got, want)
+	}
+}
+
+func TestPickNewSubConn(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockSC := mocks.NewMockSubConn(mockCtrl)
+	var scRefs = []*subConnRef{
+		{
+			subConn:     mockSC,
+			stateSignal: make(chan struct{}),
+			affinityCnt: 0,
+			streamsCnt:  100,
+		},
+	}
+
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	newSC := mocks.NewMockSubConn(mockCtrl)
+	newSC.EXPECT().Connect().Times(1)
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).Return(newSC, nil).Times(1)
+
+	mp := make(map[balancer.SubConn]*subConnRef)
+	mp[mockSC] = scRefs[0]
+	b := withConfig(&gcpBalancer{
+		cc:       mockCC,
+		scRefs:   mp,
+		scStates: make(map[balancer.SubConn]connectivity.State),
+		log:      compLogger,
+	}, &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          10,
+				MaxConcurrentStreamsLowWatermark: 100,
+			},
+		},
+	})
+
+	picker := newGCPPicker(scRefs, b)
+
+	ctx := context.Background()
+
+	pr, err := picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: ctx})
+	sc := pr.SubConn
+
+	wantErr := balancer.ErrNoSubConnAvailable
+	if sc != nil || err != wantErr {
+		t.Fatalf("gcpPicker.Pick returns %v, _, %v, want: nil, _, %v", sc, err, wantErr)
+	}
+	if _, ok := b.scRefs[newSC]; !ok {
+		t.Fatalf("Created SubConn is not stored in gcpBalancer.scRefs")
+	}
+}
+
+func TestPickMaxWait(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockSC := mocks.NewMockSubConn(mockCtrl)
+	var scRefs = []*subConnRef{
+		{
+			subConn:     mockSC,
+			stateSignal: make(chan struct{}),
+			streamsCnt:  100,
+		},
+	}
+
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		return newSC, nil
+	}).AnyTimes()
+
+	mp := make(map[balancer.SubConn]*subConnRef)
+	mp[mockSC] = scRefs[0]
+	b := withConfig(&gcpBalancer{
+		cc:       mockCC,
+		scRefs:   mp,
+		scStates: map[balancer.SubConn]connectivity.State{mockSC: connectivity.Ready},
+		log:      compLogger,
+		done:     make(chan struct{}),
+	}, &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          10,
+				MaxConcurrentStreamsLowWatermark: 100,
+				MaxPickWaitMs:                    60000,
+			},
+		},
+	})
+	defer close(b.done)
+	picker := newGCPPicker(scRefs, b)
+
+	// Calls with a deadline wait for a channel without limit.
+	dctx, cancel := context.WithTimeout(context.WithValue(context.Background(), gcpKey, &gcpContext{}), time.Hour)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if _, err := picker.Pick(balancer.PickInfo{Ctx: dctx}); err != balancer.ErrNoSubConnAvailable {
+			t.Fatalf("gcpPicker.Pick with deadline returned %v, want %v", err, balancer.ErrNoSubConnAvailable)
+		}
+	}
+
+	gcpCtx := &gcpContext{}
+	ctx := context.WithValue(context.Background(), gcpKey, gcpCtx)
+	if _, err := picker.Pick(balancer.PickInfo{Ctx: ctx}); err != balancer.ErrNoSubConnAvailable {
+		t.Fatalf("gcpPicker.Pick returned %v, want %v", err, balancer.ErrNoSubConnAvailable)
+	}
+	if atomic.LoadInt64(&gcpCtx.queuedAt) == 0 {
+		t.Fatalf("waiting call is not queued")
+	}
+	// Still within the max wait.
+	if _, err := picker.Pick(balancer.PickInfo{Ctx: ctx}); err != balancer.ErrNoSubConnAvailable {
+		t.Fatalf("gcpPicker.Pick returned %v, want %v", err, balancer.ErrNoSubConnAvailable)
+	}
+
+	atomic.StoreInt64(&gcpCtx.queuedAt, time.Now().Add(-time.Minute).UnixNano())
+	_, err := picker.Pick(balancer.PickInfo{Ctx: ctx})
+	var pe *PoolExhaustedError
+	if !errors.As(err, &pe) || !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("gcpPicker.Pick returned %v, want PoolExhaustedError", err)
+	}
+	if pe.Waited < time.Minute || pe.MaxSize != 10 || pe.Pool.Channels != len(b.scRefs) || pe.Pool.ReadyChannels != 1 || pe.Pool.ActiveStreams != 100 {
+		t.Fatalf("unexpected PoolExhaustedError: %+v", pe)
+	}
+	if got, want := status.Code(err), codes.ResourceExhausted; got != want {
+		t.Fatalf("status code of PoolExhaustedError is %v, want %v", got, want)
+	}
+
+	// A picked call is no longer queued.
+	atomic.StoreInt32(&scRefs[0].streamsCnt, 0)
+	if _, err := picker.Pick(balancer.PickInfo{Ctx: ctx}); err != nil {
+		t.Fatalf("gcpPicker.Pick returned unexpected error: %v", err)
+	}
+	if atomic.LoadInt64(&gcpCtx.queuedAt) != 0 {
+		t.Fatalf("picked call is still queued")
+	}
+}
+
+func TestPickWithCallPriority(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	sc1 := mocks.NewMockSubConn(mockCtrl)
+	sc2 := mocks.NewMockSubConn(mockCtrl)
+	var scRefs = []*subConnRef{
+		{
+			subConn:     sc1,
+			stateSignal: make(chan struct{}),
+			streamsCnt:  8,
+		},
+		{
+			subConn:     sc2,
+			stateSignal: make(chan struct{}),
+			streamsCnt:  9,
+		},
+	}
+
+	repicked := make(chan struct{}, 1)
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).Do(func(interface{}) {
+		repicked <- struct{}{}
+	}).AnyTimes()
+
+	b := withConfig(&gcpBalancer{
+		cc:       mockCC,
+		scRefs:   map[balancer.SubConn]*subConnRef{sc1: scRefs[0], sc2: scRefs[1]},
+		scStates: map[balancer.SubConn]connectivity.State{sc1: connectivity.Ready, sc2: connectivity.Ready},
+		log:      compLogger,
+		done:     make(chan struct{}),
+	}, &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          2,
+				MaxConcurrentStreamsLowWatermark: 10,
+				LowPriorityReservedStreams:       3,
+			},
+			Method: []*pb.MethodConfig{
+				{
+					Name:        []string{"lowMethod"},
+					ChannelPool: &pb.MethodChannelPoolConfig{Priority: pb.CallPriority_LOW},
+				},
+			},
+		},
+	})
+	defer close(b.done)
+	picker := newGCPPicker(scRefs, b)
+
+	pick := func(ctx context.Context, method string) (balancer.PickResult, error) {
+		return picker.Pick(balancer.PickInfo{FullMethodName: method, Ctx: ctx})
+	}
+
+	// Normal priority calls use the streams reserved for them.
+	pr, err := pick(context.Background(), "normalMethod")
+	if err != nil || pr.SubConn != sc1 {
+		t.Fatalf("pick of a normal priority call returned %v, %v, want %v, nil", pr.SubConn, err, sc1)
+	}
+
+	// Low priority calls of the method config and the context wait, all
+	// channels are busy for them and the pool is at its max size.
+	for _, ctx := range []context.Context{
+		context.Background(),
+		WithCallPriority(context.Background(), pb.CallPriority_LOW),
+	} {
+		if _, err := pick(ctx, "lowMethod"); err != balancer.ErrNoSubConnAvailable {
+			t.Fatalf("pick of a low priority call returned %v, want %v", err, balancer.ErrNoSubConnAvailable)
+		}
+	}
+	// The context overrides the priority of the method config.
+	if _, err := pick(WithCallPriority(context.Background(), pb.CallPriority_NORMAL), "lowMethod"); err != nil {
+		t.Fatalf("pick with normal priority context returned unexpected error: %v", err)
+	}
+	atomic.AddInt32(&scRefs[1].streamsCnt, -1)
+
+	// A finished call provides a new picker for the waiting low priority calls.
+	pr.Done(balancer.DoneInfo{})
+	select {
+	case <-repicked:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for a new picker for the low priority calls")
+	}
+	atomic.StoreInt32(&scRefs[0].streamsCnt, 6)
+	if pr, err := pick(context.Background(), "lowMethod"); err != nil || pr.SubConn != sc1 {
+		t.Fatalf("pick of a low priority call returned %v, %v, want %v, nil", pr.SubConn, err, sc1)
+	}
+
+	// High priority calls do not wait for the pool to grow.
+	b.config().ChannelPool.MaxSize = 3
+	atomic.StoreInt32(&scRefs[0].streamsCnt, 11)
+	atomic.StoreInt32(&scRefs[1].streamsCnt, 10)
+	newSC := mocks.NewMockSubConn(mockCtrl)
+	newSC.EXPECT().Connect().Times(1)
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).Return(newSC, nil).Times(1)
+	highCtx := WithCallPriority(context.Background(), pb.CallPriority_HIGH)
+	if pr, err := pick(highCtx, "normalMethod"); err != nil || pr.SubConn != sc2 {
+		t.Fatalf("pick of a high priority call returned %v, %v, want %v, nil", pr.SubConn, err, sc2)
+	}
+	// Normal priority calls wait for the new channel while the pool may grow.
+	b.config().ChannelPool.MaxSize = 4
+	if _, err := pick(context.Background(), "normalMethod"); err != balancer.ErrNoSubConnAvailable {
+		t.Fatalf("pick of a normal priority call returned %v, want %v", err, balancer.ErrNoSubConnAvailable)
+	}
+}
+
+func TestBindSubConn(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scBusy := mocks.NewMockSubConn(mockCtrl)
+	scIdle := mocks.NewMockSubConn(mockCtrl)
+	scBusy.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+	scIdle.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+	scBusy.EXPECT().Connect().AnyTimes()
+	scIdle.EXPECT().Connect().AnyTimes()
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mp := make(map[balancer.SubConn]*subConnRef)
+	mp[scBusy] = &subConnRef{
+		subConn:     scBusy,
+		stateSignal: make(chan struct{}),
+		affinityCnt: 0,
+		streamsCnt:  5,
+	}
+	mp[scIdle] = &subConnRef{
+		subConn:     scIdle,
+		stateSignal: make(chan struct{}),
+		affinityCnt: 0,
+		streamsCnt:  0,
+	}
+
+	testMethod := "testBindMethod"
+	gcpcfg := &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          2,
+				MaxConcurrentStreamsLowWatermark: 100,
+			},
+			Method: []*pb.MethodConfig{
+				{
+					Name: []string{testMethod},
+					Affinity: &pb.AffinityConfig{
+						Command:     pb.AffinityConfig_BIND,
+						AffinityKey: "key",
+					},
+				},
+			},
+		},
+	}
+
+	// Simulate a pool with two connections.
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.scRefs = mp
+	b.scStates[scBusy] = connectivity.Idle
+	b.scStates[scIdle] = connectivity.Idle
+	// Simulate resolver.
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{
+			Addresses: b.addrs,
+		},
+		BalancerConfig: gcpcfg,
+	})
+
+	// Simulate connections moved to the ready state.
+	b.UpdateSubConnState(scBusy, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.UpdateSubConnState(scIdle, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+
+	// Prepare a bind call context.
+	ctx := context.Background()
+	gcpCtx := &gcpContext{
+		reqMsg: &testMsg{},
+	}
+	ctx = context.WithValue(ctx, gcpKey, gcpCtx)
+
+	// Idle subconn shoud be returned.
+	pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
+	sc := pr.SubConn
+	if sc != scIdle || err != nil {
+		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", sc, err, scIdle)
+	}
+
+	testKey := "test_key"
+	// Simulate bind call completion.
+	gcpCtx.replyMsg = &testMsg{
+		Key: testKey,
+	}
+	pr.Done(balancer.DoneInfo{})
+
+	// Make sure the key is mapped to the subconn.
+	if mappedSc, ok := b.affinityMap[testKey]; !ok || mappedSc != scIdle {
+		t.Fatalf("b.affinityMap[testKey] returned: %v, %v, want: %v, %v", mappedSc, ok, scIdle, true)
+	}
+}
+
+func TestBindSubConnOnFirstStreamResponse(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	sc := mocks.NewMockSubConn(mockCtrl)
+	sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+	sc.EXPECT().Connect().AnyTimes()
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mp := make(map[balancer.SubConn]*subConnRef)
+	mp[sc] = &subConnRef{
+		subConn:     sc,
+		stateSignal: make(chan struct{}),
+	}
+
+	testMethod := "testBindStreamMethod"
+	gcpcfg := &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          1,
+				MaxConcurrentStreamsLowWatermark: 100,
+			},
+			Method: []*pb.MethodConfig{
+				{
+					Name: []string{testMethod},
+					Affinity: &pb.AffinityConfig{
+						Command:     pb.AffinityConfig_BIND,
+						AffinityKey: "key",
+					},
+				},
+			},
+		},
+	}
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.scRefs = mp
+	b.scStates[sc] = connectivity.Idle
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{
+			Addresses: b.addrs,
+		},
+		BalancerConfig: gcpcfg,
+	})
+	b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+
+	// Prepare a server-streaming bind call context as the stream interceptor
+	// does.
+	gcpCtx := &gcpContext{
+		reqMsg:    &testMsg{},
+		streaming: true,
+	}
+	ctx := context.WithValue(context.Background(), gcpKey, gcpCtx)
+	pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
+	if pr.SubConn != sc || err != nil {
+		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", pr.SubConn, err, sc)
+	}
+	if gcpCtx.firstRecv == nil {
+		t.Fatalf("gcpPicker.Pick did not set the first response post-process of a streaming bind call")
+	}
+
+	// The key must be bound on the first response while the stream is active.
+	testKey := "test_key"
+	gcpCtx.firstRecv(&testMsg{Key: testKey})
+	if mappedSc, ok := b.affinityMap[testKey]; !ok || mappedSc != sc {
+		t.Fatalf("b.affinityMap[testKey] returned: %v, %v, want: %v, %v", mappedSc, ok, sc, true)
+	}
+
+	// Stream completion must not bind again.
+	pr.Done(balancer.DoneInfo{})
+	if got := b.scRefs[sc].getAffinityCnt(); got != 1 {
+		t.Fatalf("affinity count after stream completion is %d, want 1", got)
+	}
+}
+
+func TestPickMappedSubConn(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockSCnotmapped := mocks.NewMockSubConn(mockCtrl)
+	mockSCmapped := mocks.NewMockSubConn(mockCtrl)
+	mockSCnotmapped.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+	mockSCmapped.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+	mockSCnotmapped.EXPECT().Connect().AnyTimes()
+	mockSCmapped.EXPECT().Connect().AnyTimes()
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mp := make(map[balancer.SubConn]*subConnRef)
+	mp[mockSCnotmapped] = &subConnRef{
+		subConn:     mockSCnotmapped,
+		stateSignal: make(chan struct{}),
+		affinityCnt: 0,
+		streamsCnt:  0,
+	}
+	mp[mockSCmapped] = &subConnRef{
+		subConn:     mockSCmapped,
+		stateSignal: make(chan struct{}),
+		affinityCnt: 0,
+		streamsCnt:  5,
+	}
+
+	testMethod := "testMethod"
+	gcpcfg := &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          2,
+				MaxConcurrentStreamsLowWatermark: 100,
+			},
+			Method: []*pb.MethodConfig{
+				{
+					Name: []string{testMethod},
+					Affinity: &pb.AffinityConfig{
+						Command:     pb.AffinityConfig_BOUND,
+						AffinityKey: "key",
+					},
+				},
+			},
+		},
+	}
+
+	// Simulate a pool with two connections.
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.scRefs = mp
+	b.scStates[mockSCnotmapped] = connectivity.Idle
+	b.scStates[mockSCmapped] = connectivity.Idle
+	// Simulate resolver.
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{
+			Addresses: b.addrs,
+		},
+		BalancerConfig: gcpcfg,
+	})
+
+	// Simulate connections moved to the ready state.
+	b.UpdateSubConnState(mockSCnotmapped, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.UpdateSubConnState(mockSCmapped, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+
+	// Bind the key to the SubConn.
+	theKey := "key-for-not-ready"
+	b.bindSubConn(theKey, mockSCmapped)
+
+	// Prepare call context with the mapped key.
+	ctx := context.Background()
+	gcpCtx := &gcpContext{
+		reqMsg: &testMsg{
+			Key: theKey,
+		},
+	}
+	ctx = context.WithValue(ctx, gcpKey, gcpCtx)
+
+	// The mapped connection shoud be returned.
+	pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
+	sc := pr.SubConn
+
+	if sc != mockSCmapped || err != nil {
+		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", sc, err, mockSCmapped)
+	}
+
+	// Simulate the mapped connection moved to the error state.
+	b.UpdateSubConnState(
+		mockSCmapped,
+		balancer.SubConnState{ConnectivityState: connectivity.TransientFailure},
+	)
+
+	// The picker should return ErrNoSubConnAvailable for the same context
+	// because the connection mapped to the key is not in the ready state.
+	pr, err = b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
+	sc = pr.SubConn
+
+	wantErr := balancer.ErrNoSubConnAvailable
+	if sc != nil || err != wantErr {
+		t.Fatalf("gcpPicker.Pick returns %v, _, %v, want: nil, _, %v", sc, err, wantErr)
+	}
+}
+
+func TestBoundResponseAffinityValidation(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	sc1 := mocks.NewMockSubConn(mockCtrl)
+	sc2 := mocks.NewMockSubConn(mockCtrl)
+	for _, sc := range []*mocks.MockSubConn{sc1, sc2} {
+		sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		sc.EXPECT().Connect().AnyTimes()
+	}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mp := make(map[balancer.SubConn]*subConnRef)
+	mp[sc1] = &subConnRef{
+		id:          0,
+		subConn:     sc1,
+		stateSignal: make(chan struct{}),
+	}
+	mp[sc2] = &subConnRef{
+		id:          1,
+		subConn:     sc2,
+		stateSignal: make(chan struct{}),
+	}
+
+	testMethod := "testMethod"
+	gcpcfg := &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          2,
+				MaxConcurrentStreamsLowWatermark: 100,
+			},
+			Method: []*pb.MethodConfig{
+				{
+					Name: []string{testMethod},
+					Affinity: &pb.AffinityConfig{
+						Command:             pb.AffinityConfig_BOUND,
+						AffinityKey:         "key",
+						ResponseAffinityKey: "nestedField.key",
+					},
+				},
+			},
+		},
+	}
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.scRefs = mp
+	b.scStates[sc1] = connectivity.Idle
+	b.scStates[sc2] = connectivity.Idle
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{
+			Addresses: b.addrs,
+		},
+		BalancerConfig: gcpcfg,
+	})
+	b.UpdateSubConnState(sc1, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.UpdateSubConnState(sc2, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.bindSubConn("session1", sc1)
+	b.bindSubConn("session2", sc2)
+
+	for _, tc := range []struct {
+		respKey        string
+		wantMismatches uint64
+	}{
+		// Same session as in the request.
+		{"session1", 0},
+		// Unknown session.
+		{"session3", 0},
+		// Session bound to another channel.
+		{"session2", 1},
+	} {
+		gcpCtx := &gcpContext{
+			reqMsg:   &testMsg{Key: "session1"},
+			replyMsg: &testMsg{NestedField: &nestedField{Key: tc.respKey}},
+		}
+		ctx := context.WithValue(context.Background(), gcpKey, gcpCtx)
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
+		if pr.SubConn != sc1 || err != nil {
+			t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", pr.SubConn, err, sc1)
+		}
+		pr.Done(balancer.DoneInfo{})
+		if got := b.poolMetrics().AffinityMismatches; got != tc.wantMismatches {
+			t.Fatalf("AffinityMismatches after response with %q is %d, want %d", tc.respKey, got, tc.wantMismatches)
+		}
+	}
+}
+
+func TestFallbackAffinityKeys(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	sc1 := mocks.NewMockSubConn(mockCtrl)
+	sc2 := mocks.NewMockSubConn(mockCtrl)
+	sc3 := mocks.NewMockSubConn(mockCtrl)
+	for _, sc := range []*mocks.MockSubConn{sc1, sc2, sc3} {
+		sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		sc.EXPECT().Connect().AnyTimes()
+	}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mp := make(map[balancer.SubConn]*subConnRef)
+	for i, sc := range []balancer.SubConn{sc1, sc2, sc3} {
+		mp[sc] = &subConnRef{
+			id:          i,
+			subConn:     sc,
+			stateSignal: make(chan struct{}),
+		}
+	}
+	// The unbound calls use the least busy subconn.
+	mp[sc1].streamsCnt = 5
+	mp[sc2].streamsCnt = 5
+
+	testMethod := "testMethod"
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.scRefs = mp
+	for sc := range mp {
+		b.scStates[sc] = connectivity.Idle
+	}
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{
+			Addresses: b.addrs,
+		},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MaxSize:                          3,
+					MaxConcurrentStreamsLowWatermark: 100,
+				},
+				Method: []*pb.MethodConfig{
+					{
+						Name: []string{testMethod},
+						Affinity: &pb.AffinityConfig{
+							Command:     pb.AffinityConfig_BOUND,
+							AffinityKey: "nestedField.key",
+							// The path not found in the message is skipped.
+							FallbackAffinityKeys: []string{"missing", "key"},
+						},
+					},
+				},
+			},
+		},
+	})
+	for sc := range mp {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+	b.bindSubConn("txn", sc1)
+	b.bindSubConn("session", sc2)
+
+	for _, tc := range []struct {
+		desc string
+		msg  *testMsg
+		want balancer.SubConn
+	}{
+		{
+			desc: "preferred key",
+			msg:  &testMsg{Key: "session", NestedField: &nestedField{Key: "txn"}},
+			want: sc1,
+		},
+		{
+			desc: "empty preferred key",
+			msg:  &testMsg{Key: "session", NestedField: &nestedField{}},
+			want: sc2,
+		},
+		{
+			desc: "no keys",
+			msg:  &testMsg{NestedField: &nestedField{}},
+			want: sc3,
+		},
+	} {
+		ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: tc.msg})
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
+		if err != nil {
+			t.Fatalf("%s: gcpPicker.Pick returns error %v, want nil", tc.desc, err)
+		}
+		if pr.SubConn != tc.want {
+			t.Fatalf("%s: gcpPicker.Pick picked %v, want %v", tc.desc, pr.SubConn, tc.want)
+		}
+		pr.Done(balancer.DoneInfo{})
+	}
+}
+
+func TestBigtableApiConfig(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	sc1 := mocks.NewMockSubConn(mockCtrl)
+	sc2 := mocks.NewMockSubConn(mockCtrl)
+	for _, sc := range []*mocks.MockSubConn{sc1, sc2} {
+		sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		sc.EXPECT().Connect().AnyTimes()
+	}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mp := make(map[balancer.SubConn]*subConnRef)
+	for i, sc := range []balancer.SubConn{sc1, sc2} {
+		mp[sc] = &subConnRef{
+			id:          i,
+			subConn:     sc,
+			stateSignal: make(chan struct{}),
+		}
+	}
+
+	cfg := BigtableApiConfig()
+	cfg.ChannelPool = &pb.ChannelPoolConfig{
+		MaxSize:                          2,
+		MaxConcurrentStreamsLowWatermark: 100,
+	}
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.scRefs = mp
+	for sc := range mp {
+		b.scStates[sc] = connectivity.Idle
+	}
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{
+			Addresses: b.addrs,
+		},
+		BalancerConfig: &GCPBalancerConfig{ApiConfig: cfg},
+	})
+	for sc := range mp {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+
+	table := "projects/p/instances/i/tables/t"
+	pick := func(method, params string) balancer.SubConn {
+		t.Helper()
+		// The Bigtable client sets the routing header without the GCP
+		// interceptors.
+		ctx := metadata.AppendToOutgoingContext(context.Background(), RequestParamsHeader, params)
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "/google.bigtable.v2.Bigtable/" + method, Ctx: ctx})
+		if err != nil {
+			t.Fatalf("gcpPicker.Pick of %s with %q returns error %v, want nil", method, params, err)
+		}
+		return pr.SubConn
+	}
+
+	params := "table_name=" + url.QueryEscape(table) + "&app_profile_id=a"
+	first := pick("ReadRows", params)
+	wantKey := "table_name=projects%2Fp%2Finstances%2Fi%2Ftables%2Ft&app_profile_id=a"
+	if got := b.affinityMap[wantKey]; got != first {
+		t.Fatalf("key %q is bound to %v, want %v", wantKey, got, first)
+	}
+	// The first call keeps its stream, the calls of the table with the app
+	// profile stay on its channel, with the parameters in any order.
+	for _, m := range []string{"MutateRow", "ReadRows", "CheckAndMutateRow"} {
+		if got := pick(m, "app_profile_id=a&table_name="+url.QueryEscape(table)); got != first {
+			t.Fatalf("%s of the bound table picked %v, want %v", m, got, first)
+		}
+	}
+	// Another app profile of the table is bound to the least busy channel.
+	if got := pick("ReadRows", "table_name="+url.QueryEscape(table)+"&app_profile_id=b"); got == first {
+		t.Fatalf("ReadRows of another app profile picked %v, want the other channel", got)
+	}
+	// Calls without a table have no affinity.
+	before := len(b.affinityMap)
+	pick("ReadRows", "authorized_view_name=v")
+	if got := len(b.affinityMap); got != before {
+		t.Fatalf("ReadRows without a table bound a key, %d keys, want %d", got, before)
+	}
+
+	// Parameters missing from the header are located in the request.
+	key := requestParamsKey(&pb.AffinityConfig{AffinityKeyParams: []string{"table_name", "key"}}, metadata.Pairs(RequestParamsHeader, "table_name=t"), func(locator string) ([]string, error) {
+		return getAffinityKeysFromMessage(locator, &testMsg{Key: "k 1"})
+	})
+	if want := "table_name=t&key=k+1"; key != want {
+		t.Fatalf("requestParamsKey returns %q, want %q", key, want)
+	}
+}
+
+func TestNormalizeAffinityKey(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	sc1 := mocks.NewMockSubConn(mockCtrl)
+	sc2 := mocks.NewMockSubConn(mockCtrl)
+	for _, sc := range []*mocks.MockSubConn{sc1, sc2} {
+		sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		sc.EXPECT().Connect().AnyTimes()
+	}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mp := make(map[balancer.SubConn]*subConnRef)
+	for i, sc := range []balancer.SubConn{sc1, sc2} {
+		mp[sc] = &subConnRef{
+			id:          i,
+			subConn:     sc,
+			stateSignal: make(chan struct{}),
+		}
+	}
+	// The unbound calls use the least busy subconn.
+	mp[sc2].streamsCnt = 5
+
+	testMethod := "testMethod"
+	b := (&gcpBalancerBuilder{name: Name, opts: Config{NormalizeAffinityKey: NormalizeResourceName}}).Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.scRefs = mp
+	for sc := range mp {
+		b.scStates[sc] = connectivity.Idle
+	}
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{
+			Addresses: b.addrs,
+		},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MaxSize:                          2,
+					MaxConcurrentStreamsLowWatermark: 100,
+				},
+				Method: []*pb.MethodConfig{
+					{
+						Name: []string{testMethod},
+						Affinity: &pb.AffinityConfig{
+							Command:     pb.AffinityConfig_BOUND,
+							AffinityKey: "key",
+						},
+					},
+				},
+			},
+		},
+	})
+	for sc := range mp {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+	session := "projects/my-project/instances/i/databases/d/sessions/s"
+	b.bindSubConn(session, sc2)
+
+	for _, key := range []string{session, session + "/", "projects/My-Project/instances/i/databases/d/sessions/s"} {
+		ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: &testMsg{Key: key}})
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
+		if err != nil {
+			t.Fatalf("gcpPicker.Pick with %q returns error %v, want nil", key, err)
+		}
+		if pr.SubConn != sc2 {
+			t.Fatalf("gcpPicker.Pick with %q picked %v, want the bound %v", key, pr.SubConn, sc2)
+		}
+		pr.Done(balancer.DoneInfo{})
+	}
+
+	// The keys of WithChannelAffinity are normalized as well.
+	ctx := WithChannelAffinity(context.Background(), "projects/MY-PROJECT/instances/i/databases/d/sessions/s/")
+	if key, _ := b.scopedContextAffinityKey(ctx); key != session {
+		t.Fatalf("scopedContextAffinityKey returns %q, want %q", key, session)
+	}
+	if got, want := b.normalizeScopedKey(NamespacedAffinityKey("ns", session+"/")), NamespacedAffinityKey("ns", session); got != want {
+		t.Fatalf("normalizeScopedKey returns %q, want %q", got, want)
+	}
+}
+
+func TestBindOnFirstUse(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	sc1 := mocks.NewMockSubConn(mockCtrl)
+	sc2 := mocks.NewMockSubConn(mockCtrl)
+	for _, sc := range []*mocks.MockSubConn{sc1, sc2} {
+		sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		sc.EXPECT().Connect().AnyTimes()
+	}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mp := make(map[balancer.SubConn]*subConnRef)
+	// The busy subconn is not picked for the first use of a key.
+	mp[sc1] = &subConnRef{
+		id:          0,
+		subConn:     sc1,
+		stateSignal: make(chan struct{}),
+		streamsCnt:  5,
+	}
+	mp[sc2] = &subConnRef{
+		id:          1,
+		subConn:     sc2,
+		stateSignal: make(chan struct{}),
+	}
+
+	testMethod := "testMethod"
+	gcpcfg := &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          2,
+				MaxConcurrentStreamsLowWatermark: 100,
+			},
+			Method: []*pb.MethodConfig{
+				{
+					Name: []string{testMethod},
+					Affinity: &pb.AffinityConfig{
+						Command:     pb.AffinityConfig_BIND_ON_FIRST_USE,
+						AffinityKey: "key",
+						TtlMs:       60000,
+					},
+				},
+			},
+		},
+	}
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.scRefs = mp
+	b.scStates[sc1] = connectivity.Idle
+	b.scStates[sc2] = connectivity.Idle
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{
+			Addresses: b.addrs,
+		},
+		BalancerConfig: gcpcfg,
+	})
+	b.UpdateSubConnState(sc1, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.UpdateSubConnState(sc2, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+
+	pick := func(key string) balancer.PickResult {
+		t.Helper()
+		ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: &testMsg{Key: key}})
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
+		if err != nil {
+			t.Fatalf("gcpPicker.Pick for %q returns error %v, want nil", key, err)
+		}
+		return pr
+	}
+
+	// The key is bound to the least busy subconn when the call is picked,
+	// and stays bound even if the call fails.
+	pr := pick("doc1")
+	if pr.SubConn != sc2 {
+		t.Fatalf("first call with doc1 picked %v, want %v", pr.SubConn, sc2)
+	}
+	if got := b.affinityMap["doc1"]; got != sc2 {
+		t.Fatalf("doc1 is bound to %v after the pick, want %v", got, sc2)
+	}
+	if got := b.affinityTTL["doc1"]; got != time.Minute {
+		t.Fatalf("doc1 has TTL %v, want %v", got, time.Minute)
+	}
+	pr.Done(balancer.DoneInfo{Err: status.Error(codes.Unavailable, "unavailable")})
+
+	// The following calls with the key use the bound subconn even if it is
+	// busier.
+	atomic.StoreInt32(&mp[sc2].streamsCnt, 10)
+	pr = pick("doc1")
+	if pr.SubConn != sc2 {
+		t.Fatalf("second call with doc1 picked %v, want %v", pr.SubConn, sc2)
+	}
+	pr.Done(balancer.DoneInfo{})
+
+	// Another key is bound to the now least busy subconn.
+	pr = pick("doc2")
+	if pr.SubConn != sc1 {
+		t.Fatalf("first call with doc2 picked %v, want %v", pr.SubConn, sc1)
+	}
+	pr.Done(balancer.DoneInfo{})
+	for sc, want := range map[balancer.SubConn]int32{sc1: 1, sc2: 1} {
+		if got := mp[sc].getAffinityCnt(); got != want {
+			t.Fatalf("channel %d has %d affinity keys, want %d", mp[sc].id, got, want)
+		}
+	}
+}
+
+func TestUnbindGracePeriod(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	sc1 := mocks.NewMockSubConn(mockCtrl)
+	sc2 := mocks.NewMockSubConn(mockCtrl)
+	for _, sc := range []*mocks.MockSubConn{sc1, sc2} {
+		sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		sc.EXPECT().Connect().AnyTimes()
+	}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mp := make(map[balancer.SubConn]*subConnRef)
+	mp[sc1] = &subConnRef{
+		subConn:     sc1,
+		stateSignal: make(chan struct{}),
+	}
+	// The busy subconn is not picked for unbound keys.
+	mp[sc2] = &subConnRef{
+		subConn:     sc2,
+		stateSignal: make(chan struct{}),
+		streamsCnt:  5,
+	}
+
+	gcpcfg := &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          2,
+				MaxConcurrentStreamsLowWatermark: 100,
+			},
+			Method: []*pb.MethodConfig{
+				{
+					Name: []string{"unbindWithGrace"},
+					Affinity: &pb.AffinityConfig{
+						Command:             pb.AffinityConfig_UNBIND,
+						AffinityKey:         "key",
+						UnbindGracePeriodMs: 60000,
+					},
+				},
+				{
+					Name: []string{"unbind"},
+					Affinity: &pb.AffinityConfig{
+						Command:     pb.AffinityConfig_UNBIND,
+						AffinityKey: "key",
+					},
+				},
+				{
+					Name: []string{"bound"},
+					Affinity: &pb.AffinityConfig{
+						Command:     pb.AffinityConfig_BOUND,
+						AffinityKey: "key",
+					},
+				},
+			},
+		},
+	}
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.scRefs = mp
+	b.scStates[sc1] = connectivity.Idle
+	b.scStates[sc2] = connectivity.Idle
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{
+			Addresses: b.addrs,
+		},
+		BalancerConfig: gcpcfg,
+	})
+	b.UpdateSubConnState(sc1, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.UpdateSubConnState(sc2, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+
+	call := func(method, key string) balancer.SubConn {
+		ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: &testMsg{Key: key}})
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: method, Ctx: ctx})
+		if err != nil {
+			t.Fatalf("gcpPicker.Pick for %q returned unexpected error: %v", method, err)
+		}
+		pr.Done(balancer.DoneInfo{})
+		return pr.SubConn
+	}
+
+	b.bindSubConn("s1", sc2)
+	b.bindSubConn("s2", sc2)
+	call("unbindWithGrace", "s1")
+	call("unbind", "s2")
+	if _, ok := b.affinityMap["s1"]; ok {
+		t.Fatalf("s1 is still bound after UNBIND")
+	}
+	// A late call for the recently unbound key prefers its last channel.
+	if got := call("bound", "s1"); got != sc2 {
+		t.Fatalf("call for s1 within the grace period picked %v, want %v", got, sc2)
+	}
+	// Without the grace period the key is forgotten.
+	if got := call("bound", "s2"); got != sc1 {
+		t.Fatalf("call for s2 without grace period picked %v, want %v", got, sc1)
+	}
+	// The key is forgotten after the grace period.
+	u := b.unbound["s1"]
+	u.expires = time.Now().Add(-time.Second)
+	b.unbound["s1"] = u
+	if got := call("bound", "s1"); got != sc1 {
+		t.Fatalf("call for s1 after the grace period picked %v, want %v", got, sc1)
+	}
+}
+
+func TestAffinityTTL(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	sc1 := mocks.NewMockSubConn(mockCtrl)
+	sc2 := mocks.NewMockSubConn(mockCtrl)
+	for _, sc := range []*mocks.MockSubConn{sc1, sc2} {
+		sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		sc.EXPECT().Connect().AnyTimes()
+	}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mp := make(map[balancer.SubConn]*subConnRef)
+	mp[sc1] = &subConnRef{
+		subConn:     sc1,
+		stateSignal: make(chan struct{}),
+	}
+	// The busy subconn is not picked for unbound keys.
+	mp[sc2] = &subConnRef{
+		subConn:     sc2,
+		stateSignal: make(chan struct{}),
+		streamsCnt:  5,
+	}
+
+	gcpcfg := &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          2,
+				MaxConcurrentStreamsLowWatermark: 100,
+			},
+			Method: []*pb.MethodConfig{
+				{
+					Name: []string{"bindWithTTL"},
+					Affinity: &pb.AffinityConfig{
+						Command:     pb.AffinityConfig_BIND,
+						AffinityKey: "key",
+						TtlMs:       60000,
+					},
+				},
+				{
+					Name: []string{"bound"},
+					Affinity: &pb.AffinityConfig{
+						Command:     pb.AffinityConfig_BOUND,
+						AffinityKey: "key",
+					},
+				},
+			},
+		},
+	}
+
+	unbound := []string{}
+	bb := &gcpBalancerBuilder{
+		name: Name,
+		opts: Config{
+			OnUnbind: func(key string, channelID int) {
+				unbound = append(unbound, key)
+			},
+		},
+	}
+	b := bb.Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	defer b.Close()
+	b.scRefs = mp
+	b.scStates[sc1] = connectivity.Idle
+	b.scStates[sc2] = connectivity.Idle
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{
+			Addresses: b.addrs,
+		},
+		BalancerConfig: gcpcfg,
+	})
+	b.UpdateSubConnState(sc1, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.UpdateSubConnState(sc2, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+
+	if got, want := b.affinityExpiryInterval(), time.Minute; got != want {
+		t.Fatalf("affinityExpiryInterval() = %v, want %v", got, want)
+	}
+
+	call := func(method, key string) balancer.SubConn {
+		ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: &testMsg{Key: key}, replyMsg: &testMsg{Key: key}})
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: method, Ctx: ctx})
+		if err != nil {
+			t.Fatalf("gcpPicker.Pick for %q returned unexpected error: %v", method, err)
+		}
+		pr.Done(balancer.DoneInfo{})
+		return pr.SubConn
+	}
+	expire := func(key string) {
+		atomic.StoreInt64(b.affinityUsed[key], time.Now().Add(-2*time.Minute).UnixNano())
+	}
+
+	call("bindWithTTL", "s1")
+	call("bindWithTTL", "s2")
+	b.bindSubConn("s3", sc1)
+	// Binding the key again keeps its ttl.
+	b.bindSubConn("s1", sc1)
+	if got, want := len(b.affinityTTL), 2; got != want {
+		t.Fatalf("got %d keys with ttl, want %d", got, want)
+	}
+	// Make sc1 busy for unbound keys.
+	atomic.StoreInt32(&mp[sc1].streamsCnt, 5)
+	atomic.StoreInt32(&mp[sc2].streamsCnt, 0)
+
+	// Used keys stay bound.
+	if got := call("bound", "s1"); got != sc1 {
+		t.Fatalf("call for s1 picked %v, want %v", got, sc1)
+	}
+
+	// An expired key is unbound when picked.
+	expire("s1")
+	if got := call("bound", "s1"); got != sc2 {
+		t.Fatalf("call for expired s1 picked %v, want %v", got, sc2)
+	}
+
+	// Expired keys are unbound by the sweep. Keys without ttl never expire.
+	expire("s2")
+	expire("s3")
+	b.expireAffinityKeys()
+	if _, ok := b.affinityMap["s2"]; ok {
+		t.Fatalf("s2 is still bound after expiry")
+	}
+	if _, ok := b.affinityMap["s3"]; !ok {
+		t.Fatalf("s3 without ttl is unbound")
+	}
+	if diff := cmp.Diff([]string{"s1", "s2"}, unbound); diff != "" {
+		t.Fatalf("unexpected OnUnbind calls (-want, +got):\n%s", diff)
+	}
+}
+
+func TestPickOverflowWhenBusy(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	sc1 := mocks.NewMockSubConn(mockCtrl)
+	sc2 := mocks.NewMockSubConn(mockCtrl)
+	for _, sc := range []*mocks.MockSubConn{sc1, sc2} {
+		sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		sc.EXPECT().Connect().AnyTimes()
+	}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mp := make(map[balancer.SubConn]*subConnRef)
+	mp[sc1] = &subConnRef{
+		subConn:     sc1,
+		stateSignal: make(chan struct{}),
+		streamsCnt:  2,
+	}
+	mp[sc2] = &subConnRef{
+		subConn:     sc2,
+		stateSignal: make(chan struct{}),
+	}
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.scRefs = mp
+	b.scStates[sc1] = connectivity.Idle
+	b.scStates[sc2] = connectivity.Idle
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{
+			Addresses: b.addrs,
+		},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MaxSize:                          2,
+					MaxConcurrentStreamsLowWatermark: 2,
+				},
+				Method: []*pb.MethodConfig{
+					{
+						Name: []string{"read"},
+						Affinity: &pb.AffinityConfig{
+							Command:          pb.AffinityConfig_BOUND,
+							AffinityKey:      "key",
+							OverflowWhenBusy: true,
+						},
+					},
+					{
+						Name: []string{"write"},
+						Affinity: &pb.AffinityConfig{
+							Command:     pb.AffinityConfig_BOUND,
+							AffinityKey: "key",
+						},
+					},
+				},
+			},
+		},
+	})
+	b.UpdateSubConnState(sc1, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.UpdateSubConnState(sc2, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.bindSubConn("s1", sc1)
+
+	call := func(method string) balancer.SubConn {
+		ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: &testMsg{Key: "s1"}})
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: method, Ctx: ctx})
+		if err != nil {
+			t.Fatalf("gcpPicker.Pick for %q returned unexpected error: %v", method, err)
+		}
+		pr.Done(balancer.DoneInfo{})
+		return pr.SubConn
+	}
+
+	if got := call("write"); got != sc1 {
+		t.Fatalf("call without overflow picked %v, want the bound %v", got, sc1)
+	}
+	if got := call("read"); got != sc2 {
+		t.Fatalf("call with overflow on the busy bound channel picked %v, want %v", got, sc2)
+	}
+	if got := b.affinityMap["s1"]; got != sc1 {
+		t.Fatalf("key is bound to %v after overflow, want %v", got, sc1)
+	}
+	// Without capacity on other channels the bound channel is used.
+	atomic.StoreInt32(&mp[sc2].streamsCnt, 2)
+	if got := call("read"); got != sc1 {
+		t.Fatalf("call with overflow on the busy pool picked %v, want the bound %v", got, sc1)
+	}
+}
+
+func TestBatchUnbind(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	sc1 := mocks.NewMockSubConn(mockCtrl)
+	sc2 := mocks.NewMockSubConn(mockCtrl)
+	for _, sc := range []*mocks.MockSubConn{sc1, sc2} {
+		sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		sc.EXPECT().Connect().AnyTimes()
+	}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mp := make(map[balancer.SubConn]*subConnRef)
+	mp[sc1] = &subConnRef{
+		subConn:     sc1,
+		stateSignal: make(chan struct{}),
+	}
+	mp[sc2] = &subConnRef{
+		subConn:     sc2,
+		stateSignal: make(chan struct{}),
+	}
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.scRefs = mp
+	b.scStates[sc1] = connectivity.Idle
+	b.scStates[sc2] = connectivity.Idle
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{
+			Addresses: b.addrs,
+		},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MaxSize:                          2,
+					MaxConcurrentStreamsLowWatermark: 100,
+				},
+				Method: []*pb.MethodConfig{
+					{
+						Name: []string{"batchUnbind"},
+						Affinity: &pb.AffinityConfig{
+							Command:     pb.AffinityConfig_UNBIND,
+							AffinityKey: "repeatedString",
+						},
+					},
+				},
+			},
+		},
+	})
+	b.UpdateSubConnState(sc1, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.UpdateSubConnState(sc2, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+
+	b.bindSubConn("s1", sc1)
+	b.bindSubConn("s2", sc2)
+	b.bindSubConn("s3", sc2)
+
+	ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: &testMsg{RepeatedString: []string{"s1", "s2"}}})
+	pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "batchUnbind", Ctx: ctx})
+	if err != nil {
+		t.Fatalf("gcpPicker.Pick returned unexpected error: %v", err)
+	}
+	if pr.SubConn != sc1 {
+		t.Fatalf("gcpPicker.Pick returned %v, want the channel of the first key %v", pr.SubConn, sc1)
+	}
+	pr.Done(balancer.DoneInfo{})
+
+	for _, k := range []string{"s1", "s2"} {
+		if _, ok := b.affinityMap[k]; ok {
+			t.Fatalf("%s is still bound after batch UNBIND", k)
+		}
+	}
+	if _, ok := b.affinityMap["s3"]; !ok {
+		t.Fatalf("s3 is unbound by batch UNBIND of other keys")
+	}
+	if got := mp[sc2].getAffinityCnt(); got != 1 {
+		t.Fatalf("affinity count of sc2 is %d, want 1", got)
+	}
+}
+
+func TestRetryOnTeardown(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	sc1 := mocks.NewMockSubConn(mockCtrl)
+	sc2 := mocks.NewMockSubConn(mockCtrl)
+	for _, sc := range []*mocks.MockSubConn{sc1, sc2} {
+		sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		sc.EXPECT().Connect().AnyTimes()
+	}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	// The GOAWAY starts the replacement of the connection, never READY here.
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		return newSC, nil
+	}).AnyTimes()
+	var b *gcpBalancer
+	setup := func(attempts uint32) {
+		mp := make(map[balancer.SubConn]*subConnRef)
+		mp[sc1] = &subConnRef{
+			subConn:     sc1,
+			stateSignal: make(chan struct{}),
+		}
+		// The busy subconn is picked only when the other one is torn down.
+		mp[sc2] = &subConnRef{
+			subConn:     sc2,
+			stateSignal: make(chan struct{}),
+			streamsCnt:  5,
+		}
+		b = newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+		b.scRefs = mp
+		b.scStates[sc1] = connectivity.Idle
+		b.scStates[sc2] = connectivity.Idle
+		b.UpdateClientConnState(balancer.ClientConnState{
+			ResolverState: resolver.State{
+				Addresses: b.addrs,
+			},
+			BalancerConfig: &GCPBalancerConfig{
+				ApiConfig: &pb.ApiConfig{
+					ChannelPool: &pb.ChannelPoolConfig{
+						MaxSize:                          2,
+						MaxConcurrentStreamsLowWatermark: 100,
+						TeardownRetryAttempts:            attempts,
+					},
+				},
+			},
+		})
+		b.UpdateSubConnState(sc1, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+		b.UpdateSubConnState(sc2, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+
+	goAway := status.Error(codes.Unavailable, "the connection is draining due to GOAWAY")
+	// invoke makes a unary call failing on sc1 as if sc1 was torn down.
+	invoke := func(tearDown bool, bytesReceived bool) ([]balancer.SubConn, error) {
+		var picked []balancer.SubConn
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "method", Ctx: ctx})
+			if err != nil {
+				return err
+			}
+			picked = append(picked, pr.SubConn)
+			if pr.SubConn != sc1 {
+				pr.Done(balancer.DoneInfo{})
+				return nil
+			}
+			if tearDown {
+				b.UpdateSubConnState(sc1, balancer.SubConnState{ConnectivityState: connectivity.Idle})
+			}
+			pr.Done(balancer.DoneInfo{Err: goAway, BytesReceived: bytesReceived})
+			return goAway
+		}
+		err := GCPUnaryClientInterceptor(context.Background(), "method", &testMsg{}, &testMsg{}, nil, invoker)
+		return picked, err
+	}
+
+	setup(0)
+	if picked, err := invoke(true, false); err != goAway || len(picked) != 1 {
+		t.Fatalf("call without retries returned %v after %d attempts, want %v after 1 attempt", err, len(picked), goAway)
+	}
+
+	setup(1)
+	picked, err := invoke(true, false)
+	if err != nil {
+		t.Fatalf("call with a retry returned unexpected error: %v", err)
+	}
+	if len(picked) != 2 || picked[0] != sc1 || picked[1] != sc2 {
+		t.Fatalf("call with a retry picked %v, want [%v %v]", picked, sc1, sc2)
+	}
+
+	setup(1)
+	// The channel is still ready, so the error is not caused by a teardown.
+	if picked, err := invoke(false, false); err != goAway || len(picked) != 1 {
+		t.Fatalf("call failed on a ready channel returned %v after %d attempts, want %v after 1 attempt", err, len(picked), goAway)
+	}
+	// The server may have processed the call if a response was received.
+	setup(1)
+	if picked, err := invoke(true, true); err != goAway || len(picked) != 1 {
+		t.Fatalf("call with a response returned %v after %d attempts, want %v after 1 attempt", err, len(picked), goAway)
+	}
+}
+
+func TestCallPolicy(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	sc := mocks.NewMockSubConn(mockCtrl)
+	sc.EXPECT().Connect().AnyTimes()
+	sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).Return(sc, nil).Times(1)
+
+	withPolicy := "/google.spanner.v1.Spanner/CreateSession"
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          1,
+					MaxSize:                          1,
+					MaxConcurrentStreamsLowWatermark: 100,
+				},
+				Method: []*pb.MethodConfig{
+					{
+						Name: []string{"/google.spanner.v1.Spanner/*"},
+						CallPolicy: &pb.CallPolicy{
+							TimeoutMs:        50,
+							MaxAttempts:      3,
+							InitialBackoffMs: 1,
+						},
+					},
+				},
+			},
+		},
+	})
+	b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+
+	// ok is the result of a successful attempt.
+	ok := errors.New("ok")
+	// invoke makes a call with the results of the attempts, nil results hang
+	// until the attempt is canceled, and returns the number of attempts.
+	invoke := func(ctx context.Context, method string, results ...error) (int, error) {
+		attempts := 0
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: method, Ctx: ctx})
+			if err != nil {
+				return err
+			}
+			res := results[attempts]
+			attempts++
+			if res == nil {
+				<-ctx.Done()
+				res = status.FromContextError(ctx.Err()).Err()
+			} else if res == ok {
+				res = nil
+			}
+			pr.Done(balancer.DoneInfo{Err: res})
+			return res
+		}
+		err := GCPUnaryClientInterceptor(ctx, method, &testMsg{}, &testMsg{}, nil, invoker)
+		return attempts, err
+	}
+	unavailable := status.Error(codes.Unavailable, "")
+
+	// A hanging attempt times out and is retried.
+	if n, err := invoke(context.Background(), withPolicy, nil, ok); err != nil || n != 2 {
+		t.Fatalf("call with a hanging attempt returned %v after %d attempts, want nil after 2 attempts", err, n)
+	}
+	if n, err := invoke(context.Background(), withPolicy, unavailable, unavailable, ok); err != nil || n != 3 {
+		t.Fatalf("call failing twice returned %v after %d attempts, want nil after 3 attempts", err, n)
+	}
+	// Up to max_attempts attempts.
+	if n, err := invoke(context.Background(), withPolicy, nil, nil, nil); status.Code(err) != codes.DeadlineExceeded || n != 3 {
+		t.Fatalf("call with hanging attempts returned %v after %d attempts, want DEADLINE_EXCEEDED after 3 attempts", err, n)
+	}
+	// Only UNAVAILABLE and DEADLINE_EXCEEDED are retried by default.
+	notFound := status.Error(codes.NotFound, "")
+	if n, err := invoke(context.Background(), withPolicy, notFound, ok); err != notFound || n != 1 {
+		t.Fatalf("call failed with NOT_FOUND returned %v after %d attempts, want %v after 1 attempt", err, n, notFound)
+	}
+	// The call context bounds the whole call.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if n, err := invoke(ctx, withPolicy, nil, ok); status.Code(err) != codes.DeadlineExceeded || n != 1 {
+		t.Fatalf("call with a deadline returned %v after %d attempts, want DEADLINE_EXCEEDED after 1 attempt", err, n)
+	}
+	// Methods without a call policy are neither timed out nor retried.
+	if n, err := invoke(context.Background(), "/google.spanner.v1.Operations/Get", unavailable, ok); err != unavailable || n != 1 {
+		t.Fatalf("call without a call policy returned %v after %d attempts, want %v after 1 attempt", err, n, unavailable)
+	}
+
+	// The retryable codes of the policy replace the default ones.
+	b.updateConfig(&pb.ApiConfig{
+		ChannelPool: &pb.ChannelPoolConfig{MinSize: 1, MaxSize: 1},
+		Method: []*pb.MethodConfig{
+			{
+				Name: []string{withPolicy},
+				CallPolicy: &pb.CallPolicy{
+					MaxAttempts:      2,
+					RetryableCodes:   []string{"NOT_FOUND"},
+					InitialBackoffMs: 1,
+				},
+			},
+		},
+	})
+	if n, err := invoke(context.Background(), withPolicy, notFound, ok); err != nil || n != 2 {
+		t.Fatalf("call failed with a retryable code returned %v after %d attempts, want nil after 2 attempts", err, n)
+	}
+	if n, err := invoke(context.Background(), withPolicy, unavailable, ok); err != unavailable || n != 1 {
+		t.Fatalf("call failed with UNAVAILABLE returned %v after %d attempts, want %v after 1 attempt", err, n, unavailable)
+	}
+}
+
+func TestPickWithContextAffinityAndPin(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	sc1 := mocks.NewMockSubConn(mockCtrl)
+	sc2 := mocks.NewMockSubConn(mockCtrl)
+	for _, sc := range []*mocks.MockSubConn{sc1, sc2} {
+		sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		sc.EXPECT().Connect().AnyTimes()
+	}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	ref1 := &subConnRef{
+		id:          0,
+		subConn:     sc1,
 		stateSignal: make(chan struct{}),
-		affinityCnt: 0,
-		streamsCnt:  0,
 	}
-	mp[mockSCmapped] = &subConnRef{
-		subConn:     mockSCmapped,
+	ref2 := &subConnRef{
+		id:          1,
+		subConn:     sc2,
+		stateSignal: make(chan struct{}),
+	}
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.scRefs = map[balancer.SubConn]*subConnRef{sc1: ref1, sc2: ref2}
+	b.scRefList = []*subConnRef{ref1, ref2}
+	b.scStates[sc1] = connectivity.Idle
+	b.scStates[sc2] = connectivity.Idle
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{
+			Addresses: b.addrs,
+		},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MaxSize:                          2,
+					MaxConcurrentStreamsLowWatermark: 100,
+				},
+			},
+		},
+	})
+	b.UpdateSubConnState(sc1, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.UpdateSubConnState(sc2, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+
+	pick := func(ctx context.Context) (balancer.SubConn, error) {
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "method", Ctx: ctx})
+		if err != nil {
+			return nil, err
+		}
+		return pr.SubConn, nil
+	}
+
+	// The first call with the key binds it to the least busy channel and keeps
+	// its stream open, so the other channel is the least busy one afterwards.
+	txCtx := WithChannelAffinity(context.Background(), "tx")
+	first, err := pick(txCtx)
+	if err != nil {
+		t.Fatalf("gcpPicker.Pick with channel affinity returned unexpected error: %v", err)
+	}
+	if got := b.affinityMap["tx"]; got != first {
+		t.Fatalf("key is bound to %v, want %v", got, first)
+	}
+	for i := 0; i < 3; i++ {
+		if got, err := pick(txCtx); err != nil || got != first {
+			t.Fatalf("gcpPicker.Pick with channel affinity returned (%v, %v), want (%v, nil)", got, err, first)
+		}
+	}
+	if got, _ := pick(context.Background()); got == first {
+		t.Fatalf("gcpPicker.Pick without channel affinity returned the busy channel %v", got)
+	}
+
+	conn := &grpc.ClientConn{}
+	b.linkConn(conn)
+	defer b.unlinkConn()
+	if err := ReleaseChannelAffinity(conn, "tx"); err != nil {
+		t.Fatalf("ReleaseChannelAffinity returned error: %v", err)
+	}
+	if _, ok := b.affinityMap["tx"]; ok {
+		t.Fatalf("key is still bound after release")
+	}
+	var poolErr *PoolError
+	if err := ReleaseChannelAffinity(conn, "tx"); !errors.As(err, &poolErr) || !errors.Is(err, ErrKeyNotBound) || poolErr.KeyHash != AffinityKeyHash("tx") {
+		t.Fatalf("ReleaseChannelAffinity of a released key returned %v, want: a PoolError of %v", err, ErrKeyNotBound)
+	}
+
+	// A pinned channel is used regardless of the load.
+	for i := 0; i < 3; i++ {
+		if got, err := pick(PinChannel(context.Background(), 0)); err != nil || got != sc1 {
+			t.Fatalf("gcpPicker.Pick pinned to channel 0 returned (%v, %v), want (%v, nil)", got, err, sc1)
+		}
+	}
+	// The pin takes precedence over the affinity key.
+	if got, err := pick(PinChannel(txCtx, 1)); err != nil || got != sc2 {
+		t.Fatalf("gcpPicker.Pick pinned to channel 1 returned (%v, %v), want (%v, nil)", got, err, sc2)
+	}
+	if _, err := pick(PinChannel(context.Background(), 2)); status.Code(err) != codes.InvalidArgument || !errors.Is(err, ErrChannelNotFound) {
+		t.Fatalf("gcpPicker.Pick pinned to a missing channel returned %v, want INVALID_ARGUMENT", err)
+	}
+	b.UpdateSubConnState(sc1, balancer.SubConnState{ConnectivityState: connectivity.Connecting})
+	if _, err := pick(PinChannel(context.Background(), 0)); err != balancer.ErrNoSubConnAvailable {
+		t.Fatalf("gcpPicker.Pick pinned to a not ready channel returned %v, want %v", err, balancer.ErrNoSubConnAvailable)
+	}
+}
+
+func TestPickSubConnWithFallback(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		sc := mocks.NewMockSubConn(mockCtrl)
+		sc.EXPECT().Connect().AnyTimes()
+		sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, sc)
+		return sc, nil
+	}).Times(3)
+
+	// Create new pool.
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	// Simulate resolver.
+	testMethod := "testMethod"
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{
+			Addresses: b.addrs,
+		},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          3,
+					MaxSize:                          3,
+					MaxConcurrentStreamsLowWatermark: 100,
+					FallbackToReady:                  true,
+				},
+				Method: []*pb.MethodConfig{
+					{
+						Name: []string{testMethod},
+						Affinity: &pb.AffinityConfig{
+							Command:     pb.AffinityConfig_BOUND,
+							AffinityKey: "key",
+						},
+					},
+				},
+			},
+		},
+	})
+	// Move first two subconn to ready.
+	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.UpdateSubConnState(scs[1], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+
+	// Bind the key to the subconn 2, which is not ready.
+	theKey := "key-for-not-ready"
+	b.bindSubConn(theKey, scs[2])
+
+	ctx := context.Background()
+	// Prepare call context with the mapped key.
+	gcpCtx := &gcpContext{
+		reqMsg: &testMsg{
+			Key: theKey,
+		},
+	}
+	ctx = context.WithValue(ctx, gcpKey, gcpCtx)
+
+	// Increase active streams on subconn 0 so that the pick below will be forced to subconn 1.
+	b.scRefs[scs[0]].streamsIncr(nil)
+	// Despite subconn 2 is mapped to the key, subconn 1 shoud be returned as a fallback
+	// because it has less active streams than subconn 0.
+	pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
+	sc := pr.SubConn
+
+	if sc != scs[1] || err != nil {
+		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", sc, err, scs[1])
+	}
+
+	// Should stick to subconn 1 even when it has more active streams.
+	pr, err = b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
+	sc = pr.SubConn
+
+	if sc != scs[1] || err != nil {
+		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", sc, err, scs[1])
+	}
+	pr, err = b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
+	sc = pr.SubConn
+
+	if sc != scs[1] || err != nil {
+		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", sc, err, scs[1])
+	}
+
+	// Simulate subconn 1 moved to the error state.
+	b.UpdateSubConnState(scs[1], balancer.SubConnState{ConnectivityState: connectivity.TransientFailure})
+
+	// Subconn 0 shoud be returned, because now subconn 1 (temporary fallback) is also not ready.
+	pr, err = b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
+	sc = pr.SubConn
+
+	if sc != scs[0] || err != nil {
+		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", sc, err, scs[0])
+	}
+
+	// Simulate subconn 2 recovered.
+	b.UpdateSubConnState(scs[2], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+
+	// As originally mapped subconn 2 is recovered, it shoud be returned.
+	pr, err = b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
+	sc = pr.SubConn
+
+	if sc != scs[2] || err != nil {
+		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", sc, err, scs[2])
+	}
+}
+
+func TestPickWithMethodChannelPool(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(3)
+
+	streamingMethod := "testService/streamingMethod"
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          2,
+					MaxSize:                          3,
+					MaxConcurrentStreamsLowWatermark: 100,
+				},
+				Method: []*pb.MethodConfig{
+					{
+						Name: []string{streamingMethod},
+						ChannelPool: &pb.MethodChannelPoolConfig{
+							MaxConcurrentStreamsLowWatermark: 2,
+						},
+					},
+				},
+			},
+		},
+	})
+
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+
+	// A regular call makes subconn 0 busier than subconn 1.
+	if _, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "testService/unary", Ctx: context.Background()}); err != nil {
+		t.Fatalf("gcpPicker.Pick returns error: %v, want: nil", err)
+	}
+
+	picked := make(map[balancer.SubConn]int)
+	for i := 0; i < 4; i++ {
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: streamingMethod, Ctx: context.Background()})
+		if err != nil {
+			t.Fatalf("gcpPicker.Pick returns error: %v, want: nil", err)
+		}
+		picked[pr.SubConn]++
+	}
+	for _, sc := range scs {
+		if got, want := picked[sc], 2; got != want {
+			t.Fatalf("gcpPicker.Pick picked subconn %v for %d streaming calls, want %d", sc, got, want)
+		}
+	}
+
+	// All subconns reached the limit for the streaming method. A new subconn must be requested.
+	if _, err := b.picker.Pick(balancer.PickInfo{FullMethodName: streamingMethod, Ctx: context.Background()}); err != balancer.ErrNoSubConnAvailable {
+		t.Fatalf("gcpPicker.Pick returns error: %v, want: %v", err, balancer.ErrNoSubConnAvailable)
+	}
+	if got, want := len(b.scRefs), 3; got != want {
+		t.Fatalf("gcpBalancer scRefs length is %v, want %v", got, want)
+	}
+
+	// Other calls are not affected by the streaming method limit.
+	if _, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "testService/unary", Ctx: context.Background()}); err != nil {
+		t.Fatalf("gcpPicker.Pick returns error: %v, want: nil", err)
+	}
+}
+
+func BenchmarkPick(b *testing.B) {
+	for _, poolSize := range []int{4, 8, 16, 32, 64} {
+		mockCtrl := gomock.NewController(b)
+
+		mockCC := mocks.NewMockClientConn(mockCtrl)
+		subconns := []*mocks.MockSubConn{}
+		mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+		mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+			newSC := mocks.NewMockSubConn(mockCtrl)
+			newSC.EXPECT().Connect().AnyTimes()
+			newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+			subconns = append(subconns, newSC)
+			return newSC, nil
+		}).Times(poolSize)
+		bal := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+		bal.UpdateClientConnState(
+			balancer.ClientConnState{
+				ResolverState: resolver.State{
+					Addresses: []resolver.Address{{Addr: "127.0.0.1"}},
+				},
+				BalancerConfig: &GCPBalancerConfig{
+					ApiConfig: &pb.ApiConfig{
+						ChannelPool: &pb.ChannelPoolConfig{
+							MinSize:                          uint32(poolSize),
+							MaxSize:                          uint32(poolSize),
+							MaxConcurrentStreamsLowWatermark: 100,
+							FallbackToReady:                  true,
+						},
+					},
+				},
+			},
+		)
+
+		// Move all subconns to ready state.
+		for _, sc := range subconns {
+			bal.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+		}
+
+		cwg := sync.WaitGroup{}
+		b.Run(fmt.Sprintf("pool_size_%d", poolSize), func(b *testing.B) {
+			wg := sync.WaitGroup{}
+			n := b.N / poolSize
+
+			// Call Pick concurrently from poolSize parallel jobs.
+			for i := 0; i < poolSize; i++ {
+				wg.Add(1)
+				go func() {
+					for j := 0; j < n; j++ {
+						pr, _ := bal.picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+						cwg.Add(1)
+						go func() {
+							time.Sleep((5 + time.Duration(rand.Intn(15))) * time.Millisecond)
+							pr.Done(balancer.DoneInfo{})
+							cwg.Done()
+						}()
+					}
+					wg.Done()
+				}()
+			}
+			wg.Wait()
+		})
+		// Wait for all Done callbacks.
+		cwg.Wait()
+		mockCtrl.Finish()
+	}
+}
+
+func BenchmarkPickParallel(b *testing.B) {
+	const poolSize = 8
+	mockCtrl := gomock.NewController(b)
+	defer mockCtrl.Finish()
+
+	boundMethod := "boundMethod"
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	subconns := []*mocks.MockSubConn{}
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		subconns = append(subconns, newSC)
+		return newSC, nil
+	}).Times(poolSize)
+	bal := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	bal.UpdateClientConnState(
+		balancer.ClientConnState{
+			ResolverState: resolver.State{
+				Addresses: []resolver.Address{{Addr: "127.0.0.1"}},
+			},
+			BalancerConfig: &GCPBalancerConfig{
+				ApiConfig: &pb.ApiConfig{
+					ChannelPool: &pb.ChannelPoolConfig{
+						MinSize:                          poolSize,
+						MaxSize:                          poolSize,
+						MaxConcurrentStreamsLowWatermark: 100,
+					},
+					Method: []*pb.MethodConfig{
+						{
+							Name: []string{boundMethod},
+							Affinity: &pb.AffinityConfig{
+								Command:     pb.AffinityConfig_BOUND,
+								AffinityKey: "key",
+							},
+						},
+					},
+				},
+			},
+		},
+	)
+	for _, sc := range subconns {
+		bal.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+	for i, sc := range subconns {
+		bal.bindSubConn(fmt.Sprintf("key%d", i), sc)
+	}
+
+	b.Run("unbound", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				pr, err := bal.picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+				if err != nil {
+					b.Errorf("gcpPicker.Pick returns err: %v", err)
+					return
+				}
+				pr.Done(balancer.DoneInfo{})
+			}
+		})
+	})
+
+	b.Run("bound", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			i := rand.Intn(poolSize)
+			ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: &testMsg{Key: fmt.Sprintf("key%d", i)}})
+			for pb.Next() {
+				pr, err := bal.picker.Pick(balancer.PickInfo{FullMethodName: boundMethod, Ctx: ctx})
+				if err != nil {
+					b.Errorf("gcpPicker.Pick returns err: %v", err)
+					return
+				}
+				pr.Done(balancer.DoneInfo{})
+			}
+		})
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	sc := mocks.NewMockSubConn(mockCtrl)
+	sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+	sc.EXPECT().Connect().AnyTimes()
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mp := make(map[balancer.SubConn]*subConnRef)
+	mp[sc] = &subConnRef{
+		subConn:     sc,
 		stateSignal: make(chan struct{}),
-		affinityCnt: 0,
-		streamsCnt:  5,
 	}
 
-	testMethod := "testMethod"
-	gcpcfg := &GCPBalancerConfig{
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.scRefs = mp
+	b.scStates[sc] = connectivity.Idle
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{
+			Addresses: b.addrs,
+		},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MaxSize:                          1,
+					MaxConcurrentStreamsLowWatermark: 100,
+					CircuitBreaker: &pb.CircuitBreakerConfig{
+						ConsecutiveFailures: 2,
+						CooldownMs:          60000,
+					},
+				},
+			},
+		},
+	})
+	b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	pick := func() (balancer.PickResult, error) {
+		return b.picker.Pick(balancer.PickInfo{FullMethodName: "method", Ctx: context.Background()})
+	}
+	call := func(callErr error) {
+		pr, err := pick()
+		if err != nil {
+			t.Fatalf("gcpPicker.Pick returned unexpected error: %v", err)
+		}
+		pr.Done(balancer.DoneInfo{Err: callErr})
+	}
+
+	// Application errors and interleaved successes do not open the breaker.
+	call(unavailable)
+	call(status.Error(codes.NotFound, "not found"))
+	call(nil)
+	call(unavailable)
+	// The second failure in a row opens the breaker.
+	call(unavailable)
+	if _, err := pick(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("gcpPicker.Pick with the open breaker returned %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// After the cooldown a single probe is allowed.
+	b.breaker.mu.Lock()
+	b.breaker.openUntil = time.Now()
+	b.breaker.mu.Unlock()
+	probe, err := pick()
+	if err != nil {
+		t.Fatalf("gcpPicker.Pick for the probe returned unexpected error: %v", err)
+	}
+	if _, err := pick(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("gcpPicker.Pick during the probe returned %v, want %v", err, ErrCircuitOpen)
+	}
+	// A failed probe opens the breaker again.
+	probe.Done(balancer.DoneInfo{Err: unavailable})
+	if _, err := pick(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("gcpPicker.Pick after the failed probe returned %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// A successful probe closes the breaker.
+	b.breaker.mu.Lock()
+	b.breaker.openUntil = time.Now()
+	b.breaker.mu.Unlock()
+	call(nil)
+	call(nil)
+}
+
+func TestAdaptiveThrottling(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	ref := &subConnRef{
+		subConn:     mocks.NewMockSubConn(mockCtrl),
+		stateSignal: make(chan struct{}),
+		throttler:   newAdaptiveThrottler(&pb.AdaptiveThrottlingConfig{}),
+	}
+	ref.throttler.rand = func() float64 { return 0.5 }
+	picker := newGCPPicker([]*subConnRef{ref}, withConfig(&gcpBalancer{
+		log: compLogger,
+	}, &GCPBalancerConfig{
 		ApiConfig: &pb.ApiConfig{
 			ChannelPool: &pb.ChannelPoolConfig{
-				MaxSize:                          2,
+				MaxSize:                          1,
 				MaxConcurrentStreamsLowWatermark: 100,
 			},
-			Method: []*pb.MethodConfig{
-				{
-					Name: []string{testMethod},
-					Affinity: &pb.AffinityConfig{
-						Command:     pb.AffinityConfig_BOUND,
-						AffinityKey: "key",
+		},
+	}))
+	call := func(rpcErr error) error {
+		pr, err := picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
+		if err != nil {
+			return err
+		}
+		pr.Done(balancer.DoneInfo{Err: rpcErr})
+		return nil
+	}
+
+	overloaded := status.Error(codes.ResourceExhausted, "overloaded")
+	// Rejections by the backend below the multiplier do not throttle.
+	for i := 0; i < 4; i++ {
+		if err := call(nil); err != nil {
+			t.Fatalf("call %d returned unexpected error: %v", i, err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		if err := call(overloaded); err != nil {
+			t.Fatalf("call with backend rejection %d returned unexpected error: %v", i, err)
+		}
+	}
+	// (8 - 2*4) / 9 = 0.
+	if err := call(overloaded); err != nil {
+		t.Fatalf("call returned unexpected error: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		call(overloaded)
+	}
+	// (30 - 2*4) / 31 > 0.5.
+	err := call(nil)
+	if !errors.Is(err, ErrThrottled) {
+		t.Fatalf("call on an overloaded backend returned %v, want %v", err, ErrThrottled)
+	}
+	if got := ref.getStreamsCnt(); got != 0 {
+		t.Fatalf("streams count after throttled call is %d, want 0", got)
+	}
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("throttled call returned %v code, want UNAVAILABLE", status.Code(err))
+	}
+}
+
+func BenchmarkAffinityKeys(b *testing.B) {
+	files := testDescriptors(b)
+	gb := &gcpBalancer{opts: Config{Descriptors: files}}
+	d, _ := files.FindDescriptorByName("test.Batch")
+	md := d.(protoreflect.MessageDescriptor)
+	for _, sessions := range []int{10, 1000, 10000} {
+		names := make([]string, sessions)
+		for i := range names {
+			names[i] = fmt.Sprintf("projects/p/instances/i/databases/d/sessions/%d", i)
+		}
+		raw, err := proto.Marshal(testBatch(b, files, "primary", names...))
+		if err != nil {
+			b.Fatalf("proto.Marshal returned error: %v", err)
+		}
+		// Decoding the whole message is what extracting the key from a
+		// decoded message costs when only the bytes are available.
+		b.Run(fmt.Sprintf("unmarshal_%d", sessions), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m := dynamicpb.NewMessage(md)
+				if err := proto.Unmarshal(raw, m); err != nil {
+					b.Fatalf("proto.Unmarshal returned error: %v", err)
+				}
+				if _, err := getAffinityKeysFromMessage("primary.sessionName", m); err != nil {
+					b.Fatalf("getAffinityKeysFromMessage returned error: %v", err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("scan_%d", sessions), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := gb.affinityKeys("primary.sessionName", "/test.Sessions/BatchUse", false, raw); err != nil {
+					b.Fatalf("affinityKeys returned error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+type zoneKey struct{}
+
+func TestPickWithAddressPreference(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(2)
+
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{
+			Addresses: []resolver.Address{
+				{Addr: "10.0.0.1:443", Attributes: attributes.New(zoneKey{}, "zone-a")},
+				{Addr: "10.0.0.2:443", Attributes: attributes.New(zoneKey{}, "zone-b")},
+			},
+		},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          2,
+					MaxSize:                          2,
+					MaxConcurrentStreamsLowWatermark: 100,
+				},
+			},
+		},
+	})
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+	// The connections of the channels as observed by the stats handler.
+	b.scRefs[scs[0]].setConnInfo(&connInfo{remoteAddr: "10.0.0.1:443"})
+	b.scRefs[scs[1]].setConnInfo(&connInfo{remoteAddr: "10.0.0.2:443"})
+
+	inZone := func(zone string) AddressSelector {
+		return func(addr resolver.Address) bool {
+			return addr.Attributes.Value(zoneKey{}) == zone
+		}
+	}
+	ctx := WithAddressPreference(context.Background(), inZone("zone-b"))
+	for i := 0; i < 5; i++ {
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: ctx})
+		if err != nil {
+			t.Fatalf("gcpPicker.Pick returns error: %v", err)
+		}
+		if pr.SubConn != scs[1] {
+			t.Fatalf("gcpPicker.Pick returns SubConn %v, want the zone-b SubConn %v", pr.SubConn, scs[1])
+		}
+	}
+
+	// Any channel is used if no channel matches.
+	ctx = WithAddressPreference(context.Background(), inZone("zone-c"))
+	pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: ctx})
+	if err != nil {
+		t.Fatalf("gcpPicker.Pick returns error: %v", err)
+	}
+	if pr.SubConn != scs[0] {
+		t.Fatalf("gcpPicker.Pick returns SubConn %v, want the least busy SubConn %v", pr.SubConn, scs[0])
+	}
+
+	snap := b.affinitySnapshot()
+	if got, want := snap.Channels[1].Attributes, attributes.New(zoneKey{}, "zone-b").String(); got != want {
+		t.Fatalf("affinitySnapshot() returns channel attributes %q, want %q", got, want)
+	}
+}
+
+func TestPickDecisionsAudit(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(2)
+
+	boundMethod := "testBoundMethod"
+	b := (&gcpBalancerBuilder{name: Name, opts: Config{Deterministic: true}}).Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          2,
+					MaxSize:                          2,
+					MaxConcurrentStreamsLowWatermark: 100,
+					PickAuditSize:                    3,
+				},
+				Method: []*pb.MethodConfig{
+					{
+						Name: []string{boundMethod},
+						Affinity: &pb.AffinityConfig{
+							Command:     pb.AffinityConfig_BOUND,
+							AffinityKey: "key",
+						},
 					},
 				},
 			},
 		},
+	})
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
 	}
+	b.bindSubConn("key1", scs[1])
 
-	// Simulate a pool with two connections.
+	pick := func(ctx context.Context, method string) {
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: method, Ctx: ctx})
+		if err != nil {
+			t.Fatalf("gcpPicker.Pick returns error: %v", err)
+		}
+		pr.Done(balancer.DoneInfo{})
+	}
+	boundCtx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: &testMsg{Key: "key1"}})
+	pick(context.Background(), "unbound")
+	pick(boundCtx, boundMethod)
+	pick(PinChannel(context.Background(), 1), "pinned")
+	pick(WithChannelAffinity(context.Background(), "txn"), "txn")
+
+	type decision struct {
+		Method       string
+		KeyHash      string
+		ChannelIndex int
+		Reason       PickReason
+	}
+	got := []decision{}
+	for _, d := range b.pickAudit.decisions() {
+		got = append(got, decision{d.Method, d.KeyHash, d.ChannelIndex, d.Reason})
+	}
+	// The oldest decision is dropped.
+	want := []decision{
+		{boundMethod, AffinityKeyHash("key1"), 1, PickBound},
+		{"pinned", "", 1, PickPinned},
+		{"txn", AffinityKeyHash("txn"), 0, PickContextAffinity},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unexpected pick decisions (-want, +got):\n%s", diff)
+	}
+	if got := PickBound.String(); got != "bound" {
+		t.Fatalf("PickBound.String() = %q, want %q", got, "bound")
+	}
+}
+
+func TestMaxAffinityKeysPerChannel(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(3)
+
+	bindMethod := "testBindMethod"
+	b := (&gcpBalancerBuilder{name: Name, opts: Config{Deterministic: true}}).Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          2,
+					MaxSize:                          3,
+					MaxConcurrentStreamsLowWatermark: 100,
+					MaxAffinityKeysPerChannel:        1,
+				},
+				Method: []*pb.MethodConfig{
+					{
+						Name: []string{bindMethod},
+						Affinity: &pb.AffinityConfig{
+							Command:     pb.AffinityConfig_BIND,
+							AffinityKey: "key",
+						},
+					},
+				},
+			},
+		},
+	})
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+	// Channel 0 is the least busy but has a bound key.
+	b.bindSubConn("key0", scs[0])
+	b.scRefs[scs[1]].streamsIncr(nil)
+
+	bindCtx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: &testMsg{}, replyMsg: &testMsg{}})
+	pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: bindMethod, Ctx: bindCtx})
+	if err != nil {
+		t.Fatalf("gcpPicker.Pick returns error: %v", err)
+	}
+	if pr.SubConn != scs[1] {
+		t.Fatalf("BIND call picked SubConn %v at the cap, want %v", pr.SubConn, scs[1])
+	}
+	// Calls without affinity still use the least busy channel.
+	pr, err = b.picker.Pick(balancer.PickInfo{FullMethodName: "unbound", Ctx: context.Background()})
+	if err != nil {
+		t.Fatalf("gcpPicker.Pick returns error: %v", err)
+	}
+	if pr.SubConn != scs[0] {
+		t.Fatalf("call without affinity picked SubConn %v, want %v", pr.SubConn, scs[0])
+	}
+
+	// Every channel at the cap grows the pool.
+	b.bindSubConn("key1", scs[1])
+	if _, err := b.picker.Pick(balancer.PickInfo{FullMethodName: bindMethod, Ctx: bindCtx}); err != nil {
+		t.Fatalf("gcpPicker.Pick returns error: %v", err)
+	}
+	if got, want := b.getConnectionPoolSize(), 3; got != want {
+		t.Fatalf("pool size is %d after every channel reached the cap, want %d", got, want)
+	}
+}
+
+func TestUnbindPolicy(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(2)
+
+	onSuccess := "testUnbindOnSuccess"
+	onPick := "testUnbindOnPick"
 	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
-	b.scRefs = mp
-	b.scStates[mockSCnotmapped] = connectivity.Idle
-	b.scStates[mockSCmapped] = connectivity.Idle
-	// Simulate resolver.
 	b.UpdateClientConnState(balancer.ClientConnState{
-		ResolverState: resolver.State{
-			Addresses: b.addrs,
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          2,
+					MaxSize:                          2,
+					MaxConcurrentStreamsLowWatermark: 100,
+				},
+				Method: []*pb.MethodConfig{
+					{
+						Name: []string{onSuccess},
+						Affinity: &pb.AffinityConfig{
+							Command:       pb.AffinityConfig_UNBIND,
+							AffinityKey:   "key",
+							UnbindOnCodes: []string{"NOT_FOUND"},
+						},
+					},
+					{
+						Name: []string{onPick},
+						Affinity: &pb.AffinityConfig{
+							Command:      pb.AffinityConfig_UNBIND,
+							AffinityKey:  "key",
+							UnbindPolicy: pb.AffinityConfig_UNBIND_ON_PICK,
+						},
+					},
+				},
+			},
 		},
-		BalancerConfig: gcpcfg,
 	})
-
-	// Simulate connections moved to the ready state.
-	b.UpdateSubConnState(mockSCnotmapped, balancer.SubConnState{ConnectivityState: connectivity.Ready})
-	b.UpdateSubConnState(mockSCmapped, balancer.SubConnState{ConnectivityState: connectivity.Ready})
-
-	// Bind the key to the SubConn.
-	theKey := "key-for-not-ready"
-	b.bindSubConn(theKey, mockSCmapped)
-
-	// Prepare call context with the mapped key.
-	ctx := context.Background()
-	gcpCtx := &gcpContext{
-		reqMsg: &testMsg{
-			Key: theKey,
-		},
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
 	}
-	ctx = context.WithValue(ctx, gcpKey, gcpCtx)
-
-	// The mapped connection shoud be returned.
-	pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
-	sc := pr.SubConn
 
-	if sc != mockSCmapped || err != nil {
-		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", sc, err, mockSCmapped)
+	unbind := func(method, key string) balancer.PickResult {
+		ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: &testMsg{Key: key}})
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: method, Ctx: ctx})
+		if err != nil {
+			t.Fatalf("gcpPicker.Pick returns error: %v", err)
+		}
+		return pr
+	}
+	bound := func(key string) balancer.SubConn {
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+		return b.affinityMap[key]
 	}
 
-	// Simulate the mapped connection moved to the error state.
-	b.UpdateSubConnState(
-		mockSCmapped,
-		balancer.SubConnState{ConnectivityState: connectivity.TransientFailure},
-	)
-
-	// The picker should return ErrNoSubConnAvailable for the same context
-	// because the connection mapped to the key is not in the ready state.
-	pr, err = b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
-	sc = pr.SubConn
+	// A failed UNBIND call keeps the key bound.
+	b.bindSubConnWithTTL("key1", scs[1], time.Minute)
+	unbind(onSuccess, "key1").Done(balancer.DoneInfo{Err: status.Error(codes.Unavailable, "")})
+	if got := bound("key1"); got != scs[1] {
+		t.Fatalf("key bound to %v after a failed UNBIND call, want %v", got, scs[1])
+	}
+	// Unless it failed with one of the unbind_on_codes.
+	unbind(onSuccess, "key1").Done(balancer.DoneInfo{Err: status.Error(codes.NotFound, "")})
+	if got := bound("key1"); got != nil {
+		t.Fatalf("key bound to %v after an UNBIND call failed with NOT_FOUND, want unbound", got)
+	}
 
-	wantErr := balancer.ErrNoSubConnAvailable
-	if sc != nil || err != wantErr {
-		t.Fatalf("gcpPicker.Pick returns %v, _, %v, want: nil, _, %v", sc, err, wantErr)
+	// UNBIND_ON_PICK unbinds the key when the call is sent.
+	b.bindSubConnWithTTL("key1", scs[1], time.Minute)
+	pr := unbind(onPick, "key1")
+	if got := bound("key1"); got != nil {
+		t.Fatalf("key bound to %v while an UNBIND_ON_PICK call is in flight, want unbound", got)
+	}
+	if got := b.scRefs[scs[1]].getAffinityCnt(); got != 0 {
+		t.Fatalf("channel has %d bound keys while an UNBIND_ON_PICK call is in flight, want 0", got)
+	}
+	// And restores the binding with its ttl if the call fails.
+	pr.Done(balancer.DoneInfo{Err: status.Error(codes.Unavailable, "")})
+	if got := bound("key1"); got != scs[1] {
+		t.Fatalf("key bound to %v after a failed UNBIND_ON_PICK call, want %v", got, scs[1])
+	}
+	if got := b.affinityTTL["key1"]; got != time.Minute {
+		t.Fatalf("restored key has ttl %v, want %v", got, time.Minute)
+	}
+	// A key bound again meanwhile is not restored.
+	pr = unbind(onPick, "key1")
+	b.bindSubConn("key1", scs[0])
+	pr.Done(balancer.DoneInfo{Err: status.Error(codes.Unavailable, "")})
+	if got := bound("key1"); got != scs[0] {
+		t.Fatalf("key bound to %v after a failed UNBIND_ON_PICK call, want %v it was bound to meanwhile", got, scs[0])
+	}
+	if got := b.scRefs[scs[1]].getAffinityCnt(); got != 0 {
+		t.Fatalf("previous channel has %d bound keys, want 0", got)
+	}
+	// A successful call leaves the key unbound.
+	unbind(onPick, "key1").Done(balancer.DoneInfo{})
+	if got := bound("key1"); got != nil {
+		t.Fatalf("key bound to %v after a successful UNBIND_ON_PICK call, want unbound", got)
 	}
 }
 
-func TestPickSubConnWithFallback(t *testing.T) {
+func TestControlChannel(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
@@ -553,169 +3607,361 @@ func TestPickSubConnWithFallback(t *testing.T) {
 	mockCC := mocks.NewMockClientConn(mockCtrl)
 	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
 	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
-		sc := mocks.NewMockSubConn(mockCtrl)
-		sc.EXPECT().Connect().AnyTimes()
-		sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
-		scs = append(scs, sc)
-		return sc, nil
-	}).Times(3)
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(2)
 
-	// Create new pool.
 	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
-	// Simulate resolver.
-	testMethod := "testMethod"
 	b.UpdateClientConnState(balancer.ClientConnState{
-		ResolverState: resolver.State{
-			Addresses: b.addrs,
-		},
+		ResolverState: resolver.State{},
 		BalancerConfig: &GCPBalancerConfig{
 			ApiConfig: &pb.ApiConfig{
 				ChannelPool: &pb.ChannelPoolConfig{
-					MinSize:                          3,
-					MaxSize:                          3,
+					MinSize:                          1,
+					MaxSize:                          1,
 					MaxConcurrentStreamsLowWatermark: 100,
-					FallbackToReady:                  true,
 				},
 				Method: []*pb.MethodConfig{
 					{
-						Name: []string{testMethod},
+						Name: []string{"/google.longrunning.Operations/*"},
+						// The affinity of the control methods is ignored.
 						Affinity: &pb.AffinityConfig{
 							Command:     pb.AffinityConfig_BOUND,
 							AffinityKey: "key",
 						},
+						ChannelPool: &pb.MethodChannelPoolConfig{ControlChannel: true},
 					},
 				},
 			},
 		},
 	})
-	// Move first two subconn to ready.
-	b.UpdateSubConnState(scs[0], balancer.SubConnState{ConnectivityState: connectivity.Ready})
-	b.UpdateSubConnState(scs[1], balancer.SubConnState{ConnectivityState: connectivity.Ready})
-
-	// Bind the key to the subconn 2, which is not ready.
-	theKey := "key-for-not-ready"
-	b.bindSubConn(theKey, scs[2])
-
-	ctx := context.Background()
-	// Prepare call context with the mapped key.
-	gcpCtx := &gcpContext{
-		reqMsg: &testMsg{
-			Key: theKey,
-		},
+	if len(scs) != 2 {
+		t.Fatalf("balancer created %d SubConns, want the control channel and 1 pool channel", len(scs))
 	}
-	ctx = context.WithValue(ctx, gcpKey, gcpCtx)
-
-	// Increase active streams on subconn 0 so that the pick below will be forced to subconn 1.
-	b.scRefs[scs[0]].streamsIncr()
-	// Despite subconn 2 is mapped to the key, subconn 1 shoud be returned as a fallback
-	// because it has less active streams than subconn 0.
-	pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
-	sc := pr.SubConn
+	controlSC, poolSC := scs[0], scs[1]
+	b.UpdateSubConnState(poolSC, balancer.SubConnState{ConnectivityState: connectivity.Ready})
 
-	if sc != scs[1] || err != nil {
-		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", sc, err, scs[1])
+	pick := func(method string) (balancer.PickResult, error) {
+		ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: &testMsg{Key: "key"}})
+		return b.picker.Pick(balancer.PickInfo{FullMethodName: method, Ctx: ctx})
 	}
+	getOperation := "/google.longrunning.Operations/GetOperation"
 
-	// Should stick to subconn 1 even when it has more active streams.
-	pr, err = b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
-	sc = pr.SubConn
+	// The calls wait for the control channel instead of using the pool.
+	if _, err := pick(getOperation); err != balancer.ErrNoSubConnAvailable {
+		t.Fatalf("Pick returned %v while the control channel is not ready, want %v", err, balancer.ErrNoSubConnAvailable)
+	}
 
-	if sc != scs[1] || err != nil {
-		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", sc, err, scs[1])
+	b.UpdateSubConnState(controlSC, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	pr, err := pick(getOperation)
+	if err != nil {
+		t.Fatalf("Pick returned error: %v", err)
+	}
+	if pr.SubConn != controlSC {
+		t.Fatalf("Pick returned %v, want the control channel %v", pr.SubConn, controlSC)
+	}
+	if m := b.poolMetrics(); m.Channels != 1 || m.ActiveStreams != 0 || m.BoundKeys != 0 {
+		t.Fatalf("pool metrics are %+v with a call on the control channel, want 1 channel, no active streams and no bound keys", m)
 	}
-	pr, err = b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
-	sc = pr.SubConn
 
-	if sc != scs[1] || err != nil {
-		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", sc, err, scs[1])
+	pr, err = pick("/google.spanner.v1.Spanner/ExecuteSql")
+	if err != nil {
+		t.Fatalf("Pick returned error: %v", err)
 	}
+	if pr.SubConn != poolSC {
+		t.Fatalf("Pick returned %v for a method not bypassing the pool, want %v", pr.SubConn, poolSC)
+	}
+	pr.Done(balancer.DoneInfo{})
+}
 
-	// Simulate subconn 1 moved to the error state.
-	b.UpdateSubConnState(scs[1], balancer.SubConnState{ConnectivityState: connectivity.TransientFailure})
+func TestUnknownMethodPolicy(t *testing.T) {
+	executeSQL := "/google.spanner.v1.Spanner/ExecuteSql"
+	for _, tc := range []struct {
+		policy pb.ChannelPoolConfig_UnknownMethodPolicy
+		// The channel picked for the unknown method, empty for a rejected
+		// call.
+		want string
+	}{
+		{policy: pb.ChannelPoolConfig_UNKNOWN_METHOD_DEFAULT, want: "pool"},
+		{policy: pb.ChannelPoolConfig_UNKNOWN_METHOD_REJECT},
+		{policy: pb.ChannelPoolConfig_UNKNOWN_METHOD_OVERFLOW_CHANNEL, want: "overflow"},
+	} {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			scs := []*mocks.MockSubConn{}
+			mockCC := mocks.NewMockClientConn(mockCtrl)
+			mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+			mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+				newSC := mocks.NewMockSubConn(mockCtrl)
+				newSC.EXPECT().Connect().AnyTimes()
+				newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+				scs = append(scs, newSC)
+				return newSC, nil
+			}).AnyTimes()
+
+			b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+			b.UpdateClientConnState(balancer.ClientConnState{
+				ResolverState: resolver.State{},
+				BalancerConfig: &GCPBalancerConfig{
+					ApiConfig: &pb.ApiConfig{
+						ChannelPool: &pb.ChannelPoolConfig{
+							MinSize:                          1,
+							MaxSize:                          1,
+							MaxConcurrentStreamsLowWatermark: 100,
+							UnknownMethodPolicy:              tc.policy,
+						},
+						Method: []*pb.MethodConfig{
+							{
+								Name: []string{"/google.spanner.v1.Spanner/Get*"},
+								Affinity: &pb.AffinityConfig{
+									Command:     pb.AffinityConfig_BOUND,
+									AffinityKey: "key",
+								},
+							},
+						},
+					},
+				},
+			})
+			poolSC := b.scRefList[0].subConn
+			for _, sc := range scs {
+				b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+			}
+			wantSCs := 1
+			if tc.policy == pb.ChannelPoolConfig_UNKNOWN_METHOD_OVERFLOW_CHANNEL {
+				wantSCs = 2
+			}
+			if len(scs) != wantSCs {
+				t.Fatalf("balancer created %d SubConns, want %d", len(scs), wantSCs)
+			}
 
-	// Subconn 0 shoud be returned, because now subconn 1 (temporary fallback) is also not ready.
-	pr, err = b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
-	sc = pr.SubConn
+			pick := func(method string) (balancer.PickResult, error) {
+				ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{reqMsg: &testMsg{Key: "key"}})
+				return b.picker.Pick(balancer.PickInfo{FullMethodName: method, Ctx: ctx})
+			}
 
-	if sc != scs[0] || err != nil {
-		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", sc, err, scs[0])
+			// The methods matching a wildcard pattern are known.
+			pr, err := pick("/google.spanner.v1.Spanner/GetSession")
+			if err != nil {
+				t.Fatalf("Pick returned error: %v", err)
+			}
+			if pr.SubConn != poolSC {
+				t.Fatalf("Pick returned %v for a known method, want %v", pr.SubConn, poolSC)
+			}
+
+			pr, err = pick(executeSQL)
+			if tc.want == "" {
+				if status.Code(err) != codes.FailedPrecondition {
+					t.Fatalf("Pick returned %v for an unknown method, want FailedPrecondition", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Pick returned error: %v", err)
+				}
+				wantSC := poolSC
+				if tc.want == "overflow" {
+					wantSC = b.controlSC
+				}
+				if pr.SubConn != wantSC {
+					t.Fatalf("Pick returned %v for an unknown method, want the %s channel %v", pr.SubConn, tc.want, wantSC)
+				}
+			}
+			if got := b.poolMetrics().UnknownMethodCalls; got != 1 {
+				t.Fatalf("PoolMetrics.UnknownMethodCalls is %v, want 1", got)
+			}
+		})
 	}
+}
 
-	// Simulate subconn 2 recovered.
-	b.UpdateSubConnState(scs[2], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+func TestServerTimingLatency(t *testing.T) {
+	for _, tc := range []struct {
+		vals   []string
+		want   time.Duration
+		wantOK bool
+	}{
+		{vals: []string{"gfet4t7; dur=12.5"}, want: 12500 * time.Microsecond, wantOK: true},
+		{vals: []string{`cache;desc="hit", gfet4t7;dur=3`}, want: 3 * time.Millisecond, wantOK: true},
+		{vals: []string{"db;dur=53", "gfet4t7;desc=gfe;dur=7"}, want: 7 * time.Millisecond, wantOK: true},
+		{vals: []string{"db;dur=53"}},
+		{vals: []string{"gfet4t7"}},
+		{vals: []string{"gfet4t7;dur=abc"}},
+		{},
+	} {
+		md := metadata.MD{}
+		for _, v := range tc.vals {
+			md.Append(serverTimingKey, v)
+		}
+		got, ok := serverTimingLatency(md)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("serverTimingLatency(%q) = %v, %v, want %v, %v", tc.vals, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
 
-	// As originally mapped subconn 2 is recovered, it shoud be returned.
-	pr, err = b.picker.Pick(balancer.PickInfo{FullMethodName: testMethod, Ctx: ctx})
-	sc = pr.SubConn
+func TestServerTimingLatencyScore(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
 
-	if sc != scs[2] || err != nil {
-		t.Fatalf("gcpPicker.Pick returns %v, %v, want: %v, nil", sc, err, scs[2])
+	scs := []*mocks.MockSubConn{}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
+		newSC := mocks.NewMockSubConn(mockCtrl)
+		newSC.EXPECT().Connect().AnyTimes()
+		newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		scs = append(scs, newSC)
+		return newSC, nil
+	}).Times(2)
+
+	b := (&gcpBalancerBuilder{name: Name, opts: Config{Deterministic: true}}).Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{},
+		BalancerConfig: &GCPBalancerConfig{
+			ApiConfig: &pb.ApiConfig{
+				ChannelPool: &pb.ChannelPoolConfig{
+					MinSize:                          2,
+					MaxSize:                          2,
+					MaxConcurrentStreamsLowWatermark: 100,
+					PickStrategy:                     pb.ChannelPoolConfig_PICK_LOWEST_LATENCY,
+				},
+			},
+		},
+	})
+	for _, sc := range scs {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+
+	// Streaming calls report the backend latency in the trailers only.
+	stream := func(sc balancer.SubConn, latency string) {
+		ctx := context.WithValue(context.Background(), gcpKey, &gcpContext{streaming: true})
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: "method", Ctx: ctx})
+		if err != nil {
+			t.Fatalf("Pick returned error: %v", err)
+		}
+		if pr.SubConn != sc {
+			t.Fatalf("Pick returned %v, want %v", pr.SubConn, sc)
+		}
+		pr.Done(balancer.DoneInfo{Trailer: metadata.Pairs(serverTimingKey, "gfet4t7; dur="+latency)})
+	}
+	stream(scs[0], "50")
+	stream(scs[1], "5")
+	// The channel with the lower backend latency is preferred.
+	stream(scs[1], "5")
+
+	snap := b.affinitySnapshot()
+	if got := snap.Channels[0].ServerLatencyMs; got != 50 {
+		t.Fatalf("channel 0 ServerLatencyMs is %v, want 50", got)
+	}
+	if got := snap.Channels[1].ServerLatencyMs; got != 5 {
+		t.Fatalf("channel 1 ServerLatencyMs is %v, want 5", got)
 	}
 }
 
-func BenchmarkPick(b *testing.B) {
-	for _, poolSize := range []int{4, 8, 16, 32, 64} {
-		mockCtrl := gomock.NewController(b)
+func TestAffinityNamespaces(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
 
-		mockCC := mocks.NewMockClientConn(mockCtrl)
-		subconns := []*mocks.MockSubConn{}
-		mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
-		mockCC.EXPECT().NewSubConn(gomock.Any(), gomock.Any()).DoAndReturn(func(_, _ interface{}) (*mocks.MockSubConn, error) {
-			newSC := mocks.NewMockSubConn(mockCtrl)
-			newSC.EXPECT().Connect().AnyTimes()
-			newSC.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
-			subconns = append(subconns, newSC)
-			return newSC, nil
-		}).Times(poolSize)
-		bal := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
-		bal.UpdateClientConnState(
-			balancer.ClientConnState{
-				ResolverState: resolver.State{
-					Addresses: []resolver.Address{{Addr: "127.0.0.1"}},
+	sc1 := mocks.NewMockSubConn(mockCtrl)
+	sc2 := mocks.NewMockSubConn(mockCtrl)
+	for _, sc := range []*mocks.MockSubConn{sc1, sc2} {
+		sc.EXPECT().UpdateAddresses(gomock.Any()).AnyTimes()
+		sc.EXPECT().Connect().AnyTimes()
+	}
+	mockCC := mocks.NewMockClientConn(mockCtrl)
+	mockCC.EXPECT().UpdateState(gomock.Any()).AnyTimes()
+	mp := make(map[balancer.SubConn]*subConnRef)
+	// The busy subconn is not picked for unbound keys.
+	mp[sc1] = &subConnRef{id: 0, subConn: sc1, stateSignal: make(chan struct{}), streamsCnt: 5}
+	mp[sc2] = &subConnRef{id: 1, subConn: sc2, stateSignal: make(chan struct{})}
+
+	gcpcfg := &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          2,
+				MaxConcurrentStreamsLowWatermark: 100,
+			},
+			Method: []*pb.MethodConfig{
+				{
+					Name:     []string{"bind"},
+					Affinity: &pb.AffinityConfig{Command: pb.AffinityConfig_BIND, AffinityKey: "key"},
 				},
-				BalancerConfig: &GCPBalancerConfig{
-					ApiConfig: &pb.ApiConfig{
-						ChannelPool: &pb.ChannelPoolConfig{
-							MinSize:                          uint32(poolSize),
-							MaxSize:                          uint32(poolSize),
-							MaxConcurrentStreamsLowWatermark: 100,
-							FallbackToReady:                  true,
-						},
-					},
+				{
+					Name:     []string{"bound"},
+					Affinity: &pb.AffinityConfig{Command: pb.AffinityConfig_BOUND, AffinityKey: "key"},
 				},
 			},
-		)
-
-		// Move all subconns to ready state.
-		for _, sc := range subconns {
-			bal.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+		},
+	}
+	b := newBuilder().Build(mockCC, balancer.BuildOptions{}).(*gcpBalancer)
+	b.scRefs = mp
+	b.scStates[sc1] = connectivity.Idle
+	b.scStates[sc2] = connectivity.Idle
+	b.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState:  resolver.State{Addresses: b.addrs},
+		BalancerConfig: gcpcfg,
+	})
+	b.UpdateSubConnState(sc1, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	b.UpdateSubConnState(sc2, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+
+	pick := func(ctx context.Context, method string, gcpCtx *gcpContext) balancer.PickResult {
+		t.Helper()
+		pr, err := b.picker.Pick(balancer.PickInfo{FullMethodName: method, Ctx: context.WithValue(ctx, gcpKey, gcpCtx)})
+		if err != nil {
+			t.Fatalf("gcpPicker.Pick returns error %v, want nil", err)
+		}
+		return pr
+	}
+	nsA := WithAffinityNamespace(context.Background(), "a")
+	nsB := WithAffinityNamespace(context.Background(), "b")
+
+	// The same key is bound in both namespaces, to the channels of the calls.
+	b.bindSubConn(NamespacedAffinityKey("a", "s1"), sc1)
+	pr := pick(nsB, "bind", &gcpContext{replyMsg: &testMsg{Key: "s1"}})
+	if pr.SubConn != sc2 {
+		t.Fatalf("BIND call in namespace b picked %v, want the less busy %v", pr.SubConn, sc2)
+	}
+	pr.Done(balancer.DoneInfo{})
+	for _, tc := range []struct {
+		ctx  context.Context
+		want balancer.SubConn
+	}{
+		{nsA, sc1},
+		{nsB, sc2},
+	} {
+		pr := pick(tc.ctx, "bound", &gcpContext{reqMsg: &testMsg{Key: "s1"}})
+		if pr.SubConn != tc.want {
+			ns, _ := AffinityNamespaceFromContext(tc.ctx)
+			t.Fatalf("BOUND call in namespace %q picked %v, want %v", ns, pr.SubConn, tc.want)
 		}
+		pr.Done(balancer.DoneInfo{})
+	}
+	if _, ok := b.affinityMap["s1"]; ok {
+		t.Fatalf("s1 is bound in the default namespace, want it bound in namespaces a and b only")
+	}
 
-		cwg := sync.WaitGroup{}
-		b.Run(fmt.Sprintf("pool_size_%d", poolSize), func(b *testing.B) {
-			wg := sync.WaitGroup{}
-			n := b.N / poolSize
+	// Context affinity keys are scoped too.
+	b.bindSubConn("tx", sc1)
+	pr = pick(WithChannelAffinity(nsA, "tx"), "other", &gcpContext{})
+	pr.Done(balancer.DoneInfo{})
+	if pr.SubConn != sc2 || b.affinityMap[NamespacedAffinityKey("a", "tx")] != sc2 {
+		t.Fatalf("call with context affinity key tx in namespace a picked %v, want %v bound to the key", pr.SubConn, sc2)
+	}
 
-			// Call Pick concurrently from poolSize parallel jobs.
-			for i := 0; i < poolSize; i++ {
-				wg.Add(1)
-				go func() {
-					for j := 0; j < n; j++ {
-						pr, _ := bal.picker.Pick(balancer.PickInfo{FullMethodName: "", Ctx: context.Background()})
-						cwg.Add(1)
-						go func() {
-							time.Sleep((5 + time.Duration(rand.Intn(15))) * time.Millisecond)
-							pr.Done(balancer.DoneInfo{})
-							cwg.Done()
-						}()
-					}
-					wg.Done()
-				}()
-			}
-			wg.Wait()
-		})
-		// Wait for all Done callbacks.
-		cwg.Wait()
-		mockCtrl.Finish()
+	// The interceptors set the namespace unless the Context has one.
+	var gotNS []string
+	invoker := func(ctx context.Context, _ string, _, _ interface{}, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		ns, _ := AffinityNamespaceFromContext(ctx)
+		gotNS = append(gotNS, ns)
+		return nil
+	}
+	interceptor := NamespaceUnaryClientInterceptor("client")
+	interceptor(context.Background(), "bound", nil, nil, nil, invoker)
+	interceptor(nsA, "bound", nil, nil, nil, invoker)
+	if diff := cmp.Diff([]string{"client", "a"}, gotNS); diff != "" {
+		t.Fatalf("NamespaceUnaryClientInterceptor set unexpected namespaces (-want, +got):\n%s", diff)
 	}
 }
@@ -0,0 +1,146 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+)
+
+type contextAffinityKey int
+
+type contextPinKey int
+
+var (
+	affinityCtxKey contextAffinityKey
+	pinCtxKey      contextPinKey
+)
+
+// WithChannelAffinity returns a new Context that carries the affinity key for
+// the calls made with it, e.g., all calls of a transaction. The first call
+// with the key binds the key to the channel picked for the call and following
+// calls with the key use the same channel, regardless of the affinity
+// configuration of the methods. The key stays bound until it is released with
+// ReleaseChannelAffinity or unbound by an UNBIND call with the same key.
+func WithChannelAffinity(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, affinityCtxKey, key)
+}
+
+// ChannelAffinityFromContext returns the affinity key stored in ctx, if any.
+func ChannelAffinityFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(affinityCtxKey).(string)
+	return key, ok
+}
+
+// PinChannel returns a new Context pinning the calls made with it to the
+// channel with the index in the pool. The pin takes precedence over any
+// affinity. Calls wait while the channel is not ready and fail with
+// INVALID_ARGUMENT status if the pool has no channel with the index.
+func PinChannel(ctx context.Context, index int) context.Context {
+	return context.WithValue(ctx, pinCtxKey, index)
+}
+
+// PinnedChannelFromContext returns the index of the channel pinned in ctx, if
+// any.
+func PinnedChannelFromContext(ctx context.Context) (int, bool) {
+	index, ok := ctx.Value(pinCtxKey).(int)
+	return index, ok
+}
+
+// pickCallOption is a grpc.CallOption overriding the pick of a single call
+// made with the GCP interceptors, see OnChannel and WithAffinityKey.
+type pickCallOption struct {
+	grpc.EmptyCallOption
+	apply func(ctx context.Context) context.Context
+}
+
+// OnChannel returns a CallOption pinning the call to the channel with the
+// index in the pool as PinChannel does, e.g., for a health probe of a specific
+// channel or to debug a channel in production. It requires the GCP
+// interceptors and takes precedence over the Context of the call.
+func OnChannel(index int) grpc.CallOption {
+	return pickCallOption{apply: func(ctx context.Context) context.Context {
+		return PinChannel(ctx, index)
+	}}
+}
+
+// WithAffinityKey returns a CallOption sending the call with the affinity key
+// as WithChannelAffinity does for the calls made with a Context. It requires
+// the GCP interceptors and takes precedence over the Context of the call.
+func WithAffinityKey(key string) grpc.CallOption {
+	return pickCallOption{apply: func(ctx context.Context) context.Context {
+		return WithChannelAffinity(ctx, key)
+	}}
+}
+
+// withPickCallOptions returns the ctx with the pick overrides of the call
+// options applied in order.
+func withPickCallOptions(ctx context.Context, opts []grpc.CallOption) context.Context {
+	for _, o := range opts {
+		if po, ok := o.(pickCallOption); ok {
+			ctx = po.apply(ctx)
+		}
+	}
+	return ctx
+}
+
+// ReleaseChannelAffinity unbinds the affinity key set with WithChannelAffinity
+// from its channel in the pool of the ClientConn. An error matching
+// ErrKeyNotBound is returned if the key is not bound, e.g., no call was made
+// with it or it was unbound by an UNBIND call. ErrBalancerNotFound is returned
+// if the ClientConn does not use the grpc_gcp balancer or no call was made on
+// it with the GCP interceptors yet.
+func ReleaseChannelAffinity(conn *grpc.ClientConn, key string) error {
+	gb, err := balancerForConn(conn)
+	if err != nil {
+		return err
+	}
+	if !gb.unbindSubConn(gb.normalizeScopedKey(key), 0) {
+		return gb.poolError(ErrKeyNotBound, -1, key)
+	}
+	return nil
+}
+
+// getPinnedSubConnRef returns the subConnRef with the index if it is ready.
+func (gb *gcpBalancer) getPinnedSubConnRef(index int) (*subConnRef, error) {
+	gb.mu.RLock()
+	if index < 0 || index >= len(gb.scRefList) {
+		gb.mu.RUnlock()
+		return nil, gb.poolError(ErrChannelNotFound, index, "")
+	}
+	defer gb.mu.RUnlock()
+	scRef := gb.scRefList[index]
+	if gb.scStates[scRef.subConn] != connectivity.Ready {
+		return nil, balancer.ErrNoSubConnAvailable
+	}
+	return scRef, nil
+}
+
+// getContextAffinitySubConnRef returns the subConnRef bound to the affinity
+// key from the context. If the key is not bound yet, it is bound to the least
+// busy subConnRef.
+func (p *gcpPicker) getContextAffinitySubConnRef(key string, mp *methodPool) (*subConnRef, error) {
+	if ref, ok := p.gb.getReadySubConnRef(key); ok || ref != nil {
+		return ref, nil
+	}
+	return p.bindLeastBusySubConnRef(key, 0, mp)
+}
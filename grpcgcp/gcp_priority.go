@@ -0,0 +1,71 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"sync/atomic"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+type callPriorityKey struct{}
+
+// WithCallPriority returns a new Context making the calls without an affinity
+// key use the priority when all channels approach their stream limits, e.g.,
+// HIGH for commits or LOW for background scans. It overrides the priority of
+// the method config, see MethodChannelPoolConfig.priority.
+func WithCallPriority(ctx context.Context, priority pb.CallPriority) context.Context {
+	return context.WithValue(ctx, callPriorityKey{}, priority)
+}
+
+// callPriority returns the priority of the call from the context or the
+// method config.
+func (gb *gcpBalancer) callPriority(ctx context.Context, method string) pb.CallPriority {
+	if ctx != nil {
+		if p, ok := ctx.Value(callPriorityKey{}).(pb.CallPriority); ok {
+			return p
+		}
+	}
+	return gb.methodPriority(method)
+}
+
+// lowPriorityMaxStreams returns the low watermark of the streams for LOW
+// priority calls, leaving the reserved streams to other calls. At least one
+// stream per channel is left to LOW priority calls.
+func (gb *gcpBalancer) lowPriorityMaxStreams(maxStreams int32) int32 {
+	reserved := int32(gb.config().GetChannelPool().GetLowPriorityReservedStreams())
+	if maxStreams-reserved < 1 {
+		return 1
+	}
+	return maxStreams - reserved
+}
+
+// queueLowPriority makes the next finished call provide a new picker for the
+// queued LOW priority calls.
+func (gb *gcpBalancer) queueLowPriority() {
+	atomic.StoreUint32(&gb.lowPriorityQueued, 1)
+}
+
+// dequeueLowPriority provides a new picker if LOW priority calls are queued.
+func (gb *gcpBalancer) dequeueLowPriority() {
+	if atomic.CompareAndSwapUint32(&gb.lowPriorityQueued, 1, 0) {
+		go gb.repick()
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+)
+
+// WaitForPoolReady blocks until at least minReady channels of the pool of the
+// ClientConn are READY or the ctx is done, e.g., to gate the readiness of an
+// application on a warmed pool rather than the first READY channel:
+//
+//	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+//	defer cancel()
+//	if err := grpcgcp.WaitForPoolReady(ctx, conn, 4); err != nil {
+//		// Not ready, handle error.
+//	}
+//
+// The pool connects min_size channels on its own and grows above it only with
+// the load, so minReady should not exceed min_size. An error is returned right
+// away if minReady exceeds max_size. If no call was made on the ClientConn
+// yet, WaitForPoolReady makes a call that fails on pick without being sent,
+// to find the pool. This requires the GCP interceptors, otherwise
+// ErrBalancerNotFound is returned. The ctx error is returned if the ctx is
+// done first.
+func WaitForPoolReady(ctx context.Context, conn *grpc.ClientConn, minReady int) error {
+	gb, err := balancerForConn(conn)
+	if err == ErrBalancerNotFound {
+		gb, err = linkPool(ctx, conn)
+	}
+	if err != nil {
+		return err
+	}
+	if maxSize := gb.config().GetChannelPool().GetMaxSize(); maxSize > 0 && minReady > int(maxSize) {
+		return fmt.Errorf("grpcgcp: cannot wait for %d READY channels in a pool of max_size %d", minReady, maxSize)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for u := range gb.watchStates(ctx) {
+		if u.ReadyChannels >= minReady {
+			return nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("grpcgcp: the ClientConn was closed before %d channels were READY", minReady)
+}
+
+// applyMinReadyLocked sets the number of READY channels required for the READY
+// state of the pool from Config.MinReadyChannels, capped at min_size.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) applyMinReadyLocked() {
+	minReady := gb.opts.MinReadyChannels
+	if minSize := gb.config().GetChannelPool().GetMinSize(); minReady > minSize {
+		minReady = minSize
+	}
+	gb.csEvltr.minReady = uint64(minReady)
+	if gb.csEvltr.numReady > 0 {
+		gb.state = gb.csEvltr.evaluate()
+	}
+}
+
+// checkDegradedLocked reports the pool as degraded once fewer than
+// Config.DegradedReadyRatio of its channels are READY, and as recovered once
+// they are READY again. The pool is not reported as degraded before it first
+// reaches the ratio, e.g., while its channels connect on startup.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) checkDegradedLocked() {
+	ratio := gb.opts.DegradedReadyRatio
+	if ratio <= 0 || len(gb.scRefs) == 0 {
+		return
+	}
+	ready := gb.readyChannelsLocked()
+	degraded := float64(ready) < ratio*float64(len(gb.scRefs))
+	switch {
+	case !degraded && !gb.reachedReadyRatio:
+		gb.reachedReadyRatio = true
+	case degraded && gb.reachedReadyRatio && !gb.degraded:
+		gb.degraded = true
+		gb.log.Warningf("pool is degraded: %d of %d channels are READY", ready, len(gb.scRefs))
+		if gb.resolverErr == nil {
+			gb.emit(PoolDegraded, -1, "", "")
+		}
+	case !degraded && gb.degraded:
+		gb.degraded = false
+		gb.log.Infof("pool recovered: %d of %d channels are READY", ready, len(gb.scRefs))
+		if gb.resolverErr == nil {
+			gb.emit(PoolRecovered, -1, "", "")
+		}
+	}
+}
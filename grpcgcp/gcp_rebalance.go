@@ -0,0 +1,133 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/connectivity"
+)
+
+// startAffinityRebalancing starts the periodic rebalancing of the affinity
+// keys across the channels if configured.
+func (gb *gcpBalancer) startAffinityRebalancing() {
+	if !gb.rebalancing {
+		return
+	}
+	ar := gb.config().GetChannelPool().GetAffinityRebalancing()
+	ticker := time.NewTicker(time.Duration(ar.GetIntervalMs()) * time.Millisecond)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-gb.done:
+				return
+			case <-ticker.C:
+				gb.rebalanceAffinity()
+			}
+		}
+	}()
+}
+
+// rebalanceAffinity moves up to max_moves affinity keys without active calls
+// from the channel with the most keys to the ready channel with the least
+// keys while the difference of their key counts exceeds the tolerance.
+func (gb *gcpBalancer) rebalanceAffinity() {
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+
+	ar := gb.config().GetChannelPool().GetAffinityRebalancing()
+	maxMoves := int(ar.GetMaxMoves())
+	if maxMoves == 0 {
+		maxMoves = 1
+	}
+	tolerance := int32(ar.GetTolerance())
+	if tolerance == 0 {
+		tolerance = 1
+	}
+	refs := []*subConnRef{}
+	for _, ref := range gb.scRefList {
+		if ref.draining || ref.isEjected() || gb.scStates[ref.subConn] != connectivity.Ready {
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	if len(refs) < 2 {
+		return
+	}
+	for moves := 0; moves < maxMoves; moves++ {
+		most, least := refs[0], refs[0]
+		for _, ref := range refs[1:] {
+			if ref.getAffinityCnt() > most.getAffinityCnt() {
+				most = ref
+			}
+			if ref.getAffinityCnt() < least.getAffinityCnt() {
+				least = ref
+			}
+		}
+		if most.getAffinityCnt()-least.getAffinityCnt() <= tolerance {
+			return
+		}
+		key, ok := gb.idleKeyLocked(most)
+		if !ok {
+			return
+		}
+		gb.moveKeyLocked(key, most, least)
+		atomic.AddUint64(&gb.counters.rebalancedKeys, 1)
+		if gb.log.V(FINE) {
+			gb.log.Infof("rebalanced affinity key %s from channel %s to channel %s", AffinityKeyHash(key), most.label, least.label)
+		}
+	}
+}
+
+// idleKeyLocked returns an affinity key bound to the ref without active calls.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) idleKeyLocked(ref *subConnRef) (string, bool) {
+	for key, sc := range gb.affinityMap {
+		if sc != ref.subConn {
+			continue
+		}
+		if streams := gb.affinityStreams[key]; streams != nil && atomic.LoadInt32(streams) == 0 {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// affinityKeyStreamsIncr counts an active call using the affinity key of the
+// call, if bound, and returns its counter to decrement once the call is done.
+func (gb *gcpBalancer) affinityKeyStreamsIncr(ctx context.Context, boundKey string) *int32 {
+	key := boundKey
+	if k, ok := gb.scopedContextAffinityKey(ctx); ok {
+		key = k
+	}
+	if key == "" {
+		return nil
+	}
+	gb.mu.RLock()
+	streams := gb.affinityStreams[key]
+	gb.mu.RUnlock()
+	if streams == nil {
+		return nil
+	}
+	atomic.AddInt32(streams, 1)
+	return streams
+}
@@ -0,0 +1,114 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+)
+
+const (
+	defaultReconnectMaxDelay   = 120 * time.Second
+	defaultReconnectMultiplier = 1.6
+)
+
+// reconnectDelay returns the delay before the n-th consecutive reconnect
+// attempt or 0 if the reconnect backoff is disabled.
+func (gb *gcpBalancer) reconnectDelay(attempt uint32) time.Duration {
+	rb := gb.config().GetChannelPool().GetReconnectBackoff()
+	if rb.GetBaseDelayMs() == 0 || attempt == 0 {
+		return 0
+	}
+	maxDelay := defaultReconnectMaxDelay
+	if rb.GetMaxDelayMs() > 0 {
+		maxDelay = time.Duration(rb.GetMaxDelayMs()) * time.Millisecond
+	}
+	multiplier := float64(rb.GetMultiplier())
+	if multiplier < 1 {
+		multiplier = defaultReconnectMultiplier
+	}
+	delay := float64(rb.GetBaseDelayMs()) * float64(time.Millisecond) * math.Pow(multiplier, float64(attempt-1))
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+	if jitter := math.Min(float64(rb.GetJitter()), 1); jitter > 0 && !gb.opts.Deterministic {
+		delay *= 1 + jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
+
+// reconnect connects the idle SubConn sc immediately or after a backoff delay
+// if the reconnect backoff is configured. If the max number of consecutive
+// reconnect attempts is exceeded, the channel is replaced with a new one.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) reconnect(sc balancer.SubConn) {
+	ref := gb.scRefs[sc]
+	if ref == nil {
+		sc.Connect()
+		return
+	}
+	ref.stopReconnect()
+	ref.reconnectAttempts++
+	rb := gb.config().GetChannelPool().GetReconnectBackoff()
+	if max := rb.GetMaxAttempts(); max > 0 && ref.reconnectAttempts > max {
+		gb.log.Warningf("channel %s failed to reconnect %d times, replacing it", ref.label, max)
+		ref.reconnectAttempts = 0
+		gb.refreshLocked(ref)
+		// The replacement connects on its own, the old SubConn is removed once
+		// it is ready. Keep reconnecting only if it cannot be created.
+		if ref.refreshing {
+			return
+		}
+	}
+	delay := gb.reconnectDelay(ref.reconnectAttempts)
+	if delay == 0 {
+		sc.Connect()
+		return
+	}
+	if gb.log.V(FINE) {
+		gb.log.Infof("reconnecting channel %s in %v (attempt %d)", ref.label, delay, ref.reconnectAttempts)
+	}
+	ref.reconnectTimer = time.AfterFunc(delay, func() {
+		gb.mu.Lock()
+		defer gb.mu.Unlock()
+		select {
+		case <-gb.done:
+			return
+		default:
+		}
+		// Skip if the SubConn was replaced or is no longer idle.
+		if ref.subConn != sc || gb.scStates[sc] != connectivity.Idle {
+			return
+		}
+		sc.Connect()
+	})
+}
+
+// stopReconnect cancels a scheduled reconnect of the subConnRef if any.
+// Must be called holding the balancer mutex lock.
+func (ref *subConnRef) stopReconnect() {
+	if ref.reconnectTimer != nil {
+		ref.reconnectTimer.Stop()
+		ref.reconnectTimer = nil
+	}
+}
@@ -0,0 +1,175 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"fmt"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/protobuf/proto"
+)
+
+// UpdateConfig replaces the pool limits and the method configs of the pool of
+// the ClientConn without reconnecting, e.g., for a long-lived server changing
+// its tuning at runtime. The min_size, max_size,
+// max_concurrent_streams_low_watermark and max_affinity_keys_per_channel of
+// the channel pool config and the method configs are replaced at once for the
+// calls picked afterwards. The other channel pool options keep their values.
+// The Config and the environment variables still take precedence.
+//
+// The pool grows to the new min_size right away. The channels above the new
+// max_size no longer get new calls, their affinity keys move to the remaining
+// channels and they are removed once their active calls finish.
+//
+// Method configs can change the max_concurrent_streams_low_watermark of the
+// method channel pools but not add method channel pools. ErrBalancerNotFound
+// is returned if the ClientConn does not use the grpc_gcp balancer or no call
+// was made on it with the GCP interceptors yet.
+func UpdateConfig(conn *grpc.ClientConn, cfg *pb.ApiConfig) error {
+	gb, err := balancerForConn(conn)
+	if err != nil {
+		return err
+	}
+	return gb.updateConfig(cfg)
+}
+
+func (gb *gcpBalancer) updateConfig(cfg *pb.ApiConfig) error {
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+	cur := gb.config()
+	if cur == nil {
+		return fmt.Errorf("grpcgcp: the balancer has no config to update yet")
+	}
+	newCfg := proto.Clone(cur.ApiConfig).(*pb.ApiConfig)
+	cp := newCfg.GetChannelPool()
+	cp.MinSize = cfg.GetChannelPool().GetMinSize()
+	cp.MaxSize = cfg.GetChannelPool().GetMaxSize()
+	cp.MaxConcurrentStreamsLowWatermark = cfg.GetChannelPool().GetMaxConcurrentStreamsLowWatermark()
+	cp.MaxAffinityKeysPerChannel = cfg.GetChannelPool().GetMaxAffinityKeysPerChannel()
+	gb.applyPoolDefaults(cp)
+	if cp.GetMinSize() > cp.GetMaxSize() {
+		return fmt.Errorf("grpcgcp: min_size (%d) is greater than max_size (%d)", cp.GetMinSize(), cp.GetMaxSize())
+	}
+	newCfg.Method = nil
+	for _, m := range cfg.GetMethod() {
+		newCfg.Method = append(newCfg.Method, proto.Clone(m).(*pb.MethodConfig))
+	}
+	methods := newMethodConfigs(newCfg.GetMethod())
+	if methods.poolsCnt > gb.methodPoolsCnt {
+		return fmt.Errorf("grpcgcp: the config has %d method channel pools, at most %d can be updated", methods.poolsCnt, gb.methodPoolsCnt)
+	}
+
+	gb.setConfig(&GCPBalancerConfig{ApiConfig: newCfg}, methods)
+	if needsControlChannel(methods, cp) {
+		gb.ensureControlChannelLocked()
+	}
+	if gb.log.V(FINE) {
+		gb.log.Infof("updated the config: min_size %d, max_size %d, max_concurrent_streams_low_watermark %d, %d method configs",
+			cp.GetMinSize(), cp.GetMaxSize(), cp.GetMaxConcurrentStreamsLowWatermark(), len(newCfg.GetMethod()))
+	}
+	gb.applyMinReadyLocked()
+	gb.enforceMinSize()
+	gb.shrinkLocked(int(cp.GetMaxSize()))
+	gb.regeneratePicker()
+	gb.cc.UpdateState(balancer.State{
+		ConnectivityState: gb.state,
+		Picker:            gb.picker,
+	})
+	return nil
+}
+
+// shrinkLocked removes the channels with an index of maxSize or above from the
+// pool, keeping the channel indexes contiguous. They are excluded from new
+// picks, their keys move to the remaining channels and they are removed once
+// drained, see removeLocked. The caller must update the picker.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) shrinkLocked(maxSize int) {
+	if len(gb.scRefList) <= maxSize {
+		return
+	}
+	removed := gb.scRefList[maxSize:]
+	gb.scRefList = gb.scRefList[:maxSize:maxSize]
+	for _, ref := range removed {
+		if gb.log.V(FINE) {
+			gb.log.Infof("removing channel %s above max_size %d", ref.label, maxSize)
+		}
+		ref.removing = true
+		gb.settleGrowthLocked(ref, ref.subConn, connectivity.Shutdown)
+		gb.drainLocked(ref)
+	}
+	// Migrate once all the removed channels are draining so that no key is
+	// moved to another removed channel.
+	for _, ref := range removed {
+		gb.migrateKeysLocked(ref)
+	}
+}
+
+// removeLocked removes the drained ref from the pool. The keys still bound to
+// it, because no other channel was ready, are unbound.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) removeLocked(ref *subConnRef) {
+	if gb.log.V(FINE) {
+		gb.log.Infof("channel %s is drained, removing it from the pool", ref.label)
+	}
+	for k, sc := range gb.affinityMap {
+		if sc == ref.subConn {
+			delete(gb.affinityMap, k)
+			delete(gb.affinityUsed, k)
+			delete(gb.affinityTTL, k)
+			delete(gb.affinityStreams, k)
+			gb.log.Warningf("unbound affinity key %s from the removed channel %s", AffinityKeyHash(k), ref.label)
+		}
+	}
+	for k, sc := range gb.fallbackMap {
+		if sc == ref.subConn {
+			delete(gb.fallbackMap, k)
+		}
+	}
+	for k, u := range gb.unbound {
+		if u.sc == ref.subConn {
+			delete(gb.unbound, k)
+		}
+	}
+	for sc, r := range gb.refreshingScRefs {
+		if r == ref {
+			delete(gb.refreshingScRefs, sc)
+			gb.disarmConnectTimeoutLocked(sc)
+			gb.removeSubConnLocked(sc, ref)
+		}
+	}
+	ref.stopReconnect()
+	gb.disarmConnectTimeoutLocked(ref.subConn)
+	gb.settleGrowthLocked(ref, ref.subConn, connectivity.Shutdown)
+	oldS := gb.scStates[ref.subConn]
+	gb.state = gb.csEvltr.recordTransition(oldS, connectivity.Shutdown)
+	gb.notifyStateWatchersLocked(ref.id, oldS, connectivity.Shutdown)
+	delete(gb.scRefs, ref.subConn)
+	delete(gb.scStates, ref.subConn)
+	gb.releaseBudget()
+	gb.checkDegradedLocked()
+	gb.removeSubConnLocked(ref.subConn, ref)
+	gb.regeneratePicker()
+	gb.cc.UpdateState(balancer.State{
+		ConnectivityState: gb.state,
+		Picker:            gb.picker,
+	})
+}
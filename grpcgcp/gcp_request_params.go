@@ -0,0 +1,92 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"net/url"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+// RequestParamsHeader is the routing header of Google APIs, see
+// AffinityConfig.affinity_key_params.
+const RequestParamsHeader = "x-goog-request-params"
+
+// requestParams returns the parameters of the routing header in the md. The
+// values of the header are URL-encoded.
+func requestParams(md metadata.MD) url.Values {
+	params := url.Values{}
+	for _, v := range md.Get(RequestParamsHeader) {
+		vals, err := url.ParseQuery(v)
+		if err != nil {
+			continue
+		}
+		for k, vv := range vals {
+			params[k] = append(params[k], vv...)
+		}
+	}
+	return params
+}
+
+// requestParamsKey returns the affinity key composed of the parameters of the
+// affinity config, see affinity_key_params, from the routing header in the md
+// or, for the parameters missing from the header, located in the request
+// message. The key is empty if the first parameter has no value.
+func requestParamsKey(cfg *pb.AffinityConfig, md metadata.MD, locate func(locator string) ([]string, error)) string {
+	params := requestParams(md)
+	var sb strings.Builder
+	for i, name := range cfg.GetAffinityKeyParams() {
+		v := params.Get(name)
+		if v == "" && locate != nil {
+			if keys, err := locate(name); err == nil && len(keys) > 0 {
+				v = keys[0]
+			}
+		}
+		if i == 0 && v == "" {
+			return ""
+		}
+		if i > 0 {
+			sb.WriteByte('&')
+		}
+		sb.WriteString(url.QueryEscape(name))
+		sb.WriteByte('=')
+		sb.WriteString(url.QueryEscape(v))
+	}
+	return sb.String()
+}
+
+// requestAffinityKeys retrieves the affinity key(s) of the call of the method
+// from its request message msg, or also from its routing header in the
+// outgoing metadata md if the affinity config has affinity_key_params. The
+// msg is nil for the calls made without the GCP interceptors.
+func (gb *gcpBalancer) requestAffinityKeys(md metadata.MD, cfg *pb.AffinityConfig, method string, msg interface{}) ([]string, error) {
+	if len(cfg.GetAffinityKeyParams()) == 0 {
+		return gb.configuredAffinityKeys(cfg, method, false, msg)
+	}
+	var locate func(string) ([]string, error)
+	if msg != nil {
+		locate = func(locator string) ([]string, error) {
+			return gb.affinityKeys(locator, method, false, msg)
+		}
+	}
+	return []string{gb.normalizeKey(requestParamsKey(cfg, md, locate))}, nil
+}
@@ -0,0 +1,145 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	// Backoff of the re-resolutions requested while the name resolver fails.
+	resolverRetryBaseDelay  = time.Second
+	resolverRetryMaxDelay   = 120 * time.Second
+	resolverRetryMultiplier = 1.6
+	resolverRetryJitter     = 0.2
+)
+
+// resolverFailure wraps the error returned by the picks while the name
+// resolver fails and no address was ever resolved.
+type resolverFailure struct {
+	err error
+}
+
+// ResolverError keeps the pool serving on its existing channels while the
+// name resolver fails: the channels keep their addresses and connections and
+// the pool is reported as degraded until the resolver returns addresses
+// again. Re-resolution is requested with an exponential backoff meanwhile. If
+// no address was ever resolved, the calls without a READY channel fail with
+// the resolver error instead of waiting, except the wait-for-ready calls.
+func (gb *gcpBalancer) ResolverError(err error) {
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+	atomic.AddUint64(&gb.counters.resolverErrors, 1)
+	if gb.resolverErr == nil {
+		gb.log.Warningf("name resolver error, serving on the existing channels: %v", err)
+		if !gb.degraded {
+			gb.emit(PoolDegraded, -1, "", "")
+		}
+	} else if gb.log.V(FINE) {
+		gb.log.Infof("name resolver error: %v", err)
+	}
+	gb.resolverErr = err
+	gb.scheduleResolveLocked()
+	if len(gb.addrs) > 0 || gb.hasChannelTargets() {
+		return
+	}
+	gb.resolverFailFast.Store(resolverFailure{err: fmt.Errorf("grpcgcp: name resolver error: %v", err)})
+	if gb.picker == nil {
+		return
+	}
+	// Let the waiting picks fail with the resolver error.
+	gb.regeneratePicker()
+	gb.cc.UpdateState(balancer.State{
+		ConnectivityState: gb.state,
+		Picker:            gb.picker,
+	})
+}
+
+// scheduleResolveLocked requests a re-resolution from the name resolver after
+// the backoff delay of the consecutive resolver errors, unless one is
+// scheduled.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) scheduleResolveLocked() {
+	if gb.resolveTimer != nil {
+		return
+	}
+	gb.resolveAttempts++
+	delay := gb.resolverRetryDelay(gb.resolveAttempts)
+	if gb.log.V(FINE) {
+		gb.log.Infof("requesting re-resolution in %v (attempt %d)", delay, gb.resolveAttempts)
+	}
+	gb.resolveTimer = time.AfterFunc(delay, func() {
+		gb.mu.Lock()
+		gb.resolveTimer = nil
+		gb.mu.Unlock()
+		select {
+		case <-gb.done:
+			return
+		default:
+		}
+		gb.cc.ResolveNow(resolver.ResolveNowOptions{})
+	})
+}
+
+// resolverRetryDelay returns the delay before the n-th consecutive
+// re-resolution request.
+func (gb *gcpBalancer) resolverRetryDelay(attempt uint32) time.Duration {
+	delay := float64(resolverRetryBaseDelay) * math.Pow(resolverRetryMultiplier, float64(attempt-1))
+	if delay > float64(resolverRetryMaxDelay) {
+		delay = float64(resolverRetryMaxDelay)
+	}
+	if !gb.opts.Deterministic {
+		delay *= 1 + resolverRetryJitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
+
+// resolverRecoveredLocked clears the resolver error once the resolver
+// returned addresses again.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) resolverRecoveredLocked() {
+	if gb.resolveTimer != nil {
+		gb.resolveTimer.Stop()
+		gb.resolveTimer = nil
+	}
+	gb.resolveAttempts = 0
+	if gb.resolverErr == nil {
+		return
+	}
+	gb.log.Infof("name resolver recovered")
+	gb.resolverErr = nil
+	gb.resolverFailFast.Store(resolverFailure{})
+	if !gb.degraded {
+		gb.emit(PoolRecovered, -1, "", "")
+	}
+}
+
+// resolverPickErr returns the error of the picks without a READY channel
+// while the name resolver fails and no address was ever resolved, or nil.
+func (gb *gcpBalancer) resolverPickErr() error {
+	f, _ := gb.resolverFailFast.Load().(resolverFailure)
+	return f.err
+}
@@ -0,0 +1,154 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+// ApiConfigForResource returns the ApiConfig with the method configs binding
+// the calls of the services to the channels by the name of the resource type,
+// e.g., "spanner.googleapis.com/Session", derived from the google.api.resource
+// and google.api.resource_reference annotations of the service descriptors:
+//
+//   - Create* and BatchCreate* methods returning the resource, or a message
+//     with a field of the resource, BIND the name of the returned resource(s).
+//   - Delete* methods with a request field referencing the resource UNBIND it.
+//   - Other methods with a request field referencing the resource are BOUND.
+//
+// Methods not involving the resource are left out. The channel pool config
+// is not set.
+//
+//	cfg, err := grpcgcp.ApiConfigForResource("spanner.googleapis.com/Session",
+//		spannerpb.File_google_spanner_v1_spanner_proto.Services().ByName("Spanner"))
+func ApiConfigForResource(resourceType string, services ...protoreflect.ServiceDescriptor) (*pb.ApiConfig, error) {
+	cfg := &pb.ApiConfig{}
+	groups := map[string]*pb.MethodConfig{}
+	bind := false
+	for _, sd := range services {
+		methods := sd.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			md := methods.Get(i)
+			affinity := methodAffinityForResource(md, resourceType)
+			if affinity == nil {
+				continue
+			}
+			bind = bind || affinity.GetCommand() == pb.AffinityConfig_BIND
+			name := fmt.Sprintf("/%s/%s", sd.FullName(), md.Name())
+			group := affinity.GetCommand().String() + " " + affinity.GetAffinityKey()
+			if mc, ok := groups[group]; ok {
+				mc.Name = append(mc.Name, name)
+				continue
+			}
+			mc := &pb.MethodConfig{Name: []string{name}, Affinity: affinity}
+			groups[group] = mc
+			cfg.Method = append(cfg.Method, mc)
+		}
+	}
+	if !bind {
+		return nil, fmt.Errorf("grpcgcp: no method creates %q resources", resourceType)
+	}
+	return cfg, nil
+}
+
+// methodAffinityForResource returns the affinity config of the method for the
+// resource type or nil if the method does not involve the resource.
+func methodAffinityForResource(md protoreflect.MethodDescriptor, resourceType string) *pb.AffinityConfig {
+	name := string(md.Name())
+	if strings.HasPrefix(name, "Create") || strings.HasPrefix(name, "BatchCreate") {
+		if path := resourceNamePath(md.Output(), resourceType); path != "" {
+			return &pb.AffinityConfig{Command: pb.AffinityConfig_BIND, AffinityKey: path}
+		}
+		return nil
+	}
+	path := resourceReferencePath(md.Input(), resourceType)
+	if path == "" {
+		return nil
+	}
+	cmd := pb.AffinityConfig_BOUND
+	if strings.HasPrefix(name, "Delete") {
+		cmd = pb.AffinityConfig_UNBIND
+	}
+	return &pb.AffinityConfig{Command: cmd, AffinityKey: path}
+}
+
+// resourceNamePath returns the path to the name field of the resource in the
+// message, which is the resource or has a field of the resource.
+func resourceNamePath(msg protoreflect.MessageDescriptor, resourceType string) string {
+	if nameField := resourceNameField(msg, resourceType); nameField != "" {
+		return nameField
+	}
+	fields := msg.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.Message() == nil || fd.IsMap() {
+			continue
+		}
+		if nameField := resourceNameField(fd.Message(), resourceType); nameField != "" {
+			return string(fd.Name()) + "." + nameField
+		}
+	}
+	return ""
+}
+
+// resourceNameField returns the name field of the message if it is annotated
+// as the resource type.
+func resourceNameField(msg protoreflect.MessageDescriptor, resourceType string) string {
+	opts := msg.Options()
+	if opts == nil || !proto.HasExtension(opts, annotations.E_Resource) {
+		return ""
+	}
+	rd, _ := proto.GetExtension(opts, annotations.E_Resource).(*annotations.ResourceDescriptor)
+	if rd.GetType() != resourceType {
+		return ""
+	}
+	if rd.GetNameField() != "" {
+		return rd.GetNameField()
+	}
+	return "name"
+}
+
+// resourceReferencePath returns the path to the string field of the message
+// referencing the resource type. A child_type reference, e.g., the parent of
+// the resource, does not identify the resource and is ignored.
+func resourceReferencePath(msg protoreflect.MessageDescriptor, resourceType string) string {
+	fields := msg.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.Kind() != protoreflect.StringKind {
+			continue
+		}
+		opts := fd.Options()
+		if opts == nil || !proto.HasExtension(opts, annotations.E_ResourceReference) {
+			continue
+		}
+		ref, _ := proto.GetExtension(opts, annotations.E_ResourceReference).(*annotations.ResourceReference)
+		if ref.GetType() == resourceType {
+			return string(fd.Name())
+		}
+	}
+	return ""
+}
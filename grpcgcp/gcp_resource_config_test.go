@@ -0,0 +1,108 @@
+package grpcgcp
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+// annotatedService returns a Spanner-like service with the resource
+// annotations of its sessions.
+func annotatedService(t *testing.T) protoreflect.ServiceDescriptor {
+	t.Helper()
+	str := func(name string, num int32, ref *annotations.ResourceReference) *descriptorpb.FieldDescriptorProto {
+		fd := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), JsonName: proto.String(name), Number: proto.Int32(num), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()}
+		if ref != nil {
+			fd.Options = &descriptorpb.FieldOptions{}
+			proto.SetExtension(fd.Options, annotations.E_ResourceReference, ref)
+		}
+		return fd
+	}
+	session := &descriptorpb.DescriptorProto{
+		Name:    proto.String("Session"),
+		Field:   []*descriptorpb.FieldDescriptorProto{str("name", 1, nil)},
+		Options: &descriptorpb.MessageOptions{},
+	}
+	proto.SetExtension(session.Options, annotations.E_Resource, &annotations.ResourceDescriptor{Type: "test.googleapis.com/Session"})
+	sessionRef := &annotations.ResourceReference{Type: "test.googleapis.com/Session"}
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("annotated.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			session,
+			{Name: proto.String("CreateSessionRequest"), Field: []*descriptorpb.FieldDescriptorProto{str("database", 1, &annotations.ResourceReference{Type: "test.googleapis.com/Database"})}},
+			{Name: proto.String("BatchCreateSessionsResponse"), Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("session"), JsonName: proto.String("session"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".test.Session"), Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()},
+			}},
+			{Name: proto.String("ListSessionsRequest"), Field: []*descriptorpb.FieldDescriptorProto{str("database", 1, &annotations.ResourceReference{ChildType: "test.googleapis.com/Session"})}},
+			{Name: proto.String("SessionRequest"), Field: []*descriptorpb.FieldDescriptorProto{str("name", 1, sessionRef)}},
+			{Name: proto.String("ExecuteSqlRequest"), Field: []*descriptorpb.FieldDescriptorProto{str("sql", 1, nil), str("session", 2, sessionRef)}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{
+				Name: proto.String("Spanner"),
+				Method: []*descriptorpb.MethodDescriptorProto{
+					{Name: proto.String("CreateSession"), InputType: proto.String(".test.CreateSessionRequest"), OutputType: proto.String(".test.Session")},
+					{Name: proto.String("BatchCreateSessions"), InputType: proto.String(".test.CreateSessionRequest"), OutputType: proto.String(".test.BatchCreateSessionsResponse")},
+					{Name: proto.String("ListSessions"), InputType: proto.String(".test.ListSessionsRequest"), OutputType: proto.String(".test.BatchCreateSessionsResponse")},
+					{Name: proto.String("GetSession"), InputType: proto.String(".test.SessionRequest"), OutputType: proto.String(".test.Session")},
+					{Name: proto.String("ExecuteSql"), InputType: proto.String(".test.ExecuteSqlRequest"), OutputType: proto.String(".test.Session")},
+					{Name: proto.String("DeleteSession"), InputType: proto.String(".test.SessionRequest"), OutputType: proto.String(".test.Session")},
+				},
+			},
+		},
+	}
+	fd, err := protodesc.NewFile(fdp, nil)
+	if err != nil {
+		t.Fatalf("protodesc.NewFile returned error: %v", err)
+	}
+	return fd.Services().ByName("Spanner")
+}
+
+func TestApiConfigForResource(t *testing.T) {
+	sd := annotatedService(t)
+	cfg, err := ApiConfigForResource("test.googleapis.com/Session", sd)
+	if err != nil {
+		t.Fatalf("ApiConfigForResource returned error: %v", err)
+	}
+	want := &pb.ApiConfig{
+		Method: []*pb.MethodConfig{
+			{
+				Name:     []string{"/test.Spanner/CreateSession"},
+				Affinity: &pb.AffinityConfig{Command: pb.AffinityConfig_BIND, AffinityKey: "name"},
+			},
+			{
+				Name:     []string{"/test.Spanner/BatchCreateSessions"},
+				Affinity: &pb.AffinityConfig{Command: pb.AffinityConfig_BIND, AffinityKey: "session.name"},
+			},
+			{
+				Name:     []string{"/test.Spanner/GetSession"},
+				Affinity: &pb.AffinityConfig{Command: pb.AffinityConfig_BOUND, AffinityKey: "name"},
+			},
+			{
+				Name:     []string{"/test.Spanner/ExecuteSql"},
+				Affinity: &pb.AffinityConfig{Command: pb.AffinityConfig_BOUND, AffinityKey: "session"},
+			},
+			{
+				Name:     []string{"/test.Spanner/DeleteSession"},
+				Affinity: &pb.AffinityConfig{Command: pb.AffinityConfig_UNBIND, AffinityKey: "name"},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, cfg, protocmp.Transform()); diff != "" {
+		t.Fatalf("ApiConfigForResource returned unexpected config (-want +got):\n%s", diff)
+	}
+
+	if _, err := ApiConfigForResource("test.googleapis.com/Database", sd); err == nil {
+		t.Fatalf("ApiConfigForResource for a resource never created returned no error")
+	}
+}
@@ -0,0 +1,175 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultSaturationWindow = time.Minute
+	// Number of saturation samples taken per window.
+	saturationSamples = 10
+)
+
+// SaturationWarning describes a pool saturated for a whole window, see
+// Config.OnSaturation.
+type SaturationWarning struct {
+	// The lowest saturation of the pool sampled in the window, in percent.
+	Percent float64
+	// The window the saturation was sustained for, see
+	// Config.SaturationWindow.
+	Window time.Duration
+	// Number of channels in the pool.
+	Channels int
+	// The max number of channels in the pool, see max_size.
+	MaxSize uint32
+	// The max number of channels bringing the saturation down to the
+	// threshold for the current number of active streams, i.e., a max_size
+	// to consider.
+	SuggestedMaxSize uint32
+}
+
+// observeStreams raises the high-water mark of the concurrent streams of the
+// window to n.
+func (ref *subConnRef) observeStreams(n int32) {
+	for {
+		peak := atomic.LoadInt32(&ref.peakStreams)
+		if n <= peak || atomic.CompareAndSwapInt32(&ref.peakStreams, peak, n) {
+			return
+		}
+	}
+}
+
+// rotatePeak starts a new window of the high-water mark of the concurrent
+// streams.
+func (ref *subConnRef) rotatePeak() {
+	atomic.StoreInt32(&ref.prevPeakStreams, atomic.SwapInt32(&ref.peakStreams, ref.getStreamsCnt()))
+}
+
+// getPeakStreams returns the max concurrent streams observed over the current
+// and the previous window.
+func (ref *subConnRef) getPeakStreams() int32 {
+	peak, prev := atomic.LoadInt32(&ref.peakStreams), atomic.LoadInt32(&ref.prevPeakStreams)
+	if prev > peak {
+		return prev
+	}
+	return peak
+}
+
+// saturationWindow returns the window of the high-water marks and of the
+// sustained saturation.
+func (gb *gcpBalancer) saturationWindow() time.Duration {
+	if gb.opts.SaturationWindow > 0 {
+		return gb.opts.SaturationWindow
+	}
+	return defaultSaturationWindow
+}
+
+// startSaturationTracking rotates the windows of the high-water marks of the
+// channels and samples the saturation of the pool.
+func (gb *gcpBalancer) startSaturationTracking() {
+	ticker := time.NewTicker(gb.saturationWindow() / saturationSamples)
+	go func() {
+		defer ticker.Stop()
+		for tick := 1; ; tick++ {
+			select {
+			case <-gb.done:
+				return
+			case <-ticker.C:
+				gb.sampleSaturation(tick%saturationSamples == 0)
+			}
+		}
+	}()
+}
+
+// saturationPercentLocked returns the active streams of the pool in percent of
+// the streams it serves below the low watermark at its max size, or at its
+// number of channels if greater, and that size.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) saturationPercentLocked() (float64, int) {
+	cfg := gb.config()
+	if cfg == nil {
+		return 0, 0
+	}
+	cp := cfg.GetChannelPool()
+	size := int(cp.GetMaxSize())
+	if len(gb.scRefList) > size {
+		size = len(gb.scRefList)
+	}
+	capacity := float64(size) * float64(cp.GetMaxConcurrentStreamsLowWatermark())
+	if capacity == 0 {
+		return 0, size
+	}
+	var active int32
+	for _, ref := range gb.scRefList {
+		active += ref.getStreamsCnt()
+	}
+	return 100 * float64(active) / capacity, size
+}
+
+// sampleSaturation samples the saturation of the pool and warns once it stays
+// at or above Config.SaturationWarningPercent for a whole window. The windows
+// of the high-water marks are rotated if rotate is set.
+func (gb *gcpBalancer) sampleSaturation(rotate bool) {
+	gb.mu.Lock()
+	if rotate {
+		for _, ref := range gb.scRefList {
+			ref.rotatePeak()
+		}
+	}
+	threshold := gb.opts.SaturationWarningPercent
+	if threshold <= 0 {
+		gb.mu.Unlock()
+		return
+	}
+	pct, size := gb.saturationPercentLocked()
+	if pct < threshold {
+		if gb.saturated {
+			gb.log.Infof("pool is no longer saturated: %.1f%%", pct)
+		}
+		gb.saturatedSamples = 0
+		gb.saturated = false
+		gb.mu.Unlock()
+		return
+	}
+	if gb.saturatedSamples == 0 || pct < gb.saturationLow {
+		gb.saturationLow = pct
+	}
+	gb.saturatedSamples++
+	if gb.saturated || gb.saturatedSamples < saturationSamples {
+		gb.mu.Unlock()
+		return
+	}
+	gb.saturated = true
+	w := SaturationWarning{
+		Percent:          gb.saturationLow,
+		Window:           gb.saturationWindow(),
+		Channels:         len(gb.scRefList),
+		MaxSize:          gb.config().GetChannelPool().GetMaxSize(),
+		SuggestedMaxSize: uint32(math.Ceil(float64(size) * pct / threshold)),
+	}
+	gb.log.Warningf("pool is saturated: %.1f%% or more of max_size %d for %v, consider raising max_size to %d", w.Percent, w.MaxSize, w.Window, w.SuggestedMaxSize)
+	gb.mu.Unlock()
+	if gb.opts.OnSaturation != nil {
+		gb.opts.OnSaturation(w)
+	}
+}
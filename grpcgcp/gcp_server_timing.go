@@ -0,0 +1,70 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// serverTimingKey is the response header or trailer with the W3C Server-Timing
+// metrics, e.g., "gfet4t7; dur=12.5".
+const serverTimingKey = "server-timing"
+
+// gfeLatencyMetric is the Server-Timing metric of the Google Front End with
+// the duration in milliseconds between the GFE receiving the request and
+// sending the response, i.e., the latency of the backend without the network
+// between the client and the GFE.
+const gfeLatencyMetric = "gfet4t7"
+
+// serverTimingLatency returns the backend latency reported by the GFE in the
+// Server-Timing metadata, if any.
+func serverTimingLatency(md metadata.MD) (time.Duration, bool) {
+	for _, v := range md.Get(serverTimingKey) {
+		for _, metric := range strings.Split(v, ",") {
+			params := strings.Split(metric, ";")
+			if strings.TrimSpace(params[0]) != gfeLatencyMetric {
+				continue
+			}
+			for _, p := range params[1:] {
+				kv := strings.SplitN(p, "=", 2)
+				if len(kv) != 2 || strings.TrimSpace(kv[0]) != "dur" {
+					continue
+				}
+				ms, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+				if err != nil || ms < 0 {
+					return 0, false
+				}
+				return time.Duration(ms * float64(time.Millisecond)), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// recordServerTiming updates the backend latency moving average of the
+// channel from the Server-Timing metadata of a response, if any.
+func (ref *subConnRef) recordServerTiming(md metadata.MD) {
+	if d, ok := serverTimingLatency(md); ok {
+		ref.serverLatency.add(float64(d))
+	}
+}
@@ -0,0 +1,253 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+)
+
+// ShadowMetrics are the picks of the channel pool in shadow mode, see
+// Config.ShadowPolicy. They are reported as PoolMetrics.Shadow.
+type ShadowMetrics struct {
+	// The balancer sending the calls.
+	Policy string `json:"policy"`
+	// Number of calls picked by the balancer sending the calls.
+	Picks uint64 `json:"picks"`
+	// Number of those calls the channel pool would have failed or queued,
+	// e.g., because no channel was ready.
+	PickErrors uint64 `json:"pickErrors"`
+	// Number of the calls the channel pool would have sent over its channels
+	// by channel index. The number of keys is the number of channels the pool
+	// would have used.
+	PicksByChannel map[int]uint64 `json:"picksByChannel"`
+}
+
+// shadowMetrics counts the picks of the channel pool in shadow mode.
+type shadowMetrics struct {
+	mu      sync.Mutex
+	policy  string
+	picks   uint64
+	errors  uint64
+	channel map[int]uint64
+}
+
+func (m *shadowMetrics) record(channelID int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.picks++
+	if err != nil {
+		m.errors++
+		return
+	}
+	m.channel[channelID]++
+}
+
+func (m *shadowMetrics) snapshot() *ShadowMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := &ShadowMetrics{
+		Policy:         m.policy,
+		Picks:          m.picks,
+		PickErrors:     m.errors,
+		PicksByChannel: make(map[int]uint64, len(m.channel)),
+	}
+	for id, n := range m.channel {
+		s.PicksByChannel[id] = n
+	}
+	return s
+}
+
+// shadowBalancer sends the calls over the connections of the delegate balancer
+// while the grpc_gcp balancer picks its channel for every call.
+type shadowBalancer struct {
+	gb       *gcpBalancer
+	delegate balancer.Balancer
+	cc       balancer.ClientConn
+
+	mu sync.Mutex
+	// The SubConns of the delegate.
+	delegateSCs    map[balancer.SubConn]bool
+	gcpPicker      balancer.Picker
+	delegateState  balancer.State
+	delegateUpdate bool
+}
+
+func (bb *gcpBalancerBuilder) buildShadow(cc balancer.ClientConn, opt balancer.BuildOptions) balancer.Balancer {
+	sb := &shadowBalancer{
+		cc:          cc,
+		delegateSCs: make(map[balancer.SubConn]bool),
+	}
+	sb.gb = bb.build(gcpShadowCC{ClientConn: cc, sb: sb}, opt)
+	// The pool reports its picker once a channel changes its state.
+	sb.gcpPicker = sb.gb.picker
+	policy := bb.opts.ShadowPolicy
+	builder := balancer.Get(policy)
+	if builder == nil {
+		sb.gb.log.Errorf("shadow policy %q is not a registered balancer, sending the calls with pick_first", policy)
+		policy = "pick_first"
+		builder = balancer.Get(policy)
+	}
+	sb.gb.shadow = &shadowMetrics{policy: policy, channel: make(map[int]uint64)}
+	sb.delegate = builder.Build(delegateCC{ClientConn: cc, sb: sb}, opt)
+	return sb
+}
+
+func (sb *shadowBalancer) UpdateClientConnState(ccs balancer.ClientConnState) error {
+	if err := sb.gb.UpdateClientConnState(ccs); err != nil {
+		sb.gb.log.Warningf("channel pool in shadow mode rejected the state update: %v", err)
+	}
+	return sb.delegate.UpdateClientConnState(balancer.ClientConnState{ResolverState: ccs.ResolverState})
+}
+
+func (sb *shadowBalancer) ResolverError(err error) {
+	sb.gb.ResolverError(err)
+	sb.delegate.ResolverError(err)
+}
+
+func (sb *shadowBalancer) UpdateSubConnState(sc balancer.SubConn, s balancer.SubConnState) {
+	sb.mu.Lock()
+	isDelegate := sb.delegateSCs[sc]
+	sb.mu.Unlock()
+	if isDelegate {
+		sb.delegate.UpdateSubConnState(sc, s)
+		return
+	}
+	sb.gb.UpdateSubConnState(sc, s)
+}
+
+func (sb *shadowBalancer) ExitIdle() {
+	if ei, ok := sb.delegate.(balancer.ExitIdler); ok {
+		ei.ExitIdle()
+	}
+}
+
+func (sb *shadowBalancer) Close() {
+	sb.delegate.Close()
+	sb.gb.Close()
+}
+
+// updateState updates the ClientConn with the state of the delegate and a
+// picker picking with both balancers once the delegate reported its state.
+func (sb *shadowBalancer) updateState() {
+	sb.mu.Lock()
+	if !sb.delegateUpdate {
+		sb.mu.Unlock()
+		return
+	}
+	s := sb.delegateState
+	s.Picker = &shadowPicker{sb: sb, gcp: sb.gcpPicker, delegate: s.Picker}
+	sb.mu.Unlock()
+	sb.cc.UpdateState(s)
+}
+
+// gcpShadowCC is the ClientConn of the grpc_gcp balancer in shadow mode.
+type gcpShadowCC struct {
+	balancer.ClientConn
+	sb *shadowBalancer
+}
+
+func (cc gcpShadowCC) UpdateState(s balancer.State) {
+	cc.sb.mu.Lock()
+	cc.sb.gcpPicker = s.Picker
+	cc.sb.mu.Unlock()
+	cc.sb.updateState()
+}
+
+// delegateCC is the ClientConn of the delegate balancer in shadow mode.
+type delegateCC struct {
+	balancer.ClientConn
+	sb *shadowBalancer
+}
+
+func (cc delegateCC) NewSubConn(addrs []resolver.Address, opts balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	sc, err := cc.ClientConn.NewSubConn(addrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	cc.sb.mu.Lock()
+	cc.sb.delegateSCs[sc] = true
+	cc.sb.mu.Unlock()
+	return sc, nil
+}
+
+func (cc delegateCC) RemoveSubConn(sc balancer.SubConn) {
+	cc.ClientConn.RemoveSubConn(sc)
+	cc.sb.mu.Lock()
+	delete(cc.sb.delegateSCs, sc)
+	cc.sb.mu.Unlock()
+}
+
+func (cc delegateCC) UpdateState(s balancer.State) {
+	cc.sb.mu.Lock()
+	cc.sb.delegateState = s
+	cc.sb.delegateUpdate = true
+	cc.sb.mu.Unlock()
+	cc.sb.updateState()
+}
+
+// shadowPicker returns the picks of the delegate after picking with the
+// grpc_gcp picker. The grpc_gcp pick is finished with the result of the call,
+// so the channel pool accounts the streams and the affinity of the call.
+type shadowPicker struct {
+	sb       *shadowBalancer
+	gcp      balancer.Picker
+	delegate balancer.Picker
+}
+
+func (p *shadowPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	pr, err := p.delegate.Pick(info)
+	if err != nil {
+		// The call is queued or failed by the delegate, the pool picks once
+		// the delegate picks a connection.
+		return pr, err
+	}
+	gpr, gerr := p.gcp.Pick(info)
+	channelID := -1
+	if gerr == nil {
+		channelID = p.sb.gb.channelIDOf(gpr.SubConn)
+	}
+	p.sb.gb.shadow.record(channelID, gerr)
+	if gerr != nil || gpr.Done == nil {
+		return pr, nil
+	}
+	done := pr.Done
+	pr.Done = func(di balancer.DoneInfo) {
+		gpr.Done(di)
+		if done != nil {
+			done(di)
+		}
+	}
+	return pr, nil
+}
+
+// channelIDOf returns the index of the channel of the SubConn or -1.
+func (gb *gcpBalancer) channelIDOf(sc balancer.SubConn) int {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+	if ref, ok := gb.scRefs[sc]; ok {
+		return ref.id
+	}
+	if ref, ok := gb.refreshingScRefs[sc]; ok {
+		return ref.id
+	}
+	return -1
+}
@@ -0,0 +1,145 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// stateWatchQueueSize is the number of updates buffered for a watcher of the
+// channel states in addition to the initial updates. Updates are dropped when
+// the queue is full.
+const stateWatchQueueSize = 256
+
+// ChannelStateUpdate is a connectivity state transition of a channel of the
+// pool, see WatchChannelStates.
+type ChannelStateUpdate struct {
+	// Time of the transition.
+	Time time.Time
+	// Index of the channel in the pool.
+	ChannelIndex int
+	// The state of the channel before the transition.
+	From connectivity.State
+	// The state of the channel after the transition.
+	To connectivity.State
+	// The number of READY channels in the pool after the transition.
+	ReadyChannels int
+	// The number of channels in the pool.
+	Channels int
+	// The aggregated state of the pool after the transition.
+	PoolState connectivity.State
+}
+
+// WatchChannelStates returns a channel receiving the connectivity state
+// transitions of the channels in the pool of the ClientConn, e.g., to pause
+// the creation of Cloud Spanner sessions while the pool is degraded. The first
+// updates report the current state of every channel with From equal to To.
+// The returned channel is closed when the ctx is done or the ClientConn is
+// closed. Updates are dropped, with a warning logged, if the receiver falls
+// behind. If no call was made on the ClientConn yet, WatchChannelStates makes
+// a call that fails on pick without being sent, to find the pool, as
+// WaitForPoolReady. ErrBalancerNotFound is returned if the ClientConn does not
+// use the grpc_gcp balancer with the GCP interceptors.
+func WatchChannelStates(ctx context.Context, conn *grpc.ClientConn) (<-chan ChannelStateUpdate, error) {
+	gb, err := balancerForConn(conn)
+	if err == ErrBalancerNotFound {
+		gb, err = linkPool(ctx, conn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gb.watchStates(ctx), nil
+}
+
+func (gb *gcpBalancer) watchStates(ctx context.Context) <-chan ChannelStateUpdate {
+	gb.mu.Lock()
+	w := make(chan ChannelStateUpdate, len(gb.scRefList)+stateWatchQueueSize)
+	now := time.Now()
+	ready := gb.readyChannelsLocked()
+	for _, ref := range gb.scRefList {
+		s := gb.scStates[ref.subConn]
+		w <- ChannelStateUpdate{
+			Time:          now,
+			ChannelIndex:  ref.id,
+			From:          s,
+			To:            s,
+			ReadyChannels: ready,
+			Channels:      len(gb.scRefs),
+			PoolState:     gb.state,
+		}
+	}
+	if gb.stateWatchers == nil {
+		gb.stateWatchers = make(map[chan ChannelStateUpdate]struct{})
+	}
+	gb.stateWatchers[w] = struct{}{}
+	gb.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-gb.done:
+		}
+		gb.mu.Lock()
+		delete(gb.stateWatchers, w)
+		gb.mu.Unlock()
+		close(w)
+	}()
+	return w
+}
+
+// readyChannelsLocked returns the number of READY channels.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) readyChannelsLocked() int {
+	ready := 0
+	for sc := range gb.scRefs {
+		if gb.scStates[sc] == connectivity.Ready {
+			ready++
+		}
+	}
+	return ready
+}
+
+// notifyStateWatchersLocked sends the state transition of the channel to the
+// watchers without blocking.
+// Must be called holding the mutex lock.
+func (gb *gcpBalancer) notifyStateWatchersLocked(channelIndex int, from, to connectivity.State) {
+	if len(gb.stateWatchers) == 0 {
+		return
+	}
+	u := ChannelStateUpdate{
+		Time:          time.Now(),
+		ChannelIndex:  channelIndex,
+		From:          from,
+		To:            to,
+		ReadyChannels: gb.readyChannelsLocked(),
+		Channels:      len(gb.scRefs),
+		PoolState:     gb.state,
+	}
+	for w := range gb.stateWatchers {
+		select {
+		case w <- u:
+		default:
+			gb.log.Warningf("channel state watcher is full, dropping the %v -> %v update of channel %s", from, to, gb.channelLabelLocked(channelIndex))
+		}
+	}
+}
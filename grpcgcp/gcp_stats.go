@@ -0,0 +1,132 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Weight of a new sample in the moving averages of a channel.
+	ewmaAlpha = 0.2
+	// How much the error rate of a channel inflates its latency score.
+	// E.g., a channel with 10% of errors scores as if it was twice as slow.
+	errorRatePenalty = 10
+)
+
+// ewma is an exponentially weighted moving average safe for concurrent use.
+type ewma struct {
+	bits uint64 // math.Float64bits of the current value.
+	init uint32 // Set to 1 after the first sample.
+}
+
+// add adds a sample to the moving average.
+func (e *ewma) add(v float64) {
+	if atomic.CompareAndSwapUint32(&e.init, 0, 1) {
+		atomic.StoreUint64(&e.bits, math.Float64bits(v))
+		return
+	}
+	for {
+		old := atomic.LoadUint64(&e.bits)
+		nv := ewmaAlpha*v + (1-ewmaAlpha)*math.Float64frombits(old)
+		if atomic.CompareAndSwapUint64(&e.bits, old, math.Float64bits(nv)) {
+			return
+		}
+	}
+}
+
+// value returns the current moving average or 0 if there were no samples.
+func (e *ewma) value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&e.bits))
+}
+
+// isChannelError reports whether the error of a call may indicate a problem
+// with the channel or the backend it is connected to.
+func isChannelError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
+
+// recordCall updates the latency and error rate moving averages, the calls
+// counts by status code and the outlier detection counters of the subConnRef.
+// Latency is only recorded for successful unary calls because the duration of
+// a stream does not reflect the channel latency.
+func (ref *subConnRef) recordCall(latency time.Duration, streaming bool, err error) {
+	class := classifyCall(err)
+	ref.calls.add(class)
+	failed := isChannelError(err)
+	ref.countCall(class, failed)
+	if failed {
+		ref.errorRate.add(1)
+		return
+	}
+	ref.errorRate.add(0)
+	if err == nil && !streaming {
+		ref.latency.add(float64(latency))
+	}
+}
+
+// sampledLatency returns the recent latency of the channel or 0 if it has no
+// latency samples. The backend latency reported by the GFE stands in for the
+// latency of a channel without successful unary calls, e.g., with streaming
+// calls only.
+func (ref *subConnRef) sampledLatency() float64 {
+	if latency := ref.latency.value(); latency != 0 {
+		return latency
+	}
+	return ref.serverLatency.value()
+}
+
+// latencyScore returns the expected latency of a new call on the channel
+// based on the recent latency, active streams and error rate. The unsampled
+// latency, e.g., the mean latency of the pool, stands in for the latency of a
+// channel without latency samples, so a channel whose calls all fail is not
+// scored as the fastest one.
+func (ref *subConnRef) latencyScore(streams int32, unsampled float64) float64 {
+	latency := ref.sampledLatency()
+	if latency == 0 {
+		latency = unsampled
+	}
+	return latency * float64(streams+1) * (1 + errorRatePenalty*ref.errorRate.value())
+}
+
+// meanLatency returns the mean latency of the refs with latency samples or 1
+// if none has samples, so the error rates still tell the refs apart.
+func meanLatency(refs []*subConnRef) float64 {
+	var sum float64
+	n := 0
+	for _, ref := range refs {
+		if latency := ref.sampledLatency(); latency != 0 {
+			sum += latency
+			n++
+		}
+	}
+	if n == 0 {
+		return 1
+	}
+	return sum / float64(n)
+}
@@ -0,0 +1,150 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/stats"
+)
+
+type streamTrackerKey struct{}
+
+// streamTracker tracks the channel a call attempt is assigned to so that the
+// streams count of the channel is decremented exactly once when the attempt
+// ends.
+type streamTracker struct {
+	mu    sync.Mutex
+	ref   *subConnRef
+	mp    *methodPool
+	ended bool
+}
+
+// assign increments the streams count of ref and decrements the streams count
+// of the previously assigned channel if the attempt was re-picked.
+func (st *streamTracker) assign(ref *subConnRef, mp *methodPool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.ended {
+		return
+	}
+	if st.ref != nil {
+		st.ref.streamsDecr(st.mp)
+	}
+	st.ref = ref
+	st.mp = mp
+	ref.streamsIncr(mp)
+}
+
+// end decrements the streams count of the assigned channel if any.
+func (st *streamTracker) end() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.ended {
+		return
+	}
+	st.ended = true
+	if st.ref != nil {
+		st.ref.streamsDecr(st.mp)
+	}
+}
+
+// observeConn records the details of the connection the attempt was sent over
+// on the assigned channel.
+func (st *streamTracker) observeConn(ctx context.Context, h *stats.OutHeader) {
+	st.mu.Lock()
+	ref := st.ref
+	st.mu.Unlock()
+	if ref == nil {
+		return
+	}
+	var authInfo credentials.AuthInfo
+	if p, ok := peer.FromContext(ctx); ok {
+		authInfo = p.AuthInfo
+	}
+	ref.setConnInfo(newConnInfo(h, authInfo))
+}
+
+// observeHeader records the Server-Timing metrics of the response headers on
+// the assigned channel.
+func (st *streamTracker) observeHeader(h *stats.InHeader) {
+	st.mu.Lock()
+	ref := st.ref
+	st.mu.Unlock()
+	if ref != nil {
+		ref.recordServerTiming(h.Header)
+	}
+}
+
+func streamTrackerFromContext(ctx context.Context) *streamTracker {
+	if ctx == nil {
+		return nil
+	}
+	st, _ := ctx.Value(streamTrackerKey{}).(*streamTracker)
+	return st
+}
+
+// gcpStatsHandler accounts the streams of the channels picked by the
+// grpc_gcp balancer based on the begin and end events of call attempts.
+type gcpStatsHandler struct{}
+
+// NewGCPStatsHandler returns a stats.Handler which accounts active streams of
+// the channels in the pool from the begin and end events of every call attempt,
+// including attempts failed before receiving headers or re-picked because the
+// picked channel was not ready. Without the handler the streams are accounted
+// by the picker and its done callback. The handler also records the remote
+// and local addresses and the security details of the connection of every
+// channel for GetAffinitySnapshot and to replace only the channels connected to
+// an address removed by the resolver, and the backend latency reported by the
+// GFE in the Server-Timing response headers.
+//
+// The handler must be provided for the ClientConn using the grpc_gcp balancer:
+//
+//	conn, err := grpc.Dial(target, grpc.WithStatsHandler(grpcgcp.NewGCPStatsHandler()), ...)
+func NewGCPStatsHandler() stats.Handler {
+	return &gcpStatsHandler{}
+}
+
+func (h *gcpStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, streamTrackerKey{}, &streamTracker{})
+}
+
+func (h *gcpStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	st := streamTrackerFromContext(ctx)
+	if st == nil {
+		return
+	}
+	switch s := s.(type) {
+	case *stats.OutHeader:
+		st.observeConn(ctx, s)
+	case *stats.InHeader:
+		st.observeHeader(s)
+	case *stats.End:
+		st.end()
+	}
+}
+
+func (h *gcpStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *gcpStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
@@ -0,0 +1,235 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/mocks"
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+func TestStatsHandlerAccountsStreams(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	scRefs := []*subConnRef{
+		{
+			subConn:     mocks.NewMockSubConn(mockCtrl),
+			stateSignal: make(chan struct{}),
+		},
+		{
+			subConn:     mocks.NewMockSubConn(mockCtrl),
+			stateSignal: make(chan struct{}),
+		},
+	}
+	picker := newGCPPicker(scRefs, withConfig(&gcpBalancer{
+		log: compLogger,
+	}, &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          10,
+				MaxConcurrentStreamsLowWatermark: 100,
+			},
+		},
+	}))
+	streams := func() int32 {
+		return scRefs[0].getStreamsCnt() + scRefs[1].getStreamsCnt()
+	}
+
+	h := NewGCPStatsHandler()
+	ctx := h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "method"})
+	h.HandleRPC(ctx, &stats.Begin{Client: true})
+
+	pr, err := picker.Pick(balancer.PickInfo{FullMethodName: "method", Ctx: ctx})
+	if err != nil {
+		t.Fatalf("gcpPicker.Pick returns err: %v", err)
+	}
+	if got, want := streams(), int32(1); got != want {
+		t.Fatalf("streams count after Pick is %d, want %d", got, want)
+	}
+	// The picked channel was not ready, gRPC calls done callback and picks again.
+	pr.Done(balancer.DoneInfo{})
+	if got, want := streams(), int32(1); got != want {
+		t.Fatalf("streams count after done callback is %d, want %d", got, want)
+	}
+	pr, err = picker.Pick(balancer.PickInfo{FullMethodName: "method", Ctx: ctx})
+	if err != nil {
+		t.Fatalf("gcpPicker.Pick returns err: %v", err)
+	}
+	if got, want := streams(), int32(1); got != want {
+		t.Fatalf("streams count after second Pick is %d, want %d", got, want)
+	}
+
+	// The stream fails before headers.
+	unavailableErr := status.Error(codes.Unavailable, "unavailable")
+	pr.Done(balancer.DoneInfo{Err: unavailableErr})
+	h.HandleRPC(ctx, &stats.End{Client: true, Error: unavailableErr})
+	if got, want := streams(), int32(0); got != want {
+		t.Fatalf("streams count after End is %d, want %d", got, want)
+	}
+	// Duplicate End events are ignored.
+	h.HandleRPC(ctx, &stats.End{Client: true})
+	if got, want := streams(), int32(0); got != want {
+		t.Fatalf("streams count after second End is %d, want %d", got, want)
+	}
+}
+
+func TestStatsHandlerObservesConnection(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	sc := mocks.NewMockSubConn(mockCtrl)
+	ref := &subConnRef{
+		subConn:     sc,
+		stateSignal: make(chan struct{}),
+	}
+	gb := withConfig(&gcpBalancer{
+		scRefList: []*subConnRef{ref},
+		scStates:  map[balancer.SubConn]connectivity.State{sc: connectivity.Ready},
+		log:       compLogger,
+	}, &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          10,
+				MaxConcurrentStreamsLowWatermark: 100,
+			},
+		},
+	})
+	picker := newGCPPicker(gb.scRefList, gb)
+
+	h := NewGCPStatsHandler()
+	ctx := h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "method"})
+	h.HandleRPC(ctx, &stats.Begin{Client: true})
+	if _, err := picker.Pick(balancer.PickInfo{FullMethodName: "method", Ctx: ctx}); err != nil {
+		t.Fatalf("gcpPicker.Pick returns err: %v", err)
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}
+	local := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 50000}
+	ctx = peer.NewContext(ctx, &peer.Peer{
+		Addr: remote,
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			Version:     tls.VersionTLS13,
+			CipherSuite: tls.TLS_AES_128_GCM_SHA256,
+			ServerName:  "spanner.googleapis.com",
+		}},
+	})
+	h.HandleRPC(ctx, &stats.OutHeader{Client: true, RemoteAddr: remote, LocalAddr: local})
+
+	want := ChannelSnapshot{
+		State:          "READY",
+		Network:        "tcp",
+		RemoteAddr:     "[2001:db8::1]:443",
+		LocalAddr:      "[2001:db8::2]:50000",
+		AuthType:       "tls",
+		TLSVersion:     "TLS 1.3",
+		TLSCipherSuite: "TLS_AES_128_GCM_SHA256",
+		TLSServerName:  "spanner.googleapis.com",
+		ActiveStreams:  1,
+	}
+	if diff := cmp.Diff(want, gb.affinitySnapshot().Channels[0]); diff != "" {
+		t.Fatalf("affinitySnapshot has unexpected channel (-want, +got):\n%s", diff)
+	}
+
+	ref.resetConnInfo()
+	if got := gb.affinitySnapshot().Channels[0].RemoteAddr; got != "" {
+		t.Fatalf("remote address after the connection was replaced is %q, want empty", got)
+	}
+}
+
+type fakeHandshakeCreds struct {
+	credentials.TransportCredentials
+	delay time.Duration
+}
+
+func (c *fakeHandshakeCreds) ClientHandshake(_ context.Context, _ string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	time.Sleep(c.delay)
+	return conn, credentials.TLSInfo{State: tls.ConnectionState{Version: tls.VersionTLS13, DidResume: true}}, nil
+}
+
+type fakeAddrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *fakeAddrConn) LocalAddr() net.Addr  { return c.local }
+func (c *fakeAddrConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestStatsHandlerObservesHandshake(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	sc := mocks.NewMockSubConn(mockCtrl)
+	ref := &subConnRef{
+		subConn:     sc,
+		stateSignal: make(chan struct{}),
+	}
+	gb := withConfig(&gcpBalancer{
+		scRefList: []*subConnRef{ref},
+		scStates:  map[balancer.SubConn]connectivity.State{sc: connectivity.Ready},
+		log:       compLogger,
+	}, &GCPBalancerConfig{
+		ApiConfig: &pb.ApiConfig{
+			ChannelPool: &pb.ChannelPoolConfig{
+				MaxSize:                          10,
+				MaxConcurrentStreamsLowWatermark: 100,
+			},
+		},
+	})
+	picker := newGCPPicker(gb.scRefList, gb)
+
+	remote := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}
+	local := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 50001}
+	creds := MeasureHandshakes(&fakeHandshakeCreds{delay: 10 * time.Millisecond})
+	_, authInfo, err := creds.ClientHandshake(context.Background(), "spanner.googleapis.com", &fakeAddrConn{local: local, remote: remote})
+	if err != nil {
+		t.Fatalf("ClientHandshake returned error: %v", err)
+	}
+
+	h := NewGCPStatsHandler()
+	ctx := h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "method"})
+	h.HandleRPC(ctx, &stats.Begin{Client: true})
+	if _, err := picker.Pick(balancer.PickInfo{FullMethodName: "method", Ctx: ctx}); err != nil {
+		t.Fatalf("gcpPicker.Pick returns err: %v", err)
+	}
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: remote, AuthInfo: authInfo})
+	h.HandleRPC(ctx, &stats.OutHeader{Client: true, RemoteAddr: remote, LocalAddr: local})
+
+	cs := gb.affinitySnapshot().Channels[0]
+	if !cs.TLSResumed {
+		t.Fatalf("ChannelSnapshot.TLSResumed is false for a resumed session")
+	}
+	if cs.HandshakeMs < 10 {
+		t.Fatalf("ChannelSnapshot.HandshakeMs is %v, want at least 10", cs.HandshakeMs)
+	}
+}
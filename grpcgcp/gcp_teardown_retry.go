@@ -0,0 +1,57 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+// retryOnTeardown reports whether the failed unary call must be retried
+// because its subconn sc, the connection of the channel of the ref when the
+// call was picked, was torn down, see ChannelPoolConfig.teardown_retry_attempts.
+func (p *gcpPicker) retryOnTeardown(gcpCtx *gcpContext, ref *subConnRef, sc balancer.SubConn, info balancer.DoneInfo) bool {
+	// In shadow mode the call was not sent over the torn down SubConn.
+	if p.gb.shadow != nil {
+		return false
+	}
+	if gcpCtx.retries >= p.cfg.GetChannelPool().GetTeardownRetryAttempts() {
+		return false
+	}
+	if status.Code(info.Err) != codes.Unavailable || info.BytesReceived {
+		return false
+	}
+	if !p.gb.isTornDown(sc) {
+		return false
+	}
+	if p.log.V(FINE) {
+		p.log.Infof("retrying call failed on torn down connection of channel %s: %v", ref.label, info.Err)
+	}
+	return true
+}
+
+// isTornDown reports whether the subconn is removed or no longer ready.
+func (gb *gcpBalancer) isTornDown(sc balancer.SubConn) bool {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+	state, ok := gb.scStates[sc]
+	return !ok || state != connectivity.Ready
+}
@@ -0,0 +1,127 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+const (
+	defaultThrottlingMultiplier = 2.0
+	defaultThrottlingWindow     = 2 * time.Minute
+	throttlingBuckets           = 10
+)
+
+// ErrThrottled is the error of calls rejected on the client side by the
+// adaptive throttling of a channel, see ChannelPoolConfig.adaptive_throttling.
+// Use errors.Is to check for it.
+var ErrThrottled = status.Error(codes.Unavailable, "grpcgcp: call throttled on the client side because the backend is overloaded")
+
+type throttlingBucket struct {
+	start    time.Time
+	requests uint64
+	accepts  uint64
+}
+
+// adaptiveThrottler rejects calls on the client side with the probability
+// max(0, (requests - multiplier * accepts) / (requests + 1)) over the window.
+type adaptiveThrottler struct {
+	multiplier float64
+	bucketDur  time.Duration
+	// The random number generator, replaceable in tests.
+	rand func() float64
+
+	mu      sync.Mutex
+	buckets [throttlingBuckets]throttlingBucket
+}
+
+// newAdaptiveThrottler returns an adaptiveThrottler or nil if it is not
+// configured.
+func newAdaptiveThrottler(cfg *pb.AdaptiveThrottlingConfig) *adaptiveThrottler {
+	if cfg == nil {
+		return nil
+	}
+	at := &adaptiveThrottler{
+		multiplier: defaultThrottlingMultiplier,
+		bucketDur:  defaultThrottlingWindow / throttlingBuckets,
+		rand:       rand.Float64,
+	}
+	if cfg.GetMultiplier() > 0 {
+		at.multiplier = float64(cfg.GetMultiplier())
+	}
+	if cfg.GetWindowMs() > 0 {
+		at.bucketDur = time.Duration(cfg.GetWindowMs()) * time.Millisecond / throttlingBuckets
+	}
+	return at
+}
+
+// bucket returns the bucket of the current time, resetting it if it is
+// stale. Must be called holding the mutex lock.
+func (at *adaptiveThrottler) bucket(now time.Time) *throttlingBucket {
+	start := now.Truncate(at.bucketDur)
+	b := &at.buckets[(start.UnixNano()/int64(at.bucketDur))%throttlingBuckets]
+	if !b.start.Equal(start) {
+		*b = throttlingBucket{start: start}
+	}
+	return b
+}
+
+// counts returns the requests and accepts over the window.
+// Must be called holding the mutex lock.
+func (at *adaptiveThrottler) counts(now time.Time) (requests, accepts uint64) {
+	windowStart := now.Add(-at.bucketDur * throttlingBuckets)
+	for _, b := range at.buckets {
+		if b.start.After(windowStart) {
+			requests += b.requests
+			accepts += b.accepts
+		}
+	}
+	return requests, accepts
+}
+
+// throttle counts a new call and reports whether it must be rejected.
+func (at *adaptiveThrottler) throttle() bool {
+	now := time.Now()
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	requests, accepts := at.counts(now)
+	at.bucket(now).requests++
+	p := math.Max(0, (float64(requests)-at.multiplier*float64(accepts))/float64(requests+1))
+	return p > 0 && at.rand() < p
+}
+
+// record counts the call as accepted unless the backend rejected it as
+// overloaded.
+func (at *adaptiveThrottler) record(err error) {
+	switch status.Code(err) {
+	case codes.ResourceExhausted, codes.Unavailable:
+		return
+	}
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	at.bucket(time.Now()).accepts++
+}
@@ -0,0 +1,136 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// maxHandshakeRecords bounds the handshakes remembered until a call is sent
+// over their connection, see handshakeRecords.
+const maxHandshakeRecords = 4096
+
+// NewTLSCredentials returns TLS transport credentials from the cfg, or the
+// default TLS config if nil, resuming the TLS sessions of the previous
+// connections, e.g., when an unresponsive channel is refreshed or the
+// connection of a channel is replaced. The resumed
+// handshakes skip the certificate exchange and verification, which cuts the
+// cost of rotating the connections of large pools. A ClientSessionCache of
+// the cfg is kept, otherwise one cache is shared by the connections made
+// with the credentials. ALPN is negotiated in every handshake. The handshakes
+// are measured, see MeasureHandshakes.
+//
+//	conn, err := grpc.Dial(target,
+//		grpc.WithTransportCredentials(grpcgcp.NewTLSCredentials(nil)),
+//		grpc.WithStatsHandler(grpcgcp.NewGCPStatsHandler()),
+//		...)
+func NewTLSCredentials(cfg *tls.Config) credentials.TransportCredentials {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+	if cfg.ClientSessionCache == nil {
+		cfg.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	return MeasureHandshakes(credentials.NewTLS(cfg))
+}
+
+// MeasureHandshakes returns the transport credentials measuring the duration
+// of the client handshakes of the creds, e.g., of ALTS credentials. The
+// duration and whether a TLS session was resumed are reported for the current
+// connection of every channel in the ChannelSnapshot of GetAffinitySnapshot
+// once a call is sent over the connection. It requires the GCP stats handler,
+// see NewGCPStatsHandler.
+func MeasureHandshakes(creds credentials.TransportCredentials) credentials.TransportCredentials {
+	return &measuredCreds{TransportCredentials: creds}
+}
+
+type measuredCreds struct {
+	credentials.TransportCredentials
+}
+
+func (c *measuredCreds) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	start := time.Now()
+	conn, authInfo, err := c.TransportCredentials.ClientHandshake(ctx, authority, rawConn)
+	if err == nil {
+		handshakes.record(rawConn.LocalAddr(), rawConn.RemoteAddr(), time.Since(start))
+	}
+	return conn, authInfo, err
+}
+
+func (c *measuredCreds) Clone() credentials.TransportCredentials {
+	return &measuredCreds{TransportCredentials: c.TransportCredentials.Clone()}
+}
+
+// handshakeRecords are the durations of the recent client handshakes by the
+// addresses of their connections.
+type handshakeRecords struct {
+	mu        sync.Mutex
+	durations map[string]time.Duration
+	// Keys of the durations in the order they were recorded.
+	order []string
+}
+
+var handshakes = &handshakeRecords{durations: make(map[string]time.Duration)}
+
+func handshakeKey(local, remote net.Addr) string {
+	if local == nil || remote == nil {
+		return ""
+	}
+	return local.String() + " " + remote.String()
+}
+
+// record records the duration of the handshake of a connection and forgets
+// the oldest handshakes beyond maxHandshakeRecords.
+func (r *handshakeRecords) record(local, remote net.Addr, d time.Duration) {
+	key := handshakeKey(local, remote)
+	if key == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.durations[key]; !ok {
+		r.order = append(r.order, key)
+	}
+	r.durations[key] = d
+	for len(r.order) > maxHandshakeRecords {
+		delete(r.durations, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// duration returns the duration of the handshake of the connection, if
+// measured.
+func (r *handshakeRecords) duration(local, remote net.Addr) (time.Duration, bool) {
+	key := handshakeKey(local, remote)
+	if key == "" {
+		return 0, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d, ok := r.durations[key]
+	return d, ok
+}
@@ -0,0 +1,91 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// unboundBinding is the binding of a key unbound when an UNBIND call was sent,
+// restored if the call fails.
+type unboundBinding struct {
+	key   string
+	scRef *subConnRef
+	ttl   time.Duration
+}
+
+// unbindOnPick unbinds the keys of an UNBIND call when it is sent and returns
+// their bindings for unbindFailed.
+func (gb *gcpBalancer) unbindOnPick(keys []string, grace time.Duration) []unboundBinding {
+	var bindings []unboundBinding
+	gb.mu.RLock()
+	for _, k := range keys {
+		if sc, ok := gb.affinityMap[k]; ok {
+			bindings = append(bindings, unboundBinding{key: k, scRef: gb.scRefs[sc], ttl: gb.affinityTTL[k]})
+		}
+	}
+	gb.mu.RUnlock()
+	for _, k := range keys {
+		gb.unbindSubConn(k, grace)
+	}
+	return bindings
+}
+
+// unbindFailed handles the keys of a failed UNBIND call. The keys are unbound
+// if the call failed with one of the unbind_on_codes. Otherwise, the bindings
+// unbound when the call was sent are restored, unless the key was bound again
+// meanwhile or its channel left the pool.
+func (gb *gcpBalancer) unbindFailed(mcfg *grpc_gcp.AffinityConfig, keys []string, bindings []unboundBinding, grace time.Duration, rpcErr error) {
+	if codeIn(mcfg.GetUnbindOnCodes(), status.Code(rpcErr)) {
+		for _, k := range keys {
+			gb.unbindSubConn(k, grace)
+		}
+		return
+	}
+	for _, b := range bindings {
+		gb.mu.RLock()
+		_, bound := gb.affinityMap[b.key]
+		inPool := gb.scRefs[b.scRef.subConn] == b.scRef
+		gb.mu.RUnlock()
+		if bound || !inPool {
+			continue
+		}
+		if gb.log.V(FINE) {
+			gb.log.Infof("UNBIND call failed with %v, restoring affinity key %s on channel %s", status.Code(rpcErr), AffinityKeyHash(b.key), b.scRef.label)
+		}
+		gb.bindSubConnWithTTL(b.key, b.scRef.subConn, b.ttl)
+	}
+}
+
+// codeIn reports whether the code is one of the names of the codes, such as
+// "UNAVAILABLE".
+func codeIn(names []string, code codes.Code) bool {
+	for _, n := range names {
+		var c codes.Code
+		if err := c.UnmarshalJSON([]byte(strconv.Quote(n))); err == nil && c == code {
+			return true
+		}
+	}
+	return false
+}
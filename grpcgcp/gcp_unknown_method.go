@@ -0,0 +1,52 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+import (
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+// pickUnknownMethod picks a channel for a call of a method without a method
+// config according to the unknown_method_policy and counts the call once its
+// pick is final.
+func (p *gcpPicker) pickUnknownMethod(info balancer.PickInfo) (balancer.PickResult, error) {
+	var pr balancer.PickResult
+	var err error
+	switch p.cfg.GetChannelPool().GetUnknownMethodPolicy() {
+	case pb.ChannelPoolConfig_UNKNOWN_METHOD_REJECT:
+		if p.log.V(FINEST) {
+			p.log.Infof("rejecting call of method %s without a method config", info.FullMethodName)
+		}
+		err = status.Errorf(codes.FailedPrecondition, "grpcgcp: method %s has no method config in the ApiConfig", info.FullMethodName)
+	case pb.ChannelPoolConfig_UNKNOWN_METHOD_OVERFLOW_CHANNEL:
+		pr, err = p.pickControl(info)
+	default:
+		pr, err = p.pickPool(info)
+	}
+	if err != balancer.ErrNoSubConnAvailable {
+		atomic.AddUint64(&p.gb.counters.unknownMethodCalls, 1)
+	}
+	return pr, err
+}
@@ -0,0 +1,68 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcp
+
+// wrrMinHealth is the lowest health score of a channel, so that a channel
+// with a high error rate still gets some calls and its error rate recovers.
+const wrrMinHealth = 0.1
+
+// wrrWeight returns the weight of the channel for the weighted round-robin:
+// its remaining stream budget scaled by its health score. The budget is
+// bounded by the max concurrent streams limit of the server if known.
+func (ref *subConnRef) wrrWeight(mp *methodPool, maxStreams int32) float64 {
+	budget := maxStreams - ref.getMethodStreamsCnt(mp)
+	if limit := ref.getServerMaxStreams(); limit > 0 && limit-ref.getStreamsCnt() < budget {
+		budget = limit - ref.getStreamsCnt()
+	}
+	if budget <= 0 {
+		return 0
+	}
+	health := 1 - ref.errorRate.value()
+	if health < wrrMinHealth {
+		health = wrrMinHealth
+	}
+	return float64(budget) * health
+}
+
+// getWeightedSubConnRef returns a subConnRef with capacity, see hasCapacity,
+// in a smooth weighted round-robin manner: every pick adds the weights of the
+// channels to their current weights and picks the channel with the highest
+// current weight, which is then lowered by the sum of the weights. The picks
+// are spread proportionally to the weights and interleaved, so the channels
+// with a similar budget do not take turns receiving bursts of calls. At least
+// one such subConnRef must exist.
+func (p *gcpPicker) getWeightedSubConnRef(mp *methodPool, maxStreams int32) *subConnRef {
+	p.gb.wrrMu.Lock()
+	defer p.gb.wrrMu.Unlock()
+	var bestScRef *subConnRef
+	var total float64
+	for _, scRef := range p.scRefs {
+		if !hasCapacity(scRef, mp, maxStreams) {
+			continue
+		}
+		w := scRef.wrrWeight(mp, maxStreams)
+		scRef.wrrCurrent += w
+		total += w
+		if bestScRef == nil || scRef.wrrCurrent > bestScRef.wrrCurrent {
+			bestScRef = scRef
+		}
+	}
+	bestScRef.wrrCurrent -= total
+	return bestScRef
+}
@@ -5,6 +5,7 @@ go 1.12
 require (
 	github.com/golang/mock v1.6.0
 	github.com/google/go-cmp v0.5.9
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1
 	google.golang.org/grpc v1.56.3
 	google.golang.org/protobuf v1.30.0
 )
@@ -34,6 +34,66 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// CallPriority is the priority of a call in the picking of a channel when the
+// channels approach their stream limits. It applies to calls without an
+// affinity key.
+type CallPriority int32
+
+const (
+	// The call uses the least busy channel with capacity or waits for a new
+	// channel while the pool grows.
+	CallPriority_NORMAL CallPriority = 0
+	// The call uses the least busy channel even if all channels are busy, it
+	// never waits for the pool to grow, e.g., commits.
+	CallPriority_HIGH CallPriority = 1
+	// The call leaves the streams reserved by
+	// ChannelPoolConfig.low_priority_reserved_streams to other calls. Once all
+	// channels are busy for the call and the pool is at its max size, the call
+	// waits for a call to finish instead of using a busy channel.
+	CallPriority_LOW CallPriority = 2
+)
+
+// Enum value maps for CallPriority.
+var (
+	CallPriority_name = map[int32]string{
+		0: "NORMAL",
+		1: "HIGH",
+		2: "LOW",
+	}
+	CallPriority_value = map[string]int32{
+		"NORMAL": 0,
+		"HIGH":   1,
+		"LOW":    2,
+	}
+)
+
+func (x CallPriority) Enum() *CallPriority {
+	p := new(CallPriority)
+	*p = x
+	return p
+}
+
+func (x CallPriority) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (CallPriority) Descriptor() protoreflect.EnumDescriptor {
+	return file_grpc_gcp_proto_enumTypes[0].Descriptor()
+}
+
+func (CallPriority) Type() protoreflect.EnumType {
+	return &file_grpc_gcp_proto_enumTypes[0]
+}
+
+func (x CallPriority) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use CallPriority.Descriptor instead.
+func (CallPriority) EnumDescriptor() ([]byte, []int) {
+	return file_grpc_gcp_proto_rawDescGZIP(), []int{0}
+}
+
 // A selection of strategies for picking a channel for a call with BIND command.
 type ChannelPoolConfig_BindPickStrategy int32
 
@@ -75,11 +135,11 @@ func (x ChannelPoolConfig_BindPickStrategy) String() string {
 }
 
 func (ChannelPoolConfig_BindPickStrategy) Descriptor() protoreflect.EnumDescriptor {
-	return file_grpc_gcp_proto_enumTypes[0].Descriptor()
+	return file_grpc_gcp_proto_enumTypes[1].Descriptor()
 }
 
 func (ChannelPoolConfig_BindPickStrategy) Type() protoreflect.EnumType {
-	return &file_grpc_gcp_proto_enumTypes[0]
+	return &file_grpc_gcp_proto_enumTypes[1]
 }
 
 func (x ChannelPoolConfig_BindPickStrategy) Number() protoreflect.EnumNumber {
@@ -91,6 +151,151 @@ func (ChannelPoolConfig_BindPickStrategy) EnumDescriptor() ([]byte, []int) {
 	return file_grpc_gcp_proto_rawDescGZIP(), []int{1, 0}
 }
 
+// A selection of strategies for picking a channel for a call which is not
+// bound to a channel by an affinity key.
+type ChannelPoolConfig_PickStrategy int32
+
+const (
+	// Default -- same as PICK_LEAST_ACTIVE_STREAMS.
+	ChannelPoolConfig_PICK_STRATEGY_UNSPECIFIED ChannelPoolConfig_PickStrategy = 0
+	// A channel with the least active streams will be picked.
+	ChannelPoolConfig_PICK_LEAST_ACTIVE_STREAMS ChannelPoolConfig_PickStrategy = 1
+	// A channel with the best recent latency will be picked. Every channel
+	// keeps track of the moving average of its calls latency and error rate.
+	// The picker prefers a channel with the lowest average latency multiplied
+	// by the number of active streams (plus one) and penalized by the error
+	// rate. Only channels with less than max_concurrent_streams_low_watermark
+	// active streams are considered. A channel without successful unary calls
+	// uses the backend latency reported by the Google Front End in the
+	// Server-Timing response metadata instead.
+	ChannelPoolConfig_PICK_LOWEST_LATENCY ChannelPoolConfig_PickStrategy = 2
+	// A channel with the least utilized backend will be picked. Every channel
+	// keeps track of the moving average of the backend utilization reported
+	// by the server in ORCA per-call load reports (the
+	// endpoint-load-metrics-bin trailer). The application utilization is used
+	// if reported, the CPU utilization otherwise. The picker prefers a channel
+	// with the lowest average utilization multiplied by the number of active
+	// streams (plus one). Channels without reports are preferred so that they
+	// get reports. Only channels with less than
+	// max_concurrent_streams_low_watermark active streams are considered.
+	ChannelPoolConfig_PICK_LEAST_UTILIZATION ChannelPoolConfig_PickStrategy = 3
+	// Channels are picked in a smooth weighted round-robin manner. The weight
+	// of a channel is its remaining stream budget, i.e.,
+	// max_concurrent_streams_low_watermark (or the limit advertised by the
+	// server if lower) minus its active streams, scaled by its health score,
+	// i.e., one minus the moving average of its calls error rate. Unlike
+	// PICK_LEAST_ACTIVE_STREAMS, it spreads the calls proportionally across
+	// channels of unequal capacity, e.g., after outlier ejections or while
+	// channels are draining, instead of sending every call to the one least
+	// busy channel until another becomes less busy. Only channels with less
+	// than max_concurrent_streams_low_watermark active streams are considered.
+	ChannelPoolConfig_PICK_WEIGHTED_ROUND_ROBIN ChannelPoolConfig_PickStrategy = 4
+)
+
+// Enum value maps for ChannelPoolConfig_PickStrategy.
+var (
+	ChannelPoolConfig_PickStrategy_name = map[int32]string{
+		0: "PICK_STRATEGY_UNSPECIFIED",
+		1: "PICK_LEAST_ACTIVE_STREAMS",
+		2: "PICK_LOWEST_LATENCY",
+		3: "PICK_LEAST_UTILIZATION",
+		4: "PICK_WEIGHTED_ROUND_ROBIN",
+	}
+	ChannelPoolConfig_PickStrategy_value = map[string]int32{
+		"PICK_STRATEGY_UNSPECIFIED": 0,
+		"PICK_LEAST_ACTIVE_STREAMS": 1,
+		"PICK_LOWEST_LATENCY":       2,
+		"PICK_LEAST_UTILIZATION":    3,
+		"PICK_WEIGHTED_ROUND_ROBIN": 4,
+	}
+)
+
+func (x ChannelPoolConfig_PickStrategy) Enum() *ChannelPoolConfig_PickStrategy {
+	p := new(ChannelPoolConfig_PickStrategy)
+	*p = x
+	return p
+}
+
+func (x ChannelPoolConfig_PickStrategy) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ChannelPoolConfig_PickStrategy) Descriptor() protoreflect.EnumDescriptor {
+	return file_grpc_gcp_proto_enumTypes[2].Descriptor()
+}
+
+func (ChannelPoolConfig_PickStrategy) Type() protoreflect.EnumType {
+	return &file_grpc_gcp_proto_enumTypes[2]
+}
+
+func (x ChannelPoolConfig_PickStrategy) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ChannelPoolConfig_PickStrategy.Descriptor instead.
+func (ChannelPoolConfig_PickStrategy) EnumDescriptor() ([]byte, []int) {
+	return file_grpc_gcp_proto_rawDescGZIP(), []int{1, 1}
+}
+
+// How calls of the methods without a method config, neither by name nor by
+// a wildcard pattern, are handled. They are counted as UnknownMethodCalls
+// in the PoolMetrics with every policy.
+type ChannelPoolConfig_UnknownMethodPolicy int32
+
+const (
+	// The calls pick a channel of the pool like calls without affinity.
+	ChannelPoolConfig_UNKNOWN_METHOD_DEFAULT ChannelPoolConfig_UnknownMethodPolicy = 0
+	// The calls fail with FAILED_PRECONDITION without being sent, e.g., in
+	// tests and staging environments to catch methods missing from the
+	// method configs after the client library added them.
+	ChannelPoolConfig_UNKNOWN_METHOD_REJECT ChannelPoolConfig_UnknownMethodPolicy = 1
+	// The calls are sent over the control channel, see
+	// MethodChannelPoolConfig.control_channel, so the calls the method
+	// configs do not account for cannot load the channels of the pool.
+	ChannelPoolConfig_UNKNOWN_METHOD_OVERFLOW_CHANNEL ChannelPoolConfig_UnknownMethodPolicy = 2
+)
+
+// Enum value maps for ChannelPoolConfig_UnknownMethodPolicy.
+var (
+	ChannelPoolConfig_UnknownMethodPolicy_name = map[int32]string{
+		0: "UNKNOWN_METHOD_DEFAULT",
+		1: "UNKNOWN_METHOD_REJECT",
+		2: "UNKNOWN_METHOD_OVERFLOW_CHANNEL",
+	}
+	ChannelPoolConfig_UnknownMethodPolicy_value = map[string]int32{
+		"UNKNOWN_METHOD_DEFAULT":          0,
+		"UNKNOWN_METHOD_REJECT":           1,
+		"UNKNOWN_METHOD_OVERFLOW_CHANNEL": 2,
+	}
+)
+
+func (x ChannelPoolConfig_UnknownMethodPolicy) Enum() *ChannelPoolConfig_UnknownMethodPolicy {
+	p := new(ChannelPoolConfig_UnknownMethodPolicy)
+	*p = x
+	return p
+}
+
+func (x ChannelPoolConfig_UnknownMethodPolicy) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ChannelPoolConfig_UnknownMethodPolicy) Descriptor() protoreflect.EnumDescriptor {
+	return file_grpc_gcp_proto_enumTypes[3].Descriptor()
+}
+
+func (ChannelPoolConfig_UnknownMethodPolicy) Type() protoreflect.EnumType {
+	return &file_grpc_gcp_proto_enumTypes[3]
+}
+
+func (x ChannelPoolConfig_UnknownMethodPolicy) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ChannelPoolConfig_UnknownMethodPolicy.Descriptor instead.
+func (ChannelPoolConfig_UnknownMethodPolicy) EnumDescriptor() ([]byte, []int) {
+	return file_grpc_gcp_proto_rawDescGZIP(), []int{1, 2}
+}
+
 type AffinityConfig_Command int32
 
 const (
@@ -106,8 +311,15 @@ const (
 	// The annotated method will remove the channel affinity with the
 	// channel which is used to execute the RPC. The corresponding
 	// <affinity_key_field_path> will be used to find the affinity key from the
-	// request message.
+	// request message. If the path leads to a repeated field, e.g., the
+	// session names of a batch delete, every key of the field is unbound.
 	AffinityConfig_UNBIND AffinityConfig_Command = 2
+	// The annotated method will be bound to the channel which is used to
+	// execute the RPC, like BOUND, but the affinity key from the request
+	// message is bound to the picked channel when it is not bound yet, before
+	// the RPC starts. No response field is needed, e.g., for APIs where the
+	// session is implicit in the resource path of the requests.
+	AffinityConfig_BIND_ON_FIRST_USE AffinityConfig_Command = 3
 )
 
 // Enum value maps for AffinityConfig_Command.
@@ -116,11 +328,13 @@ var (
 		0: "BOUND",
 		1: "BIND",
 		2: "UNBIND",
+		3: "BIND_ON_FIRST_USE",
 	}
 	AffinityConfig_Command_value = map[string]int32{
-		"BOUND":  0,
-		"BIND":   1,
-		"UNBIND": 2,
+		"BOUND":             0,
+		"BIND":              1,
+		"UNBIND":            2,
+		"BIND_ON_FIRST_USE": 3,
 	}
 )
 
@@ -135,11 +349,11 @@ func (x AffinityConfig_Command) String() string {
 }
 
 func (AffinityConfig_Command) Descriptor() protoreflect.EnumDescriptor {
-	return file_grpc_gcp_proto_enumTypes[1].Descriptor()
+	return file_grpc_gcp_proto_enumTypes[4].Descriptor()
 }
 
 func (AffinityConfig_Command) Type() protoreflect.EnumType {
-	return &file_grpc_gcp_proto_enumTypes[1]
+	return &file_grpc_gcp_proto_enumTypes[4]
 }
 
 func (x AffinityConfig_Command) Number() protoreflect.EnumNumber {
@@ -148,7 +362,59 @@ func (x AffinityConfig_Command) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use AffinityConfig_Command.Descriptor instead.
 func (AffinityConfig_Command) EnumDescriptor() ([]byte, []int) {
-	return file_grpc_gcp_proto_rawDescGZIP(), []int{3, 0}
+	return file_grpc_gcp_proto_rawDescGZIP(), []int{11, 0}
+}
+
+type AffinityConfig_UnbindPolicy int32
+
+const (
+	// The keys are unbound once the UNBIND call succeeds. The keys of a failed
+	// call stay bound.
+	AffinityConfig_UNBIND_ON_SUCCESS AffinityConfig_UnbindPolicy = 0
+	// The keys are unbound when the UNBIND call is sent, so the channel no
+	// longer accounts for them while the call is in flight. The keys of a
+	// failed call are bound to their channel again, unless bound by another
+	// call meanwhile.
+	AffinityConfig_UNBIND_ON_PICK AffinityConfig_UnbindPolicy = 1
+)
+
+// Enum value maps for AffinityConfig_UnbindPolicy.
+var (
+	AffinityConfig_UnbindPolicy_name = map[int32]string{
+		0: "UNBIND_ON_SUCCESS",
+		1: "UNBIND_ON_PICK",
+	}
+	AffinityConfig_UnbindPolicy_value = map[string]int32{
+		"UNBIND_ON_SUCCESS": 0,
+		"UNBIND_ON_PICK":    1,
+	}
+)
+
+func (x AffinityConfig_UnbindPolicy) Enum() *AffinityConfig_UnbindPolicy {
+	p := new(AffinityConfig_UnbindPolicy)
+	*p = x
+	return p
+}
+
+func (x AffinityConfig_UnbindPolicy) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (AffinityConfig_UnbindPolicy) Descriptor() protoreflect.EnumDescriptor {
+	return file_grpc_gcp_proto_enumTypes[5].Descriptor()
+}
+
+func (AffinityConfig_UnbindPolicy) Type() protoreflect.EnumType {
+	return &file_grpc_gcp_proto_enumTypes[5]
+}
+
+func (x AffinityConfig_UnbindPolicy) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use AffinityConfig_UnbindPolicy.Descriptor instead.
+func (AffinityConfig_UnbindPolicy) EnumDescriptor() ([]byte, []int) {
+	return file_grpc_gcp_proto_rawDescGZIP(), []int{11, 1}
 }
 
 type ApiConfig struct {
@@ -255,6 +521,104 @@ type ChannelPoolConfig struct {
 	UnresponsiveCalls uint32 `protobuf:"varint,7,opt,name=unresponsive_calls,json=unresponsiveCalls,proto3" json:"unresponsive_calls,omitempty"`
 	// The strategy for picking a channel for a call with BIND command.
 	BindPickStrategy ChannelPoolConfig_BindPickStrategy `protobuf:"varint,8,opt,name=bind_pick_strategy,json=bindPickStrategy,proto3,enum=grpc.gcp.ChannelPoolConfig_BindPickStrategy" json:"bind_pick_strategy,omitempty"`
+	// The strategy for picking a channel for a call which is not bound to a
+	// channel by an affinity key.
+	PickStrategy ChannelPoolConfig_PickStrategy `protobuf:"varint,9,opt,name=pick_strategy,json=pickStrategy,proto3,enum=grpc.gcp.ChannelPoolConfig_PickStrategy" json:"pick_strategy,omitempty"`
+	// The outlier detection configuration. Outlier detection is disabled if not
+	// set or interval_ms is 0.
+	OutlierDetection *OutlierDetectionConfig `protobuf:"bytes,10,opt,name=outlier_detection,json=outlierDetection,proto3" json:"outlier_detection,omitempty"`
+	// The reconnect backoff configuration. If not set or base_delay_ms is 0, a
+	// channel reconnects immediately when it becomes idle.
+	ReconnectBackoff *ReconnectBackoffConfig `protobuf:"bytes,11,opt,name=reconnect_backoff,json=reconnectBackoff,proto3" json:"reconnect_backoff,omitempty"`
+	// If set, every call carries a metadata header with this name and the index
+	// of the channel in the pool the call was sent over, e.g.,
+	// "x-grpcgcp-channel-id". This allows correlating server-side logs and
+	// client traces per pooled connection.
+	ChannelIdHeader string `protobuf:"bytes,12,opt,name=channel_id_header,json=channelIdHeader,proto3" json:"channel_id_header,omitempty"`
+	// The circuit breaker configuration. If not set, the circuit breaker is
+	// disabled.
+	CircuitBreaker *CircuitBreakerConfig `protobuf:"bytes,13,opt,name=circuit_breaker,json=circuitBreaker,proto3" json:"circuit_breaker,omitempty"`
+	// The max number of times a unary call is retried on another channel when it
+	// failed with UNAVAILABLE status because its channel was being torn down,
+	// e.g., on GOAWAY from the server or during connection replacement. A call
+	// is retried only if no response was received and its channel is no longer
+	// READY when the call fails. Note that the server may have started
+	// processing the call, so enable it only for idempotent methods or APIs
+	// tolerating duplicate requests. Default value is 0, meaning no retries.
+	TeardownRetryAttempts uint32 `protobuf:"varint,14,opt,name=teardown_retry_attempts,json=teardownRetryAttempts,proto3" json:"teardown_retry_attempts,omitempty"`
+	// If true, the attempts of a call without an affinity key, i.e., gRPC
+	// retries, hedged attempts and teardown retries, are sent over channels not
+	// used by previous attempts of the call while such ready channels exist.
+	// This makes retries and hedging effective against a slow or failing
+	// connection. Requires the GCP interceptors.
+	SpreadCallAttempts bool `protobuf:"varint,15,opt,name=spread_call_attempts,json=spreadCallAttempts,proto3" json:"spread_call_attempts,omitempty"`
+	// The adaptive throttling configuration. If not set, calls are not throttled
+	// on the client side.
+	AdaptiveThrottling *AdaptiveThrottlingConfig `protobuf:"bytes,16,opt,name=adaptive_throttling,json=adaptiveThrottling,proto3" json:"adaptive_throttling,omitempty"`
+	// If set, the affinity bindings are aggregated by the prefix of the affinity
+	// keys before the last occurrence of this delimiter in the key, e.g.,
+	// "/sessions/" groups Cloud Spanner sessions by their database. Keys without
+	// the delimiter are aggregated under the empty prefix. The aggregates are
+	// reported in the AffinitySnapshot and the PoolMetrics to detect skewed
+	// distribution of the prefixes across the channels.
+	AffinityKeyPrefixDelimiter string `protobuf:"bytes,17,opt,name=affinity_key_prefix_delimiter,json=affinityKeyPrefixDelimiter,proto3" json:"affinity_key_prefix_delimiter,omitempty"`
+	// The keepalive configuration of the channels. Applied by the DialOptions
+	// from WithDefaults. If not set, the keepalive of the DialOptions is used.
+	Keepalive *KeepaliveConfig `protobuf:"bytes,18,opt,name=keepalive,proto3" json:"keepalive,omitempty"`
+	// The max time in milliseconds a call without a deadline waits for a
+	// channel, e.g., while all channels are busy and the pool is growing or no
+	// channel is ready. Such calls fail with PoolExhaustedError, including the
+	// pool metrics, after the wait instead of waiting indefinitely. Calls with a
+	// deadline are not affected. Requires the GCP interceptors. Default value is
+	// 0, meaning no limit.
+	MaxPickWaitMs uint32 `protobuf:"varint,19,opt,name=max_pick_wait_ms,json=maxPickWaitMs,proto3" json:"max_pick_wait_ms,omitempty"`
+	// The number of streams below the max_concurrent_streams_low_watermark (or
+	// the watermark of the method pool) of a channel reserved for calls which
+	// are not of the LOW priority, see CallPriority. LOW priority calls consider
+	// a channel busy once it has the watermark minus this number of streams.
+	// Default value is 0, meaning no streams are reserved.
+	LowPriorityReservedStreams uint32 `protobuf:"varint,20,opt,name=low_priority_reserved_streams,json=lowPriorityReservedStreams,proto3" json:"low_priority_reserved_streams,omitempty"`
+	// The affinity rebalancing configuration. If not set or interval_ms is 0,
+	// affinity keys stay bound to their channels until unbound.
+	AffinityRebalancing *AffinityRebalancingConfig `protobuf:"bytes,21,opt,name=affinity_rebalancing,json=affinityRebalancing,proto3" json:"affinity_rebalancing,omitempty"`
+	// Disables the client-side health checking of the channels even if the
+	// service config enables it, e.g., for DirectPath backends or sidecars
+	// listening on a unix socket which do not serve the grpc.health.v1 service.
+	// Client-side health checking is only active with a healthCheckConfig in the
+	// service config, e.g., provided by xDS.
+	DisableHealthCheck bool `protobuf:"varint,22,opt,name=disable_health_check,json=disableHealthCheck,proto3" json:"disable_health_check,omitempty"`
+	// The number of the last pick decisions recorded to debug why a call was
+	// sent over a channel, see GetPickDecisions. Default value is 0, meaning
+	// pick decisions are not recorded.
+	PickAuditSize uint32 `protobuf:"varint,23,opt,name=pick_audit_size,json=pickAuditSize,proto3" json:"pick_audit_size,omitempty"`
+	// The max number of affinity keys bound to a channel, e.g., 100 Cloud
+	// Spanner sessions per channel. BIND calls prefer the channels below the
+	// cap even if a channel at the cap is the least busy, and the pool grows if
+	// every channel is at the cap. The cap is soft: concurrent BIND calls may
+	// exceed it, and keys are still bound to a channel at the cap if the pool
+	// cannot grow. Default value is 0, meaning no cap.
+	MaxAffinityKeysPerChannel uint32 `protobuf:"varint,24,opt,name=max_affinity_keys_per_channel,json=maxAffinityKeysPerChannel,proto3" json:"max_affinity_keys_per_channel,omitempty"`
+	// The dial targets of the channels overriding the resolved addresses, e.g.,
+	// to spread the channels across frontends with DNS-based load distribution
+	// or alternate VIP hostnames. The channel with index i dials the target
+	// i % len(channel_targets), with "{index}" replaced by i, e.g.,
+	// "spanner-{index}.example.com". Every channel resolves the hostname of its
+	// target itself when connecting, and the targets without a port use the
+	// port of the resolved addresses, or 443. The authority of the ClientConn is
+	// kept for the calls and the TLS handshakes. The control channel uses the
+	// resolved addresses. Default value is empty, meaning every channel uses the
+	// resolved addresses.
+	ChannelTargets []string `protobuf:"bytes,25,rep,name=channel_targets,json=channelTargets,proto3" json:"channel_targets,omitempty"`
+	// The max time in milliseconds a new connection of a channel, of a new
+	// channel or replacing the connection of a channel, may take to become
+	// READY, e.g., 10000. A connection not READY in time, e.g., because its SYN
+	// is black-holed, is abandoned and replaced with a new connection, which may
+	// connect to another resolved address, so that it does not reduce the
+	// effective pool size for good. The abandoned connections are counted as
+	// ConnectTimeouts in the PoolMetrics. Default value is 0, meaning no
+	// timeout.
+	ConnectTimeoutMs    uint32                                `protobuf:"varint,26,opt,name=connect_timeout_ms,json=connectTimeoutMs,proto3" json:"connect_timeout_ms,omitempty"`
+	UnknownMethodPolicy ChannelPoolConfig_UnknownMethodPolicy `protobuf:"varint,27,opt,name=unknown_method_policy,json=unknownMethodPolicy,proto3,enum=grpc.gcp.ChannelPoolConfig_UnknownMethodPolicy" json:"unknown_method_policy,omitempty"`
 }
 
 func (x *ChannelPoolConfig) Reset() {
@@ -345,21 +709,160 @@ func (x *ChannelPoolConfig) GetBindPickStrategy() ChannelPoolConfig_BindPickStra
 	return ChannelPoolConfig_UNSPECIFIED
 }
 
-type MethodConfig struct {
+func (x *ChannelPoolConfig) GetPickStrategy() ChannelPoolConfig_PickStrategy {
+	if x != nil {
+		return x.PickStrategy
+	}
+	return ChannelPoolConfig_PICK_STRATEGY_UNSPECIFIED
+}
+
+func (x *ChannelPoolConfig) GetOutlierDetection() *OutlierDetectionConfig {
+	if x != nil {
+		return x.OutlierDetection
+	}
+	return nil
+}
+
+func (x *ChannelPoolConfig) GetReconnectBackoff() *ReconnectBackoffConfig {
+	if x != nil {
+		return x.ReconnectBackoff
+	}
+	return nil
+}
+
+func (x *ChannelPoolConfig) GetChannelIdHeader() string {
+	if x != nil {
+		return x.ChannelIdHeader
+	}
+	return ""
+}
+
+func (x *ChannelPoolConfig) GetCircuitBreaker() *CircuitBreakerConfig {
+	if x != nil {
+		return x.CircuitBreaker
+	}
+	return nil
+}
+
+func (x *ChannelPoolConfig) GetTeardownRetryAttempts() uint32 {
+	if x != nil {
+		return x.TeardownRetryAttempts
+	}
+	return 0
+}
+
+func (x *ChannelPoolConfig) GetSpreadCallAttempts() bool {
+	if x != nil {
+		return x.SpreadCallAttempts
+	}
+	return false
+}
+
+func (x *ChannelPoolConfig) GetAdaptiveThrottling() *AdaptiveThrottlingConfig {
+	if x != nil {
+		return x.AdaptiveThrottling
+	}
+	return nil
+}
+
+func (x *ChannelPoolConfig) GetAffinityKeyPrefixDelimiter() string {
+	if x != nil {
+		return x.AffinityKeyPrefixDelimiter
+	}
+	return ""
+}
+
+func (x *ChannelPoolConfig) GetKeepalive() *KeepaliveConfig {
+	if x != nil {
+		return x.Keepalive
+	}
+	return nil
+}
+
+func (x *ChannelPoolConfig) GetMaxPickWaitMs() uint32 {
+	if x != nil {
+		return x.MaxPickWaitMs
+	}
+	return 0
+}
+
+func (x *ChannelPoolConfig) GetLowPriorityReservedStreams() uint32 {
+	if x != nil {
+		return x.LowPriorityReservedStreams
+	}
+	return 0
+}
+
+func (x *ChannelPoolConfig) GetAffinityRebalancing() *AffinityRebalancingConfig {
+	if x != nil {
+		return x.AffinityRebalancing
+	}
+	return nil
+}
+
+func (x *ChannelPoolConfig) GetDisableHealthCheck() bool {
+	if x != nil {
+		return x.DisableHealthCheck
+	}
+	return false
+}
+
+func (x *ChannelPoolConfig) GetPickAuditSize() uint32 {
+	if x != nil {
+		return x.PickAuditSize
+	}
+	return 0
+}
+
+func (x *ChannelPoolConfig) GetMaxAffinityKeysPerChannel() uint32 {
+	if x != nil {
+		return x.MaxAffinityKeysPerChannel
+	}
+	return 0
+}
+
+func (x *ChannelPoolConfig) GetChannelTargets() []string {
+	if x != nil {
+		return x.ChannelTargets
+	}
+	return nil
+}
+
+func (x *ChannelPoolConfig) GetConnectTimeoutMs() uint32 {
+	if x != nil {
+		return x.ConnectTimeoutMs
+	}
+	return 0
+}
+
+func (x *ChannelPoolConfig) GetUnknownMethodPolicy() ChannelPoolConfig_UnknownMethodPolicy {
+	if x != nil {
+		return x.UnknownMethodPolicy
+	}
+	return ChannelPoolConfig_UNKNOWN_METHOD_DEFAULT
+}
+
+// AffinityRebalancingConfig are options for evening out the number of affinity
+// keys bound to the channels over long uptimes, when the keys concentrate on
+// older channels. Every interval_ms, up to max_moves keys without active calls
+// are moved from the channel with the most keys to the ready channel with the
+// least keys while the difference of their key counts exceeds tolerance.
+type AffinityRebalancingConfig struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	// A fully qualified name of a gRPC method, or a wildcard pattern ending
-	// with .*, such as foo.bar.A, foo.bar.*. Method configs are evaluated
-	// sequentially, and the first one takes precedence.
-	Name []string `protobuf:"bytes,1,rep,name=name,proto3" json:"name,omitempty"`
-	// The channel affinity configurations.
-	Affinity *AffinityConfig `protobuf:"bytes,1001,opt,name=affinity,proto3" json:"affinity,omitempty"`
+	// The interval of rebalancing in milliseconds.
+	IntervalMs uint32 `protobuf:"varint,1,opt,name=interval_ms,json=intervalMs,proto3" json:"interval_ms,omitempty"`
+	// The max number of keys moved per interval. Default value is 0, meaning 1.
+	MaxMoves uint32 `protobuf:"varint,2,opt,name=max_moves,json=maxMoves,proto3" json:"max_moves,omitempty"`
+	// The tolerated difference of the key counts of the channels with the most
+	// and the least keys. Default value is 0, meaning 1.
+	Tolerance uint32 `protobuf:"varint,3,opt,name=tolerance,proto3" json:"tolerance,omitempty"`
 }
 
-func (x *MethodConfig) Reset() {
-	*x = MethodConfig{}
+func (x *AffinityRebalancingConfig) Reset() {
+	*x = AffinityRebalancingConfig{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_grpc_gcp_proto_msgTypes[2]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -367,13 +870,13 @@ func (x *MethodConfig) Reset() {
 	}
 }
 
-func (x *MethodConfig) String() string {
+func (x *AffinityRebalancingConfig) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*MethodConfig) ProtoMessage() {}
+func (*AffinityRebalancingConfig) ProtoMessage() {}
 
-func (x *MethodConfig) ProtoReflect() protoreflect.Message {
+func (x *AffinityRebalancingConfig) ProtoReflect() protoreflect.Message {
 	mi := &file_grpc_gcp_proto_msgTypes[2]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -385,39 +888,55 @@ func (x *MethodConfig) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use MethodConfig.ProtoReflect.Descriptor instead.
-func (*MethodConfig) Descriptor() ([]byte, []int) {
+// Deprecated: Use AffinityRebalancingConfig.ProtoReflect.Descriptor instead.
+func (*AffinityRebalancingConfig) Descriptor() ([]byte, []int) {
 	return file_grpc_gcp_proto_rawDescGZIP(), []int{2}
 }
 
-func (x *MethodConfig) GetName() []string {
+func (x *AffinityRebalancingConfig) GetIntervalMs() uint32 {
 	if x != nil {
-		return x.Name
+		return x.IntervalMs
 	}
-	return nil
+	return 0
 }
 
-func (x *MethodConfig) GetAffinity() *AffinityConfig {
+func (x *AffinityRebalancingConfig) GetMaxMoves() uint32 {
 	if x != nil {
-		return x.Affinity
+		return x.MaxMoves
 	}
-	return nil
+	return 0
 }
 
-type AffinityConfig struct {
+func (x *AffinityRebalancingConfig) GetTolerance() uint32 {
+	if x != nil {
+		return x.Tolerance
+	}
+	return 0
+}
+
+// KeepaliveConfig are the client keepalive parameters of the channels. Servers,
+// e.g., Google Front Ends, close connections pinged more often than they permit
+// with a GOAWAY with the ENHANCE_YOUR_CALM code and "too_many_pings" debug
+// data. Such closures and keepalive timeouts are counted separately in the
+// PoolMetrics.
+type KeepaliveConfig struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	// The affinity command applies on the selected gRPC methods.
-	Command AffinityConfig_Command `protobuf:"varint,2,opt,name=command,proto3,enum=grpc.gcp.AffinityConfig_Command" json:"command,omitempty"`
-	// The field path of the affinity key in the request/response message.
-	// For example: "f.a", "f.b.d", etc.
-	AffinityKey string `protobuf:"bytes,3,opt,name=affinity_key,json=affinityKey,proto3" json:"affinity_key,omitempty"`
+	// The time without activity after which a channel pings the server. Must be
+	// at least 10000 (10 seconds), the minimum of gRPC clients.
+	TimeMs uint32 `protobuf:"varint,1,opt,name=time_ms,json=timeMs,proto3" json:"time_ms,omitempty"`
+	// The time to wait for the ping acknowledgment before the connection is
+	// closed. Requires time_ms. Default value is 20000 (20 seconds).
+	TimeoutMs uint32 `protobuf:"varint,2,opt,name=timeout_ms,json=timeoutMs,proto3" json:"timeout_ms,omitempty"`
+	// If true, channels ping the server even without active calls. Servers
+	// usually permit less frequent pings without active calls.
+	PermitWithoutStream bool `protobuf:"varint,3,opt,name=permit_without_stream,json=permitWithoutStream,proto3" json:"permit_without_stream,omitempty"`
 }
 
-func (x *AffinityConfig) Reset() {
-	*x = AffinityConfig{}
+func (x *KeepaliveConfig) Reset() {
+	*x = KeepaliveConfig{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_grpc_gcp_proto_msgTypes[3]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -425,13 +944,13 @@ func (x *AffinityConfig) Reset() {
 	}
 }
 
-func (x *AffinityConfig) String() string {
+func (x *KeepaliveConfig) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*AffinityConfig) ProtoMessage() {}
+func (*KeepaliveConfig) ProtoMessage() {}
 
-func (x *AffinityConfig) ProtoReflect() protoreflect.Message {
+func (x *KeepaliveConfig) ProtoReflect() protoreflect.Message {
 	mi := &file_grpc_gcp_proto_msgTypes[3]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -443,38 +962,827 @@ func (x *AffinityConfig) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use AffinityConfig.ProtoReflect.Descriptor instead.
-func (*AffinityConfig) Descriptor() ([]byte, []int) {
+// Deprecated: Use KeepaliveConfig.ProtoReflect.Descriptor instead.
+func (*KeepaliveConfig) Descriptor() ([]byte, []int) {
 	return file_grpc_gcp_proto_rawDescGZIP(), []int{3}
 }
 
-func (x *AffinityConfig) GetCommand() AffinityConfig_Command {
+func (x *KeepaliveConfig) GetTimeMs() uint32 {
 	if x != nil {
-		return x.Command
+		return x.TimeMs
 	}
-	return AffinityConfig_BOUND
+	return 0
 }
 
-func (x *AffinityConfig) GetAffinityKey() string {
+func (x *KeepaliveConfig) GetTimeoutMs() uint32 {
 	if x != nil {
-		return x.AffinityKey
+		return x.TimeoutMs
 	}
-	return ""
+	return 0
 }
 
-var File_grpc_gcp_proto protoreflect.FileDescriptor
+func (x *KeepaliveConfig) GetPermitWithoutStream() bool {
+	if x != nil {
+		return x.PermitWithoutStream
+	}
+	return false
+}
 
-var file_grpc_gcp_proto_rawDesc = []byte{
-	0x0a, 0x0e, 0x67, 0x72, 0x70, 0x63, 0x5f, 0x67, 0x63, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
-	0x12, 0x08, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x67, 0x63, 0x70, 0x22, 0x7c, 0x0a, 0x09, 0x41, 0x70,
-	0x69, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x3e, 0x0a, 0x0c, 0x63, 0x68, 0x61, 0x6e, 0x6e,
+// AdaptiveThrottlingConfig are options for rejecting calls on the client side
+// while the backend of a channel is overloaded, as described in the "Handling
+// Overload" chapter of the Google SRE book. Every channel counts the requests
+// made and the requests accepted by the backend, i.e., not failed with
+// RESOURCE_EXHAUSTED or UNAVAILABLE status, during the last window_ms. A new
+// call picked for the channel is rejected with the probability
+// max(0, (requests - multiplier * accepts) / (requests + 1)) with UNAVAILABLE
+// status and the "throttled on the client side" message, see ErrThrottled.
+// Rejected calls count as requests.
+type AdaptiveThrottlingConfig struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// How many requests per accepted request are allowed before throttling.
+	// Lower values throttle more aggressively. Default value is 0, meaning 2.
+	Multiplier float32 `protobuf:"fixed32,1,opt,name=multiplier,proto3" json:"multiplier,omitempty"`
+	// The window of the requests history in milliseconds.
+	// Default value is 0, meaning 120000 (2 minutes).
+	WindowMs uint32 `protobuf:"varint,2,opt,name=window_ms,json=windowMs,proto3" json:"window_ms,omitempty"`
+}
+
+func (x *AdaptiveThrottlingConfig) Reset() {
+	*x = AdaptiveThrottlingConfig{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_grpc_gcp_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AdaptiveThrottlingConfig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AdaptiveThrottlingConfig) ProtoMessage() {}
+
+func (x *AdaptiveThrottlingConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_gcp_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AdaptiveThrottlingConfig.ProtoReflect.Descriptor instead.
+func (*AdaptiveThrottlingConfig) Descriptor() ([]byte, []int) {
+	return file_grpc_gcp_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *AdaptiveThrottlingConfig) GetMultiplier() float32 {
+	if x != nil {
+		return x.Multiplier
+	}
+	return 0
+}
+
+func (x *AdaptiveThrottlingConfig) GetWindowMs() uint32 {
+	if x != nil {
+		return x.WindowMs
+	}
+	return 0
+}
+
+// ReconnectBackoffConfig are options for delaying reconnection of idle channels.
+// The delay before the n-th consecutive reconnect attempt of a channel is
+// min(base_delay_ms * multiplier^(n-1), max_delay_ms) randomized by +/- jitter.
+// The count of attempts is reset once the channel becomes ready.
+type ReconnectBackoffConfig struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// The delay in milliseconds before the first reconnect attempt.
+	BaseDelayMs uint32 `protobuf:"varint,1,opt,name=base_delay_ms,json=baseDelayMs,proto3" json:"base_delay_ms,omitempty"`
+	// The max delay in milliseconds between reconnect attempts.
+	// Default value is 0, meaning 120000 (2 minutes).
+	MaxDelayMs uint32 `protobuf:"varint,2,opt,name=max_delay_ms,json=maxDelayMs,proto3" json:"max_delay_ms,omitempty"`
+	// The factor the delay is multiplied by after every unsuccessful attempt.
+	// Default value is 0, meaning 1.6. Values less than 1 are ignored.
+	Multiplier float32 `protobuf:"fixed32,3,opt,name=multiplier,proto3" json:"multiplier,omitempty"`
+	// The relative random spread of the delay in the [0, 1] range.
+	// E.g., 0.2 means the delay is randomized within +/-20%.
+	Jitter float32 `protobuf:"fixed32,4,opt,name=jitter,proto3" json:"jitter,omitempty"`
+	// The max number of consecutive reconnect attempts after which the channel
+	// is replaced with a new one. Default value is 0, meaning unlimited.
+	MaxAttempts uint32 `protobuf:"varint,5,opt,name=max_attempts,json=maxAttempts,proto3" json:"max_attempts,omitempty"`
+}
+
+func (x *ReconnectBackoffConfig) Reset() {
+	*x = ReconnectBackoffConfig{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_grpc_gcp_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ReconnectBackoffConfig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReconnectBackoffConfig) ProtoMessage() {}
+
+func (x *ReconnectBackoffConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_gcp_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReconnectBackoffConfig.ProtoReflect.Descriptor instead.
+func (*ReconnectBackoffConfig) Descriptor() ([]byte, []int) {
+	return file_grpc_gcp_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ReconnectBackoffConfig) GetBaseDelayMs() uint32 {
+	if x != nil {
+		return x.BaseDelayMs
+	}
+	return 0
+}
+
+func (x *ReconnectBackoffConfig) GetMaxDelayMs() uint32 {
+	if x != nil {
+		return x.MaxDelayMs
+	}
+	return 0
+}
+
+func (x *ReconnectBackoffConfig) GetMultiplier() float32 {
+	if x != nil {
+		return x.Multiplier
+	}
+	return 0
+}
+
+func (x *ReconnectBackoffConfig) GetJitter() float32 {
+	if x != nil {
+		return x.Jitter
+	}
+	return 0
+}
+
+func (x *ReconnectBackoffConfig) GetMaxAttempts() uint32 {
+	if x != nil {
+		return x.MaxAttempts
+	}
+	return 0
+}
+
+// OutlierDetectionConfig are options for detecting channels with an error rate
+// much higher than the error rate of other channels in the pool.
+// Every interval_ms each ready channel with at least min_calls calls finished
+// during the interval is evaluated. If the percentage of calls failed with
+// UNAVAILABLE, DEADLINE_EXCEEDED, INTERNAL or UNKNOWN status on the channel
+// exceeds the average percentage of such failures on other evaluated channels by
+// error_percentage_over_peers or more, the channel is ejected: it will not be
+// picked for new calls without an affinity key for ejection_time_ms and a
+// replacement connection is created for the channel. The ejection is lifted once
+// the replacement connection is ready or after ejection_time_ms passed. Calls
+// bound to the channel by an affinity key still use the channel.
+type OutlierDetectionConfig struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// The interval of outlier detection evaluation in milliseconds.
+	IntervalMs uint32 `protobuf:"varint,1,opt,name=interval_ms,json=intervalMs,proto3" json:"interval_ms,omitempty"`
+	// The minimum number of calls finished on a channel during the interval for
+	// the channel to be evaluated.
+	MinCalls uint32 `protobuf:"varint,2,opt,name=min_calls,json=minCalls,proto3" json:"min_calls,omitempty"`
+	// How many percentage points the error percentage of a channel must exceed the
+	// average error percentage of other evaluated channels for the channel to be
+	// ejected. Default value is 0, meaning 10. Channels without errors are never
+	// ejected.
+	ErrorPercentageOverPeers uint32 `protobuf:"varint,3,opt,name=error_percentage_over_peers,json=errorPercentageOverPeers,proto3" json:"error_percentage_over_peers,omitempty"`
+	// The time in milliseconds an ejected channel is excluded from picking.
+	EjectionTimeMs uint32 `protobuf:"varint,4,opt,name=ejection_time_ms,json=ejectionTimeMs,proto3" json:"ejection_time_ms,omitempty"`
+	// The max percentage of channels in the pool that can be ejected at the same
+	// time. Default value is 0, meaning 50%.
+	MaxEjectionPercent uint32 `protobuf:"varint,5,opt,name=max_ejection_percent,json=maxEjectionPercent,proto3" json:"max_ejection_percent,omitempty"`
+}
+
+func (x *OutlierDetectionConfig) Reset() {
+	*x = OutlierDetectionConfig{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_grpc_gcp_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *OutlierDetectionConfig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OutlierDetectionConfig) ProtoMessage() {}
+
+func (x *OutlierDetectionConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_gcp_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OutlierDetectionConfig.ProtoReflect.Descriptor instead.
+func (*OutlierDetectionConfig) Descriptor() ([]byte, []int) {
+	return file_grpc_gcp_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *OutlierDetectionConfig) GetIntervalMs() uint32 {
+	if x != nil {
+		return x.IntervalMs
+	}
+	return 0
+}
+
+func (x *OutlierDetectionConfig) GetMinCalls() uint32 {
+	if x != nil {
+		return x.MinCalls
+	}
+	return 0
+}
+
+func (x *OutlierDetectionConfig) GetErrorPercentageOverPeers() uint32 {
+	if x != nil {
+		return x.ErrorPercentageOverPeers
+	}
+	return 0
+}
+
+func (x *OutlierDetectionConfig) GetEjectionTimeMs() uint32 {
+	if x != nil {
+		return x.EjectionTimeMs
+	}
+	return 0
+}
+
+func (x *OutlierDetectionConfig) GetMaxEjectionPercent() uint32 {
+	if x != nil {
+		return x.MaxEjectionPercent
+	}
+	return 0
+}
+
+// CircuitBreakerConfig are options for failing calls fast during an outage of
+// the backend of the whole pool, e.g., a regional outage.
+// The circuit breaker opens when consecutive_failures calls in a row failed or
+// when at least min_calls calls finished during the last interval_ms and at
+// least error_percentage of them failed. A failure is a call failed with
+// UNAVAILABLE, DEADLINE_EXCEEDED, INTERNAL or UNKNOWN status. While open, new
+// calls fail immediately with UNAVAILABLE status for cooldown_ms. After that a
+// single probe call is allowed: the circuit breaker closes if it succeeds and
+// opens for another cooldown_ms otherwise.
+type CircuitBreakerConfig struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// The number of consecutive failed calls opening the circuit breaker.
+	// Default value is 0, meaning calls in a row are not considered.
+	ConsecutiveFailures uint32 `protobuf:"varint,1,opt,name=consecutive_failures,json=consecutiveFailures,proto3" json:"consecutive_failures,omitempty"`
+	// The percentage of failed calls during the interval opening the circuit
+	// breaker. Default value is 0, meaning the error rate is not considered.
+	ErrorPercentage uint32 `protobuf:"varint,2,opt,name=error_percentage,json=errorPercentage,proto3" json:"error_percentage,omitempty"`
+	// The minimum number of calls finished during the interval for the error
+	// percentage to be evaluated.
+	MinCalls uint32 `protobuf:"varint,3,opt,name=min_calls,json=minCalls,proto3" json:"min_calls,omitempty"`
+	// The interval of error percentage evaluation in milliseconds.
+	// Default value is 0, meaning 10000 (10 seconds).
+	IntervalMs uint32 `protobuf:"varint,4,opt,name=interval_ms,json=intervalMs,proto3" json:"interval_ms,omitempty"`
+	// The time in milliseconds the circuit breaker stays open before a probe
+	// call is allowed. Default value is 0, meaning 5000 (5 seconds).
+	CooldownMs uint32 `protobuf:"varint,5,opt,name=cooldown_ms,json=cooldownMs,proto3" json:"cooldown_ms,omitempty"`
+}
+
+func (x *CircuitBreakerConfig) Reset() {
+	*x = CircuitBreakerConfig{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_grpc_gcp_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CircuitBreakerConfig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CircuitBreakerConfig) ProtoMessage() {}
+
+func (x *CircuitBreakerConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_gcp_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CircuitBreakerConfig.ProtoReflect.Descriptor instead.
+func (*CircuitBreakerConfig) Descriptor() ([]byte, []int) {
+	return file_grpc_gcp_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *CircuitBreakerConfig) GetConsecutiveFailures() uint32 {
+	if x != nil {
+		return x.ConsecutiveFailures
+	}
+	return 0
+}
+
+func (x *CircuitBreakerConfig) GetErrorPercentage() uint32 {
+	if x != nil {
+		return x.ErrorPercentage
+	}
+	return 0
+}
+
+func (x *CircuitBreakerConfig) GetMinCalls() uint32 {
+	if x != nil {
+		return x.MinCalls
+	}
+	return 0
+}
+
+func (x *CircuitBreakerConfig) GetIntervalMs() uint32 {
+	if x != nil {
+		return x.IntervalMs
+	}
+	return 0
+}
+
+func (x *CircuitBreakerConfig) GetCooldownMs() uint32 {
+	if x != nil {
+		return x.CooldownMs
+	}
+	return 0
+}
+
+type MethodConfig struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// A fully qualified name of a gRPC method, or a wildcard pattern ending
+	// with *, such as /foo.bar.Service/*, foo.bar.*. A wildcard pattern matches
+	// all methods with the name starting with the pattern, the leading / is
+	// optional. The config of an exact method name takes precedence over
+	// wildcard patterns, which are evaluated sequentially, and the first
+	// matching one takes precedence.
+	Name []string `protobuf:"bytes,1,rep,name=name,proto3" json:"name,omitempty"`
+	// The channel affinity configurations.
+	Affinity *AffinityConfig `protobuf:"bytes,1001,opt,name=affinity,proto3" json:"affinity,omitempty"`
+	// The channel pool overrides for the methods.
+	ChannelPool *MethodChannelPoolConfig `protobuf:"bytes,1002,opt,name=channel_pool,json=channelPool,proto3" json:"channel_pool,omitempty"`
+	// The default timeout and retries of the unary calls of the methods,
+	// applied by the GCP unary interceptor, e.g., for the BIND methods creating
+	// the sessions the following calls are routed by.
+	CallPolicy *CallPolicy `protobuf:"bytes,1003,opt,name=call_policy,json=callPolicy,proto3" json:"call_policy,omitempty"`
+}
+
+func (x *MethodConfig) Reset() {
+	*x = MethodConfig{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_grpc_gcp_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MethodConfig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MethodConfig) ProtoMessage() {}
+
+func (x *MethodConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_gcp_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MethodConfig.ProtoReflect.Descriptor instead.
+func (*MethodConfig) Descriptor() ([]byte, []int) {
+	return file_grpc_gcp_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *MethodConfig) GetName() []string {
+	if x != nil {
+		return x.Name
+	}
+	return nil
+}
+
+func (x *MethodConfig) GetAffinity() *AffinityConfig {
+	if x != nil {
+		return x.Affinity
+	}
+	return nil
+}
+
+func (x *MethodConfig) GetChannelPool() *MethodChannelPoolConfig {
+	if x != nil {
+		return x.ChannelPool
+	}
+	return nil
+}
+
+func (x *MethodConfig) GetCallPolicy() *CallPolicy {
+	if x != nil {
+		return x.CallPolicy
+	}
+	return nil
+}
+
+// CallPolicy is the timeout and retry policy of the unary calls of a method.
+// A call is retried after an attempt failed with a retryable status code, up
+// to max_attempts attempts, while the call context is not done. The n-th retry
+// waits min(initial_backoff_ms * 2^(n-1), max_backoff_ms) randomized by +/-
+// 20%. Only retry methods that are safe to call more than once.
+type CallPolicy struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// The timeout of each attempt in milliseconds, including the time waiting
+	// for a channel. An attempt not finished in time fails with
+	// DEADLINE_EXCEEDED status. The deadline of the call context, if any, still
+	// bounds the whole call. No timeout is applied if 0.
+	TimeoutMs uint32 `protobuf:"varint,1,opt,name=timeout_ms,json=timeoutMs,proto3" json:"timeout_ms,omitempty"`
+	// The max number of attempts of a call, including the first one. Values
+	// below 2 disable retries.
+	MaxAttempts uint32 `protobuf:"varint,2,opt,name=max_attempts,json=maxAttempts,proto3" json:"max_attempts,omitempty"`
+	// The status codes retried, such as "UNAVAILABLE". If empty, UNAVAILABLE
+	// and DEADLINE_EXCEEDED are retried.
+	RetryableCodes []string `protobuf:"bytes,3,rep,name=retryable_codes,json=retryableCodes,proto3" json:"retryable_codes,omitempty"`
+	// The backoff before the first retry in milliseconds. Default value is 100.
+	InitialBackoffMs uint32 `protobuf:"varint,4,opt,name=initial_backoff_ms,json=initialBackoffMs,proto3" json:"initial_backoff_ms,omitempty"`
+	// The max backoff between attempts in milliseconds. Default value is 5000.
+	MaxBackoffMs uint32 `protobuf:"varint,5,opt,name=max_backoff_ms,json=maxBackoffMs,proto3" json:"max_backoff_ms,omitempty"`
+}
+
+func (x *CallPolicy) Reset() {
+	*x = CallPolicy{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_grpc_gcp_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CallPolicy) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CallPolicy) ProtoMessage() {}
+
+func (x *CallPolicy) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_gcp_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CallPolicy.ProtoReflect.Descriptor instead.
+func (*CallPolicy) Descriptor() ([]byte, []int) {
+	return file_grpc_gcp_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *CallPolicy) GetTimeoutMs() uint32 {
+	if x != nil {
+		return x.TimeoutMs
+	}
+	return 0
+}
+
+func (x *CallPolicy) GetMaxAttempts() uint32 {
+	if x != nil {
+		return x.MaxAttempts
+	}
+	return 0
+}
+
+func (x *CallPolicy) GetRetryableCodes() []string {
+	if x != nil {
+		return x.RetryableCodes
+	}
+	return nil
+}
+
+func (x *CallPolicy) GetInitialBackoffMs() uint32 {
+	if x != nil {
+		return x.InitialBackoffMs
+	}
+	return 0
+}
+
+func (x *CallPolicy) GetMaxBackoffMs() uint32 {
+	if x != nil {
+		return x.MaxBackoffMs
+	}
+	return 0
+}
+
+// MethodChannelPoolConfig are options for configuring the channel pool
+// differently for specific methods.
+type MethodChannelPoolConfig struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// The low watermark of max number of concurrent streams of the methods in a
+	// channel. If set, the streams of the methods are counted separately from the
+	// streams of other methods and a channel with this number of streams of the
+	// methods is considered busy for the methods (but not for other methods).
+	// Once all channels are busy for the methods, a new channel will be created,
+	// until we reach the max size of the channel pool.
+	// This allows limiting heavy streaming methods so that they can't starve
+	// other calls.
+	// Default value is 0, meaning the channel pool's
+	// max_concurrent_streams_low_watermark applies.
+	MaxConcurrentStreamsLowWatermark uint32 `protobuf:"varint,1,opt,name=max_concurrent_streams_low_watermark,json=maxConcurrentStreamsLowWatermark,proto3" json:"max_concurrent_streams_low_watermark,omitempty"`
+	// The priority of the calls of the methods without an affinity key. May be
+	// overridden per call with grpcgcp.WithCallPriority.
+	Priority CallPriority `protobuf:"varint,2,opt,name=priority,proto3,enum=grpc.gcp.CallPriority" json:"priority,omitempty"`
+	// If true, the calls of the methods bypass the channel pool and the
+	// affinity, and are sent over a dedicated control channel outside the pool,
+	// e.g., the polling of long-running operations. The control channel is
+	// created with the first method config setting it and shared by all such
+	// methods. Its calls do not count toward the streams of the pool channels.
+	ControlChannel bool `protobuf:"varint,3,opt,name=control_channel,json=controlChannel,proto3" json:"control_channel,omitempty"`
+}
+
+func (x *MethodChannelPoolConfig) Reset() {
+	*x = MethodChannelPoolConfig{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_grpc_gcp_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MethodChannelPoolConfig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MethodChannelPoolConfig) ProtoMessage() {}
+
+func (x *MethodChannelPoolConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_gcp_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MethodChannelPoolConfig.ProtoReflect.Descriptor instead.
+func (*MethodChannelPoolConfig) Descriptor() ([]byte, []int) {
+	return file_grpc_gcp_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *MethodChannelPoolConfig) GetMaxConcurrentStreamsLowWatermark() uint32 {
+	if x != nil {
+		return x.MaxConcurrentStreamsLowWatermark
+	}
+	return 0
+}
+
+func (x *MethodChannelPoolConfig) GetPriority() CallPriority {
+	if x != nil {
+		return x.Priority
+	}
+	return CallPriority_NORMAL
+}
+
+func (x *MethodChannelPoolConfig) GetControlChannel() bool {
+	if x != nil {
+		return x.ControlChannel
+	}
+	return false
+}
+
+type AffinityConfig struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// The affinity command applies on the selected gRPC methods.
+	Command AffinityConfig_Command `protobuf:"varint,2,opt,name=command,proto3,enum=grpc.gcp.AffinityConfig_Command" json:"command,omitempty"`
+	// The field path of the affinity key in the request/response message.
+	// For example: "f.a", "f.b.d", etc. Members of a oneof are selected by their
+	// names and the entries of a map with string keys by their keys, e.g.,
+	// "f.labels[key]".
+	AffinityKey string `protobuf:"bytes,3,opt,name=affinity_key,json=affinityKey,proto3" json:"affinity_key,omitempty"`
+	// The field paths of the affinity key tried in order when the field at
+	// affinity_key holds no key, i.e., it is unset, empty or an empty repeated
+	// field, e.g., ["session"] after "transaction.id" for methods whose
+	// requests carry either a transaction or a session. The first path
+	// holding a non-empty key is used. A path not found in the message is
+	// skipped.
+	FallbackAffinityKeys []string `protobuf:"bytes,10,rep,name=fallback_affinity_keys,json=fallbackAffinityKeys,proto3" json:"fallback_affinity_keys,omitempty"`
+	// The names of the parameters of the x-goog-request-params request header,
+	// the routing header of Google APIs, composing the affinity key of the
+	// call, e.g., ["table_name", "app_profile_id"] for Bigtable. A parameter
+	// missing from the header is looked up at the field path of its name in
+	// the request message. The affinity key is the parameters with their
+	// values in this order formatted as the header, e.g.,
+	// "table_name=projects%2Fp%2Finstances%2Fi%2Ftables%2Ft&app_profile_id=a".
+	// The calls without a value of the first parameter have no affinity key.
+	// If set, affinity_key and fallback_affinity_keys are not used. Applies to
+	// the BOUND, UNBIND and BIND_ON_FIRST_USE commands. The header is read
+	// even if the call is made without the GCP interceptors.
+	AffinityKeyParams []string `protobuf:"bytes,11,rep,name=affinity_key_params,json=affinityKeyParams,proto3" json:"affinity_key_params,omitempty"`
+	// The field path of the affinity key in the response message of a BOUND
+	// call, e.g., the name of the returned resource. If set, the affinity key
+	// from the response of a successful BOUND call is validated to be bound to
+	// the channel used by the call. A mismatch, which may indicate a server-side
+	// session migration, is logged and counted.
+	ResponseAffinityKey string `protobuf:"bytes,4,opt,name=response_affinity_key,json=responseAffinityKey,proto3" json:"response_affinity_key,omitempty"`
+	// The period in milliseconds an affinity key unbound by an UNBIND call is
+	// remembered with its channel. Calls with the key arriving late, e.g., racing
+	// with the UNBIND call, still prefer the same channel during the period.
+	// Applies to UNBIND methods only. 0 (default) means the key is forgotten
+	// immediately.
+	UnbindGracePeriodMs uint32 `protobuf:"varint,5,opt,name=unbind_grace_period_ms,json=unbindGracePeriodMs,proto3" json:"unbind_grace_period_ms,omitempty"`
+	// If true, a BOUND call whose bound channel has
+	// max_concurrent_streams_low_watermark or more active streams runs on the
+	// least busy channel with capacity without rebinding the key. If no channel
+	// has capacity, a new channel is created if the pool is not at max_size and
+	// the call still runs on the bound channel. Enable it only for methods which
+	// do not require the bound channel, e.g., read-only methods.
+	OverflowWhenBusy bool `protobuf:"varint,6,opt,name=overflow_when_busy,json=overflowWhenBusy,proto3" json:"overflow_when_busy,omitempty"`
+	// If set, the affinity keys bound by a BIND method expire after ttl_ms
+	// milliseconds without a call using them and are unbound as if by an UNBIND
+	// call. This suits APIs without explicit delete calls, e.g., Firestore
+	// listen targets. Applies to BIND methods only. 0 (default) means the keys
+	// are bound until unbound by an UNBIND call.
+	TtlMs uint32 `protobuf:"varint,7,opt,name=ttl_ms,json=ttlMs,proto3" json:"ttl_ms,omitempty"`
+	// When an UNBIND call unbinds its keys. Applies to UNBIND methods only.
+	UnbindPolicy AffinityConfig_UnbindPolicy `protobuf:"varint,8,opt,name=unbind_policy,json=unbindPolicy,proto3,enum=grpc.gcp.AffinityConfig_UnbindPolicy" json:"unbind_policy,omitempty"`
+	// The status codes, e.g., "NOT_FOUND", with which a failed UNBIND call
+	// still unbinds its keys because the server no longer knows them, e.g., a
+	// deleted session. Applies to UNBIND methods only.
+	UnbindOnCodes []string `protobuf:"bytes,9,rep,name=unbind_on_codes,json=unbindOnCodes,proto3" json:"unbind_on_codes,omitempty"`
+}
+
+func (x *AffinityConfig) Reset() {
+	*x = AffinityConfig{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_grpc_gcp_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AffinityConfig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AffinityConfig) ProtoMessage() {}
+
+func (x *AffinityConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_gcp_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AffinityConfig.ProtoReflect.Descriptor instead.
+func (*AffinityConfig) Descriptor() ([]byte, []int) {
+	return file_grpc_gcp_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *AffinityConfig) GetCommand() AffinityConfig_Command {
+	if x != nil {
+		return x.Command
+	}
+	return AffinityConfig_BOUND
+}
+
+func (x *AffinityConfig) GetAffinityKey() string {
+	if x != nil {
+		return x.AffinityKey
+	}
+	return ""
+}
+
+func (x *AffinityConfig) GetFallbackAffinityKeys() []string {
+	if x != nil {
+		return x.FallbackAffinityKeys
+	}
+	return nil
+}
+
+func (x *AffinityConfig) GetAffinityKeyParams() []string {
+	if x != nil {
+		return x.AffinityKeyParams
+	}
+	return nil
+}
+
+func (x *AffinityConfig) GetResponseAffinityKey() string {
+	if x != nil {
+		return x.ResponseAffinityKey
+	}
+	return ""
+}
+
+func (x *AffinityConfig) GetUnbindGracePeriodMs() uint32 {
+	if x != nil {
+		return x.UnbindGracePeriodMs
+	}
+	return 0
+}
+
+func (x *AffinityConfig) GetOverflowWhenBusy() bool {
+	if x != nil {
+		return x.OverflowWhenBusy
+	}
+	return false
+}
+
+func (x *AffinityConfig) GetTtlMs() uint32 {
+	if x != nil {
+		return x.TtlMs
+	}
+	return 0
+}
+
+func (x *AffinityConfig) GetUnbindPolicy() AffinityConfig_UnbindPolicy {
+	if x != nil {
+		return x.UnbindPolicy
+	}
+	return AffinityConfig_UNBIND_ON_SUCCESS
+}
+
+func (x *AffinityConfig) GetUnbindOnCodes() []string {
+	if x != nil {
+		return x.UnbindOnCodes
+	}
+	return nil
+}
+
+var File_grpc_gcp_proto protoreflect.FileDescriptor
+
+var file_grpc_gcp_proto_rawDesc = []byte{
+	0x0a, 0x0e, 0x67, 0x72, 0x70, 0x63, 0x5f, 0x67, 0x63, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x12, 0x08, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x67, 0x63, 0x70, 0x22, 0x7c, 0x0a, 0x09, 0x41, 0x70,
+	0x69, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x3e, 0x0a, 0x0c, 0x63, 0x68, 0x61, 0x6e, 0x6e,
 	0x65, 0x6c, 0x5f, 0x70, 0x6f, 0x6f, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1b, 0x2e,
 	0x67, 0x72, 0x70, 0x63, 0x2e, 0x67, 0x63, 0x70, 0x2e, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c,
 	0x50, 0x6f, 0x6f, 0x6c, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x0b, 0x63, 0x68, 0x61, 0x6e,
 	0x6e, 0x65, 0x6c, 0x50, 0x6f, 0x6f, 0x6c, 0x12, 0x2f, 0x0a, 0x06, 0x6d, 0x65, 0x74, 0x68, 0x6f,
 	0x64, 0x18, 0xe9, 0x07, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x67, 0x72, 0x70, 0x63, 0x2e,
 	0x67, 0x63, 0x70, 0x2e, 0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67,
-	0x52, 0x06, 0x6d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x22, 0xff, 0x03, 0x0a, 0x11, 0x43, 0x68, 0x61,
+	0x52, 0x06, 0x6d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x22, 0xce, 0x0f, 0x0a, 0x11, 0x43, 0x68, 0x61,
 	0x6e, 0x6e, 0x65, 0x6c, 0x50, 0x6f, 0x6f, 0x6c, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x19,
 	0x0a, 0x08, 0x6d, 0x61, 0x78, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d,
 	0x52, 0x07, 0x6d, 0x61, 0x78, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x21, 0x0a, 0x0c, 0x69, 0x64, 0x6c,
@@ -501,29 +1809,249 @@ var file_grpc_gcp_proto_rawDesc = []byte{
 	0x63, 0x2e, 0x67, 0x63, 0x70, 0x2e, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x50, 0x6f, 0x6f,
 	0x6c, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x2e, 0x42, 0x69, 0x6e, 0x64, 0x50, 0x69, 0x63, 0x6b,
 	0x53, 0x74, 0x72, 0x61, 0x74, 0x65, 0x67, 0x79, 0x52, 0x10, 0x62, 0x69, 0x6e, 0x64, 0x50, 0x69,
-	0x63, 0x6b, 0x53, 0x74, 0x72, 0x61, 0x74, 0x65, 0x67, 0x79, 0x22, 0x4e, 0x0a, 0x10, 0x42, 0x69,
-	0x6e, 0x64, 0x50, 0x69, 0x63, 0x6b, 0x53, 0x74, 0x72, 0x61, 0x74, 0x65, 0x67, 0x79, 0x12, 0x0f,
-	0x0a, 0x0b, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12,
-	0x18, 0x0a, 0x14, 0x4c, 0x45, 0x41, 0x53, 0x54, 0x5f, 0x41, 0x43, 0x54, 0x49, 0x56, 0x45, 0x5f,
-	0x53, 0x54, 0x52, 0x45, 0x41, 0x4d, 0x53, 0x10, 0x01, 0x12, 0x0f, 0x0a, 0x0b, 0x52, 0x4f, 0x55,
-	0x4e, 0x44, 0x5f, 0x52, 0x4f, 0x42, 0x49, 0x4e, 0x10, 0x02, 0x22, 0x59, 0x0a, 0x0c, 0x4d, 0x65,
-	0x74, 0x68, 0x6f, 0x64, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61,
-	0x6d, 0x65, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x35,
-	0x0a, 0x08, 0x61, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x79, 0x18, 0xe9, 0x07, 0x20, 0x01, 0x28,
-	0x0b, 0x32, 0x18, 0x2e, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x67, 0x63, 0x70, 0x2e, 0x41, 0x66, 0x66,
-	0x69, 0x6e, 0x69, 0x74, 0x79, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x08, 0x61, 0x66, 0x66,
-	0x69, 0x6e, 0x69, 0x74, 0x79, 0x22, 0x9b, 0x01, 0x0a, 0x0e, 0x41, 0x66, 0x66, 0x69, 0x6e, 0x69,
-	0x74, 0x79, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x3a, 0x0a, 0x07, 0x63, 0x6f, 0x6d, 0x6d,
-	0x61, 0x6e, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x20, 0x2e, 0x67, 0x72, 0x70, 0x63,
-	0x2e, 0x67, 0x63, 0x70, 0x2e, 0x41, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x79, 0x43, 0x6f, 0x6e,
-	0x66, 0x69, 0x67, 0x2e, 0x43, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x52, 0x07, 0x63, 0x6f, 0x6d,
-	0x6d, 0x61, 0x6e, 0x64, 0x12, 0x21, 0x0a, 0x0c, 0x61, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x79,
-	0x5f, 0x6b, 0x65, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x61, 0x66, 0x66, 0x69,
-	0x6e, 0x69, 0x74, 0x79, 0x4b, 0x65, 0x79, 0x22, 0x2a, 0x0a, 0x07, 0x43, 0x6f, 0x6d, 0x6d, 0x61,
-	0x6e, 0x64, 0x12, 0x09, 0x0a, 0x05, 0x42, 0x4f, 0x55, 0x4e, 0x44, 0x10, 0x00, 0x12, 0x08, 0x0a,
-	0x04, 0x42, 0x49, 0x4e, 0x44, 0x10, 0x01, 0x12, 0x0a, 0x0a, 0x06, 0x55, 0x4e, 0x42, 0x49, 0x4e,
-	0x44, 0x10, 0x02, 0x42, 0x0c, 0x5a, 0x0a, 0x2e, 0x2f, 0x67, 0x72, 0x70, 0x63, 0x5f, 0x67, 0x63,
-	0x70, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x63, 0x6b, 0x53, 0x74, 0x72, 0x61, 0x74, 0x65, 0x67, 0x79, 0x12, 0x4d, 0x0a, 0x0d, 0x70, 0x69,
+	0x63, 0x6b, 0x5f, 0x73, 0x74, 0x72, 0x61, 0x74, 0x65, 0x67, 0x79, 0x18, 0x09, 0x20, 0x01, 0x28,
+	0x0e, 0x32, 0x28, 0x2e, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x67, 0x63, 0x70, 0x2e, 0x43, 0x68, 0x61,
+	0x6e, 0x6e, 0x65, 0x6c, 0x50, 0x6f, 0x6f, 0x6c, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x2e, 0x50,
+	0x69, 0x63, 0x6b, 0x53, 0x74, 0x72, 0x61, 0x74, 0x65, 0x67, 0x79, 0x52, 0x0c, 0x70, 0x69, 0x63,
+	0x6b, 0x53, 0x74, 0x72, 0x61, 0x74, 0x65, 0x67, 0x79, 0x12, 0x4d, 0x0a, 0x11, 0x6f, 0x75, 0x74,
+	0x6c, 0x69, 0x65, 0x72, 0x5f, 0x64, 0x65, 0x74, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x0a,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x20, 0x2e, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x67, 0x63, 0x70, 0x2e,
+	0x4f, 0x75, 0x74, 0x6c, 0x69, 0x65, 0x72, 0x44, 0x65, 0x74, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e,
+	0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x10, 0x6f, 0x75, 0x74, 0x6c, 0x69, 0x65, 0x72, 0x44,
+	0x65, 0x74, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x4d, 0x0a, 0x11, 0x72, 0x65, 0x63, 0x6f,
+	0x6e, 0x6e, 0x65, 0x63, 0x74, 0x5f, 0x62, 0x61, 0x63, 0x6b, 0x6f, 0x66, 0x66, 0x18, 0x0b, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x20, 0x2e, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x67, 0x63, 0x70, 0x2e, 0x52,
+	0x65, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x42, 0x61, 0x63, 0x6b, 0x6f, 0x66, 0x66, 0x43,
+	0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x10, 0x72, 0x65, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74,
+	0x42, 0x61, 0x63, 0x6b, 0x6f, 0x66, 0x66, 0x12, 0x2a, 0x0a, 0x11, 0x63, 0x68, 0x61, 0x6e, 0x6e,
+	0x65, 0x6c, 0x5f, 0x69, 0x64, 0x5f, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x18, 0x0c, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x0f, 0x63, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x49, 0x64, 0x48, 0x65, 0x61,
+	0x64, 0x65, 0x72, 0x12, 0x47, 0x0a, 0x0f, 0x63, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74, 0x5f, 0x62,
+	0x72, 0x65, 0x61, 0x6b, 0x65, 0x72, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1e, 0x2e, 0x67,
+	0x72, 0x70, 0x63, 0x2e, 0x67, 0x63, 0x70, 0x2e, 0x43, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74, 0x42,
+	0x72, 0x65, 0x61, 0x6b, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x0e, 0x63, 0x69,
+	0x72, 0x63, 0x75, 0x69, 0x74, 0x42, 0x72, 0x65, 0x61, 0x6b, 0x65, 0x72, 0x12, 0x36, 0x0a, 0x17,
+	0x74, 0x65, 0x61, 0x72, 0x64, 0x6f, 0x77, 0x6e, 0x5f, 0x72, 0x65, 0x74, 0x72, 0x79, 0x5f, 0x61,
+	0x74, 0x74, 0x65, 0x6d, 0x70, 0x74, 0x73, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x15, 0x74,
+	0x65, 0x61, 0x72, 0x64, 0x6f, 0x77, 0x6e, 0x52, 0x65, 0x74, 0x72, 0x79, 0x41, 0x74, 0x74, 0x65,
+	0x6d, 0x70, 0x74, 0x73, 0x12, 0x30, 0x0a, 0x14, 0x73, 0x70, 0x72, 0x65, 0x61, 0x64, 0x5f, 0x63,
+	0x61, 0x6c, 0x6c, 0x5f, 0x61, 0x74, 0x74, 0x65, 0x6d, 0x70, 0x74, 0x73, 0x18, 0x0f, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x12, 0x73, 0x70, 0x72, 0x65, 0x61, 0x64, 0x43, 0x61, 0x6c, 0x6c, 0x41, 0x74,
+	0x74, 0x65, 0x6d, 0x70, 0x74, 0x73, 0x12, 0x53, 0x0a, 0x13, 0x61, 0x64, 0x61, 0x70, 0x74, 0x69,
+	0x76, 0x65, 0x5f, 0x74, 0x68, 0x72, 0x6f, 0x74, 0x74, 0x6c, 0x69, 0x6e, 0x67, 0x18, 0x10, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x22, 0x2e, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x67, 0x63, 0x70, 0x2e, 0x41,
+	0x64, 0x61, 0x70, 0x74, 0x69, 0x76, 0x65, 0x54, 0x68, 0x72, 0x6f, 0x74, 0x74, 0x6c, 0x69, 0x6e,
+	0x67, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x12, 0x61, 0x64, 0x61, 0x70, 0x74, 0x69, 0x76,
+	0x65, 0x54, 0x68, 0x72, 0x6f, 0x74, 0x74, 0x6c, 0x69, 0x6e, 0x67, 0x12, 0x41, 0x0a, 0x1d, 0x61,
+	0x66, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x79, 0x5f, 0x6b, 0x65, 0x79, 0x5f, 0x70, 0x72, 0x65, 0x66,
+	0x69, 0x78, 0x5f, 0x64, 0x65, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x65, 0x72, 0x18, 0x11, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x1a, 0x61, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x79, 0x4b, 0x65, 0x79, 0x50,
+	0x72, 0x65, 0x66, 0x69, 0x78, 0x44, 0x65, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x65, 0x72, 0x12, 0x37,
+	0x0a, 0x09, 0x6b, 0x65, 0x65, 0x70, 0x61, 0x6c, 0x69, 0x76, 0x65, 0x18, 0x12, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x19, 0x2e, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x67, 0x63, 0x70, 0x2e, 0x4b, 0x65, 0x65,
+	0x70, 0x61, 0x6c, 0x69, 0x76, 0x65, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x09, 0x6b, 0x65,
+	0x65, 0x70, 0x61, 0x6c, 0x69, 0x76, 0x65, 0x12, 0x27, 0x0a, 0x10, 0x6d, 0x61, 0x78, 0x5f, 0x70,
+	0x69, 0x63, 0x6b, 0x5f, 0x77, 0x61, 0x69, 0x74, 0x5f, 0x6d, 0x73, 0x18, 0x13, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x0d, 0x6d, 0x61, 0x78, 0x50, 0x69, 0x63, 0x6b, 0x57, 0x61, 0x69, 0x74, 0x4d, 0x73,
+	0x12, 0x41, 0x0a, 0x1d, 0x6c, 0x6f, 0x77, 0x5f, 0x70, 0x72, 0x69, 0x6f, 0x72, 0x69, 0x74, 0x79,
+	0x5f, 0x72, 0x65, 0x73, 0x65, 0x72, 0x76, 0x65, 0x64, 0x5f, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d,
+	0x73, 0x18, 0x14, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x1a, 0x6c, 0x6f, 0x77, 0x50, 0x72, 0x69, 0x6f,
+	0x72, 0x69, 0x74, 0x79, 0x52, 0x65, 0x73, 0x65, 0x72, 0x76, 0x65, 0x64, 0x53, 0x74, 0x72, 0x65,
+	0x61, 0x6d, 0x73, 0x12, 0x56, 0x0a, 0x14, 0x61, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x79, 0x5f,
+	0x72, 0x65, 0x62, 0x61, 0x6c, 0x61, 0x6e, 0x63, 0x69, 0x6e, 0x67, 0x18, 0x15, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x23, 0x2e, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x67, 0x63, 0x70, 0x2e, 0x41, 0x66, 0x66,
+	0x69, 0x6e, 0x69, 0x74, 0x79, 0x52, 0x65, 0x62, 0x61, 0x6c, 0x61, 0x6e, 0x63, 0x69, 0x6e, 0x67,
+	0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x13, 0x61, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x79,
+	0x52, 0x65, 0x62, 0x61, 0x6c, 0x61, 0x6e, 0x63, 0x69, 0x6e, 0x67, 0x12, 0x30, 0x0a, 0x14, 0x64,
+	0x69, 0x73, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x68, 0x65, 0x61, 0x6c, 0x74, 0x68, 0x5f, 0x63, 0x68,
+	0x65, 0x63, 0x6b, 0x18, 0x16, 0x20, 0x01, 0x28, 0x08, 0x52, 0x12, 0x64, 0x69, 0x73, 0x61, 0x62,
+	0x6c, 0x65, 0x48, 0x65, 0x61, 0x6c, 0x74, 0x68, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x12, 0x26, 0x0a,
+	0x0f, 0x70, 0x69, 0x63, 0x6b, 0x5f, 0x61, 0x75, 0x64, 0x69, 0x74, 0x5f, 0x73, 0x69, 0x7a, 0x65,
+	0x18, 0x17, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0d, 0x70, 0x69, 0x63, 0x6b, 0x41, 0x75, 0x64, 0x69,
+	0x74, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x40, 0x0a, 0x1d, 0x6d, 0x61, 0x78, 0x5f, 0x61, 0x66, 0x66,
+	0x69, 0x6e, 0x69, 0x74, 0x79, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x5f, 0x70, 0x65, 0x72, 0x5f, 0x63,
+	0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x18, 0x18, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x19, 0x6d, 0x61,
+	0x78, 0x41, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x79, 0x4b, 0x65, 0x79, 0x73, 0x50, 0x65, 0x72,
+	0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x12, 0x27, 0x0a, 0x0f, 0x63, 0x68, 0x61, 0x6e, 0x6e,
+	0x65, 0x6c, 0x5f, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x73, 0x18, 0x19, 0x20, 0x03, 0x28, 0x09,
+	0x52, 0x0e, 0x63, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74, 0x73,
+	0x12, 0x2c, 0x0a, 0x12, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x5f, 0x74, 0x69, 0x6d, 0x65,
+	0x6f, 0x75, 0x74, 0x5f, 0x6d, 0x73, 0x18, 0x1a, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x10, 0x63, 0x6f,
+	0x6e, 0x6e, 0x65, 0x63, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x4d, 0x73, 0x12, 0x63,
+	0x0a, 0x15, 0x75, 0x6e, 0x6b, 0x6e, 0x6f, 0x77, 0x6e, 0x5f, 0x6d, 0x65, 0x74, 0x68, 0x6f, 0x64,
+	0x5f, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x18, 0x1b, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x2f, 0x2e,
+	0x67, 0x72, 0x70, 0x63, 0x2e, 0x67, 0x63, 0x70, 0x2e, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c,
+	0x50, 0x6f, 0x6f, 0x6c, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x2e, 0x55, 0x6e, 0x6b, 0x6e, 0x6f,
+	0x77, 0x6e, 0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52, 0x13,
+	0x75, 0x6e, 0x6b, 0x6e, 0x6f, 0x77, 0x6e, 0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x50, 0x6f, 0x6c,
+	0x69, 0x63, 0x79, 0x22, 0x4e, 0x0a, 0x10, 0x42, 0x69, 0x6e, 0x64, 0x50, 0x69, 0x63, 0x6b, 0x53,
+	0x74, 0x72, 0x61, 0x74, 0x65, 0x67, 0x79, 0x12, 0x0f, 0x0a, 0x0b, 0x55, 0x4e, 0x53, 0x50, 0x45,
+	0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x18, 0x0a, 0x14, 0x4c, 0x45, 0x41, 0x53,
+	0x54, 0x5f, 0x41, 0x43, 0x54, 0x49, 0x56, 0x45, 0x5f, 0x53, 0x54, 0x52, 0x45, 0x41, 0x4d, 0x53,
+	0x10, 0x01, 0x12, 0x0f, 0x0a, 0x0b, 0x52, 0x4f, 0x55, 0x4e, 0x44, 0x5f, 0x52, 0x4f, 0x42, 0x49,
+	0x4e, 0x10, 0x02, 0x22, 0xa0, 0x01, 0x0a, 0x0c, 0x50, 0x69, 0x63, 0x6b, 0x53, 0x74, 0x72, 0x61,
+	0x74, 0x65, 0x67, 0x79, 0x12, 0x1d, 0x0a, 0x19, 0x50, 0x49, 0x43, 0x4b, 0x5f, 0x53, 0x54, 0x52,
+	0x41, 0x54, 0x45, 0x47, 0x59, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45,
+	0x44, 0x10, 0x00, 0x12, 0x1d, 0x0a, 0x19, 0x50, 0x49, 0x43, 0x4b, 0x5f, 0x4c, 0x45, 0x41, 0x53,
+	0x54, 0x5f, 0x41, 0x43, 0x54, 0x49, 0x56, 0x45, 0x5f, 0x53, 0x54, 0x52, 0x45, 0x41, 0x4d, 0x53,
+	0x10, 0x01, 0x12, 0x17, 0x0a, 0x13, 0x50, 0x49, 0x43, 0x4b, 0x5f, 0x4c, 0x4f, 0x57, 0x45, 0x53,
+	0x54, 0x5f, 0x4c, 0x41, 0x54, 0x45, 0x4e, 0x43, 0x59, 0x10, 0x02, 0x12, 0x1a, 0x0a, 0x16, 0x50,
+	0x49, 0x43, 0x4b, 0x5f, 0x4c, 0x45, 0x41, 0x53, 0x54, 0x5f, 0x55, 0x54, 0x49, 0x4c, 0x49, 0x5a,
+	0x41, 0x54, 0x49, 0x4f, 0x4e, 0x10, 0x03, 0x12, 0x1d, 0x0a, 0x19, 0x50, 0x49, 0x43, 0x4b, 0x5f,
+	0x57, 0x45, 0x49, 0x47, 0x48, 0x54, 0x45, 0x44, 0x5f, 0x52, 0x4f, 0x55, 0x4e, 0x44, 0x5f, 0x52,
+	0x4f, 0x42, 0x49, 0x4e, 0x10, 0x04, 0x22, 0x71, 0x0a, 0x13, 0x55, 0x6e, 0x6b, 0x6e, 0x6f, 0x77,
+	0x6e, 0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x12, 0x1a, 0x0a,
+	0x16, 0x55, 0x4e, 0x4b, 0x4e, 0x4f, 0x57, 0x4e, 0x5f, 0x4d, 0x45, 0x54, 0x48, 0x4f, 0x44, 0x5f,
+	0x44, 0x45, 0x46, 0x41, 0x55, 0x4c, 0x54, 0x10, 0x00, 0x12, 0x19, 0x0a, 0x15, 0x55, 0x4e, 0x4b,
+	0x4e, 0x4f, 0x57, 0x4e, 0x5f, 0x4d, 0x45, 0x54, 0x48, 0x4f, 0x44, 0x5f, 0x52, 0x45, 0x4a, 0x45,
+	0x43, 0x54, 0x10, 0x01, 0x12, 0x23, 0x0a, 0x1f, 0x55, 0x4e, 0x4b, 0x4e, 0x4f, 0x57, 0x4e, 0x5f,
+	0x4d, 0x45, 0x54, 0x48, 0x4f, 0x44, 0x5f, 0x4f, 0x56, 0x45, 0x52, 0x46, 0x4c, 0x4f, 0x57, 0x5f,
+	0x43, 0x48, 0x41, 0x4e, 0x4e, 0x45, 0x4c, 0x10, 0x02, 0x22, 0x77, 0x0a, 0x19, 0x41, 0x66, 0x66,
+	0x69, 0x6e, 0x69, 0x74, 0x79, 0x52, 0x65, 0x62, 0x61, 0x6c, 0x61, 0x6e, 0x63, 0x69, 0x6e, 0x67,
+	0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x1f, 0x0a, 0x0b, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76,
+	0x61, 0x6c, 0x5f, 0x6d, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0a, 0x69, 0x6e, 0x74,
+	0x65, 0x72, 0x76, 0x61, 0x6c, 0x4d, 0x73, 0x12, 0x1b, 0x0a, 0x09, 0x6d, 0x61, 0x78, 0x5f, 0x6d,
+	0x6f, 0x76, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x6d, 0x61, 0x78, 0x4d,
+	0x6f, 0x76, 0x65, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x74, 0x6f, 0x6c, 0x65, 0x72, 0x61, 0x6e, 0x63,
+	0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x74, 0x6f, 0x6c, 0x65, 0x72, 0x61, 0x6e,
+	0x63, 0x65, 0x22, 0x7d, 0x0a, 0x0f, 0x4b, 0x65, 0x65, 0x70, 0x61, 0x6c, 0x69, 0x76, 0x65, 0x43,
+	0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x17, 0x0a, 0x07, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x6d, 0x73,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x06, 0x74, 0x69, 0x6d, 0x65, 0x4d, 0x73, 0x12, 0x1d,
+	0x0a, 0x0a, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x5f, 0x6d, 0x73, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x4d, 0x73, 0x12, 0x32, 0x0a,
+	0x15, 0x70, 0x65, 0x72, 0x6d, 0x69, 0x74, 0x5f, 0x77, 0x69, 0x74, 0x68, 0x6f, 0x75, 0x74, 0x5f,
+	0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x13, 0x70, 0x65,
+	0x72, 0x6d, 0x69, 0x74, 0x57, 0x69, 0x74, 0x68, 0x6f, 0x75, 0x74, 0x53, 0x74, 0x72, 0x65, 0x61,
+	0x6d, 0x22, 0x57, 0x0a, 0x18, 0x41, 0x64, 0x61, 0x70, 0x74, 0x69, 0x76, 0x65, 0x54, 0x68, 0x72,
+	0x6f, 0x74, 0x74, 0x6c, 0x69, 0x6e, 0x67, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x1e, 0x0a,
+	0x0a, 0x6d, 0x75, 0x6c, 0x74, 0x69, 0x70, 0x6c, 0x69, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x02, 0x52, 0x0a, 0x6d, 0x75, 0x6c, 0x74, 0x69, 0x70, 0x6c, 0x69, 0x65, 0x72, 0x12, 0x1b, 0x0a,
+	0x09, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x5f, 0x6d, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x08, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x4d, 0x73, 0x22, 0xb9, 0x01, 0x0a, 0x16, 0x52,
+	0x65, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x42, 0x61, 0x63, 0x6b, 0x6f, 0x66, 0x66, 0x43,
+	0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x22, 0x0a, 0x0d, 0x62, 0x61, 0x73, 0x65, 0x5f, 0x64, 0x65,
+	0x6c, 0x61, 0x79, 0x5f, 0x6d, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0b, 0x62, 0x61,
+	0x73, 0x65, 0x44, 0x65, 0x6c, 0x61, 0x79, 0x4d, 0x73, 0x12, 0x20, 0x0a, 0x0c, 0x6d, 0x61, 0x78,
+	0x5f, 0x64, 0x65, 0x6c, 0x61, 0x79, 0x5f, 0x6d, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x0a, 0x6d, 0x61, 0x78, 0x44, 0x65, 0x6c, 0x61, 0x79, 0x4d, 0x73, 0x12, 0x1e, 0x0a, 0x0a, 0x6d,
+	0x75, 0x6c, 0x74, 0x69, 0x70, 0x6c, 0x69, 0x65, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x02, 0x52,
+	0x0a, 0x6d, 0x75, 0x6c, 0x74, 0x69, 0x70, 0x6c, 0x69, 0x65, 0x72, 0x12, 0x16, 0x0a, 0x06, 0x6a,
+	0x69, 0x74, 0x74, 0x65, 0x72, 0x18, 0x04, 0x20, 0x01, 0x28, 0x02, 0x52, 0x06, 0x6a, 0x69, 0x74,
+	0x74, 0x65, 0x72, 0x12, 0x21, 0x0a, 0x0c, 0x6d, 0x61, 0x78, 0x5f, 0x61, 0x74, 0x74, 0x65, 0x6d,
+	0x70, 0x74, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0b, 0x6d, 0x61, 0x78, 0x41, 0x74,
+	0x74, 0x65, 0x6d, 0x70, 0x74, 0x73, 0x22, 0xf1, 0x01, 0x0a, 0x16, 0x4f, 0x75, 0x74, 0x6c, 0x69,
+	0x65, 0x72, 0x44, 0x65, 0x74, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x43, 0x6f, 0x6e, 0x66, 0x69,
+	0x67, 0x12, 0x1f, 0x0a, 0x0b, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x5f, 0x6d, 0x73,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0a, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c,
+	0x4d, 0x73, 0x12, 0x1b, 0x0a, 0x09, 0x6d, 0x69, 0x6e, 0x5f, 0x63, 0x61, 0x6c, 0x6c, 0x73, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x6d, 0x69, 0x6e, 0x43, 0x61, 0x6c, 0x6c, 0x73, 0x12,
+	0x3d, 0x0a, 0x1b, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x5f, 0x70, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74,
+	0x61, 0x67, 0x65, 0x5f, 0x6f, 0x76, 0x65, 0x72, 0x5f, 0x70, 0x65, 0x65, 0x72, 0x73, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x18, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x50, 0x65, 0x72, 0x63, 0x65,
+	0x6e, 0x74, 0x61, 0x67, 0x65, 0x4f, 0x76, 0x65, 0x72, 0x50, 0x65, 0x65, 0x72, 0x73, 0x12, 0x28,
+	0x0a, 0x10, 0x65, 0x6a, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x5f,
+	0x6d, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0e, 0x65, 0x6a, 0x65, 0x63, 0x74, 0x69,
+	0x6f, 0x6e, 0x54, 0x69, 0x6d, 0x65, 0x4d, 0x73, 0x12, 0x30, 0x0a, 0x14, 0x6d, 0x61, 0x78, 0x5f,
+	0x65, 0x6a, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x70, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x12, 0x6d, 0x61, 0x78, 0x45, 0x6a, 0x65, 0x63, 0x74,
+	0x69, 0x6f, 0x6e, 0x50, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x22, 0xd3, 0x01, 0x0a, 0x14, 0x43,
+	0x69, 0x72, 0x63, 0x75, 0x69, 0x74, 0x42, 0x72, 0x65, 0x61, 0x6b, 0x65, 0x72, 0x43, 0x6f, 0x6e,
+	0x66, 0x69, 0x67, 0x12, 0x31, 0x0a, 0x14, 0x63, 0x6f, 0x6e, 0x73, 0x65, 0x63, 0x75, 0x74, 0x69,
+	0x76, 0x65, 0x5f, 0x66, 0x61, 0x69, 0x6c, 0x75, 0x72, 0x65, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x13, 0x63, 0x6f, 0x6e, 0x73, 0x65, 0x63, 0x75, 0x74, 0x69, 0x76, 0x65, 0x46, 0x61,
+	0x69, 0x6c, 0x75, 0x72, 0x65, 0x73, 0x12, 0x29, 0x0a, 0x10, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x5f,
+	0x70, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x61, 0x67, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x0f, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x50, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x61, 0x67,
+	0x65, 0x12, 0x1b, 0x0a, 0x09, 0x6d, 0x69, 0x6e, 0x5f, 0x63, 0x61, 0x6c, 0x6c, 0x73, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x6d, 0x69, 0x6e, 0x43, 0x61, 0x6c, 0x6c, 0x73, 0x12, 0x1f,
+	0x0a, 0x0b, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x5f, 0x6d, 0x73, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x0d, 0x52, 0x0a, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x4d, 0x73, 0x12,
+	0x1f, 0x0a, 0x0b, 0x63, 0x6f, 0x6f, 0x6c, 0x64, 0x6f, 0x77, 0x6e, 0x5f, 0x6d, 0x73, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x0a, 0x63, 0x6f, 0x6f, 0x6c, 0x64, 0x6f, 0x77, 0x6e, 0x4d, 0x73,
+	0x22, 0xd8, 0x01, 0x0a, 0x0c, 0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x43, 0x6f, 0x6e, 0x66, 0x69,
+	0x67, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52,
+	0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x35, 0x0a, 0x08, 0x61, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74,
+	0x79, 0x18, 0xe9, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x18, 0x2e, 0x67, 0x72, 0x70, 0x63, 0x2e,
+	0x67, 0x63, 0x70, 0x2e, 0x41, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x79, 0x43, 0x6f, 0x6e, 0x66,
+	0x69, 0x67, 0x52, 0x08, 0x61, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x79, 0x12, 0x45, 0x0a, 0x0c,
+	0x63, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x5f, 0x70, 0x6f, 0x6f, 0x6c, 0x18, 0xea, 0x07, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x21, 0x2e, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x67, 0x63, 0x70, 0x2e, 0x4d,
+	0x65, 0x74, 0x68, 0x6f, 0x64, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x50, 0x6f, 0x6f, 0x6c,
+	0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x0b, 0x63, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x50,
+	0x6f, 0x6f, 0x6c, 0x12, 0x36, 0x0a, 0x0b, 0x63, 0x61, 0x6c, 0x6c, 0x5f, 0x70, 0x6f, 0x6c, 0x69,
+	0x63, 0x79, 0x18, 0xeb, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x67, 0x72, 0x70, 0x63,
+	0x2e, 0x67, 0x63, 0x70, 0x2e, 0x43, 0x61, 0x6c, 0x6c, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52,
+	0x0a, 0x63, 0x61, 0x6c, 0x6c, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x22, 0xcb, 0x01, 0x0a, 0x0a,
+	0x43, 0x61, 0x6c, 0x6c, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x12, 0x1d, 0x0a, 0x0a, 0x74, 0x69,
+	0x6d, 0x65, 0x6f, 0x75, 0x74, 0x5f, 0x6d, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09,
+	0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x4d, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x6d, 0x61, 0x78,
+	0x5f, 0x61, 0x74, 0x74, 0x65, 0x6d, 0x70, 0x74, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x0b, 0x6d, 0x61, 0x78, 0x41, 0x74, 0x74, 0x65, 0x6d, 0x70, 0x74, 0x73, 0x12, 0x27, 0x0a, 0x0f,
+	0x72, 0x65, 0x74, 0x72, 0x79, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x63, 0x6f, 0x64, 0x65, 0x73, 0x18,
+	0x03, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0e, 0x72, 0x65, 0x74, 0x72, 0x79, 0x61, 0x62, 0x6c, 0x65,
+	0x43, 0x6f, 0x64, 0x65, 0x73, 0x12, 0x2c, 0x0a, 0x12, 0x69, 0x6e, 0x69, 0x74, 0x69, 0x61, 0x6c,
+	0x5f, 0x62, 0x61, 0x63, 0x6b, 0x6f, 0x66, 0x66, 0x5f, 0x6d, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x10, 0x69, 0x6e, 0x69, 0x74, 0x69, 0x61, 0x6c, 0x42, 0x61, 0x63, 0x6b, 0x6f, 0x66,
+	0x66, 0x4d, 0x73, 0x12, 0x24, 0x0a, 0x0e, 0x6d, 0x61, 0x78, 0x5f, 0x62, 0x61, 0x63, 0x6b, 0x6f,
+	0x66, 0x66, 0x5f, 0x6d, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0c, 0x6d, 0x61, 0x78,
+	0x42, 0x61, 0x63, 0x6b, 0x6f, 0x66, 0x66, 0x4d, 0x73, 0x22, 0xc6, 0x01, 0x0a, 0x17, 0x4d, 0x65,
+	0x74, 0x68, 0x6f, 0x64, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x50, 0x6f, 0x6f, 0x6c, 0x43,
+	0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x4e, 0x0a, 0x24, 0x6d, 0x61, 0x78, 0x5f, 0x63, 0x6f, 0x6e,
+	0x63, 0x75, 0x72, 0x72, 0x65, 0x6e, 0x74, 0x5f, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x73, 0x5f,
+	0x6c, 0x6f, 0x77, 0x5f, 0x77, 0x61, 0x74, 0x65, 0x72, 0x6d, 0x61, 0x72, 0x6b, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0d, 0x52, 0x20, 0x6d, 0x61, 0x78, 0x43, 0x6f, 0x6e, 0x63, 0x75, 0x72, 0x72, 0x65,
+	0x6e, 0x74, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x73, 0x4c, 0x6f, 0x77, 0x57, 0x61, 0x74, 0x65,
+	0x72, 0x6d, 0x61, 0x72, 0x6b, 0x12, 0x32, 0x0a, 0x08, 0x70, 0x72, 0x69, 0x6f, 0x72, 0x69, 0x74,
+	0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x16, 0x2e, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x67,
+	0x63, 0x70, 0x2e, 0x43, 0x61, 0x6c, 0x6c, 0x50, 0x72, 0x69, 0x6f, 0x72, 0x69, 0x74, 0x79, 0x52,
+	0x08, 0x70, 0x72, 0x69, 0x6f, 0x72, 0x69, 0x74, 0x79, 0x12, 0x27, 0x0a, 0x0f, 0x63, 0x6f, 0x6e,
+	0x74, 0x72, 0x6f, 0x6c, 0x5f, 0x63, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x0e, 0x63, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x43, 0x68, 0x61, 0x6e, 0x6e,
+	0x65, 0x6c, 0x22, 0xf5, 0x04, 0x0a, 0x0e, 0x41, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x79, 0x43,
+	0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x3a, 0x0a, 0x07, 0x63, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x20, 0x2e, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x67, 0x63,
+	0x70, 0x2e, 0x41, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x79, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67,
+	0x2e, 0x43, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x52, 0x07, 0x63, 0x6f, 0x6d, 0x6d, 0x61, 0x6e,
+	0x64, 0x12, 0x21, 0x0a, 0x0c, 0x61, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x79, 0x5f, 0x6b, 0x65,
+	0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x61, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74,
+	0x79, 0x4b, 0x65, 0x79, 0x12, 0x34, 0x0a, 0x16, 0x66, 0x61, 0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b,
+	0x5f, 0x61, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x79, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x0a,
+	0x20, 0x03, 0x28, 0x09, 0x52, 0x14, 0x66, 0x61, 0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b, 0x41, 0x66,
+	0x66, 0x69, 0x6e, 0x69, 0x74, 0x79, 0x4b, 0x65, 0x79, 0x73, 0x12, 0x2e, 0x0a, 0x13, 0x61, 0x66,
+	0x66, 0x69, 0x6e, 0x69, 0x74, 0x79, 0x5f, 0x6b, 0x65, 0x79, 0x5f, 0x70, 0x61, 0x72, 0x61, 0x6d,
+	0x73, 0x18, 0x0b, 0x20, 0x03, 0x28, 0x09, 0x52, 0x11, 0x61, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74,
+	0x79, 0x4b, 0x65, 0x79, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x12, 0x32, 0x0a, 0x15, 0x72, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x5f, 0x61, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x79, 0x5f,
+	0x6b, 0x65, 0x79, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x13, 0x72, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x41, 0x66, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x79, 0x4b, 0x65, 0x79, 0x12, 0x33,
+	0x0a, 0x16, 0x75, 0x6e, 0x62, 0x69, 0x6e, 0x64, 0x5f, 0x67, 0x72, 0x61, 0x63, 0x65, 0x5f, 0x70,
+	0x65, 0x72, 0x69, 0x6f, 0x64, 0x5f, 0x6d, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x13,
+	0x75, 0x6e, 0x62, 0x69, 0x6e, 0x64, 0x47, 0x72, 0x61, 0x63, 0x65, 0x50, 0x65, 0x72, 0x69, 0x6f,
+	0x64, 0x4d, 0x73, 0x12, 0x2c, 0x0a, 0x12, 0x6f, 0x76, 0x65, 0x72, 0x66, 0x6c, 0x6f, 0x77, 0x5f,
+	0x77, 0x68, 0x65, 0x6e, 0x5f, 0x62, 0x75, 0x73, 0x79, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x10, 0x6f, 0x76, 0x65, 0x72, 0x66, 0x6c, 0x6f, 0x77, 0x57, 0x68, 0x65, 0x6e, 0x42, 0x75, 0x73,
+	0x79, 0x12, 0x15, 0x0a, 0x06, 0x74, 0x74, 0x6c, 0x5f, 0x6d, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x05, 0x74, 0x74, 0x6c, 0x4d, 0x73, 0x12, 0x4a, 0x0a, 0x0d, 0x75, 0x6e, 0x62, 0x69,
+	0x6e, 0x64, 0x5f, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0e, 0x32,
+	0x25, 0x2e, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x67, 0x63, 0x70, 0x2e, 0x41, 0x66, 0x66, 0x69, 0x6e,
+	0x69, 0x74, 0x79, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x2e, 0x55, 0x6e, 0x62, 0x69, 0x6e, 0x64,
+	0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52, 0x0c, 0x75, 0x6e, 0x62, 0x69, 0x6e, 0x64, 0x50, 0x6f,
+	0x6c, 0x69, 0x63, 0x79, 0x12, 0x26, 0x0a, 0x0f, 0x75, 0x6e, 0x62, 0x69, 0x6e, 0x64, 0x5f, 0x6f,
+	0x6e, 0x5f, 0x63, 0x6f, 0x64, 0x65, 0x73, 0x18, 0x09, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0d, 0x75,
+	0x6e, 0x62, 0x69, 0x6e, 0x64, 0x4f, 0x6e, 0x43, 0x6f, 0x64, 0x65, 0x73, 0x22, 0x41, 0x0a, 0x07,
+	0x43, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x12, 0x09, 0x0a, 0x05, 0x42, 0x4f, 0x55, 0x4e, 0x44,
+	0x10, 0x00, 0x12, 0x08, 0x0a, 0x04, 0x42, 0x49, 0x4e, 0x44, 0x10, 0x01, 0x12, 0x0a, 0x0a, 0x06,
+	0x55, 0x4e, 0x42, 0x49, 0x4e, 0x44, 0x10, 0x02, 0x12, 0x15, 0x0a, 0x11, 0x42, 0x49, 0x4e, 0x44,
+	0x5f, 0x4f, 0x4e, 0x5f, 0x46, 0x49, 0x52, 0x53, 0x54, 0x5f, 0x55, 0x53, 0x45, 0x10, 0x03, 0x22,
+	0x39, 0x0a, 0x0c, 0x55, 0x6e, 0x62, 0x69, 0x6e, 0x64, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x12,
+	0x15, 0x0a, 0x11, 0x55, 0x4e, 0x42, 0x49, 0x4e, 0x44, 0x5f, 0x4f, 0x4e, 0x5f, 0x53, 0x55, 0x43,
+	0x43, 0x45, 0x53, 0x53, 0x10, 0x00, 0x12, 0x12, 0x0a, 0x0e, 0x55, 0x4e, 0x42, 0x49, 0x4e, 0x44,
+	0x5f, 0x4f, 0x4e, 0x5f, 0x50, 0x49, 0x43, 0x4b, 0x10, 0x01, 0x2a, 0x2d, 0x0a, 0x0c, 0x43, 0x61,
+	0x6c, 0x6c, 0x50, 0x72, 0x69, 0x6f, 0x72, 0x69, 0x74, 0x79, 0x12, 0x0a, 0x0a, 0x06, 0x4e, 0x4f,
+	0x52, 0x4d, 0x41, 0x4c, 0x10, 0x00, 0x12, 0x08, 0x0a, 0x04, 0x48, 0x49, 0x47, 0x48, 0x10, 0x01,
+	0x12, 0x07, 0x0a, 0x03, 0x4c, 0x4f, 0x57, 0x10, 0x02, 0x42, 0x0c, 0x5a, 0x0a, 0x2e, 0x2f, 0x67,
+	0x72, 0x70, 0x63, 0x5f, 0x67, 0x63, 0x70, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -538,27 +2066,51 @@ func file_grpc_gcp_proto_rawDescGZIP() []byte {
 	return file_grpc_gcp_proto_rawDescData
 }
 
-var file_grpc_gcp_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
-var file_grpc_gcp_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_grpc_gcp_proto_enumTypes = make([]protoimpl.EnumInfo, 6)
+var file_grpc_gcp_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
 var file_grpc_gcp_proto_goTypes = []interface{}{
-	(ChannelPoolConfig_BindPickStrategy)(0), // 0: grpc.gcp.ChannelPoolConfig.BindPickStrategy
-	(AffinityConfig_Command)(0),             // 1: grpc.gcp.AffinityConfig.Command
-	(*ApiConfig)(nil),                       // 2: grpc.gcp.ApiConfig
-	(*ChannelPoolConfig)(nil),               // 3: grpc.gcp.ChannelPoolConfig
-	(*MethodConfig)(nil),                    // 4: grpc.gcp.MethodConfig
-	(*AffinityConfig)(nil),                  // 5: grpc.gcp.AffinityConfig
+	(CallPriority)(0),                          // 0: grpc.gcp.CallPriority
+	(ChannelPoolConfig_BindPickStrategy)(0),    // 1: grpc.gcp.ChannelPoolConfig.BindPickStrategy
+	(ChannelPoolConfig_PickStrategy)(0),        // 2: grpc.gcp.ChannelPoolConfig.PickStrategy
+	(ChannelPoolConfig_UnknownMethodPolicy)(0), // 3: grpc.gcp.ChannelPoolConfig.UnknownMethodPolicy
+	(AffinityConfig_Command)(0),                // 4: grpc.gcp.AffinityConfig.Command
+	(AffinityConfig_UnbindPolicy)(0),           // 5: grpc.gcp.AffinityConfig.UnbindPolicy
+	(*ApiConfig)(nil),                          // 6: grpc.gcp.ApiConfig
+	(*ChannelPoolConfig)(nil),                  // 7: grpc.gcp.ChannelPoolConfig
+	(*AffinityRebalancingConfig)(nil),          // 8: grpc.gcp.AffinityRebalancingConfig
+	(*KeepaliveConfig)(nil),                    // 9: grpc.gcp.KeepaliveConfig
+	(*AdaptiveThrottlingConfig)(nil),           // 10: grpc.gcp.AdaptiveThrottlingConfig
+	(*ReconnectBackoffConfig)(nil),             // 11: grpc.gcp.ReconnectBackoffConfig
+	(*OutlierDetectionConfig)(nil),             // 12: grpc.gcp.OutlierDetectionConfig
+	(*CircuitBreakerConfig)(nil),               // 13: grpc.gcp.CircuitBreakerConfig
+	(*MethodConfig)(nil),                       // 14: grpc.gcp.MethodConfig
+	(*CallPolicy)(nil),                         // 15: grpc.gcp.CallPolicy
+	(*MethodChannelPoolConfig)(nil),            // 16: grpc.gcp.MethodChannelPoolConfig
+	(*AffinityConfig)(nil),                     // 17: grpc.gcp.AffinityConfig
 }
 var file_grpc_gcp_proto_depIdxs = []int32{
-	3, // 0: grpc.gcp.ApiConfig.channel_pool:type_name -> grpc.gcp.ChannelPoolConfig
-	4, // 1: grpc.gcp.ApiConfig.method:type_name -> grpc.gcp.MethodConfig
-	0, // 2: grpc.gcp.ChannelPoolConfig.bind_pick_strategy:type_name -> grpc.gcp.ChannelPoolConfig.BindPickStrategy
-	5, // 3: grpc.gcp.MethodConfig.affinity:type_name -> grpc.gcp.AffinityConfig
-	1, // 4: grpc.gcp.AffinityConfig.command:type_name -> grpc.gcp.AffinityConfig.Command
-	5, // [5:5] is the sub-list for method output_type
-	5, // [5:5] is the sub-list for method input_type
-	5, // [5:5] is the sub-list for extension type_name
-	5, // [5:5] is the sub-list for extension extendee
-	0, // [0:5] is the sub-list for field type_name
+	7,  // 0: grpc.gcp.ApiConfig.channel_pool:type_name -> grpc.gcp.ChannelPoolConfig
+	14, // 1: grpc.gcp.ApiConfig.method:type_name -> grpc.gcp.MethodConfig
+	1,  // 2: grpc.gcp.ChannelPoolConfig.bind_pick_strategy:type_name -> grpc.gcp.ChannelPoolConfig.BindPickStrategy
+	2,  // 3: grpc.gcp.ChannelPoolConfig.pick_strategy:type_name -> grpc.gcp.ChannelPoolConfig.PickStrategy
+	12, // 4: grpc.gcp.ChannelPoolConfig.outlier_detection:type_name -> grpc.gcp.OutlierDetectionConfig
+	11, // 5: grpc.gcp.ChannelPoolConfig.reconnect_backoff:type_name -> grpc.gcp.ReconnectBackoffConfig
+	13, // 6: grpc.gcp.ChannelPoolConfig.circuit_breaker:type_name -> grpc.gcp.CircuitBreakerConfig
+	10, // 7: grpc.gcp.ChannelPoolConfig.adaptive_throttling:type_name -> grpc.gcp.AdaptiveThrottlingConfig
+	9,  // 8: grpc.gcp.ChannelPoolConfig.keepalive:type_name -> grpc.gcp.KeepaliveConfig
+	8,  // 9: grpc.gcp.ChannelPoolConfig.affinity_rebalancing:type_name -> grpc.gcp.AffinityRebalancingConfig
+	3,  // 10: grpc.gcp.ChannelPoolConfig.unknown_method_policy:type_name -> grpc.gcp.ChannelPoolConfig.UnknownMethodPolicy
+	17, // 11: grpc.gcp.MethodConfig.affinity:type_name -> grpc.gcp.AffinityConfig
+	16, // 12: grpc.gcp.MethodConfig.channel_pool:type_name -> grpc.gcp.MethodChannelPoolConfig
+	15, // 13: grpc.gcp.MethodConfig.call_policy:type_name -> grpc.gcp.CallPolicy
+	0,  // 14: grpc.gcp.MethodChannelPoolConfig.priority:type_name -> grpc.gcp.CallPriority
+	4,  // 15: grpc.gcp.AffinityConfig.command:type_name -> grpc.gcp.AffinityConfig.Command
+	5,  // 16: grpc.gcp.AffinityConfig.unbind_policy:type_name -> grpc.gcp.AffinityConfig.UnbindPolicy
+	17, // [17:17] is the sub-list for method output_type
+	17, // [17:17] is the sub-list for method input_type
+	17, // [17:17] is the sub-list for extension type_name
+	17, // [17:17] is the sub-list for extension extendee
+	0,  // [0:17] is the sub-list for field type_name
 }
 
 func init() { file_grpc_gcp_proto_init() }
@@ -592,7 +2144,7 @@ func file_grpc_gcp_proto_init() {
 			}
 		}
 		file_grpc_gcp_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*MethodConfig); i {
+			switch v := v.(*AffinityRebalancingConfig); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -604,6 +2156,102 @@ func file_grpc_gcp_proto_init() {
 			}
 		}
 		file_grpc_gcp_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*KeepaliveConfig); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_grpc_gcp_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AdaptiveThrottlingConfig); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_grpc_gcp_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ReconnectBackoffConfig); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_grpc_gcp_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*OutlierDetectionConfig); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_grpc_gcp_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CircuitBreakerConfig); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_grpc_gcp_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MethodConfig); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_grpc_gcp_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CallPolicy); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_grpc_gcp_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MethodChannelPoolConfig); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_grpc_gcp_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*AffinityConfig); i {
 			case 0:
 				return &v.state
@@ -621,8 +2269,8 @@ func file_grpc_gcp_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_grpc_gcp_proto_rawDesc,
-			NumEnums:      2,
-			NumMessages:   4,
+			NumEnums:      6,
+			NumMessages:   12,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -0,0 +1,213 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package grpcgcptest provides a fake channel pool of the grpc_gcp balancer
+// for reproducible tests of client libraries, see [Pool], and an in-process
+// server for end-to-end tests of the channel pool, see [Server].
+package grpcgcptest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+// SubConn is a fake balancer.SubConn. It never connects on its own, its state
+// is set with Pool.SetState.
+type SubConn struct {
+	// Index is the order in which the SubConn was created by the balancer.
+	Index int
+
+	mu       sync.Mutex
+	addrs    []resolver.Address
+	connects int
+	removed  bool
+}
+
+// UpdateAddresses implements balancer.SubConn.
+func (sc *SubConn) UpdateAddresses(addrs []resolver.Address) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.addrs = addrs
+}
+
+// Connect implements balancer.SubConn.
+func (sc *SubConn) Connect() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.connects++
+}
+
+// GetOrBuildProducer implements balancer.SubConn.
+func (sc *SubConn) GetOrBuildProducer(balancer.ProducerBuilder) (balancer.Producer, func()) {
+	return nil, func() {}
+}
+
+// Connects returns the number of times the balancer asked to connect.
+func (sc *SubConn) Connects() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.connects
+}
+
+// Removed returns whether the balancer removed the SubConn.
+func (sc *SubConn) Removed() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.removed
+}
+
+// ClientConn is a fake balancer.ClientConn recording the SubConns and the
+// state reported by the balancer.
+type ClientConn struct {
+	mu       sync.Mutex
+	subConns []*SubConn
+	state    balancer.State
+}
+
+// NewSubConn implements balancer.ClientConn.
+func (cc *ClientConn) NewSubConn(addrs []resolver.Address, _ balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	sc := &SubConn{Index: len(cc.subConns), addrs: addrs}
+	cc.subConns = append(cc.subConns, sc)
+	return sc, nil
+}
+
+// RemoveSubConn implements balancer.ClientConn.
+func (cc *ClientConn) RemoveSubConn(sc balancer.SubConn) {
+	fsc := sc.(*SubConn)
+	fsc.mu.Lock()
+	defer fsc.mu.Unlock()
+	fsc.removed = true
+}
+
+// UpdateAddresses implements balancer.ClientConn.
+func (cc *ClientConn) UpdateAddresses(sc balancer.SubConn, addrs []resolver.Address) {
+	sc.UpdateAddresses(addrs)
+}
+
+// UpdateState implements balancer.ClientConn.
+func (cc *ClientConn) UpdateState(s balancer.State) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.state = s
+}
+
+// ResolveNow implements balancer.ClientConn.
+func (cc *ClientConn) ResolveNow(resolver.ResolveNowOptions) {}
+
+// Target implements balancer.ClientConn.
+func (cc *ClientConn) Target() string {
+	return "grpcgcptest"
+}
+
+// SubConns returns all SubConns created by the balancer in the order of
+// creation, including the removed ones.
+func (cc *ClientConn) SubConns() []*SubConn {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return append([]*SubConn{}, cc.subConns...)
+}
+
+// State returns the last state reported by the balancer.
+func (cc *ClientConn) State() balancer.State {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.state
+}
+
+// Pool is a grpc_gcp balancer in the deterministic mode on top of a fake
+// ClientConn. Calls are simulated with Invoke and the channel states are
+// driven by the test, so the channel assignment is reproducible.
+//
+//	pool := grpcgcptest.NewPool(grpcgcp.Config{}, apiConfig)
+//	defer pool.Close()
+//	pool.SetAllReady()
+//	sc, err := pool.Invoke(ctx, "/some.api.v1/Method1", req, reply)
+type Pool struct {
+	CC *ClientConn
+
+	b balancer.Balancer
+}
+
+// NewPool builds the grpc_gcp balancer with the Config in the deterministic
+// mode and applies the apiConfig.
+func NewPool(cfg grpcgcp.Config, apiConfig *pb.ApiConfig) *Pool {
+	cfg.Deterministic = true
+	cc := &ClientConn{}
+	b := grpcgcp.NewBalancerBuilder(cfg).Build(cc, balancer.BuildOptions{})
+	b.UpdateClientConnState(balancer.ClientConnState{
+		BalancerConfig: &grpcgcp.GCPBalancerConfig{ApiConfig: apiConfig},
+	})
+	return &Pool{CC: cc, b: b}
+}
+
+// SetState reports the connectivity state of the SubConn to the balancer.
+func (p *Pool) SetState(sc *SubConn, s connectivity.State) {
+	p.b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: s})
+}
+
+// SetAllReady reports all SubConns which are not removed as READY.
+func (p *Pool) SetAllReady() {
+	for _, sc := range p.CC.SubConns() {
+		if !sc.Removed() {
+			p.SetState(sc, connectivity.Ready)
+		}
+	}
+}
+
+// Invoke simulates a successful unary call made with the GCP interceptors and
+// returns the SubConn picked for the call. The reply must be populated before
+// as if it was received from the server. Affinity keys are bound or unbound
+// before Invoke returns. balancer.ErrNoSubConnAvailable is returned if the
+// call would wait for a READY SubConn.
+func (p *Pool) Invoke(ctx context.Context, method string, req, reply interface{}) (*SubConn, error) {
+	var picked *SubConn
+	invoker := func(ctx context.Context, method string, req, reply interface{}, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		picker := p.CC.State().Picker
+		if picker == nil {
+			return balancer.ErrNoSubConnAvailable
+		}
+		pr, err := picker.Pick(balancer.PickInfo{FullMethodName: method, Ctx: ctx})
+		if err != nil {
+			return err
+		}
+		picked = pr.SubConn.(*SubConn)
+		if pr.Done != nil {
+			pr.Done(balancer.DoneInfo{})
+		}
+		return nil
+	}
+	if err := grpcgcp.GCPUnaryClientInterceptor(ctx, method, req, reply, nil, invoker); err != nil {
+		return nil, err
+	}
+	return picked, nil
+}
+
+// Close closes the balancer.
+func (p *Pool) Close() {
+	p.b.Close()
+}
@@ -0,0 +1,84 @@
+package grpcgcptest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+type session struct {
+	Name string
+}
+
+func TestPool(t *testing.T) {
+	apiConfig := &pb.ApiConfig{
+		ChannelPool: &pb.ChannelPoolConfig{
+			MinSize:                          3,
+			MaxSize:                          3,
+			MaxConcurrentStreamsLowWatermark: 100,
+		},
+		Method: []*pb.MethodConfig{
+			{
+				Name: []string{"/test/Create"},
+				Affinity: &pb.AffinityConfig{
+					Command:     pb.AffinityConfig_BIND,
+					AffinityKey: "name",
+				},
+			},
+			{
+				Name: []string{"/test/Use"},
+				Affinity: &pb.AffinityConfig{
+					Command:     pb.AffinityConfig_BOUND,
+					AffinityKey: "name",
+				},
+			},
+		},
+	}
+	bound := map[string]int{}
+	pool := NewPool(grpcgcp.Config{
+		OnBind: func(key string, channelID int) {
+			bound[key] = channelID
+		},
+	}, apiConfig)
+	defer pool.Close()
+
+	ctx := context.Background()
+	if _, err := pool.Invoke(ctx, "/test/Create", &session{}, &session{Name: "s0"}); err != balancer.ErrNoSubConnAvailable {
+		t.Fatalf("Invoke before any channel is ready returned %v, want %v", err, balancer.ErrNoSubConnAvailable)
+	}
+	pool.SetAllReady()
+	if got := len(pool.CC.SubConns()); got != 3 {
+		t.Fatalf("pool has %d SubConns, want 3", got)
+	}
+
+	// Channels with equal load are picked in the order of their index.
+	for i := 0; i < 10; i++ {
+		sc, err := pool.Invoke(ctx, "/test/Create", &session{}, &session{Name: "s1"})
+		if err != nil {
+			t.Fatalf("Invoke returned unexpected error: %v", err)
+		}
+		if sc.Index != 0 {
+			t.Fatalf("Invoke picked SubConn %d, want 0", sc.Index)
+		}
+	}
+	if ch, ok := bound["s1"]; !ok || ch != 0 {
+		t.Fatalf("s1 is bound to %d, %v, want 0, true", ch, ok)
+	}
+
+	// A bound call uses the bound channel even if it is not the first one.
+	pool.SetState(pool.CC.SubConns()[0], connectivity.Connecting)
+	sc, err := pool.Invoke(ctx, "/test/Create", &session{}, &session{Name: "s2"})
+	if err != nil || sc.Index != 1 {
+		t.Fatalf("Invoke returned %v, %v, want SubConn 1", sc, err)
+	}
+	pool.SetState(pool.CC.SubConns()[0], connectivity.Ready)
+	sc, err = pool.Invoke(ctx, "/test/Use", &session{Name: "s2"}, &session{})
+	if err != nil || sc.Index != 1 {
+		t.Fatalf("Invoke returned %v, %v, want SubConn 1", sc, err)
+	}
+}
@@ -0,0 +1,385 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcgcptest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+	"github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpcgcptest/testpb"
+)
+
+// Full method names of the SessionService served by Server.
+const (
+	CreateSessionMethod = "/grpc.gcp.testing.SessionService/CreateSession"
+	UseSessionMethod    = "/grpc.gcp.testing.SessionService/UseSession"
+	StreamSessionMethod = "/grpc.gcp.testing.SessionService/StreamSession"
+	DeleteSessionMethod = "/grpc.gcp.testing.SessionService/DeleteSession"
+)
+
+// MethodConfig returns the affinity configuration of the SessionService
+// methods to use in the ApiConfig for a Server.
+func MethodConfig() []*pb.MethodConfig {
+	return []*pb.MethodConfig{
+		{
+			Name: []string{CreateSessionMethod},
+			Affinity: &pb.AffinityConfig{
+				Command:     pb.AffinityConfig_BIND,
+				AffinityKey: "name",
+			},
+		},
+		{
+			Name: []string{UseSessionMethod, StreamSessionMethod},
+			Affinity: &pb.AffinityConfig{
+				Command:     pb.AffinityConfig_BOUND,
+				AffinityKey: "session",
+			},
+		},
+		{
+			Name: []string{DeleteSessionMethod},
+			Affinity: &pb.AffinityConfig{
+				Command:     pb.AffinityConfig_UNBIND,
+				AffinityKey: "name",
+			},
+		},
+	}
+}
+
+// StreamInfo describes a call received by a Server.
+type StreamInfo struct {
+	// Full method name of the call.
+	Method string
+	// Session of the call, empty for CreateSession.
+	Session string
+	// Address of the client connection the call was received over. Calls sent
+	// over the same channel of the pool have the same Peer.
+	Peer string
+}
+
+// Fault is injected into a call received by a Server.
+type Fault struct {
+	// Delay before the call is processed.
+	Delay time.Duration
+	// Error the call fails with after the Delay, e.g., a status error.
+	Err error
+}
+
+// Server is an in-process SessionService server for end-to-end tests of the
+// channel pool, e.g., of binding, spillover and failover, without real Google
+// Cloud APIs. Every call can be delayed or failed with a fault injector.
+//
+//	srv, err := grpcgcptest.NewServer()
+//	if err != nil {
+//		// Handle error.
+//	}
+//	defer srv.Stop()
+//	apiConfig := &configpb.ApiConfig{Method: grpcgcptest.MethodConfig()}
+//	opts, err := grpcgcp.WithDefaults(apiConfig)
+//	conn, err := grpc.Dial(srv.Addr(), append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+//	client := grpcgcptest.NewClient(conn)
+//	session, err := client.CreateSession(ctx)
+type Server struct {
+	lis net.Listener
+	srv *grpc.Server
+
+	mu       sync.Mutex
+	inject   func(*StreamInfo) Fault
+	sessions map[string]string
+	nextID   int
+}
+
+// NewServer starts a Server listening on a local port with the ServerOptions.
+func NewServer(opts ...grpc.ServerOption) (*Server, error) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, fmt.Errorf("grpcgcptest: cannot listen: %v", err)
+	}
+	return NewServerWithListener(lis, opts...), nil
+}
+
+// NewServerWithListener starts a Server serving the listener with the
+// ServerOptions, e.g., a unix socket listener to test a channel pool over
+// unix sockets:
+//
+//	lis, err := net.Listen("unix", "/tmp/grpcgcptest.sock")
+//	srv := grpcgcptest.NewServerWithListener(lis)
+//	conn, err := grpcgcp.Dial("unix:///tmp/grpcgcptest.sock", apiConfig, grpc.WithTransportCredentials(insecure.NewCredentials()))
+func NewServerWithListener(lis net.Listener, opts ...grpc.ServerOption) *Server {
+	s := &Server{
+		lis:      lis,
+		srv:      grpc.NewServer(opts...),
+		sessions: make(map[string]string),
+	}
+	s.srv.RegisterService(&sessionServiceDesc, s)
+	go s.srv.Serve(lis)
+	return s
+}
+
+// Addr returns the address of the Server to dial, e.g., "unix:///tmp/grpc.sock"
+// for a unix socket listener.
+func (s *Server) Addr() string {
+	if a := s.lis.Addr(); a.Network() == "unix" {
+		return "unix://" + a.String()
+	}
+	return s.lis.Addr().String()
+}
+
+// SetFaultInjector sets the function called for every call received by the
+// Server, before the call is processed, to get the Fault of the call. A nil
+// function disables the fault injection.
+func (s *Server) SetFaultInjector(f func(*StreamInfo) Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inject = f
+}
+
+// SessionPeer returns the address of the client connection the session was
+// created over and whether the session exists.
+func (s *Server) SessionPeer(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.sessions[name]
+	return p, ok
+}
+
+// Stop stops the Server closing all connections.
+func (s *Server) Stop() {
+	s.srv.Stop()
+}
+
+// start injects the fault of the call and returns the peer of the call.
+func (s *Server) start(ctx context.Context, method, session string) (string, error) {
+	info := &StreamInfo{Method: method, Session: session}
+	if p, ok := peer.FromContext(ctx); ok {
+		info.Peer = p.Addr.String()
+	}
+	s.mu.Lock()
+	inject := s.inject
+	s.mu.Unlock()
+	if inject == nil {
+		return info.Peer, nil
+	}
+	f := inject(info)
+	if f.Delay > 0 {
+		select {
+		case <-time.After(f.Delay):
+		case <-ctx.Done():
+			return "", status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	return info.Peer, f.Err
+}
+
+func (s *Server) checkSession(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[name]; !ok {
+		return status.Errorf(codes.NotFound, "session %q not found", name)
+	}
+	return nil
+}
+
+func (s *Server) createSession(ctx context.Context, _ *testpb.CreateSessionRequest) (*testpb.Session, error) {
+	p, err := s.start(ctx, CreateSessionMethod, "")
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := fmt.Sprintf("sessions/%d", s.nextID)
+	s.nextID++
+	s.sessions[name] = p
+	return &testpb.Session{Name: name, Peer: p}, nil
+}
+
+func (s *Server) useSession(ctx context.Context, req *testpb.UseSessionRequest) (*testpb.UseSessionResponse, error) {
+	p, err := s.start(ctx, UseSessionMethod, req.GetSession())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkSession(req.GetSession()); err != nil {
+		return nil, err
+	}
+	return &testpb.UseSessionResponse{Session: req.GetSession(), Peer: p}, nil
+}
+
+func (s *Server) streamSession(req *testpb.UseSessionRequest, stream grpc.ServerStream) error {
+	p, err := s.start(stream.Context(), StreamSessionMethod, req.GetSession())
+	if err != nil {
+		return err
+	}
+	if err := s.checkSession(req.GetSession()); err != nil {
+		return err
+	}
+	for i := uint32(0); i < req.GetResponses(); i++ {
+		if err := stream.SendMsg(&testpb.UseSessionResponse{Session: req.GetSession(), Peer: p}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) deleteSession(ctx context.Context, req *testpb.DeleteSessionRequest) (*testpb.DeleteSessionResponse, error) {
+	if _, err := s.start(ctx, DeleteSessionMethod, req.GetName()); err != nil {
+		return nil, err
+	}
+	if err := s.checkSession(req.GetName()); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, req.GetName())
+	return &testpb.DeleteSessionResponse{}, nil
+}
+
+var sessionServiceDesc = grpc.ServiceDesc{
+	ServiceName: "grpc.gcp.testing.SessionService",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateSession",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &testpb.CreateSessionRequest{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				return unary(ctx, in, CreateSessionMethod, interceptor, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(*Server).createSession(ctx, req.(*testpb.CreateSessionRequest))
+				})
+			},
+		},
+		{
+			MethodName: "UseSession",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &testpb.UseSessionRequest{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				return unary(ctx, in, UseSessionMethod, interceptor, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(*Server).useSession(ctx, req.(*testpb.UseSessionRequest))
+				})
+			},
+		},
+		{
+			MethodName: "DeleteSession",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &testpb.DeleteSessionRequest{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				return unary(ctx, in, DeleteSessionMethod, interceptor, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(*Server).deleteSession(ctx, req.(*testpb.DeleteSessionRequest))
+				})
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "StreamSession",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				in := &testpb.UseSessionRequest{}
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				return srv.(*Server).streamSession(in, stream)
+			},
+			ServerStreams: true,
+		},
+	},
+	Metadata: "testpb.proto",
+}
+
+// unary calls the handler through the server interceptor if any.
+func unary(ctx context.Context, req interface{}, method string, interceptor grpc.UnaryServerInterceptor, handler grpc.UnaryHandler) (interface{}, error) {
+	if interceptor == nil {
+		return handler(ctx, req)
+	}
+	return interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+}
+
+// Client is a client of the SessionService served by Server.
+type Client struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewClient returns a Client making calls over the cc, e.g., a ClientConn
+// with the grpc_gcp balancer and the MethodConfig.
+func NewClient(cc grpc.ClientConnInterface) *Client {
+	return &Client{cc: cc}
+}
+
+// CreateSession creates a session bound to the channel of the call.
+func (c *Client) CreateSession(ctx context.Context, opts ...grpc.CallOption) (*testpb.Session, error) {
+	out := &testpb.Session{}
+	if err := c.cc.Invoke(ctx, CreateSessionMethod, &testpb.CreateSessionRequest{}, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UseSession makes a call with the session.
+func (c *Client) UseSession(ctx context.Context, session string, opts ...grpc.CallOption) (*testpb.UseSessionResponse, error) {
+	out := &testpb.UseSessionResponse{}
+	if err := c.cc.Invoke(ctx, UseSessionMethod, &testpb.UseSessionRequest{Session: session}, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StreamSession makes a server-streaming call with the session and returns
+// all its responses.
+func (c *Client) StreamSession(ctx context.Context, session string, responses uint32, opts ...grpc.CallOption) ([]*testpb.UseSessionResponse, error) {
+	desc := &grpc.StreamDesc{StreamName: "StreamSession", ServerStreams: true}
+	stream, err := c.cc.NewStream(ctx, desc, StreamSessionMethod, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(&testpb.UseSessionRequest{Session: session, Responses: responses}); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	out := []*testpb.UseSessionResponse{}
+	for {
+		resp := &testpb.UseSessionResponse{}
+		if err := stream.RecvMsg(resp); err != nil {
+			if err == io.EOF {
+				return out, nil
+			}
+			return out, err
+		}
+		out = append(out, resp)
+	}
+}
+
+// DeleteSession deletes the session unbinding it from its channel.
+func (c *Client) DeleteSession(ctx context.Context, name string, opts ...grpc.CallOption) error {
+	return c.cc.Invoke(ctx, DeleteSessionMethod, &testpb.DeleteSessionRequest{Name: name}, &testpb.DeleteSessionResponse{}, opts...)
+}
@@ -0,0 +1,451 @@
+package grpcgcptest
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
+)
+
+func TestServer(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatalf("NewServer returned error: %v", err)
+	}
+	defer srv.Stop()
+
+	opts, err := grpcgcp.WithDefaults(&pb.ApiConfig{
+		ChannelPool: &pb.ChannelPoolConfig{
+			MinSize: 2,
+			MaxSize: 2,
+		},
+		Method: MethodConfig(),
+	})
+	if err != nil {
+		t.Fatalf("WithDefaults returned error: %v", err)
+	}
+	conn, err := grpc.Dial(srv.Addr(), append(opts, grpc.WithInsecure())...)
+	if err != nil {
+		t.Fatalf("grpc.Dial returned error: %v", err)
+	}
+	defer conn.Close()
+	client := NewClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := client.CreateSession(ctx, grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatalf("CreateSession returned error: %v", err)
+	}
+	if p, ok := srv.SessionPeer(session.GetName()); !ok || p != session.GetPeer() {
+		t.Fatalf("SessionPeer(%q) returned %q, %v, want %q, true", session.GetName(), p, ok, session.GetPeer())
+	}
+
+	// Calls with the session are sent over the channel it was created over.
+	for i := 0; i < 5; i++ {
+		resp, err := client.UseSession(ctx, session.GetName())
+		if err != nil {
+			t.Fatalf("UseSession returned error: %v", err)
+		}
+		if resp.GetPeer() != session.GetPeer() {
+			t.Fatalf("UseSession was sent over %q, want %q", resp.GetPeer(), session.GetPeer())
+		}
+	}
+	resps, err := client.StreamSession(ctx, session.GetName(), 3)
+	if err != nil {
+		t.Fatalf("StreamSession returned error: %v", err)
+	}
+	if len(resps) != 3 {
+		t.Fatalf("StreamSession returned %d responses, want 3", len(resps))
+	}
+	for _, resp := range resps {
+		if resp.GetPeer() != session.GetPeer() {
+			t.Fatalf("StreamSession was sent over %q, want %q", resp.GetPeer(), session.GetPeer())
+		}
+	}
+
+	// Faults are injected per call.
+	var infos []StreamInfo
+	srv.SetFaultInjector(func(info *StreamInfo) Fault {
+		infos = append(infos, *info)
+		return Fault{Delay: 10 * time.Millisecond, Err: status.Error(codes.Unavailable, "injected")}
+	})
+	start := time.Now()
+	if _, err := client.StreamSession(ctx, session.GetName(), 1); status.Code(err) != codes.Unavailable {
+		t.Fatalf("StreamSession returned error: %v, want Unavailable", err)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Fatalf("StreamSession returned after %v, want at least the injected delay", d)
+	}
+	want := StreamInfo{Method: StreamSessionMethod, Session: session.GetName(), Peer: session.GetPeer()}
+	if len(infos) != 1 || infos[0] != want {
+		t.Fatalf("fault injector was called with %+v, want %+v", infos, want)
+	}
+	srv.SetFaultInjector(nil)
+
+	if err := client.DeleteSession(ctx, session.GetName()); err != nil {
+		t.Fatalf("DeleteSession returned error: %v", err)
+	}
+	if _, err := client.UseSession(ctx, session.GetName()); status.Code(err) != codes.NotFound {
+		t.Fatalf("UseSession of deleted session returned error: %v, want NotFound", err)
+	}
+}
+
+func TestServerOverUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "grpc.sock")
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	srv := NewServerWithListener(lis)
+	defer srv.Stop()
+	if got, want := srv.Addr(), "unix://"+path; got != want {
+		t.Fatalf("Addr() = %q, want %q", got, want)
+	}
+
+	conn, err := grpcgcp.Dial(srv.Addr(), &pb.ApiConfig{
+		ChannelPool: &pb.ChannelPoolConfig{
+			MinSize:            2,
+			MaxSize:            2,
+			DisableHealthCheck: true,
+		},
+		Method: MethodConfig(),
+	}, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("grpcgcp.Dial returned error: %v", err)
+	}
+	defer conn.Close()
+	client := NewClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := client.CreateSession(ctx, grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatalf("CreateSession returned error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := client.UseSession(ctx, session.GetName()); err != nil {
+			t.Fatalf("UseSession returned error: %v", err)
+		}
+	}
+
+	snap, err := conn.AffinitySnapshot()
+	if err != nil {
+		t.Fatalf("AffinitySnapshot returned error: %v", err)
+	}
+	bound := 0
+	for _, ch := range snap.Channels {
+		if ch.AffinityCount == 0 {
+			continue
+		}
+		bound++
+		if ch.Network != "unix" || ch.RemoteAddr != path {
+			t.Fatalf("channel %d is connected to %s %q, want unix %q", ch.Index, ch.Network, ch.RemoteAddr, path)
+		}
+	}
+	if bound != 1 {
+		t.Fatalf("session is bound to %d channels, want 1", bound)
+	}
+}
+
+func TestBoundCallsFollowBindImmediately(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatalf("NewServer returned error: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := grpcgcp.Dial(srv.Addr(), &pb.ApiConfig{
+		ChannelPool: &pb.ChannelPoolConfig{
+			MinSize: 4,
+			MaxSize: 4,
+		},
+		Method: MethodConfig(),
+	}, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("grpcgcp.Dial returned error: %v", err)
+	}
+	defer conn.Close()
+	client := NewClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// The binding of a session is applied before CreateSession returns, so the
+	// first call with the session, made right away, is sent over its channel.
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session, err := client.CreateSession(ctx, grpc.WaitForReady(true))
+			if err != nil {
+				errs <- err
+				return
+			}
+			resp, err := client.UseSession(ctx, session.GetName())
+			if err != nil {
+				errs <- err
+				return
+			}
+			if resp.GetPeer() != session.GetPeer() {
+				errs <- status.Errorf(codes.Internal, "UseSession of %q was sent over %q, want %q", session.GetName(), resp.GetPeer(), session.GetPeer())
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestWatchChannelStatesBeforeFirstCall(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatalf("NewServer returned error: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := grpcgcp.Dial(srv.Addr(), &pb.ApiConfig{
+		ChannelPool: &pb.ChannelPoolConfig{
+			MinSize: 2,
+			MaxSize: 2,
+		},
+		Method: MethodConfig(),
+	}, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("grpcgcp.Dial returned error: %v", err)
+	}
+	defer conn.Close()
+
+	// No call was made on the ClientConn yet.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	updates, err := conn.WatchChannelStates(ctx)
+	if err != nil {
+		t.Fatalf("WatchChannelStates returned error: %v", err)
+	}
+	for u := range updates {
+		if u.ReadyChannels == 2 {
+			return
+		}
+	}
+	t.Fatalf("updates closed before 2 channels were READY: %v", ctx.Err())
+}
+
+func TestEchoServerInterceptors(t *testing.T) {
+	apiConfig := &pb.ApiConfig{
+		ChannelPool: &pb.ChannelPoolConfig{
+			MinSize: 2,
+			MaxSize: 2,
+		},
+		Method: MethodConfig(),
+	}
+	srv, err := NewServer(
+		grpc.UnaryInterceptor(grpcgcp.EchoUnaryServerInterceptor(apiConfig)),
+		grpc.StreamInterceptor(grpcgcp.EchoStreamServerInterceptor(apiConfig)),
+	)
+	if err != nil {
+		t.Fatalf("NewServer returned error: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := grpcgcp.Dial(srv.Addr(), apiConfig, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("grpcgcp.Dial returned error: %v", err)
+	}
+	defer conn.Close()
+	client := NewClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	check := func(call string, trailer metadata.MD, wantConn, wantKey string) {
+		t.Helper()
+		if got := trailer.Get(grpcgcp.EchoConnectionTrailer); len(got) != 1 || got[0] != wantConn {
+			t.Fatalf("%s echoed connection %v, want [%s]", call, got, wantConn)
+		}
+		if got := trailer.Get(grpcgcp.EchoAffinityKeyTrailer); len(got) != 1 || got[0] != wantKey {
+			t.Fatalf("%s echoed affinity key %v, want [%s]", call, got, wantKey)
+		}
+	}
+
+	// The key of a BIND call is echoed from the response.
+	var trailer metadata.MD
+	session, err := client.CreateSession(ctx, grpc.WaitForReady(true), grpc.Trailer(&trailer))
+	if err != nil {
+		t.Fatalf("CreateSession returned error: %v", err)
+	}
+	check("CreateSession", trailer, session.GetPeer(), session.GetName())
+
+	// The calls with the session stay on its connection.
+	for i := 0; i < 3; i++ {
+		trailer = nil
+		if _, err := client.UseSession(ctx, session.GetName(), grpc.Trailer(&trailer)); err != nil {
+			t.Fatalf("UseSession returned error: %v", err)
+		}
+		check("UseSession", trailer, session.GetPeer(), session.GetName())
+	}
+	trailer = nil
+	if _, err := client.StreamSession(ctx, session.GetName(), 2, grpc.Trailer(&trailer)); err != nil {
+		t.Fatalf("StreamSession returned error: %v", err)
+	}
+	check("StreamSession", trailer, session.GetPeer(), session.GetName())
+
+	// The connection is echoed for failed calls too.
+	trailer = nil
+	if _, err := client.UseSession(ctx, "sessions/unknown", grpc.Trailer(&trailer)); status.Code(err) != codes.NotFound {
+		t.Fatalf("UseSession of unknown session returned error: %v, want NotFound", err)
+	}
+	if got := trailer.Get(grpcgcp.EchoConnectionTrailer); len(got) != 1 {
+		t.Fatalf("failed UseSession echoed connection %v, want one connection", got)
+	}
+}
+
+func TestWaitForPoolReady(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatalf("NewServer returned error: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := grpcgcp.Dial(srv.Addr(), &pb.ApiConfig{
+		ChannelPool: &pb.ChannelPoolConfig{
+			MinSize: 3,
+			MaxSize: 4,
+		},
+		Method: MethodConfig(),
+	}, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("grpcgcp.Dial returned error: %v", err)
+	}
+	defer conn.Close()
+
+	// No call was made on the ClientConn yet.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := conn.WaitForPoolReady(ctx, 3); err != nil {
+		t.Fatalf("WaitForPoolReady returned error: %v", err)
+	}
+	stats, err := conn.ChannelStats()
+	if err != nil {
+		t.Fatalf("ChannelStats returned error: %v", err)
+	}
+	if len(stats) != 3 {
+		t.Fatalf("pool has %d channels, want 3", len(stats))
+	}
+	m, err := conn.PoolMetrics()
+	if err != nil {
+		t.Fatalf("PoolMetrics returned error: %v", err)
+	}
+	if m.ReadyChannels != 3 {
+		t.Fatalf("pool has %d READY channels, want 3", m.ReadyChannels)
+	}
+
+	// More channels than max_size never get READY.
+	if err := conn.WaitForPoolReady(ctx, 5); err == nil {
+		t.Fatal("WaitForPoolReady for 5 channels with max_size 4 returned nil, want error")
+	}
+	// The pool does not grow to 4 channels without load.
+	shortCtx, shortCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer shortCancel()
+	if err := conn.WaitForPoolReady(shortCtx, 4); err != context.DeadlineExceeded {
+		t.Fatalf("WaitForPoolReady for 4 channels returned error: %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// pickRecorder records the pick decisions of the calls in the OutHeader event.
+type pickRecorder struct {
+	mu    sync.Mutex
+	picks []grpcgcp.PickDecision
+}
+
+func (r *pickRecorder) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (r *pickRecorder) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	if _, ok := s.(*stats.OutHeader); !ok {
+		return
+	}
+	if d, ok := grpcgcp.PickDecisionFromContext(ctx); ok {
+		r.mu.Lock()
+		r.picks = append(r.picks, d)
+		r.mu.Unlock()
+	}
+}
+
+func (r *pickRecorder) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (r *pickRecorder) HandleConn(context.Context, stats.ConnStats) {}
+
+func TestPickDecisionFromContext(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatalf("NewServer returned error: %v", err)
+	}
+	defer srv.Stop()
+
+	rec := &pickRecorder{}
+	var intercepted []grpcgcp.PickDecision
+	conn, err := grpcgcp.Dial(srv.Addr(), &pb.ApiConfig{
+		ChannelPool: &pb.ChannelPoolConfig{
+			MinSize: 2,
+			MaxSize: 2,
+		},
+		Method: MethodConfig(),
+	}, grpc.WithInsecure(), grpc.WithStatsHandler(rec), grpc.WithChainUnaryInterceptor(
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			if d, ok := grpcgcp.PickDecisionFromContext(ctx); ok {
+				intercepted = append(intercepted, d)
+			}
+			return err
+		}))
+	if err != nil {
+		t.Fatalf("grpcgcp.Dial returned error: %v", err)
+	}
+	defer conn.Close()
+	client := NewClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := client.CreateSession(ctx, grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatalf("CreateSession returned error: %v", err)
+	}
+	if _, err := client.UseSession(ctx, session.GetName()); err != nil {
+		t.Fatalf("UseSession returned error: %v", err)
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for name, picks := range map[string][]grpcgcp.PickDecision{"stats handler": rec.picks, "interceptor": intercepted} {
+		if len(picks) != 2 {
+			t.Fatalf("%s got %d pick decisions, want 2", name, len(picks))
+		}
+		create, use := picks[0], picks[1]
+		if create.KeyBound || create.Reason != grpcgcp.PickLeastBusy {
+			t.Errorf("%s got CreateSession pick %+v, want an unbound least-busy pick", name, create)
+		}
+		if !use.KeyBound || use.Reason != grpcgcp.PickBound || use.ChannelIndex != create.ChannelIndex {
+			t.Errorf("%s got UseSession pick %+v, want a bound pick of channel %d", name, use, create.ChannelIndex)
+		}
+		if use.KeyHash != grpcgcp.AffinityKeyHash(session.GetName()) {
+			t.Errorf("%s got UseSession pick with key hash %q, want the hash of %q", name, use.KeyHash, session.GetName())
+		}
+	}
+}
@@ -0,0 +1,5 @@
+#!/usr/bin/env bash
+cd "$(dirname "$0")"
+
+rm testpb.pb.go
+protoc --plugin=$(go env GOPATH)/bin/protoc-gen-go --proto_path=./ --go_out=.. ./testpb.proto
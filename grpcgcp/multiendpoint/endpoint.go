@@ -51,4 +51,6 @@ type endpoint struct {
 	status       status
 	lastChange   time.Time
 	futureChange timerAlike
+	// Latency reported with SetEndpointLatency or 0 if unknown.
+	latency time.Duration
 }
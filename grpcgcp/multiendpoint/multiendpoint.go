@@ -69,6 +69,10 @@ var (
 // MultiEndpoint will delay switching from an available endpoint to another endpoint for this amount
 // of time. This delay is only applicable when switching from a lower priority available endpoint to
 // a higher priority available endpoint.
+//
+// When several endpoints are available, e.g., a regional and a global endpoint, use
+// [MultiEndpointOptions.PreferLowestLatency] to prefer the available endpoint with the lowest
+// latency reported with [MultiEndpoint.SetEndpointLatency] over the priority order.
 type MultiEndpoint interface {
 	// Current returns current endpoint.
 	//
@@ -80,6 +84,11 @@ type MultiEndpoint interface {
 	// This may change the current endpoint.
 	SetEndpointAvailability(e string, avail bool)
 
+	// SetEndpointLatency informs MultiEndpoint of the latest latency of an endpoint, e.g., the
+	// round-trip time of a probe call. This may change the current endpoint if
+	// [MultiEndpointOptions.PreferLowestLatency] is set.
+	SetEndpointLatency(e string, latency time.Duration)
+
 	// SetEndpoints updates a list of endpoints:
 	//   - remove obsolete endpoints
 	//   - preserve remaining endpoints and their states
@@ -99,6 +108,13 @@ type MultiEndpointOptions struct {
 	// When switching from a lower priority available endpoint to a higher priority available
 	// endpoint the MultiEndpoint will delay the switch for this duration.
 	SwitchingDelay time.Duration
+	// If set, the current endpoint is the available endpoint with the lowest latency reported with
+	// [MultiEndpoint.SetEndpointLatency] instead of the top priority available endpoint. Endpoints
+	// are picked by priority until the latency of the top priority available endpoint is known.
+	PreferLowestLatency bool
+	// With PreferLowestLatency, the current endpoint is only replaced by an endpoint with a latency
+	// lower by more than this tolerance, so that endpoints with similar latencies do not take turns.
+	LatencyTolerance time.Duration
 }
 
 // NewMultiEndpoint validates options and creates a new [MultiEndpoint].
@@ -108,9 +124,11 @@ func NewMultiEndpoint(b *MultiEndpointOptions) (MultiEndpoint, error) {
 	}
 
 	me := &multiEndpoint{
-		recoveryTimeout: b.RecoveryTimeout,
-		switchingDelay:  b.SwitchingDelay,
-		current:         b.Endpoints[0],
+		recoveryTimeout:     b.RecoveryTimeout,
+		switchingDelay:      b.SwitchingDelay,
+		preferLowestLatency: b.PreferLowestLatency,
+		latencyTolerance:    b.LatencyTolerance,
+		current:             b.Endpoints[0],
 	}
 	eMap := make(map[string]*endpoint)
 	for i, e := range b.Endpoints {
@@ -123,11 +141,13 @@ func NewMultiEndpoint(b *MultiEndpointOptions) (MultiEndpoint, error) {
 type multiEndpoint struct {
 	sync.RWMutex
 
-	endpoints       map[string]*endpoint
-	recoveryTimeout time.Duration
-	switchingDelay  time.Duration
-	current         string
-	future          string
+	endpoints           map[string]*endpoint
+	recoveryTimeout     time.Duration
+	switchingDelay      time.Duration
+	preferLowestLatency bool
+	latencyTolerance    time.Duration
+	current             string
+	future              string
 }
 
 // Current returns current endpoint.
@@ -196,6 +216,9 @@ func (me *multiEndpoint) maybeUpdateCurrent() {
 
 	// Always prefer top available endpoint.
 	if topA != nil {
+		if me.preferLowestLatency {
+			topA = me.lowestLatency(c, topA)
+		}
 		me.switchFromTo(c, topA)
 		return
 	}
@@ -206,6 +229,34 @@ func (me *multiEndpoint) maybeUpdateCurrent() {
 	}
 }
 
+// Returns the available endpoint with the lowest latency if its latency is lower by more than
+// the tolerance than the latency of the current endpoint c, if available, or the top priority
+// available endpoint topA otherwise. Returns topA while its latency is unknown.
+//
+// Must be run under me.Lock.
+func (me *multiEndpoint) lowestLatency(c, topA *endpoint) *endpoint {
+	keep := topA
+	if c != nil && c.status == available && c.latency > 0 {
+		keep = c
+	}
+	if keep.latency == 0 {
+		return keep
+	}
+	best := keep
+	for _, e := range me.endpoints {
+		if e.status != available || e.latency == 0 {
+			continue
+		}
+		if e.latency < best.latency || (e.latency == best.latency && e.priority < best.priority) {
+			best = e
+		}
+	}
+	if best.latency+me.latencyTolerance < keep.latency {
+		return best
+	}
+	return keep
+}
+
 func (me *multiEndpoint) newEndpoint(id string, priority int) *endpoint {
 	s := unavailable
 	if me.recoveryTimeout > 0 {
@@ -254,6 +305,20 @@ func (me *multiEndpoint) SetEndpointAvailability(e string, avail bool) {
 	me.maybeUpdateCurrent()
 }
 
+// SetEndpointLatency updates the latency of an endpoint.
+func (me *multiEndpoint) SetEndpointLatency(e string, latency time.Duration) {
+	me.Lock()
+	defer me.Unlock()
+	ee, ok := me.endpoints[e]
+	if !ok {
+		return
+	}
+	ee.latency = latency
+	if me.preferLowestLatency {
+		me.maybeUpdateCurrent()
+	}
+}
+
 // Must be run under me.Lock.
 func (me *multiEndpoint) setEndpointAvailability(e string, avail bool) {
 	ee, ok := me.endpoints[e]
@@ -721,3 +721,59 @@ func TestSetEndpointsSwitchingDelayed(t *testing.T) {
 		t.Fatalf("Current() returns %q, want: %q", c, want)
 	}
 }
+
+func TestPreferLowestLatency(t *testing.T) {
+	me, err := NewMultiEndpoint(&MultiEndpointOptions{
+		Endpoints:           threeEndpoints,
+		PreferLowestLatency: true,
+		LatencyTolerance:    time.Millisecond * 5,
+	})
+	if err != nil {
+		t.Fatalf("multiendpointBuilder.Build() returns unexpected error: %v", err)
+	}
+	for _, e := range threeEndpoints {
+		me.SetEndpointAvailability(e, true)
+	}
+
+	// Priority order while the latency of the top priority endpoint is unknown.
+	me.SetEndpointLatency(threeEndpoints[1], time.Millisecond*10)
+	if c, want := me.Current(), threeEndpoints[0]; c != want {
+		t.Fatalf("Current() returns %q, want: %q", c, want)
+	}
+
+	// The lowest latency endpoint becomes the current.
+	me.SetEndpointLatency(threeEndpoints[0], time.Millisecond*30)
+	if c, want := me.Current(), threeEndpoints[1]; c != want {
+		t.Fatalf("Current() returns %q, want: %q", c, want)
+	}
+
+	// A latency lower within the tolerance does not switch the current endpoint.
+	me.SetEndpointLatency(threeEndpoints[2], time.Millisecond*6)
+	if c, want := me.Current(), threeEndpoints[1]; c != want {
+		t.Fatalf("Current() returns %q, want: %q", c, want)
+	}
+
+	// A latency lower by more than the tolerance does.
+	me.SetEndpointLatency(threeEndpoints[2], time.Millisecond*4)
+	if c, want := me.Current(), threeEndpoints[2]; c != want {
+		t.Fatalf("Current() returns %q, want: %q", c, want)
+	}
+
+	// Unavailable endpoints are skipped regardless of their latency.
+	me.SetEndpointAvailability(threeEndpoints[2], false)
+	if c, want := me.Current(), threeEndpoints[1]; c != want {
+		t.Fatalf("Current() returns %q, want: %q", c, want)
+	}
+}
+
+func TestLatencyIgnoredByDefault(t *testing.T) {
+	me := initPlain(t, threeEndpoints)
+	for _, e := range threeEndpoints {
+		me.SetEndpointAvailability(e, true)
+	}
+	me.SetEndpointLatency(threeEndpoints[0], time.Millisecond*30)
+	me.SetEndpointLatency(threeEndpoints[1], time.Millisecond*10)
+	if c, want := me.Current(), threeEndpoints[0]; c != want {
+		t.Fatalf("Current() returns %q, want: %q", c, want)
+	}
+}
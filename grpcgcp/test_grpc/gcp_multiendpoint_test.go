@@ -769,6 +769,72 @@ func TestGCPMultiEndpointDialFunc(t *testing.T) {
 	}
 }
 
+func TestGCPMultiEndpointEndpointOptions(t *testing.T) {
+
+	lEndpoint, fEndpoint := "localhost:50051", "127.0.0.3:50051"
+	fAuthority := "follower.example.com"
+
+	defaultME, followerME := "default", "follower"
+
+	apiCfg := &configpb.ApiConfig{
+		ChannelPool: &configpb.ChannelPoolConfig{
+			MinSize: 1,
+			MaxSize: 1,
+		},
+	}
+
+	var fCalls atomic.Int32
+	conn, err := grpcgcp.NewGCPMultiEndpoint(
+		&grpcgcp.GCPMultiEndpointOptions{
+			GRPCgcpConfig: apiCfg,
+			MultiEndpoints: map[string]*multiendpoint.MultiEndpointOptions{
+				defaultME: {
+					Endpoints: []string{lEndpoint, fEndpoint},
+				},
+				followerME: {
+					Endpoints: []string{fEndpoint, lEndpoint},
+				},
+			},
+			Default: defaultME,
+			EndpointOptions: map[string]*grpcgcp.EndpointOptions{
+				fEndpoint: {
+					Authority: fAuthority,
+					DialOptions: []grpc.DialOption{
+						grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+							fCalls.Add(1)
+							return invoker(ctx, method, req, reply, cc, opts...)
+						}),
+					},
+				},
+			},
+		},
+		grpc.WithInsecure(),
+	)
+
+	if err != nil {
+		t.Fatalf("NewMultiEndpointConn returns unexpected error: %v", err)
+	}
+
+	defer conn.Close()
+	c := pb.NewGreeterClient(conn)
+	tc := &testingClient{
+		c: c,
+		t: t,
+	}
+
+	// The default endpoint uses common options.
+	tc.SayHelloWorks(context.Background(), lEndpoint)
+	if got, want := fCalls.Load(), int32(0); got != want {
+		t.Fatalf("follower endpoint interceptor was called %v times, want %v times", got, want)
+	}
+
+	// The follower endpoint uses its own authority and dial options.
+	tc.SayHelloWorks(grpcgcp.NewMEContext(context.Background(), followerME), fAuthority)
+	if got, want := fCalls.Load(), int32(1); got != want {
+		t.Fatalf("follower endpoint interceptor was called %v times, want %v times", got, want)
+	}
+}
+
 func TestGCPMultiEndpointGCPConfig(t *testing.T) {
 
 	lEndpoint, fEndpoint := "localhost:50051", "127.0.0.3:50051"
@@ -820,3 +886,119 @@ func TestGCPMultiEndpointGCPConfig(t *testing.T) {
 		t.Fatalf("conn.GCPConfig() returned unexpected difference in protobuf messages (-want +got):\n%s", diff)
 	}
 }
+
+func TestGCPMultiEndpointStandbyPoolSize(t *testing.T) {
+
+	lEndpoint, fEndpoint := "localhost:50051", "127.0.0.3:50051"
+
+	apiCfg := &configpb.ApiConfig{
+		ChannelPool: &configpb.ChannelPoolConfig{
+			MinSize: 3,
+			MaxSize: 3,
+		},
+	}
+
+	var mu sync.Mutex
+	conns := make(map[string]*grpc.ClientConn)
+	conn, err := grpcgcp.NewGCPMultiEndpoint(
+		&grpcgcp.GCPMultiEndpointOptions{
+			GRPCgcpConfig: apiCfg,
+			MultiEndpoints: map[string]*multiendpoint.MultiEndpointOptions{
+				"default": {
+					Endpoints: []string{lEndpoint, fEndpoint},
+				},
+			},
+			Default:         "default",
+			StandbyPoolSize: 1,
+			DialFunc: func(ctx context.Context, target string, dopts ...grpc.DialOption) (*grpc.ClientConn, error) {
+				cc, err := grpc.DialContext(ctx, target, dopts...)
+				mu.Lock()
+				conns[target] = cc
+				mu.Unlock()
+				return cc, err
+			},
+		},
+		grpc.WithInsecure(),
+	)
+
+	if err != nil {
+		t.Fatalf("NewMultiEndpointConn returns unexpected error: %v", err)
+	}
+
+	defer conn.Close()
+
+	for e, want := range map[string]int{lEndpoint: 3, fEndpoint: 1} {
+		// A call made with the GCP interceptors links the pool for the metrics.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if _, err := pb.NewGreeterClient(conns[e]).SayHello(ctx, &pb.HelloRequest{Name: "world"}, grpc.WaitForReady(true)); err != nil {
+			t.Fatalf("SayHello over the pool of %q endpoint returns error: %v", e, err)
+		}
+		cancel()
+		m, err := grpcgcp.GetPoolMetrics(conns[e])
+		if err != nil {
+			t.Fatalf("GetPoolMetrics for %q endpoint returns error: %v", e, err)
+		}
+		if m.Channels != want {
+			t.Fatalf("pool of %q endpoint has %d channels, want %d", e, m.Channels, want)
+		}
+	}
+}
+
+func TestGCPMultiEndpointLatencyProbe(t *testing.T) {
+
+	lEndpoint, fEndpoint := "localhost:50051", "127.0.0.3:50051"
+
+	apiCfg := &configpb.ApiConfig{
+		ChannelPool: &configpb.ChannelPoolConfig{
+			MinSize: 1,
+			MaxSize: 1,
+		},
+	}
+
+	probed := make(map[string]*atomic.Int32)
+	probed[lEndpoint] = &atomic.Int32{}
+	probed[fEndpoint] = &atomic.Int32{}
+
+	conn, err := grpcgcp.NewGCPMultiEndpoint(
+		&grpcgcp.GCPMultiEndpointOptions{
+			GRPCgcpConfig: apiCfg,
+			MultiEndpoints: map[string]*multiendpoint.MultiEndpointOptions{
+				"default": {
+					Endpoints:           []string{lEndpoint, fEndpoint},
+					PreferLowestLatency: true,
+					LatencyTolerance:    time.Millisecond * 10,
+				},
+			},
+			Default:              "default",
+			LatencyProbeInterval: time.Millisecond * 50,
+			LatencyProbe: func(ctx context.Context, cc *grpc.ClientConn) error {
+				probed[cc.Target()].Add(1)
+				if cc.Target() == lEndpoint {
+					// Simulate a distant top priority endpoint.
+					time.Sleep(time.Millisecond * 30)
+				}
+				return status.Error(codes.Unimplemented, "no health service")
+			},
+		},
+		grpc.WithInsecure(),
+	)
+
+	if err != nil {
+		t.Fatalf("NewMultiEndpointConn returns unexpected error: %v", err)
+	}
+
+	defer conn.Close()
+	tc := &testingClient{
+		c: pb.NewGreeterClient(conn),
+		t: t,
+	}
+
+	// The lower latency endpoint is preferred over the priority order once
+	// both endpoints are probed.
+	tc.SayHelloWorksWithin(context.Background(), fEndpoint, waitTO)
+	for e, n := range probed {
+		if n.Load() == 0 {
+			t.Fatalf("%q endpoint was not probed", e)
+		}
+	}
+}
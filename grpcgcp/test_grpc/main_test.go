@@ -29,7 +29,10 @@ import (
 
 	"github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 
 	configpb "github.com/GoogleCloudPlatform/grpc-gcp-go/grpcgcp/grpc_gcp"
@@ -258,3 +261,71 @@ func TestStreamingCallNoResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestWaitForReadyInTransientFailure(t *testing.T) {
+	opts, err := grpcgcp.WithDefaults(apiConfigNoMethods)
+	if err != nil {
+		t.Fatalf("cannot create grpcgcp dial options: %v", err)
+	}
+	// Nothing listens on the port, so the pool goes to TransientFailure.
+	conn, err := grpc.Dial("localhost:1", append(opts, grpc.WithInsecure())...)
+	if err != nil {
+		t.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+	c := pb.NewGreeterClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		// Fail-fast calls fail with UNAVAILABLE once the pool is in TransientFailure.
+		_, err := c.SayHello(ctx, &pb.HelloRequest{Name: "world"})
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("fail-fast call returned %v, want UNAVAILABLE", err)
+		}
+		if conn.GetState() == connectivity.TransientFailure {
+			break
+		}
+	}
+
+	// Wait-for-ready calls wait for a ready channel until the deadline.
+	wfrCtx, wfrCancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer wfrCancel()
+	if _, err := c.SayHello(wfrCtx, &pb.HelloRequest{Name: "world"}, grpc.WaitForReady(true)); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("wait-for-ready call returned %v, want DEADLINE_EXCEEDED", err)
+	}
+}
+
+func TestDial(t *testing.T) {
+	conn, err := grpcgcp.Dial("localhost:50051", apiConfig, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+	c := pb.NewGreeterClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r, err := c.SayHello(ctx, &pb.HelloRequest{Name: "world"})
+	if err != nil {
+		t.Fatalf("could not greet: %v", err)
+	}
+	if r.GetMessage() != "Hello world" {
+		t.Errorf("Expected Hello World, got %v", r.GetMessage())
+	}
+
+	m, err := conn.PoolMetrics()
+	if err != nil {
+		t.Fatalf("PoolMetrics returned unexpected error: %v", err)
+	}
+	if m.Channels < 1 || m.BoundKeys != 1 {
+		t.Errorf("PoolMetrics returned %d channels and %d bound keys, want at least 1 channel and 1 bound key", m.Channels, m.BoundKeys)
+	}
+	snap, err := conn.AffinitySnapshot()
+	if err != nil {
+		t.Fatalf("AffinitySnapshot returned unexpected error: %v", err)
+	}
+	if got := snap.Channels[0].RemoteAddr; got != "127.0.0.1:50051" && got != "[::1]:50051" {
+		t.Errorf("remote address of the channel is %q, want the server address", got)
+	}
+}